@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Hash allowlist modes (HASH_ALLOWLIST_MODE)
+const (
+	// allowlistSkip returns the allowlisted verdict without scanning when the
+	// hash is known beforehand, and suppresses detections otherwise
+	allowlistSkip = "skip"
+	// allowlistSuppress always scans and suppresses the detections
+	allowlistSuppress = "suppress"
+)
+
+// verdictAllowlisted is recorded for files on the hash allowlist
+const verdictAllowlisted = "allowlisted"
+
+var allowlistedScans = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "finguard_allowlisted_scans_skipped_total",
+	Help: "Scans skipped because the file SHA-256 is on the hash allowlist.",
+})
+
+var errAllowlistEntryNotFound = errors.New("hash is not on the allowlist")
+
+// HashAllowlistEntry is one approved file
+type HashAllowlistEntry struct {
+	SHA256    string `json:"sha256"`
+	Reason    string `json:"reason,omitempty"`
+	AddedBy   string `json:"addedBy,omitempty"`
+	AddedAt   string `json:"addedAt,omitempty"`
+	ExpiresAt string `json:"expiresAt,omitempty"` // RFC 3339; expired entries no longer match
+}
+
+// HashAllowlistResponse is returned by GET /allowlist
+type HashAllowlistResponse struct {
+	Mode    string               `json:"mode"`
+	Entries []HashAllowlistEntry `json:"entries"`
+}
+
+// HashAllowlist holds the SHA-256 hashes of approved files, loaded from
+// HASH_ALLOWLIST_FILE and managed through /allowlist. API changes are written
+// back to the file so they survive restarts.
+type HashAllowlist struct {
+	mu      sync.RWMutex
+	mode    string
+	entries map[string]HashAllowlistEntry
+}
+
+// hashAllowlist is loaded at startup and replaced on reload
+var hashAllowlist = &HashAllowlist{mode: allowlistSkip, entries: map[string]HashAllowlistEntry{}}
+
+// initHashAllowlist loads the allowlist at startup
+func initHashAllowlist() {
+	if err := hashAllowlist.reload(); err != nil {
+		log.Printf("Warning: hash allowlist not loaded: %v", err)
+	}
+}
+
+// normalizeSHA256 lowercases a hex SHA-256, or returns "" when it is not one
+func normalizeSHA256(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if len(value) != 64 {
+		return ""
+	}
+	if _, err := hex.DecodeString(value); err != nil {
+		return ""
+	}
+	return value
+}
+
+// validate normalizes the entry's hash and checks its expiry
+func (e *HashAllowlistEntry) validate() error {
+	if e.SHA256 = normalizeSHA256(e.SHA256); e.SHA256 == "" {
+		return fmt.Errorf("sha256 must be 64 hex characters")
+	}
+	if e.ExpiresAt != "" {
+		if _, err := time.Parse(time.RFC3339, e.ExpiresAt); err != nil {
+			return fmt.Errorf("expiresAt must be an RFC 3339 timestamp")
+		}
+	}
+	return nil
+}
+
+// expired reports whether the entry's expiry has passed
+func (e HashAllowlistEntry) expired(now time.Time) bool {
+	if e.ExpiresAt == "" {
+		return false
+	}
+	expires, err := time.Parse(time.RFC3339, e.ExpiresAt)
+	return err == nil && !now.Before(expires)
+}
+
+// reload replaces the entries with HASH_ALLOWLIST_FILE, a JSON array of
+// entries. On error the current entries are kept.
+func (a *HashAllowlist) reload() error {
+	mode := strings.ToLower(getEnv("HASH_ALLOWLIST_MODE", allowlistSkip))
+	if mode != allowlistSkip && mode != allowlistSuppress {
+		return fmt.Errorf("invalid HASH_ALLOWLIST_MODE %q, expected skip or suppress", mode)
+	}
+	entries := map[string]HashAllowlistEntry{}
+	if path := os.Getenv("HASH_ALLOWLIST_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		var list []HashAllowlistEntry
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &list); err != nil {
+				return fmt.Errorf("invalid %s: %v", path, err)
+			}
+		}
+		for i, entry := range list {
+			if err := entry.validate(); err != nil {
+				log.Printf("Warning: skipping allowlist entry %d in %s: %v", i, path, err)
+				continue
+			}
+			entries[entry.SHA256] = entry
+		}
+	}
+	a.mu.Lock()
+	a.mode, a.entries = mode, entries
+	a.mu.Unlock()
+	if len(entries) > 0 {
+		log.Printf("- Hash allowlist: %d entries (%s)", len(entries), mode)
+	}
+	return nil
+}
+
+// skipsHashes reports whether listed hashes are answered without scanning
+func (a *HashAllowlist) skipsHashes() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.mode == allowlistSkip && len(a.entries) > 0
+}
+
+// Match returns the unexpired entry for a SHA-256
+func (a *HashAllowlist) Match(sha256 string) (HashAllowlistEntry, bool) {
+	if sha256 == "" {
+		return HashAllowlistEntry{}, false
+	}
+	a.mu.RLock()
+	entry, ok := a.entries[strings.ToLower(sha256)]
+	a.mu.RUnlock()
+	if !ok || entry.expired(time.Now()) {
+		return HashAllowlistEntry{}, false
+	}
+	return entry, true
+}
+
+// List returns the entries sorted by hash
+func (a *HashAllowlist) List() []HashAllowlistEntry {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	entries := make([]HashAllowlistEntry, 0, len(a.entries))
+	for _, entry := range a.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].SHA256 < entries[j].SHA256 })
+	return entries
+}
+
+// Add adds or replaces an entry
+func (a *HashAllowlist) Add(entry HashAllowlistEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries[entry.SHA256] = entry
+	return a.save()
+}
+
+// Remove deletes the entry for a SHA-256
+func (a *HashAllowlist) Remove(sha256 string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sha256 = strings.ToLower(sha256)
+	if _, ok := a.entries[sha256]; !ok {
+		return errAllowlistEntryNotFound
+	}
+	delete(a.entries, sha256)
+	return a.save()
+}
+
+// save writes the entries to HASH_ALLOWLIST_FILE, replacing it atomically.
+// Without a file, entries added through the API last until restart.
+func (a *HashAllowlist) save() error {
+	path := os.Getenv("HASH_ALLOWLIST_FILE")
+	if path == "" {
+		return nil
+	}
+	entries := make([]HashAllowlistEntry, 0, len(a.entries))
+	for _, entry := range a.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].SHA256 < entries[j].SHA256 })
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to save allowlist: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces a file through a temporary file in its directory,
+// so readers never see it half written
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// allowlistedResult returns a clean SDK-style result marked allowlisted for a
+// file whose SHA-256 is on the allowlist, so it need not be scanned. It
+// returns false in suppress mode.
+func allowlistedResult(sha256 string) (string, bool) {
+	hashAllowlist.mu.RLock()
+	mode := hashAllowlist.mode
+	hashAllowlist.mu.RUnlock()
+	if mode != allowlistSkip {
+		return "", false
+	}
+	entry, ok := hashAllowlist.Match(sha256)
+	if !ok {
+		return "", false
+	}
+	allowlistedScans.Inc()
+	result, _ := json.Marshal(map[string]interface{}{
+		"scanResult":    0,
+		"foundMalwares": []interface{}{},
+		"fileSHA256":    entry.SHA256,
+		"allowlisted":   true,
+	})
+	return string(result), true
+}
+
+// Skips reports whether files on the allowlist are answered without scanning,
+// so callers know whether hashing a file beforehand is worthwhile
+func (a *HashAllowlist) Skips() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.mode == allowlistSkip && len(a.entries) > 0
+}
+
+// applyAllowlist marks the verdict of an allowlisted file, or of a result
+// already marked by allowlistedResult: it is safe, and the names of any
+// suppressed detections are kept apart
+func applyAllowlist(verdict *ScanVerdict, marked bool) {
+	if !marked {
+		if _, ok := hashAllowlist.Match(verdict.FileSHA256); !ok {
+			return
+		}
+	}
+	verdict.Allowlisted = true
+	if !verdict.IsSafe {
+		verdict.SuppressedMalware = verdict.MalwareNames
+		verdict.MalwareNames = []string{}
+		verdict.IsSafe = true
+	}
+}
+
+// handleAllowlist serves GET and POST /allowlist and DELETE /allowlist/{sha256}
+func handleAllowlist(w http.ResponseWriter, r *http.Request) {
+	sha := strings.Trim(strings.TrimPrefix(r.URL.Path, "/allowlist"), "/")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case sha == "" && r.Method == http.MethodGet:
+		hashAllowlist.mu.RLock()
+		mode := hashAllowlist.mode
+		hashAllowlist.mu.RUnlock()
+		json.NewEncoder(w).Encode(HashAllowlistResponse{Mode: mode, Entries: hashAllowlist.List()})
+	case sha == "" && r.Method == http.MethodPost:
+		var entry HashAllowlistEntry
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON request body", "")
+			return
+		}
+		entry.AddedBy = callerFrom(r.Context())
+		entry.AddedAt = time.Now().UTC().Format(time.RFC3339)
+		if err := entry.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "sha256")
+			return
+		}
+		if err := hashAllowlist.Add(entry); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error(), "")
+			return
+		}
+		log.Printf("Hash allowlist: %s added by %s (%s)", entry.SHA256, entry.AddedBy, entry.Reason)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(entry)
+	case sha != "" && r.Method == http.MethodDelete:
+		err := hashAllowlist.Remove(sha)
+		if errors.Is(err, errAllowlistEntryNotFound) {
+			writeJSONError(w, http.StatusNotFound, err.Error(), "sha256")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error(), "")
+			return
+		}
+		log.Printf("Hash allowlist: %s removed by %s", strings.ToLower(sha), callerFrom(r.Context()))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import "time"
+
+// Request and response bodies of the JSON API. The OpenAPI document served at
+// /openapi.json is generated from these types.
+
+// Secret is a credential sent in a request body. Secret fields are kept in
+// memory only and removed from job and schedule parameters before those are
+// stored or returned.
+type Secret string
+
+// S3BucketInfo is one bucket returned by /s3/buckets
+type S3BucketInfo struct {
+	Name         string     `json:"name"`
+	CreationDate *time.Time `json:"creationDate"`
+}
+
+// S3ListBucketsResponse is returned by /s3/buckets
+type S3ListBucketsResponse struct {
+	Buckets []S3BucketInfo `json:"buckets"`
+}
+
+// S3ListObjectsRequest is the body accepted by /s3/objects
+type S3ListObjectsRequest struct {
+	S3Options
+	Bucket      string `json:"bucket"`
+	Prefix      string `json:"prefix"`
+	Recursive   bool   `json:"recursive"`
+	Sharded     bool   `json:"sharded"`
+	Concurrency int    `json:"concurrency"`
+}
+
+// S3ObjectInfo is one object returned by /s3/objects
+type S3ObjectInfo struct {
+	Key          string     `json:"key"`
+	Size         *int64     `json:"size"`
+	LastModified *time.Time `json:"lastModified"`
+}
+
+// S3ListObjectsResponse is returned by /s3/objects
+type S3ListObjectsResponse struct {
+	Bucket  string         `json:"bucket"`
+	Objects []S3ObjectInfo `json:"objects"`
+}
+
+// S3ScanRequest is the body accepted by /s3/scan
+type S3ScanRequest struct {
+	S3Options
+	Bucket        string   `json:"bucket"`
+	Key           string   `json:"key"`
+	Tags          []string `json:"tags"`
+	CheckPolicy   bool     `json:"checkPolicy"`
+	DeleteOnClean bool     `json:"deleteOnClean"`
+	CallbackURL   string   `json:"callbackUrl"`
+	// Remediation is applied when malware is found; it overrides bucket rules
+	Remediation *RemediationPolicy `json:"remediation"`
+	// Quarantine is shorthand for a quarantine remediation
+	Quarantine *QuarantineOptions `json:"quarantine"`
+	// SkipUnchanged returns the previous verdict when the ETag/version is unchanged
+	SkipUnchanged bool `json:"skipUnchanged"`
+	// SSECustomerKey reads objects encrypted with SSE-C; the SSE-C headers
+	// may be sent instead
+	SSECustomerAlgorithm string `json:"sseCustomerAlgorithm,omitempty"`
+	SSECustomerKey       Secret `json:"sseCustomerKey,omitempty"`
+	SSECustomerKeyMD5    string `json:"sseCustomerKeyMD5,omitempty"`
+	// Archived says what to do with Glacier and Deep Archive objects
+	Archived *ArchivedObjectOptions `json:"archived"`
+}
+
+// S3ScanResponse is returned by /s3/scan and sent to its callback
+type S3ScanResponse struct {
+	ScanResult string `json:"scanResult,omitempty"`
+	Bucket     string `json:"bucket"`
+	Key        string `json:"key"`
+	Region     string `json:"region"`
+	RequestID  string `json:"requestId,omitempty"`
+	Attempts   int    `json:"attempts,omitempty"`
+	// PolicyFindings is set when checkPolicy was requested
+	PolicyFindings *[]PolicyFinding   `json:"policyFindings,omitempty"`
+	Remediation    *RemediationResult `json:"remediation,omitempty"`
+	// Deleted is set when deleteOnClean was requested
+	Deleted     *bool  `json:"deleted,omitempty"`
+	DeleteError string `json:"deleteError,omitempty"`
+	// Skipped responses reuse the verdict of PreviousScan
+	Skipped      bool        `json:"skipped,omitempty"`
+	Verdict      string      `json:"verdict,omitempty"`
+	MalwareNames []string    `json:"malwareNames,omitempty"`
+	PreviousScan *ScanRecord `json:"previousScan,omitempty"`
+	// Result is the normalized verdict shared by every scan endpoint
+	Result *NormalizedVerdict `json:"result,omitempty"`
+	// Archive is set for archived objects, with the restore when requested
+	Archive *ArchiveResult `json:"archive,omitempty"`
+}
+
+// ScanURIRequest is the body accepted by /scan/uri
+type ScanURIRequest struct {
+	URI     string            `json:"uri"`
+	Tags    []string          `json:"tags"`
+	Options map[string]string `json:"options"`
+}
+
+// ScanURIResponse is returned by /scan/uri
+type ScanURIResponse struct {
+	URI        string `json:"uri"`
+	Identifier string `json:"identifier"`
+	ScanResult string `json:"scanResult"`
+	Attempts   int    `json:"attempts,omitempty"`
+	// Result is the normalized verdict shared by every scan endpoint
+	Result *NormalizedVerdict `json:"result,omitempty"`
+}
+
+// ScanURLRequest is the body accepted by /scan/url and /scan/remote
+type ScanURLRequest struct {
+	URL         string   `json:"url"`
+	Tags        []string `json:"tags"`
+	CallbackURL string   `json:"callbackUrl"`
+}
+
+// ScanURLResponse is returned by /scan/url and /scan/remote
+type ScanURLResponse struct {
+	Identifier string `json:"identifier"`
+	Size       int64  `json:"size"`
+	ScanResult string `json:"scanResult"`
+	RequestID  string `json:"requestId,omitempty"`
+	Attempts   int    `json:"attempts,omitempty"`
+	// Result is the normalized verdict shared by every scan endpoint
+	Result *NormalizedVerdict `json:"result,omitempty"`
+}
+
+// ScanTimeoutResponse is returned with 504 when a scan exceeds its deadline
+type ScanTimeoutResponse struct {
+	Error string `json:"error"`
+	// Stage is queued when no scan slot freed up in time, scanning otherwise
+	Stage     string  `json:"stage"`
+	Timeout   string  `json:"timeout"`
+	ElapsedMs float64 `json:"elapsedMs"`
+	Attempts  int     `json:"attempts"`
+	LastError string  `json:"lastError,omitempty"`
+	RequestID string  `json:"requestId,omitempty"`
+}
+
+// JobAcceptedResponse is returned when a job is queued
+type JobAcceptedResponse struct {
+	JobID  string `json:"jobId"`
+	Status string `json:"status"`
+}
+
+// ScheduleListResponse is returned by GET /schedules
+type ScheduleListResponse struct {
+	Schedules []Schedule `json:"schedules"`
+}
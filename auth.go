@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Paths reachable without credentials (container health probes)
+var publicPaths = map[string]bool{
+	"/health":  true,
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// APIKey is a client name with its secret key
+type APIKey struct {
+	Client string
+	Key    string
+}
+
+// loadAPIKeys reads keys from SCANNER_API_KEY (a single key for client "default")
+// and SCANNER_API_KEYS_FILE (one "client:key" per line, # for comments).
+// No keys means authentication is disabled.
+func loadAPIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	if key := os.Getenv("SCANNER_API_KEY"); key != "" {
+		keys = append(keys, APIKey{Client: "default", Key: key})
+	}
+
+	path := os.Getenv("SCANNER_API_KEYS_FILE")
+	if path == "" {
+		return keys, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open API keys file: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		client, key, ok := strings.Cut(text, ":")
+		client, key = strings.TrimSpace(client), strings.TrimSpace(key)
+		if !ok || client == "" || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected client:key", path, line)
+		}
+		keys = append(keys, APIKey{Client: client, Key: key})
+	}
+	return keys, scanner.Err()
+}
+
+// callerFrom returns the authenticated client name stored in ctx
+func callerFrom(ctx context.Context) string {
+	client, _ := ctx.Value(callerKey).(string)
+	return client
+}
+
+// bearerToken extracts the key from "Authorization: Bearer <key>" or X-API-Key.
+// Browsers cannot set headers on WebSocket handshakes, so /scan/ws also
+// accepts an access_token query parameter.
+func bearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" || r.URL.Path != wsScanPath {
+		return key
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// matchAPIKey returns the client owning token. Every key is compared in
+// constant time so the lookup does not leak which prefix matched.
+func matchAPIKey(keys []APIKey, token string) (string, bool) {
+	client := ""
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(token)) == 1 {
+			client = k.Client
+		}
+	}
+	return client, client != ""
+}
+
+// requireAuth rejects unauthenticated requests with 401. Callers present either
+// an API key (full access) or, when JWT_JWKS_URL is set, a JWT whose scopes must
+// include the one required for the endpoint (403 otherwise). The caller identity
+// is stored in the request context and written to the audit log.
+func requireAuth(keys []APIKey, jwtValidator *JWTValidator, next http.Handler) http.Handler {
+	if len(keys) == 0 && jwtValidator == nil {
+		log.Printf("Warning: API authentication disabled (set SCANNER_API_KEY, SCANNER_API_KEYS_FILE or JWT_JWKS_URL)")
+		return next
+	}
+	log.Printf("- API authentication: %d key(s) configured, JWT: %v", len(keys), jwtValidator != nil)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if client, ok := quarantineLinkCaller(r); ok {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey, client)))
+			return
+		}
+
+		token := bearerToken(r)
+		client, method, err := authenticate(keys, jwtValidator, r, token)
+		if err != nil {
+			status := http.StatusUnauthorized
+			if err == errInsufficientScope {
+				status = http.StatusForbidden
+			}
+			slog.Warn("authentication failed",
+				"request_id", requestIDFrom(r.Context()),
+				"client", client,
+				"remote_addr", r.RemoteAddr,
+				"method", r.Method,
+				"path", r.URL.Path,
+				"key_present", token != "",
+				"error", err.Error(),
+			)
+			if status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="finguard"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, requiredScope(r)))
+			}
+			writeJSONError(w, status, err.Error(), "")
+			return
+		}
+
+		slog.Info("api access",
+			"request_id", requestIDFrom(r.Context()),
+			"client", client,
+			"auth", method,
+			"remote_addr", r.RemoteAddr,
+			"method", r.Method,
+			"path", r.URL.Path,
+		)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey, client)))
+	})
+}
+
+var errInsufficientScope = errors.New("Token lacks the required scope")
+
+// authenticate resolves the caller for token, returning the client name and
+// the method used ("api_key" or "jwt")
+func authenticate(keys []APIKey, jwtValidator *JWTValidator, r *http.Request, token string) (string, string, error) {
+	if token == "" {
+		return "", "", errors.New("Missing or invalid API key")
+	}
+	if client, ok := matchAPIKey(keys, token); ok {
+		return client, "api_key", nil
+	}
+	if jwtValidator == nil || !looksLikeJWT(token) {
+		return "", "", errors.New("Missing or invalid API key")
+	}
+
+	identity, err := jwtValidator.Validate(token)
+	if err != nil {
+		return "", "jwt", fmt.Errorf("Invalid token: %v", err)
+	}
+	if scope := requiredScope(r); scope != "" && !identity.Scopes[scope] {
+		return identity.Subject, "jwt", errInsufficientScope
+	}
+	return identity.Subject, "jwt", nil
+}
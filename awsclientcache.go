@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultAWSClientCacheTTL is how long AWS configs and S3 clients are reused
+const defaultAWSClientCacheTTL = 15 * time.Minute
+
+// AWSClientCache keeps the AWS configs and S3 clients built for requests,
+// keyed by credentials and region, so repeated calls reuse resolved
+// credentials and open connections
+type AWSClientCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	configs map[string]awsCachedConfig
+	clients map[string]awsCachedClient
+}
+
+type awsCachedConfig struct {
+	cfg     aws.Config
+	expires time.Time
+}
+
+type awsCachedClient struct {
+	client  *s3.Client
+	expires time.Time
+}
+
+// awsClients is configured at startup; nil or a zero TTL disables caching
+var awsClients *AWSClientCache
+
+// NewAWSClientCache creates a cache with the TTL from AWS_CLIENT_CACHE_TTL
+func NewAWSClientCache() *AWSClientCache {
+	ttl, err := time.ParseDuration(getEnv("AWS_CLIENT_CACHE_TTL", defaultAWSClientCacheTTL.String()))
+	if err != nil || ttl < 0 {
+		log.Printf("Warning: invalid AWS_CLIENT_CACHE_TTL, using %s", defaultAWSClientCacheTTL)
+		ttl = defaultAWSClientCacheTTL
+	}
+	return &AWSClientCache{
+		ttl:     ttl,
+		configs: make(map[string]awsCachedConfig),
+		clients: make(map[string]awsCachedClient),
+	}
+}
+
+// awsConfigKey identifies the credentials of opts in region. Secrets are
+// hashed so they are not kept in the keys.
+func awsConfigKey(opts S3Options, region string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		opts.Profile, opts.AwsAccessKey, string(opts.AwsSecretKey), opts.RoleArn, opts.ExternalID, opts.RoleSessionName, region,
+	}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// config returns the cached config for opts in region, loading it with load
+// when missing or expired. Errors are not cached.
+func (c *AWSClientCache) config(opts S3Options, region string, load func() (aws.Config, error)) (aws.Config, error) {
+	if c == nil || c.ttl == 0 {
+		return load()
+	}
+	key := awsConfigKey(opts, region)
+	c.mu.Lock()
+	cached, ok := c.configs[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		awsClientCacheLookups.WithLabelValues("config", "hit").Inc()
+		return cached.cfg, nil
+	}
+	awsClientCacheLookups.WithLabelValues("config", "miss").Inc()
+
+	cfg, err := load()
+	if err != nil {
+		return cfg, err
+	}
+	c.mu.Lock()
+	c.evictExpired()
+	c.configs[key] = awsCachedConfig{cfg: cfg, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return cfg, nil
+}
+
+// client returns the cached S3 client for cfg, built from opts, creating
+// it with build when missing or expired
+func (c *AWSClientCache) client(cfg aws.Config, opts S3Options, build func() *s3.Client) *s3.Client {
+	if c == nil || c.ttl == 0 {
+		return build()
+	}
+	key := awsConfigKey(opts, cfg.Region) + "|" + opts.EndpointURL + "|" + strconv.FormatBool(opts.ForcePathStyle) + "|" + strconv.FormatBool(opts.RequesterPays)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.clients[key]; ok && time.Now().Before(cached.expires) {
+		awsClientCacheLookups.WithLabelValues("s3", "hit").Inc()
+		return cached.client
+	}
+	awsClientCacheLookups.WithLabelValues("s3", "miss").Inc()
+	c.evictExpired()
+	client := build()
+	c.clients[key] = awsCachedClient{client: client, expires: time.Now().Add(c.ttl)}
+	return client
+}
+
+// evictExpired drops expired entries; callers must hold c.mu
+func (c *AWSClientCache) evictExpired() {
+	now := time.Now()
+	for key, cached := range c.configs {
+		if now.After(cached.expires) {
+			delete(c.configs, key)
+		}
+	}
+	for key, cached := range c.clients {
+		if now.After(cached.expires) {
+			delete(c.clients, key)
+		}
+	}
+}
+
+// flush drops every entry, so reloaded profiles and secrets take effect
+func (c *AWSClientCache) flush() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.configs)
+	clear(c.clients)
+	return nil
+}
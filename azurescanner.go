@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// AzureCredentials selects how to authenticate against a storage account.
+// The first populated option wins: connection string, account key, SAS token,
+// then the default Azure credential chain (managed identity, env, CLI).
+type AzureCredentials struct {
+	AccountName      string `json:"accountName"`
+	AccountKey       Secret `json:"accountKey"`
+	ConnectionString Secret `json:"connectionString"`
+	SASToken         Secret `json:"sasToken"`
+	Endpoint         string `json:"endpoint"`
+}
+
+// azureAccountPattern matches storage account names, which become part of
+// the service host name
+var azureAccountPattern = regexp.MustCompile(`^[a-z0-9]{3,24}$`)
+
+// azureClientOptions send every blob request through the outbound guard, so
+// endpoints from requests and connection strings cannot reach internal addresses
+var azureClientOptions = &azblob.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: newGuardedHTTPClient(0)}}
+
+// serviceURL returns the blob service URL for the account
+func (c AzureCredentials) serviceURL() string {
+	if c.Endpoint != "" {
+		return strings.TrimSuffix(c.Endpoint, "/") + "/"
+	}
+	return fmt.Sprintf("https://%s.blob.core.windows.net/", c.AccountName)
+}
+
+// checkEndpoint validates a caller-supplied endpoint. ambient is set when
+// the server's own Azure identity would authenticate the requests; its
+// tokens are only sent to the account's blob.core.windows.net URL or to
+// hosts listed in AZURE_TRUSTED_ENDPOINTS.
+func (c AzureCredentials) checkEndpoint(ambient bool) error {
+	if c.Endpoint == "" {
+		return nil
+	}
+	u, err := parseHTTPURL(c.Endpoint)
+	if err != nil {
+		return fmt.Errorf("endpoint must be an absolute http or https URL")
+	}
+	if err := checkOutboundHost(u.Hostname()); err != nil {
+		return fmt.Errorf("endpoint is not allowed: %v", err)
+	}
+	if ambient && !c.trustedEndpoint(u) {
+		return fmt.Errorf("endpoint %s needs accountKey, sasToken or connectionString; the server's Azure credentials are only used with %s.blob.core.windows.net", u.Host, c.AccountName)
+	}
+	return nil
+}
+
+// trustedEndpoint reports whether u is the canonical endpoint of the account
+// or an operator-configured one
+func (c AzureCredentials) trustedEndpoint(u *url.URL) bool {
+	if u.Scheme == "https" && strings.EqualFold(u.Host, c.AccountName+".blob.core.windows.net") {
+		return true
+	}
+	for _, host := range strings.Split(os.Getenv("AZURE_TRUSTED_ENDPOINTS"), ",") {
+		if host = strings.TrimSpace(host); host != "" && strings.EqualFold(u.Host, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// newAzureBlobClient creates a blob service client from the given credentials
+func newAzureBlobClient(creds AzureCredentials) (*azblob.Client, error) {
+	if creds.ConnectionString == "" && creds.AccountName != "" && !azureAccountPattern.MatchString(creds.AccountName) {
+		return nil, fmt.Errorf("invalid accountName %q", creds.AccountName)
+	}
+	ambient := creds.ConnectionString == "" && creds.AccountKey == "" && creds.SASToken == ""
+	if err := creds.checkEndpoint(ambient); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case creds.ConnectionString != "":
+		log.Println("Using Azure connection string")
+		client, err := azblob.NewClientFromConnectionString(string(creds.ConnectionString), azureClientOptions)
+		if err != nil {
+			return nil, err
+		}
+		// The connection string may name its own blob endpoint
+		if err := (AzureCredentials{Endpoint: client.URL()}).checkEndpoint(false); err != nil {
+			return nil, err
+		}
+		return client, nil
+	case creds.AccountName == "":
+		return nil, fmt.Errorf("accountName or connectionString is required")
+	case creds.AccountKey != "":
+		log.Println("Using Azure shared key credentials")
+		cred, err := azblob.NewSharedKeyCredential(creds.AccountName, string(creds.AccountKey))
+		if err != nil {
+			return nil, err
+		}
+		return azblob.NewClientWithSharedKeyCredential(creds.serviceURL(), cred, azureClientOptions)
+	case creds.SASToken != "":
+		log.Println("Using Azure SAS token")
+		return azblob.NewClientWithNoCredential(creds.serviceURL()+"?"+strings.TrimPrefix(string(creds.SASToken), "?"), azureClientOptions)
+	default:
+		log.Println("Using default Azure credentials from environment")
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, err
+		}
+		return azblob.NewClient(creds.serviceURL(), cred, azureClientOptions)
+	}
+}
+
+// AzureBlobClientReader implements AmaasClientReader for Azure blobs using ranged downloads
+type AzureBlobClientReader struct {
+	ctx       context.Context
+	client    *azblob.Client
+	account   string
+	container string
+	blob      string
+	size      int64
+}
+
+func NewAzureBlobClientReader(ctx context.Context, creds AzureCredentials, container, blob string) (*AzureBlobClientReader, error) {
+	log.Printf("Creating Azure reader for %s/%s", container, blob)
+
+	client, err := newAzureBlobClient(creds)
+	if err != nil {
+		log.Printf("Failed to create Azure blob client: %v", err)
+		return nil, err
+	}
+
+	props, err := client.ServiceClient().NewContainerClient(container).NewBlobClient(blob).GetProperties(ctx, nil)
+	if err != nil {
+		log.Printf("Failed to get blob properties: %v", err)
+		return nil, err
+	}
+	if props.ContentLength == nil {
+		return nil, fmt.Errorf("unable to get blob size from Azure")
+	}
+
+	log.Printf("Blob size: %d bytes", *props.ContentLength)
+	return &AzureBlobClientReader{
+		ctx:       ctx,
+		client:    client,
+		account:   creds.AccountName,
+		container: container,
+		blob:      blob,
+		size:      *props.ContentLength,
+	}, nil
+}
+
+// scanTarget describes the blob for the scan policy, with the container as its bucket
+func (r *AzureBlobClientReader) scanTarget() ScanTarget {
+	return ScanTarget{Bucket: r.container, Key: r.blob, Size: r.size}
+}
+
+// Identifier returns the Azure blob identifier
+func (r *AzureBlobClientReader) Identifier() string {
+	return fmt.Sprintf("az://%s/%s/%s", r.account, r.container, r.blob)
+}
+
+// DataSize returns the size of the blob
+func (r *AzureBlobClientReader) DataSize() (int64, error) {
+	return r.size, nil
+}
+
+// bindContext runs the ranged downloads of a scan under its context
+func (r *AzureBlobClientReader) bindContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// ReadBytes reads bytes from the blob at the specified offset
+func (r *AzureBlobClientReader) ReadBytes(offset int64, length int32) ([]byte, error) {
+	resp, err := r.client.DownloadStream(r.ctx, r.container, r.blob, &azblob.DownloadStreamOptions{
+		Range: azblob.HTTPRange{Offset: offset, Count: int64(length)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bytes, err := io.ReadAll(resp.Body)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading Azure blob body: %v", err)
+	}
+	return bytes, err
+}
+
+// newAzureReaderFromURI opens an Azure reader for account/container/blob
+func newAzureReaderFromURI(ctx context.Context, location string, options map[string]string) (amaasclient.AmaasClientReader, error) {
+	parts := strings.SplitN(location, "/", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("invalid Azure location %q, expected az://account/container/blob", location)
+	}
+	creds := AzureCredentials{
+		AccountName:      parts[0],
+		AccountKey:       Secret(options["accountKey"]),
+		ConnectionString: Secret(options["connectionString"]),
+		SASToken:         Secret(options["sasToken"]),
+		Endpoint:         options["endpoint"],
+	}
+	return NewAzureBlobClientReader(ctx, creds, parts[1], parts[2])
+}
+
+// HTTP handler for listing Azure containers
+func handleListAzureContainers(scannerClient *amaasclient.AmaasClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Printf("--- LIST AZURE CONTAINERS REQUEST at %s ---", time.Now().Format(time.RFC3339))
+
+		var req AzureCredentials
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		client, err := newAzureBlobClient(req)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Failed to create Azure client: %v", err), "accountName")
+			return
+		}
+
+		ctx := r.Context()
+		containers := make([]map[string]interface{}, 0)
+		pager := client.NewListContainersPager(nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				log.Printf("ERROR: Failed to list containers: %v", err)
+				http.Error(w, fmt.Sprintf("Failed to list containers: %v", err), http.StatusInternalServerError)
+				return
+			}
+			for _, item := range page.ContainerItems {
+				container := map[string]interface{}{"name": *item.Name}
+				if item.Properties != nil {
+					container["lastModified"] = item.Properties.LastModified
+				}
+				containers = append(containers, container)
+			}
+		}
+		log.Printf("Successfully listed %d Azure containers", len(containers))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"containers": containers,
+		})
+	}
+}
+
+// HTTP handler for listing blobs in an Azure container
+func handleListAzureBlobs(scannerClient *amaasclient.AmaasClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Printf("--- LIST AZURE BLOBS REQUEST at %s ---", time.Now().Format(time.RFC3339))
+
+		var req struct {
+			AzureCredentials
+			Container string `json:"container"`
+			Prefix    string `json:"prefix"`
+			Recursive bool   `json:"recursive"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Container == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing required field: container", "container")
+			return
+		}
+
+		client, err := newAzureBlobClient(req.AzureCredentials)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Failed to create Azure client: %v", err), "accountName")
+			return
+		}
+
+		var prefix *string
+		if req.Prefix != "" {
+			prefix = &req.Prefix
+		}
+
+		ctx := r.Context()
+		blobs := make([]map[string]interface{}, 0)
+		pager := client.NewListBlobsFlatPager(req.Container, &azblob.ListBlobsFlatOptions{Prefix: prefix})
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				log.Printf("Failed to list blobs in %s: %v", req.Container, err)
+				http.Error(w, fmt.Sprintf("Failed to list blobs: %v", err), http.StatusInternalServerError)
+				return
+			}
+			for _, item := range page.Segment.BlobItems {
+				// If not recursive, skip blobs in virtual subdirectories
+				if !req.Recursive && strings.Contains(strings.TrimPrefix(*item.Name, req.Prefix), "/") {
+					continue
+				}
+				blob := map[string]interface{}{"name": *item.Name}
+				if item.Properties != nil {
+					blob["size"] = item.Properties.ContentLength
+					blob["lastModified"] = item.Properties.LastModified
+				}
+				blobs = append(blobs, blob)
+			}
+		}
+		log.Printf("Successfully listed %d blobs from %s/%s", len(blobs), req.Container, req.Prefix)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"container": req.Container,
+			"blobs":     blobs,
+		})
+	}
+}
+
+// HTTP handler for scanning Azure blobs
+func handleScanAzureBlob(scannerClient *amaasclient.AmaasClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Printf("=== AZURE SCAN REQUEST at %s ===", time.Now().Format(time.RFC3339))
+
+		var req struct {
+			AzureCredentials
+			Container string   `json:"container"`
+			Blob      string   `json:"blob"`
+			Tags      []string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.Container == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing required field: container", "container")
+			return
+		}
+		if req.Blob == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing required field: blob", "blob")
+			return
+		}
+
+		ctx := r.Context()
+		reader, err := NewAzureBlobClientReader(ctx, req.AzureCredentials, req.Container, req.Blob)
+		if err != nil {
+			log.Printf("ERROR: Failed to create Azure reader: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to create Azure reader: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		tags := buildScanTags(sourceAzure, getCustomTags(), append(req.Tags, "file_type="+path.Ext(req.Blob))...)
+
+		log.Printf("=== Starting Azure Scan ===")
+		log.Printf("Blob: %s", reader.Identifier())
+		log.Printf("Size: %d bytes", reader.size)
+
+		scanStart := time.Now()
+		scanResult, err := observeScan(ctx, sourceAzure, reader.size, func(ctx context.Context) (string, error) {
+			return scanReader(ctx, scannerClient, reader, tags)
+		})
+		if writeScanRejected(w, err) || writeScanTimeout(w, ctx, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("❌ Scan FAILED for %s: %v", reader.Identifier(), err)
+			http.Error(w, fmt.Sprintf("Scan failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("✓ Scan COMPLETED successfully for %s", reader.Identifier())
+		response := map[string]interface{}{
+			"scanResult": scanResult,
+			"container":  req.Container,
+			"blob":       req.Blob,
+		}
+		if verdict, err := parseScanVerdict(scanResult); err == nil {
+			reportScanOutcome(ctx, ScanOutcome{
+				Source:     sourceAzure,
+				Bucket:     req.Container,
+				Key:        req.Blob,
+				Identifier: reader.Identifier(),
+				Tags:       tags,
+				Verdict:    verdict,
+				Duration:   time.Since(scanStart),
+				Size:       reader.size,
+			})
+			response["result"] = verdict.Normalize(time.Since(scanStart), wantsRawResult(r))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAzureEndpointChecks(t *testing.T) {
+	tests := []struct {
+		name    string
+		creds   AzureCredentials
+		allowed string
+		wantErr bool
+	}{
+		{"account key with a custom endpoint", AzureCredentials{AccountName: "acct", AccountKey: "a2V5", Endpoint: "https://blob.example.com/acct"}, "", false},
+		{"SAS token with a custom endpoint", AzureCredentials{AccountName: "acct", SASToken: "sv=1", Endpoint: "https://blob.example.com/acct"}, "", false},
+		{"account key with a metadata endpoint", AzureCredentials{AccountName: "acct", AccountKey: "a2V5", Endpoint: "http://169.254.169.254/"}, "", true},
+		{"account key with a loopback endpoint", AzureCredentials{AccountName: "devstoreaccount1", AccountKey: "a2V5", Endpoint: "http://127.0.0.1:10000/devstoreaccount1"}, "", true},
+		{"Azurite on an allowed network", AzureCredentials{AccountName: "devstoreaccount1", AccountKey: "a2V5", Endpoint: "http://127.0.0.1:10000/devstoreaccount1"}, "127.0.0.0/8", false},
+		{"account name injecting a host", AzureCredentials{AccountName: "evil.example.com?", AccountKey: "a2V5"}, "", true},
+		{"connection string with an internal endpoint", AzureCredentials{ConnectionString: "DefaultEndpointsProtocol=http;AccountName=acct;AccountKey=a2V5;BlobEndpoint=http://10.0.0.5/acct;"}, "", true},
+		{"connection string", AzureCredentials{ConnectionString: "DefaultEndpointsProtocol=https;AccountName=acct;AccountKey=a2V5;EndpointSuffix=core.windows.net"}, "", false},
+		{"server identity with a caller endpoint", AzureCredentials{AccountName: "acct", Endpoint: "https://attacker.example.com/"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OUTBOUND_ALLOWED_NETWORKS", tt.allowed)
+			if _, err := newAzureBlobClient(tt.creds); (err != nil) != tt.wantErr {
+				t.Errorf("newAzureBlobClient error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAzureAmbientCredentialEndpoints(t *testing.T) {
+	t.Setenv("AZURE_TRUSTED_ENDPOINTS", "blob.privatelink.example.com")
+	tests := []struct {
+		endpoint string
+		wantErr  bool
+	}{
+		{"", false},
+		{"https://acct.blob.core.windows.net/", false},
+		{"https://blob.privatelink.example.com/acct", false},
+		{"http://acct.blob.core.windows.net/", true},
+		{"https://other.blob.core.windows.net/", true},
+		{"https://attacker.example.com/", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			creds := AzureCredentials{AccountName: "acct", Endpoint: tt.endpoint}
+			if err := creds.checkEndpoint(true); (err != nil) != tt.wantErr {
+				t.Errorf("checkEndpoint(%q) = %v, want error %v", tt.endpoint, err, tt.wantErr)
+			}
+		})
+	}
+}
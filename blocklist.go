@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Malware names reported for blocklist matches without a threat name
+const (
+	blocklistHashThreat     = "Blocklisted.Hash"
+	blocklistFilenameThreat = "Blocklisted.Filename"
+)
+
+var blocklistedScans = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "finguard_blocklisted_scans_total",
+	Help: "Files reported malicious from the hash blocklist without scanning, by match.",
+}, []string{"match"})
+
+var errBlocklistEntryNotFound = errors.New("entry is not on the blocklist")
+
+// HashBlocklistEntry is one known-bad file, matched by SHA-256 or by a
+// filename pattern
+type HashBlocklistEntry struct {
+	SHA256 string `json:"sha256,omitempty"`
+	// Filename is a glob matched case-insensitively against the base name
+	Filename string `json:"filename,omitempty"`
+	// Threat is the malware name reported for matches
+	Threat    string `json:"threat,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	AddedBy   string `json:"addedBy,omitempty"`
+	AddedAt   string `json:"addedAt,omitempty"`
+	ExpiresAt string `json:"expiresAt,omitempty"` // RFC 3339; expired entries no longer match
+}
+
+// HashBlocklistResponse is returned by GET /blocklist. Feed hashes are
+// counted rather than listed.
+type HashBlocklistResponse struct {
+	Entries    []HashBlocklistEntry `json:"entries"`
+	FeedHashes map[string]int       `json:"feedHashes,omitempty"`
+}
+
+// HashBlocklist holds known-bad hashes and filename patterns that are
+// reported malicious before calling the scanner. Entries come from
+// HASH_BLOCKLIST_FILE and /blocklist; HASH_BLOCKLIST_FEEDS adds read-only
+// plain-text IOC feeds of hashes.
+type HashBlocklist struct {
+	mu       sync.RWMutex
+	hashes   map[string]HashBlocklistEntry
+	patterns []HashBlocklistEntry
+	feeds    map[string]string // SHA-256 to the feed that listed it
+}
+
+// hashBlocklist is loaded at startup and replaced on reload
+var hashBlocklist = &HashBlocklist{hashes: map[string]HashBlocklistEntry{}, feeds: map[string]string{}}
+
+// initHashBlocklist loads the blocklist at startup and re-reads it every
+// HASH_BLOCKLIST_REFRESH so updated feeds are picked up
+func initHashBlocklist() {
+	if err := hashBlocklist.reload(); err != nil {
+		log.Printf("Warning: hash blocklist not loaded: %v", err)
+	}
+	refresh := getEnv("HASH_BLOCKLIST_REFRESH", "")
+	if refresh == "" {
+		return
+	}
+	interval, err := time.ParseDuration(refresh)
+	if err != nil || interval <= 0 {
+		log.Printf("Warning: invalid HASH_BLOCKLIST_REFRESH %q, feeds are only re-read on reload", refresh)
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := hashBlocklist.reload(); err != nil {
+				log.Printf("Warning: hash blocklist refresh failed: %v", err)
+			}
+		}
+	}()
+}
+
+// validate normalizes the entry and checks it has a hash or a pattern
+func (e *HashBlocklistEntry) validate() error {
+	if (e.SHA256 == "") == (e.Filename == "") {
+		return fmt.Errorf("exactly one of sha256 or filename is required")
+	}
+	if e.SHA256 != "" {
+		if e.SHA256 = normalizeSHA256(e.SHA256); e.SHA256 == "" {
+			return fmt.Errorf("sha256 must be 64 hex characters")
+		}
+	}
+	if e.Filename != "" {
+		e.Filename = strings.ToLower(e.Filename)
+		if _, err := path.Match(e.Filename, ""); err != nil || strings.Contains(e.Filename, "/") {
+			return fmt.Errorf("filename must be a glob pattern for a base name, such as *.scr")
+		}
+	}
+	if e.ExpiresAt != "" {
+		if _, err := time.Parse(time.RFC3339, e.ExpiresAt); err != nil {
+			return fmt.Errorf("expiresAt must be an RFC 3339 timestamp")
+		}
+	}
+	return nil
+}
+
+// expired reports whether the entry's expiry has passed
+func (e HashBlocklistEntry) expired(now time.Time) bool {
+	if e.ExpiresAt == "" {
+		return false
+	}
+	expires, err := time.Parse(time.RFC3339, e.ExpiresAt)
+	return err == nil && !now.Before(expires)
+}
+
+// threat returns the malware name reported for the entry
+func (e HashBlocklistEntry) threat() string {
+	switch {
+	case e.Threat != "":
+		return e.Threat
+	case e.Filename != "":
+		return blocklistFilenameThreat
+	}
+	return blocklistHashThreat
+}
+
+// reload replaces the entries with HASH_BLOCKLIST_FILE, a JSON array of
+// entries, and the hashes of HASH_BLOCKLIST_FEEDS. On error the current
+// entries are kept.
+func (b *HashBlocklist) reload() error {
+	hashes := map[string]HashBlocklistEntry{}
+	var patterns []HashBlocklistEntry
+	if file := os.Getenv("HASH_BLOCKLIST_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		var list []HashBlocklistEntry
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &list); err != nil {
+				return fmt.Errorf("invalid %s: %v", file, err)
+			}
+		}
+		for i, entry := range list {
+			if err := entry.validate(); err != nil {
+				log.Printf("Warning: skipping blocklist entry %d in %s: %v", i, file, err)
+				continue
+			}
+			if entry.SHA256 != "" {
+				hashes[entry.SHA256] = entry
+			} else {
+				patterns = append(patterns, entry)
+			}
+		}
+	}
+	feeds := map[string]string{}
+	for _, feed := range envList("HASH_BLOCKLIST_FEEDS") {
+		if err := readHashFeed(feed, feeds); err != nil {
+			return fmt.Errorf("failed to read feed %s: %v", feed, err)
+		}
+	}
+
+	b.mu.Lock()
+	b.hashes, b.patterns, b.feeds = hashes, patterns, feeds
+	b.mu.Unlock()
+	if total := len(hashes) + len(patterns) + len(feeds); total > 0 {
+		log.Printf("- Hash blocklist: %d hashes, %d filename patterns, %d feed hashes", len(hashes), len(patterns), len(feeds))
+	}
+	return nil
+}
+
+// readHashFeed adds the SHA-256 hashes of a plain-text feed to feeds: the
+// first field of each line, as in sha256sum output. Blank lines, # comments
+// and other hash types are skipped.
+func readHashFeed(feed string, feeds map[string]string) error {
+	file, err := os.Open(feed)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if sum := normalizeSHA256(fields[0]); sum != "" {
+			feeds[sum] = feed
+		}
+	}
+	return scanner.Err()
+}
+
+// Match returns the unexpired entry matching a SHA-256 or a file name, and
+// whether it matched by hash or by filename
+func (b *HashBlocklist) Match(sha256, name string) (HashBlocklistEntry, string, bool) {
+	now := time.Now()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if sha256 = strings.ToLower(sha256); sha256 != "" {
+		if entry, ok := b.hashes[sha256]; ok && !entry.expired(now) {
+			return entry, "hash", true
+		}
+		if feed, ok := b.feeds[sha256]; ok {
+			return HashBlocklistEntry{SHA256: sha256, Reason: "feed " + feed}, "hash", true
+		}
+	}
+	if name != "" {
+		base := strings.ToLower(path.Base(strings.ReplaceAll(name, "\\", "/")))
+		for _, entry := range b.patterns {
+			if matched, _ := path.Match(entry.Filename, base); matched && !entry.expired(now) {
+				return entry, "filename", true
+			}
+		}
+	}
+	return HashBlocklistEntry{}, "", false
+}
+
+// List returns the file and API entries, hashes first
+func (b *HashBlocklist) List() HashBlocklistResponse {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	response := HashBlocklistResponse{Entries: b.entries()}
+	if len(b.feeds) > 0 {
+		response.FeedHashes = map[string]int{}
+		for _, feed := range b.feeds {
+			response.FeedHashes[feed]++
+		}
+	}
+	return response
+}
+
+// entries returns the file and API entries; the caller holds the lock
+func (b *HashBlocklist) entries() []HashBlocklistEntry {
+	entries := make([]HashBlocklistEntry, 0, len(b.hashes)+len(b.patterns))
+	for _, entry := range b.hashes {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].SHA256 < entries[j].SHA256 })
+	return append(entries, b.patterns...)
+}
+
+// Add adds or replaces an entry
+func (b *HashBlocklist) Add(entry HashBlocklistEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if entry.SHA256 != "" {
+		b.hashes[entry.SHA256] = entry
+	} else {
+		patterns := make([]HashBlocklistEntry, 0, len(b.patterns)+1)
+		for _, existing := range b.patterns {
+			if existing.Filename != entry.Filename {
+				patterns = append(patterns, existing)
+			}
+		}
+		b.patterns = append(patterns, entry)
+	}
+	return b.save()
+}
+
+// Remove deletes the entry for a SHA-256 or a filename pattern. Feed hashes
+// are removed by editing the feed.
+func (b *HashBlocklist) Remove(sha256, filename string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if sha256 != "" {
+		sha256 = strings.ToLower(sha256)
+		if _, ok := b.hashes[sha256]; !ok {
+			return errBlocklistEntryNotFound
+		}
+		delete(b.hashes, sha256)
+		return b.save()
+	}
+	filename = strings.ToLower(filename)
+	for i, entry := range b.patterns {
+		if entry.Filename == filename {
+			b.patterns = append(b.patterns[:i:i], b.patterns[i+1:]...)
+			return b.save()
+		}
+	}
+	return errBlocklistEntryNotFound
+}
+
+// save writes the entries to HASH_BLOCKLIST_FILE, when it is set
+func (b *HashBlocklist) save() error {
+	file := os.Getenv("HASH_BLOCKLIST_FILE")
+	if file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(b.entries(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(file, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to save blocklist: %v", err)
+	}
+	return nil
+}
+
+// blocklistedResult returns an infected SDK-style result marked blocklisted
+// for a file whose SHA-256 or name is on the blocklist, so it need not be
+// scanned
+func blocklistedResult(sha256, name string) (string, bool) {
+	entry, match, ok := hashBlocklist.Match(sha256, name)
+	if !ok {
+		return "", false
+	}
+	blocklistedScans.WithLabelValues(match).Inc()
+	log.Printf("Blocklist: %s matched by %s (%s)", name, match, entry.threat())
+	result, _ := json.Marshal(map[string]interface{}{
+		"scanResult":    1,
+		"foundMalwares": []interface{}{map[string]string{"malwareName": entry.threat(), "fileName": name}},
+		"fileSHA256":    strings.ToLower(sha256),
+		"blocklisted":   true,
+	})
+	return string(result), true
+}
+
+// applyBlocklist marks the verdict of a result from blocklistedResult, or of
+// a scanned file whose SHA-256 is on the blocklist, as malicious. It reports
+// whether the verdict is blocklisted.
+func applyBlocklist(verdict *ScanVerdict, marked bool) bool {
+	if !marked {
+		entry, _, ok := hashBlocklist.Match(verdict.FileSHA256, "")
+		if !ok {
+			return false
+		}
+		if !containsString(verdict.MalwareNames, entry.threat()) {
+			verdict.MalwareNames = append(verdict.MalwareNames, entry.threat())
+		}
+	}
+	verdict.Blocklisted = true
+	verdict.IsSafe = false
+	return true
+}
+
+// hasHashes reports whether any hash, from the file, the API or a feed, is listed
+func (b *HashBlocklist) hasHashes() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.hashes) > 0 || len(b.feeds) > 0
+}
+
+// listedResult answers from the blocklist, the scan policy in ctx, then the
+// allowlist, when the file's SHA-256, name or policy rule say so before
+// scanning. sha256 and name may be empty when unknown. Answers carry the type
+// detected before scanning.
+func listedResult(ctx context.Context, sha256, name string) (string, bool) {
+	result, ok := blocklistedResult(sha256, name)
+	if !ok {
+		result, ok = skippedResult(ctx, sha256)
+	}
+	if !ok {
+		result, ok = allowlistedResult(sha256)
+	}
+	if !ok {
+		return "", false
+	}
+	if detected := detectedTypeFrom(ctx); detected != "" {
+		result = annotateResult(result, map[string]interface{}{"detectedType": detected})
+	}
+	return result, true
+}
+
+// scanUnlessListed answers from listedResult, and otherwise scans through
+// the cache
+func scanUnlessListed(ctx context.Context, cache *ScanCache, sha256, name, cacheKey string, scan func() (string, error)) (string, bool, error) {
+	if result, ok := listedResult(ctx, sha256, name); ok {
+		return result, false, nil
+	}
+	return cache.Do(ctx, cacheKey, scan)
+}
+
+// scanListed runs listedResult in the scan funnels, so every backend checks
+// the lists before the scanner is called. sum returns the content's SHA-256;
+// it is only called while hashes are listed, and is nil for content that
+// cannot be hashed before it is scanned, which is checked by name and again
+// by hash once scanned.
+func scanListed(ctx context.Context, name string, sum func() string) (string, bool) {
+	sha := ""
+	if sum != nil && (hashBlocklist.hasHashes() || hashAllowlist.skipsHashes()) {
+		sha = sum()
+	}
+	return listedResult(ctx, sha, name)
+}
+
+// handleBlocklist serves GET and POST /blocklist, and DELETE
+// /blocklist/{sha256} and /blocklist?filename=pattern
+func handleBlocklist(w http.ResponseWriter, r *http.Request) {
+	sha := strings.Trim(strings.TrimPrefix(r.URL.Path, "/blocklist"), "/")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case sha == "" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(hashBlocklist.List())
+	case sha == "" && r.Method == http.MethodPost:
+		var entry HashBlocklistEntry
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON request body", "")
+			return
+		}
+		entry.AddedBy = callerFrom(r.Context())
+		entry.AddedAt = time.Now().UTC().Format(time.RFC3339)
+		if err := entry.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "")
+			return
+		}
+		if err := hashBlocklist.Add(entry); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error(), "")
+			return
+		}
+		log.Printf("Hash blocklist: %s%s added by %s (%s)", entry.SHA256, entry.Filename, entry.AddedBy, entry.Reason)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(entry)
+	case r.Method == http.MethodDelete:
+		filename := r.URL.Query().Get("filename")
+		if (sha == "") == (filename == "") {
+			writeJSONError(w, http.StatusBadRequest, "DELETE /blocklist/{sha256} or /blocklist?filename=pattern", "")
+			return
+		}
+		err := hashBlocklist.Remove(sha, filename)
+		if errors.Is(err, errBlocklistEntryNotFound) {
+			writeJSONError(w, http.StatusNotFound, err.Error(), "")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error(), "")
+			return
+		}
+		log.Printf("Hash blocklist: %s%s removed by %s", strings.ToLower(sha), strings.ToLower(filename), callerFrom(r.Context()))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
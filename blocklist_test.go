@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useTestLists replaces the blocklist and allowlist for the test
+func useTestLists(t *testing.T, blocked []HashBlocklistEntry, allowed []HashAllowlistEntry) {
+	t.Helper()
+	blocklist, allowlist := hashBlocklist, hashAllowlist
+	t.Cleanup(func() { hashBlocklist, hashAllowlist = blocklist, allowlist })
+	hashBlocklist = &HashBlocklist{hashes: map[string]HashBlocklistEntry{}, feeds: map[string]string{}}
+	hashAllowlist = &HashAllowlist{mode: allowlistSkip, entries: map[string]HashAllowlistEntry{}}
+	for _, entry := range blocked {
+		if err := hashBlocklist.Add(entry); err != nil {
+			t.Fatalf("blocklist %v: %v", entry, err)
+		}
+	}
+	for _, entry := range allowed {
+		hashAllowlist.entries[entry.SHA256] = entry
+	}
+}
+
+// The scan funnels answer listed files without calling the scanner, which is
+// nil here
+func TestScanFunnelsCheckLists(t *testing.T) {
+	eicar := []byte("not really malware")
+	benign := []byte("known good")
+	useTestLists(t,
+		[]HashBlocklistEntry{{SHA256: sha256Hex(eicar)}, {Filename: "*.scr"}},
+		[]HashAllowlistEntry{{SHA256: sha256Hex(benign)}})
+
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	blockedFile, patternFile, allowedFile := write("a.bin", eicar), write("b.scr", benign[:4]), write("c.txt", benign)
+
+	tests := []struct {
+		name string
+		scan func(ctx context.Context) (string, error)
+		want func(ScanVerdict) bool
+	}{
+		{"buffer by hash", func(ctx context.Context) (string, error) {
+			return scanBuffer(ctx, nil, eicar, "a.bin", nil)
+		}, func(v ScanVerdict) bool { return v.Blocklisted && !v.IsSafe }},
+		{"buffer by name", func(ctx context.Context) (string, error) {
+			return scanBuffer(ctx, nil, benign[:4], "mail/b.scr", nil)
+		}, func(v ScanVerdict) bool { return v.Blocklisted && !v.IsSafe }},
+		{"buffer allowlisted", func(ctx context.Context) (string, error) {
+			return scanBuffer(ctx, nil, benign, "c.txt", nil)
+		}, func(v ScanVerdict) bool { return v.Allowlisted && v.IsSafe }},
+		{"file by hash", func(ctx context.Context) (string, error) {
+			return scanFile(ctx, nil, blockedFile, nil)
+		}, func(v ScanVerdict) bool { return v.Blocklisted && !v.IsSafe }},
+		{"file by name", func(ctx context.Context) (string, error) {
+			return scanFile(ctx, nil, patternFile, nil)
+		}, func(v ScanVerdict) bool { return v.Blocklisted && !v.IsSafe }},
+		{"file allowlisted", func(ctx context.Context) (string, error) {
+			return scanFile(ctx, nil, allowedFile, nil)
+		}, func(v ScanVerdict) bool { return v.Allowlisted && v.IsSafe }},
+		{"local reader by hash", func(ctx context.Context) (string, error) {
+			reader, err := NewFileReader(blockedFile, blockedFile)
+			if err != nil {
+				return "", err
+			}
+			defer reader.Close()
+			return scanReader(ctx, nil, reader, nil)
+		}, func(v ScanVerdict) bool { return v.Blocklisted && !v.IsSafe }},
+		{"reader by name", func(ctx context.Context) (string, error) {
+			reader, err := NewFileReader(patternFile, "s3://bucket/b.scr")
+			if err != nil {
+				return "", err
+			}
+			defer reader.Close()
+			return scanReader(ctx, nil, reader, nil)
+		}, func(v ScanVerdict) bool { return v.Blocklisted && !v.IsSafe }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.scan(context.Background())
+			if err != nil {
+				t.Fatalf("scan: %v", err)
+			}
+			verdict, err := parseScanVerdict(result)
+			if err != nil {
+				t.Fatalf("parseScanVerdict(%s): %v", result, err)
+			}
+			if !tt.want(verdict) {
+				t.Errorf("unexpected verdict %+v for %s", verdict, result)
+			}
+		})
+	}
+}
+
+func TestScanListedHashesOnlyWhenListed(t *testing.T) {
+	tests := []struct {
+		name     string
+		blocked  []HashBlocklistEntry
+		wantSum  bool
+		wantList bool
+	}{
+		{"no entries", nil, false, false},
+		{"patterns only", []HashBlocklistEntry{{Filename: "*.exe"}}, false, false},
+		{"hashes listed", []HashBlocklistEntry{{SHA256: sha256Hex([]byte("x"))}}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestLists(t, tt.blocked, nil)
+			summed := false
+			_, listed := scanListed(context.Background(), "file.txt", func() string {
+				summed = true
+				return sha256Hex([]byte("x"))
+			})
+			if summed != tt.wantSum || listed != tt.wantList {
+				t.Errorf("summed %v listed %v, want %v and %v", summed, listed, tt.wantSum, tt.wantList)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// errScannerUnavailable is returned while the circuit breaker is open
+var errScannerUnavailable = errors.New("scanner backend unavailable")
+
+// CircuitBreaker stops scanner calls after consecutive backend failures so
+// requests fail fast instead of waiting out the scan timeout. While open it
+// probes the backend every cooldown; without a probe the first request after
+// the cooldown is let through as a trial.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	trial    bool // a half-open trial request is in flight
+	probe    func() error
+	probing  bool
+}
+
+// scanBreaker is the process-wide breaker used by observeScan
+var scanBreaker = newCircuitBreakerFromEnv()
+
+// newCircuitBreakerFromEnv reads SCANNER_BREAKER_THRESHOLD and
+// SCANNER_BREAKER_COOLDOWN. A threshold of 0 disables the breaker.
+func newCircuitBreakerFromEnv() *CircuitBreaker {
+	cooldown, err := time.ParseDuration(getEnv("SCANNER_BREAKER_COOLDOWN", defaultBreakerCooldown.String()))
+	if err != nil || cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return &CircuitBreaker{
+		threshold: getEnvInt("SCANNER_BREAKER_THRESHOLD", defaultBreakerThreshold),
+		cooldown:  cooldown,
+		state:     breakerClosed,
+	}
+}
+
+// SetProbe sets the check run every cooldown while the breaker is open
+func (b *CircuitBreaker) SetProbe(probe func() error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probe = probe
+}
+
+// Allow reports whether a scanner call may proceed
+func (b *CircuitBreaker) Allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.probe != nil || time.Since(b.openedAt) < b.cooldown {
+			return errScannerUnavailable
+		}
+		b.state = breakerHalfOpen
+		b.trial = true
+		log.Printf("Scanner circuit breaker half-open, sending a trial scan")
+		return nil
+	case breakerHalfOpen:
+		if b.trial {
+			return errScannerUnavailable
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+// Record updates the breaker with the outcome of an allowed scanner call.
+// Only outage-type errors count as failures; any other outcome shows the
+// backend is answering.
+func (b *CircuitBreaker) Record(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.trial = false
+	}
+	var timeout *ScanTimeoutError
+	switch {
+	case errors.Is(err, context.Canceled), status.Code(err) == codes.Canceled,
+		errors.Is(err, errScanQueueFull), errors.As(err, &timeout):
+		// The caller gave up or ran out of its own time; this says nothing
+		// about the backend
+	case err != nil && isTransientScanError(err):
+		b.failures++
+		if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+			b.open(err)
+		}
+	default:
+		b.close()
+	}
+}
+
+// open trips the breaker; the caller holds b.mu
+func (b *CircuitBreaker) open(err error) {
+	b.state = breakerOpen
+	b.openedAt = time.Now()
+	log.Printf("Scanner circuit breaker open after %d consecutive failures (last: %v); failing scans for %s", b.failures, err, b.cooldown)
+	if b.probe != nil && !b.probing {
+		b.probing = true
+		go b.probeUntilClosed()
+	}
+}
+
+// close resets the breaker; the caller holds b.mu
+func (b *CircuitBreaker) close() {
+	if b.state != breakerClosed {
+		log.Printf("Scanner circuit breaker closed, scanner backend recovered")
+	}
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+// probeUntilClosed runs the probe every cooldown until it succeeds
+func (b *CircuitBreaker) probeUntilClosed() {
+	for {
+		time.Sleep(b.cooldown)
+		b.mu.Lock()
+		probe := b.probe
+		b.mu.Unlock()
+
+		err := probe()
+
+		b.mu.Lock()
+		if err == nil {
+			b.close()
+			b.probing = false
+			b.mu.Unlock()
+			return
+		}
+		b.openedAt = time.Now()
+		b.mu.Unlock()
+		log.Printf("Scanner circuit breaker probe failed: %v", err)
+	}
+}
+
+// State returns closed, open or half-open
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// RetryAfter returns the time until the next probe or trial, at least one second
+func (b *CircuitBreaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return max(b.cooldown-time.Since(b.openedAt), time.Second)
+}
+
+// writeScannerUnavailable sends 503 for scans rejected by the open breaker
+func writeScannerUnavailable(w http.ResponseWriter) {
+	retryAfter := int(scanBreaker.RetryAfter().Round(time.Second) / time.Second)
+	log.Printf("Scan rejected: %v", errScannerUnavailable)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	writeJSONError(w, http.StatusServiceUnavailable, "Scanner backend is unavailable, retry later", "")
+}
+
+// writeScanRejected answers scans turned away before reaching the scanner
+// and reports whether it did
+func writeScanRejected(w http.ResponseWriter, err error) bool {
+	var tooLarge *ScanTooLargeError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeScanTooLarge(w, tooLarge)
+	case errors.Is(err, errScanQueueFull):
+		writeScanQueueFull(w)
+	case errors.Is(err, errScannerUnavailable):
+		writeScannerUnavailable(w)
+	case errors.Is(err, errTenantQuotaExceeded):
+		writeTenantQuotaExceeded(w)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BucketStats summarizes the recorded scans of one bucket or container
+type BucketStats struct {
+	Source          string     `json:"source"`
+	Bucket          string     `json:"bucket"`
+	Scans           int        `json:"scans"`
+	Objects         int        `json:"objects"`         // distinct keys scanned
+	Infected        int        `json:"infected"`        // malicious verdicts
+	InfectedObjects int        `json:"infectedObjects"` // distinct keys with a malicious verdict
+	Skipped         int        `json:"skipped"`
+	BytesScanned    int64      `json:"bytesScanned"`
+	FirstScanAt     time.Time  `json:"firstScanAt"`
+	LastScanAt      time.Time  `json:"lastScanAt"`
+	LastSweepAt     *time.Time `json:"lastSweepAt,omitempty"` // last completed scan of the whole bucket
+	LastSweepJob    string     `json:"lastSweepJob,omitempty"`
+}
+
+// BucketStatsResponse is returned by /stats/buckets
+type BucketStatsResponse struct {
+	Tenant      string        `json:"tenant,omitempty"`
+	From        *time.Time    `json:"from,omitempty"`
+	To          *time.Time    `json:"to,omitempty"`
+	GeneratedAt time.Time     `json:"generatedAt"`
+	Buckets     []BucketStats `json:"buckets"`
+}
+
+// handleBucketStats aggregates the scan history per bucket, with the last
+// bucket scan job that covered each S3 bucket whole
+func handleBucketStats(jobs *JobManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if scanHistory == nil {
+			writeJSONError(w, http.StatusServiceUnavailable, "Scan history is disabled (set HISTORY_DSN)", "")
+			return
+		}
+		filter, field, err := parseHistoryFilter(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), field)
+			return
+		}
+		// Statistics cover every verdict and file of the period
+		filter.Verdict, filter.FileSHA256, filter.Limit = "", "", 0
+
+		type statsKey struct{ source, bucket string }
+		stats := make(map[statsKey]*BucketStats)
+		objects := make(map[statsKey]map[string]bool)
+		infected := make(map[statsKey]map[string]bool)
+		err = scanHistory.Each(r.Context(), filter, func(record ScanRecord) error {
+			if record.Bucket == "" {
+				return nil
+			}
+			key := statsKey{record.Source, record.Bucket}
+			s := stats[key]
+			if s == nil {
+				s = &BucketStats{Source: record.Source, Bucket: record.Bucket, FirstScanAt: record.ScannedAt}
+				stats[key] = s
+				objects[key] = map[string]bool{}
+				infected[key] = map[string]bool{}
+			}
+			s.Scans++
+			s.LastScanAt = record.ScannedAt
+			// Skipped files were not scanned, so they only count as skipped
+			if isSkippedVerdict(record.Verdict) {
+				s.Skipped++
+				return nil
+			}
+			s.BytesScanned += record.SizeBytes
+			objects[key][record.Key] = true
+			if record.Verdict == "malicious" {
+				s.Infected++
+				infected[key][record.Key] = true
+			}
+			return nil
+		})
+		if err != nil {
+			loggerFrom(r.Context()).Error("bucket stats failed", "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to read the scan history", "")
+			return
+		}
+
+		sweeps := lastBucketSweeps(jobs, tenantName(r.Context()))
+		response := BucketStatsResponse{
+			Tenant:      filter.Tenant,
+			GeneratedAt: time.Now().UTC(),
+			Buckets:     make([]BucketStats, 0, len(stats)),
+		}
+		if !filter.From.IsZero() {
+			response.From = &filter.From
+		}
+		if !filter.To.IsZero() {
+			response.To = &filter.To
+		}
+		for key, s := range stats {
+			s.Objects = len(objects[key])
+			s.InfectedObjects = len(infected[key])
+			if sweep, ok := sweeps[s.Bucket]; ok && s.Source == sourceS3 {
+				s.LastSweepAt = sweep.FinishedAt
+				s.LastSweepJob = sweep.ID
+			}
+			response.Buckets = append(response.Buckets, *s)
+		}
+		sort.Slice(response.Buckets, func(i, j int) bool {
+			if response.Buckets[i].Source != response.Buckets[j].Source {
+				return response.Buckets[i].Source < response.Buckets[j].Source
+			}
+			return response.Buckets[i].Bucket < response.Buckets[j].Bucket
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// lastBucketSweeps returns the latest completed bucket scan job without a
+// prefix for each bucket of tenant
+func lastBucketSweeps(jobs *JobManager, tenant string) map[string]JobState {
+	sweeps := make(map[string]JobState)
+	if jobs == nil {
+		return sweeps
+	}
+	for _, job := range jobs.List() {
+		state := job.Snapshot(false)
+		if state.Type != jobTypeBucketScan || state.Status != JobCompleted || state.Tenant != tenant || state.FinishedAt == nil {
+			continue
+		}
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(state.Target, "s3://"), "/")
+		if prefix != "" {
+			continue
+		}
+		if previous, ok := sweeps[bucket]; !ok || state.FinishedAt.After(*previous.FinishedAt) {
+			sweeps[bucket] = state
+		}
+	}
+	return sweeps
+}
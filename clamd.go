@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+const (
+	clamdReadTimeout = 2 * time.Minute
+	clamdCommands    = "PING VERSION VERSIONCOMMANDS INSTREAM IDSESSION END"
+)
+
+// errClamdStreamTooLarge is returned when an INSTREAM exceeds the size limit
+var errClamdStreamTooLarge = errors.New("INSTREAM size limit exceeded")
+
+// ClamdServer speaks the subset of the clamd protocol used by client
+// libraries (PING, VERSION, INSTREAM and IDSESSION) and answers INSTREAM
+// with the verdict of a regular scan. Commands are either prefixed with 'z'
+// and NUL-terminated, prefixed with 'n' and newline-terminated, or bare
+// newline-terminated lines; replies use the same terminator.
+type ClamdServer struct {
+	scannerClient *amaasclient.AmaasClient
+	listener      net.Listener
+	maxStream     int64
+	tenant        *Tenant
+}
+
+// startClamdListener listens for clamd clients on addr (CLAMD_LISTEN_ADDR).
+// The protocol has no authentication, so the port belongs on a private network.
+func startClamdListener(ctx context.Context, scannerClient *amaasclient.AmaasClient, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	maxStream := int64(getEnvInt("CLAMD_STREAM_MAX_MB", 0)) << 20
+	if maxStream <= 0 {
+		maxStream = getMaxUploadSize()
+	}
+	s := &ClamdServer{
+		scannerClient: scannerClient,
+		listener:      listener,
+		maxStream:     maxStream,
+		tenant:        tenantRegistry.get(getEnv("CLAMD_TENANT", "")),
+	}
+	log.Printf("- clamd listener: %s (stream limit %d MB)", listener.Addr(), maxStream>>20)
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go s.serve(withBackgroundScan(withTenant(ctx, s.tenant)))
+	return nil
+}
+
+// serve accepts connections until the listener is closed
+func (s *ClamdServer) serve(ctx context.Context) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("clamd listener stopped: %v", err)
+			}
+			return
+		}
+		go s.handle(ctx, conn)
+	}
+}
+
+// readClamdCommand reads one command and returns it with the reply terminator
+func readClamdCommand(r *bufio.Reader) (string, byte, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return "", 0, err
+	}
+	switch first {
+	case 'z':
+		line, err := r.ReadString(0)
+		return strings.TrimSuffix(line, "\x00"), 0, err
+	case 'n':
+		line, err := r.ReadString('\n')
+		return strings.TrimSpace(line), '\n', err
+	}
+	line, err := r.ReadString('\n')
+	return strings.TrimSpace(string(first) + line), '\n', err
+}
+
+// handle serves one client connection, or a whole IDSESSION
+func (s *ClamdServer) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	session := false
+	for id := 1; ; id++ {
+		conn.SetReadDeadline(time.Now().Add(clamdReadTimeout))
+		command, term, err := readClamdCommand(r)
+		if err != nil {
+			return
+		}
+
+		var reply string
+		closeAfter := false
+		switch command {
+		case "PING":
+			reply = "PONG"
+		case "VERSION":
+			reply = clamdVersion()
+		case "VERSIONCOMMANDS":
+			reply = clamdVersion() + "| COMMANDS: " + clamdCommands
+		case "IDSESSION":
+			session = true
+			id--
+			continue
+		case "END":
+			return
+		case "INSTREAM":
+			reply, closeAfter = s.instream(ctx, conn, r)
+		default:
+			reply = "UNKNOWN COMMAND"
+			closeAfter = true
+		}
+
+		if session {
+			reply = fmt.Sprintf("%d: %s", id, reply)
+		}
+		conn.SetWriteDeadline(time.Now().Add(clamdReadTimeout))
+		if _, err := conn.Write(append([]byte(reply), term)); err != nil || closeAfter || !session {
+			return
+		}
+	}
+}
+
+// clamdVersion reports finguard in the "ClamAV <engine>/<db>/<date>" layout
+func clamdVersion() string {
+	build := buildVersionInfo()
+	return fmt.Sprintf("ClamAV finguard-%s/%s/%s", build.Version, build.SDKVersion, build.BuildDate)
+}
+
+// instreamReader decodes INSTREAM chunks: a 4-byte big-endian length followed
+// by the data, ending with a zero length
+type instreamReader struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	remaining uint32
+	total     int64
+	max       int64
+	done      bool
+}
+
+func (ir *instreamReader) Read(p []byte) (int, error) {
+	for ir.remaining == 0 {
+		if ir.done {
+			return 0, io.EOF
+		}
+		ir.conn.SetReadDeadline(time.Now().Add(clamdReadTimeout))
+		var size [4]byte
+		if _, err := io.ReadFull(ir.r, size[:]); err != nil {
+			return 0, err
+		}
+		ir.remaining = binary.BigEndian.Uint32(size[:])
+		if ir.remaining == 0 {
+			ir.done = true
+			return 0, io.EOF
+		}
+		if ir.total+int64(ir.remaining) > ir.max {
+			return 0, errClamdStreamTooLarge
+		}
+		ir.total += int64(ir.remaining)
+	}
+	if uint32(len(p)) > ir.remaining {
+		p = p[:ir.remaining]
+	}
+	n, err := ir.r.Read(p)
+	ir.remaining -= uint32(n)
+	return n, err
+}
+
+// instream scans one INSTREAM upload, spooled to SCAN_SPOOL_DIR, and returns
+// the clamd reply and whether the connection must be closed afterwards
+func (s *ClamdServer) instream(ctx context.Context, conn net.Conn, r *bufio.Reader) (string, bool) {
+	reader, _, err := spoolUpload(&instreamReader{conn: conn, r: r, max: s.maxStream}, "stream")
+	if errors.Is(err, errClamdStreamTooLarge) {
+		return errClamdStreamTooLarge.Error() + ". ERROR", true
+	}
+	if err != nil {
+		log.Printf("clamd: failed to read stream from %s: %v", conn.RemoteAddr(), err)
+		return "stream: read error ERROR", true
+	}
+	defer reader.Close()
+
+	tags := buildScanTags(sourceClamd, getCustomTags(), "trigger=clamd")
+	scanStart := time.Now()
+	scanResult, err := observeScan(ctx, sourceClamd, reader.size, func(ctx context.Context) (string, error) {
+		return scanReader(ctx, s.scannerClient, reader, tags)
+	})
+	var verdict ScanVerdict
+	if err == nil {
+		verdict, err = parseScanVerdict(scanResult)
+	}
+	if err != nil {
+		loggerFrom(ctx).Error("scan failed", "source", sourceClamd, "remote_addr", conn.RemoteAddr().String(), "error", err)
+		return "stream: scan failed ERROR", false
+	}
+
+	identifier := "clamd:" + conn.RemoteAddr().String()
+	reportScanOutcome(ctx, ScanOutcome{
+		Source:     sourceClamd,
+		Identifier: identifier,
+		Tags:       tags,
+		Verdict:    verdict,
+		Duration:   time.Since(scanStart),
+		Size:       reader.size,
+	})
+	if verdict.IsSafe {
+		return "stream: OK", false
+	}
+	name := "Malware"
+	if len(verdict.MalwareNames) > 0 {
+		name = verdict.MalwareNames[0]
+	}
+	return "stream: " + name + " FOUND", false
+}
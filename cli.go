@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// Exit codes of the scan subcommands
+const (
+	exitClean    = 0
+	exitDetected = 1
+	exitError    = 2
+)
+
+// CLIReport is the JSON printed by the scan subcommands
+type CLIReport struct {
+	Target   string            `json:"target"`
+	IsSafe   bool              `json:"isSafe"`
+	Scanned  int               `json:"scanned"`
+	Clean    int               `json:"clean"`
+	Infected int               `json:"infected"`
+	Skipped  int               `json:"skipped"`
+	Failed   int               `json:"failed"`
+	Results  []JobObjectResult `json:"results"`
+}
+
+// runCLI runs "scan file <path>", "scan s3 s3://bucket/key" or "scan dir <path>"
+// without the HTTP server. The report is printed to stdout and logs go to
+// stderr. It returns 0 when everything is clean, 1 when malware was found and
+// 2 on usage or scan errors.
+func runCLI(args []string) int {
+	if len(args) == 0 {
+		cliUsage()
+		return exitError
+	}
+
+	fs := flag.NewFlagSet("scan "+args[0], flag.ContinueOnError)
+	tags := fs.String("tags", "", "comma-separated key=value scan tags")
+	recursive := fs.Bool("recursive", true, "scan subdirectories (dir only)")
+	workers := fs.Int("workers", 0, "concurrent scans (dir only, default S3_SCAN_WORKERS)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 {
+		cliUsage()
+		return exitError
+	}
+	target := fs.Arg(0)
+	var extraTags []string
+	if *tags != "" {
+		extraTags = strings.Split(*tags, ",")
+	}
+
+	// Logs never mix with the JSON report
+	log.SetOutput(os.Stderr)
+	s3Logger = newComponentLogger(os.Stderr, "s3")
+
+	client, endpoint, err := newScannerClient(getCustomTags())
+	if err != nil {
+		log.Printf("Failed to create scanner client: %v", err)
+		return exitError
+	}
+	defer client.Destroy()
+	if scannerPool, err = newScannerPoolFromEnv(client, endpoint); err != nil {
+		log.Printf("Failed to create scanner backends: %v", err)
+		return exitError
+	}
+
+	ctx := withBackgroundScan(context.Background())
+	report := CLIReport{Target: target, Results: []JobObjectResult{}}
+	switch args[0] {
+	case "file":
+		report.add(scanDirectoryFile(ctx, client, WatchDir{Action: watchActionNone, Tags: extraTags}, target, "cli"))
+	case "s3":
+		if !strings.HasPrefix(target, "s3://") {
+			log.Printf("Expected an s3://bucket/key URI, got %q", target)
+			return exitError
+		}
+		report.add(scanURIObject(ctx, client, target, extraTags))
+	case "dir":
+		dir := WatchDir{Path: filepath.Clean(target), Recursive: *recursive, Action: watchActionNone, Tags: extraTags}
+		job := &Job{state: JobState{ID: "cli", Type: jobTypeDirectoryScan, Target: target}}
+		if err := runDirectoryScan(ctx, client, job, dir, *workers); err != nil {
+			log.Printf("Directory scan failed: %v", err)
+			return exitError
+		}
+		for _, result := range job.Snapshot(true).Results {
+			report.add(result)
+		}
+	default:
+		cliUsage()
+		return exitError
+	}
+
+	output, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(output))
+
+	switch {
+	case report.Infected > 0:
+		return exitDetected
+	case report.Failed > 0:
+		return exitError
+	}
+	return exitClean
+}
+
+// add counts one result into the report
+func (r *CLIReport) add(result JobObjectResult) {
+	r.Results = append(r.Results, result)
+	r.Scanned++
+	switch result.Verdict {
+	case "clean", verdictAllowlisted:
+		r.Clean++
+	case "malicious":
+		r.Infected++
+	case verdictSkipped, verdictSkippedTooLarge, verdictArchived:
+		r.Skipped++
+	default:
+		r.Failed++
+	}
+	r.IsSafe = r.Infected == 0 && r.Failed == 0
+}
+
+// scanURIObject scans one object addressed by a registered URI scheme
+func scanURIObject(ctx context.Context, scannerClient *amaasclient.AmaasClient, uri string, extraTags []string) JobObjectResult {
+	result := JobObjectResult{Key: uri}
+	scheme, _, err := splitScanURI(uri)
+	if err != nil {
+		result.Verdict, result.Error = "error", err.Error()
+		return result
+	}
+	reader, err := openReaderForURI(ctx, uri, nil)
+	if err != nil {
+		result.Verdict, result.Error = "error", err.Error()
+		return result
+	}
+
+	source := readerFactories[scheme].source
+	tags := buildScanTags(source, getCustomTags(), append(append([]string{}, extraTags...), "trigger=cli")...)
+	size, _ := reader.DataSize()
+	scanStart := time.Now()
+	scanResult, err := observeScan(ctx, source, size, func(ctx context.Context) (string, error) {
+		return scanReader(ctx, scannerClient, reader, tags)
+	})
+	if err != nil {
+		result.Verdict, result.Error = "error", err.Error()
+		return result
+	}
+	verdict, err := parseScanVerdict(scanResult)
+	if err != nil {
+		result.Verdict, result.Error = "error", err.Error()
+		return result
+	}
+
+	result.Verdict = verdict.Label()
+	result.MalwareNames = verdict.MalwareNames
+	normalized := verdict.Normalize(time.Since(scanStart), false)
+	result.Result = &normalized
+	reportScanOutcome(ctx, ScanOutcome{
+		Source:     source,
+		Identifier: reader.Identifier(),
+		Tags:       tags,
+		Verdict:    verdict,
+		Duration:   time.Since(scanStart),
+		Size:       size,
+	})
+	return result
+}
+
+// cliUsage prints the scan subcommand usage to stderr
+func cliUsage() {
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, `Usage:
+  %[1]s scan file [--tags k=v,...] <path>
+  %[1]s scan s3 [--tags k=v,...] s3://bucket/key
+  %[1]s scan dir [--tags k=v,...] [--recursive=false] [--workers N] <path>
+
+Prints a JSON report and exits 0 when clean, 1 when malware is found and 2 on errors.
+`, name)
+}
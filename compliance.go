@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	defaultComplianceMaxDetections = 1000
+	complianceMaxUnscanned         = 100
+)
+
+// Response headers carrying the compliance report signature
+const (
+	complianceHeaderID        = "X-Finguard-Report-Id"
+	complianceHeaderGenerated = "X-Finguard-Report-Generated"
+	complianceHeaderSHA256    = "X-Finguard-Report-Sha256"
+	complianceHeaderSignature = "X-Finguard-Report-Signature"
+	complianceHeaderKeyID     = "X-Finguard-Report-Key-Id"
+)
+
+// ComplianceReport is the scanning evidence for a period and scope
+type ComplianceReport struct {
+	ID                  string                `json:"id"`
+	GeneratedAt         time.Time             `json:"generatedAt"`
+	From                time.Time             `json:"from"`
+	To                  time.Time             `json:"to"`
+	Tenant              string                `json:"tenant,omitempty"`
+	Bucket              string                `json:"bucket,omitempty"`
+	Scanner             VersionInfo           `json:"scanner"`
+	SigningKeyID        string                `json:"signingKeyId,omitempty"`
+	Coverage            ComplianceCoverage    `json:"coverage"`
+	Inventory           *ComplianceInventory  `json:"inventory,omitempty"`
+	Buckets             []ReportCount         `json:"buckets"`
+	Detections          []ComplianceDetection `json:"detections"`
+	DetectionsTruncated bool                  `json:"detectionsTruncated,omitempty"`
+}
+
+// ComplianceCoverage counts the scans made in the period
+type ComplianceCoverage struct {
+	Scans          int        `json:"scans"`
+	ObjectsScanned int        `json:"objectsScanned"` // distinct identifiers
+	Clean          int        `json:"clean"`
+	Infected       int        `json:"infected"`
+	Errors         int        `json:"errors"`
+	Skipped        int        `json:"skipped"` // not scanned, by scan policy rules
+	BytesScanned   int64      `json:"bytesScanned"`
+	FirstScan      *time.Time `json:"firstScan,omitempty"`
+	LastScan       *time.Time `json:"lastScan,omitempty"`
+}
+
+// ComplianceInventory compares the objects in a bucket with those scanned
+type ComplianceInventory struct {
+	Objects            int      `json:"objects"`
+	Scanned            int      `json:"scanned"`
+	CoveragePercent    float64  `json:"coveragePercent"`
+	Unscanned          []string `json:"unscanned"` // first keys not scanned in the period
+	UnscannedTruncated bool     `json:"unscannedTruncated,omitempty"`
+}
+
+// ComplianceDetection is one malicious verdict
+type ComplianceDetection struct {
+	ScannedAt    time.Time `json:"scannedAt"`
+	Identifier   string    `json:"identifier"`
+	MalwareNames []string  `json:"malwareNames"`
+	FileSHA256   string    `json:"fileSha256,omitempty"`
+	ScanID       string    `json:"scanId,omitempty"`
+	Source       string    `json:"source"`
+}
+
+// complianceKey signs compliance reports; nil leaves them unsigned
+var complianceKey ed25519.PrivateKey
+
+// initComplianceSigning loads the Ed25519 key in COMPLIANCE_SIGNING_KEY_FILE,
+// a PKCS #8 PEM file such as `openssl genpkey -algorithm ed25519` writes. A
+// configured key that cannot be loaded is an error rather than unsigned reports.
+func initComplianceSigning() error {
+	path := os.Getenv("COMPLIANCE_SIGNING_KEY_FILE")
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("%s is not a PEM file", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return fmt.Errorf("%s is not an Ed25519 key", path)
+	}
+	complianceKey = edKey
+	log.Printf("- Compliance reports: signed with key %s", complianceKeyID())
+	return nil
+}
+
+// complianceKeyID identifies the signing key: the first 16 hex characters of
+// the SHA-256 of its public key
+func complianceKeyID() string {
+	if complianceKey == nil {
+		return ""
+	}
+	sum := sha256.Sum256(complianceKey.Public().(ed25519.PublicKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// parseComplianceTime accepts an RFC 3339 timestamp or a date, which is
+// midnight UTC
+func parseComplianceTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// buildComplianceReport aggregates the scan history of the period in report,
+// listing the bucket for the inventory when listInventory is set
+func buildComplianceReport(ctx context.Context, report *ComplianceReport, listInventory func(fn func([]types.Object) error) error) error {
+	maxDetections := getEnvInt("COMPLIANCE_MAX_DETECTIONS", defaultComplianceMaxDetections)
+	identifiers := make(map[string]bool)
+	scannedKeys := make(map[string]bool)
+	buckets := make(map[string]*ReportCount)
+	report.Detections = []ComplianceDetection{}
+
+	filter := HistoryFilter{Tenant: report.Tenant, From: report.From, To: report.To, Bucket: report.Bucket}
+	err := scanHistory.Each(ctx, filter, func(record ScanRecord) error {
+		coverage := &report.Coverage
+		// Skipped files were not scanned, so they only count as skipped
+		if isSkippedVerdict(record.Verdict) {
+			coverage.Skipped++
+			return nil
+		}
+		coverage.Scans++
+		coverage.BytesScanned += record.SizeBytes
+		if coverage.FirstScan == nil {
+			first := record.ScannedAt.UTC()
+			coverage.FirstScan = &first
+		}
+		last := record.ScannedAt.UTC()
+		coverage.LastScan = &last
+		identifiers[record.Identifier] = true
+		if report.Bucket != "" && record.Verdict != "error" {
+			scannedKeys[record.Key] = true
+		}
+
+		infected := record.Verdict == "malicious"
+		switch {
+		case infected:
+			coverage.Infected++
+			if len(report.Detections) < maxDetections {
+				report.Detections = append(report.Detections, ComplianceDetection{
+					ScannedAt:    record.ScannedAt.UTC(),
+					Identifier:   record.Identifier,
+					MalwareNames: record.MalwareNames,
+					FileSHA256:   record.FileSHA256,
+					ScanID:       record.ScanID,
+					Source:       record.Source,
+				})
+			} else {
+				report.DetectionsTruncated = true
+			}
+		case record.Verdict == "clean" || record.Verdict == verdictAllowlisted:
+			coverage.Clean++
+		default:
+			coverage.Errors++
+		}
+		if record.Bucket != "" {
+			count := buckets[record.Bucket]
+			if count == nil {
+				count = &ReportCount{Name: record.Bucket}
+				buckets[record.Bucket] = count
+			}
+			count.Scans++
+			count.Bytes += record.SizeBytes
+			if infected {
+				count.Infected++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	report.Coverage.ObjectsScanned = len(identifiers)
+	report.Buckets = topReportCounts(buckets, 0)
+	sort.Slice(report.Buckets, func(i, j int) bool { return report.Buckets[i].Name < report.Buckets[j].Name })
+
+	if listInventory == nil {
+		return nil
+	}
+	inventory := &ComplianceInventory{Unscanned: []string{}}
+	err = listInventory(func(page []types.Object) error {
+		for _, obj := range page {
+			key := aws.ToString(obj.Key)
+			// Folder placeholders are never scanned
+			if strings.HasSuffix(key, "/") && aws.ToInt64(obj.Size) == 0 {
+				continue
+			}
+			inventory.Objects++
+			switch {
+			case scannedKeys[key]:
+				inventory.Scanned++
+			case len(inventory.Unscanned) < complianceMaxUnscanned:
+				inventory.Unscanned = append(inventory.Unscanned, key)
+			default:
+				inventory.UnscannedTruncated = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list s3://%s: %v", report.Bucket, err)
+	}
+	if inventory.Objects > 0 {
+		inventory.CoveragePercent = float64(inventory.Scanned) * 100 / float64(inventory.Objects)
+	}
+	report.Inventory = inventory
+	return nil
+}
+
+// complianceBucketLister lists the bucket with the server's credentials, or
+// the named S3 credential profile
+func complianceBucketLister(ctx context.Context, bucket, profile string) (func(fn func([]types.Object) error) error, error) {
+	opts := S3Options{Profile: profile}.withDefaults()
+	target, err := resolveBucket(bucket)
+	if err == nil {
+		opts, err = target.clientOptions(opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	region := opts.Region
+	if target.Region != "" {
+		region = target.Region
+	}
+	cfg, err := loadAWSConfig(ctx, opts, region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %v", err)
+	}
+	if region == "" && !target.AccessPoint && opts.EndpointURL == "" {
+		if detected, err := getBucketRegion(ctx, cfg, opts, target.Name); err == nil {
+			cfg.Region = detected
+		}
+	}
+	client := newS3Client(cfg, opts)
+	return func(fn func([]types.Object) error) error {
+		return listObjectPages(ctx, client, target.Name, "", "", fn)
+	}, nil
+}
+
+// compliancePage renders a compliance report as HTML
+var compliancePage = template.Must(template.New("compliance").Funcs(template.FuncMap{
+	"bytes": formatReportBytes,
+	"ts":    func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+	"join":  strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>FinGuard compliance report {{.ID}}</title>
+<style>body { font-family: sans-serif; max-width: 60em; margin: 2em auto } table { border-collapse: collapse; margin-bottom: 1.5em }
+th, td { border: 1px solid #ccc; padding: .3em .8em; text-align: left; vertical-align: top } td.n { text-align: right } .bad { color: #b00020 }</style></head>
+<body>
+<h2>FinGuard malware scanning compliance report</h2>
+<table>
+<tr><th>Report ID</th><td>{{.ID}}</td></tr>
+<tr><th>Generated</th><td>{{ts .GeneratedAt}}</td></tr>
+<tr><th>Period</th><td>{{ts .From}} to {{ts .To}}</td></tr>
+<tr><th>Scope</th><td>{{if .Tenant}}tenant {{.Tenant}}{{else}}all tenants{{end}}, {{if .Bucket}}bucket {{.Bucket}}{{else}}all sources{{end}}</td></tr>
+<tr><th>Scanner</th><td>FinGuard {{.Scanner.Version}} ({{.Scanner.GitCommit}}), SDK {{.Scanner.SDKVersion}}</td></tr>
+<tr><th>Signing key</th><td>{{if .SigningKeyID}}{{.SigningKeyID}}, Ed25519 signature in the {{.SignatureHeader}} header{{else}}unsigned{{end}}</td></tr>
+</table>
+<h3>Coverage</h3>
+{{with .Coverage}}<table>
+<tr><th>Scans</th><td class="n">{{.Scans}}</td></tr>
+<tr><th>Distinct objects scanned</th><td class="n">{{.ObjectsScanned}}</td></tr>
+<tr><th>Clean</th><td class="n">{{.Clean}}</td></tr>
+<tr><th>Infected</th><td class="n{{if .Infected}} bad{{end}}">{{.Infected}}</td></tr>
+<tr><th>Errors</th><td class="n">{{.Errors}}</td></tr>
+<tr><th>Skipped by policy</th><td class="n">{{.Skipped}}</td></tr>
+<tr><th>Bytes scanned</th><td class="n">{{bytes .BytesScanned}}</td></tr>
+{{if .FirstScan}}<tr><th>First scan</th><td>{{ts .FirstScan}}</td></tr><tr><th>Last scan</th><td>{{ts .LastScan}}</td></tr>{{end}}
+</table>{{end}}
+{{with .Inventory}}<h3>Bucket inventory</h3>
+<table>
+<tr><th>Objects in bucket</th><td class="n">{{.Objects}}</td></tr>
+<tr><th>Scanned in period</th><td class="n">{{.Scanned}} ({{printf "%.1f" .CoveragePercent}}%)</td></tr>
+</table>
+{{if .Unscanned}}<p>Objects not scanned in the period{{if .UnscannedTruncated}} (first {{len .Unscanned}}){{end}}:</p><ul>{{range .Unscanned}}<li><code>{{.}}</code></li>{{end}}</ul>{{end}}
+{{end}}
+{{if .Buckets}}<h3>Buckets</h3>
+<table><tr><th>Bucket</th><th>Scans</th><th>Infected</th><th>Bytes</th></tr>
+{{range .Buckets}}<tr><td>{{.Name}}</td><td class="n">{{.Scans}}</td><td class="n{{if .Infected}} bad{{end}}">{{.Infected}}</td><td class="n">{{bytes .Bytes}}</td></tr>
+{{end}}</table>{{end}}
+<h3>Detections</h3>
+{{if .Detections}}<table><tr><th>Scanned</th><th>Object</th><th>Malware</th><th>SHA-256</th></tr>
+{{range .Detections}}<tr><td>{{ts .ScannedAt}}</td><td>{{.Identifier}}</td><td>{{join .MalwareNames ", "}}</td><td><code>{{.FileSHA256}}</code></td></tr>
+{{end}}</table>{{if .DetectionsTruncated}}<p>Only the first {{len .Detections}} detections are listed.</p>{{end}}{{else}}<p>No detections.</p>{{end}}
+</body></html>
+`))
+
+// renderCompliancePDF lays the report out as a PDF document
+func renderCompliancePDF(report *ComplianceReport) []byte {
+	ts := func(t time.Time) string { return t.UTC().Format(time.RFC3339) }
+	doc := newPDFDocument("FinGuard compliance report " + report.ID)
+	doc.Heading("FinGuard malware scanning compliance report", 16)
+	facts := []float64{0.3, 0.7}
+	scope := "all tenants"
+	if report.Tenant != "" {
+		scope = "tenant " + report.Tenant
+	}
+	if report.Bucket != "" {
+		scope += ", bucket " + report.Bucket
+	} else {
+		scope += ", all sources"
+	}
+	signing := "unsigned"
+	if report.SigningKeyID != "" {
+		signing = report.SigningKeyID + " (Ed25519, see " + complianceHeaderSignature + ")"
+	}
+	doc.Row(true, facts, "Report ID", report.ID)
+	doc.Row(false, facts, "Generated", ts(report.GeneratedAt))
+	doc.Row(false, facts, "Period", ts(report.From)+" to "+ts(report.To))
+	doc.Row(false, facts, "Scope", scope)
+	doc.Row(false, facts, "Scanner", fmt.Sprintf("FinGuard %s (%s), SDK %s", report.Scanner.Version, report.Scanner.GitCommit, report.Scanner.SDKVersion))
+	doc.Row(false, facts, "Signing key", signing)
+
+	coverage := report.Coverage
+	doc.Heading("Coverage", 12)
+	doc.Row(false, facts, "Scans", strconv.Itoa(coverage.Scans))
+	doc.Row(false, facts, "Distinct objects scanned", strconv.Itoa(coverage.ObjectsScanned))
+	doc.Row(false, facts, "Clean", strconv.Itoa(coverage.Clean))
+	doc.Row(false, facts, "Infected", strconv.Itoa(coverage.Infected))
+	doc.Row(false, facts, "Errors", strconv.Itoa(coverage.Errors))
+	doc.Row(false, facts, "Skipped by policy", strconv.Itoa(coverage.Skipped))
+	doc.Row(false, facts, "Bytes scanned", formatReportBytes(coverage.BytesScanned))
+	if coverage.FirstScan != nil {
+		doc.Row(false, facts, "First scan", ts(*coverage.FirstScan))
+		doc.Row(false, facts, "Last scan", ts(*coverage.LastScan))
+	}
+
+	if inventory := report.Inventory; inventory != nil {
+		doc.Heading("Bucket inventory", 12)
+		doc.Row(false, facts, "Objects in bucket", strconv.Itoa(inventory.Objects))
+		doc.Row(false, facts, "Scanned in period", fmt.Sprintf("%d (%.1f%%)", inventory.Scanned, inventory.CoveragePercent))
+		if len(inventory.Unscanned) > 0 {
+			doc.Space(6)
+			if inventory.UnscannedTruncated {
+				doc.Text(fmt.Sprintf("Objects not scanned in the period (first %d):", len(inventory.Unscanned)))
+			} else {
+				doc.Text("Objects not scanned in the period:")
+			}
+			for _, key := range inventory.Unscanned {
+				doc.Row(false, []float64{1}, "  "+key)
+			}
+		}
+	}
+
+	if len(report.Buckets) > 0 {
+		doc.Heading("Buckets", 12)
+		columns := []float64{0.55, 0.15, 0.15, 0.15}
+		doc.Row(true, columns, "Bucket", "Scans", "Infected", "Bytes")
+		for _, bucket := range report.Buckets {
+			doc.Row(false, columns, bucket.Name, strconv.Itoa(bucket.Scans), strconv.Itoa(bucket.Infected), formatReportBytes(bucket.Bytes))
+		}
+	}
+
+	doc.Heading("Detections", 12)
+	if len(report.Detections) == 0 {
+		doc.Text("No detections.")
+	} else {
+		columns := []float64{0.22, 0.43, 0.35}
+		doc.Row(true, columns, "Scanned", "Object", "Malware")
+		for _, detection := range report.Detections {
+			doc.Row(false, columns, ts(detection.ScannedAt), detection.Identifier, strings.Join(detection.MalwareNames, ", "))
+		}
+		if report.DetectionsTruncated {
+			doc.Space(6)
+			doc.Text(fmt.Sprintf("Only the first %d detections are listed.", len(report.Detections)))
+		}
+	}
+	return doc.Bytes()
+}
+
+// handleComplianceReport serves GET /compliance/report: the scanning evidence
+// for a period (from, to) and scope (tenant, bucket) as PDF, HTML or JSON.
+// The document is signed with COMPLIANCE_SIGNING_KEY_FILE and the signature
+// returned in response headers.
+func handleComplianceReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if scanHistory == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Compliance reports need the scan history (set HISTORY_DSN)", "")
+		return
+	}
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "pdf"
+	}
+	if format != "pdf" && format != "html" && format != "json" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported report format %q, expected pdf, html or json", format), "format")
+		return
+	}
+
+	now := time.Now().UTC()
+	report := ComplianceReport{
+		ID:           "cr-" + now.Format("20060102T150405Z") + "-" + newRequestID()[:8],
+		GeneratedAt:  now,
+		To:           now,
+		Tenant:       query.Get("tenant"),
+		Bucket:       query.Get("bucket"),
+		Scanner:      buildVersionInfo(),
+		SigningKeyID: complianceKeyID(),
+	}
+	if name := tenantName(r.Context()); name != "" {
+		// Tenants only get evidence of their own scans
+		report.Tenant = name
+	}
+	if query.Get("from") == "" {
+		writeJSONError(w, http.StatusBadRequest, "from is required (RFC 3339 timestamp or YYYY-MM-DD)", "from")
+		return
+	}
+	for _, param := range []struct {
+		name string
+		dest *time.Time
+	}{{"from", &report.From}, {"to", &report.To}} {
+		if value := query.Get(param.name); value != "" {
+			t, err := parseComplianceTime(value)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, param.name+" must be an RFC 3339 timestamp or YYYY-MM-DD", param.name)
+				return
+			}
+			*param.dest = t
+		}
+	}
+	if !report.From.Before(report.To) {
+		writeJSONError(w, http.StatusBadRequest, "from must be before to", "from")
+		return
+	}
+
+	var lister func(fn func([]types.Object) error) error
+	if query.Get("inventory") == "true" {
+		if report.Bucket == "" {
+			writeJSONError(w, http.StatusBadRequest, "inventory needs a bucket", "inventory")
+			return
+		}
+		var err error
+		if lister, err = complianceBucketLister(r.Context(), report.Bucket, query.Get("profile")); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "bucket")
+			return
+		}
+	}
+	if err := buildComplianceReport(r.Context(), &report, lister); err != nil {
+		loggerFrom(r.Context()).Error("compliance report failed", "report", report.ID, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to build compliance report: "+err.Error(), "")
+		return
+	}
+
+	var body []byte
+	var contentType string
+	switch format {
+	case "pdf":
+		body, contentType = renderCompliancePDF(&report), "application/pdf"
+	case "html":
+		var buf bytes.Buffer
+		page := struct {
+			ComplianceReport
+			SignatureHeader string
+		}{report, complianceHeaderSignature}
+		if err := compliancePage.Execute(&buf, page); err != nil {
+			loggerFrom(r.Context()).Error("compliance report failed", "report", report.ID, "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to render compliance report", "")
+			return
+		}
+		body, contentType = buf.Bytes(), "text/html; charset=utf-8"
+	default:
+		body, _ = json.MarshalIndent(report, "", "  ")
+		contentType = "application/json"
+	}
+
+	sum := sha256.Sum256(body)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="finguard-compliance-%s.%s"`, report.ID, format))
+	w.Header().Set(complianceHeaderID, report.ID)
+	w.Header().Set(complianceHeaderGenerated, report.GeneratedAt.Format(time.RFC3339))
+	w.Header().Set(complianceHeaderSHA256, hex.EncodeToString(sum[:]))
+	if complianceKey != nil {
+		w.Header().Set(complianceHeaderSignature, base64.StdEncoding.EncodeToString(ed25519.Sign(complianceKey, body)))
+		w.Header().Set(complianceHeaderKeyID, report.SigningKeyID)
+	}
+	log.Printf("Compliance report %s generated for %s to %s (tenant %q, bucket %q)", report.ID,
+		report.From.Format(time.RFC3339), report.To.Format(time.RFC3339), report.Tenant, report.Bucket)
+	w.Write(body)
+}
+
+// handleCompliancePublicKey serves the PEM public key that verifies
+// compliance report signatures
+func handleCompliancePublicKey(w http.ResponseWriter, r *http.Request) {
+	if complianceKey == nil {
+		writeJSONError(w, http.StatusNotFound, "Compliance reports are unsigned (set COMPLIANCE_SIGNING_KEY_FILE)", "")
+		return
+	}
+	der, err := x509.MarshalPKIXPublicKey(complianceKey.Public())
+	if err != nil {
+		http.Error(w, "Failed to encode public key", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set(complianceHeaderKeyID, complianceKeyID())
+	pem.Encode(w, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writePKCS8 writes key as a PKCS #8 PEM file and returns its path
+func writePKCS8(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInitComplianceSigning(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	notPEM := filepath.Join(t.TempDir(), "key.txt")
+	os.WriteFile(notPEM, []byte("not a key"), 0o600)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantErr    bool
+		wantSigned bool
+	}{
+		{"not configured", "", false, false},
+		{"Ed25519 key", writePKCS8(t, edKey), false, true},
+		{"missing file", filepath.Join(t.TempDir(), "missing.pem"), true, false},
+		{"not PEM", notPEM, true, false},
+		{"not Ed25519", writePKCS8(t, ecKey), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			complianceKey = nil
+			t.Cleanup(func() { complianceKey = nil })
+			t.Setenv("COMPLIANCE_SIGNING_KEY_FILE", tt.path)
+			err := initComplianceSigning()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("initComplianceSigning() = %v, want error %v", err, tt.wantErr)
+			}
+			if signed := complianceKey != nil; signed != tt.wantSigned {
+				t.Errorf("signing key loaded = %v, want %v", signed, tt.wantSigned)
+			}
+		})
+	}
+}
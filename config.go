@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the file passed with --config. Each setting maps to the
+// environment variable in its env tag; variables already set in the
+// environment take precedence over the file.
+type Config struct {
+	Listener struct {
+		Address            string `yaml:"address" env:"SCANNER_LISTEN_ADDR"`
+		TLSCert            string `yaml:"tlsCert" env:"SCANNER_TLS_CERT"`
+		TLSKey             string `yaml:"tlsKey" env:"SCANNER_TLS_KEY"`
+		TLSClientCA        string `yaml:"tlsClientCA" env:"SCANNER_TLS_CLIENT_CA"`
+		TLSSelfSigned      string `yaml:"tlsSelfSigned" env:"SCANNER_TLS_SELF_SIGNED"`
+		APIKey             string `yaml:"apiKey" env:"SCANNER_API_KEY"`
+		APIKeysFile        string `yaml:"apiKeysFile" env:"SCANNER_API_KEYS_FILE"`
+		TenantsFile        string `yaml:"tenantsFile" env:"TENANTS_FILE"`
+		MaxUploadSizeMB    string `yaml:"maxUploadSizeMB" env:"MAX_UPLOAD_SIZE_MB"`
+		WSAllowedOrigins   string `yaml:"wsAllowedOrigins" env:"SCAN_WS_ALLOWED_ORIGINS"`
+		ClamdAddress       string `yaml:"clamdAddress" env:"CLAMD_LISTEN_ADDR"`
+		ClamdTenant        string `yaml:"clamdTenant" env:"CLAMD_TENANT"`
+		ClamdStreamMaxMB   string `yaml:"clamdStreamMaxMB" env:"CLAMD_STREAM_MAX_MB"`
+		MilterAddress      string `yaml:"milterAddress" env:"MILTER_LISTEN_ADDR"`
+		MilterAction       string `yaml:"milterAction" env:"MILTER_ACTION"`
+		MilterOnError      string `yaml:"milterOnError" env:"MILTER_ON_ERROR"`
+		MilterMaxMessageMB string `yaml:"milterMaxMessageMB" env:"MILTER_MAX_MESSAGE_MB"`
+		MilterTenant       string `yaml:"milterTenant" env:"MILTER_TENANT"`
+		GRPCAddress        string `yaml:"grpcAddress" env:"GRPC_LISTEN_ADDR"`
+		GRPCMaxMessageMB   string `yaml:"grpcMaxMessageMB" env:"GRPC_MAX_MESSAGE_MB"`
+	} `yaml:"listener"`
+
+	Scanner struct {
+		APIKey               string `yaml:"apiKey" env:"FSS_API_KEY"`
+		Region               string `yaml:"region" env:"FSS_REGION"`
+		ExternalAddr         string `yaml:"externalAddr" env:"SCANNER_EXTERNAL_ADDR"`
+		UseTLS               string `yaml:"useTLS" env:"SCANNER_USE_TLS"`
+		CustomTags           string `yaml:"customTags" env:"FSS_CUSTOM_TAGS"`
+		Preflight            string `yaml:"preflight" env:"SCANNER_PREFLIGHT"`
+		Timeout              string `yaml:"timeout" env:"SCAN_TIMEOUT"`
+		TimeoutMax           string `yaml:"timeoutMax" env:"SCAN_TIMEOUT_MAX"`
+		MaxScanSizeMB        string `yaml:"maxScanSizeMB" env:"MAX_SCAN_SIZE_MB"`
+		MaxScanSizeAction    string `yaml:"maxScanSizeAction" env:"MAX_SCAN_SIZE_ACTION"`
+		MaxScanSizeOverrides string `yaml:"maxScanSizeOverrides" env:"MAX_SCAN_SIZE_OVERRIDES"`
+		FailoverBackends     string `yaml:"failoverBackends" env:"SCANNER_FAILOVER_BACKENDS"`
+		Selection            string `yaml:"selection" env:"SCANNER_SELECTION"`
+		HealthInterval       string `yaml:"healthInterval" env:"SCANNER_HEALTH_INTERVAL"`
+		Retry                struct {
+			Attempts  string `yaml:"attempts" env:"SCAN_RETRY_ATTEMPTS"`
+			BaseDelay string `yaml:"baseDelay" env:"SCAN_RETRY_BASE_DELAY"`
+			MaxDelay  string `yaml:"maxDelay" env:"SCAN_RETRY_MAX_DELAY"`
+		} `yaml:"retry"`
+		Breaker struct {
+			Threshold string `yaml:"threshold" env:"SCANNER_BREAKER_THRESHOLD"`
+			Cooldown  string `yaml:"cooldown" env:"SCANNER_BREAKER_COOLDOWN"`
+		} `yaml:"breaker"`
+	} `yaml:"scanner"`
+
+	Secrets struct {
+		AWSRegion       string `yaml:"awsRegion" env:"SECRETS_AWS_REGION"`
+		RefreshInterval string `yaml:"refreshInterval" env:"SECRETS_REFRESH_INTERVAL"`
+		VaultAddr       string `yaml:"vaultAddr" env:"VAULT_ADDR"`
+		VaultNamespace  string `yaml:"vaultNamespace" env:"VAULT_NAMESPACE"`
+	} `yaml:"secrets"`
+
+	S3 struct {
+		Region          string `yaml:"region" env:"AWS_REGION"`
+		EndpointURL     string `yaml:"endpointURL" env:"S3_ENDPOINT_URL"`
+		EndpointRegion  string `yaml:"endpointRegion" env:"S3_ENDPOINT_REGION"`
+		ForcePathStyle  string `yaml:"forcePathStyle" env:"S3_FORCE_PATH_STYLE"`
+		ScanWorkers     string `yaml:"scanWorkers" env:"S3_SCAN_WORKERS"`
+		ListConcurrency string `yaml:"listConcurrency" env:"S3_LIST_CONCURRENCY"`
+		ReadAheadMB     string `yaml:"readAheadMB" env:"S3_READ_AHEAD_MB"`
+		PrefetchDepth   string `yaml:"prefetchDepth" env:"S3_PREFETCH_DEPTH"`
+		PrefetchMinMB   string `yaml:"prefetchMinMB" env:"S3_PREFETCH_MIN_MB"`
+		SizeProbe       string `yaml:"sizeProbe" env:"S3_SIZE_PROBE"`
+		ArchivedAction  string `yaml:"archivedAction" env:"S3_ARCHIVED_ACTION"`
+		RestoreTier     string `yaml:"restoreTier" env:"S3_RESTORE_TIER"`
+		RestoreDays     string `yaml:"restoreDays" env:"S3_RESTORE_DAYS"`
+		RestoreWait     string `yaml:"restoreWait" env:"S3_RESTORE_WAIT"`
+		RestorePoll     string `yaml:"restorePollInterval" env:"S3_RESTORE_POLL_INTERVAL"`
+		LogPath         string `yaml:"logPath" env:"S3_LOG_PATH"`
+		ProfilesFile    string `yaml:"profilesFile" env:"AWS_PROFILES_FILE"`
+		ClientCacheTTL  string `yaml:"clientCacheTTL" env:"AWS_CLIENT_CACHE_TTL"`
+		RegionCacheTTL  string `yaml:"regionCacheTTL" env:"S3_REGION_CACHE_TTL"`
+		DefaultProfile  string `yaml:"defaultProfile" env:"S3_DEFAULT_PROFILE"`
+		RejectInline    string `yaml:"rejectInlineCredentials" env:"S3_REJECT_INLINE_CREDENTIALS"`
+	} `yaml:"s3"`
+
+	Dropbox struct {
+		AppKey       string `yaml:"appKey" env:"DROPBOX_APP_KEY"`
+		AppSecret    string `yaml:"appSecret" env:"DROPBOX_APP_SECRET"`
+		RefreshToken string `yaml:"refreshToken" env:"DROPBOX_REFRESH_TOKEN"`
+	} `yaml:"dropbox"`
+
+	Kafka struct {
+		Brokers       string `yaml:"brokers" env:"KAFKA_BROKERS"`
+		Topic         string `yaml:"topic" env:"KAFKA_TOPIC"`
+		ResultsTopic  string `yaml:"resultsTopic" env:"KAFKA_RESULTS_TOPIC"`
+		GroupID       string `yaml:"groupID" env:"KAFKA_GROUP_ID"`
+		ClientID      string `yaml:"clientID" env:"KAFKA_CLIENT_ID"`
+		Workers       string `yaml:"workers" env:"KAFKA_WORKERS"`
+		StartOffset   string `yaml:"startOffset" env:"KAFKA_START_OFFSET"`
+		TLS           string `yaml:"tls" env:"KAFKA_TLS"`
+		SASLMechanism string `yaml:"saslMechanism" env:"KAFKA_SASL_MECHANISM"`
+		SASLUsername  string `yaml:"saslUsername" env:"KAFKA_SASL_USERNAME"`
+		SASLPassword  string `yaml:"saslPassword" env:"KAFKA_SASL_PASSWORD"`
+		Tenant        string `yaml:"tenant" env:"KAFKA_TENANT"`
+	} `yaml:"kafka"`
+
+	NATS struct {
+		URL            string `yaml:"url" env:"NATS_URL"`
+		Subject        string `yaml:"subject" env:"NATS_SUBJECT"`
+		QueueGroup     string `yaml:"queueGroup" env:"NATS_QUEUE_GROUP"`
+		Stream         string `yaml:"stream" env:"NATS_STREAM"`
+		StreamSubject  string `yaml:"streamSubject" env:"NATS_STREAM_SUBJECT"`
+		Consumer       string `yaml:"consumer" env:"NATS_CONSUMER"`
+		ResultsSubject string `yaml:"resultsSubject" env:"NATS_RESULTS_SUBJECT"`
+		MaxDeliver     string `yaml:"maxDeliver" env:"NATS_MAX_DELIVER"`
+		Workers        string `yaml:"workers" env:"NATS_WORKERS"`
+		ClientName     string `yaml:"clientName" env:"NATS_CLIENT_NAME"`
+		CredsFile      string `yaml:"credsFile" env:"NATS_CREDS_FILE"`
+		Token          string `yaml:"token" env:"NATS_TOKEN"`
+		User           string `yaml:"user" env:"NATS_USER"`
+		Password       string `yaml:"password" env:"NATS_PASSWORD"`
+		TLSCA          string `yaml:"tlsCA" env:"NATS_TLS_CA"`
+		Tenant         string `yaml:"tenant" env:"NATS_TENANT"`
+	} `yaml:"nats"`
+
+	RabbitMQ struct {
+		URL               string `yaml:"url" env:"RABBITMQ_URL"`
+		Queue             string `yaml:"queue" env:"RABBITMQ_QUEUE"`
+		Workers           string `yaml:"workers" env:"RABBITMQ_WORKERS"`
+		Prefetch          string `yaml:"prefetch" env:"RABBITMQ_PREFETCH"`
+		MaxRetries        string `yaml:"maxRetries" env:"RABBITMQ_MAX_RETRIES"`
+		ResultsExchange   string `yaml:"resultsExchange" env:"RABBITMQ_RESULTS_EXCHANGE"`
+		ResultsRoutingKey string `yaml:"resultsRoutingKey" env:"RABBITMQ_RESULTS_ROUTING_KEY"`
+		Tenant            string `yaml:"tenant" env:"RABBITMQ_TENANT"`
+	} `yaml:"rabbitmq"`
+
+	Logging struct {
+		Path       string `yaml:"path" env:"LOG_PATH"`
+		MaxSizeMB  string `yaml:"maxSizeMB" env:"LOG_MAX_SIZE_MB"`
+		MaxAgeDays string `yaml:"maxAgeDays" env:"LOG_MAX_AGE_DAYS"`
+		MaxBackups string `yaml:"maxBackups" env:"LOG_MAX_BACKUPS"`
+		Compress   string `yaml:"compress" env:"LOG_COMPRESS"`
+	} `yaml:"logging"`
+
+	Concurrency struct {
+		Scans      string `yaml:"scans" env:"SCAN_CONCURRENCY"`
+		QueueDepth string `yaml:"queueDepth" env:"SCAN_QUEUE_DEPTH"`
+		JobWorkers string `yaml:"jobWorkers" env:"JOB_WORKERS"`
+		SQSWorkers string `yaml:"sqsWorkers" env:"SQS_WORKERS"`
+	} `yaml:"concurrency"`
+
+	Remediation struct {
+		DryRun     string `yaml:"dryRun" env:"REMEDIATION_DRY_RUN"`
+		RulesFile  string `yaml:"rulesFile" env:"REMEDIATION_RULES_FILE"`
+		Quarantine struct {
+			Bucket   string `yaml:"bucket" env:"QUARANTINE_BUCKET"`
+			Prefix   string `yaml:"prefix" env:"QUARANTINE_PREFIX"`
+			Original string `yaml:"original" env:"QUARANTINE_ORIGINAL"`
+		} `yaml:"quarantine"`
+		// Rules uses the REMEDIATION_RULES_FILE format; a rules file wins
+		Rules []map[string]interface{} `yaml:"rules"`
+	} `yaml:"remediation"`
+
+	Policy struct {
+		RulesFile string `yaml:"rulesFile" env:"SCAN_POLICY_FILE"`
+		// Rules uses the SCAN_POLICY_FILE format; a rules file wins
+		Rules []map[string]interface{} `yaml:"rules"`
+	} `yaml:"policy"`
+}
+
+var (
+	// configFilePath is the --config file, re-read on reload
+	configFilePath string
+	// configEnv holds the variables set from the config file, so a reload can
+	// update them without overriding the real environment
+	configEnv = map[string]string{}
+	// configRemediationRules holds the inline remediation rules of the config file as JSON
+	configRemediationRules []byte
+	// configScanPolicyRules holds the inline scan policy rules of the config file as JSON
+	configScanPolicyRules []byte
+)
+
+// loadConfigFile reads a YAML config file and exports its settings as
+// environment variables that are not set in the real environment. Variables
+// a previous load set and the file no longer contains are unset.
+func loadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("invalid %s: %v", path, err)
+	}
+
+	var rules, policyRules []byte
+	if len(cfg.Remediation.Rules) > 0 {
+		if rules, err = json.Marshal(cfg.Remediation.Rules); err != nil {
+			return fmt.Errorf("invalid remediation rules in %s: %v", path, err)
+		}
+	}
+	if len(cfg.Policy.Rules) > 0 {
+		if policyRules, err = json.Marshal(cfg.Policy.Rules); err != nil {
+			return fmt.Errorf("invalid policy rules in %s: %v", path, err)
+		}
+	}
+
+	previous := configEnv
+	configEnv = map[string]string{}
+	setEnvDefaults(reflect.ValueOf(&cfg).Elem(), previous)
+	for name, value := range previous {
+		if _, ok := configEnv[name]; !ok && os.Getenv(name) == value {
+			os.Unsetenv(name)
+		}
+	}
+	configFilePath = path
+	configRemediationRules = rules
+	configScanPolicyRules = policyRules
+	return nil
+}
+
+// setEnvDefaults sets the env tag of every non-empty string field, walking
+// nested sections, unless the variable has a value that previous did not set
+func setEnvDefaults(v reflect.Value, previous map[string]string) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			setEnvDefaults(field, previous)
+			continue
+		}
+		name := v.Type().Field(i).Tag.Get("env")
+		if name == "" || field.Kind() != reflect.String || field.String() == "" {
+			continue
+		}
+		if current := os.Getenv(name); current == "" || current == previous[name] {
+			os.Setenv(name, field.String())
+			configEnv[name] = field.String()
+		}
+	}
+}
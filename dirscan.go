@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+const jobTypeDirectoryScan = "directory-scan"
+
+// DirectoryScanRequest is the parameter set of a directory scan job. Action,
+// Target and DryRun work as in WATCH_CONFIG_FILE (none, quarantine, move or
+// delete).
+type DirectoryScanRequest struct {
+	Path      string   `json:"path"`
+	Recursive bool     `json:"recursive"`
+	Action    string   `json:"action"`
+	Target    string   `json:"target"`
+	DryRun    bool     `json:"dryRun"`
+	Tags      []string `json:"tags"`
+	Workers   int      `json:"workers"`
+}
+
+// validate checks the request before the job is queued and again when it
+// runs. Path and Target must be inside DIRECTORY_SCAN_ROOTS.
+func (req *DirectoryScanRequest) validate() error {
+	if req.Action == "" {
+		req.Action = watchActionNone
+	}
+	if err := req.watchDir().validate(); err != nil {
+		return err
+	}
+	if err := checkDirectoryScanRoot("path", req.Path); err != nil {
+		return err
+	}
+	if req.Target != "" {
+		return checkDirectoryScanRoot("target", req.Target)
+	}
+	return nil
+}
+
+// directoryScanRoots returns DIRECTORY_SCAN_ROOTS, the comma-separated
+// directories directory scan jobs may read, move and delete files in
+func directoryScanRoots() []string {
+	var roots []string
+	for _, root := range strings.Split(os.Getenv("DIRECTORY_SCAN_ROOTS"), ",") {
+		if root = strings.TrimSpace(root); root != "" {
+			roots = append(roots, resolvePath(filepath.Clean(root)))
+		}
+	}
+	return roots
+}
+
+// checkDirectoryScanRoot returns an error unless path, with symlinks
+// resolved, is one of the DIRECTORY_SCAN_ROOTS or below one
+func checkDirectoryScanRoot(field, path string) error {
+	roots := directoryScanRoots()
+	if len(roots) == 0 {
+		return fmt.Errorf("directory scans are disabled, set DIRECTORY_SCAN_ROOTS")
+	}
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("%s must be an absolute path", field)
+	}
+	resolved := resolvePath(filepath.Clean(path))
+	for _, root := range roots {
+		rel, err := filepath.Rel(root, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %s is outside DIRECTORY_SCAN_ROOTS", field, path)
+}
+
+// resolvePath resolves the symlinks of path, or of its nearest existing
+// parent when path does not exist yet
+func resolvePath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	if parent := filepath.Dir(path); parent != path {
+		return filepath.Join(resolvePath(parent), filepath.Base(path))
+	}
+	return path
+}
+
+// watchDir returns the request as a directory action configuration
+func (req DirectoryScanRequest) watchDir() WatchDir {
+	return WatchDir{Path: filepath.Clean(req.Path), Recursive: req.Recursive, Action: req.Action, Target: req.Target, DryRun: req.DryRun, Tags: req.Tags}
+}
+
+// directoryScanRunner returns the job runner for local directory scans
+func directoryScanRunner(scannerClient *amaasclient.AmaasClient) JobRunner {
+	return func(ctx context.Context, job *Job) error {
+		var req DirectoryScanRequest
+		if err := json.Unmarshal(job.Params(), &req); err != nil {
+			return fmt.Errorf("invalid directory scan parameters: %v", err)
+		}
+		if err := req.validate(); err != nil {
+			return err
+		}
+		return runDirectoryScan(ctx, scannerClient, job, req.watchDir(), req.Workers)
+	}
+}
+
+// runDirectoryScan scans every file under dir with a worker pool, skipping
+// files recorded on the job before a pause or restart
+func runDirectoryScan(ctx context.Context, scannerClient *amaasclient.AmaasClient, job *Job, dir WatchDir, workers int) error {
+	ctx = withBackgroundScan(ctx)
+	target := filepath.Clean(dir.Target)
+
+	var files []string
+	err := filepath.WalkDir(dir.Path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir.Path && (!dir.Recursive || (dir.Target != "" && p == target)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %v", dir.Path, err)
+	}
+
+	done := job.CompletedKeys()
+	pending := make([]string, 0, len(files))
+	for _, file := range files {
+		if !done[file] {
+			pending = append(pending, file)
+		}
+	}
+
+	workers = getBucketScanWorkers(workers)
+	log.Printf("Job %s: scanning %d files in %s (%d already done) with %d workers", job.ID(), len(pending), dir.Path, len(files)-len(pending), workers)
+	job.SetDryRun(dir.DryRun)
+	job.Start(len(files))
+
+	// Scans in flight when the job is paused or canceled run to completion
+	scanCtx := context.WithoutCancel(ctx)
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range queue {
+				job.Record(scanDirectoryFile(scanCtx, scannerClient, dir, path, "job"))
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(queue)
+	for _, path := range pending {
+		select {
+		case queue <- path:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// scanDirectoryFile scans one file and applies the directory action. trigger
+// is recorded in the scan tags.
+func scanDirectoryFile(ctx context.Context, scannerClient *amaasclient.AmaasClient, dir WatchDir, path, trigger string) JobObjectResult {
+	result := JobObjectResult{Key: path}
+
+	reader, err := NewFileReader(path, path)
+	if err != nil {
+		result.Verdict = "error"
+		result.Error = err.Error()
+		return result
+	}
+
+	tags := buildScanTags(sourceFilesystem, getCustomTags(), append(append([]string{}, dir.Tags...), "file_type="+filepath.Ext(path), "trigger="+trigger)...)
+	scanStart := time.Now()
+	scanResult, err := observeScan(ctx, sourceFilesystem, reader.size, func(ctx context.Context) (string, error) {
+		return scanReader(ctx, scannerClient, reader, tags)
+	})
+	// Close before the directory action moves or deletes the file
+	reader.Close()
+	if err != nil {
+		result.Verdict = "error"
+		result.Error = err.Error()
+		return result
+	}
+
+	verdict, err := parseScanVerdict(scanResult)
+	if err != nil {
+		result.Verdict = "error"
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Verdict = verdict.Label()
+	result.MalwareNames = verdict.MalwareNames
+	normalized := verdict.Normalize(time.Since(scanStart), false)
+	result.Result = &normalized
+	reportScanOutcome(ctx, ScanOutcome{
+		Source:     sourceFilesystem,
+		Identifier: path,
+		Tags:       tags,
+		Verdict:    verdict,
+		Duration:   time.Since(scanStart),
+		Size:       reader.size,
+	})
+	result.Remediation = applyDirAction(ctx, dir, path, verdict)
+	return result
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	driveAPIBase  = "https://www.googleapis.com/drive/v3"
+	driveReadOnly = "https://www.googleapis.com/auth/drive.readonly"
+	driveFull     = "https://www.googleapis.com/auth/drive"
+	// Google Docs, Sheets and other native files have no binary content
+	driveNativePrefix = "application/vnd.google-apps."
+	driveFolderType   = "application/vnd.google-apps.folder"
+	driveFileFields   = "id,name,mimeType,size,modifiedTime,parents,md5Checksum"
+)
+
+// DriveCredentials selects how Google Drive is accessed: an OAuth access
+// token obtained by the caller, a service-account JSON key (impersonating
+// Subject with domain-wide delegation when set), or the default credential
+// chain (workload identity, GOOGLE_APPLICATION_CREDENTIALS).
+type DriveCredentials struct {
+	AccessToken        Secret `json:"accessToken"`
+	ServiceAccountJSON Secret `json:"serviceAccountJson"`
+	Subject            string `json:"subject"`
+}
+
+// DriveQuarantineOptions enables actions on infected Drive files. Label
+// records the verdict in the file's appProperties; FolderID moves the file
+// into that folder.
+type DriveQuarantineOptions struct {
+	FolderID string `json:"folderId"`
+	Label    bool   `json:"label"`
+}
+
+// DriveQuarantineResult records the actions taken for an infected file
+type DriveQuarantineResult struct {
+	Labeled bool   `json:"labeled,omitempty"`
+	MovedTo string `json:"movedTo,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// driveFile is the subset of the Drive file resource used here
+type driveFile struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	MimeType     string   `json:"mimeType"`
+	Size         string   `json:"size"`
+	ModifiedTime string   `json:"modifiedTime"`
+	Parents      []string `json:"parents"`
+	MD5Checksum  string   `json:"md5Checksum"`
+}
+
+// driveClient is a minimal client for the Drive v3 API
+type driveClient struct {
+	http *http.Client
+}
+
+// newDriveClient creates an authenticated Drive client. write requests the
+// full Drive scope needed to label and move files.
+func newDriveClient(ctx context.Context, creds DriveCredentials, write bool) (*driveClient, error) {
+	scope := driveReadOnly
+	if write {
+		scope = driveFull
+	}
+
+	if creds.AccessToken != "" {
+		log.Println("Using provided Google Drive OAuth access token")
+		return &driveClient{http: oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: string(creds.AccessToken)}))}, nil
+	}
+	if creds.ServiceAccountJSON != "" {
+		log.Println("Using provided Google Drive service account credentials")
+		config, err := google.JWTConfigFromJSON([]byte(creds.ServiceAccountJSON), scope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Drive credentials: %v", err)
+		}
+		// Tokens come from Google's endpoint, not the key's token_uri
+		config.TokenURL = google.JWTTokenURL
+		config.Subject = creds.Subject
+		return &driveClient{http: config.Client(ctx)}, nil
+	}
+
+	log.Println("Using default Google Drive credentials from environment")
+	gcreds, err := google.FindDefaultCredentials(ctx, scope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Drive credentials: %v", err)
+	}
+	return &driveClient{http: oauth2.NewClient(ctx, gcreds.TokenSource)}, nil
+}
+
+// do sends a request to the Drive API and decodes a JSON response into out
+func (c *driveClient) do(ctx context.Context, method, endpoint string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Drive API returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// driveFileURL returns the API URL for a file with query parameters
+func driveFileURL(fileID string, query url.Values) string {
+	query.Set("supportsAllDrives", "true")
+	return fmt.Sprintf("%s/files/%s?%s", driveAPIBase, url.PathEscape(fileID), query.Encode())
+}
+
+// getFile fetches the metadata of a file
+func (c *driveClient) getFile(ctx context.Context, fileID string) (driveFile, error) {
+	var file driveFile
+	err := c.do(ctx, http.MethodGet, driveFileURL(fileID, url.Values{"fields": {driveFileFields}}), nil, &file)
+	return file, err
+}
+
+// DriveClientReader implements AmaasClientReader for Drive files using ranged
+// media downloads
+type DriveClientReader struct {
+	ctx    context.Context
+	client *driveClient
+	file   driveFile
+	size   int64
+}
+
+func NewDriveClientReader(ctx context.Context, creds DriveCredentials, fileID string) (*DriveClientReader, error) {
+	log.Printf("Creating Google Drive reader for file %s", fileID)
+
+	client, err := newDriveClient(ctx, creds, false)
+	if err != nil {
+		log.Printf("Failed to create Drive client: %v", err)
+		return nil, err
+	}
+	return newDriveReader(ctx, client, fileID)
+}
+
+// newDriveReader opens a reader for fileID with an existing client
+func newDriveReader(ctx context.Context, client *driveClient, fileID string) (*DriveClientReader, error) {
+	file, err := client.getFile(ctx, fileID)
+	if err != nil {
+		log.Printf("Failed to get Drive file metadata: %v", err)
+		return nil, err
+	}
+	if strings.HasPrefix(file.MimeType, driveNativePrefix) {
+		return nil, fmt.Errorf("%s is a %s; only files with binary content can be scanned", fileID, file.MimeType)
+	}
+	size, err := strconv.ParseInt(file.Size, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get file size from Drive: %v", err)
+	}
+
+	log.Printf("File %s (%s) size: %d bytes", file.Name, fileID, size)
+	return &DriveClientReader{
+		ctx:    ctx,
+		client: client,
+		file:   file,
+		size:   size,
+	}, nil
+}
+
+// Identifier returns the Drive file identifier
+func (r *DriveClientReader) Identifier() string {
+	return "gdrive://" + r.file.ID
+}
+
+// DataSize returns the size of the Drive file
+func (r *DriveClientReader) DataSize() (int64, error) {
+	return r.size, nil
+}
+
+// bindContext runs the ranged reads of a scan under its context
+func (r *DriveClientReader) bindContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// ReadBytes reads bytes from the Drive file at the specified offset
+func (r *DriveClientReader) ReadBytes(offset int64, length int32) ([]byte, error) {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, driveFileURL(r.file.ID, url.Values{"alt": {"media"}}), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+int64(length)-1))
+
+	resp, err := r.client.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Drive ranged read returned %s", resp.Status)
+	}
+
+	bytes, err := io.ReadAll(resp.Body)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading Drive file body: %v", err)
+	}
+	return bytes, err
+}
+
+// newDriveReaderFromURI opens a Drive reader for gdrive://<fileId>
+func newDriveReaderFromURI(ctx context.Context, location string, options map[string]string) (amaasclient.AmaasClientReader, error) {
+	fileID := strings.Trim(location, "/")
+	if fileID == "" || strings.Contains(fileID, "/") {
+		return nil, fmt.Errorf("invalid Drive location %q, expected gdrive://fileId", location)
+	}
+	creds := DriveCredentials{
+		AccessToken:        Secret(options["accessToken"]),
+		ServiceAccountJSON: Secret(options["serviceAccountJson"]),
+		Subject:            options["subject"],
+	}
+	return NewDriveClientReader(ctx, creds, fileID)
+}
+
+// quarantine labels and/or moves an infected file
+func (c *driveClient) quarantine(ctx context.Context, file driveFile, malwareNames []string, opts DriveQuarantineOptions) DriveQuarantineResult {
+	var result DriveQuarantineResult
+	query := url.Values{"fields": {"id"}}
+	var body interface{}
+	if opts.Label {
+		body = map[string]interface{}{"appProperties": map[string]string{
+			"finguard-verdict":   "malicious",
+			"finguard-malware":   strings.Join(malwareNames, ","),
+			"finguard-scannedAt": time.Now().UTC().Format(time.RFC3339),
+		}}
+	}
+	if opts.FolderID != "" {
+		query.Set("addParents", opts.FolderID)
+		query.Set("removeParents", strings.Join(file.Parents, ","))
+	}
+	if err := c.do(ctx, http.MethodPatch, driveFileURL(file.ID, query), body, nil); err != nil {
+		log.Printf("Failed to quarantine Drive file %s: %v", file.ID, err)
+		result.Error = err.Error()
+		return result
+	}
+	result.Labeled = opts.Label
+	result.MovedTo = opts.FolderID
+	log.Printf("Quarantined Drive file %s (labeled: %v, moved to: %s)", file.ID, opts.Label, opts.FolderID)
+	return result
+}
+
+// HTTP handler for listing files in a Drive folder or shared drive
+func handleListDriveFiles(scannerClient *amaasclient.AmaasClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Printf("--- LIST DRIVE FILES REQUEST at %s ---", time.Now().Format(time.RFC3339))
+
+		var req struct {
+			DriveCredentials
+			FolderID string `json:"folderId"`
+			DriveID  string `json:"driveId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		client, err := newDriveClient(ctx, req.DriveCredentials, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		query := url.Values{
+			"fields":                    {"nextPageToken,files(" + driveFileFields + ")"},
+			"pageSize":                  {"1000"},
+			"supportsAllDrives":         {"true"},
+			"includeItemsFromAllDrives": {"true"},
+		}
+		filter := "trashed = false"
+		if req.FolderID != "" {
+			filter += fmt.Sprintf(" and '%s' in parents", strings.ReplaceAll(req.FolderID, "'", `\'`))
+		}
+		query.Set("q", filter)
+		if req.DriveID != "" {
+			query.Set("corpora", "drive")
+			query.Set("driveId", req.DriveID)
+		}
+
+		files := make([]map[string]interface{}, 0)
+		for {
+			var page struct {
+				Files         []driveFile `json:"files"`
+				NextPageToken string      `json:"nextPageToken"`
+			}
+			if err := client.do(ctx, http.MethodGet, driveAPIBase+"/files?"+query.Encode(), nil, &page); err != nil {
+				log.Printf("Failed to list Drive files: %v", err)
+				http.Error(w, fmt.Sprintf("Failed to list files: %v", err), http.StatusInternalServerError)
+				return
+			}
+			for _, file := range page.Files {
+				size, _ := strconv.ParseInt(file.Size, 10, 64)
+				files = append(files, map[string]interface{}{
+					"id":           file.ID,
+					"name":         file.Name,
+					"mimeType":     file.MimeType,
+					"size":         size,
+					"lastModified": file.ModifiedTime,
+					"folder":       file.MimeType == driveFolderType,
+					"scannable":    !strings.HasPrefix(file.MimeType, driveNativePrefix),
+				})
+			}
+			if page.NextPageToken == "" {
+				break
+			}
+			query.Set("pageToken", page.NextPageToken)
+		}
+		log.Printf("Successfully listed %d Drive files", len(files))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"folderId": req.FolderID,
+			"files":    files,
+		})
+	}
+}
+
+// HTTP handler for scanning a Drive file by ID
+func handleScanDriveFile(scannerClient *amaasclient.AmaasClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Printf("=== DRIVE SCAN REQUEST at %s ===", time.Now().Format(time.RFC3339))
+
+		var req struct {
+			DriveCredentials
+			FileID     string                  `json:"fileId"`
+			Tags       []string                `json:"tags"`
+			Quarantine *DriveQuarantineOptions `json:"quarantine,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.FileID == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing required field: fileId", "fileId")
+			return
+		}
+		if req.Quarantine != nil && req.Quarantine.FolderID == "" && !req.Quarantine.Label {
+			writeJSONError(w, http.StatusBadRequest, "quarantine requires folderId or label", "quarantine")
+			return
+		}
+
+		ctx := r.Context()
+		client, err := newDriveClient(ctx, req.DriveCredentials, req.Quarantine != nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		reader, err := newDriveReader(ctx, client, req.FileID)
+		if err != nil {
+			log.Printf("ERROR: Failed to create Drive reader: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to create Drive reader: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		tags := buildScanTags(sourceDrive, getCustomTags(), append(req.Tags, "file_type="+path.Ext(reader.file.Name))...)
+
+		log.Printf("=== Starting Drive Scan ===")
+		log.Printf("File: %s (%s)", reader.file.Name, reader.Identifier())
+		log.Printf("Size: %d bytes", reader.size)
+
+		scanStart := time.Now()
+		scanResult, err := observeScan(ctx, sourceDrive, reader.size, func(ctx context.Context) (string, error) {
+			return scanReader(ctx, scannerClient, reader, tags)
+		})
+		if writeScanRejected(w, err) || writeScanTimeout(w, ctx, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("❌ Scan FAILED for %s: %v", reader.Identifier(), err)
+			http.Error(w, fmt.Sprintf("Scan failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("✓ Scan COMPLETED successfully for %s", reader.Identifier())
+
+		response := map[string]interface{}{
+			"scanResult": scanResult,
+			"fileId":     req.FileID,
+			"name":       reader.file.Name,
+		}
+		if verdict, err := parseScanVerdict(scanResult); err == nil {
+			reportScanOutcome(ctx, ScanOutcome{
+				Source:     sourceDrive,
+				Key:        reader.file.Name,
+				Identifier: reader.Identifier(),
+				Tags:       tags,
+				Verdict:    verdict,
+				Duration:   time.Since(scanStart),
+				Size:       reader.size,
+			})
+			response["result"] = verdict.Normalize(time.Since(scanStart), wantsRawResult(r))
+			if !verdict.IsSafe && req.Quarantine != nil {
+				response["quarantine"] = client.quarantine(ctx, reader.file, verdict.MalwareNames, *req.Quarantine)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+	"golang.org/x/oauth2"
+)
+
+const (
+	dropboxAPIBase           = "https://api.dropboxapi.com/2"
+	dropboxContentBase       = "https://content.dropboxapi.com/2"
+	dropboxTokenURL          = "https://api.dropboxapi.com/oauth2/token"
+	jobTypeDropboxFolderScan = "dropbox-folder-scan"
+)
+
+// DropboxCredentials selects how Dropbox is accessed: a short-lived access
+// token, or a refresh token with the app key and secret. Without either,
+// DROPBOX_REFRESH_TOKEN, DROPBOX_APP_KEY and DROPBOX_APP_SECRET are used.
+// SelectUser picks the member a Dropbox Business team token acts for.
+type DropboxCredentials struct {
+	AccessToken  Secret `json:"accessToken"`
+	RefreshToken Secret `json:"refreshToken"`
+	AppKey       string `json:"appKey"`
+	AppSecret    Secret `json:"appSecret"`
+	SelectUser   string `json:"selectUser"`
+}
+
+// dropboxFile is the subset of the Dropbox file metadata used here
+type dropboxFile struct {
+	Tag            string `json:".tag"`
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	PathDisplay    string `json:"path_display"`
+	Rev            string `json:"rev"`
+	Size           int64  `json:"size"`
+	ServerModified string `json:"server_modified"`
+	IsDownloadable *bool  `json:"is_downloadable"`
+}
+
+// downloadable reports whether the file has binary content; Paper documents
+// and similar files do not
+func (f dropboxFile) downloadable() bool {
+	return f.Tag == "file" && (f.IsDownloadable == nil || *f.IsDownloadable)
+}
+
+// dropboxClient is a minimal client for the Dropbox v2 API
+type dropboxClient struct {
+	http       *http.Client
+	selectUser string
+}
+
+// newDropboxClient creates an authenticated Dropbox client
+func newDropboxClient(ctx context.Context, creds DropboxCredentials) (*dropboxClient, error) {
+	client := &dropboxClient{selectUser: creds.SelectUser}
+	if creds.AccessToken != "" {
+		log.Println("Using provided Dropbox access token")
+		client.http = oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: string(creds.AccessToken)}))
+		return client, nil
+	}
+
+	if creds.RefreshToken != "" {
+		log.Println("Using provided Dropbox refresh token")
+	} else {
+		log.Println("Using Dropbox refresh token from environment")
+		creds.RefreshToken = Secret(os.Getenv("DROPBOX_REFRESH_TOKEN"))
+		creds.AppKey = os.Getenv("DROPBOX_APP_KEY")
+		creds.AppSecret = Secret(os.Getenv("DROPBOX_APP_SECRET"))
+	}
+	if creds.RefreshToken == "" || creds.AppKey == "" {
+		return nil, fmt.Errorf("Dropbox credentials required: accessToken, refreshToken with appKey, or DROPBOX_REFRESH_TOKEN and DROPBOX_APP_KEY")
+	}
+	config := oauth2.Config{
+		ClientID:     creds.AppKey,
+		ClientSecret: string(creds.AppSecret),
+		Endpoint:     oauth2.Endpoint{TokenURL: dropboxTokenURL, AuthStyle: oauth2.AuthStyleInParams},
+	}
+	// The token source outlives the request that created the client
+	client.http = oauth2.NewClient(ctx, config.TokenSource(context.WithoutCancel(ctx), &oauth2.Token{RefreshToken: string(creds.RefreshToken)}))
+	return client, nil
+}
+
+// newRequest builds a request with the team member selection header
+func (c *dropboxClient) newRequest(ctx context.Context, endpoint string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.selectUser != "" {
+		req.Header.Set("Dropbox-API-Select-User", c.selectUser)
+	}
+	return req, nil
+}
+
+// rpc calls a Dropbox RPC endpoint with a JSON argument and decodes the result
+func (c *dropboxClient) rpc(ctx context.Context, route string, arg, out interface{}) error {
+	data, err := json.Marshal(arg)
+	if err != nil {
+		return err
+	}
+	req, err := c.newRequest(ctx, dropboxAPIBase+route, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Dropbox API returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// dropboxURI returns the dropbox:// URI of a path
+func dropboxURI(filePath string) string {
+	return "dropbox://" + strings.TrimPrefix(filePath, "/")
+}
+
+// getMetadata fetches the metadata of a file by path or id:
+func (c *dropboxClient) getMetadata(ctx context.Context, filePath string) (dropboxFile, error) {
+	var file dropboxFile
+	err := c.rpc(ctx, "/files/get_metadata", map[string]string{"path": filePath}, &file)
+	return file, err
+}
+
+// DropboxClientReader implements AmaasClientReader for Dropbox files using
+// ranged downloads of the revision seen when the reader was opened
+type DropboxClientReader struct {
+	ctx    context.Context
+	client *dropboxClient
+	file   dropboxFile
+	size   int64
+}
+
+func NewDropboxClientReader(ctx context.Context, creds DropboxCredentials, filePath string) (*DropboxClientReader, error) {
+	log.Printf("Creating Dropbox reader for %s", filePath)
+
+	client, err := newDropboxClient(ctx, creds)
+	if err != nil {
+		log.Printf("Failed to create Dropbox client: %v", err)
+		return nil, err
+	}
+	return newDropboxReader(ctx, client, filePath)
+}
+
+// newDropboxReader opens a reader for filePath with an existing client
+func newDropboxReader(ctx context.Context, client *dropboxClient, filePath string) (*DropboxClientReader, error) {
+	file, err := client.getMetadata(ctx, filePath)
+	if err != nil {
+		log.Printf("Failed to get Dropbox file metadata: %v", err)
+		return nil, err
+	}
+	if !file.downloadable() {
+		return nil, fmt.Errorf("%s is not a downloadable file", filePath)
+	}
+
+	log.Printf("File %s (rev %s) size: %d bytes", file.PathDisplay, file.Rev, file.Size)
+	return &DropboxClientReader{
+		ctx:    ctx,
+		client: client,
+		file:   file,
+		size:   file.Size,
+	}, nil
+}
+
+// Identifier returns the dropbox:// URI of the file
+func (r *DropboxClientReader) Identifier() string {
+	return dropboxURI(r.file.PathDisplay)
+}
+
+// DataSize returns the size of the file
+func (r *DropboxClientReader) DataSize() (int64, error) {
+	return r.size, nil
+}
+
+// bindContext runs the ranged reads of a scan under its context
+func (r *DropboxClientReader) bindContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// ReadBytes reads bytes from the Dropbox file at the specified offset
+func (r *DropboxClientReader) ReadBytes(offset int64, length int32) ([]byte, error) {
+	req, err := r.client.newRequest(r.ctx, dropboxContentBase+"/files/download", nil)
+	if err != nil {
+		return nil, err
+	}
+	// Pin the revision so every range comes from the same content
+	req.Header.Set("Dropbox-API-Arg", fmt.Sprintf(`{"path":"rev:%s"}`, r.file.Rev))
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+int64(length)-1))
+
+	resp, err := r.client.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Dropbox ranged read returned %s", resp.Status)
+	}
+
+	bytes, err := io.ReadAll(resp.Body)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading Dropbox file body: %v", err)
+	}
+	return bytes, err
+}
+
+// newDropboxReaderFromURI opens a Dropbox reader for dropbox://<path>
+func newDropboxReaderFromURI(ctx context.Context, location string, options map[string]string) (amaasclient.AmaasClientReader, error) {
+	filePath := "/" + strings.Trim(location, "/")
+	if filePath == "/" {
+		return nil, fmt.Errorf("invalid Dropbox location %q, expected dropbox://path/to/file", location)
+	}
+	creds := DropboxCredentials{
+		AccessToken:  Secret(options["accessToken"]),
+		RefreshToken: Secret(options["refreshToken"]),
+		AppKey:       options["appKey"],
+		AppSecret:    Secret(options["appSecret"]),
+		SelectUser:   options["selectUser"],
+	}
+	return NewDropboxClientReader(ctx, creds, filePath)
+}
+
+// scanDropboxFile scans one Dropbox file. It is shared by /dropbox/scan and
+// folder scan jobs.
+func scanDropboxFile(ctx context.Context, scannerClient *amaasclient.AmaasClient, reader *DropboxClientReader, extraTags []string) (string, ScanVerdict, error) {
+	tags := buildScanTags(sourceDropbox, getCustomTags(), append(append([]string{}, extraTags...), "file_type="+path.Ext(reader.file.Name))...)
+	scanStart := time.Now()
+	scanResult, err := observeScan(ctx, sourceDropbox, reader.size, func(ctx context.Context) (string, error) {
+		return scanReader(ctx, scannerClient, reader, tags)
+	})
+	if err != nil {
+		return "", ScanVerdict{}, err
+	}
+	verdict, err := parseScanVerdict(scanResult)
+	if err != nil {
+		return scanResult, verdict, err
+	}
+	reportScanOutcome(ctx, ScanOutcome{
+		Source:     sourceDropbox,
+		Key:        reader.file.PathDisplay,
+		Identifier: reader.Identifier(),
+		Tags:       tags,
+		Verdict:    verdict,
+		Duration:   time.Since(scanStart),
+		Size:       reader.size,
+	})
+	return scanResult, verdict, nil
+}
+
+// HTTP handler for scanning a Dropbox file by path or id:
+func handleScanDropboxFile(scannerClient *amaasclient.AmaasClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Printf("=== DROPBOX SCAN REQUEST at %s ===", time.Now().Format(time.RFC3339))
+
+		var req struct {
+			DropboxCredentials
+			Path string   `json:"path"`
+			Tags []string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.Path == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing required field: path", "path")
+			return
+		}
+
+		ctx := r.Context()
+		reader, err := NewDropboxClientReader(ctx, req.DropboxCredentials, req.Path)
+		if err != nil {
+			log.Printf("ERROR: Failed to create Dropbox reader: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to create Dropbox reader: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("=== Starting Dropbox Scan ===")
+		log.Printf("File: %s (rev %s)", reader.file.PathDisplay, reader.file.Rev)
+		log.Printf("Size: %d bytes", reader.size)
+
+		scanStart := time.Now()
+		scanResult, _, err := scanDropboxFile(ctx, scannerClient, reader, req.Tags)
+		if writeScanRejected(w, err) || writeScanTimeout(w, ctx, err) {
+			return
+		}
+		if err != nil && scanResult == "" {
+			log.Printf("❌ Scan FAILED for %s: %v", reader.Identifier(), err)
+			http.Error(w, fmt.Sprintf("Scan failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("✓ Scan COMPLETED successfully for %s", reader.Identifier())
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"scanResult": scanResult,
+			"path":       reader.file.PathDisplay,
+			"id":         reader.file.ID,
+			"rev":        reader.file.Rev,
+			"result":     normalizeResult(scanResult, time.Since(scanStart), wantsRawResult(r)),
+		})
+	}
+}
+
+// DropboxFolderScanRequest is the body accepted by /dropbox/scan-folder
+type DropboxFolderScanRequest struct {
+	DropboxCredentials
+	// Path is the folder to sweep; empty or / is the whole Dropbox
+	Path      string   `json:"path"`
+	Recursive bool     `json:"recursive"`
+	Tags      []string `json:"tags"`
+	Workers   int      `json:"workers"`
+	// CallbackURL receives the final job state when the scan finishes
+	CallbackURL string `json:"callbackUrl"`
+}
+
+// folder returns the folder path in the form list_folder expects
+func (req DropboxFolderScanRequest) folder() string {
+	if req.Path == "/" {
+		return ""
+	}
+	return req.Path
+}
+
+// HTTP handler that enqueues a scan of every file in a Dropbox folder
+func handleScanDropboxFolder(scannerClient *amaasclient.AmaasClient, jobs *JobManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Printf("=== DROPBOX FOLDER SCAN REQUEST at %s ===", time.Now().Format(time.RFC3339))
+
+		var req DropboxFolderScanRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "callbackUrl")
+			return
+		}
+
+		job, err := jobs.Submit(r.Context(), jobTypeDropboxFolderScan, dropboxURI(req.folder()), req.CallbackURL, req)
+		if err != nil {
+			log.Printf("ERROR: Failed to queue Dropbox folder scan: %v", err)
+			writeJSONError(w, http.StatusServiceUnavailable, fmt.Sprintf("Failed to queue Dropbox folder scan: %v", err), "")
+			return
+		}
+		log.Printf("Queued Dropbox folder scan job %s for %q", job.ID(), req.Path)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(JobAcceptedResponse{
+			JobID:  job.ID(),
+			Status: JobQueued,
+		})
+	}
+}
+
+// dropboxFolderScanRunner returns the job runner for Dropbox folder sweeps
+func dropboxFolderScanRunner(scannerClient *amaasclient.AmaasClient) JobRunner {
+	return func(ctx context.Context, job *Job) error {
+		var req DropboxFolderScanRequest
+		if err := json.Unmarshal(job.Params(), &req); err != nil {
+			return fmt.Errorf("invalid Dropbox folder scan parameters: %v", err)
+		}
+		return runDropboxFolderScan(ctx, scannerClient, job, req)
+	}
+}
+
+// runDropboxFolderScan lists the folder page by page and scans every file
+// with a worker pool. The list_folder cursor is checkpointed after each
+// page, so a paused or restarted job continues listing from it.
+func runDropboxFolderScan(ctx context.Context, scannerClient *amaasclient.AmaasClient, job *Job, req DropboxFolderScanRequest) error {
+	ctx = withBackgroundScan(ctx)
+	client, err := newDropboxClient(ctx, req.DropboxCredentials)
+	if err != nil {
+		return err
+	}
+
+	// Scans in flight when the job is paused or canceled run to completion
+	scanCtx := context.WithoutCancel(ctx)
+	workers := getBucketScanWorkers(req.Workers)
+	cursor := job.Cursor()
+	log.Printf("Job %s: scanning Dropbox folder %q with %d workers (resuming: %v)", job.ID(), req.Path, workers, cursor != "")
+
+	job.Start(job.Snapshot(false).Scanned)
+	done := job.CompletedKeys()
+
+	for {
+		var page struct {
+			Entries []dropboxFile `json:"entries"`
+			Cursor  string        `json:"cursor"`
+			HasMore bool          `json:"has_more"`
+		}
+		if cursor == "" {
+			err = client.rpc(ctx, "/files/list_folder", map[string]interface{}{"path": req.folder(), "recursive": req.Recursive, "limit": 2000}, &page)
+		} else {
+			err = client.rpc(ctx, "/files/list_folder/continue", map[string]string{"cursor": cursor}, &page)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list Dropbox folder %q: %v", req.Path, err)
+		}
+
+		// Skip folders, Paper documents and files already scanned
+		files := make([]dropboxFile, 0, len(page.Entries))
+		for _, entry := range page.Entries {
+			if entry.downloadable() && !done[dropboxURI(entry.PathDisplay)] {
+				files = append(files, entry)
+			}
+		}
+		job.AddTotal(len(files))
+
+		queue := make(chan dropboxFile)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for file := range queue {
+					job.Record(scanDropboxFolderFile(scanCtx, scannerClient, client, file, req.Tags, job.ID()))
+				}
+			}()
+		}
+
+		// Stop dispatching once the job is paused or canceled
+		stopped := false
+		for _, file := range files {
+			select {
+			case queue <- file:
+				continue
+			case <-ctx.Done():
+				stopped = true
+			}
+			break
+		}
+		close(queue)
+		wg.Wait()
+		if stopped {
+			return ctx.Err()
+		}
+
+		// Every file of the page is scanned, continue after it from now on
+		cursor = page.Cursor
+		job.Checkpoint(cursor)
+		if !page.HasMore {
+			log.Printf("Job %s: finished scanning Dropbox folder %q", job.ID(), req.Path)
+			return nil
+		}
+	}
+}
+
+// scanDropboxFolderFile scans one listed file for a folder scan job
+func scanDropboxFolderFile(ctx context.Context, scannerClient *amaasclient.AmaasClient, client *dropboxClient, file dropboxFile, extraTags []string, jobID string) JobObjectResult {
+	result := JobObjectResult{Key: dropboxURI(file.PathDisplay)}
+	reader := &DropboxClientReader{ctx: ctx, client: client, file: file, size: file.Size}
+
+	scanStart := time.Now()
+	_, verdict, err := scanDropboxFile(ctx, scannerClient, reader, extraTags)
+	if err != nil {
+		log.Printf("Job %s: scan FAILED for %s: %v", jobID, result.Key, err)
+		result.Verdict = "error"
+		result.Error = err.Error()
+		return result
+	}
+	result.Verdict = verdict.Label()
+	result.MalwareNames = verdict.MalwareNames
+	normalized := verdict.Normalize(time.Since(scanStart), false)
+	result.Result = &normalized
+	log.Printf("Job %s: %s is %s", jobID, result.Key, result.Verdict)
+	return result
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultElasticsearchIndex      = "finguard-scans-%{+yyyy.MM.dd}"
+	defaultElasticsearchBatchSize  = 500
+	defaultElasticsearchMaxRetries = 3
+)
+
+// elasticsearchDateTokens maps the date pattern tokens of index names to Go layouts
+var elasticsearchDateTokens = strings.NewReplacer("yyyy", "2006", "MM", "01", "dd", "02", "HH", "15")
+
+// elasticsearchDocument is the indexed form of a scan verdict
+type elasticsearchDocument struct {
+	Timestamp       string   `json:"@timestamp"`
+	MalwareFamilies []string `json:"malwareFamilies"`
+	ScanCompletedEvent
+}
+
+// elasticsearchBulkItem pairs a document with the index it goes to
+type elasticsearchBulkItem struct {
+	index string
+	id    string
+	doc   []byte
+}
+
+// elasticsearchSink indexes every scan verdict into Elasticsearch or
+// OpenSearch with the bulk API. The index name may contain a date pattern
+// such as %{+yyyy.MM.dd}, filled from the scan's completion time in UTC.
+type elasticsearchSink struct {
+	bulkURL    string
+	index      string
+	auth       string
+	maxRetries int
+	client     *http.Client
+	batcher    *eventBatcher
+}
+
+// newElasticsearchSink creates a sink for the cluster at url (ELASTICSEARCH_URL)
+func newElasticsearchSink(url string) (*elasticsearchSink, error) {
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return nil, fmt.Errorf("invalid ELASTICSEARCH_URL %q", url)
+	}
+	interval, err := time.ParseDuration(getEnv("ELASTICSEARCH_FLUSH_INTERVAL", "5s"))
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid ELASTICSEARCH_FLUSH_INTERVAL %q", getEnv("ELASTICSEARCH_FLUSH_INTERVAL", ""))
+	}
+	batchSize := getEnvInt("ELASTICSEARCH_BATCH_SIZE", defaultElasticsearchBatchSize)
+	if batchSize <= 0 {
+		batchSize = defaultElasticsearchBatchSize
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if getEnv("ELASTICSEARCH_INSECURE_SKIP_VERIFY", "false") == "true" {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	s := &elasticsearchSink{
+		bulkURL:    strings.TrimRight(url, "/") + "/_bulk",
+		index:      getEnv("ELASTICSEARCH_INDEX", defaultElasticsearchIndex),
+		maxRetries: getEnvInt("ELASTICSEARCH_MAX_RETRIES", defaultElasticsearchMaxRetries),
+		client:     &http.Client{Timeout: notifyTimeout, Transport: transport},
+	}
+	switch {
+	case getEnv("ELASTICSEARCH_API_KEY", "") != "":
+		s.auth = "ApiKey " + getEnv("ELASTICSEARCH_API_KEY", "")
+	case getEnv("ELASTICSEARCH_USERNAME", "") != "":
+		req, _ := http.NewRequest(http.MethodPost, s.bulkURL, nil)
+		req.SetBasicAuth(getEnv("ELASTICSEARCH_USERNAME", ""), getEnv("ELASTICSEARCH_PASSWORD", ""))
+		s.auth = req.Header.Get("Authorization")
+	}
+	s.batcher = newEventBatcher(batchSize, interval, s.send)
+	return s, nil
+}
+
+func (s *elasticsearchSink) Name() string {
+	return "elasticsearch:" + strings.TrimSuffix(s.bulkURL, "/_bulk") + "/" + s.index
+}
+
+// indexName fills the date pattern of the index name
+func (s *elasticsearchSink) indexName(at time.Time) string {
+	start := strings.Index(s.index, "%{+")
+	if start < 0 {
+		return s.index
+	}
+	end := strings.Index(s.index[start:], "}")
+	if end < 0 {
+		return s.index
+	}
+	pattern := s.index[start+3 : start+end]
+	return s.index[:start] + at.UTC().Format(elasticsearchDateTokens.Replace(pattern)) + s.index[start+end+1:]
+}
+
+// Emit queues the verdict for the next bulk request. Scans with a scan ID use
+// it as the document ID, so a retried bulk request does not index duplicates.
+func (s *elasticsearchSink) Emit(ctx context.Context, event ScanCompletedEvent) error {
+	at, err := time.Parse(time.RFC3339, event.CompletedAt)
+	if err != nil {
+		at = time.Now()
+	}
+	if event.MalwareNames == nil {
+		event.MalwareNames = []string{}
+	}
+	doc, err := json.Marshal(elasticsearchDocument{
+		Timestamp:          at.UTC().Format(time.RFC3339),
+		MalwareFamilies:    malwareFamilies(event.MalwareNames),
+		ScanCompletedEvent: event,
+	})
+	if err != nil {
+		return err
+	}
+	return s.batcher.add(elasticsearchBulkItem{index: s.indexName(at), id: event.ScanID, doc: doc})
+}
+
+// send indexes a batch. Requests failing with a network error, 429 or 5xx
+// are retried with backoff, as are the documents the cluster rejected with 429.
+func (s *elasticsearchSink) send(batch []interface{}) {
+	items := make([]elasticsearchBulkItem, len(batch))
+	for i, item := range batch {
+		items[i] = item.(elasticsearchBulkItem)
+	}
+
+	err := retryWithBackoff(s.maxRetries, func() (bool, error) {
+		rejected, retry, err := s.bulk(items)
+		if err == nil && len(rejected) > 0 {
+			items = rejected
+			return true, fmt.Errorf("%d documents rejected with 429", len(rejected))
+		}
+		if err != nil && retry {
+			log.Printf("Elasticsearch: bulk request failed: %v", err)
+		}
+		return retry, err
+	})
+	if err != nil {
+		log.Printf("Elasticsearch: dropping %d documents: %v", len(items), err)
+	}
+}
+
+// bulk sends one bulk request and returns the items to retry
+func (s *elasticsearchSink) bulk(items []elasticsearchBulkItem) ([]elasticsearchBulkItem, bool, error) {
+	var body bytes.Buffer
+	for _, item := range items {
+		action := map[string]map[string]string{"index": {"_index": item.index}}
+		if item.id != "" {
+			action["index"]["_id"] = item.id
+		}
+		line, _ := json.Marshal(action)
+		body.Write(line)
+		body.WriteByte('\n')
+		body.Write(item.doc)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.bulkURL, &body)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.auth != "" {
+		req.Header.Set("Authorization", s.auth)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("bulk API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+		return nil, resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, false, fmt.Errorf("invalid bulk response: %v", err)
+	}
+	if !result.Errors {
+		return nil, false, nil
+	}
+	var rejected []elasticsearchBulkItem
+	failed := 0
+	for i, item := range result.Items {
+		if i >= len(items) {
+			break
+		}
+		for _, status := range item {
+			switch {
+			case status.Status == http.StatusTooManyRequests:
+				rejected = append(rejected, items[i])
+			case status.Status >= 300:
+				if failed == 0 {
+					log.Printf("Elasticsearch: failed to index into %s: %s", items[i].index, status.Error)
+				}
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		log.Printf("Elasticsearch: %d of %d documents were not indexed", failed, len(items))
+	}
+	return rejected, false, nil
+}
+
+// malwareFamilies strips the variant suffix from detection names, so that
+// TROJ_FAKEAV.SMA and TROJ_FAKEAV.SMB both count as TROJ_FAKEAV
+func malwareFamilies(names []string) []string {
+	families := []string{}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		family := name
+		if i := strings.LastIndex(name, "."); i > 0 {
+			family = name[:i]
+		}
+		if !seen[family] {
+			seen[family] = true
+			families = append(families, family)
+		}
+	}
+	return families
+}
+
+// Close indexes the queued documents and stops the batcher
+func (s *elasticsearchSink) Close() error {
+	return s.batcher.Close()
+}
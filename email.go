@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	defaultDigestSchedule = "0 8 * * *"
+	// digestMaxDetections bounds the detections listed in a digest
+	digestMaxDetections = 100
+)
+
+// smtpMailer sends plain-text mail through the SMTP server configured in
+// SMTP_HOST, SMTP_PORT, SMTP_TLS (starttls, tls or none), SMTP_USERNAME,
+// SMTP_PASSWORD and SMTP_FROM
+type smtpMailer struct {
+	addr     string
+	host     string
+	security string
+	username string
+	password string
+	from     string
+}
+
+// newSMTPMailer reads the SMTP settings for host
+func newSMTPMailer(host string) (*smtpMailer, error) {
+	m := &smtpMailer{
+		host:     host,
+		security: strings.ToLower(getEnv("SMTP_TLS", "starttls")),
+		username: getEnv("SMTP_USERNAME", ""),
+		password: getEnv("SMTP_PASSWORD", ""),
+		from:     getEnv("SMTP_FROM", ""),
+	}
+	port := getEnv("SMTP_PORT", "587")
+	if m.security == "tls" && os.Getenv("SMTP_PORT") == "" {
+		port = "465"
+	}
+	m.addr = net.JoinHostPort(host, port)
+	switch m.security {
+	case "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("invalid SMTP_TLS %q, expected starttls, tls or none", m.security)
+	}
+	if m.from == "" {
+		return nil, fmt.Errorf("SMTP_FROM is required with SMTP_HOST")
+	}
+	return m, nil
+}
+
+// send delivers one message to the recipients
+func (m *smtpMailer) send(ctx context.Context, to []string, subject, body string) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(notifyTimeout)
+	}
+	dialer := &net.Dialer{Deadline: deadline}
+	var conn net.Conn
+	var err error
+	if m.security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", m.addr, &tls.Config{ServerName: m.host, MinVersion: tls.VersionTLS12})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", m.addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(deadline)
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if m.security == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not support STARTTLS (set SMTP_TLS=none to send in clear text)", m.addr)
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: m.host, MinVersion: tls.VersionTLS12}); err != nil {
+			return err
+		}
+	}
+	if m.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(m.from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("recipient %s rejected: %v", rcpt, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m.message(to, subject, body)); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// message formats the headers and body of a UTF-8 plain-text message
+func (m *smtpMailer) message(to []string, subject, body string) []byte {
+	domain := m.from[strings.LastIndex(m.from, "@")+1:]
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", newRequestID(), domain)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// scanDigestStats accumulates the scans for the next digest email. It lives
+// outside the notifier so a reload does not reset the period.
+type scanDigestStats struct {
+	mu         sync.Mutex
+	since      time.Time
+	total      int
+	malicious  int
+	bySource   map[string][2]int // source -> scans, detections
+	detections []ScanCompletedEvent
+	dropped    int
+}
+
+var scanDigest = &scanDigestStats{since: time.Now(), bySource: map[string][2]int{}}
+
+// record counts a completed scan
+func (d *scanDigestStats) record(event ScanCompletedEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.total++
+	counts := d.bySource[event.Source]
+	counts[0]++
+	if event.Verdict == "malicious" {
+		d.malicious++
+		counts[1]++
+		if len(d.detections) < digestMaxDetections {
+			d.detections = append(d.detections, event)
+		} else {
+			d.dropped++
+		}
+	}
+	d.bySource[event.Source] = counts
+}
+
+// take returns the digest text for the period so far and starts a new one
+func (d *scanDigestStats) take() (subject, body string) {
+	d.mu.Lock()
+	since, total, malicious, bySource, detections, dropped := d.since, d.total, d.malicious, d.bySource, d.detections, d.dropped
+	d.since, d.total, d.malicious, d.bySource, d.detections, d.dropped = time.Now(), 0, 0, map[string][2]int{}, nil, 0
+	d.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "FinGuard scan digest\n%s to %s\n\n", since.Format(time.RFC1123), time.Now().Format(time.RFC1123))
+	fmt.Fprintf(&b, "Scans:      %d\nClean:      %d\nDetections: %d\n", total, total-malicious, malicious)
+	if len(bySource) > 0 {
+		sources := make([]string, 0, len(bySource))
+		for source := range bySource {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+		b.WriteString("\nBy source:\n")
+		for _, source := range sources {
+			fmt.Fprintf(&b, "  %-12s %d scans, %d detections\n", source, bySource[source][0], bySource[source][1])
+		}
+	}
+	if len(detections) > 0 {
+		b.WriteString("\nDetections:\n")
+		for _, event := range detections {
+			fmt.Fprintf(&b, "  %s  %s  %s", event.CompletedAt, event.Identifier, strings.Join(event.MalwareNames, ", "))
+			if event.ScanID != "" {
+				fmt.Fprintf(&b, "  (scan %s)", event.ScanID)
+			}
+			b.WriteByte('\n')
+		}
+		if dropped > 0 {
+			fmt.Fprintf(&b, "  ... and %d more\n", dropped)
+		}
+	}
+	return fmt.Sprintf("FinGuard digest: %d scans, %d detections", total, malicious), b.String()
+}
+
+// emailNotifier sends an alert email for every detection to EMAIL_ALERT_TO
+// and a digest of scan volume and detections to EMAIL_DIGEST_TO on the
+// EMAIL_DIGEST_SCHEDULE cron schedule
+type emailNotifier struct {
+	mailer      *smtpMailer
+	alertTo     []string
+	minSeverity string
+	resultURL   string
+	digestTo    []string
+	cron        *cron.Cron
+	closeOnce   sync.Once
+}
+
+// newEmailNotifier creates a notifier sending through the SMTP server host
+// (SMTP_HOST)
+func newEmailNotifier(host string) (*emailNotifier, error) {
+	mailer, err := newSMTPMailer(host)
+	if err != nil {
+		return nil, err
+	}
+	n := &emailNotifier{
+		mailer:      mailer,
+		alertTo:     envList("EMAIL_ALERT_TO"),
+		minSeverity: strings.ToLower(getEnv("EMAIL_ALERT_MIN_SEVERITY", severityLow)),
+		resultURL:   getEnv("EMAIL_RESULT_URL", ""),
+		digestTo:    envList("EMAIL_DIGEST_TO"),
+	}
+	if len(n.alertTo) == 0 && len(n.digestTo) == 0 {
+		return nil, fmt.Errorf("set EMAIL_ALERT_TO or EMAIL_DIGEST_TO to send email")
+	}
+	if _, ok := severityRank[n.minSeverity]; !ok {
+		return nil, fmt.Errorf("invalid EMAIL_ALERT_MIN_SEVERITY %q, expected critical, high or low", n.minSeverity)
+	}
+	if len(n.digestTo) > 0 {
+		spec := getEnv("EMAIL_DIGEST_SCHEDULE", defaultDigestSchedule)
+		schedule, err := cron.ParseStandard(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EMAIL_DIGEST_SCHEDULE %q: %v", spec, err)
+		}
+		n.cron = cron.New()
+		n.cron.Schedule(schedule, cron.FuncJob(n.sendDigest))
+		n.cron.Start()
+	}
+	return n, nil
+}
+
+func (n *emailNotifier) Name() string {
+	var parts []string
+	if len(n.alertTo) > 0 {
+		parts = append(parts, "alerts to "+strings.Join(n.alertTo, ", "))
+	}
+	if len(n.digestTo) > 0 {
+		parts = append(parts, "digests to "+strings.Join(n.digestTo, ", "))
+	}
+	return "email:" + n.mailer.addr + " (" + strings.Join(parts, "; ") + ")"
+}
+
+// Notify emails an alert for the detection
+func (n *emailNotifier) Notify(ctx context.Context, event DetectionEvent) error {
+	if len(n.alertTo) == 0 || severityRank[event.Severity] < severityRank[n.minSeverity] {
+		return nil
+	}
+	malware := strings.Join(event.MalwareNames, ", ")
+	if malware == "" {
+		malware = "unknown"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "FinGuard detected malware.\n\n")
+	fmt.Fprintf(&b, "Object:   %s\nMalware:  %s\nSeverity: %s\nSource:   %s\n", event.Identifier, malware, event.Severity, event.Source)
+	if event.ScanID != "" {
+		fmt.Fprintf(&b, "Scan ID:  %s\n", event.ScanID)
+	}
+	fmt.Fprintf(&b, "Detected: %s\n", event.DetectedAt)
+	if len(event.Tags) > 0 {
+		fmt.Fprintf(&b, "Tags:     %s\n", strings.Join(event.Tags, ", "))
+	}
+	if link := detectionLink(n.resultURL, event); link != "" {
+		fmt.Fprintf(&b, "\nResult: %s\n", link)
+	}
+	if event.Source == sourceS3 {
+		if link := quarantineLink(event.Bucket, event.Key); link != "" {
+			fmt.Fprintf(&b, "Quarantine: %s\n", link)
+		}
+	}
+	subject := fmt.Sprintf("[FinGuard] Malware detected in %s (%s)", event.Identifier, event.Severity)
+	return n.mailer.send(ctx, n.alertTo, truncateText(subject, 200), b.String())
+}
+
+// Emit counts the scan for the next digest
+func (n *emailNotifier) Emit(ctx context.Context, event ScanCompletedEvent) error {
+	if len(n.digestTo) > 0 {
+		scanDigest.record(event)
+	}
+	return nil
+}
+
+// sendDigest emails the digest for the period since the last one
+func (n *emailNotifier) sendDigest() {
+	subject, body := scanDigest.take()
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if err := n.mailer.send(ctx, n.digestTo, subject, body); err != nil {
+		log.Printf("Email: failed to send digest to %s: %v", strings.Join(n.digestTo, ", "), err)
+		return
+	}
+	log.Printf("Email: sent digest to %s", strings.Join(n.digestTo, ", "))
+}
+
+// Close stops the digest schedule. It is registered as both a notifier and a
+// sink, so it may be called twice on reload.
+func (n *emailNotifier) Close() error {
+	n.closeOnce.Do(func() {
+		if n.cron != nil {
+			<-n.cron.Stop().Done()
+		}
+	})
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// eventBatchQueueSize bounds the events a batching sink holds in memory
+const eventBatchQueueSize = 10000
+
+// eventBatcher queues events for a sink and hands them to send in batches of
+// up to size, or every interval when fewer are waiting. Batches are sent one
+// at a time by a background goroutine, so producers never wait on the sink.
+type eventBatcher struct {
+	size     int
+	interval time.Duration
+	send     func(batch []interface{})
+
+	mu     sync.RWMutex
+	closed bool
+	events chan interface{}
+	done   chan struct{}
+}
+
+// newEventBatcher starts the batching goroutine
+func newEventBatcher(size int, interval time.Duration, send func(batch []interface{})) *eventBatcher {
+	b := &eventBatcher{
+		size:     size,
+		interval: interval,
+		send:     send,
+		events:   make(chan interface{}, eventBatchQueueSize),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// add queues an event, dropping it when the sink cannot keep up
+func (b *eventBatcher) add(event interface{}) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return fmt.Errorf("sink closed by a reload, event dropped")
+	}
+	select {
+	case b.events <- event:
+		return nil
+	default:
+		return fmt.Errorf("queue full, event dropped")
+	}
+}
+
+// run collects batches until Close
+func (b *eventBatcher) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	var batch []interface{}
+	flush := func() {
+		if len(batch) > 0 {
+			b.send(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case event, ok := <-b.events:
+			if !ok {
+				flush()
+				return
+			}
+			if batch = append(batch, event); len(batch) >= b.size {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Close sends the queued events and stops the batcher
+func (b *eventBatcher) Close() error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.events)
+	}
+	b.mu.Unlock()
+	<-b.done
+	return nil
+}
+
+// retryWithBackoff calls attempt up to retries+1 times, doubling the wait from
+// one second between tries, while it reports a failure worth retrying
+func retryWithBackoff(retries int, attempt func() (retry bool, err error)) error {
+	backoff := time.Second
+	var err error
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var retry bool
+		if retry, err = attempt(); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const eventDetailTypeScanCompleted = "finguard.scan.completed"
+
+// eventBridgeSink sends scan.completed events to an EventBridge bus. The
+// PutEvents call is signed directly with SigV4 against the JSON API.
+type eventBridgeSink struct {
+	cfg      aws.Config
+	busName  string
+	source   string
+	endpoint string
+	signer   *v4.Signer
+	client   *http.Client
+}
+
+// newEventBridgeSink creates a sink for busName using the default credential chain
+func newEventBridgeSink(ctx context.Context, busName string) (*eventBridgeSink, error) {
+	cfg, err := loadAWSConfig(ctx, S3Options{}, getEnv("EVENTBRIDGE_REGION", ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config for EventBridge: %v", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("no region configured for EventBridge (set EVENTBRIDGE_REGION)")
+	}
+	return &eventBridgeSink{
+		cfg:      cfg,
+		busName:  busName,
+		source:   getEnv("EVENTBRIDGE_SOURCE", "finguard"),
+		endpoint: fmt.Sprintf("https://events.%s.amazonaws.com/", cfg.Region),
+		signer:   v4.NewSigner(),
+		client:   &http.Client{Timeout: notifyTimeout},
+	}, nil
+}
+
+func (s *eventBridgeSink) Name() string {
+	return "eventbridge:" + s.busName
+}
+
+// Emit puts a single finguard.scan.completed event on the bus
+func (s *eventBridgeSink) Emit(ctx context.Context, event ScanCompletedEvent) error {
+	detail, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"Entries": []map[string]string{{
+			"EventBusName": s.busName,
+			"Source":       s.source,
+			"DetailType":   eventDetailTypeScanCompleted,
+			"Detail":       string(detail),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AWSEvents.PutEvents")
+
+	creds, err := s.cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve AWS credentials: %v", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := s.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "events", s.cfg.Region, time.Now()); err != nil {
+		return fmt.Errorf("failed to sign request: %v", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("PutEvents returned %d: %s", resp.StatusCode, respBody)
+	}
+
+	var result struct {
+		FailedEntryCount int `json:"FailedEntryCount"`
+		Entries          []struct {
+			ErrorCode    string `json:"ErrorCode"`
+			ErrorMessage string `json:"ErrorMessage"`
+		} `json:"Entries"`
+	}
+	if err := json.Unmarshal(respBody, &result); err == nil && result.FailedEntryCount > 0 && len(result.Entries) > 0 {
+		return fmt.Errorf("PutEvents rejected event: %s %s", result.Entries[0].ErrorCode, result.Entries[0].ErrorMessage)
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// Backend selection strategies for SCANNER_SELECTION
+const (
+	selectPriority = "priority" // first healthy backend in configuration order
+	selectLatency  = "latency"  // healthy backend with the lowest recent latency
+)
+
+const defaultBackendHealthInterval = 30 * time.Second
+
+// scannerBackend is one scanner endpoint of the pool
+type scannerBackend struct {
+	name   string
+	client *amaasclient.AmaasClient
+
+	mu        sync.Mutex
+	healthy   bool
+	latency   time.Duration // moving average of successful calls
+	lastError string
+}
+
+// ScannerPool spreads scans over several scanner backends: the primary from
+// FSS_REGION or SCANNER_EXTERNAL_ADDR plus those in SCANNER_FAILOVER_BACKENDS.
+// A backend that fails with a transient error is skipped until a health
+// check or a later scan shows it answering again, so retries land on the
+// next backend.
+type ScannerPool struct {
+	backends []*scannerBackend
+	strategy string
+	interval time.Duration
+}
+
+// scannerPool is nil when only one backend is configured
+var scannerPool *ScannerPool
+
+// newScannerPoolFromEnv builds the pool from SCANNER_FAILOVER_BACKENDS, a
+// comma-separated list of region:<saas-region>, grpc://host:port or
+// grpcs://host:port entries. It returns nil when the list is empty.
+func newScannerPoolFromEnv(primary *amaasclient.AmaasClient, primaryName string) (*ScannerPool, error) {
+	spec := os.Getenv("SCANNER_FAILOVER_BACKENDS")
+	if spec == "" {
+		return nil, nil
+	}
+	strategy := getEnv("SCANNER_SELECTION", selectPriority)
+	if strategy != selectPriority && strategy != selectLatency {
+		return nil, fmt.Errorf("invalid SCANNER_SELECTION %q, expected priority or latency", strategy)
+	}
+	interval, err := time.ParseDuration(getEnv("SCANNER_HEALTH_INTERVAL", defaultBackendHealthInterval.String()))
+	if err != nil || interval <= 0 {
+		interval = defaultBackendHealthInterval
+	}
+
+	pool := &ScannerPool{
+		backends: []*scannerBackend{{name: primaryName, client: primary, healthy: true}},
+		strategy: strategy,
+		interval: interval,
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		client, err := newBackendClient(entry)
+		if err != nil {
+			return nil, fmt.Errorf("scanner backend %q: %v", entry, err)
+		}
+		pool.backends = append(pool.backends, &scannerBackend{name: entry, client: client, healthy: true})
+	}
+	log.Printf("- Scanner Backends: %d (selection: %s)", len(pool.backends), strategy)
+	return pool, nil
+}
+
+// newBackendClient creates the client for one SCANNER_FAILOVER_BACKENDS entry
+func newBackendClient(entry string) (*amaasclient.AmaasClient, error) {
+	switch {
+	case strings.HasPrefix(entry, "region:"):
+		apiKey := os.Getenv("FSS_API_KEY")
+		if apiKey == "" {
+			return nil, fmt.Errorf("FSS_API_KEY must be set for SaaS backends")
+		}
+		return amaasclient.NewClient(apiKey, strings.TrimPrefix(entry, "region:"))
+	case strings.HasPrefix(entry, "grpc://"):
+		return amaasclient.NewClientInternal("", strings.TrimPrefix(entry, "grpc://"), false, "")
+	case strings.HasPrefix(entry, "grpcs://"):
+		return amaasclient.NewClientInternal("", strings.TrimPrefix(entry, "grpcs://"), true, "")
+	}
+	return nil, fmt.Errorf("expected region:<region>, grpc://host:port or grpcs://host:port")
+}
+
+// pick returns the backend for the next call. When every backend is marked
+// down the primary is used, leaving the circuit breaker to fail fast.
+func (p *ScannerPool) pick() *scannerBackend {
+	var chosen *scannerBackend
+	best := time.Duration(math.MaxInt64)
+	for _, b := range p.backends {
+		b.mu.Lock()
+		healthy, latency := b.healthy, b.latency
+		b.mu.Unlock()
+		if !healthy {
+			continue
+		}
+		if p.strategy == selectPriority {
+			return b
+		}
+		if latency < best {
+			chosen, best = b, latency
+		}
+	}
+	if chosen == nil {
+		return p.backends[0]
+	}
+	return chosen
+}
+
+// record updates a backend with the outcome of a call
+func (b *scannerBackend) record(elapsed time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case err == nil:
+		if !b.healthy {
+			log.Printf("Scanner backend %s is available again", b.name)
+		}
+		b.healthy = true
+		b.lastError = ""
+		if b.latency == 0 {
+			b.latency = elapsed
+		} else {
+			b.latency = (b.latency*4 + elapsed) / 5
+		}
+	case isTransientScanError(err):
+		if b.healthy {
+			log.Printf("Scanner backend %s marked down: %v", b.name, err)
+		}
+		b.healthy = false
+		b.lastError = err.Error()
+	}
+}
+
+// scan runs call on the selected backend. client is the request's client for
+// the primary backend, which may carry per-request options; other backends
+// get the same options from ctx. Without a pool call runs on client.
+func (p *ScannerPool) scan(ctx context.Context, client *amaasclient.AmaasClient, call func(client *amaasclient.AmaasClient) (string, error)) (string, error) {
+	// Tenants with their own credentials scan on their dedicated client
+	if tenant := tenantFrom(ctx); tenant != nil && tenant.client != nil {
+		return call(clientWithOptions(tenant.client, scanOptionsFrom(ctx)))
+	}
+	if lease := acquireRotatedScanner(); lease != nil {
+		defer lease.release()
+		client = clientWithOptions(lease.client, scanOptionsFrom(ctx))
+	}
+	if p == nil {
+		return call(client)
+	}
+	backend := p.pick()
+	if backend != p.backends[0] {
+		client = clientWithOptions(backend.client, scanOptionsFrom(ctx))
+	}
+	start := time.Now()
+	scanResult, err := call(client)
+	backend.record(time.Since(start), err)
+	return scanResult, err
+}
+
+// Start probes every backend each SCANNER_HEALTH_INTERVAL so backends that
+// were marked down rejoin and latency stays current without traffic
+func (p *ScannerPool) Start() {
+	if p == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			p.probeAll()
+		}
+	}()
+}
+
+// probeAll scans a small buffer on every backend concurrently
+func (p *ScannerPool) probeAll() {
+	var wg sync.WaitGroup
+	for _, b := range p.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			client, release := b.client, func() {}
+			if b == p.backends[0] {
+				client, release = primaryClient(client)
+			}
+			defer release()
+			_, err := client.ScanBuffer([]byte("finguard-preflight"), "finguard-backend-probe", []string{"app=finguard", "preflight=true"})
+			b.record(time.Since(start), err)
+		}()
+	}
+	wg.Wait()
+}
+
+// check probes every backend for /health/deep; it passes when any backend answers
+func (p *ScannerPool) check() PreflightCheck {
+	p.probeAll()
+	check := PreflightCheck{Name: "scanner", Status: "failed"}
+	details := make([]string, 0, len(p.backends))
+	for _, b := range p.backends {
+		b.mu.Lock()
+		if b.healthy {
+			check.Status = "ok"
+			details = append(details, fmt.Sprintf("%s up (%s)", b.name, b.latency.Round(time.Millisecond)))
+		} else {
+			details = append(details, fmt.Sprintf("%s down (%s)", b.name, b.lastError))
+		}
+		b.mu.Unlock()
+	}
+	check.Detail = strings.Join(details, "; ")
+	return check
+}
+
+// probe reports whether any backend answers, for the circuit breaker
+func (p *ScannerPool) probe() error {
+	if check := p.check(); check.Status != "ok" {
+		return fmt.Errorf("no scanner backend reachable: %s", check.Detail)
+	}
+	return nil
+}
+
+type scanOptionsKey struct{}
+
+// withScanOptions records the request's scan options so failover backends
+// apply them too
+func withScanOptions(ctx context.Context, opts ScanOptions) context.Context {
+	return context.WithValue(ctx, scanOptionsKey{}, opts)
+}
+
+// scanOptionsFrom returns the options recorded by withScanOptions
+func scanOptionsFrom(ctx context.Context) ScanOptions {
+	opts, _ := ctx.Value(scanOptionsKey{}).(ScanOptions)
+	return opts
+}
+
+// scanBuffer scans data on the selected backend
+func scanBuffer(ctx context.Context, scannerClient *amaasclient.AmaasClient, data []byte, identifier string, tags []string) (string, error) {
+	detected := detectedTypeFrom(ctx)
+	if detected == "" {
+		detected = detectContentType(data)
+	}
+	ctx = withDetectedType(ctx, detected)
+	ctx, scannerClient = funnelScanPolicy(ctx, scannerClient, ScanTarget{Key: identifier, DetectedType: detected, Size: int64(len(data))})
+	if result, ok := scanListed(ctx, identifier, func() string { return sha256Hex(data) }); ok {
+		return result, nil
+	}
+	if limit := scanTruncationFrom(ctx); limit > 0 && int64(len(data)) > limit {
+		data = data[:limit]
+	}
+	tags = detectedTypeTags(tenantFrom(ctx).scanTags(tags), detected)
+	return scanDetected(detected, func() (string, error) {
+		return scannerPool.scan(ctx, scannerClient, func(client *amaasclient.AmaasClient) (string, error) {
+			return client.ScanBufferWithContext(ctx, data, identifier, tags)
+		})
+	})
+}
+
+// scanFile scans a local file on the selected backend. Truncated scans read
+// the file through a FileReader.
+func scanFile(ctx context.Context, scannerClient *amaasclient.AmaasClient, path string, tags []string) (string, error) {
+	detected := detectedTypeFrom(ctx)
+	if detected == "" {
+		detected = sniffFile(path)
+	}
+	ctx = withDetectedType(ctx, detected)
+	target := ScanTarget{Key: path, DetectedType: detected, Size: -1}
+	if info, err := os.Stat(path); err == nil {
+		target.Size = info.Size()
+	}
+	ctx, scannerClient = funnelScanPolicy(ctx, scannerClient, target)
+	if result, ok := scanListed(ctx, path, func() string {
+		sum, _ := sha256File(path)
+		return sum
+	}); ok {
+		return result, nil
+	}
+	if scanTruncationFrom(ctx) > 0 {
+		reader, err := NewFileReader(path, path)
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		return scanReader(ctx, scannerClient, reader, tags)
+	}
+	tags = detectedTypeTags(tenantFrom(ctx).scanTags(tags), detected)
+	return scanDetected(detected, func() (string, error) {
+		return scannerPool.scan(ctx, scannerClient, func(client *amaasclient.AmaasClient) (string, error) {
+			return client.ScanFileWithContext(ctx, path, tags)
+		})
+	})
+}
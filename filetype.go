@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// sniffLen is how much of a file is read to detect its type
+const sniffLen = 512
+
+// fileSignatures are magic bytes net/http does not recognize, mostly
+// executables and archives, checked before http.DetectContentType
+var fileSignatures = []struct {
+	magic     []byte
+	mediaType string
+}{
+	{[]byte("MZ"), "application/vnd.microsoft.portable-executable"},
+	{[]byte("\x7fELF"), "application/x-elf"},
+	{[]byte("\xfe\xed\xfa\xce"), "application/x-mach-binary"},
+	{[]byte("\xfe\xed\xfa\xcf"), "application/x-mach-binary"},
+	{[]byte("\xce\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), "application/x-ole-storage"},
+	{[]byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed"},
+	{[]byte("BZh"), "application/x-bzip2"},
+	{[]byte("\xfd7zXZ\x00"), "application/x-xz"},
+	{[]byte("\x28\xb5\x2f\xfd"), "application/zstd"},
+	{[]byte("MSCF"), "application/vnd.ms-cab-compressed"},
+	{[]byte("{\\rtf"), "application/rtf"},
+	{[]byte("#!"), "text/x-shellscript"},
+}
+
+// zipContainers tell apart ZIP-based formats by the entry names in the
+// first local headers
+var zipContainers = []struct {
+	entry     []byte
+	mediaType string
+}{
+	{[]byte("word/"), "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	{[]byte("xl/"), "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{[]byte("ppt/"), "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+	{[]byte("META-INF/MANIFEST.MF"), "application/java-archive"},
+	{[]byte("AndroidManifest.xml"), "application/vnd.android.package-archive"},
+	{[]byte("mimetypeapplication/epub+zip"), "application/epub+zip"},
+}
+
+// detectContentType returns the media type of a file from its first bytes,
+// without parameters, or "" when head is empty
+func detectContentType(head []byte) string {
+	if len(head) == 0 {
+		return ""
+	}
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+	for _, signature := range fileSignatures {
+		if bytes.HasPrefix(head, signature.magic) {
+			return signature.mediaType
+		}
+	}
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	if mediaType == "application/zip" {
+		for _, container := range zipContainers {
+			if bytes.Contains(head, container.entry) {
+				return container.mediaType
+			}
+		}
+	}
+	return mediaType
+}
+
+// sniffReader detects the type of a reader's data from its first bytes. It
+// returns "" when they cannot be read.
+func sniffReader(reader amaasclient.AmaasClientReader) string {
+	size, err := reader.DataSize()
+	if err != nil || size <= 0 {
+		return ""
+	}
+	head, err := reader.ReadBytes(0, int32(min(size, sniffLen)))
+	if err != nil {
+		log.Printf("Warning: failed to read %s to detect its type: %v", reader.Identifier(), err)
+		return ""
+	}
+	return detectContentType(head)
+}
+
+// sniffFile detects the type of the file at path
+func sniffFile(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, sniffLen)
+	n, _ := io.ReadFull(f, head)
+	return detectContentType(head[:n])
+}
+
+// sniffBody detects the type of a request body from its first bytes, which
+// are kept so the body can still be read whole
+func sniffBody(r *http.Request) string {
+	if r.Body == nil || r.Body == http.NoBody {
+		return ""
+	}
+	body := bufio.NewReaderSize(r.Body, sniffLen)
+	head, _ := body.Peek(sniffLen)
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, r.Body}
+	return detectContentType(head)
+}
+
+type detectedTypeKey struct{}
+
+// withDetectedType records a type detected before scanning so the scan
+// functions do not read the file again
+func withDetectedType(ctx context.Context, mediaType string) context.Context {
+	if mediaType == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, detectedTypeKey{}, mediaType)
+}
+
+// detectedTypeFrom returns the type recorded by withDetectedType, or ""
+func detectedTypeFrom(ctx context.Context) string {
+	mediaType, _ := ctx.Value(detectedTypeKey{}).(string)
+	return mediaType
+}
+
+// detectedTypeTags adds the detected_type tag when the tag limit allows
+func detectedTypeTags(tags []string, mediaType string) []string {
+	if mediaType == "" || len(tags) >= maxScanTags {
+		return tags
+	}
+	tag := "detected_type=" + mediaType
+	if len(tag) > maxScanTagLength {
+		tag = tag[:maxScanTagLength]
+	}
+	return append(append([]string{}, tags...), tag)
+}
+
+// scanDetected runs scan and records mediaType as detectedType in its result
+func scanDetected(mediaType string, scan func() (string, error)) (string, error) {
+	result, err := scan()
+	if err != nil || mediaType == "" {
+		return result, err
+	}
+	return annotateResult(result, map[string]interface{}{"detectedType": mediaType}), nil
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+const gcsReadOnly = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// GCSCredentials holds an optional service-account JSON key. When it is empty the
+// default credential chain is used (workload identity, GOOGLE_APPLICATION_CREDENTIALS).
+type GCSCredentials struct {
+	ServiceAccountJSON Secret `json:"serviceAccountJson"`
+	ProjectID          string `json:"projectId"`
+}
+
+// gcsClient is a Cloud Storage client and the project buckets are listed in
+type gcsClient struct {
+	storage   *storage.Client
+	projectID string
+}
+
+// gcsServiceAccountConfig parses a service-account key from a request. Other
+// credential types, such as external_account, make the client read local
+// files and call URLs named in the JSON, so they are refused, and tokens are
+// always requested from Google's token endpoint whatever token_uri says.
+func gcsServiceAccountConfig(serviceAccountJSON []byte) (*jwt.Config, string, error) {
+	config, err := google.JWTConfigFromJSON(serviceAccountJSON, gcsReadOnly)
+	if err != nil {
+		return nil, "", err
+	}
+	config.TokenURL = google.JWTTokenURL
+	var key struct {
+		ProjectID string `json:"project_id"`
+	}
+	json.Unmarshal(serviceAccountJSON, &key)
+	return config, key.ProjectID, nil
+}
+
+// newGCSClient creates an authenticated GCS client
+func newGCSClient(ctx context.Context, creds GCSCredentials) (*gcsClient, error) {
+	var (
+		tokens    oauth2.TokenSource
+		projectID string
+	)
+	if creds.ServiceAccountJSON != "" {
+		log.Println("Using provided GCS service account credentials")
+		config, keyProject, err := gcsServiceAccountConfig([]byte(creds.ServiceAccountJSON))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load GCS credentials: %v", err)
+		}
+		tokens, projectID = config.TokenSource(ctx), keyProject
+	} else {
+		log.Println("Using default GCS credentials from environment")
+		gcreds, err := google.FindDefaultCredentials(ctx, gcsReadOnly)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load GCS credentials: %v", err)
+		}
+		tokens, projectID = gcreds.TokenSource, gcreds.ProjectID
+	}
+	if creds.ProjectID != "" {
+		projectID = creds.ProjectID
+	}
+
+	// The oauth2 client shares the default transport, so the clients made
+	// for each request do not need closing
+	client, err := storage.NewClient(ctx, option.WithHTTPClient(oauth2.NewClient(ctx, tokens)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %v", err)
+	}
+	return &gcsClient{storage: client, projectID: projectID}, nil
+}
+
+// GCSClientReader implements AmaasClientReader for GCS objects using ranged
+// reads of the generation seen when the reader was opened
+type GCSClientReader struct {
+	ctx    context.Context
+	handle *storage.ObjectHandle
+	bucket string
+	object string
+	size   int64
+}
+
+func NewGCSClientReader(ctx context.Context, creds GCSCredentials, bucket, object string) (*GCSClientReader, error) {
+	log.Printf("Creating GCS reader for gs://%s/%s", bucket, object)
+
+	client, err := newGCSClient(ctx, creds)
+	if err != nil {
+		log.Printf("Failed to create GCS client: %v", err)
+		return nil, err
+	}
+
+	handle := client.storage.Bucket(bucket).Object(object)
+	attrs, err := handle.Attrs(ctx)
+	if err != nil {
+		log.Printf("Failed to get object attributes: %v", err)
+		return nil, err
+	}
+
+	log.Printf("Object size: %d bytes", attrs.Size)
+	return &GCSClientReader{
+		ctx:    ctx,
+		handle: handle.Generation(attrs.Generation),
+		bucket: bucket,
+		object: object,
+		size:   attrs.Size,
+	}, nil
+}
+
+// scanTarget describes the object for the scan policy
+func (r *GCSClientReader) scanTarget() ScanTarget {
+	return ScanTarget{Bucket: r.bucket, Key: r.object, Size: r.size}
+}
+
+// Identifier returns the GCS object identifier
+func (r *GCSClientReader) Identifier() string {
+	return fmt.Sprintf("gs://%s/%s", r.bucket, r.object)
+}
+
+// DataSize returns the size of the GCS object
+func (r *GCSClientReader) DataSize() (int64, error) {
+	return r.size, nil
+}
+
+// bindContext runs the ranged reads of a scan under its context
+func (r *GCSClientReader) bindContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// ReadBytes reads bytes from the GCS object at the specified offset
+func (r *GCSClientReader) ReadBytes(offset int64, length int32) ([]byte, error) {
+	reader, err := r.handle.NewRangeReader(r.ctx, offset, int64(length))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	bytes, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading GCS object body: %v", err)
+	}
+	return bytes, nil
+}
+
+// newGCSReaderFromURI opens a GCS reader for bucket/object
+func newGCSReaderFromURI(ctx context.Context, location string, options map[string]string) (amaasclient.AmaasClientReader, error) {
+	bucket, object, ok := strings.Cut(location, "/")
+	if !ok || bucket == "" || object == "" {
+		return nil, fmt.Errorf("invalid GCS location %q, expected gs://bucket/object", location)
+	}
+	creds := GCSCredentials{
+		ServiceAccountJSON: Secret(options["serviceAccountJson"]),
+		ProjectID:          options["projectId"],
+	}
+	return NewGCSClientReader(ctx, creds, bucket, object)
+}
+
+// HTTP handler for listing GCS buckets
+func handleListGCSBuckets(scannerClient *amaasclient.AmaasClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Printf("--- LIST GCS BUCKETS REQUEST at %s ---", time.Now().Format(time.RFC3339))
+
+		var req GCSCredentials
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		client, err := newGCSClient(ctx, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if client.projectID == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing required field: projectId", "projectId")
+			return
+		}
+
+		buckets := make([]map[string]interface{}, 0)
+		it := client.storage.Buckets(ctx, client.projectID)
+		for {
+			bucket, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				log.Printf("ERROR: Failed to list GCS buckets: %v", err)
+				http.Error(w, fmt.Sprintf("Failed to list buckets: %v", err), http.StatusInternalServerError)
+				return
+			}
+			buckets = append(buckets, map[string]interface{}{
+				"name":         bucket.Name,
+				"creationDate": bucket.Created,
+			})
+		}
+		log.Printf("Successfully listed %d GCS buckets", len(buckets))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"buckets": buckets,
+		})
+	}
+}
+
+// HTTP handler for listing objects in a GCS bucket
+func handleListGCSObjects(scannerClient *amaasclient.AmaasClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Printf("--- LIST GCS OBJECTS REQUEST at %s ---", time.Now().Format(time.RFC3339))
+
+		var req struct {
+			GCSCredentials
+			Bucket    string `json:"bucket"`
+			Prefix    string `json:"prefix"`
+			Recursive bool   `json:"recursive"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Bucket == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing required field: bucket", "bucket")
+			return
+		}
+
+		ctx := r.Context()
+		client, err := newGCSClient(ctx, req.GCSCredentials)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		objects := make([]map[string]interface{}, 0)
+		it := client.storage.Bucket(req.Bucket).Objects(ctx, &storage.Query{Prefix: req.Prefix})
+		for {
+			item, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				log.Printf("Failed to list objects in %s: %v", req.Bucket, err)
+				http.Error(w, fmt.Sprintf("Failed to list objects: %v", err), http.StatusInternalServerError)
+				return
+			}
+			// If not recursive, skip objects that are in subdirectories
+			if !req.Recursive && strings.Contains(strings.TrimPrefix(item.Name, req.Prefix), "/") {
+				continue
+			}
+			objects = append(objects, map[string]interface{}{
+				"key":          item.Name,
+				"size":         item.Size,
+				"lastModified": item.Updated,
+			})
+		}
+		log.Printf("Successfully listed %d objects from gs://%s/%s", len(objects), req.Bucket, req.Prefix)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"bucket":  req.Bucket,
+			"objects": objects,
+		})
+	}
+}
+
+// HTTP handler for scanning GCS objects
+func handleScanGCSObject(scannerClient *amaasclient.AmaasClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Printf("=== GCS SCAN REQUEST at %s ===", time.Now().Format(time.RFC3339))
+
+		var req struct {
+			GCSCredentials
+			Bucket string   `json:"bucket"`
+			Key    string   `json:"key"`
+			Tags   []string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.Bucket == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing required field: bucket", "bucket")
+			return
+		}
+		if req.Key == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing required field: key", "key")
+			return
+		}
+
+		ctx := r.Context()
+		reader, err := NewGCSClientReader(ctx, req.GCSCredentials, req.Bucket, req.Key)
+		if err != nil {
+			log.Printf("ERROR: Failed to create GCS reader: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to create GCS reader: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		tags := buildScanTags(sourceGCS, getCustomTags(), append(req.Tags, "file_type="+path.Ext(req.Key))...)
+
+		log.Printf("=== Starting GCS Scan ===")
+		log.Printf("Object: %s", reader.Identifier())
+		log.Printf("Size: %d bytes", reader.size)
+
+		scanStart := time.Now()
+		scanResult, err := observeScan(ctx, sourceGCS, reader.size, func(ctx context.Context) (string, error) {
+			return scanReader(ctx, scannerClient, reader, tags)
+		})
+		if writeScanRejected(w, err) || writeScanTimeout(w, ctx, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("❌ Scan FAILED for %s: %v", reader.Identifier(), err)
+			http.Error(w, fmt.Sprintf("Scan failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("✓ Scan COMPLETED successfully for %s", reader.Identifier())
+		response := map[string]interface{}{
+			"scanResult": scanResult,
+			"bucket":     req.Bucket,
+			"key":        req.Key,
+		}
+		if verdict, err := parseScanVerdict(scanResult); err == nil {
+			reportScanOutcome(ctx, ScanOutcome{
+				Source:     sourceGCS,
+				Bucket:     req.Bucket,
+				Key:        req.Key,
+				Identifier: reader.Identifier(),
+				Tags:       tags,
+				Verdict:    verdict,
+				Duration:   time.Since(scanStart),
+				Size:       reader.size,
+			})
+			response["result"] = verdict.Normalize(time.Since(scanStart), wantsRawResult(r))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
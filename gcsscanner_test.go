@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2/google"
+)
+
+func TestGCSServiceAccountConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		json        string
+		wantErr     string
+		wantProject string
+	}{
+		{
+			name:        "service account",
+			json:        `{"type":"service_account","project_id":"scans","client_email":"scanner@scans.iam.gserviceaccount.com","private_key":"key","token_uri":"http://169.254.169.254/token"}`,
+			wantProject: "scans",
+		},
+		{
+			name:    "external account",
+			json:    `{"type":"external_account","audience":"aud","subject_token_type":"urn:ietf:params:oauth:token-type:jwt","token_url":"http://127.0.0.1/token","credential_source":{"file":"/etc/passwd"}}`,
+			wantErr: "external_account",
+		},
+		{
+			name:    "authorized user",
+			json:    `{"type":"authorized_user","client_id":"id","client_secret":"secret","refresh_token":"token"}`,
+			wantErr: "authorized_user",
+		},
+		{name: "not JSON", json: `key`, wantErr: "invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, project, err := gcsServiceAccountConfig([]byte(tt.json))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("gcsServiceAccountConfig: %v", err)
+			}
+			if config.TokenURL != google.JWTTokenURL {
+				t.Errorf("TokenURL = %s, want %s", config.TokenURL, google.JWTTokenURL)
+			}
+			if project != tt.wantProject {
+				t.Errorf("project = %q, want %q", project, tt.wantProject)
+			}
+		})
+	}
+}
@@ -0,0 +1,645 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+const (
+	graphAPIBase          = "https://graph.microsoft.com/v1.0"
+	graphScope            = "https://graph.microsoft.com/.default"
+	jobTypeGraphDeltaScan = "graph-delta-scan"
+)
+
+// GraphCredentials selects how Microsoft Graph is accessed: an access token
+// obtained by the caller, an app registration client secret, or the default
+// Azure credential chain (AZURE_TENANT_ID/AZURE_CLIENT_ID/AZURE_CLIENT_SECRET,
+// workload identity, managed identity). Apps need Files.Read.All or
+// Sites.Read.All.
+type GraphCredentials struct {
+	AccessToken  Secret `json:"accessToken"`
+	TenantID     string `json:"tenantId"`
+	ClientID     string `json:"clientId"`
+	ClientSecret Secret `json:"clientSecret"`
+}
+
+// GraphDriveTarget selects a document library: a drive by ID, the default
+// library of a SharePoint site, or a user's OneDrive
+type GraphDriveTarget struct {
+	DriveID string `json:"driveId"`
+	SiteID  string `json:"siteId"`
+	UserID  string `json:"userId"`
+}
+
+// drivePath returns the Graph path of the selected drive
+func (t GraphDriveTarget) drivePath() (string, error) {
+	switch {
+	case t.DriveID != "" && t.SiteID == "" && t.UserID == "":
+		return "/drives/" + graphPathEscape(t.DriveID), nil
+	case t.SiteID != "" && t.DriveID == "" && t.UserID == "":
+		return "/sites/" + graphPathEscape(t.SiteID) + "/drive", nil
+	case t.UserID != "" && t.DriveID == "" && t.SiteID == "":
+		return "/users/" + graphPathEscape(t.UserID) + "/drive", nil
+	}
+	return "", fmt.Errorf("exactly one of driveId, siteId or userId is required")
+}
+
+// graphPathEscape escapes an ID for a Graph URL path, keeping the "!" of
+// drive IDs and the "," of site IDs readable
+func graphPathEscape(id string) string {
+	return strings.NewReplacer("%21", "!", "%2C", ",").Replace(url.PathEscape(id))
+}
+
+// graphItem is the subset of the driveItem resource used here
+type graphItem struct {
+	ID                   string `json:"id"`
+	Name                 string `json:"name"`
+	Size                 int64  `json:"size"`
+	ETag                 string `json:"eTag"`
+	LastModifiedDateTime string `json:"lastModifiedDateTime"`
+	File                 *struct {
+		MimeType string `json:"mimeType"`
+	} `json:"file"`
+	Folder          *struct{} `json:"folder"`
+	Deleted         *struct{} `json:"deleted"`
+	ParentReference struct {
+		DriveID string `json:"driveId"`
+	} `json:"parentReference"`
+	DownloadURL string `json:"@microsoft.graph.downloadUrl"`
+}
+
+// graphItemURI returns the msgraph:// URI of a drive item
+func graphItemURI(driveID, itemID string) string {
+	return fmt.Sprintf("msgraph://drives/%s/items/%s", driveID, itemID)
+}
+
+// graphPage is one page of a children or delta listing
+type graphPage struct {
+	Value     []graphItem `json:"value"`
+	NextLink  string      `json:"@odata.nextLink"`
+	DeltaLink string      `json:"@odata.deltaLink"`
+}
+
+// graphAPIError is a non-success response from Microsoft Graph
+type graphAPIError struct {
+	status int
+	msg    string
+}
+
+func (e *graphAPIError) Error() string {
+	return fmt.Sprintf("Graph API returned %d %s: %s", e.status, http.StatusText(e.status), e.msg)
+}
+
+// staticGraphToken serves an access token provided by the caller
+type staticGraphToken string
+
+func (t staticGraphToken) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: string(t), ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// graphClient is a minimal client for the Graph drive APIs
+type graphClient struct {
+	cred azcore.TokenCredential
+}
+
+// newGraphClient creates an authenticated Graph client
+func newGraphClient(creds GraphCredentials) (*graphClient, error) {
+	switch {
+	case creds.AccessToken != "":
+		log.Println("Using provided Microsoft Graph access token")
+		return &graphClient{cred: staticGraphToken(creds.AccessToken)}, nil
+	case creds.ClientSecret != "":
+		log.Println("Using provided Microsoft Graph client secret credentials")
+		cred, err := azidentity.NewClientSecretCredential(creds.TenantID, creds.ClientID, string(creds.ClientSecret), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Graph credentials: %v", err)
+		}
+		return &graphClient{cred: cred}, nil
+	default:
+		log.Println("Using default Azure credentials for Microsoft Graph")
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Graph credentials: %v", err)
+		}
+		return &graphClient{cred: cred}, nil
+	}
+}
+
+// get sends an authenticated GET to a Graph URL and decodes the JSON response
+func (c *graphClient) get(ctx context.Context, endpoint string, out interface{}) error {
+	token, err := c.cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{graphScope}})
+	if err != nil {
+		return fmt.Errorf("failed to get Graph token: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &graphAPIError{status: resp.StatusCode, msg: strings.TrimSpace(string(msg))}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// getItem fetches the metadata of a drive item, including a fresh download URL
+func (c *graphClient) getItem(ctx context.Context, drivePath, itemID string) (graphItem, error) {
+	var item graphItem
+	err := c.get(ctx, graphAPIBase+drivePath+"/items/"+graphPathEscape(itemID), &item)
+	return item, err
+}
+
+// GraphClientReader implements AmaasClientReader for OneDrive and SharePoint
+// files using ranged reads of the pre-authenticated download URL
+type GraphClientReader struct {
+	ctx  context.Context
+	item graphItem
+	size int64
+}
+
+func NewGraphClientReader(ctx context.Context, creds GraphCredentials, drivePath, itemID string) (*GraphClientReader, error) {
+	log.Printf("Creating Microsoft Graph reader for item %s in %s", itemID, drivePath)
+
+	client, err := newGraphClient(creds)
+	if err != nil {
+		log.Printf("Failed to create Graph client: %v", err)
+		return nil, err
+	}
+	return newGraphReader(ctx, client, drivePath, itemID)
+}
+
+// newGraphReader opens a reader for a drive item with an existing client
+func newGraphReader(ctx context.Context, client *graphClient, drivePath, itemID string) (*GraphClientReader, error) {
+	item, err := client.getItem(ctx, drivePath, itemID)
+	if err != nil {
+		log.Printf("Failed to get Graph item metadata: %v", err)
+		return nil, err
+	}
+	if item.File == nil || item.DownloadURL == "" {
+		return nil, fmt.Errorf("%s is not a file; only files can be scanned", itemID)
+	}
+
+	log.Printf("File %s (%s) size: %d bytes", item.Name, itemID, item.Size)
+	return &GraphClientReader{
+		ctx:  ctx,
+		item: item,
+		size: item.Size,
+	}, nil
+}
+
+// Identifier returns the msgraph:// URI of the file
+func (r *GraphClientReader) Identifier() string {
+	return graphItemURI(r.item.ParentReference.DriveID, r.item.ID)
+}
+
+// DataSize returns the size of the file
+func (r *GraphClientReader) DataSize() (int64, error) {
+	return r.size, nil
+}
+
+// bindContext runs the ranged reads of a scan under its context
+func (r *GraphClientReader) bindContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// ReadBytes reads bytes from the file at the specified offset. The download
+// URL carries its own authorization and must not get a bearer token.
+func (r *GraphClientReader) ReadBytes(offset int64, length int32) ([]byte, error) {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.item.DownloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+int64(length)-1))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Graph ranged read returned %s", resp.Status)
+	}
+
+	bytes, err := io.ReadAll(resp.Body)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading Graph file body: %v", err)
+	}
+	return bytes, err
+}
+
+// newGraphReaderFromURI opens a reader for msgraph://drives/<driveId>/items/<itemId>
+func newGraphReaderFromURI(ctx context.Context, location string, options map[string]string) (amaasclient.AmaasClientReader, error) {
+	parts := strings.Split(strings.Trim(location, "/"), "/")
+	if len(parts) != 4 || parts[0] != "drives" || parts[2] != "items" || parts[1] == "" || parts[3] == "" {
+		return nil, fmt.Errorf("invalid Graph location %q, expected msgraph://drives/driveId/items/itemId", location)
+	}
+	creds := GraphCredentials{
+		AccessToken:  Secret(options["accessToken"]),
+		TenantID:     options["tenantId"],
+		ClientID:     options["clientId"],
+		ClientSecret: Secret(options["clientSecret"]),
+	}
+	return NewGraphClientReader(ctx, creds, "/drives/"+graphPathEscape(parts[1]), parts[3])
+}
+
+// scanGraphItem scans one drive item. It is shared by /graph/scan and delta
+// scan jobs.
+func scanGraphItem(ctx context.Context, scannerClient *amaasclient.AmaasClient, reader *GraphClientReader, extraTags []string) (string, ScanVerdict, error) {
+	tags := buildScanTags(sourceGraph, getCustomTags(), append(append([]string{}, extraTags...), "file_type="+path.Ext(reader.item.Name))...)
+	scanStart := time.Now()
+	scanResult, err := observeScan(ctx, sourceGraph, reader.size, func(ctx context.Context) (string, error) {
+		return scanReader(ctx, scannerClient, reader, tags)
+	})
+	if err != nil {
+		return "", ScanVerdict{}, err
+	}
+	verdict, err := parseScanVerdict(scanResult)
+	if err != nil {
+		return scanResult, verdict, err
+	}
+	reportScanOutcome(ctx, ScanOutcome{
+		Source:     sourceGraph,
+		Key:        reader.item.Name,
+		Identifier: reader.Identifier(),
+		Tags:       tags,
+		Verdict:    verdict,
+		Duration:   time.Since(scanStart),
+		Size:       reader.size,
+	})
+	return scanResult, verdict, nil
+}
+
+// HTTP handler for listing a folder of a OneDrive or SharePoint library
+func handleListGraphItems(scannerClient *amaasclient.AmaasClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Printf("--- LIST GRAPH ITEMS REQUEST at %s ---", time.Now().Format(time.RFC3339))
+
+		var req struct {
+			GraphCredentials
+			GraphDriveTarget
+			ItemID string `json:"itemId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		drivePath, err := req.drivePath()
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "driveId")
+			return
+		}
+
+		client, err := newGraphClient(req.GraphCredentials)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		endpoint := graphAPIBase + drivePath + "/root/children?$top=200"
+		if req.ItemID != "" {
+			endpoint = graphAPIBase + drivePath + "/items/" + graphPathEscape(req.ItemID) + "/children?$top=200"
+		}
+		ctx := r.Context()
+		items := make([]map[string]interface{}, 0)
+		for endpoint != "" {
+			var page graphPage
+			if err := client.get(ctx, endpoint, &page); err != nil {
+				log.Printf("Failed to list Graph items: %v", err)
+				http.Error(w, fmt.Sprintf("Failed to list items: %v", err), http.StatusInternalServerError)
+				return
+			}
+			for _, item := range page.Value {
+				items = append(items, map[string]interface{}{
+					"id":           item.ID,
+					"driveId":      item.ParentReference.DriveID,
+					"name":         item.Name,
+					"size":         item.Size,
+					"lastModified": item.LastModifiedDateTime,
+					"folder":       item.Folder != nil,
+					"scannable":    item.File != nil,
+				})
+			}
+			endpoint = page.NextLink
+		}
+		log.Printf("Successfully listed %d Graph items", len(items))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"itemId": req.ItemID,
+			"items":  items,
+		})
+	}
+}
+
+// HTTP handler for scanning a OneDrive or SharePoint file by item ID
+func handleScanGraphItem(scannerClient *amaasclient.AmaasClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Printf("=== GRAPH SCAN REQUEST at %s ===", time.Now().Format(time.RFC3339))
+
+		var req struct {
+			GraphCredentials
+			GraphDriveTarget
+			ItemID string   `json:"itemId"`
+			Tags   []string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		drivePath, err := req.drivePath()
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "driveId")
+			return
+		}
+		if req.ItemID == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing required field: itemId", "itemId")
+			return
+		}
+
+		ctx := r.Context()
+		reader, err := NewGraphClientReader(ctx, req.GraphCredentials, drivePath, req.ItemID)
+		if err != nil {
+			log.Printf("ERROR: Failed to create Graph reader: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to create Graph reader: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("=== Starting Graph Scan ===")
+		log.Printf("File: %s (%s)", reader.item.Name, reader.Identifier())
+		log.Printf("Size: %d bytes", reader.size)
+
+		scanStart := time.Now()
+		scanResult, _, err := scanGraphItem(ctx, scannerClient, reader, req.Tags)
+		if writeScanRejected(w, err) || writeScanTimeout(w, ctx, err) {
+			return
+		}
+		if err != nil && scanResult == "" {
+			log.Printf("❌ Scan FAILED for %s: %v", reader.Identifier(), err)
+			http.Error(w, fmt.Sprintf("Scan failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("✓ Scan COMPLETED successfully for %s", reader.Identifier())
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"scanResult": scanResult,
+			"itemId":     req.ItemID,
+			"driveId":    reader.item.ParentReference.DriveID,
+			"name":       reader.item.Name,
+			"result":     normalizeResult(scanResult, time.Since(scanStart), wantsRawResult(r)),
+		})
+	}
+}
+
+// GraphDeltaScanRequest is the body accepted by /graph/scan-delta. Each run
+// continues from the delta link of the last completed run for the same drive
+// and tenant, so only files added or changed since then are scanned.
+type GraphDeltaScanRequest struct {
+	GraphCredentials
+	GraphDriveTarget
+	// DeltaLink overrides the delta link of the previous run
+	DeltaLink string `json:"deltaLink"`
+	// Full ignores previous runs and scans every file of the drive
+	Full    bool     `json:"full"`
+	Tags    []string `json:"tags"`
+	Workers int      `json:"workers"`
+	// CallbackURL receives the final job state when the scan finishes
+	CallbackURL string `json:"callbackUrl"`
+}
+
+// target validates the request and returns the job target
+func (req GraphDeltaScanRequest) target() (string, error) {
+	drivePath, err := req.drivePath()
+	if err != nil {
+		return "", err
+	}
+	if req.DeltaLink != "" && !strings.HasPrefix(req.DeltaLink, graphAPIBase+"/") {
+		return "", fmt.Errorf("deltaLink must be a %s URL", graphAPIBase)
+	}
+	return "msgraph:/" + drivePath, nil
+}
+
+// HTTP handler that enqueues an incremental scan of a OneDrive or SharePoint library
+func handleScanGraphDelta(scannerClient *amaasclient.AmaasClient, jobs *JobManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		log.Printf("=== GRAPH DELTA SCAN REQUEST at %s ===", time.Now().Format(time.RFC3339))
+
+		var req GraphDeltaScanRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		target, err := req.target()
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "")
+			return
+		}
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "callbackUrl")
+			return
+		}
+
+		job, err := jobs.Submit(r.Context(), jobTypeGraphDeltaScan, target, req.CallbackURL, req)
+		if err != nil {
+			log.Printf("ERROR: Failed to queue Graph delta scan: %v", err)
+			writeJSONError(w, http.StatusServiceUnavailable, fmt.Sprintf("Failed to queue Graph delta scan: %v", err), "")
+			return
+		}
+		log.Printf("Queued Graph delta scan job %s for %s", job.ID(), target)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(JobAcceptedResponse{
+			JobID:  job.ID(),
+			Status: JobQueued,
+		})
+	}
+}
+
+// graphDeltaScanRunner returns the job runner for Graph delta scans. jobs is
+// searched for the delta link of the previous run.
+func graphDeltaScanRunner(scannerClient *amaasclient.AmaasClient, jobs *JobManager) JobRunner {
+	return func(ctx context.Context, job *Job) error {
+		var req GraphDeltaScanRequest
+		if err := json.Unmarshal(job.Params(), &req); err != nil {
+			return fmt.Errorf("invalid Graph delta scan parameters: %v", err)
+		}
+		return runGraphDeltaScan(ctx, scannerClient, jobs, job, req)
+	}
+}
+
+// lastGraphDeltaLink returns the delta link saved by the newest completed
+// delta scan of the same drive and tenant
+func lastGraphDeltaLink(jobs *JobManager, job *Job) string {
+	current := job.Snapshot(false)
+	for _, other := range jobs.List() {
+		state := other.Snapshot(false)
+		if state.ID != current.ID && state.Type == jobTypeGraphDeltaScan && state.Status == JobCompleted &&
+			state.Tenant == current.Tenant && state.Target == current.Target && state.Cursor != "" {
+			return state.Cursor
+		}
+	}
+	return ""
+}
+
+// runGraphDeltaScan follows the drive's delta feed page by page and scans
+// every added or changed file with a worker pool. The cursor holds the next
+// page while the job runs and the delta link for the next run once it is done.
+func runGraphDeltaScan(ctx context.Context, scannerClient *amaasclient.AmaasClient, jobs *JobManager, job *Job, req GraphDeltaScanRequest) error {
+	ctx = withBackgroundScan(ctx)
+	drivePath, err := req.drivePath()
+	if err != nil {
+		return err
+	}
+	client, err := newGraphClient(req.GraphCredentials)
+	if err != nil {
+		return err
+	}
+
+	fullScan := graphAPIBase + drivePath + "/root/delta"
+	link := job.Cursor()
+	switch {
+	case link != "":
+		log.Printf("Job %s: resuming Graph delta scan of %s", job.ID(), drivePath)
+	case req.DeltaLink != "":
+		link = req.DeltaLink
+	case !req.Full:
+		link = lastGraphDeltaLink(jobs, job)
+	}
+	if link == "" {
+		log.Printf("Job %s: no previous delta link, scanning every file of %s", job.ID(), drivePath)
+		link = fullScan
+	}
+
+	// Scans in flight when the job is paused or canceled run to completion
+	scanCtx := context.WithoutCancel(ctx)
+	workers := getBucketScanWorkers(req.Workers)
+	job.Start(job.Snapshot(false).Scanned)
+	done := job.CompletedKeys()
+
+	for {
+		var page graphPage
+		err := client.get(ctx, link, &page)
+		var apiErr *graphAPIError
+		if errors.As(err, &apiErr) && apiErr.status == http.StatusGone && link != fullScan {
+			// The delta token expired; Graph requires a full resync
+			log.Printf("Job %s: delta link for %s expired, scanning every file", job.ID(), drivePath)
+			link = fullScan
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read delta of %s: %v", drivePath, err)
+		}
+
+		// Skip folders, deleted items and files already scanned by this job
+		items := make([]graphItem, 0, len(page.Value))
+		for _, item := range page.Value {
+			if item.File == nil || item.Deleted != nil || done[graphItemURI(item.ParentReference.DriveID, item.ID)] {
+				continue
+			}
+			items = append(items, item)
+		}
+		job.AddTotal(len(items))
+
+		queue := make(chan graphItem)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for item := range queue {
+					job.Record(scanGraphDeltaItem(scanCtx, scannerClient, client, drivePath, item, req.Tags, job.ID()))
+				}
+			}()
+		}
+
+		// Stop dispatching once the job is paused or canceled
+		stopped := false
+		for _, item := range items {
+			select {
+			case queue <- item:
+				continue
+			case <-ctx.Done():
+				stopped = true
+			}
+			break
+		}
+		close(queue)
+		wg.Wait()
+		if stopped {
+			return ctx.Err()
+		}
+
+		if page.NextLink == "" {
+			job.Checkpoint(page.DeltaLink)
+			log.Printf("Job %s: finished Graph delta scan of %s", job.ID(), drivePath)
+			return nil
+		}
+		link = page.NextLink
+		job.Checkpoint(link)
+	}
+}
+
+// scanGraphDeltaItem scans one changed file for a delta scan job
+func scanGraphDeltaItem(ctx context.Context, scannerClient *amaasclient.AmaasClient, client *graphClient, drivePath string, item graphItem, extraTags []string, jobID string) JobObjectResult {
+	result := JobObjectResult{Key: graphItemURI(item.ParentReference.DriveID, item.ID)}
+
+	scanStart := time.Now()
+	reader, err := newGraphReader(ctx, client, drivePath, item.ID)
+	if err == nil {
+		var verdict ScanVerdict
+		if _, verdict, err = scanGraphItem(ctx, scannerClient, reader, extraTags); err == nil {
+			result.Verdict = verdict.Label()
+			result.MalwareNames = verdict.MalwareNames
+			normalized := verdict.Normalize(time.Since(scanStart), false)
+			result.Result = &normalized
+			log.Printf("Job %s: %s (%s) is %s", jobID, item.Name, result.Key, result.Verdict)
+			return result
+		}
+	}
+	log.Printf("Job %s: scan FAILED for %s (%s): %v", jobID, item.Name, result.Key, err)
+	result.Verdict = "error"
+	result.Error = err.Error()
+	return result
+}
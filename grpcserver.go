@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"bytevault-scanner/scanpb"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// errGRPCStreamTooLarge is returned when a ScanStream exceeds MAX_UPLOAD_SIZE_MB
+var errGRPCStreamTooLarge = errors.New("stream exceeds the maximum upload size")
+
+// grpcRoutes maps each RPC to the HTTP endpoint whose authentication scope,
+// tenant checks and rate limits it shares. Scan results are read with the
+// jobs:read scope.
+var grpcRoutes = map[string]struct{ method, path string }{
+	scanpb.Scanner_Scan_FullMethodName:       {http.MethodPost, "/scan"},
+	scanpb.Scanner_ScanStream_FullMethodName: {http.MethodPost, "/scan"},
+	scanpb.Scanner_ListJobs_FullMethodName:   {http.MethodGet, "/jobs/"},
+	scanpb.Scanner_GetResult_FullMethodName:  {http.MethodGet, "/jobs/"},
+}
+
+type grpcAdmitKey struct{}
+
+// GRPCServer implements the Scanner gRPC service on top of the same scan
+// pipeline, job manager and history store as the HTTP endpoints
+type GRPCServer struct {
+	scanpb.UnimplementedScannerServer
+	scannerClient *amaasclient.AmaasClient
+	scanCache     *ScanCache
+	jobs          *JobManager
+	gate          http.Handler
+}
+
+// startGRPCServer serves the gRPC API on addr (GRPC_LISTEN_ADDR). gate is the
+// HTTP middleware chain (authentication, tenants, rate limits) every call is
+// admitted through; tlsConfig, when set, is the scanner listener TLS config.
+func startGRPCServer(scannerClient *amaasclient.AmaasClient, scanCache *ScanCache, jobs *JobManager, gate func(http.Handler) http.Handler, tlsConfig *tls.Config, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+
+	s := &GRPCServer{
+		scannerClient: scannerClient,
+		scanCache:     scanCache,
+		jobs:          jobs,
+		gate: gate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if admitted, ok := r.Context().Value(grpcAdmitKey{}).(*context.Context); ok {
+				*admitted = r.Context()
+			}
+		})),
+	}
+	maxMessage := getEnvInt("GRPC_MAX_MESSAGE_MB", 16) << 20
+	serverOpts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(maxMessage),
+		grpc.UnaryInterceptor(s.unaryInterceptor),
+		grpc.StreamInterceptor(s.streamInterceptor),
+	}
+	if tlsConfig != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(serverOpts...)
+	scanpb.RegisterScannerServer(server, s)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+
+	log.Printf("- gRPC API: %s (TLS: %v, max message %d MB)", listener.Addr(), tlsConfig != nil, maxMessage>>20)
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Printf("gRPC server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// admit runs a call through the HTTP middleware chain using an equivalent
+// request built from the call metadata, returning the context it produced
+// (caller, tenant and request ID) or the rejection as a gRPC status
+func (s *GRPCServer) admit(ctx context.Context, fullMethod string) (context.Context, error) {
+	route, ok := grpcRoutes[fullMethod]
+	if !ok {
+		// Health checks are public, like /healthz
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	requestID := firstMetadata(md, "x-request-id")
+	if requestID == "" || len(requestID) > 128 {
+		requestID = newRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", requestID))
+	ctx = context.WithValue(ctx, requestIDKey, requestID)
+
+	r := (&http.Request{
+		Method: route.method,
+		URL:    &url.URL{Path: route.path},
+		Header: http.Header{},
+	}).WithContext(ctx)
+	r.Header.Set("Authorization", firstMetadata(md, "authorization"))
+	r.Header.Set(tenantHeader, firstMetadata(md, "x-tenant"))
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &info.State
+		}
+	}
+
+	var admitted context.Context
+	rec := httptest.NewRecorder()
+	s.gate.ServeHTTP(rec, r.WithContext(context.WithValue(ctx, grpcAdmitKey{}, &admitted)))
+	if admitted != nil {
+		return admitted, nil
+	}
+
+	var body ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if retryAfter := rec.Header().Get("Retry-After"); retryAfter != "" {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter))
+	}
+	return nil, status.Error(grpcCode(rec.Code), body.Error)
+}
+
+func (s *GRPCServer) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.admit(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *GRPCServer) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.admit(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &admittedStream{ServerStream: ss, ctx: ctx})
+}
+
+// admittedStream carries the admitted context into stream handlers
+type admittedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *admittedStream) Context() context.Context {
+	return s.ctx
+}
+
+// firstMetadata returns the first value of key in md
+func firstMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// grpcCode maps an HTTP status from the middleware chain to a gRPC code
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	return codes.Internal
+}
+
+// grpcScanError maps scan pipeline errors to gRPC statuses, as
+// writeScanRejected and writeScanTimeout do for HTTP
+func grpcScanError(err error) error {
+	var timeout *ScanTimeoutError
+	var tooLarge *ScanTooLargeError
+	switch {
+	case errors.As(err, &tooLarge):
+		return status.Errorf(codes.FailedPrecondition, "File exceeds the maximum scan size of %d MB", tooLarge.Limit>>20)
+	case errors.Is(err, errScanQueueFull):
+		return status.Error(codes.ResourceExhausted, "Scan queue is full")
+	case errors.Is(err, errTenantQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, "Tenant daily scan quota exceeded")
+	case errors.Is(err, errScannerUnavailable):
+		return status.Error(codes.Unavailable, "Scanner unavailable")
+	case errors.As(err, &timeout):
+		return status.Errorf(codes.DeadlineExceeded, "Scan timed out after %s while %s (attempts: %d)", timeout.Timeout, timeout.Stage, timeout.Attempts)
+	}
+	return status.Error(codes.Internal, "Scanning failed")
+}
+
+// scanOptionsFromProto converts request options to ScanOptions
+func scanOptionsFromProto(o *scanpb.ScanOptions) ScanOptions {
+	return ScanOptions{
+		PML:           o.GetPml(),
+		Feedback:      o.GetFeedback(),
+		Verbose:       o.GetVerbose(),
+		ActiveContent: o.GetActiveContent(),
+		DisableDigest: o.GetDisableDigest(),
+	}
+}
+
+// Scan scans a payload sent in one message
+func (s *GRPCServer) Scan(ctx context.Context, req *scanpb.ScanRequest) (*scanpb.ScanResponse, error) {
+	if maxSize := getMaxUploadSize(); int64(len(req.Data)) > maxSize {
+		return nil, status.Errorf(codes.ResourceExhausted, "File exceeds the maximum upload size of %d MB", maxSize>>20)
+	}
+	data := req.Data
+	return s.scan(ctx, req.Filename, req.Tags, req.Options, "buffer", int64(len(data)), sha256Hex(data), detectContentType(data),
+		func(ctx context.Context, client *amaasclient.AmaasClient, identifier string, tags []string) (string, error) {
+			return scanBuffer(ctx, client, data, identifier, tags)
+		})
+}
+
+// ScanStream spools a chunked payload to SCAN_SPOOL_DIR and scans it with
+// ScanReader, so payloads larger than GRPC_MAX_MESSAGE_MB are supported
+func (s *GRPCServer) ScanStream(stream scanpb.Scanner_ScanStreamServer) error {
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "Empty scan stream")
+	}
+	if err != nil {
+		return err
+	}
+
+	maxSize := getMaxUploadSize()
+	reader, sum, err := spoolUpload(&chunkReader{stream: stream, data: first.Data, max: maxSize}, first.Filename)
+	if errors.Is(err, errGRPCStreamTooLarge) {
+		return status.Errorf(codes.ResourceExhausted, "File exceeds the maximum upload size of %d MB", maxSize>>20)
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	response, err := s.scan(stream.Context(), first.Filename, first.Tags, first.Options, "spool", reader.size, sum, sniffReader(reader),
+		func(ctx context.Context, client *amaasclient.AmaasClient, identifier string, tags []string) (string, error) {
+			return scanReader(ctx, client, reader, tags)
+		})
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(response)
+}
+
+// chunkReader reads the data of ScanStream chunks in order
+type chunkReader struct {
+	stream scanpb.Scanner_ScanStreamServer
+	data   []byte
+	total  int64
+	max    int64
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.data) == 0 {
+		chunk, err := c.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		c.data = chunk.Data
+	}
+	n := copy(p, c.data)
+	c.data = c.data[n:]
+	c.total += int64(n)
+	if c.total > c.max {
+		return n, errGRPCStreamTooLarge
+	}
+	return n, nil
+}
+
+// scan runs one scan through the cache and scan pipeline and reports it like
+// an HTTP upload
+func (s *GRPCServer) scan(ctx context.Context, filename string, requestTags []string, options *scanpb.ScanOptions, source string, size int64, sum, detectedType string,
+	call func(ctx context.Context, client *amaasclient.AmaasClient, identifier string, tags []string) (string, error)) (*scanpb.ScanResponse, error) {
+	if filename == "" {
+		filename = "unknown"
+	}
+	ctx, scanClient, opts := scanPolicyClient(ctx, s.scannerClient, ScanTarget{
+		Source: sourceUpload, Key: filename, DetectedType: detectedType, Size: size,
+	}, scanOptionsFromProto(options))
+	ctx, attempts := withScanAttempts(ctx)
+
+	identifier := time.Now().Format("20060102150405") + "-" + filepath.Base(filename)
+	extras := append([]string{"file_type=" + filepath.Ext(filename), "scan_method=grpc"}, requestTags...)
+	tags := buildScanTags(sourceUpload, getCustomTags(), extras...)
+
+	cacheKey := ""
+	if s.scanCache != nil {
+		cacheKey = scanCacheKey(sum, opts)
+	}
+	log.Printf("Starting gRPC scan for file: %s (%d bytes) with tags: %v", identifier, size, tags)
+	scanStart := time.Now()
+	scanResult, cached, err := scanUnlessListed(ctx, s.scanCache, sum, filename, cacheKey, func() (string, error) {
+		return observeScan(ctx, source, size, func(ctx context.Context) (string, error) {
+			return call(ctx, scanClient, identifier, tags)
+		})
+	})
+	var verdict ScanVerdict
+	if err == nil {
+		verdict, err = parseScanVerdict(scanResult)
+	}
+	if err != nil {
+		loggerFrom(ctx).Error("scan failed", "scan_id", identifier, "source", sourceUpload, "scan_method", "grpc", "error", err)
+		return nil, grpcScanError(err)
+	}
+
+	if verdict.ScanID == "" {
+		verdict.ScanID = identifier
+	}
+	reportScanOutcome(ctx, ScanOutcome{
+		Source:     sourceUpload,
+		Identifier: identifier,
+		Tags:       tags,
+		Verdict:    verdict,
+		Duration:   time.Since(scanStart),
+		Size:       size,
+	})
+	return &scanpb.ScanResponse{
+		IsSafe:       verdict.IsSafe,
+		Verdict:      verdict.Label(),
+		MalwareNames: verdict.MalwareNames,
+		ScanId:       verdict.ScanID,
+		RequestId:    requestIDFrom(ctx),
+		Cached:       cached,
+		Attempts:     attempts.Load(),
+		Size:         size,
+		FileSha256:   sum,
+	}, nil
+}
+
+// ListJobs lists the jobs visible to the caller's tenant, newest first
+func (s *GRPCServer) ListJobs(ctx context.Context, req *scanpb.ListJobsRequest) (*scanpb.ListJobsResponse, error) {
+	response := &scanpb.ListJobsResponse{}
+	for _, job := range s.jobs.List() {
+		state := job.Snapshot(false)
+		if !tenantOwns(ctx, state.Tenant) || (req.Status != "" && state.Status != req.Status) {
+			continue
+		}
+		pbJob := &scanpb.Job{
+			Id:        state.ID,
+			Type:      state.Type,
+			Status:    state.Status,
+			Tenant:    state.Tenant,
+			Target:    state.Target,
+			CreatedAt: timestamppb.New(state.CreatedAt),
+			Total:     int32(state.Total),
+			Scanned:   int32(state.Scanned),
+			Clean:     int32(state.Clean),
+			Infected:  int32(state.Infected),
+			Failed:    int32(state.Failed),
+			Skipped:   int32(state.Skipped),
+			Error:     state.Error,
+		}
+		if state.FinishedAt != nil {
+			pbJob.FinishedAt = timestamppb.New(*state.FinishedAt)
+		}
+		response.Jobs = append(response.Jobs, pbJob)
+	}
+	return response, nil
+}
+
+// GetResult returns the recorded result of a scan from the history store
+func (s *GRPCServer) GetResult(ctx context.Context, req *scanpb.GetResultRequest) (*scanpb.ScanResult, error) {
+	if req.ScanId == "" {
+		return nil, status.Error(codes.InvalidArgument, "scan_id is required")
+	}
+	if scanHistory == nil {
+		return nil, status.Error(codes.FailedPrecondition, "Scan history is disabled (set HISTORY_DSN)")
+	}
+	record, ok, err := scanHistory.Get(ctx, tenantName(ctx), req.ScanId)
+	if err != nil {
+		loggerFrom(ctx).Error("history lookup failed", "scan_id", req.ScanId, "error", err)
+		return nil, status.Error(codes.Internal, "History lookup failed")
+	}
+	if !ok {
+		return nil, status.Error(codes.NotFound, "Scan result not found")
+	}
+	return &scanpb.ScanResult{
+		ScanId:       record.ScanID,
+		ScannedAt:    timestamppb.New(record.ScannedAt),
+		Tenant:       record.Tenant,
+		Source:       record.Source,
+		Identifier:   record.Identifier,
+		Verdict:      record.Verdict,
+		MalwareNames: record.MalwareNames,
+		Tags:         record.Tags,
+		FileSha256:   record.FileSHA256,
+		DurationMs:   record.DurationMs,
+	}, nil
+}
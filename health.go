@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+const (
+	// Consecutive failed scans after which /readyz reports the backend as down
+	defaultReadyMaxScanFailures = 5
+	readyCheckTimeout           = 2 * time.Second
+	deepHealthTimeout           = 10 * time.Second
+	defaultDeepHealthCacheTTL   = 30 * time.Second
+)
+
+// scanFailures counts scans that failed in a row; a successful scan resets it
+var scanFailures atomic.Int64
+
+// ReadinessReport is the body of /readyz
+type ReadinessReport struct {
+	Status    string           `json:"status"` // ready or not_ready
+	Timestamp string           `json:"timestamp"`
+	Checks    []PreflightCheck `json:"checks"`
+}
+
+// Saturated reports whether interactive scans are being rejected because the
+// queue is at its configured depth
+func (l *ScanLimiter) Saturated() bool {
+	return len(l.slots) == cap(l.slots) && l.waiting.Load() >= l.depth
+}
+
+// handleLiveness answers /healthz: the process is up and serving HTTP
+func handleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":    "alive",
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// handleReadiness answers /readyz with 503 while the scanner client is
+// missing, a backend is unreachable or the scan queue is full, so traffic is
+// routed elsewhere without restarting the pod
+func handleReadiness(client *amaasclient.AmaasClient, externalAddr string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+		defer cancel()
+
+		report := ReadinessReport{
+			Status:    "ready",
+			Timestamp: time.Now().Format(time.RFC3339),
+			Checks: []PreflightCheck{
+				readyCheck("scanner", func() (string, error) {
+					if client == nil {
+						return "", fmt.Errorf("scanner client is not initialized")
+					}
+					return "scanner client initialized", nil
+				}),
+				readyCheck("scanner_backend", func() (string, error) {
+					return checkBackendReachable(ctx, externalAddr)
+				}),
+				readyCheck("history", func() (string, error) {
+					if scanHistory == nil {
+						return "", nil
+					}
+					if err := scanHistory.db.PingContext(ctx); err != nil {
+						return "", fmt.Errorf("scan history database unreachable: %v", err)
+					}
+					return "scan history database reachable", nil
+				}),
+				readyCheck("scan_queue", func() (string, error) {
+					if scanLimiter.Saturated() {
+						return "", fmt.Errorf("scan queue is full (%d waiting)", scanLimiter.waiting.Load())
+					}
+					return fmt.Sprintf("%d/%d scan slots in use", len(scanLimiter.slots), cap(scanLimiter.slots)), nil
+				}),
+			},
+		}
+		for _, check := range report.Checks {
+			if check.Status == "failed" {
+				report.Status = "not_ready"
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != "ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// readyCheck runs one readiness check. A check that returns no detail and no
+// error does not apply to this deployment and is reported as skipped.
+func readyCheck(name string, check func() (string, error)) PreflightCheck {
+	start := time.Now()
+	detail, err := check()
+	result := PreflightCheck{Name: name, Status: "ok", Detail: detail}
+	switch {
+	case err != nil:
+		result.Status = "failed"
+		result.Detail = err.Error()
+	case detail == "":
+		result.Status = "skipped"
+	}
+	result.Duration = time.Since(start).String()
+	result.LatencyMs = latencyMs(time.Since(start))
+	return result
+}
+
+// latencyMs converts a duration to fractional milliseconds
+func latencyMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// checkBackendReachable fails after READY_MAX_SCAN_FAILURES scans failed in a
+// row and, for an external scanner, when its gRPC port does not accept
+// connections
+func checkBackendReachable(ctx context.Context, externalAddr string) (string, error) {
+	if scanBreaker.State() == breakerOpen {
+		return "", fmt.Errorf("circuit breaker open, retrying in %s", scanBreaker.RetryAfter().Round(time.Second))
+	}
+	maxFailures := int64(getEnvInt("READY_MAX_SCAN_FAILURES", defaultReadyMaxScanFailures))
+	if failures := scanFailures.Load(); maxFailures > 0 && failures >= maxFailures {
+		return "", fmt.Errorf("last %d scans failed", failures)
+	}
+	if externalAddr == "" {
+		return "recent scans succeeded", nil
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", externalAddr)
+	if err != nil {
+		return "", fmt.Errorf("external scanner %s unreachable: %v", externalAddr, err)
+	}
+	conn.Close()
+	return fmt.Sprintf("external scanner %s reachable", externalAddr), nil
+}
+
+// DeepHealthReport is the body of /health/deep
+type DeepHealthReport struct {
+	Status    string           `json:"status"` // healthy, degraded or unhealthy
+	Timestamp string           `json:"timestamp"`
+	Cached    bool             `json:"cached"`
+	Checks    []PreflightCheck `json:"checks"`
+}
+
+// handleDeepHealth answers /health/deep by exercising every dependency: a real
+// scan of a tiny buffer, the scan history and cache stores, and AWS STS. The
+// scanner failing makes the service unhealthy (503); other failures degrade
+// it. Results are reused for DEEP_HEALTH_CACHE_TTL since each call is a scan.
+func handleDeepHealth(client *amaasclient.AmaasClient, endpoint string, scanCache *ScanCache) http.HandlerFunc {
+	ttl, err := time.ParseDuration(getEnv("DEEP_HEALTH_CACHE_TTL", defaultDeepHealthCacheTTL.String()))
+	if err != nil || ttl < 0 {
+		ttl = defaultDeepHealthCacheTTL
+	}
+
+	var (
+		mu      sync.Mutex
+		last    DeepHealthReport
+		checked time.Time
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		report := last
+		if time.Since(checked) < ttl && !checked.IsZero() {
+			report.Cached = true
+		} else {
+			report = runDeepHealth(r.Context(), client, endpoint, scanCache)
+			last, checked = report, time.Now()
+		}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status == "unhealthy" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// runDeepHealth runs the dependency checks concurrently
+func runDeepHealth(ctx context.Context, client *amaasclient.AmaasClient, endpoint string, scanCache *ScanCache) DeepHealthReport {
+	ctx, cancel := context.WithTimeout(ctx, deepHealthTimeout)
+	defer cancel()
+
+	checks := []func() PreflightCheck{
+		func() PreflightCheck {
+			return timedCheck(func() PreflightCheck {
+				if scannerPool != nil {
+					return scannerPool.check()
+				}
+				return checkScannerBackend(ctx, client, endpoint)
+			})
+		},
+		func() PreflightCheck {
+			return readyCheck("history", func() (string, error) {
+				if scanHistory == nil {
+					return "", nil
+				}
+				if err := scanHistory.db.PingContext(ctx); err != nil {
+					return "", fmt.Errorf("scan history database unreachable: %v", err)
+				}
+				return "scan history database reachable", nil
+			})
+		},
+		func() PreflightCheck {
+			return readyCheck("cache", func() (string, error) {
+				if scanCache == nil {
+					return "", nil
+				}
+				if err := scanCache.Ping(ctx); err != nil {
+					return "", fmt.Errorf("scan cache unreachable: %v", err)
+				}
+				return "scan cache reachable", nil
+			})
+		},
+		func() PreflightCheck {
+			return timedCheck(func() PreflightCheck { return checkAWSCredentials(ctx) })
+		},
+	}
+
+	report := DeepHealthReport{
+		Status:    "healthy",
+		Timestamp: time.Now().Format(time.RFC3339),
+		Checks:    make([]PreflightCheck, len(checks)),
+	}
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Checks[i] = check()
+		}()
+	}
+	wg.Wait()
+
+	for _, check := range report.Checks {
+		if check.Status != "failed" {
+			continue
+		}
+		if check.Name == "scanner" {
+			report.Status = "unhealthy"
+		} else if report.Status == "healthy" {
+			report.Status = "degraded"
+		}
+	}
+	return report
+}
+
+// timedCheck adds the latency to a preflight check
+func timedCheck(check func() PreflightCheck) PreflightCheck {
+	start := time.Now()
+	result := check()
+	result.LatencyMs = latencyMs(time.Since(start))
+	return result
+}
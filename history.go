@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	historyQueueSize   = 1000
+	historyTable       = "scan_history"
+	historyInsertLimit = 10 * time.Second
+)
+
+// ScanRecord is one row of the scan history
+type ScanRecord struct {
+	ID           int64     `json:"id"`
+	ScannedAt    time.Time `json:"scannedAt"`
+	RequestID    string    `json:"requestId,omitempty"`
+	Tenant       string    `json:"tenant,omitempty"`
+	ScanID       string    `json:"scanId,omitempty"`
+	Source       string    `json:"source"`
+	Identifier   string    `json:"identifier"`
+	Bucket       string    `json:"bucket,omitempty"`
+	Key          string    `json:"key,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	VersionID    string    `json:"versionId,omitempty"`
+	FileSHA1     string    `json:"fileSha1,omitempty"`
+	FileSHA256   string    `json:"fileSha256,omitempty"`
+	DetectedType string    `json:"detectedType,omitempty"`
+	Verdict      string    `json:"verdict"`
+	MalwareNames []string  `json:"malwareNames"`
+	Tags         []string  `json:"tags,omitempty"`
+	DurationMs   int64     `json:"durationMs"`
+	SizeBytes    int64     `json:"sizeBytes,omitempty"`
+	// Result is the normalized verdict, missing for scans recorded before
+	// it was stored
+	Result *NormalizedVerdict `json:"result,omitempty"`
+}
+
+// HistoryStore persists scan records to SQLite or Postgres. Records are
+// written by a background goroutine so scans never wait on the database.
+type HistoryStore struct {
+	db      *sql.DB
+	driver  string
+	records chan ScanRecord
+	done    chan struct{}
+}
+
+// scanHistory is configured at startup by initHistory; nil disables history
+var scanHistory *HistoryStore
+
+// parseHistoryDSN maps HISTORY_DSN to a database/sql driver and data source.
+// postgres:// and postgresql:// URLs use Postgres, sqlite:<path> and
+// file:<path> use SQLite.
+func parseHistoryDSN(dsn string) (driver, source string, err error) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return "postgres", dsn, nil
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return "sqlite3", strings.TrimPrefix(dsn, "sqlite://"), nil
+	case strings.HasPrefix(dsn, "sqlite:"):
+		return "sqlite3", strings.TrimPrefix(dsn, "sqlite:"), nil
+	case strings.HasPrefix(dsn, "file:"):
+		return "sqlite3", dsn, nil
+	}
+	return "", "", fmt.Errorf("unsupported HISTORY_DSN %q, expected postgres:// or sqlite:<path>", dsn)
+}
+
+// NewHistoryStore opens the database and creates the history table if needed
+func NewHistoryStore(ctx context.Context, dsn string) (*HistoryStore, error) {
+	driver, source, err := parseHistoryDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database: %v", err)
+	}
+	if driver == "sqlite3" {
+		// SQLite allows a single writer at a time
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to history database: %v", err)
+	}
+
+	store := &HistoryStore{
+		db:      db,
+		driver:  driver,
+		records: make(chan ScanRecord, historyQueueSize),
+		done:    make(chan struct{}),
+	}
+	if err := store.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	go store.writer()
+	return store, nil
+}
+
+// migrate creates the history table and its indexes
+func (h *HistoryStore) migrate(ctx context.Context) error {
+	idColumn := "id INTEGER PRIMARY KEY AUTOINCREMENT"
+	if h.driver == "postgres" {
+		idColumn = "id BIGSERIAL PRIMARY KEY"
+	}
+
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS ` + historyTable + ` (
+			` + idColumn + `,
+			scanned_at TIMESTAMP NOT NULL,
+			request_id TEXT,
+			tenant TEXT,
+			scan_id TEXT,
+			source TEXT NOT NULL,
+			identifier TEXT NOT NULL,
+			bucket TEXT,
+			object_key TEXT,
+			etag TEXT,
+			version_id TEXT,
+			file_sha1 TEXT,
+			file_sha256 TEXT,
+			detected_type TEXT,
+			verdict TEXT NOT NULL,
+			malware_names TEXT,
+			tags TEXT,
+			duration_ms BIGINT,
+			size_bytes BIGINT,
+			result TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_scan_history_scanned_at ON ` + historyTable + ` (scanned_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_scan_history_sha256 ON ` + historyTable + ` (file_sha256)`,
+		`CREATE INDEX IF NOT EXISTS idx_scan_history_bucket_key ON ` + historyTable + ` (bucket, object_key, etag)`,
+	}
+	for _, stmt := range statements {
+		if _, err := h.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to create history table: %v", err)
+		}
+	}
+	// Tables created before tenants, sizes, detected types and normalized
+	// results were recorded lack the columns; the statements fail harmlessly
+	// when they already exist
+	h.db.ExecContext(ctx, `ALTER TABLE `+historyTable+` ADD COLUMN tenant TEXT`)
+	h.db.ExecContext(ctx, `ALTER TABLE `+historyTable+` ADD COLUMN size_bytes BIGINT`)
+	h.db.ExecContext(ctx, `ALTER TABLE `+historyTable+` ADD COLUMN detected_type TEXT`)
+	h.db.ExecContext(ctx, `ALTER TABLE `+historyTable+` ADD COLUMN result TEXT`)
+	return nil
+}
+
+// placeholder returns the i-th (1-based) bind parameter in the driver's syntax
+func (h *HistoryStore) placeholder(i int) string {
+	if h.driver == "postgres" {
+		return fmt.Sprintf("$%d", i)
+	}
+	return "?"
+}
+
+// placeholders returns n bind parameters in the driver's syntax
+func (h *HistoryStore) placeholders(n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = h.placeholder(i + 1)
+	}
+	return strings.Join(params, ", ")
+}
+
+// Record queues a scan record for writing. Records are dropped with a
+// warning when the database cannot keep up.
+func (h *HistoryStore) Record(record ScanRecord) {
+	if h == nil {
+		return
+	}
+	select {
+	case h.records <- record:
+	default:
+		log.Printf("Warning: scan history queue full, dropping record for %s", record.Identifier)
+	}
+}
+
+// writer inserts queued records until Close is called
+func (h *HistoryStore) writer() {
+	defer close(h.done)
+	for record := range h.records {
+		ctx, cancel := context.WithTimeout(context.Background(), historyInsertLimit)
+		if err := h.insert(ctx, record); err != nil {
+			log.Printf("Warning: failed to save scan history for %s: %v", record.Identifier, err)
+		}
+		cancel()
+	}
+}
+
+// insert writes a single record
+func (h *HistoryStore) insert(ctx context.Context, record ScanRecord) error {
+	malwareNames, err := json.Marshal(record.MalwareNames)
+	if err != nil {
+		return err
+	}
+	tags, err := json.Marshal(record.Tags)
+	if err != nil {
+		return err
+	}
+	var result sql.NullString
+	if record.Result != nil {
+		data, err := json.Marshal(record.Result)
+		if err != nil {
+			return err
+		}
+		result = sql.NullString{String: string(data), Valid: true}
+	}
+
+	_, err = h.db.ExecContext(ctx,
+		`INSERT INTO `+historyTable+` (scanned_at, request_id, tenant, scan_id, source, identifier, bucket, object_key,
+			etag, version_id, file_sha1, file_sha256, detected_type, verdict, malware_names, tags, duration_ms, size_bytes, result)
+		VALUES (`+h.placeholders(19)+`)`,
+		record.ScannedAt.UTC(), record.RequestID, record.Tenant, record.ScanID, record.Source, record.Identifier,
+		record.Bucket, record.Key, record.ETag, record.VersionID, record.FileSHA1, record.FileSHA256, record.DetectedType, record.Verdict,
+		string(malwareNames), string(tags), record.DurationMs, record.SizeBytes, result,
+	)
+	return err
+}
+
+// LastObjectScan returns the most recent clean or malicious verdict for an
+// S3 object with the given ETag, and version when versionID is not empty.
+// Only scans made for tenant are considered.
+func (h *HistoryStore) LastObjectScan(ctx context.Context, tenant, bucket, key, etag, versionID string) (ScanRecord, bool, error) {
+	query := `SELECT id, scanned_at, scan_id, identifier, verdict, malware_names, result FROM ` + historyTable +
+		` WHERE COALESCE(tenant, '') = ` + h.placeholder(1) + ` AND bucket = ` + h.placeholder(2) +
+		` AND object_key = ` + h.placeholder(3) + ` AND etag = ` + h.placeholder(4)
+	args := []interface{}{tenant, bucket, key, etag}
+	if versionID != "" {
+		query += ` AND version_id = ` + h.placeholder(5)
+		args = append(args, versionID)
+	}
+	query += ` AND verdict IN ('clean', 'malicious') ORDER BY scanned_at DESC LIMIT 1`
+
+	record := ScanRecord{Source: sourceS3, Tenant: tenant, Bucket: bucket, Key: key, ETag: etag, VersionID: versionID}
+	var scanID, malwareNames, result sql.NullString
+	err := h.db.QueryRowContext(ctx, query, args...).Scan(&record.ID, &record.ScannedAt, &scanID, &record.Identifier, &record.Verdict, &malwareNames, &result)
+	if err == sql.ErrNoRows {
+		return record, false, nil
+	}
+	if err != nil {
+		return record, false, err
+	}
+	record.ScanID = scanID.String
+	record.MalwareNames = []string{}
+	if malwareNames.Valid {
+		json.Unmarshal([]byte(malwareNames.String), &record.MalwareNames)
+	}
+	record.Result = parseStoredResult(result)
+	return record, true, nil
+}
+
+// Get returns the most recent record with scanID. Without a tenant all
+// records are searched, as with jobs and schedules.
+func (h *HistoryStore) Get(ctx context.Context, tenant, scanID string) (ScanRecord, bool, error) {
+	query := `SELECT id, scanned_at, request_id, tenant, scan_id, source, identifier, file_sha256, detected_type, verdict, malware_names, tags, duration_ms, result FROM ` +
+		historyTable + ` WHERE scan_id = ` + h.placeholder(1)
+	args := []interface{}{scanID}
+	if tenant != "" {
+		query += ` AND tenant = ` + h.placeholder(2)
+		args = append(args, tenant)
+	}
+	query += ` ORDER BY scanned_at DESC LIMIT 1`
+
+	var record ScanRecord
+	var requestID, recordTenant, fileSHA256, detectedType, malwareNames, tags, result sql.NullString
+	var durationMs sql.NullInt64
+	err := h.db.QueryRowContext(ctx, query, args...).Scan(&record.ID, &record.ScannedAt, &requestID, &recordTenant, &record.ScanID,
+		&record.Source, &record.Identifier, &fileSHA256, &detectedType, &record.Verdict, &malwareNames, &tags, &durationMs, &result)
+	if err == sql.ErrNoRows {
+		return record, false, nil
+	}
+	if err != nil {
+		return record, false, err
+	}
+	record.RequestID = requestID.String
+	record.Tenant = recordTenant.String
+	record.FileSHA256 = fileSHA256.String
+	record.DetectedType = detectedType.String
+	record.DurationMs = durationMs.Int64
+	record.MalwareNames = []string{}
+	if malwareNames.Valid {
+		json.Unmarshal([]byte(malwareNames.String), &record.MalwareNames)
+	}
+	if tags.Valid {
+		json.Unmarshal([]byte(tags.String), &record.Tags)
+	}
+	record.Result = parseStoredResult(result)
+	return record, true, nil
+}
+
+// HistoryFilter selects scan records; zero fields match everything
+type HistoryFilter struct {
+	Tenant     string
+	From       time.Time
+	To         time.Time
+	Verdict    string
+	Source     string
+	Bucket     string
+	FileSHA256 string
+	Limit      int
+}
+
+// where returns the SQL condition and bind arguments of the filter
+func (h *HistoryStore) where(filter HistoryFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(column string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, column+h.placeholder(len(args)))
+	}
+	if filter.Tenant != "" {
+		add("tenant = ", filter.Tenant)
+	}
+	if !filter.From.IsZero() {
+		add("scanned_at >= ", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		add("scanned_at < ", filter.To.UTC())
+	}
+	if filter.Verdict != "" {
+		add("verdict = ", filter.Verdict)
+	}
+	if filter.Source != "" {
+		add("source = ", filter.Source)
+	}
+	if filter.Bucket != "" {
+		add("bucket = ", filter.Bucket)
+	}
+	if filter.FileSHA256 != "" {
+		add("file_sha256 = ", strings.ToLower(filter.FileSHA256))
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// Each calls fn for every record matching filter, oldest first, without
+// loading them all into memory. It stops at the first error fn returns.
+func (h *HistoryStore) Each(ctx context.Context, filter HistoryFilter, fn func(ScanRecord) error) error {
+	where, args := h.where(filter)
+	query := `SELECT id, scanned_at, request_id, tenant, scan_id, source, identifier, bucket, object_key, etag, version_id,
+		file_sha1, file_sha256, detected_type, verdict, malware_names, tags, duration_ms, size_bytes, result FROM ` + historyTable + where + ` ORDER BY scanned_at, id`
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record ScanRecord
+		var requestID, tenant, scanID, bucket, key, etag, versionID, fileSHA1, fileSHA256, detectedType, malwareNames, tags, result sql.NullString
+		var durationMs, sizeBytes sql.NullInt64
+		if err := rows.Scan(&record.ID, &record.ScannedAt, &requestID, &tenant, &scanID, &record.Source, &record.Identifier,
+			&bucket, &key, &etag, &versionID, &fileSHA1, &fileSHA256, &detectedType, &record.Verdict, &malwareNames, &tags, &durationMs, &sizeBytes, &result); err != nil {
+			return err
+		}
+		record.RequestID = requestID.String
+		record.Tenant = tenant.String
+		record.ScanID = scanID.String
+		record.Bucket = bucket.String
+		record.Key = key.String
+		record.ETag = etag.String
+		record.VersionID = versionID.String
+		record.FileSHA1 = fileSHA1.String
+		record.FileSHA256 = fileSHA256.String
+		record.DetectedType = detectedType.String
+		record.DurationMs = durationMs.Int64
+		record.SizeBytes = sizeBytes.Int64
+		record.MalwareNames = []string{}
+		if malwareNames.Valid {
+			json.Unmarshal([]byte(malwareNames.String), &record.MalwareNames)
+		}
+		if tags.Valid {
+			json.Unmarshal([]byte(tags.String), &record.Tags)
+		}
+		record.Result = parseStoredResult(result)
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// parseStoredResult decodes the result column, or returns nil when empty
+func parseStoredResult(result sql.NullString) *NormalizedVerdict {
+	if !result.Valid || result.String == "" {
+		return nil
+	}
+	var normalized NormalizedVerdict
+	if err := json.Unmarshal([]byte(result.String), &normalized); err != nil {
+		return nil
+	}
+	return &normalized
+}
+
+// Close flushes queued records and closes the database
+func (h *HistoryStore) Close() error {
+	if h == nil {
+		return nil
+	}
+	close(h.records)
+	<-h.done
+	return h.db.Close()
+}
+
+// initHistory enables scan history when HISTORY_DSN is set
+func initHistory(ctx context.Context) {
+	dsn := os.Getenv("HISTORY_DSN")
+	if dsn == "" {
+		return
+	}
+	store, err := NewHistoryStore(ctx, dsn)
+	if err != nil {
+		log.Printf("Warning: scan history disabled: %v", err)
+		return
+	}
+	scanHistory = store
+	log.Printf("- Scan history: %s", store.driver)
+}
+
+// lookupUnchanged returns the previous verdict for an S3 object whose ETag
+// (and version, when versioned) has not changed since it was last scanned
+func lookupUnchanged(ctx context.Context, reader *S3ClientReader) (ScanRecord, bool) {
+	if scanHistory == nil || reader.etag == "" {
+		return ScanRecord{}, false
+	}
+	record, ok, err := scanHistory.LastObjectScan(ctx, tenantName(ctx), reader.bucket, reader.key, reader.etag, reader.versionID)
+	if err != nil {
+		log.Printf("Warning: scan history lookup failed for %s: %v", reader.Identifier(), err)
+		return ScanRecord{}, false
+	}
+	return record, ok
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIdempotencyTTL    = 24 * time.Hour
+	defaultIdempotencyMaxMB  = 64
+	idempotencySweepInterval = time.Minute
+)
+
+// idempotentResponse is a recorded response replayed for repeated keys
+type idempotentResponse struct {
+	done        chan struct{}
+	fingerprint string // SHA-256 of the query and body of the first request
+	status      int
+	header      http.Header
+	body        []byte
+	expires     time.Time
+	// size is counted against the store's byte budget once stored
+	size    int64
+	element *list.Element
+}
+
+// IdempotencyStore keeps responses by Idempotency-Key for a TTL, within a
+// byte budget. Concurrent requests with the same key wait for the first one
+// instead of executing again.
+type IdempotencyStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	maxBytes int64
+	bytes    int64
+	entries  map[string]*idempotentResponse
+	// order lists the keys of stored responses, oldest first; with a single
+	// TTL that is also the order in which they expire
+	order *list.List
+}
+
+// NewIdempotencyStore creates a store with the TTL from IDEMPOTENCY_TTL and
+// the byte budget from IDEMPOTENCY_MAX_MB
+func NewIdempotencyStore() *IdempotencyStore {
+	ttl, err := time.ParseDuration(getEnv("IDEMPOTENCY_TTL", defaultIdempotencyTTL.String()))
+	if err != nil || ttl <= 0 {
+		log.Printf("Warning: invalid IDEMPOTENCY_TTL, using %s", defaultIdempotencyTTL)
+		ttl = defaultIdempotencyTTL
+	}
+	maxMB := getEnvInt("IDEMPOTENCY_MAX_MB", defaultIdempotencyMaxMB)
+	if maxMB <= 0 {
+		log.Printf("Warning: invalid IDEMPOTENCY_MAX_MB, using %d", defaultIdempotencyMaxMB)
+		maxMB = defaultIdempotencyMaxMB
+	}
+	return &IdempotencyStore{
+		ttl:      ttl,
+		maxBytes: int64(maxMB) << 20,
+		entries:  make(map[string]*idempotentResponse),
+		order:    list.New(),
+	}
+}
+
+// responseRecorder captures a handler's response so it can be stored and replayed
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header { return r.header }
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+// fingerprintBody hashes a request body as the handler reads it
+type fingerprintBody struct {
+	body io.ReadCloser
+	hash hash.Hash
+}
+
+// newFingerprintBody starts the fingerprint of r with its query string
+func newFingerprintBody(r *http.Request) *fingerprintBody {
+	b := &fingerprintBody{body: r.Body, hash: sha256.New()}
+	io.WriteString(b.hash, r.URL.RawQuery+"\n")
+	return b
+}
+
+func (b *fingerprintBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.hash.Write(p[:n])
+	return n, err
+}
+
+// Close hashes what the handler left unread before closing the body
+func (b *fingerprintBody) Close() error {
+	io.Copy(b.hash, b.body)
+	return b.body.Close()
+}
+
+// sum hashes the rest of the body and returns the fingerprint
+func (b *fingerprintBody) sum() string {
+	io.Copy(b.hash, b.body)
+	return hex.EncodeToString(b.hash.Sum(nil))
+}
+
+// Wrap executes next at most once per Idempotency-Key, caller and endpoint
+// while the key is retained. Requests without the header are passed through
+// unchanged. A key reused with a different query or body is rejected with
+// 422. Retryable responses, 429 and server errors including a panic in next,
+// are not retained so the client can retry them.
+func (s *IdempotencyStore) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		key = tenantScoped(r.Context(), callerFrom(r.Context())+"|"+r.URL.Path+"|"+key)
+		body := newFingerprintBody(r)
+
+		s.mu.Lock()
+		s.purgeExpired()
+		entry, exists := s.entries[key]
+		if !exists {
+			entry = &idempotentResponse{done: make(chan struct{})}
+			s.entries[key] = entry
+		}
+		s.mu.Unlock()
+
+		if exists {
+			fingerprint := body.sum()
+			<-entry.done
+			if !retryableStatus(entry.status) && fingerprint != entry.fingerprint {
+				writeJSONError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request", "")
+				return
+			}
+			log.Printf("Idempotency-Key replay for %s", key)
+			entry.replay(w)
+			return
+		}
+
+		recorder := &responseRecorder{header: make(http.Header)}
+		completed := false
+		defer func() {
+			if !completed {
+				// next panicked: waiters get a server error and the key is released
+				s.complete(key, entry, http.StatusInternalServerError, http.Header{}, nil)
+			}
+		}()
+		r.Body = body
+		next(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		entry.fingerprint = body.sum()
+		s.complete(key, entry, recorder.status, recorder.header, recorder.body.Bytes())
+		completed = true
+
+		for name, values := range entry.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(entry.status)
+		w.Write(entry.body)
+	}
+}
+
+// complete stores the response for key, dropping retryable responses and
+// those larger than the whole budget, and releases the requests waiting for
+// it. The oldest responses are evicted to stay within the budget.
+func (s *IdempotencyStore) complete(key string, entry *idempotentResponse, status int, header http.Header, body []byte) {
+	s.mu.Lock()
+	entry.status = status
+	entry.header = header
+	entry.body = body
+	entry.expires = time.Now().Add(s.ttl)
+	entry.size = responseSize(key, header, body)
+	if retryableStatus(status) || entry.size > s.maxBytes {
+		delete(s.entries, key)
+	} else {
+		entry.element = s.order.PushBack(key)
+		s.bytes += entry.size
+		for s.bytes > s.maxBytes {
+			s.evict(s.order.Front())
+		}
+	}
+	s.mu.Unlock()
+	close(entry.done)
+}
+
+// retryableStatus reports whether a response must not be replayed because a
+// retry may succeed: 429 from a full queue or quota, and server errors
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// responseSize approximates the memory held by a stored response
+func responseSize(key string, header http.Header, body []byte) int64 {
+	size := len(key) + len(body)
+	for name, values := range header {
+		size += len(name)
+		for _, value := range values {
+			size += len(value)
+		}
+	}
+	return int64(size)
+}
+
+// evict drops the stored response at element; callers must hold s.mu
+func (s *IdempotencyStore) evict(element *list.Element) {
+	key := s.order.Remove(element).(string)
+	s.bytes -= s.entries[key].size
+	delete(s.entries, key)
+}
+
+// replay writes a stored response, marking it as replayed
+func (e *idempotentResponse) replay(w http.ResponseWriter) {
+	for name, values := range e.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// purgeExpired drops stored responses past their TTL; callers must hold s.mu
+func (s *IdempotencyStore) purgeExpired() {
+	now := time.Now()
+	for element := s.order.Front(); element != nil; element = s.order.Front() {
+		if now.Before(s.entries[element.Value.(string)].expires) {
+			return
+		}
+		s.evict(element)
+	}
+}
+
+// sweep purges expired responses every interval, so they are freed even
+// when no requests arrive
+func (s *IdempotencyStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.mu.Lock()
+		s.purgeExpired()
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// idempotentRequest builds a POST with an Idempotency-Key from caller
+func idempotentRequest(caller, key, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/scan", strings.NewReader(body))
+	r.Header.Set("Idempotency-Key", key)
+	return r.WithContext(context.WithValue(r.Context(), callerKey, caller))
+}
+
+func TestIdempotencyWrap(t *testing.T) {
+	tests := []struct {
+		name         string
+		caller, body string
+		wantStatus   int
+		wantReplayed bool
+		wantCalls    int32
+	}{
+		{"same caller and body", "client-a", "payload", http.StatusOK, true, 1},
+		{"different body", "client-a", "other payload", http.StatusUnprocessableEntity, false, 1},
+		{"different caller", "client-b", "payload", http.StatusOK, false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			handler := NewIdempotencyStore().Wrap(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				io.Copy(w, r.Body)
+			})
+			handler(httptest.NewRecorder(), idempotentRequest("client-a", "k1", "payload"))
+
+			rec := httptest.NewRecorder()
+			handler(rec, idempotentRequest(tt.caller, "k1", tt.body))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if replayed := rec.Header().Get("Idempotent-Replayed") == "true"; replayed != tt.wantReplayed {
+				t.Errorf("replayed = %v, want %v", replayed, tt.wantReplayed)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("handler ran %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// A key is released when the handler panics, so a retry runs again
+func TestIdempotencyWrapPanic(t *testing.T) {
+	var calls atomic.Int32
+	handler := NewIdempotencyStore().Wrap(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			panic("handler failed")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic was swallowed")
+			}
+		}()
+		handler(httptest.NewRecorder(), idempotentRequest("client-a", "k1", "payload"))
+	}()
+
+	rec := httptest.NewRecorder()
+	handler(rec, idempotentRequest("client-a", "k1", "payload"))
+	if rec.Code != http.StatusCreated || calls.Load() != 2 {
+		t.Errorf("retry status %d after %d calls, want %d after 2", rec.Code, calls.Load(), http.StatusCreated)
+	}
+}
+
+func TestIdempotencyRetainsOnlyFinalResponses(t *testing.T) {
+	tests := []struct {
+		status    int
+		wantCalls int32
+	}{
+		{http.StatusOK, 1},
+		{http.StatusBadRequest, 1},
+		{http.StatusTooManyRequests, 2},
+		{http.StatusServiceUnavailable, 2},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			var calls atomic.Int32
+			handler := NewIdempotencyStore().Wrap(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.WriteHeader(tt.status)
+			})
+			for i := 0; i < 2; i++ {
+				handler(httptest.NewRecorder(), idempotentRequest("client-a", "k1", "payload"))
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("handler ran %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestIdempotencyByteBudget(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_MAX_MB", "1")
+	store := NewIdempotencyStore()
+	var calls atomic.Int32
+	handler := store.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write(make([]byte, 400<<10))
+	})
+	for _, key := range []string{"k1", "k2", "k3"} {
+		handler(httptest.NewRecorder(), idempotentRequest("client-a", key, "payload"))
+	}
+	if store.bytes > store.maxBytes || len(store.entries) != 2 {
+		t.Errorf("store holds %d entries in %d bytes, want 2 within %d", len(store.entries), store.bytes, store.maxBytes)
+	}
+
+	// k1 was evicted and runs again; k3 is replayed
+	handler(httptest.NewRecorder(), idempotentRequest("client-a", "k1", "payload"))
+	handler(httptest.NewRecorder(), idempotentRequest("client-a", "k3", "payload"))
+	if got := calls.Load(); got != 4 {
+		t.Errorf("handler ran %d times, want 4", got)
+	}
+}
+
+func TestIdempotencyPurgeExpired(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_TTL", "1ms")
+	store := NewIdempotencyStore()
+	handler := store.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "done")
+	})
+	handler(httptest.NewRecorder(), idempotentRequest("client-a", "k1", "payload"))
+	time.Sleep(5 * time.Millisecond)
+
+	store.mu.Lock()
+	store.purgeExpired()
+	entries, bytes := len(store.entries), store.bytes
+	store.mu.Unlock()
+	if entries != 0 || bytes != 0 {
+		t.Errorf("%d entries and %d bytes left after the TTL", entries, bytes)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Jira ticket triggers (JIRA_TRIGGER)
+const (
+	jiraTriggerDetection = "detection"
+	jiraTriggerJob       = "job"
+)
+
+const defaultJiraMaxRetries = 3
+
+// jiraPlaceholders are the {name} placeholders of JIRA_FIELDS; those that do
+// not apply to the trigger are left empty
+var jiraPlaceholders = []string{
+	"identifier", "source", "bucket", "key", "malwareNames", "severity", "scanId", "detectedAt",
+	"jobId", "jobType", "target", "infected", "scanned",
+}
+
+// jiraNotifier opens a Jira issue for every detection, or for every bulk job
+// that found malware, through the REST API v2 (Jira Cloud and Data Center)
+// and attaches the verdict JSON to it
+type jiraNotifier struct {
+	baseURL    string
+	auth       string
+	project    string
+	issueType  string
+	trigger    string
+	fields     map[string]interface{} // JIRA_FIELDS, with {placeholders}
+	maxRetries int
+	client     *http.Client
+}
+
+// newJiraNotifier creates a notifier for the Jira site at baseURL (JIRA_URL)
+func newJiraNotifier(baseURL string) (*jiraNotifier, error) {
+	if !strings.HasPrefix(baseURL, "https://") && !strings.HasPrefix(baseURL, "http://") {
+		return nil, fmt.Errorf("invalid JIRA_URL %q", baseURL)
+	}
+	n := &jiraNotifier{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		project:    getEnv("JIRA_PROJECT", ""),
+		issueType:  getEnv("JIRA_ISSUE_TYPE", "Task"),
+		trigger:    strings.ToLower(getEnv("JIRA_TRIGGER", jiraTriggerDetection)),
+		maxRetries: getEnvInt("JIRA_MAX_RETRIES", defaultJiraMaxRetries),
+		client:     &http.Client{Timeout: notifyTimeout},
+	}
+	if n.project == "" {
+		return nil, fmt.Errorf("JIRA_PROJECT is required with JIRA_URL")
+	}
+	if n.trigger != jiraTriggerDetection && n.trigger != jiraTriggerJob {
+		return nil, fmt.Errorf("invalid JIRA_TRIGGER %q, expected detection or job", n.trigger)
+	}
+	switch {
+	case getEnv("JIRA_API_TOKEN", "") != "" && getEnv("JIRA_EMAIL", "") != "":
+		// Jira Cloud: basic auth with the account email and an API token
+		req, _ := http.NewRequest(http.MethodGet, n.baseURL, nil)
+		req.SetBasicAuth(getEnv("JIRA_EMAIL", ""), getEnv("JIRA_API_TOKEN", ""))
+		n.auth = req.Header.Get("Authorization")
+	case getEnv("JIRA_API_TOKEN", "") != "":
+		// Jira Data Center: personal access token
+		n.auth = "Bearer " + getEnv("JIRA_API_TOKEN", "")
+	default:
+		return nil, fmt.Errorf("JIRA_API_TOKEN is required with JIRA_URL")
+	}
+	if raw := getEnv("JIRA_FIELDS", ""); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &n.fields); err != nil {
+			return nil, fmt.Errorf("invalid JIRA_FIELDS, expected a JSON object of issue fields: %v", err)
+		}
+	}
+	return n, nil
+}
+
+func (n *jiraNotifier) Name() string {
+	return fmt.Sprintf("jira:%s (%s, per %s)", n.baseURL, n.project, n.trigger)
+}
+
+// Notify opens an issue for the detection
+func (n *jiraNotifier) Notify(ctx context.Context, event DetectionEvent) error {
+	if n.trigger != jiraTriggerDetection {
+		return nil
+	}
+	malware := strings.Join(event.MalwareNames, ", ")
+	values := map[string]string{
+		"identifier":   event.Identifier,
+		"source":       event.Source,
+		"bucket":       event.Bucket,
+		"key":          event.Key,
+		"malwareNames": malware,
+		"severity":     event.Severity,
+		"scanId":       event.ScanID,
+		"detectedAt":   event.DetectedAt,
+	}
+	if malware == "" {
+		malware = "unknown malware"
+	}
+	var description strings.Builder
+	fmt.Fprintf(&description, "FinGuard detected *%s* in {{%s}}.\n\n", malware, event.Identifier)
+	fmt.Fprintf(&description, "||Source|%s|\n||Severity|%s|\n", event.Source, event.Severity)
+	if event.ScanID != "" {
+		fmt.Fprintf(&description, "||Scan ID|%s|\n", event.ScanID)
+	}
+	fmt.Fprintf(&description, "||Detected|%s|\n", event.DetectedAt)
+	if event.Source == sourceS3 {
+		if link := quarantineLink(event.Bucket, event.Key); link != "" {
+			fmt.Fprintf(&description, "\n[Quarantine the object|%s]\n", link)
+		}
+	}
+	summary := fmt.Sprintf("Malware detected: %s in %s", malware, event.Identifier)
+	return n.open(ctx, summary, description.String(), values, event)
+}
+
+// NotifyJob opens an issue for a finished job that found malware, listing
+// the infected objects
+func (n *jiraNotifier) NotifyJob(ctx context.Context, state JobState) error {
+	if n.trigger != jiraTriggerJob || state.Infected == 0 {
+		return nil
+	}
+	infected := state
+	infected.Results = nil
+	for _, result := range state.Results {
+		if result.Verdict == "malicious" {
+			infected.Results = append(infected.Results, result)
+		}
+	}
+	values := map[string]string{
+		"jobId":    state.ID,
+		"jobType":  state.Type,
+		"target":   state.Target,
+		"infected": strconv.Itoa(state.Infected),
+		"scanned":  strconv.Itoa(state.Scanned),
+		"severity": severityHigh,
+	}
+	var description strings.Builder
+	fmt.Fprintf(&description, "FinGuard job %s (%s) found malware in %d of %d objects in {{%s}}.\n\n", state.ID, state.Type, state.Infected, state.Scanned, state.Target)
+	description.WriteString("||Object||Malware||Remediation||\n")
+	for i, result := range infected.Results {
+		if i == 50 {
+			fmt.Fprintf(&description, "\n... and %d more, see the attached verdict JSON\n", len(infected.Results)-50)
+			break
+		}
+		remediation := "-"
+		if result.Remediation != nil {
+			remediation = result.Remediation.Action + " " + result.Remediation.Status
+		}
+		fmt.Fprintf(&description, "|{{%s}}|%s|%s|\n", result.Key, strings.Join(result.MalwareNames, ", "), remediation)
+	}
+	summary := fmt.Sprintf("Malware found by scan of %s: %d infected objects", state.Target, state.Infected)
+	return n.open(ctx, summary, description.String(), values, infected)
+}
+
+// open creates the issue and attaches verdict as verdict.json
+func (n *jiraNotifier) open(ctx context.Context, summary, description string, values map[string]string, verdict interface{}) error {
+	fields := map[string]interface{}{
+		"project":     map[string]string{"key": n.project},
+		"issuetype":   map[string]string{"name": n.issueType},
+		"summary":     truncateText(summary, 255),
+		"description": description,
+	}
+	for _, name := range jiraPlaceholders {
+		if _, ok := values[name]; !ok {
+			values[name] = ""
+		}
+	}
+	for name, value := range n.fields {
+		fields[name] = fillJiraPlaceholders(value, values)
+	}
+	body, err := json.Marshal(map[string]interface{}{"fields": fields})
+	if err != nil {
+		return err
+	}
+
+	var created struct {
+		Key string `json:"key"`
+	}
+	err = retryWithBackoff(n.maxRetries, func() (bool, error) {
+		return n.do(ctx, http.MethodPost, "/rest/api/2/issue", "application/json", body, &created)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create issue: %v", err)
+	}
+
+	attachment, err := json.MarshalIndent(verdict, "", "  ")
+	if err != nil {
+		return err
+	}
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("file", "verdict.json")
+	part.Write(attachment)
+	mw.Close()
+	err = retryWithBackoff(n.maxRetries, func() (bool, error) {
+		return n.do(ctx, http.MethodPost, "/rest/api/2/issue/"+created.Key+"/attachments", mw.FormDataContentType(), form.Bytes(), nil)
+	})
+	if err != nil {
+		return fmt.Errorf("created %s but failed to attach the verdict: %v", created.Key, err)
+	}
+	return nil
+}
+
+// do sends one API request, decoding the response into out when set, and
+// reports whether a failure is worth retrying
+func (n *jiraNotifier) do(ctx context.Context, method, path, contentType string, body []byte, out interface{}) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, n.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", n.auth)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	// Attachment uploads are rejected without it as a CSRF safeguard
+	req.Header.Set("X-Atlassian-Token", "no-check")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("Jira returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return false, fmt.Errorf("invalid Jira response: %v", err)
+		}
+	}
+	return false, nil
+}
+
+// fillJiraPlaceholders replaces {name} placeholders in every string of a
+// JIRA_FIELDS value
+func fillJiraPlaceholders(value interface{}, values map[string]string) interface{} {
+	switch v := value.(type) {
+	case string:
+		for name, replacement := range values {
+			v = strings.ReplaceAll(v, "{"+name+"}", replacement)
+		}
+		return v
+	case []interface{}:
+		filled := make([]interface{}, len(v))
+		for i, item := range v {
+			filled[i] = fillJiraPlaceholders(item, values)
+		}
+		return filled
+	case map[string]interface{}:
+		filled := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			filled[key] = fillJiraPlaceholders(item, values)
+		}
+		return filled
+	}
+	return value
+}
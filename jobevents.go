@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Interval between SSE keep-alive comments
+const sseKeepAlive = 15 * time.Second
+
+// streamJobEvents streams job progress as Server-Sent Events. The first event
+// is the current state; the stream ends after the "done" event.
+func streamJobEvents(w http.ResponseWriter, r *http.Request, job *Job) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "Streaming not supported", "")
+		return
+	}
+
+	events, unsubscribe := job.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	job.mu.Lock()
+	initial := job.event(jobEventProgress, nil)
+	job.mu.Unlock()
+	if initial.State.FinishedAt != nil {
+		initial.Type = jobEventDone
+	}
+	writeSSE(w, initial)
+	flusher.Flush()
+	if initial.Type == jobEventDone {
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event := <-events:
+			writeSSE(w, event)
+			flusher.Flush()
+			if event.Type == jobEventDone {
+				return
+			}
+		}
+	}
+}
+
+// writeSSE writes one event in text/event-stream format
+func writeSSE(w http.ResponseWriter, event JobEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const defaultJobWorkers = 2
+
+var (
+	jobsBucket       = []byte("jobs")
+	jobResultsBucket = []byte("job-results")
+)
+
+// jobParamTypes are the request types the params of each job type decode into
+var jobParamTypes = map[string]reflect.Type{
+	jobTypeBucketScan:        reflect.TypeOf(BucketScanRequest{}),
+	jobTypeDirectoryScan:     reflect.TypeOf(DirectoryScanRequest{}),
+	jobTypeGraphDeltaScan:    reflect.TypeOf(GraphDeltaScanRequest{}),
+	jobTypeDropboxFolderScan: reflect.TypeOf(DropboxFolderScanRequest{}),
+}
+
+var secretType = reflect.TypeOf(Secret(""))
+
+// JobRunner executes a job of one type. It is called again for unfinished jobs
+// after a restart and should skip work already recorded on the job.
+type JobRunner func(ctx context.Context, job *Job) error
+
+// JobStore persists job state across restarts. States hold counters only;
+// per-object results are appended separately so large jobs are not
+// rewritten for every object.
+type JobStore interface {
+	Save(state JobState) error
+	// SaveResult appends result to the job's results and saves state
+	SaveResult(state JobState, result JobObjectResult) error
+	LoadResults(id string) ([]JobObjectResult, error)
+	LoadAll() ([]JobState, error)
+	Close() error
+}
+
+// boltJobStore keeps job states as JSON documents in a BoltDB file, and the
+// results of each job in its own bucket keyed by sequence number
+type boltJobStore struct {
+	db *bolt.DB
+}
+
+// NewBoltJobStore opens (or creates) the job database at path
+func NewBoltJobStore(path string) (JobStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(jobsBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(jobResultsBucket); err != nil {
+			return err
+		}
+		return migrateJobResults(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltJobStore{db: db}, nil
+}
+
+// migrateJobResults moves results stored inside job states by earlier
+// versions into the results buckets
+func migrateJobResults(tx *bolt.Tx) error {
+	jobs := tx.Bucket(jobsBucket)
+	var legacy []JobState
+	err := jobs.ForEach(func(_, data []byte) error {
+		var state JobState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return err
+		}
+		if len(state.Results) > 0 {
+			legacy = append(legacy, state)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, state := range legacy {
+		for _, result := range state.Results {
+			if err := appendJobResult(tx, state.ID, result); err != nil {
+				return err
+			}
+		}
+		if err := putJobState(tx, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putJobState writes state without its results
+func putJobState(tx *bolt.Tx, state JobState) error {
+	state.Results = nil
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(jobsBucket).Put([]byte(state.ID), data)
+}
+
+// appendJobResult adds result to the bucket of job id under the next sequence number
+func appendJobResult(tx *bolt.Tx, id string, result JobObjectResult) error {
+	results, err := tx.Bucket(jobResultsBucket).CreateBucketIfNotExists([]byte(id))
+	if err != nil {
+		return err
+	}
+	seq, err := results.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return results.Put(binary.BigEndian.AppendUint64(nil, seq), data)
+}
+
+func (s *boltJobStore) Save(state JobState) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJobState(tx, state)
+	})
+}
+
+func (s *boltJobStore) SaveResult(state JobState, result JobObjectResult) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := appendJobResult(tx, state.ID, result); err != nil {
+			return err
+		}
+		return putJobState(tx, state)
+	})
+}
+
+func (s *boltJobStore) LoadResults(id string) ([]JobObjectResult, error) {
+	var results []JobObjectResult
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobResultsBucket).Bucket([]byte(id))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, data []byte) error {
+			var result JobObjectResult
+			if err := json.Unmarshal(data, &result); err != nil {
+				return err
+			}
+			results = append(results, result)
+			return nil
+		})
+	})
+	return results, err
+}
+
+func (s *boltJobStore) LoadAll() ([]JobState, error) {
+	states := make([]JobState, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, data []byte) error {
+			var state JobState
+			if err := json.Unmarshal(data, &state); err != nil {
+				return err
+			}
+			states = append(states, state)
+			return nil
+		})
+	})
+	return states, err
+}
+
+func (s *boltJobStore) Close() error {
+	return s.db.Close()
+}
+
+// JobManager queues long-running operations, runs them on a fixed number of
+// workers and persists their state so unfinished jobs resume after a restart.
+type JobManager struct {
+	mu      sync.RWMutex
+	jobs    map[string]*Job
+	runners map[string]JobRunner
+	store   JobStore
+	queue   chan *Job
+	workers int
+}
+
+// NewJobManager creates a manager backed by store (nil keeps jobs in memory only)
+func NewJobManager(store JobStore, workers int) *JobManager {
+	if workers <= 0 {
+		workers = defaultJobWorkers
+	}
+	return &JobManager{
+		jobs:    make(map[string]*Job),
+		runners: make(map[string]JobRunner),
+		store:   store,
+		queue:   make(chan *Job, 1024),
+		workers: workers,
+	}
+}
+
+// newJobManagerFromEnv builds the manager from JOB_STORE_PATH and JOB_WORKERS.
+// If the store cannot be opened jobs are kept in memory.
+func newJobManagerFromEnv() *JobManager {
+	workers, _ := strconv.Atoi(getEnv("JOB_WORKERS", strconv.Itoa(defaultJobWorkers)))
+
+	var store JobStore
+	if path := getEnv("JOB_STORE_PATH", "/app/jobs.db"); path != "" {
+		var err error
+		store, err = NewBoltJobStore(path)
+		if err != nil {
+			log.Printf("Warning: failed to open job store %s, jobs will not survive restarts: %v", path, err)
+			store = nil
+		} else {
+			log.Printf("- Job Store: %s", path)
+		}
+	}
+	return NewJobManager(store, workers)
+}
+
+// RegisterRunner sets the runner for a job type; call before Start
+func (m *JobManager) RegisterRunner(jobType string, runner JobRunner) {
+	m.runners[jobType] = runner
+}
+
+// Start resumes unfinished persisted jobs and starts the workers
+func (m *JobManager) Start(ctx context.Context) {
+	if m.store != nil {
+		states, err := m.store.LoadAll()
+		if err != nil {
+			log.Printf("Warning: failed to load persisted jobs: %v", err)
+		}
+		for _, state := range states {
+			job := m.track(state)
+			if state.Status == JobCancelling {
+				// The runner was stopped by the restart
+				job.finishAs(JobCanceled)
+				continue
+			}
+			if state.Status == JobQueued || state.Status == JobRunning {
+				log.Printf("Resuming %s job %s (%d/%d scanned)", state.Type, state.ID, state.Scanned, state.Total)
+				m.queue <- job
+			}
+		}
+	}
+
+	for i := 0; i < m.workers; i++ {
+		go m.worker(ctx)
+	}
+}
+
+// Submit creates a queued job of jobType with params and enqueues it for the
+// tenant in ctx. callbackURL, if set, receives the final job state.
+func (m *JobManager) Submit(ctx context.Context, jobType, target, callbackURL string, params interface{}) (*Job, error) {
+	if _, ok := m.runners[jobType]; !ok {
+		return nil, fmt.Errorf("unknown job type %q", jobType)
+	}
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	job := m.track(JobState{
+		ID:          newJobID(),
+		Type:        jobType,
+		Status:      JobQueued,
+		Tenant:      tenantName(ctx),
+		Target:      target,
+		CallbackURL: callbackURL,
+		CreatedAt:   time.Now(),
+	})
+	job.state.Params, job.state.InlineSecrets = withoutSecretParams(jobType, raw)
+	job.params = raw
+	job.mu.Lock()
+	job.save()
+	job.mu.Unlock()
+
+	select {
+	case m.queue <- job:
+	default:
+		job.Finish(fmt.Errorf("job queue is full"))
+		return job, fmt.Errorf("job queue is full")
+	}
+	return job, nil
+}
+
+// Control pauses, resumes or cancels a job. Resumed jobs are queued again
+// and continue from their checkpoint.
+func (m *JobManager) Control(id, action string) error {
+	job, ok := m.Get(id)
+	if !ok {
+		return errJobNotFound
+	}
+	requeue, final, err := job.control(action)
+	if err != nil {
+		return err
+	}
+	if final {
+		snapshot := job.Snapshot(true)
+		sendCallback(job.state.CallbackURL, eventJobCompleted, snapshot)
+		notifyJobCompleted(snapshot)
+	}
+	if requeue {
+		select {
+		case m.queue <- job:
+		default:
+			job.Finish(fmt.Errorf("job queue is full"))
+			return fmt.Errorf("job queue is full")
+		}
+	}
+	return nil
+}
+
+// Get returns the job with the given ID
+func (m *JobManager) Get(id string) (*Job, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// List returns every known job, newest first
+func (m *JobManager) List() []*Job {
+	m.mu.RLock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	m.mu.RUnlock()
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].state.CreatedAt.After(jobs[j].state.CreatedAt)
+	})
+	return jobs
+}
+
+// track registers a job built from state and attaches the store
+func (m *JobManager) track(state JobState) *Job {
+	job := &Job{state: state, store: m.store}
+	m.mu.Lock()
+	m.jobs[state.ID] = job
+	m.mu.Unlock()
+	return job
+}
+
+// withoutSecretParams removes the Secret fields of the job type's request
+// from a JSON object, so inline credentials stay in memory and never reach
+// the job store. It reports whether any were removed.
+func withoutSecretParams(jobType string, raw json.RawMessage) (json.RawMessage, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return raw, false
+	}
+	stripped := false
+	for _, name := range secretParamNames(jobParamTypes[jobType]) {
+		if _, ok := fields[name]; ok {
+			delete(fields, name)
+			stripped = true
+		}
+	}
+	if !stripped {
+		return raw, false
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, true
+	}
+	return data, true
+}
+
+// secretParamNames returns the JSON names of the Secret fields of t,
+// including those of embedded structs
+func secretParamNames(t reflect.Type) []string {
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			names = append(names, secretParamNames(field.Type)...)
+			continue
+		}
+		if field.Type != secretType {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// worker runs queued jobs one at a time
+func (m *JobManager) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-m.queue:
+			m.run(ctx, job)
+		}
+	}
+}
+
+// run executes one job with a context that pause and cancel requests cancel
+func (m *JobManager) run(ctx context.Context, job *Job) {
+	runner := m.runners[job.state.Type]
+	if runner == nil {
+		job.Finish(fmt.Errorf("no runner for job type %q", job.state.Type))
+		return
+	}
+
+	if job.params == nil && job.state.InlineSecrets {
+		// Inline credentials are not persisted, so they are lost on restart
+		runner = func(context.Context, *Job) error {
+			return fmt.Errorf("inline credentials are not kept across restarts, submit the job again")
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(withTenant(ctx, tenantRegistry.get(job.state.Tenant)))
+	defer cancel()
+	if !job.begin(cancel) {
+		// Paused or canceled while queued
+		return
+	}
+	if job.end(runner(runCtx, job)) {
+		snapshot := job.Snapshot(true)
+		sendCallback(job.state.CallbackURL, eventJobCompleted, snapshot)
+		notifyJobCompleted(snapshot)
+	}
+}
+
+// newJobID returns a random 16-byte hex identifier
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
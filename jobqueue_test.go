@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestWithoutSecretParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		jobType string
+		raw     string
+		want    string
+	}{
+		{"inline AWS credentials", jobTypeBucketScan, `{"bucket":"b","awsAccessKey":"AKIA","awsSecretKey":"secret"}`, `{"awsAccessKey":"AKIA","bucket":"b"}`},
+		{"graph token and client secret", jobTypeGraphDeltaScan, `{"driveId":"d","accessToken":"token","clientId":"c","clientSecret":"secret"}`, `{"clientId":"c","driveId":"d"}`},
+		{"dropbox tokens and app secret", jobTypeDropboxFolderScan, `{"path":"/p","accessToken":"token","refreshToken":"refresh","appKey":"k","appSecret":"secret"}`, `{"appKey":"k","path":"/p"}`},
+		{"no credentials", jobTypeBucketScan, `{"bucket":"b"}`, `{"bucket":"b"}`},
+		{"not an object", jobTypeBucketScan, `["awsSecretKey"]`, `["awsSecretKey"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, stripped := withoutSecretParams(tt.jobType, json.RawMessage(tt.raw))
+			if string(got) != tt.want {
+				t.Errorf("withoutSecretParams(%s) = %s, want %s", tt.raw, got, tt.want)
+			}
+			if stripped != (tt.raw != tt.want) {
+				t.Errorf("withoutSecretParams(%s) reported stripped = %v", tt.raw, stripped)
+			}
+		})
+	}
+}
+
+// openTestJobStore opens a job store in a temporary directory
+func openTestJobStore(t *testing.T) (JobStore, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	store, err := NewBoltJobStore(path)
+	if err != nil {
+		t.Fatalf("NewBoltJobStore: %v", err)
+	}
+	return store, path
+}
+
+func TestJobStoreKeepsSecretsOutOfTheDatabase(t *testing.T) {
+	store, path := openTestJobStore(t)
+	jobs := NewJobManager(store, 1)
+	for _, jobType := range []string{jobTypeBucketScan, jobTypeGraphDeltaScan, jobTypeDropboxFolderScan} {
+		jobs.RegisterRunner(jobType, func(context.Context, *Job) error { return nil })
+	}
+
+	secrets := []string{"aws-secret-key", "graph-access-token", "graph-client-secret", "dropbox-access-token", "dropbox-refresh-token", "dropbox-app-secret"}
+	submissions := []struct {
+		jobType string
+		params  interface{}
+	}{
+		{jobTypeBucketScan, BucketScanRequest{S3Options: S3Options{AwsAccessKey: "AKIAEXAMPLE", AwsSecretKey: "aws-secret-key"}, Bucket: "bucket"}},
+		{jobTypeGraphDeltaScan, GraphDeltaScanRequest{GraphCredentials: GraphCredentials{AccessToken: "graph-access-token"}}},
+		{jobTypeGraphDeltaScan, GraphDeltaScanRequest{GraphCredentials: GraphCredentials{TenantID: "tenant", ClientID: "client", ClientSecret: "graph-client-secret"}}},
+		{jobTypeDropboxFolderScan, DropboxFolderScanRequest{DropboxCredentials: DropboxCredentials{AccessToken: "dropbox-access-token"}}},
+		{jobTypeDropboxFolderScan, DropboxFolderScanRequest{DropboxCredentials: DropboxCredentials{RefreshToken: "dropbox-refresh-token", AppKey: "app-key", AppSecret: "dropbox-app-secret"}}},
+	}
+	for _, submission := range submissions {
+		job, err := jobs.Submit(context.Background(), submission.jobType, "target", "", submission.params)
+		if err != nil {
+			t.Fatalf("Submit(%s): %v", submission.jobType, err)
+		}
+		want, _ := json.Marshal(submission.params)
+		if !bytes.Equal(job.Params(), want) {
+			t.Errorf("in-memory params lost the credentials: %s, want %s", job.Params(), want)
+		}
+		if !job.state.InlineSecrets {
+			t.Errorf("%s job does not record that inline secrets were removed", submission.jobType)
+		}
+		for _, secret := range secrets {
+			if bytes.Contains(job.state.Params, []byte(secret)) {
+				t.Errorf("%s job state params contain %s: %s", submission.jobType, secret, job.state.Params)
+			}
+		}
+	}
+	store.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range secrets {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("%s was written to the job store", secret)
+		}
+	}
+	for _, kept := range []string{"AKIAEXAMPLE", "client", "app-key"} {
+		if !bytes.Contains(data, []byte(kept)) {
+			t.Errorf("the job parameters were not persisted: %s is missing", kept)
+		}
+	}
+}
+
+func TestJobWithInlineSecretsFailsAfterRestart(t *testing.T) {
+	store, path := openTestJobStore(t)
+	job := &Job{store: store, state: JobState{
+		ID:            "job-1",
+		Type:          jobTypeGraphDeltaScan,
+		Status:        JobQueued,
+		Params:        json.RawMessage(`{"driveId":"d"}`),
+		InlineSecrets: true,
+	}}
+	job.mu.Lock()
+	job.save()
+	job.mu.Unlock()
+	store.Close()
+
+	store, err := NewBoltJobStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	jobs := NewJobManager(store, 1)
+	ran := make(chan struct{}, 1)
+	jobs.RegisterRunner(jobTypeGraphDeltaScan, func(context.Context, *Job) error {
+		ran <- struct{}{}
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs.Start(ctx)
+
+	resumed, ok := jobs.Get("job-1")
+	if !ok {
+		t.Fatal("the persisted job was not loaded")
+	}
+	state := waitForJob(t, resumed, func(s JobState) bool { return s.Status == JobFailed })
+	if !strings.Contains(state.Error, "inline credentials") {
+		t.Errorf("resumed job failed with %q, want lost inline credentials", state.Error)
+	}
+	select {
+	case <-ran:
+		t.Error("the runner ran without the credentials it was submitted with")
+	default:
+	}
+}
+
+func TestJobStoreAppendsResults(t *testing.T) {
+	store, _ := openTestJobStore(t)
+	defer store.Close()
+
+	job := &Job{state: JobState{ID: "job-1", Status: JobRunning}, store: store}
+	keys := []string{"a", "b", "c"}
+	for _, key := range keys {
+		job.Record(JobObjectResult{Key: key, Verdict: "clean"})
+	}
+
+	states, err := store.LoadAll()
+	if err != nil || len(states) != 1 {
+		t.Fatalf("LoadAll = %v, %v", states, err)
+	}
+	if states[0].Scanned != 3 || len(states[0].Results) != 0 {
+		t.Errorf("stored state has scanned=%d and %d inline results, want 3 and 0", states[0].Scanned, len(states[0].Results))
+	}
+	results := job.Snapshot(true).Results
+	if len(results) != len(keys) {
+		t.Fatalf("got %d results, want %d", len(results), len(keys))
+	}
+	for i, key := range keys {
+		if results[i].Key != key {
+			t.Errorf("result %d is %s, want %s", i, results[i].Key, key)
+		}
+	}
+	if done := job.CompletedKeys(); len(done) != 3 || !done["b"] {
+		t.Errorf("CompletedKeys = %v", done)
+	}
+}
+
+func TestJobStoreMigratesInlineResults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, _ := json.Marshal(JobState{ID: "old", Scanned: 2, Results: []JobObjectResult{{Key: "x"}, {Key: "y"}}})
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket(jobsBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("old"), legacy)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewBoltJobStore(path)
+	if err != nil {
+		t.Fatalf("NewBoltJobStore: %v", err)
+	}
+	defer store.Close()
+	states, _ := store.LoadAll()
+	if len(states) != 1 || len(states[0].Results) != 0 {
+		t.Errorf("state still holds results after migration: %+v", states)
+	}
+	if results, err := store.LoadResults("old"); err != nil || len(results) != 2 || results[1].Key != "y" {
+		t.Errorf("LoadResults = %+v, %v", results, err)
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job states reported by /jobs/{id}
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobPaused    = "paused"
+	JobCanceled  = "canceled"
+	// JobCancelling is reported between a cancel request and the end of the
+	// runner's in-flight scans
+	JobCancelling = "cancelling"
+)
+
+// Control actions accepted on /jobs/{id}/{action}
+const (
+	jobActionPause  = "pause"
+	jobActionResume = "resume"
+	jobActionCancel = "cancel"
+)
+
+var (
+	errJobNotFound = errors.New("job not found")
+	errJobState    = errors.New("job cannot be changed in its current state")
+)
+
+// JobObjectResult is the outcome for a single object scanned by a job
+type JobObjectResult struct {
+	Key          string   `json:"key"`
+	Verdict      string   `json:"verdict"` // clean, malicious, skipped, skipped-too-large, archived or error
+	MalwareNames []string `json:"malwareNames,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	// Skipped is true when the verdict was reused because the object is
+	// unchanged, or a scan policy rule or the size limit skipped the object
+	Skipped bool `json:"skipped,omitempty"`
+	// Remediation is set when an action was taken after a detection
+	Remediation *RemediationResult `json:"remediation,omitempty"`
+	// Result is the normalized verdict, without the raw SDK result
+	Result *NormalizedVerdict `json:"result,omitempty"`
+	// Archive is set for archived S3 objects
+	Archive *ArchiveResult `json:"archive,omitempty"`
+}
+
+// JobState is the externally visible state of a job
+type JobState struct {
+	ID          string     `json:"id"`
+	Type        string     `json:"type"`
+	Status      string     `json:"status"`
+	Tenant      string     `json:"tenant,omitempty"`
+	Target      string     `json:"target"`
+	CallbackURL string     `json:"callbackUrl,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	Total       int        `json:"total"`
+	Scanned     int        `json:"scanned"`
+	Clean       int        `json:"clean"`
+	Infected    int        `json:"infected"`
+	Failed      int        `json:"failed"`
+	Skipped     int        `json:"skipped"`
+	// DryRun is set for jobs that only report remediation. Remediations
+	// counts the actions taken, or that would be taken, by action.
+	DryRun       bool           `json:"dryRun,omitempty"`
+	Remediations map[string]int `json:"remediations,omitempty"`
+	// Cursor is the last listed key whose page was fully scanned; resumed
+	// bucket scans continue listing after it
+	Cursor string `json:"cursor,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Results are stored apart from the state when the job has a store,
+	// and only filled in by Snapshot
+	Results []JobObjectResult `json:"results,omitempty"`
+	// Params is the request without inline credentials; InlineSecrets is
+	// set when any were removed
+	Params        json.RawMessage `json:"params,omitempty"`
+	InlineSecrets bool            `json:"inlineSecrets,omitempty"`
+}
+
+// Job event types streamed on /jobs/{id}/events
+const (
+	jobEventProgress = "progress"
+	jobEventResult   = "result"
+	jobEventDone     = "done"
+)
+
+// JobEvent is a progress update sent to /jobs/{id}/events subscribers
+type JobEvent struct {
+	Type     string           `json:"type"`
+	Progress float64          `json:"progress"` // percent of objects scanned
+	State    JobState         `json:"state"`
+	Result   *JobObjectResult `json:"result,omitempty"`
+}
+
+// Job tracks a long-running bulk scan. Every state change is persisted to
+// the store, if any, and published to event subscribers. Without a store
+// per-object results are kept in memory.
+type Job struct {
+	mu          sync.Mutex
+	state       JobState
+	store       JobStore
+	params      json.RawMessage // as submitted, with credentials; never persisted
+	subscribers map[chan JobEvent]struct{}
+	// cancel stops the runner while it is active; stop records why
+	cancel context.CancelFunc
+	stop   string
+}
+
+// ID returns the job identifier
+func (j *Job) ID() string {
+	return j.state.ID
+}
+
+// Snapshot copies the job state; per-object results are only included when requested
+func (j *Job) Snapshot(includeResults bool) JobState {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	snapshot := j.state
+	snapshot.Params = nil
+	snapshot.Results = nil
+	snapshot.Remediations = maps.Clone(j.state.Remediations)
+	if includeResults {
+		snapshot.Results = j.results()
+	}
+	return snapshot
+}
+
+// results returns the per-object results; callers must hold j.mu
+func (j *Job) results() []JobObjectResult {
+	if j.store == nil {
+		return append([]JobObjectResult(nil), j.state.Results...)
+	}
+	results, err := j.store.LoadResults(j.state.ID)
+	if err != nil {
+		log.Printf("Warning: failed to load results of job %s: %v", j.state.ID, err)
+	}
+	return results
+}
+
+// Params returns the request the job was submitted with. After a restart
+// inline credentials are missing, since they are never persisted.
+func (j *Job) Params() json.RawMessage {
+	if j.params != nil {
+		return j.params
+	}
+	return j.state.Params
+}
+
+// CompletedKeys returns the objects already scanned, so resumed jobs can skip them
+func (j *Job) CompletedKeys() map[string]bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	results := j.results()
+	keys := make(map[string]bool, len(results))
+	for _, result := range results {
+		keys[result.Key] = true
+	}
+	return keys
+}
+
+// Cursor returns the listing checkpoint of the job
+func (j *Job) Cursor() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state.Cursor
+}
+
+// Subscribe returns a channel receiving the job's events and a function to
+// stop receiving them. Slow subscribers miss events rather than block the job.
+func (j *Job) Subscribe() (<-chan JobEvent, func()) {
+	ch := make(chan JobEvent, 64)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.subscribers == nil {
+		j.subscribers = make(map[chan JobEvent]struct{})
+	}
+	j.subscribers[ch] = struct{}{}
+	return ch, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		delete(j.subscribers, ch)
+	}
+}
+
+// event builds an event from the current state; callers must hold j.mu
+func (j *Job) event(eventType string, result *JobObjectResult) JobEvent {
+	state := j.state
+	state.Params = nil
+	state.Results = nil
+	state.Remediations = maps.Clone(j.state.Remediations)
+
+	progress := 0.0
+	if state.Total > 0 {
+		progress = float64(state.Scanned) * 100 / float64(state.Total)
+	} else if state.FinishedAt != nil {
+		progress = 100
+	}
+	return JobEvent{Type: eventType, Progress: progress, State: state, Result: result}
+}
+
+// publish sends an event to every subscriber; callers must hold j.mu
+func (j *Job) publish(eventType string, result *JobObjectResult) {
+	if len(j.subscribers) == 0 {
+		return
+	}
+	event := j.event(eventType, result)
+	for ch := range j.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// save writes the state, without results, to the store; callers must hold j.mu
+func (j *Job) save() {
+	if j.store == nil {
+		return
+	}
+	if err := j.store.Save(j.state); err != nil {
+		log.Printf("Warning: failed to persist job %s: %v", j.state.ID, err)
+	}
+}
+
+// Start marks the job as running with the number of objects to scan.
+// A resumed job keeps its original start time.
+func (j *Job) Start(total int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state.StartedAt == nil {
+		now := time.Now()
+		j.state.StartedAt = &now
+	}
+	j.state.Status = JobRunning
+	j.state.Total = total
+	j.save()
+	j.publish(jobEventProgress, nil)
+}
+
+// AddTotal adds objects discovered while listing to the job total
+func (j *Job) AddTotal(n int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state.Total += n
+	j.save()
+	j.publish(jobEventProgress, nil)
+}
+
+// Checkpoint records the listing cursor once every object up to it is scanned
+func (j *Job) Checkpoint(cursor string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state.Cursor = cursor
+	j.save()
+}
+
+// Record adds the outcome of one object to the job counters
+func (j *Job) Record(result JobObjectResult) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state.Scanned++
+	if result.Skipped || isSkippedVerdict(result.Verdict) {
+		j.state.Skipped++
+	}
+	switch result.Verdict {
+	case "clean", verdictAllowlisted:
+		j.state.Clean++
+	case verdictSkipped, verdictSkippedTooLarge, verdictArchived:
+		// counted as skipped above
+	case "malicious":
+		j.state.Infected++
+	default:
+		j.state.Failed++
+	}
+	if result.Remediation != nil && result.Remediation.Status != remediationFailed {
+		if j.state.Remediations == nil {
+			j.state.Remediations = map[string]int{}
+		}
+		j.state.Remediations[result.Remediation.Action]++
+	}
+	if j.store == nil {
+		j.state.Results = append(j.state.Results, result)
+	} else if err := j.store.SaveResult(j.state, result); err != nil {
+		log.Printf("Warning: failed to persist job %s result for %s: %v", j.state.ID, result.Key, err)
+	}
+	j.publish(jobEventResult, &result)
+}
+
+// SetDryRun records whether the job only reports remediation
+func (j *Job) SetDryRun(dryRun bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state.DryRun = dryRun
+}
+
+// Finish marks the job as completed, or failed when err is non-nil
+func (j *Job) Finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.state.FinishedAt = &now
+	if err != nil {
+		j.state.Status = JobFailed
+		j.state.Error = err.Error()
+	} else {
+		j.state.Status = JobCompleted
+	}
+	j.save()
+	j.publish(jobEventDone, nil)
+}
+
+// begin attaches the runner's cancel function and marks the job running in
+// the same step, so later pause and cancel requests stop the runner. It
+// returns false when the job was paused or canceled while it waited in the queue.
+func (j *Job) begin(cancel context.CancelFunc) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state.Status != JobQueued && j.state.Status != JobRunning {
+		return false
+	}
+	j.cancel = cancel
+	if j.state.Status == JobQueued {
+		j.state.Status = JobRunning
+		j.save()
+		j.publish(jobEventProgress, nil)
+	}
+	return true
+}
+
+// end settles the job after its runner returns: paused or canceled when a
+// stop was requested, otherwise completed or failed. It reports whether the
+// job reached a final state.
+func (j *Job) end(err error) bool {
+	j.mu.Lock()
+	stop := j.stop
+	j.cancel, j.stop = nil, ""
+	if stop == jobActionPause {
+		j.state.Status = JobPaused
+		j.save()
+		j.publish(jobEventProgress, nil)
+		j.mu.Unlock()
+		return false
+	}
+	j.mu.Unlock()
+
+	if stop == jobActionCancel {
+		j.finishAs(JobCanceled)
+		return true
+	}
+	j.Finish(err)
+	return true
+}
+
+// finishAs ends the job with a final status other than completed or failed
+func (j *Job) finishAs(status string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.state.FinishedAt = &now
+	j.state.Status = status
+	j.save()
+	j.publish(jobEventDone, nil)
+}
+
+// control applies a pause, resume or cancel action. A running job is stopped
+// through its context: no new objects are dispatched and in-flight scans
+// finish. requeue is true when a paused job must be queued again; final is
+// true when a job that was not running has just been canceled.
+func (j *Job) control(action string) (requeue, final bool, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := j.state.Status
+	switch action {
+	case jobActionPause:
+		if j.cancel != nil && status != JobCancelling {
+			j.stop = action
+			j.cancel()
+			return false, false, nil
+		}
+		if status == JobQueued {
+			j.state.Status = JobPaused
+			j.save()
+			j.publish(jobEventProgress, nil)
+			return false, false, nil
+		}
+	case jobActionResume:
+		if status == JobPaused {
+			j.state.Status = JobQueued
+			j.save()
+			j.publish(jobEventProgress, nil)
+			return true, false, nil
+		}
+	case jobActionCancel:
+		if j.cancel != nil && status != JobCancelling {
+			j.stop = action
+			j.cancel()
+			j.state.Status = JobCancelling
+			j.save()
+			j.publish(jobEventProgress, nil)
+			return false, false, nil
+		}
+		if status == JobQueued || status == JobPaused {
+			now := time.Now()
+			j.state.FinishedAt = &now
+			j.state.Status = JobCanceled
+			j.save()
+			j.publish(jobEventDone, nil)
+			return false, true, nil
+		}
+	}
+	return false, false, errJobState
+}
+
+// HTTP handler for job status at /jobs/{id}, live progress at
+// /jobs/{id}/events and job control at /jobs/{id}/{pause,resume,cancel}.
+// DELETE /jobs/{id} is the same as cancel; a running job answers with the
+// cancelling status and reaches canceled once its in-flight scans finish.
+func handleJobs(jobs *JobManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/"), "/")
+		if id == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing job ID", "id")
+			return
+		}
+
+		switch {
+		case action == "" && r.Method == http.MethodDelete:
+			action = jobActionCancel
+		case action == "" || action == "events":
+			if r.Method != http.MethodGet {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+		case action == jobActionPause || action == jobActionResume || action == jobActionCancel:
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		// Jobs of other tenants are reported as missing
+		job, ok := jobs.Get(id)
+		if !ok || !tenantOwns(r.Context(), job.state.Tenant) {
+			writeJSONError(w, http.StatusNotFound, "Job not found", "id")
+			return
+		}
+
+		switch action {
+		case "":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(job.Snapshot(r.URL.Query().Get("results") == "true"))
+			return
+		case "events":
+			streamJobEvents(w, r, job)
+			return
+		}
+
+		if err := jobs.Control(id, action); errors.Is(err, errJobState) {
+			writeJSONError(w, http.StatusConflict, fmt.Sprintf("Cannot %s a job that is %s", action, job.Snapshot(false).Status), "status")
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusServiceUnavailable, err.Error(), "")
+			return
+		}
+		log.Printf("Job %s: %s requested", id, action)
+
+		// A running job stops once its in-flight scans finish
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job.Snapshot(false))
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForJob polls the job until cond holds or the test times out
+func waitForJob(t *testing.T, job *Job, cond func(JobState) bool) JobState {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		state := job.Snapshot(false)
+		if cond(state) {
+			return state
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s did not reach the expected state, last status %s", job.ID(), state.Status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// newTestJobManager starts an in-memory manager whose "bucket" runner scans
+// objects until scanned reach stopAt, then blocks until its context ends
+func newTestJobManager(t *testing.T, objects, stopAt int) *JobManager {
+	t.Helper()
+	jobs := NewJobManager(nil, 1)
+	jobs.RegisterRunner("bucket", func(ctx context.Context, job *Job) error {
+		job.Start(objects)
+		for i := 0; i < objects; i++ {
+			if i == stopAt {
+				<-ctx.Done()
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			job.Record(JobObjectResult{Key: fmt.Sprintf("object-%d", i), Verdict: "clean"})
+		}
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	jobs.Start(ctx)
+	return jobs
+}
+
+func TestDeleteJobCancelsRunningScan(t *testing.T) {
+	jobs := newTestJobManager(t, 10, 3)
+	job, err := jobs.Submit(context.Background(), "bucket", "s3://bucket", "", struct{}{})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	waitForJob(t, job, func(s JobState) bool { return s.Scanned == 3 })
+
+	rec := httptest.NewRecorder()
+	handleJobs(jobs)(rec, httptest.NewRequest(http.MethodDelete, "/jobs/"+job.ID(), nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("DELETE status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+	var summary JobState
+	if err := json.NewDecoder(rec.Body).Decode(&summary); err != nil {
+		t.Fatalf("decode summary: %v", err)
+	}
+	if summary.Status != JobCancelling || summary.Scanned != 3 || summary.Total != 10 {
+		t.Errorf("summary %s with %d/%d scanned, want %s with 3/10", summary.Status, summary.Scanned, summary.Total, JobCancelling)
+	}
+
+	final := waitForJob(t, job, func(s JobState) bool { return s.FinishedAt != nil })
+	if final.Status != JobCanceled {
+		t.Errorf("status = %s, want %s", final.Status, JobCanceled)
+	}
+	if final.Scanned != 3 || final.Clean != 3 {
+		t.Errorf("partial summary scanned=%d clean=%d, want 3 and 3", final.Scanned, final.Clean)
+	}
+}
+
+// blockingBucket serves a ListObjectsV2 page of the objects in fake, with
+// sizes, and holds reads of the blocked key until release is closed
+type blockingBucket struct {
+	*fakeS3
+	keys    []string
+	blocked string
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if r.URL.Query().Get("list-type") == "2" {
+		type content struct {
+			Key  string
+			Size int
+		}
+		result := struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			KeyCount int
+			Contents []content
+		}{KeyCount: len(b.keys)}
+		for _, key := range b.keys {
+			result.Contents = append(result.Contents, content{key, len(b.object(path + "/" + key).data)})
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(result)
+		return
+	}
+	if r.Method == http.MethodGet && path == b.blocked {
+		select {
+		case <-b.entered:
+		default:
+			close(b.entered)
+		}
+		<-b.release
+	}
+	b.fakeS3.ServeHTTP(w, r)
+}
+
+func TestDeleteJobStopsBucketScan(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("OUTBOUND_ALLOWED_NETWORKS", "127.0.0.0/8")
+	objects := map[string]string{}
+	var keys []string
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("object-%d", i)
+		keys = append(keys, key)
+		objects["uploads/"+key] = "clean content " + key
+	}
+	bucket := &blockingBucket{fakeS3: newFakeS3(objects), keys: keys, blocked: "uploads/object-3", entered: make(chan struct{}), release: make(chan struct{})}
+	s3Logger = newComponentLogger(io.Discard, "s3")
+	server := httptest.NewServer(bucket)
+	defer server.Close()
+
+	jobs := NewJobManager(nil, 1)
+	jobs.RegisterRunner(jobTypeBucketScan, bucketScanRunner(newTestScanner(t)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs.Start(ctx)
+	req := BucketScanRequest{S3Options: S3Options{Region: "us-east-1", EndpointURL: server.URL, ForcePathStyle: true}, Bucket: "uploads", Workers: 1}
+	job, err := jobs.Submit(context.Background(), jobTypeBucketScan, "s3://uploads/", "", req)
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	// object-3 is being read when the job is canceled
+	select {
+	case <-bucket.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job never reached object-3")
+	}
+	rec := httptest.NewRecorder()
+	handleJobs(jobs)(rec, httptest.NewRequest(http.MethodDelete, "/jobs/"+job.ID(), nil))
+	var summary JobState
+	if rec.Code != http.StatusAccepted || json.NewDecoder(rec.Body).Decode(&summary) != nil || summary.Status != JobCancelling {
+		t.Fatalf("DELETE = %d %s, want %d with status %s", rec.Code, rec.Body, http.StatusAccepted, JobCancelling)
+	}
+	close(bucket.release)
+
+	final := waitForJob(t, job, func(s JobState) bool { return s.FinishedAt != nil })
+	if final.Status != JobCanceled {
+		t.Errorf("status = %s, want %s", final.Status, JobCanceled)
+	}
+	// The in-flight scan finishes; nothing after it is read
+	if final.Scanned != 4 || final.Clean != 4 {
+		t.Errorf("scanned=%d clean=%d, want 4 and 4", final.Scanned, final.Clean)
+	}
+	for _, key := range keys[4:] {
+		if reads := bucket.received(http.MethodGet, "uploads/"+key); len(reads) > 0 {
+			t.Errorf("%s was read after the job was canceled", key)
+		}
+	}
+}
+
+func TestDeleteJobStates(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       func(job *Job) string
+		finished   bool
+		wantStatus int
+	}{
+		{"finished job", func(job *Job) string { return "/jobs/" + job.ID() }, true, http.StatusConflict},
+		{"unknown job", func(*Job) string { return "/jobs/missing" }, false, http.StatusNotFound},
+		{"missing id", func(*Job) string { return "/jobs/" }, false, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs := newTestJobManager(t, 1, -1)
+			job, err := jobs.Submit(context.Background(), "bucket", "s3://bucket", "", struct{}{})
+			if err != nil {
+				t.Fatalf("Submit: %v", err)
+			}
+			if tt.finished {
+				waitForJob(t, job, func(s JobState) bool { return s.Status == JobCompleted })
+			}
+			rec := httptest.NewRecorder()
+			handleJobs(jobs)(rec, httptest.NewRequest(http.MethodDelete, tt.path(job), nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("DELETE status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+func TestControlAfterRunnerAttached(t *testing.T) {
+	tests := []struct {
+		action     string
+		wantFinal  bool
+		wantStatus string
+	}{
+		{jobActionCancel, true, JobCanceled},
+		{jobActionPause, false, JobPaused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			job := &Job{state: JobState{ID: "job", Status: JobQueued}}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if !job.begin(cancel) {
+				t.Fatal("begin refused a queued job")
+			}
+			if status := job.Snapshot(false).Status; status != JobRunning {
+				t.Fatalf("status after begin = %s, want %s", status, JobRunning)
+			}
+
+			// The request lands before the runner has called Start
+			_, final, err := job.control(tt.action)
+			if err != nil || final {
+				t.Fatalf("control(%s) = final %v, %v; want the runner to settle the job", tt.action, final, err)
+			}
+			if ctx.Err() == nil {
+				t.Fatal("the runner context was not canceled")
+			}
+			if final := job.end(ctx.Err()); final != tt.wantFinal {
+				t.Errorf("end reported final %v, want %v", final, tt.wantFinal)
+			}
+			if status := job.Snapshot(false).Status; status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", status, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultJWKSRefresh = time.Hour
+	// minimum time between refreshes triggered by an unknown kid
+	jwksMissRefresh = time.Minute
+)
+
+// Scopes required per endpoint group when JWT authorization is enabled
+const (
+	scopeScanWrite   = "scan:write"
+	scopeS3List      = "s3:list"
+	scopeAzureList   = "azure:list"
+	scopeGCSList     = "gcs:list"
+	scopeDriveList   = "gdrive:list"
+	scopeGraphList   = "graph:list"
+	scopeJobsRead    = "jobs:read"
+	scopeJobsWrite   = "jobs:write"
+	scopeMetricsRead = "metrics:read"
+	scopeAdmin       = "admin"
+)
+
+// requiredScope maps a request to the scope its JWT must carry. Paths not
+// listed only need a valid token.
+func requiredScope(r *http.Request) string {
+	switch {
+	case r.URL.Path == "/scan" || strings.HasPrefix(r.URL.Path, "/scan/"),
+		r.URL.Path == "/s3/scan", r.URL.Path == "/s3/scan-bucket", r.URL.Path == "/s3/quarantine",
+		r.URL.Path == "/azure/scan", r.URL.Path == "/gcs/scan", r.URL.Path == "/gdrive/scan",
+		r.URL.Path == "/graph/scan", r.URL.Path == "/graph/scan-delta",
+		r.URL.Path == "/dropbox/scan", r.URL.Path == "/dropbox/scan-folder":
+		return scopeScanWrite
+	case r.URL.Path == "/s3/buckets" || r.URL.Path == "/s3/objects":
+		return scopeS3List
+	case r.URL.Path == "/azure/containers" || r.URL.Path == "/azure/blobs":
+		return scopeAzureList
+	case r.URL.Path == "/gcs/buckets" || r.URL.Path == "/gcs/objects":
+		return scopeGCSList
+	case r.URL.Path == "/gdrive/files":
+		return scopeDriveList
+	case r.URL.Path == "/graph/files":
+		return scopeGraphList
+	case strings.HasPrefix(r.URL.Path, "/jobs/"), r.URL.Path == "/schedules", strings.HasPrefix(r.URL.Path, "/schedules/"),
+		strings.HasPrefix(r.URL.Path, "/scans/"), r.URL.Path == "/reports", strings.HasPrefix(r.URL.Path, "/reports/"),
+		strings.HasPrefix(r.URL.Path, "/compliance/"), strings.HasPrefix(r.URL.Path, "/stats/"):
+		if r.Method == http.MethodGet {
+			return scopeJobsRead
+		}
+		return scopeJobsWrite
+	case r.URL.Path == "/metrics":
+		return scopeMetricsRead
+	case strings.HasPrefix(r.URL.Path, "/admin/"), r.URL.Path == "/allowlist", strings.HasPrefix(r.URL.Path, "/allowlist/"),
+		r.URL.Path == "/blocklist", strings.HasPrefix(r.URL.Path, "/blocklist/"):
+		return scopeAdmin
+	}
+	return ""
+}
+
+// JWTValidator verifies bearer JWTs against keys published at a JWKS URL
+type JWTValidator struct {
+	jwksURL  string
+	issuer   string
+	audience string
+	refresh  time.Duration
+	client   *http.Client
+
+	mu        sync.RWMutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// newJWTValidatorFromEnv returns a validator when JWT_JWKS_URL is set, nil otherwise.
+// JWT_ISSUER and JWT_AUDIENCE are checked when set.
+func newJWTValidatorFromEnv() *JWTValidator {
+	jwksURL := os.Getenv("JWT_JWKS_URL")
+	if jwksURL == "" {
+		return nil
+	}
+	refresh, err := time.ParseDuration(getEnv("JWT_JWKS_REFRESH", defaultJWKSRefresh.String()))
+	if err != nil || refresh <= 0 {
+		refresh = defaultJWKSRefresh
+	}
+	return &JWTValidator{
+		jwksURL:  jwksURL,
+		issuer:   os.Getenv("JWT_ISSUER"),
+		audience: os.Getenv("JWT_AUDIENCE"),
+		refresh:  refresh,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		keys:     make(map[string]interface{}),
+	}
+}
+
+// JWTIdentity is the authenticated subject and its granted scopes
+type JWTIdentity struct {
+	Subject string
+	Scopes  map[string]bool
+}
+
+// Validate parses and verifies token, returning the subject and scopes
+func (v *JWTValidator) Validate(token string) (JWTIdentity, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512"}),
+		jwt.WithExpirationRequired(),
+	}
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+	if v.audience != "" {
+		opts = append(opts, jwt.WithAudience(v.audience))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, v.keyFunc, opts...)
+	if err != nil {
+		return JWTIdentity{}, err
+	}
+
+	identity := JWTIdentity{Scopes: make(map[string]bool)}
+	identity.Subject, _ = claims.GetSubject()
+	// "scope" is a space separated string (RFC 8693); some providers use "scp"
+	for _, claim := range []string{"scope", "scp"} {
+		switch value := claims[claim].(type) {
+		case string:
+			for _, s := range strings.Fields(value) {
+				identity.Scopes[s] = true
+			}
+		case []interface{}:
+			for _, s := range value {
+				if str, ok := s.(string); ok {
+					identity.Scopes[str] = true
+				}
+			}
+		}
+	}
+	return identity, nil
+}
+
+// keyFunc looks up the signing key by kid, refreshing the JWKS when it is
+// stale or the kid is unknown
+func (v *JWTValidator) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	v.mu.RLock()
+	key, ok := v.keys[kid]
+	age := time.Since(v.fetchedAt)
+	v.mu.RUnlock()
+
+	if ok && age < v.refresh {
+		return key, nil
+	}
+	if !ok && age < jwksMissRefresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if err := v.fetchKeys(); err != nil {
+		if ok {
+			// Keep using the cached key if the JWKS endpoint is unavailable
+			return key, nil
+		}
+		return nil, err
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// jsonWebKey is the subset of RFC 7517 fields needed for RSA and EC keys
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys downloads and parses the JWKS document
+func (v *JWTValidator) fetchKeys() error {
+	resp, err := v.client.Get(v.jwksURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS endpoint returned %d", resp.StatusCode)
+	}
+
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("invalid JWKS document: %v", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+	return nil
+}
+
+// publicKey converts the JWK into an *rsa.PublicKey or *ecdsa.PublicKey
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// looksLikeJWT reports whether token has the three dot separated JWS segments
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
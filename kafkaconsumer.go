@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+const (
+	defaultKafkaWorkers         = 4
+	defaultKafkaMaxRetries      = 5
+	kafkaSessionTimeout         = 30 * time.Second
+	kafkaRebalanceTimeout       = 5 * time.Minute
+	kafkaHeartbeatInterval      = 3 * time.Second
+	kafkaRequestTimeout         = 30 * time.Second
+	kafkaFetchWait              = 500 * time.Millisecond
+	kafkaPartitionFetchMaxBytes = 16 << 20
+)
+
+// KafkaConsumer reads ScanRequestMessage records from a topic as a member of a
+// consumer group, scans them and publishes ScanResultMessage records. Offsets
+// are committed after the results are acknowledged, so delivery is at least once.
+// Records whose scan failed with a retryable error are not committed, so they
+// are consumed again, up to maxRetries times.
+type KafkaConsumer struct {
+	scannerClient *amaasclient.AmaasClient
+	opts          []kgo.Opt
+	topic         string
+	resultsTopic  string
+	group         string
+	workers       int
+	maxRetries    int
+	tenant        *Tenant
+	// retries counts the failed attempts of held back records by
+	// topic/partition/offset; only the consuming goroutine uses it
+	retries map[string]int
+}
+
+// startKafkaConsumer joins the consumer group on brokers (KAFKA_BROKERS, a
+// comma-separated list of host:port) and consumes KAFKA_TOPIC in the background
+func startKafkaConsumer(ctx context.Context, scannerClient *amaasclient.AmaasClient, brokers string) error {
+	c, err := newKafkaConsumer(scannerClient, brokers)
+	if err != nil {
+		return err
+	}
+	log.Printf("- Kafka consumer: %s -> %s (group %s, %d workers, brokers %s)", c.topic, c.resultsTopic, c.group, c.workers, brokers)
+	go c.run(withBackgroundScan(withTenant(ctx, c.tenant)))
+	return nil
+}
+
+// newKafkaConsumer builds the consumer and its client options from the environment
+func newKafkaConsumer(scannerClient *amaasclient.AmaasClient, brokers string) (*KafkaConsumer, error) {
+	topic := getEnv("KAFKA_TOPIC", "")
+	resultsTopic := getEnv("KAFKA_RESULTS_TOPIC", "")
+	if topic == "" || resultsTopic == "" {
+		return nil, fmt.Errorf("KAFKA_TOPIC and KAFKA_RESULTS_TOPIC are required with KAFKA_BROKERS")
+	}
+	var startOffset kgo.Offset
+	switch start := getEnv("KAFKA_START_OFFSET", "earliest"); start {
+	case "earliest":
+		startOffset = kgo.NewOffset().AtStart()
+	case "latest":
+		startOffset = kgo.NewOffset().AtEnd()
+	default:
+		return nil, fmt.Errorf("invalid KAFKA_START_OFFSET %q, expected earliest or latest", start)
+	}
+
+	var seeds []string
+	for _, broker := range strings.Split(brokers, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			seeds = append(seeds, broker)
+		}
+	}
+	workers := getEnvInt("KAFKA_WORKERS", defaultKafkaWorkers)
+	if workers <= 0 {
+		workers = defaultKafkaWorkers
+	}
+	group := getEnv("KAFKA_GROUP_ID", "finguard")
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(seeds...),
+		kgo.ClientID(getEnv("KAFKA_CLIENT_ID", "finguard")),
+		kgo.ConsumerGroup(group),
+		kgo.ConsumeTopics(topic),
+		kgo.ConsumeResetOffset(startOffset),
+		kgo.Balancers(kgo.RoundRobinBalancer()),
+		kgo.SessionTimeout(kafkaSessionTimeout),
+		kgo.RebalanceTimeout(kafkaRebalanceTimeout),
+		kgo.HeartbeatInterval(kafkaHeartbeatInterval),
+		kgo.FetchMaxWait(kafkaFetchWait),
+		kgo.FetchMaxPartitionBytes(kafkaPartitionFetchMaxBytes),
+		// Offsets are committed by hand once results are acknowledged, and
+		// the group does not rebalance while a batch is being scanned
+		kgo.DisableAutoCommit(),
+		kgo.BlockRebalanceOnPoll(),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		kgo.DefaultProduceTopic(resultsTopic),
+		kgo.OnPartitionsAssigned(func(_ context.Context, _ *kgo.Client, assigned map[string][]int32) {
+			log.Printf("Kafka consumer: assigned %s partitions %v", topic, assigned[topic])
+		}),
+	}
+	if getEnv("KAFKA_TLS", "false") == "true" {
+		opts = append(opts, kgo.DialTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+	if mechanism := strings.ToUpper(getEnv("KAFKA_SASL_MECHANISM", "")); mechanism != "" {
+		mech, err := kafkaSASL(mechanism, getEnv("KAFKA_SASL_USERNAME", ""), getEnv("KAFKA_SASL_PASSWORD", ""))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kgo.SASL(mech))
+	}
+
+	return &KafkaConsumer{
+		scannerClient: scannerClient,
+		opts:          opts,
+		topic:         topic,
+		resultsTopic:  resultsTopic,
+		group:         group,
+		workers:       workers,
+		maxRetries:    getEnvInt("KAFKA_MAX_RETRIES", defaultKafkaMaxRetries),
+		tenant:        tenantRegistry.get(getEnv("KAFKA_TENANT", "")),
+		retries:       make(map[string]int),
+	}, nil
+}
+
+// kafkaSASL returns the SASL mechanism named by KAFKA_SASL_MECHANISM
+func kafkaSASL(mechanism, username, password string) (sasl.Mechanism, error) {
+	switch mechanism {
+	case "PLAIN":
+		return plain.Auth{User: username, Pass: password}.AsMechanism(), nil
+	case "SCRAM-SHA-256":
+		return scram.Auth{User: username, Pass: password}.AsSha256Mechanism(), nil
+	case "SCRAM-SHA-512":
+		return scram.Auth{User: username, Pass: password}.AsSha512Mechanism(), nil
+	}
+	return nil, fmt.Errorf("invalid KAFKA_SASL_MECHANISM %q, expected PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512", mechanism)
+}
+
+// run keeps the consumer in the group until ctx is canceled. A batch whose
+// results cannot be published or committed, or that holds back records for
+// another attempt, ends the session; the next one resumes from the
+// committed offsets.
+func (c *KafkaConsumer) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := c.consume(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Kafka consumer: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// consume polls, scans, publishes and commits batches with one client until
+// a batch fails or ctx is canceled. Closing the client leaves the group.
+func (c *KafkaConsumer) consume(ctx context.Context) error {
+	client, err := kgo.NewClient(c.opts...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// A poll blocks rebalances until allowed, and leaving the group is one
+		client.AllowRebalance()
+		client.Close()
+	}()
+
+	for {
+		fetches := client.PollFetches(ctx)
+		if fetches.IsClientClosed() || ctx.Err() != nil {
+			return nil
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			log.Printf("Kafka consumer: fetch %s/%d: %v", topic, partition, err)
+		})
+		if records := fetches.Records(); len(records) > 0 {
+			results := c.scanRecords(ctx, records)
+			if ctx.Err() != nil {
+				// Scans cut short by shutdown are left to the next member
+				return nil
+			}
+			done, results, held := c.committable(records, results)
+			if err := c.finish(ctx, client, done, results); err != nil {
+				return err
+			}
+			if held > 0 {
+				return fmt.Errorf("a scan failed with a retryable error, %d records will be consumed again", held)
+			}
+		}
+		client.AllowRebalance()
+	}
+}
+
+// finish publishes the results of a batch and commits its offsets. Both run
+// to completion during shutdown, so a scanned batch is not scanned again.
+func (c *KafkaConsumer) finish(ctx context.Context, client *kgo.Client, records []*kgo.Record, results []ScanResultMessage) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), kafkaRequestTimeout)
+	defer cancel()
+	if err := c.publish(ctx, client, results); err != nil {
+		return fmt.Errorf("publish results: %w", err)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	if err := client.CommitRecords(ctx, records...); err != nil {
+		return fmt.Errorf("commit offsets: %w", err)
+	}
+	return nil
+}
+
+// committable returns the records of a batch whose offsets can be committed
+// and the results to publish for them. The first record of a partition that
+// failed with a retryable error, and the records after it, are held back
+// until the record has failed maxRetries times; held counts them.
+func (c *KafkaConsumer) committable(records []*kgo.Record, results []ScanResultMessage) (done []*kgo.Record, publish []ScanResultMessage, held int) {
+	failed := make(map[int32]bool)
+	for i, record := range records {
+		if failed[record.Partition] {
+			held++
+			continue
+		}
+		key := fmt.Sprintf("%s/%d/%d", record.Topic, record.Partition, record.Offset)
+		if results[i].retryable {
+			c.retries[key]++
+			if c.retries[key] <= c.maxRetries {
+				log.Printf("Kafka consumer: scan of %s failed (attempt %d), retrying: %s", results[i].ID, c.retries[key], results[i].Error)
+				failed[record.Partition] = true
+				held++
+				continue
+			}
+		}
+		delete(c.retries, key)
+		done = append(done, record)
+		if results[i].ID != "" {
+			publish = append(publish, results[i])
+		}
+	}
+	return done, publish, held
+}
+
+// scanRecords scans records with the configured number of workers and
+// returns their results in order; records without a request get an empty one
+func (c *KafkaConsumer) scanRecords(ctx context.Context, records []*kgo.Record) []ScanResultMessage {
+	results := make([]ScanResultMessage, len(records))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(c.workers, len(records)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i] = c.scanRecord(ctx, records[i])
+			}
+		}()
+	}
+	for i := range records {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return results
+}
+
+// scanRecord parses and scans one record
+func (c *KafkaConsumer) scanRecord(ctx context.Context, record *kgo.Record) ScanResultMessage {
+	if len(record.Value) == 0 {
+		return ScanResultMessage{}
+	}
+	var req ScanRequestMessage
+	err := json.Unmarshal(record.Value, &req)
+	if req.ID == "" {
+		req.ID = string(record.Key)
+	}
+	if req.ID == "" {
+		req.ID = fmt.Sprintf("%s-%d-%d", record.Topic, record.Partition, record.Offset)
+	}
+	if err != nil {
+		log.Printf("Kafka consumer: invalid scan request at %s/%d offset %d: %v", record.Topic, record.Partition, record.Offset, err)
+		return ScanResultMessage{ID: req.ID, Verdict: "error", Error: fmt.Sprintf("invalid scan request: %v", err), ScannedAt: time.Now().UTC()}
+	}
+
+	result := scanRequestMessage(ctx, c.scannerClient, "kafka", req)
+	if result.Verdict == "malicious" {
+		log.Printf("Kafka consumer: THREAT DETECTED in %s (%s): %v", result.ID, result.Identifier, result.MalwareNames)
+	}
+	return result
+}
+
+// publish produces results to the results topic, keyed by request ID, and
+// waits for all in-sync replicas to acknowledge them
+func (c *KafkaConsumer) publish(ctx context.Context, client *kgo.Client, results []ScanResultMessage) error {
+	records := make([]*kgo.Record, 0, len(results))
+	for _, result := range results {
+		value, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		records = append(records, &kgo.Record{Topic: c.resultsTopic, Key: []byte(result.ID), Value: value})
+	}
+	return client.ProduceSync(ctx, records...).FirstErr()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// startTestKafka starts an in-memory cluster with the request and result
+// topics and configures the consumer environment for it
+func startTestKafka(t *testing.T) string {
+	t.Helper()
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(2, "scan-requests", "scan-results"))
+	if err != nil {
+		t.Fatalf("kfake: %v", err)
+	}
+	t.Cleanup(cluster.Close)
+	t.Setenv("KAFKA_TOPIC", "scan-requests")
+	t.Setenv("KAFKA_RESULTS_TOPIC", "scan-results")
+	t.Setenv("KAFKA_GROUP_ID", "finguard-test")
+	return strings.Join(cluster.ListenAddrs(), ",")
+}
+
+// runTestConsumer consumes with a fresh consumer until the returned stop function is called
+func runTestConsumer(t *testing.T, brokers string) func() {
+	t.Helper()
+	c, err := newKafkaConsumer(nil, brokers)
+	if err != nil {
+		t.Fatalf("newKafkaConsumer: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := c.consume(ctx); err != nil {
+			t.Logf("consume: %v", err)
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// readTestResults reads n results from the results topic
+func readTestResults(t *testing.T, client *kgo.Client, n int) map[string]ScanResultMessage {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	results := map[string]ScanResultMessage{}
+	for len(results) < n {
+		fetches := client.PollFetches(ctx)
+		if ctx.Err() != nil {
+			t.Fatalf("got %d of %d results: %v", len(results), n, results)
+		}
+		fetches.EachRecord(func(record *kgo.Record) {
+			var result ScanResultMessage
+			if err := json.Unmarshal(record.Value, &result); err != nil {
+				t.Errorf("invalid result %s: %v", record.Value, err)
+			}
+			if string(record.Key) != result.ID {
+				t.Errorf("result key %q, want the request ID %q", record.Key, result.ID)
+			}
+			results[result.ID] = result
+		})
+	}
+	return results
+}
+
+func TestKafkaConsumerPublishesAndCommits(t *testing.T) {
+	brokers := startTestKafka(t)
+	client, err := kgo.NewClient(kgo.SeedBrokers(brokers), kgo.ConsumeTopics("scan-results"), kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	requests := []*kgo.Record{
+		{Topic: "scan-requests", Key: []byte("k1"), Value: []byte(`{"id":"r1","uri":"ftp://host/file"}`)},
+		{Topic: "scan-requests", Key: []byte("k2"), Value: []byte(`not json`)},
+		{Topic: "scan-requests", Key: []byte("k3")}, // no request, no result
+	}
+	if err := client.ProduceSync(ctx, requests...).FirstErr(); err != nil {
+		t.Fatalf("produce: %v", err)
+	}
+
+	stop := runTestConsumer(t, brokers)
+	results := readTestResults(t, client, 2)
+	stop()
+
+	tests := []struct {
+		id    string
+		error string
+	}{
+		{"r1", "unsupported URI scheme"},
+		{"k2", "invalid scan request"},
+	}
+	for _, tt := range tests {
+		result, ok := results[tt.id]
+		if !ok {
+			t.Errorf("no result for %s", tt.id)
+			continue
+		}
+		if result.Verdict != "error" || !strings.Contains(result.Error, tt.error) {
+			t.Errorf("result for %s = %s %q, want error containing %q", tt.id, result.Verdict, result.Error, tt.error)
+		}
+	}
+
+	// A new member of the group starts after the committed offsets
+	if err := client.ProduceSync(ctx, &kgo.Record{Topic: "scan-requests", Value: []byte(`{"id":"r4","uri":"ftp://host/other"}`)}).FirstErr(); err != nil {
+		t.Fatalf("produce: %v", err)
+	}
+	stop = runTestConsumer(t, brokers)
+	defer stop()
+	if results := readTestResults(t, client, 1); len(results) != 1 || results["r4"].ID == "" {
+		t.Errorf("got %v, want only the result for r4", results)
+	}
+}
+
+func TestKafkaConsumerHoldsBackRetryableFailures(t *testing.T) {
+	c := &KafkaConsumer{maxRetries: 2, retries: make(map[string]int)}
+	records := []*kgo.Record{
+		{Topic: "scan-requests", Partition: 0, Offset: 0},
+		{Topic: "scan-requests", Partition: 0, Offset: 1},
+		{Topic: "scan-requests", Partition: 0, Offset: 2},
+		{Topic: "scan-requests", Partition: 1, Offset: 0},
+	}
+	results := []ScanResultMessage{
+		{ID: "a", Verdict: "clean"},
+		{ID: "b", Verdict: "error", Error: "scanner backend unavailable", retryable: true},
+		{ID: "c", Verdict: "clean"},
+		{ID: "d", Verdict: "error", Error: "unsupported URI scheme"},
+	}
+
+	for attempt := 1; attempt <= c.maxRetries; attempt++ {
+		done, publish, held := c.committable(records, results)
+		if len(done) != 2 || done[0] != records[0] || done[1] != records[3] || held != 2 {
+			t.Fatalf("attempt %d: committed %v and held back %d, want partition 0 offset 0 and partition 1, holding back 2", attempt, done, held)
+		}
+		if len(publish) != 2 || publish[0].ID != "a" || publish[1].ID != "d" {
+			t.Errorf("attempt %d: published %v, want a and d", attempt, publish)
+		}
+	}
+
+	// Once the retries are used up the error result is published and committed
+	done, publish, held := c.committable(records[1:3], results[1:3])
+	if len(done) != 2 || held != 0 || len(publish) != 2 || publish[0].ID != "b" {
+		t.Errorf("after %d retries: committed %d, published %v, held back %d; want both committed with the error result", c.maxRetries, len(done), publish, held)
+	}
+	if len(c.retries) != 0 {
+		t.Errorf("retry counts not cleared: %v", c.retries)
+	}
+}
+
+func TestIsRetryableMessageError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errScanQueueFull, true},
+		{fmt.Errorf("scan: %w", errScannerUnavailable), true},
+		{context.DeadlineExceeded, true},
+		{status.Error(codes.Unavailable, "connection refused"), true},
+		{errors.New("unsupported URI scheme \"ftp\""), false},
+		{status.Error(codes.InvalidArgument, "bad file"), false},
+	}
+	for _, tt := range tests {
+		if got := isRetryableMessageError(tt.err); got != tt.want {
+			t.Errorf("isRetryableMessageError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestKafkaSASL(t *testing.T) {
+	tests := []struct {
+		mechanism string
+		wantErr   bool
+	}{
+		{"PLAIN", false},
+		{"SCRAM-SHA-256", false},
+		{"SCRAM-SHA-512", false},
+		{"GSSAPI", true},
+	}
+	for _, tt := range tests {
+		mech, err := kafkaSASL(tt.mechanism, "user", "password")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("kafkaSASL(%s) error = %v, want error %v", tt.mechanism, err, tt.wantErr)
+		}
+		if err == nil && mech.Name() != tt.mechanism {
+			t.Errorf("kafkaSASL(%s) = %s", tt.mechanism, mech.Name())
+		}
+	}
+}
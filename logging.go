@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// logStdout as a log path disables file logging and writes to stdout only
+const logStdout = "stdout"
+
+type contextKey int
+
+// Request context keys
+const (
+	requestIDKey contextKey = iota
+	callerKey
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// initLogging sends all logging, including the existing log.Printf calls, through
+// a JSON slog handler writing to w. Structured fields (request_id, source, ...)
+// are added by the call sites that use slog directly.
+func initLogging(w io.Writer) {
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelInfo})
+	slog.SetDefault(slog.New(handler))
+}
+
+// openLogWriter returns a size- and age-rotated writer for path, or stdout
+// when path is "stdout". Rotation is controlled by LOG_MAX_SIZE_MB,
+// LOG_MAX_AGE_DAYS, LOG_MAX_BACKUPS and LOG_COMPRESS.
+func openLogWriter(path string) io.Writer {
+	if path == logStdout {
+		return os.Stdout
+	}
+	return &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    getEnvInt("LOG_MAX_SIZE_MB", 100),
+		MaxAge:     getEnvInt("LOG_MAX_AGE_DAYS", 7),
+		MaxBackups: getEnvInt("LOG_MAX_BACKUPS", 5),
+		Compress:   getEnv("LOG_COMPRESS", "true") == "true",
+	}
+}
+
+// getEnvInt reads a non-negative integer environment variable
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(getEnv(key, strconv.Itoa(defaultValue)))
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// newComponentLogger returns a *log.Logger that writes JSON records tagged with
+// component to w, for subsystems that keep their own log file
+func newComponentLogger(w io.Writer, component string) *log.Logger {
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelInfo})
+	return slog.NewLogLogger(handler.WithAttrs([]slog.Attr{slog.String("component", component)}), slog.LevelInfo)
+}
+
+// requestIDFrom returns the request ID stored in ctx, if any
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// loggerFrom returns the default logger annotated with the request ID from ctx
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if id := requestIDFrom(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// statusRecorder captures the response status for the access log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades take over the connection
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// withRequestID assigns every request an ID (reusing a caller-supplied
+// X-Request-ID), returns it in the X-Request-ID response header, stores it in
+// the request context and writes a structured access log line.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		slog.Info("request completed",
+			"request_id", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+// newRequestID returns a random 8-byte hex identifier
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Prometheus metrics exposed on /metrics. The source label is buffer or file
+// for uploads and s3, azure, gcs or the URI backend for object storage scans.
+var (
+	scansTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "finguard_scans_total",
+		Help: "Completed scans by source and verdict (clean, malicious, error, rejected).",
+	}, []string{"source", "verdict"})
+
+	scanDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "finguard_scan_duration_seconds",
+		Help:    "Time spent in the scanner SDK call.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"source"})
+
+	scannedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "finguard_scanned_bytes_total",
+		Help: "Bytes submitted for scanning.",
+	}, []string{"source"})
+
+	scanErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "finguard_scanner_errors_total",
+		Help: "Scanner (AMaaS) calls that returned an error.",
+	}, []string{"source"})
+
+	scanRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "finguard_scan_retries_total",
+		Help: "Scanner calls retried after a transient error.",
+	}, []string{"source"})
+
+	scansInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "finguard_scans_in_flight",
+		Help: "Scans currently in progress.",
+	})
+
+	awsClientCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "finguard_aws_client_cache_total",
+		Help: "AWS config and S3 client cache lookups by kind (config, s3) and result (hit, miss).",
+	}, []string{"kind", "result"})
+
+	s3RegionLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "finguard_s3_region_cache_total",
+		Help: "Bucket region lookups by result (hit, miss).",
+	}, []string{"result"})
+
+	s3RangeGets = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "finguard_s3_range_get_total",
+		Help: "Ranged S3 GetObject requests issued while scanning.",
+	})
+
+	s3ReadAheadHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "finguard_s3_read_ahead_hits_total",
+		Help: "S3 reads served from a read-ahead chunk already fetched or being prefetched.",
+	})
+
+	s3Prefetches = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "finguard_s3_prefetch_total",
+		Help: "S3 read-ahead chunks fetched in the background before the scanner read them.",
+	})
+
+	s3Restores = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "finguard_s3_restores_total",
+		Help: "Restores of archived S3 objects by result (started, restored, failed).",
+	}, []string{"result"})
+)
+
+// observeScan runs a scanner call inside a trace span and records its metrics.
+// The call fails fast while scanBreaker is open, waits for a slot from
+// scanLimiter and is retried on transient errors according to scanRetry. The
+// whole scan, including the wait, runs under the per-scan deadline; scan gets
+// a context that carries it.
+func observeScan(ctx context.Context, source string, size int64, scan func(ctx context.Context) (string, error)) (string, error) {
+	ctx = withScanSource(ctx, source)
+	if err := tenantFrom(ctx).allowScan(); err != nil {
+		scansTotal.WithLabelValues(source, "rejected").Inc()
+		return "", err
+	}
+	if err := scanBreaker.Allow(); err != nil {
+		scansTotal.WithLabelValues(source, "rejected").Inc()
+		return "", err
+	}
+
+	// Files over the maximum scan size are rejected, skipped or scanned in
+	// part, before they take a scan slot
+	limit := scanSizeLimitFor(source)
+	truncated := false
+	if limit.exceeded(size) {
+		switch limit.Action {
+		case oversizeSkip:
+			scansTotal.WithLabelValues(source, verdictSkippedTooLarge).Inc()
+			return tooLargeResult(size, limit), nil
+		case oversizeTruncate:
+			ctx, truncated = withScanTruncation(ctx, limit.Bytes), true
+		default:
+			scansTotal.WithLabelValues(source, "rejected").Inc()
+			return "", &ScanTooLargeError{Size: size, Limit: limit.Bytes}
+		}
+	}
+
+	timeout := scanTimeoutFor(ctx)
+	queuedAt := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	release, err := scanLimiter.Acquire(ctx)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = &ScanTimeoutError{Timeout: timeout, Elapsed: time.Since(queuedAt), Stage: scanStageQueued}
+		}
+		scanBreaker.Record(err)
+		scansTotal.WithLabelValues(source, "rejected").Inc()
+		return "", err
+	}
+	defer release()
+
+	scansInFlight.Inc()
+	defer scansInFlight.Dec()
+
+	ctx, span := tracer.Start(ctx, "scanner.scan", trace.WithAttributes(
+		attribute.String("finguard.source", source),
+		attribute.Int64("finguard.size", size),
+	))
+	defer span.End()
+
+	start := time.Now()
+	scanResult, attempts, err := scanRetry.do(ctx, source, scan)
+	scanDuration.WithLabelValues(source).Observe(time.Since(start).Seconds())
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = &ScanTimeoutError{Timeout: timeout, Elapsed: time.Since(queuedAt), Stage: scanStageScanning, Attempts: attempts, Err: err}
+	}
+	recordScanAttempts(ctx, attempts)
+	scanBreaker.Record(err)
+	span.SetAttributes(attribute.Int("finguard.attempts", attempts))
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "scan failed")
+		scanErrors.WithLabelValues(source).Inc()
+		scansTotal.WithLabelValues(source, "error").Inc()
+		// Deadlines can be set per request, so they say little about the backend
+		var timeoutErr *ScanTimeoutError
+		if !errors.As(err, &timeoutErr) {
+			scanFailures.Add(1)
+		}
+		return scanResult, err
+	}
+	scanFailures.Store(0)
+
+	if truncated {
+		scanResult, size = markTruncated(scanResult, size, limit.Bytes), limit.Bytes
+	}
+	scannedBytes.WithLabelValues(source).Add(float64(size))
+	verdict := "error"
+	if parsed, parseErr := parseScanVerdict(scanResult); parseErr == nil {
+		verdict = parsed.Label()
+	}
+	scansTotal.WithLabelValues(source, verdict).Inc()
+	span.SetAttributes(attribute.String("finguard.verdict", verdict))
+	return scanResult, err
+}
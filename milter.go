@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+const (
+	milterReadTimeout = 2 * time.Minute
+	milterVersion     = 6
+	// milterMaxPacket bounds one protocol packet; MTAs send bodies in 64 KB chunks
+	milterMaxPacket = 1 << 20
+	// milterMaxDepth bounds nested multiparts and attached messages
+	milterMaxDepth = 10
+
+	// MILTER_ACTION values for messages with infected attachments
+	milterActionReject     = "reject"
+	milterActionQuarantine = "quarantine"
+	milterActionTag        = "tag"
+)
+
+// Milter commands sent by the MTA
+const (
+	smficAbort   = 'A'
+	smficBody    = 'B'
+	smficConnect = 'C'
+	smficMacro   = 'D'
+	smficEOB     = 'E'
+	smficHelo    = 'H'
+	smficQuitNC  = 'K'
+	smficHeader  = 'L'
+	smficMail    = 'M'
+	smficEOH     = 'N'
+	smficOptNeg  = 'O'
+	smficQuit    = 'Q'
+	smficRcpt    = 'R'
+	smficData    = 'T'
+	smficUnknown = 'U'
+)
+
+// Milter replies and modification actions
+const (
+	smfirAddHeader  = 'h'
+	smfirContinue   = 'c'
+	smfirQuarantine = 'q'
+	smfirReplyCode  = 'y'
+	smfirTempFail   = 't'
+)
+
+// Negotiated capabilities: the actions requested and the protocol steps
+// skipped, since only headers and body are needed
+const (
+	smfifAddHeaders = 0x01
+	smfifQuarantine = 0x20
+
+	smfipNoConnect = 0x01
+	smfipNoHelo    = 0x02
+	smfipNoMail    = 0x04
+	smfipNoRcpt    = 0x08
+	smfipNoUnknown = 0x100
+	smfipNoData    = 0x200
+	milterSkipped  = smfipNoConnect | smfipNoHelo | smfipNoMail | smfipNoRcpt | smfipNoUnknown | smfipNoData
+)
+
+// MilterServer implements the Sendmail milter protocol so Postfix and
+// Sendmail can hand every message to finguard. Attachments are extracted from
+// the MIME structure and scanned; messages with malware are rejected,
+// quarantined or tagged according to MILTER_ACTION.
+type MilterServer struct {
+	scannerClient *amaasclient.AmaasClient
+	listener      net.Listener
+	action        string
+	acceptOnError bool
+	maxMessage    int64
+	tenant        *Tenant
+}
+
+// mailAttachment is one attachment extracted from a message
+type mailAttachment struct {
+	name string
+	data []byte
+}
+
+// startMilterListener listens for MTA connections on addr (MILTER_LISTEN_ADDR),
+// either host:port or unix:/path/to/socket
+func startMilterListener(ctx context.Context, scannerClient *amaasclient.AmaasClient, addr string) error {
+	action := getEnv("MILTER_ACTION", milterActionReject)
+	if action != milterActionReject && action != milterActionQuarantine && action != milterActionTag {
+		return fmt.Errorf("invalid MILTER_ACTION %q, expected reject, quarantine or tag", action)
+	}
+	onError := getEnv("MILTER_ON_ERROR", "tempfail")
+	if onError != "tempfail" && onError != "accept" {
+		return fmt.Errorf("invalid MILTER_ON_ERROR %q, expected tempfail or accept", onError)
+	}
+
+	network := "tcp"
+	if socket, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", socket
+		// Remove the socket left behind by a previous run
+		os.Remove(socket)
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	s := &MilterServer{
+		scannerClient: scannerClient,
+		listener:      listener,
+		action:        action,
+		acceptOnError: onError == "accept",
+		maxMessage:    int64(getEnvInt("MILTER_MAX_MESSAGE_MB", 50)) << 20,
+		tenant:        tenantRegistry.get(getEnv("MILTER_TENANT", "")),
+	}
+	log.Printf("- Milter listener: %s (action %s, on error %s, message limit %d MB)", listener.Addr(), action, onError, s.maxMessage>>20)
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go s.serve(withBackgroundScan(withTenant(ctx, s.tenant)))
+	return nil
+}
+
+// serve accepts connections until the listener is closed
+func (s *MilterServer) serve(ctx context.Context) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Milter listener stopped: %v", err)
+			}
+			return
+		}
+		go s.handle(ctx, conn)
+	}
+}
+
+// readMilterPacket reads one packet: a 4-byte big-endian length, the command
+// and its data
+func readMilterPacket(r *bufio.Reader) (byte, []byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n == 0 || n > milterMaxPacket {
+		return 0, nil, fmt.Errorf("invalid milter packet length %d", n)
+	}
+	packet := make([]byte, n)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return 0, nil, err
+	}
+	return packet[0], packet[1:], nil
+}
+
+// writeMilterPacket writes one reply packet
+func writeMilterPacket(w io.Writer, cmd byte, data []byte) error {
+	packet := make([]byte, 5+len(data))
+	binary.BigEndian.PutUint32(packet, uint32(1+len(data)))
+	packet[4] = cmd
+	copy(packet[5:], data)
+	_, err := w.Write(packet)
+	return err
+}
+
+// milterStrings joins NUL-terminated strings as used by header and reply packets
+func milterStrings(values ...string) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		buf.WriteString(v)
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// handle serves one MTA connection, which may carry several messages
+func (s *MilterServer) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(cmd byte, data []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(milterReadTimeout))
+		return writeMilterPacket(conn, cmd, data)
+	}
+
+	var actions uint32
+	var message bytes.Buffer
+	queueID := ""
+	oversized := false
+	reset := func() {
+		message.Reset()
+		queueID = ""
+		oversized = false
+	}
+	appendMessage := func(data []byte) {
+		if int64(message.Len()+len(data)) > s.maxMessage {
+			oversized = true
+			return
+		}
+		message.Write(data)
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(milterReadTimeout))
+		cmd, data, err := readMilterPacket(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("Milter: connection from %s closed: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		switch cmd {
+		case smficOptNeg:
+			if len(data) < 12 {
+				return
+			}
+			actions = binary.BigEndian.Uint32(data[4:8]) & (smfifAddHeaders | smfifQuarantine)
+			protocol := binary.BigEndian.Uint32(data[8:12]) & milterSkipped
+			resp := make([]byte, 12)
+			binary.BigEndian.PutUint32(resp[0:], milterVersion)
+			binary.BigEndian.PutUint32(resp[4:], actions)
+			binary.BigEndian.PutUint32(resp[8:], protocol)
+			err = reply(smficOptNeg, resp)
+		case smficMacro:
+			// The queue ID identifies the message in logs and scan results
+			fields := bytes.Split(bytes.TrimSuffix(data[min(1, len(data)):], []byte{0}), []byte{0})
+			for i := 0; i+1 < len(fields); i += 2 {
+				if name := string(fields[i]); name == "i" || name == "{i}" {
+					queueID = string(fields[i+1])
+				}
+			}
+		case smficConnect, smficHelo, smficMail, smficRcpt, smficData, smficUnknown, smficEOH:
+			if cmd == smficEOH {
+				appendMessage([]byte("\r\n"))
+			}
+			err = reply(smfirContinue, nil)
+		case smficHeader:
+			if name, value, ok := bytes.Cut(bytes.TrimSuffix(data, []byte{0}), []byte{0}); ok {
+				appendMessage([]byte(fmt.Sprintf("%s: %s\r\n", name, value)))
+			}
+			err = reply(smfirContinue, nil)
+		case smficBody:
+			appendMessage(data)
+			err = reply(smfirContinue, nil)
+		case smficEOB:
+			for _, packet := range s.decide(ctx, message.Bytes(), queueID, oversized, actions) {
+				if err = reply(packet.cmd, packet.data); err != nil {
+					break
+				}
+			}
+			reset()
+		case smficAbort, smficQuitNC:
+			reset()
+		case smficQuit:
+			return
+		default:
+			log.Printf("Milter: unknown command %q from %s", cmd, conn.RemoteAddr())
+			err = reply(smfirContinue, nil)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// milterPacket is one reply sent at the end of a message
+type milterPacket struct {
+	cmd  byte
+	data []byte
+}
+
+// decide scans the attachments of a complete message and returns the
+// modifications and final reply for the MTA
+func (s *MilterServer) decide(ctx context.Context, message []byte, queueID string, oversized bool, actions uint32) []milterPacket {
+	if queueID == "" {
+		queueID = "unknown"
+	}
+	var packets []milterPacket
+	addHeader := func(name, value string) {
+		if actions&smfifAddHeaders != 0 {
+			packets = append(packets, milterPacket{smfirAddHeader, milterStrings(name, value)})
+		}
+	}
+
+	var malware []string
+	var err error
+	if oversized {
+		err = fmt.Errorf("message exceeds %d MB", s.maxMessage>>20)
+	} else {
+		malware, err = s.scanMessage(ctx, message, queueID)
+	}
+	switch {
+	case err != nil:
+		log.Printf("Milter: message %s was not scanned: %v", queueID, err)
+		if !s.acceptOnError {
+			return []milterPacket{{smfirTempFail, nil}}
+		}
+		addHeader("X-Finguard-Verdict", "unscanned")
+	case len(malware) == 0:
+		if s.action == milterActionTag {
+			addHeader("X-Finguard-Verdict", "clean")
+		}
+	case s.action == milterActionReject:
+		log.Printf("Milter: rejecting message %s: %s", queueID, strings.Join(malware, ", "))
+		return []milterPacket{{smfirReplyCode, milterStrings("550 5.7.1 Message rejected: malware detected (" + strings.Join(malware, ", ") + ")")}}
+	default:
+		log.Printf("Milter: %s message %s: %s", s.action, queueID, strings.Join(malware, ", "))
+		addHeader("X-Finguard-Verdict", "malicious")
+		addHeader("X-Finguard-Malware", strings.Join(malware, ", "))
+		if s.action == milterActionQuarantine && actions&smfifQuarantine != 0 {
+			packets = append(packets, milterPacket{smfirQuarantine, milterStrings("finguard: " + strings.Join(malware, ", "))})
+		}
+	}
+	return append(packets, milterPacket{smfirContinue, nil})
+}
+
+// scanMessage scans every attachment of a message and returns the malware
+// found. Any failed scan fails the whole message.
+func (s *MilterServer) scanMessage(ctx context.Context, message []byte, queueID string) ([]string, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		return nil, fmt.Errorf("invalid message: %v", err)
+	}
+	var attachments []mailAttachment
+	if err := collectAttachments(textproto.MIMEHeader(msg.Header), msg.Body, 0, &attachments); err != nil {
+		return nil, fmt.Errorf("invalid MIME structure: %v", err)
+	}
+
+	var malware []string
+	for _, attachment := range attachments {
+		identifier := fmt.Sprintf("milter:%s/%s", queueID, attachment.name)
+		tags := buildScanTags(sourceMilter, getCustomTags(), "trigger=milter", "file_type="+path.Ext(attachment.name))
+		scanStart := time.Now()
+		scanResult, err := observeScan(ctx, sourceMilter, int64(len(attachment.data)), func(ctx context.Context) (string, error) {
+			return scanBuffer(ctx, s.scannerClient, attachment.data, identifier, tags)
+		})
+		var verdict ScanVerdict
+		if err == nil {
+			verdict, err = parseScanVerdict(scanResult)
+		}
+		if err != nil {
+			loggerFrom(ctx).Error("scan failed", "source", sourceMilter, "scan_id", identifier, "error", err)
+			return nil, err
+		}
+		reportScanOutcome(ctx, ScanOutcome{
+			Source:     sourceMilter,
+			Key:        attachment.name,
+			Identifier: identifier,
+			Tags:       tags,
+			Verdict:    verdict,
+			Duration:   time.Since(scanStart),
+			Size:       int64(len(attachment.data)),
+		})
+		if !verdict.IsSafe {
+			if len(verdict.MalwareNames) == 0 {
+				malware = append(malware, "Malware")
+			}
+			malware = append(malware, verdict.MalwareNames...)
+		}
+	}
+	log.Printf("Milter: scanned %d attachment(s) of message %s", len(attachments), queueID)
+	return malware, nil
+}
+
+// collectAttachments walks a MIME entity and appends every part that is a
+// file: parts with a filename or attachment disposition, and non-text leaf
+// parts. Attached messages are walked as well.
+func collectAttachments(header textproto.MIMEHeader, body io.Reader, depth int, out *[]mailAttachment) error {
+	if depth > milterMaxDepth {
+		return fmt.Errorf("nested more than %d levels", milterMaxDepth)
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			// Raw parts keep their Content-Transfer-Encoding for decodePart
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := collectAttachments(part.Header, part, depth+1, out); err != nil {
+				return err
+			}
+		}
+	case mediaType == "message/rfc822":
+		inner, err := mail.ReadMessage(decodePart(header, body))
+		if err != nil {
+			return err
+		}
+		return collectAttachments(textproto.MIMEHeader(inner.Header), inner.Body, depth+1, out)
+	}
+
+	disposition, dispParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	name := dispParams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	if name == "" && disposition != "attachment" && strings.HasPrefix(mediaType, "text/") {
+		return nil
+	}
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	if name == "" {
+		name = fmt.Sprintf("part-%d", len(*out)+1)
+	}
+
+	data, err := io.ReadAll(decodePart(header, body))
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %v", name, err)
+	}
+	*out = append(*out, mailAttachment{name: path.Base(name), data: data})
+	return nil
+}
+
+// decodePart undoes the Content-Transfer-Encoding of a part
+func decodePart(header textproto.MIMEHeader, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// ScanRequestMessage is a scan request received from a message broker. The
+// content is referenced by URI (any scheme /scan/uri accepts) or carried in
+// data, base64 encoded in JSON.
+type ScanRequestMessage struct {
+	ID       string            `json:"id"`
+	URI      string            `json:"uri,omitempty"`
+	Data     []byte            `json:"data,omitempty"`
+	Filename string            `json:"filename,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	Options  map[string]string `json:"options,omitempty"`
+}
+
+// ScanResultMessage is the verdict published for a ScanRequestMessage
+type ScanResultMessage struct {
+	ID           string    `json:"id"`
+	URI          string    `json:"uri,omitempty"`
+	Identifier   string    `json:"identifier,omitempty"`
+	Verdict      string    `json:"verdict"` // clean, malicious or error
+	MalwareNames []string  `json:"malwareNames,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ScannedAt    time.Time `json:"scannedAt"`
+	DurationMs   int64     `json:"durationMs"`
+	// Result is the normalized verdict, without the raw SDK result
+	Result *NormalizedVerdict `json:"result,omitempty"`
+	// retryable is set for errors that may pass on another delivery
+	retryable bool
+}
+
+// isRetryableMessageError reports whether a broker message that failed with
+// err should be delivered again rather than answered with an error result:
+// the scanner is unavailable, overloaded or timed out
+func isRetryableMessageError(err error) bool {
+	return isTransientScanError(err) ||
+		errors.Is(err, errScanQueueFull) ||
+		errors.Is(err, errScannerUnavailable) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// scanRequestMessage scans the content of a broker message. trigger names the
+// broker in the scan tags; inline payloads are reported with it as the source
+// prefix of their identifier.
+func scanRequestMessage(ctx context.Context, scannerClient *amaasclient.AmaasClient, trigger string, req ScanRequestMessage) ScanResultMessage {
+	result := ScanResultMessage{ID: req.ID, URI: req.URI, ScannedAt: time.Now().UTC()}
+	fail := func(err error) ScanResultMessage {
+		result.Verdict = "error"
+		result.Error = err.Error()
+		result.retryable = isRetryableMessageError(err)
+		return result
+	}
+
+	var (
+		source string
+		reader amaasclient.AmaasClientReader
+		err    error
+	)
+	extras := append([]string{"trigger=" + trigger}, req.Tags...)
+	switch {
+	case req.URI != "":
+		scheme, _, err := splitScanURI(req.URI)
+		if err != nil {
+			return fail(err)
+		}
+		backend, ok := readerFactories[scheme]
+		if !ok {
+			return fail(fmt.Errorf("unsupported URI scheme %q", scheme))
+		}
+		if scheme == "s3" {
+			opts := S3Options{Profile: req.Options["profile"], AwsAccessKey: req.Options["awsAccessKey"]}
+			if _, _, err := checkS3Credentials(ctx, opts); err != nil {
+				return fail(err)
+			}
+		}
+		source = backend.source
+		if reader, err = openReaderForURI(ctx, req.URI, req.Options); err != nil {
+			return fail(fmt.Errorf("failed to open %s: %v", req.URI, err))
+		}
+		extras = append(extras, "file_type="+path.Ext(req.URI))
+	case len(req.Data) > 0:
+		source = sourceUpload
+		name := req.Filename
+		if name == "" {
+			name = req.ID
+		}
+		extras = append(extras, "file_type="+path.Ext(name))
+	default:
+		return fail(fmt.Errorf("uri or data is required"))
+	}
+
+	tags := buildScanTags(source, getCustomTags(), extras...)
+	identifier := fmt.Sprintf("%s:%s", trigger, req.ID)
+	size := int64(len(req.Data))
+	if reader != nil {
+		identifier = reader.Identifier()
+		size, _ = reader.DataSize()
+	}
+	result.Identifier = identifier
+
+	scanStart := time.Now()
+	scanResult, err := observeScan(ctx, source, size, func(ctx context.Context) (string, error) {
+		if reader != nil {
+			return scanReader(ctx, scannerClient, reader, tags)
+		}
+		return scanBuffer(ctx, scannerClient, req.Data, identifier, tags)
+	})
+	result.DurationMs = time.Since(scanStart).Milliseconds()
+	if err != nil {
+		loggerFrom(ctx).Error("scan failed", "source", source, "trigger", trigger, "scan_id", identifier, "error", err)
+		return fail(err)
+	}
+	verdict, err := parseScanVerdict(scanResult)
+	if err != nil {
+		return fail(err)
+	}
+
+	reportScanOutcome(ctx, ScanOutcome{
+		Source:     source,
+		Identifier: identifier,
+		Tags:       tags,
+		Verdict:    verdict,
+		Duration:   time.Since(scanStart),
+		Size:       size,
+	})
+	result.Verdict = verdict.Label()
+	result.MalwareNames = verdict.MalwareNames
+	normalized := verdict.Normalize(time.Since(scanStart), false)
+	result.Result = &normalized
+	return result
+}
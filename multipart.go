@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// FileScanResult is the verdict for one file of a multipart upload
+type FileScanResult struct {
+	Filename     string   `json:"filename"`
+	Field        string   `json:"field"`
+	Size         int64    `json:"size"`
+	IsSafe       bool     `json:"isSafe"`
+	Verdict      string   `json:"verdict"` // clean, malicious or error
+	MalwareNames []string `json:"malwareNames"`
+	ScanID       string   `json:"scanId,omitempty"`
+	DetectedType string   `json:"detectedType,omitempty"`
+	Cached       bool     `json:"cached,omitempty"`
+	Attempts     int      `json:"attempts,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	// Result is the normalized verdict shared by every scan endpoint
+	Result *NormalizedVerdict `json:"result,omitempty"`
+}
+
+// MultiScanResponse is returned for multipart/form-data uploads to /scan.
+// IsSafe is true only when every file was scanned and found clean.
+type MultiScanResponse struct {
+	IsSafe    bool             `json:"isSafe"`
+	Files     []FileScanResult `json:"files"`
+	RequestID string           `json:"requestId,omitempty"`
+}
+
+// isMultipartUpload reports whether the request body is multipart/form-data
+func isMultipartUpload(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// serveMultipartScan scans every file part of a multipart/form-data upload in
+// order and writes one verdict per file. MAX_UPLOAD_SIZE_MB applies to the
+// whole request. tagsFor returns the scan tags for a file name. The scan
+// policy is applied to each file, on top of the request's opts.
+func serveMultipartScan(w http.ResponseWriter, r *http.Request, baseClient *amaasclient.AmaasClient, scanCache *ScanCache, opts ScanOptions, tagsFor func(filename string) []string, callbackURL string) {
+	maxSize := getMaxUploadSize()
+	if r.ContentLength > maxSize {
+		writeUploadTooLarge(w, maxSize)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+
+	parts, err := r.MultipartReader()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid multipart body: "+err.Error(), "")
+		return
+	}
+
+	ctx := r.Context()
+	response := MultiScanResponse{IsSafe: true, Files: []FileScanResult{}, RequestID: requestIDFrom(ctx)}
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeUploadTooLarge(w, maxSize)
+				return
+			}
+			writeJSONError(w, http.StatusBadRequest, "Invalid multipart body: "+err.Error(), "")
+			return
+		}
+		// Plain form fields are ignored
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		data, err := io.ReadAll(part)
+		part.Close()
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeUploadTooLarge(w, maxSize)
+				return
+			}
+			writeJSONError(w, http.StatusBadRequest, "Failed to read multipart file: "+err.Error(), "")
+			return
+		}
+
+		filename := filepath.Base(part.FileName())
+		result := FileScanResult{
+			Filename:     filename,
+			Field:        part.FormName(),
+			Size:         int64(len(data)),
+			MalwareNames: []string{},
+			ScanID:       time.Now().Format("20060102150405") + "-" + filename,
+		}
+		tags := tagsFor(filename)
+
+		log.Printf("Starting multipart scan for file: %s (%d bytes)", result.ScanID, len(data))
+		fileCtx, scanClient, fileOpts := scanPolicyClient(ctx, baseClient, ScanTarget{
+			Source: sourceUpload, Key: filename, ContentType: part.Header.Get("Content-Type"),
+			DetectedType: detectContentType(data), Size: int64(len(data)),
+		}, opts)
+		sum := sha256Hex(data)
+		cacheKey := ""
+		if scanCache != nil {
+			cacheKey = scanCacheKey(sum, fileOpts)
+		}
+		scanStart := time.Now()
+		fileCtx, attempts := withScanAttempts(fileCtx)
+		scanResult, cached, err := scanUnlessListed(fileCtx, scanCache, sum, filename, cacheKey, func() (string, error) {
+			return observeScan(fileCtx, "buffer", int64(len(data)), func(ctx context.Context) (string, error) {
+				return scanBuffer(ctx, scanClient, data, result.ScanID, tags)
+			})
+		})
+		if writeScanRejected(w, err) {
+			return
+		}
+		result.Cached = cached
+		result.Attempts = int(attempts.Load())
+
+		var verdict ScanVerdict
+		if err == nil {
+			verdict, err = parseScanVerdict(scanResult)
+		}
+		if err != nil {
+			loggerFrom(ctx).Error("scan failed", "scan_id", result.ScanID, "source", sourceUpload, "scan_method", "multipart", "error", err)
+			result.Verdict = "error"
+			result.Error = "Scanning failed"
+			if errors.Is(err, context.DeadlineExceeded) {
+				result.Error = "Scan timed out"
+			}
+			response.IsSafe = false
+			response.Files = append(response.Files, result)
+			continue
+		}
+
+		result.IsSafe = verdict.IsSafe
+		result.Verdict = verdict.Label()
+		result.MalwareNames = verdict.MalwareNames
+		result.DetectedType = verdict.DetectedType
+		verdict.ScanID = result.ScanID
+		normalized := verdict.Normalize(time.Since(scanStart), wantsRawResult(r))
+		result.Result = &normalized
+		if !verdict.IsSafe {
+			response.IsSafe = false
+		}
+		response.Files = append(response.Files, result)
+
+		reportScanOutcome(ctx, ScanOutcome{
+			Source:     sourceUpload,
+			Identifier: result.ScanID,
+			Tags:       tags,
+			Verdict:    verdict,
+			Duration:   time.Since(scanStart),
+			Size:       int64(len(data)),
+		})
+	}
+
+	if len(response.Files) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "No files found in multipart body", "")
+		return
+	}
+
+	log.Printf("Multipart scan completed: %d file(s), safe=%v", len(response.Files), response.IsSafe)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		return
+	}
+	sendCallback(callbackURL, eventScanCompleted, response)
+}
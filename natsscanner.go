@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nuid"
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+const (
+	defaultNATSWorkers    = 4
+	defaultNATSMaxDeliver = 5
+	natsAckWait           = 5 * time.Minute
+	natsProgressInterval  = 30 * time.Second
+	natsRetryDelay        = 30 * time.Second
+)
+
+// NATSScanner answers scan requests on a core NATS subject and consumes a
+// JetStream durable consumer. Both paths take ScanRequestMessage payloads.
+type NATSScanner struct {
+	scannerClient *amaasclient.AmaasClient
+	conn          *nats.Conn
+	resultsSubj   string
+	maxDeliver    int
+	slots         chan struct{}
+}
+
+// startNATSScanner connects to url (NATS_URL) and starts the request-reply
+// subscription (NATS_SUBJECT) and the JetStream consumer (NATS_STREAM)
+func startNATSScanner(ctx context.Context, scannerClient *amaasclient.AmaasClient, url string) error {
+	subject := getEnv("NATS_SUBJECT", "")
+	stream := getEnv("NATS_STREAM", "")
+	if subject == "" && stream == "" {
+		return fmt.Errorf("NATS_SUBJECT or NATS_STREAM is required with NATS_URL")
+	}
+	resultsSubject := getEnv("NATS_RESULTS_SUBJECT", "")
+	if stream != "" && resultsSubject == "" {
+		return fmt.Errorf("NATS_RESULTS_SUBJECT is required with NATS_STREAM")
+	}
+
+	opts := []nats.Option{nats.Name(getEnv("NATS_CLIENT_NAME", "finguard")), nats.MaxReconnects(-1)}
+	if creds := getEnv("NATS_CREDS_FILE", ""); creds != "" {
+		opts = append(opts, nats.UserCredentials(creds))
+	}
+	if token := getEnv("NATS_TOKEN", ""); token != "" {
+		opts = append(opts, nats.Token(token))
+	}
+	if user := getEnv("NATS_USER", ""); user != "" {
+		opts = append(opts, nats.UserInfo(user, getEnv("NATS_PASSWORD", "")))
+	}
+	if ca := getEnv("NATS_TLS_CA", ""); ca != "" {
+		opts = append(opts, nats.RootCAs(ca))
+	}
+	conn, err := nats.Connect(url, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS at %s: %v", url, err)
+	}
+
+	workers := getEnvInt("NATS_WORKERS", defaultNATSWorkers)
+	if workers <= 0 {
+		workers = defaultNATSWorkers
+	}
+	s := &NATSScanner{
+		scannerClient: scannerClient,
+		conn:          conn,
+		resultsSubj:   resultsSubject,
+		maxDeliver:    getEnvInt("NATS_MAX_DELIVER", defaultNATSMaxDeliver),
+		slots:         make(chan struct{}, workers),
+	}
+	scanCtx := withBackgroundScan(withTenant(ctx, tenantRegistry.get(getEnv("NATS_TENANT", ""))))
+
+	if subject != "" {
+		queue := getEnv("NATS_QUEUE_GROUP", "finguard")
+		_, err := conn.QueueSubscribe(subject, queue, func(msg *nats.Msg) {
+			s.dispatch(func() { s.handleRequest(scanCtx, msg) })
+		})
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to subscribe to %s: %v", subject, err)
+		}
+		log.Printf("- NATS request-reply: %s (queue group %s, %d workers)", subject, queue, workers)
+	}
+
+	if stream != "" {
+		js, err := jetstream.New(conn)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		durable := getEnv("NATS_CONSUMER", "finguard")
+		consumer, err := js.CreateOrUpdateConsumer(ctx, stream, jetstream.ConsumerConfig{
+			Durable:       durable,
+			FilterSubject: getEnv("NATS_STREAM_SUBJECT", ""),
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			AckWait:       natsAckWait,
+			MaxDeliver:    s.maxDeliver,
+			MaxAckPending: workers * 4,
+		})
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to create JetStream consumer %s on stream %s: %v", durable, stream, err)
+		}
+		_, err = consumer.Consume(func(msg jetstream.Msg) {
+			s.dispatch(func() { s.handleStreamMessage(scanCtx, msg) })
+		}, jetstream.PullMaxMessages(workers), jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+			log.Printf("NATS JetStream consumer %s: %v", durable, err)
+		}))
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to consume from %s: %v", stream, err)
+		}
+		log.Printf("- NATS JetStream: stream %s, durable consumer %s -> %s (%d workers)", stream, durable, resultsSubject, workers)
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Drain()
+	}()
+	return nil
+}
+
+// dispatch runs fn once a worker slot is free. It blocks the subscription
+// callback meanwhile, so the client buffers further messages.
+func (s *NATSScanner) dispatch(fn func()) {
+	s.slots <- struct{}{}
+	go func() {
+		defer func() { <-s.slots }()
+		fn()
+	}()
+}
+
+// scan parses and scans one message payload; requests without an id get
+// defaultID. valid is false when the payload is not a scan request.
+func (s *NATSScanner) scan(ctx context.Context, subject string, data []byte, defaultID string) (result ScanResultMessage, valid bool) {
+	var req ScanRequestMessage
+	if err := json.Unmarshal(data, &req); err != nil {
+		return ScanResultMessage{ID: defaultID, Verdict: "error", Error: fmt.Sprintf("invalid scan request: %v", err), ScannedAt: time.Now().UTC()}, false
+	}
+	if req.ID == "" {
+		req.ID = defaultID
+	}
+	result = scanRequestMessage(ctx, s.scannerClient, "nats", req)
+	if result.Verdict == "malicious" {
+		log.Printf("NATS %s: THREAT DETECTED in %s (%s): %v", subject, result.ID, result.Identifier, result.MalwareNames)
+	}
+	return result, true
+}
+
+// handleRequest answers a request-reply message. Requests published without
+// a reply subject get their result on NATS_RESULTS_SUBJECT, if set.
+func (s *NATSScanner) handleRequest(ctx context.Context, msg *nats.Msg) {
+	result, _ := s.scan(ctx, msg.Subject, msg.Data, nuid.Next())
+	body, _ := json.Marshal(result)
+	switch {
+	case msg.Reply != "":
+		if err := msg.Respond(body); err != nil {
+			log.Printf("NATS %s: failed to reply to %s: %v", msg.Subject, result.ID, err)
+		}
+	case s.resultsSubj != "":
+		if err := s.conn.Publish(s.resultsSubj, body); err != nil {
+			log.Printf("NATS %s: failed to publish result of %s: %v", msg.Subject, result.ID, err)
+		}
+	}
+}
+
+// handleStreamMessage scans a JetStream message, publishes the result and
+// acknowledges it. Failed scans are redelivered after natsRetryDelay until
+// NATS_MAX_DELIVER attempts; the last failure is published as an error result.
+func (s *NATSScanner) handleStreamMessage(ctx context.Context, msg jetstream.Msg) {
+	// Keep the message from being redelivered while a long scan runs
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(natsProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				msg.InProgress()
+			}
+		}
+	}()
+
+	meta, err := msg.Metadata()
+	if err != nil {
+		log.Printf("NATS %s: %v", msg.Subject(), err)
+		msg.Term()
+		return
+	}
+	result, valid := s.scan(ctx, msg.Subject(), msg.Data(), fmt.Sprintf("%s-%d", meta.Stream, meta.Sequence.Stream))
+	if result.Verdict == "error" && valid {
+		if s.maxDeliver <= 0 || int(meta.NumDelivered) < s.maxDeliver {
+			log.Printf("NATS %s: scan of %s failed (delivery %d), retrying: %s", msg.Subject(), result.ID, meta.NumDelivered, result.Error)
+			msg.NakWithDelay(natsRetryDelay)
+			return
+		}
+	}
+
+	body, _ := json.Marshal(result)
+	if err := s.conn.Publish(s.resultsSubj, body); err != nil {
+		log.Printf("NATS %s: failed to publish result of %s: %v", msg.Subject(), result.ID, err)
+		msg.Nak()
+		return
+	}
+	if err := s.conn.Flush(); err != nil {
+		msg.Nak()
+		return
+	}
+	if result.Verdict == "error" {
+		msg.Term()
+		return
+	}
+	msg.Ack()
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const notifyTimeout = 30 * time.Second
+
+// DetectionEvent describes a malware detection for downstream notifiers
+type DetectionEvent struct {
+	Tenant       string   `json:"tenant,omitempty"`
+	Source       string   `json:"source"`
+	Bucket       string   `json:"bucket,omitempty"`
+	Key          string   `json:"key,omitempty"`
+	Identifier   string   `json:"identifier"`
+	MalwareNames []string `json:"malwareNames"`
+	Severity     string   `json:"severity"`
+	ScanID       string   `json:"scanId,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	DetectedAt   string   `json:"detectedAt"`
+}
+
+// Detection severities, from most to least urgent
+const (
+	severityCritical = "critical"
+	severityHigh     = "high"
+	severityLow      = "low"
+)
+
+// detectionSeverity rates a detection by its malware names: ransomware,
+// backdoors and worms are critical, test signatures such as EICAR are low and
+// everything else is high
+func detectionSeverity(malwareNames []string) string {
+	severity := severityLow
+	for _, name := range malwareNames {
+		lower := strings.ToLower(name)
+		switch {
+		case strings.Contains(lower, "ransom"), strings.Contains(lower, "backdoor"),
+			strings.HasPrefix(lower, "bkdr"), strings.HasPrefix(lower, "worm"):
+			return severityCritical
+		case !strings.Contains(lower, "eicar"):
+			severity = severityHigh
+		}
+	}
+	if len(malwareNames) == 0 {
+		return severityHigh
+	}
+	return severity
+}
+
+// ScanCompletedEvent is the normalized record emitted for every finished scan
+type ScanCompletedEvent struct {
+	RequestID    string   `json:"requestId,omitempty"`
+	Tenant       string   `json:"tenant,omitempty"`
+	Source       string   `json:"source"`
+	Identifier   string   `json:"identifier"`
+	Bucket       string   `json:"bucket,omitempty"`
+	Key          string   `json:"key,omitempty"`
+	Verdict      string   `json:"verdict"`
+	MalwareNames []string `json:"malwareNames"`
+	ScanID       string   `json:"scanId,omitempty"`
+	FileSHA1     string   `json:"fileSha1,omitempty"`
+	FileSHA256   string   `json:"fileSha256,omitempty"`
+	DetectedType string   `json:"detectedType,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	CompletedAt  string   `json:"completedAt"`
+}
+
+// RemediationEvent records a remediation action taken on an infected object
+type RemediationEvent struct {
+	RequestID string            `json:"requestId,omitempty"`
+	Tenant    string            `json:"tenant,omitempty"`
+	Client    string            `json:"client,omitempty"`
+	Bucket    string            `json:"bucket"`
+	Key       string            `json:"key"`
+	Result    RemediationResult `json:"result"`
+	At        string            `json:"at"`
+}
+
+// ScanOutcome is what each scan path reports once the verdict is known
+type ScanOutcome struct {
+	Source     string
+	Bucket     string
+	Key        string
+	ETag       string // S3 objects only
+	VersionID  string // S3 objects only
+	Identifier string
+	Tags       []string
+	Verdict    ScanVerdict
+	Duration   time.Duration
+	Size       int64 // bytes scanned, when known
+}
+
+// Notifier delivers detection events to an external system
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, event DetectionEvent) error
+}
+
+// ScanEventSink receives an event for every completed scan
+type ScanEventSink interface {
+	Name() string
+	Emit(ctx context.Context, event ScanCompletedEvent) error
+}
+
+// RemediationEventSink is a ScanEventSink that also receives remediation actions
+type RemediationEventSink interface {
+	EmitRemediation(ctx context.Context, event RemediationEvent) error
+}
+
+// JobNotifier is a Notifier that is also told when a bulk job finishes
+type JobNotifier interface {
+	NotifyJob(ctx context.Context, state JobState) error
+}
+
+// Notifiers and sinks configured by initNotifiers, replaced on reload
+var (
+	notifyMu       sync.RWMutex
+	notifiers      []Notifier
+	scanEventSinks []ScanEventSink
+)
+
+// initNotifiers enables the notifiers and event sinks configured in the
+// environment, replacing any configured before
+func initNotifiers(ctx context.Context) {
+	var (
+		newNotifiers []Notifier
+		newSinks     []ScanEventSink
+	)
+	if topicARN := os.Getenv("SNS_TOPIC_ARN"); topicARN != "" {
+		n, err := newSNSNotifier(ctx, topicARN)
+		if err != nil {
+			log.Printf("Warning: SNS notifications disabled: %v", err)
+		} else {
+			newNotifiers = append(newNotifiers, n)
+		}
+	}
+
+	if busName := os.Getenv("EVENTBRIDGE_BUS_NAME"); busName != "" {
+		s, err := newEventBridgeSink(ctx, busName)
+		if err != nil {
+			log.Printf("Warning: EventBridge events disabled: %v", err)
+		} else {
+			newSinks = append(newSinks, s)
+		}
+	}
+
+	if hecURL := os.Getenv("SPLUNK_HEC_URL"); hecURL != "" {
+		s, err := newSplunkHECSink(hecURL)
+		if err != nil {
+			log.Printf("Warning: Splunk HEC events disabled: %v", err)
+		} else {
+			newSinks = append(newSinks, s)
+		}
+	}
+
+	if esURL := os.Getenv("ELASTICSEARCH_URL"); esURL != "" {
+		s, err := newElasticsearchSink(esURL)
+		if err != nil {
+			log.Printf("Warning: Elasticsearch indexing disabled: %v", err)
+		} else {
+			newSinks = append(newSinks, s)
+		}
+	}
+
+	if webhookURL := os.Getenv("SLACK_WEBHOOK_URL"); webhookURL != "" {
+		n, err := newSlackNotifier(webhookURL)
+		if err != nil {
+			log.Printf("Warning: Slack notifications disabled: %v", err)
+		} else {
+			newNotifiers = append(newNotifiers, n)
+		}
+	}
+
+	if webhookURL := os.Getenv("TEAMS_WEBHOOK_URL"); webhookURL != "" {
+		n, err := newTeamsNotifier(webhookURL)
+		if err != nil {
+			log.Printf("Warning: Teams notifications disabled: %v", err)
+		} else {
+			newNotifiers = append(newNotifiers, n)
+		}
+	}
+
+	if routingKey := os.Getenv("PAGERDUTY_ROUTING_KEY"); routingKey != "" {
+		n, err := newPagerDutyNotifier(routingKey)
+		if err != nil {
+			log.Printf("Warning: PagerDuty incidents disabled: %v", err)
+		} else {
+			newNotifiers = append(newNotifiers, n)
+		}
+	}
+
+	if jiraURL := os.Getenv("JIRA_URL"); jiraURL != "" {
+		n, err := newJiraNotifier(jiraURL)
+		if err != nil {
+			log.Printf("Warning: Jira issues disabled: %v", err)
+		} else {
+			newNotifiers = append(newNotifiers, n)
+		}
+	}
+
+	if smtpHost := os.Getenv("SMTP_HOST"); smtpHost != "" {
+		n, err := newEmailNotifier(smtpHost)
+		if err != nil {
+			log.Printf("Warning: email notifications disabled: %v", err)
+		} else {
+			// Alerts go through the notifier list, digest counts through the sinks
+			newNotifiers = append(newNotifiers, n)
+			newSinks = append(newSinks, n)
+		}
+	}
+
+	if path := os.Getenv("WEBHOOKS_FILE"); path != "" {
+		webhooks, err := loadOutboundWebhooks(path)
+		if err != nil {
+			log.Printf("Warning: outbound webhooks disabled: %v", err)
+		}
+		// Detections and jobs go through the notifier list, scans and
+		// remediation through the sinks
+		for _, w := range webhooks {
+			newNotifiers = append(newNotifiers, w)
+			newSinks = append(newSinks, w)
+		}
+	}
+
+	if syslogAddr := os.Getenv("SIEM_SYSLOG_ADDR"); syslogAddr != "" {
+		s, err := newSyslogSink(syslogAddr)
+		if err != nil {
+			log.Printf("Warning: SIEM forwarding disabled: %v", err)
+		} else {
+			newSinks = append(newSinks, s)
+		}
+	}
+
+	for _, n := range newNotifiers {
+		log.Printf("- Detection notifier: %s", n.Name())
+	}
+	for _, s := range newSinks {
+		log.Printf("- Scan event sink: %s", s.Name())
+	}
+
+	notifyMu.Lock()
+	oldNotifiers, oldSinks := notifiers, scanEventSinks
+	notifiers, scanEventSinks = newNotifiers, newSinks
+	notifyMu.Unlock()
+
+	// Notifiers and sinks that buffer events flush them before they are dropped
+	for _, n := range oldNotifiers {
+		if closer, ok := n.(io.Closer); ok {
+			go closer.Close()
+		}
+	}
+	for _, s := range oldSinks {
+		if closer, ok := s.(io.Closer); ok {
+			go closer.Close()
+		}
+	}
+}
+
+// currentNotifiers returns the configured notifiers and scan event sinks
+func currentNotifiers() ([]Notifier, []ScanEventSink) {
+	notifyMu.RLock()
+	defer notifyMu.RUnlock()
+	return notifiers, scanEventSinks
+}
+
+// reportScanOutcome writes the structured scan log line, emits the
+// scan.completed event, saves the scan history record and, for malicious verdicts, notifies the detection notifiers
+func reportScanOutcome(ctx context.Context, outcome ScanOutcome) {
+	completedAt := time.Now()
+	now := completedAt.Format(time.RFC3339)
+	verdict := outcome.Verdict.Label()
+
+	loggerFrom(ctx).Info("scan completed",
+		"scan_id", outcome.Verdict.ScanID,
+		"tenant", tenantName(ctx),
+		"source", outcome.Source,
+		"identifier", outcome.Identifier,
+		"bucket", outcome.Bucket,
+		"key", outcome.Key,
+		"verdict", verdict,
+		"malware_names", outcome.Verdict.MalwareNames,
+		"duration_ms", outcome.Duration.Milliseconds(),
+	)
+
+	emitScanCompleted(ScanCompletedEvent{
+		RequestID:    requestIDFrom(ctx),
+		Tenant:       tenantName(ctx),
+		Source:       outcome.Source,
+		Identifier:   outcome.Identifier,
+		Bucket:       outcome.Bucket,
+		Key:          outcome.Key,
+		Verdict:      verdict,
+		MalwareNames: outcome.Verdict.MalwareNames,
+		ScanID:       outcome.Verdict.ScanID,
+		FileSHA1:     outcome.Verdict.FileSHA1,
+		FileSHA256:   outcome.Verdict.FileSHA256,
+		DetectedType: outcome.Verdict.DetectedType,
+		Tags:         outcome.Tags,
+		CompletedAt:  now,
+	})
+
+	normalized := outcome.Verdict.Normalize(outcome.Duration, false)
+	scanHistory.Record(ScanRecord{
+		ScannedAt:    completedAt,
+		RequestID:    requestIDFrom(ctx),
+		Tenant:       tenantName(ctx),
+		ScanID:       outcome.Verdict.ScanID,
+		Source:       outcome.Source,
+		Identifier:   outcome.Identifier,
+		Bucket:       outcome.Bucket,
+		Key:          outcome.Key,
+		ETag:         outcome.ETag,
+		VersionID:    outcome.VersionID,
+		FileSHA1:     outcome.Verdict.FileSHA1,
+		FileSHA256:   outcome.Verdict.FileSHA256,
+		DetectedType: outcome.Verdict.DetectedType,
+		Verdict:      verdict,
+		MalwareNames: outcome.Verdict.MalwareNames,
+		Tags:         outcome.Tags,
+		DurationMs:   outcome.Duration.Milliseconds(),
+		SizeBytes:    outcome.Size,
+		Result:       &normalized,
+	})
+
+	if !outcome.Verdict.IsSafe {
+		notifyDetection(DetectionEvent{
+			Tenant:       tenantName(ctx),
+			Source:       outcome.Source,
+			Bucket:       outcome.Bucket,
+			Key:          outcome.Key,
+			Identifier:   outcome.Identifier,
+			MalwareNames: outcome.Verdict.MalwareNames,
+			ScanID:       outcome.Verdict.ScanID,
+			Tags:         outcome.Tags,
+			DetectedAt:   now,
+		})
+	}
+}
+
+// detectionLink fills a link template such as
+// https://console.example.com/scans/{scanId} for the detection; {identifier}
+// is also replaced. Templates needing a scan ID give "" for detections without one.
+func detectionLink(template string, event DetectionEvent) string {
+	if template == "" || (event.ScanID == "" && strings.Contains(template, "{scanId}")) {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{scanId}", url.PathEscape(event.ScanID),
+		"{identifier}", url.QueryEscape(event.Identifier),
+	).Replace(template)
+}
+
+// emitScanCompleted sends event to every scan event sink in the background
+func emitScanCompleted(event ScanCompletedEvent) {
+	_, sinks := currentNotifiers()
+	for _, s := range sinks {
+		go func(s ScanEventSink) {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := s.Emit(ctx, event); err != nil {
+				log.Printf("Event sink %s: failed to emit scan event for %s: %v", s.Name(), event.Identifier, err)
+			}
+		}(s)
+	}
+}
+
+// emitRemediation sends event to every sink that accepts remediation actions
+func emitRemediation(event RemediationEvent) {
+	if event.At == "" {
+		event.At = time.Now().Format(time.RFC3339)
+	}
+	_, sinks := currentNotifiers()
+	for _, s := range sinks {
+		rs, ok := s.(RemediationEventSink)
+		if !ok {
+			continue
+		}
+		go func(name string, rs RemediationEventSink) {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := rs.EmitRemediation(ctx, event); err != nil {
+				log.Printf("Event sink %s: failed to emit remediation of s3://%s/%s: %v", name, event.Bucket, event.Key, err)
+			}
+		}(s.Name(), rs)
+	}
+}
+
+// notifyDetection sends event to every configured notifier in the background
+func notifyDetection(event DetectionEvent) {
+	if event.DetectedAt == "" {
+		event.DetectedAt = time.Now().Format(time.RFC3339)
+	}
+	if event.Severity == "" {
+		event.Severity = detectionSeverity(event.MalwareNames)
+	}
+	current, _ := currentNotifiers()
+	for _, n := range current {
+		go func(n Notifier) {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := n.Notify(ctx, event); err != nil {
+				log.Printf("Notifier %s: failed to send detection for %s: %v", n.Name(), event.Identifier, err)
+			}
+		}(n)
+	}
+}
+
+// notifyJobCompleted sends a finished job to every notifier that accepts jobs
+func notifyJobCompleted(state JobState) {
+	current, _ := currentNotifiers()
+	for _, n := range current {
+		jn, ok := n.(JobNotifier)
+		if !ok {
+			continue
+		}
+		go func(name string, jn JobNotifier) {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := jn.NotifyJob(ctx, state); err != nil {
+				log.Printf("Notifier %s: failed to send job %s: %v", name, state.ID, err)
+			}
+		}(n.Name(), jn)
+	}
+}
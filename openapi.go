@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiParam is a path, query or header parameter of an API operation
+type apiParam struct {
+	Name        string
+	In          string // path, query or header
+	Description string
+}
+
+// apiOperation describes one route of the HTTP API. Request and Response are
+// zero values of the JSON body types; the schemas are derived from their
+// json tags so the spec cannot drift from the handlers.
+type apiOperation struct {
+	Method   string
+	Path     string
+	Summary  string
+	Tag      string
+	Params   []apiParam
+	Upload   bool // raw file body or multipart/form-data instead of JSON
+	Request  interface{}
+	Response interface{}
+	Status   int
+	Stream   bool   // text/event-stream response
+	Produces string // media type of a non-JSON response, such as text/csv
+}
+
+var scanHeaderParams = []apiParam{
+	{Name: "X-Filename", In: "header", Description: "Name of the uploaded file"},
+	{Name: "X-Scan-Method", In: "header", Description: "buffer (default) or file"},
+	{Name: "X-Callback-Url", In: "header", Description: "URL that receives the verdict once the scan completes"},
+	{Name: "X-Response-Mode", In: "header", Description: "minimal returns the verdict-only response"},
+	{Name: "X-PML-Enabled", In: "header", Description: "Enable predictive machine learning"},
+	{Name: "X-SPN-Feedback-Enabled", In: "header", Description: "Enable Smart Protection Network feedback"},
+	{Name: "X-Verbose-Enabled", In: "header", Description: "Return the verbose scan result"},
+	{Name: "X-Active-Content-Enabled", In: "header", Description: "Detect active content such as macros and scripts"},
+	{Name: "X-Digest-Enabled", In: "header", Description: "false disables digest calculation"},
+	{Name: "Idempotency-Key", In: "header", Description: "Replays the stored response for repeated requests"},
+}
+
+var (
+	scanTimeoutParam = apiParam{Name: scanTimeoutHeader, In: "header", Description: "Per-scan deadline such as 90s, up to SCAN_TIMEOUT_MAX"}
+	tenantParam      = apiParam{Name: tenantHeader, In: "header", Description: "Tenant to act for when TENANTS_FILE is set"}
+	jobIDParam       = apiParam{Name: "id", In: "path", Description: "Job ID"}
+	scheduleIDParam  = apiParam{Name: "id", In: "path", Description: "Schedule ID"}
+)
+
+var historyFilterParams = []apiParam{
+	{Name: "from", In: "query", Description: "Earliest scan time (RFC 3339)"},
+	{Name: "to", In: "query", Description: "Scans before this time (RFC 3339)"},
+	{Name: "verdict", In: "query", Description: "clean, malicious, allowlisted, skipped or skipped-too-large"},
+	{Name: "source", In: "query", Description: "Scan source such as upload, s3 or kafka"},
+	{Name: "bucket", In: "query", Description: "S3 bucket"},
+	{Name: "sha256", In: "query", Description: "SHA-256 of the file"},
+	{Name: "limit", In: "query", Description: "Maximum rows"},
+	{Name: "format", In: "query", Description: "csv (default)"},
+}
+
+var reportParams = []apiParam{
+	{Name: "period", In: "query", Description: "daily or weekly"},
+	{Name: "format", In: "query", Description: "json (default) or html"},
+}
+
+// apiOperations lists the documented routes of the scanner service
+var apiOperations = []apiOperation{
+	{Method: http.MethodPost, Path: "/scan", Tag: "scan", Summary: "Scan an uploaded file, or every file of a multipart/form-data upload",
+		Params: scanHeaderParams, Upload: true, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/scan/uri", Tag: "scan", Summary: "Scan an object addressed by URI (s3://, az://, gs://, gdrive://, msgraph://, dropbox://, https://)",
+		Request: ScanURIRequest{}, Response: ScanURIResponse{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/scan/url", Tag: "scan", Summary: "Scan the content behind a presigned or public URL",
+		Request: ScanURLRequest{}, Response: ScanURLResponse{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/scan/remote", Tag: "scan", Summary: "Download and scan a file from an allowlisted HTTPS host",
+		Request: ScanURLRequest{}, Response: ScanURLResponse{}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: wsScanPath, Tag: "scan", Summary: "Upgrade to a WebSocket, send the file in chunks and receive progress and the verdict",
+		Params: []apiParam{{Name: "access_token", In: "query", Description: "API key or JWT for browsers that cannot set headers"}}, Status: http.StatusSwitchingProtocols},
+
+	{Method: http.MethodPost, Path: "/s3/buckets", Tag: "s3", Summary: "List S3 buckets",
+		Request: S3Options{}, Response: S3ListBucketsResponse{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/s3/objects", Tag: "s3", Summary: "List objects in an S3 bucket",
+		Request: S3ListObjectsRequest{}, Response: S3ListObjectsResponse{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/s3/scan", Tag: "s3", Summary: "Scan a single S3 object",
+		Request: S3ScanRequest{}, Response: S3ScanResponse{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/s3/quarantine", Tag: "s3", Summary: "Move an S3 object to quarantine",
+		Request: S3QuarantineRequest{}, Response: RemediationResult{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/s3/scan-bucket", Tag: "s3", Summary: "Queue a scan of every object in a bucket or prefix",
+		Request: BucketScanRequest{}, Response: JobAcceptedResponse{}, Status: http.StatusAccepted},
+
+	{Method: http.MethodGet, Path: "/jobs/{id}", Tag: "jobs", Summary: "Get job progress",
+		Params:   []apiParam{jobIDParam, {Name: "results", In: "query", Description: "true includes per-object results"}},
+		Response: JobState{}, Status: http.StatusOK},
+	{Method: http.MethodDelete, Path: "/jobs/{id}", Tag: "jobs", Summary: "Cancel a job; a running job reports cancelling until its in-flight scans finish",
+		Params: []apiParam{jobIDParam}, Response: JobState{}, Status: http.StatusAccepted},
+	{Method: http.MethodGet, Path: "/jobs/{id}/events", Tag: "jobs", Summary: "Stream job progress as server-sent events",
+		Params: []apiParam{jobIDParam}, Response: JobEvent{}, Status: http.StatusOK, Stream: true},
+	{Method: http.MethodPost, Path: "/jobs/{id}/pause", Tag: "jobs", Summary: "Pause a queued or running job",
+		Params: []apiParam{jobIDParam}, Response: JobState{}, Status: http.StatusAccepted},
+	{Method: http.MethodPost, Path: "/jobs/{id}/resume", Tag: "jobs", Summary: "Resume a paused job",
+		Params: []apiParam{jobIDParam}, Response: JobState{}, Status: http.StatusAccepted},
+	{Method: http.MethodPost, Path: "/jobs/{id}/cancel", Tag: "jobs", Summary: "Cancel a job",
+		Params: []apiParam{jobIDParam}, Response: JobState{}, Status: http.StatusAccepted},
+
+	{Method: http.MethodGet, Path: "/scans/export", Tag: "history", Summary: "Export the scan history as CSV",
+		Params: historyFilterParams, Produces: "text/csv", Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/reports", Tag: "history", Summary: "List the daily and weekly summary reports",
+		Params: reportParams, Response: ReportListResponse{}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/reports/latest", Tag: "history", Summary: "Get the latest daily or weekly summary report",
+		Params: reportParams, Response: ScanReport{}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/reports/{id}", Tag: "history", Summary: "Get a summary report, building it from the history if needed",
+		Params:   []apiParam{{Name: "id", In: "path", Description: "Report ID such as daily-2026-10-15 or weekly-2026-10-12"}, reportParams[1]},
+		Response: ScanReport{}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/stats/buckets", Tag: "history", Summary: "Scan statistics per bucket from the scan history",
+		Params:   []apiParam{historyFilterParams[0], historyFilterParams[1], historyFilterParams[3], historyFilterParams[4]},
+		Response: BucketStatsResponse{}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/compliance/report", Tag: "history", Summary: "Download a signed compliance report for a period and scope",
+		Params: []apiParam{
+			{Name: "from", In: "query", Description: "Start of the period (RFC 3339 or YYYY-MM-DD), required"},
+			{Name: "to", In: "query", Description: "End of the period (RFC 3339 or YYYY-MM-DD), now by default"},
+			{Name: "tenant", In: "query", Description: "Tenant, for callers without one"},
+			{Name: "bucket", In: "query", Description: "S3 bucket"},
+			{Name: "inventory", In: "query", Description: "true lists the bucket to report the objects not scanned"},
+			{Name: "profile", In: "query", Description: "S3 credential profile for the inventory"},
+			{Name: "format", In: "query", Description: "pdf (default), html or json"},
+		},
+		Response: ComplianceReport{}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/compliance/public-key", Tag: "history", Summary: "Public key verifying compliance report signatures",
+		Produces: "application/x-pem-file", Status: http.StatusOK},
+
+	{Method: http.MethodGet, Path: "/schedules", Tag: "schedules", Summary: "List schedules",
+		Response: ScheduleListResponse{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/schedules", Tag: "schedules", Summary: "Create a schedule",
+		Request: Schedule{}, Response: Schedule{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/schedules/{id}", Tag: "schedules", Summary: "Get a schedule and its recent runs",
+		Params: []apiParam{scheduleIDParam}, Response: Schedule{}, Status: http.StatusOK},
+	{Method: http.MethodDelete, Path: "/schedules/{id}", Tag: "schedules", Summary: "Delete an API-created schedule",
+		Params: []apiParam{scheduleIDParam}, Status: http.StatusNoContent},
+
+	{Method: http.MethodGet, Path: "/health", Tag: "health", Summary: "Service health",
+		Response: HealthResponse{}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/readyz", Tag: "health", Summary: "Readiness probe",
+		Response: ReadinessReport{}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/health/deep", Tag: "health", Summary: "Dependency checks with latency",
+		Response: DeepHealthReport{}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/version", Tag: "health", Summary: "Build and version information",
+		Response: VersionInfo{}, Status: http.StatusOK},
+
+	{Method: http.MethodPost, Path: "/admin/reload", Tag: "admin", Summary: "Reload configuration",
+		Response: ReloadResult{}, Status: http.StatusOK},
+	{Method: http.MethodGet, Path: "/allowlist", Tag: "admin", Summary: "List the hash allowlist",
+		Response: HashAllowlistResponse{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/allowlist", Tag: "admin", Summary: "Allowlist a SHA-256",
+		Request: HashAllowlistEntry{}, Response: HashAllowlistEntry{}, Status: http.StatusCreated},
+	{Method: http.MethodDelete, Path: "/allowlist/{sha256}", Tag: "admin", Summary: "Remove a SHA-256 from the allowlist",
+		Params: []apiParam{{Name: "sha256", In: "path", Description: "Allowlisted SHA-256"}}, Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/blocklist", Tag: "admin", Summary: "List the hash blocklist",
+		Response: HashBlocklistResponse{}, Status: http.StatusOK},
+	{Method: http.MethodPost, Path: "/blocklist", Tag: "admin", Summary: "Blocklist a SHA-256 or filename pattern",
+		Request: HashBlocklistEntry{}, Response: HashBlocklistEntry{}, Status: http.StatusCreated},
+	{Method: http.MethodDelete, Path: "/blocklist", Tag: "admin", Summary: "Remove a filename pattern from the blocklist",
+		Params: []apiParam{{Name: "filename", In: "query", Description: "Blocklisted filename pattern"}}, Status: http.StatusNoContent},
+	{Method: http.MethodDelete, Path: "/blocklist/{sha256}", Tag: "admin", Summary: "Remove a SHA-256 from the blocklist",
+		Params: []apiParam{{Name: "sha256", In: "path", Description: "Blocklisted SHA-256"}}, Status: http.StatusNoContent},
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  []byte
+)
+
+// HTTP handler that serves the OpenAPI 3 document for the JSON API
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	openAPIOnce.Do(func() {
+		openAPIDoc, _ = json.MarshalIndent(buildOpenAPISpec(apiOperations), "", "  ")
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDoc)
+}
+
+// buildOpenAPISpec assembles the OpenAPI document for ops
+func buildOpenAPISpec(ops []apiOperation) map[string]interface{} {
+	schemas := schemaBuilder{schemas: map[string]interface{}{}}
+	errorResponse := schemas.schemaFor(reflect.TypeOf(ErrorResponse{}))
+
+	paths := map[string]map[string]interface{}{}
+	for _, op := range ops {
+		operation := map[string]interface{}{
+			"operationId": operationID(op),
+			"summary":     op.Summary,
+			"tags":        []string{op.Tag},
+		}
+
+		// Synchronous scans accept a deadline and answer 504 when it expires
+		synchronousScan := op.Tag == "scan" || op.Path == "/s3/scan"
+		opParams := op.Params
+		if synchronousScan {
+			opParams = append(append([]apiParam{}, opParams...), scanTimeoutParam)
+		}
+		if !publicPaths[op.Path] {
+			opParams = append(append([]apiParam{}, opParams...), tenantParam)
+		}
+
+		var params []map[string]interface{}
+		for _, p := range opParams {
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"description": p.Description,
+				"required":    p.In == "path",
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+
+		switch {
+		case op.Upload:
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/octet-stream": map[string]interface{}{
+						"schema": map[string]interface{}{"type": "string", "format": "binary"},
+					},
+					"multipart/form-data": map[string]interface{}{
+						"schema": map[string]interface{}{
+							"type":                 "object",
+							"additionalProperties": map[string]interface{}{"type": "string", "format": "binary"},
+						},
+					},
+				},
+			}
+		case op.Request != nil:
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemas.schemaFor(reflect.TypeOf(op.Request))},
+				},
+			}
+		}
+
+		success := map[string]interface{}{"description": http.StatusText(op.Status)}
+		if op.Response != nil {
+			contentType := "application/json"
+			if op.Stream {
+				contentType = "text/event-stream"
+			}
+			success["content"] = map[string]interface{}{
+				contentType: map[string]interface{}{"schema": schemas.schemaFor(reflect.TypeOf(op.Response))},
+			}
+		}
+		if op.Produces != "" {
+			success["content"] = map[string]interface{}{
+				op.Produces: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			}
+		}
+		responses := map[string]interface{}{strconv.Itoa(op.Status): success}
+		// /scan answers with the full, minimal or per-file multipart response
+		if op.Path == "/scan" {
+			success["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": map[string]interface{}{
+					"oneOf": []interface{}{
+						schemas.schemaFor(reflect.TypeOf(ScanResponse{})),
+						schemas.schemaFor(reflect.TypeOf(MinimalScanResponse{})),
+						schemas.schemaFor(reflect.TypeOf(MultiScanResponse{})),
+					},
+				}},
+			}
+		}
+		if synchronousScan {
+			responses[strconv.Itoa(http.StatusGatewayTimeout)] = map[string]interface{}{
+				"description": "The scan exceeded its deadline",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemas.schemaFor(reflect.TypeOf(ScanTimeoutResponse{}))},
+				},
+			}
+		}
+		responses["default"] = map[string]interface{}{
+			"description": "Error",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": errorResponse},
+			},
+		}
+		operation["responses"] = responses
+
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]interface{}{}
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "FinGuard scanner API",
+			"version": version,
+		},
+		"security": []map[string][]string{{"apiKey": {}}, {"bearerAuth": {}}},
+		"paths":    paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey":     map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+// operationID derives a stable operation name such as postS3ScanBucket
+func operationID(op apiOperation) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(op.Method))
+	for _, part := range strings.FieldsFunc(op.Path, func(r rune) bool {
+		return r == '/' || r == '-' || r == '{' || r == '}'
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// schemaBuilder converts Go types into OpenAPI schemas, collecting named
+// structs under components/schemas
+type schemaBuilder struct {
+	schemas map[string]interface{}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaFor returns the schema for t, or a $ref for named structs
+func (b *schemaBuilder) schemaFor(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		if _, ok := b.schemas[t.Name()]; !ok {
+			// Reserve the name first so recursive types terminate
+			b.schemas[t.Name()] = map[string]interface{}{}
+			b.schemas[t.Name()] = b.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	// interface{} and anything else accepts any JSON value
+	return map[string]interface{}{}
+}
+
+// structSchema lists the JSON properties of a struct, flattening embedded
+// structs the way encoding/json does
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	b.addFields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func (b *schemaBuilder) addFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				b.addFields(embedded, properties)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schemaFor(field.Type)
+	}
+}
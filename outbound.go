@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// errOutboundBlocked is returned when a user-supplied URL reaches a blocked network
+var errOutboundBlocked = errors.New("destination address is not allowed")
+
+// blockedNetworks are never reached through user-supplied URLs: loopback,
+// private, link-local (cloud metadata services), carrier-grade NAT,
+// multicast and other special-purpose ranges
+var blockedNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// outboundAllowedNetworks reads OUTBOUND_ALLOWED_NETWORKS, comma-separated
+// CIDRs exempted from the block, such as an internal MinIO subnet
+func outboundAllowedNetworks() []netip.Prefix {
+	var allowed []netip.Prefix
+	for _, raw := range strings.Split(os.Getenv("OUTBOUND_ALLOWED_NETWORKS"), ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(raw)
+		if err != nil {
+			log.Printf("Warning: invalid OUTBOUND_ALLOWED_NETWORKS entry %q: %v", raw, err)
+			continue
+		}
+		allowed = append(allowed, prefix.Masked())
+	}
+	return allowed
+}
+
+// checkOutboundIP rejects addresses in a blocked network unless they are in
+// OUTBOUND_ALLOWED_NETWORKS
+func checkOutboundIP(ip netip.Addr) error {
+	ip = ip.Unmap()
+	for _, allowed := range outboundAllowedNetworks() {
+		if allowed.Contains(ip) {
+			return nil
+		}
+	}
+	for _, blocked := range blockedNetworks {
+		if blocked.Contains(ip) {
+			return fmt.Errorf("%w: %s", errOutboundBlocked, ip)
+		}
+	}
+	return nil
+}
+
+// checkOutboundHost rejects hosts that are blocked before any lookup:
+// localhost and literal addresses in a blocked network. Names are checked
+// again on every connection, after DNS resolution.
+func checkOutboundHost(host string) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", errOutboundBlocked, host)
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return checkOutboundIP(ip)
+	}
+	return nil
+}
+
+// guardedDialControl checks the resolved address of every connection, so
+// redirects and DNS names pointing at internal addresses are rejected too
+func guardedDialControl(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errOutboundBlocked, address)
+	}
+	return checkOutboundIP(addrPort.Addr())
+}
+
+// newGuardedHTTPClient returns a client for user-supplied URLs that cannot
+// connect to blocked networks. Proxies are not used, since they would hide
+// the destination from the check.
+func newGuardedHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: guardedDialControl}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
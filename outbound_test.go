@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestCheckOutboundIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		allowed string
+		blocked bool
+	}{
+		{"127.0.0.1", "", true},
+		{"169.254.169.254", "", true},
+		{"10.1.2.3", "", true},
+		{"172.16.0.1", "", true},
+		{"192.168.1.1", "", true},
+		{"100.64.0.1", "", true},
+		{"0.0.0.0", "", true},
+		{"::1", "", true},
+		{"fe80::1", "", true},
+		{"fd00:ec2::254", "", true},
+		{"::ffff:127.0.0.1", "", true},
+		{"8.8.8.8", "", false},
+		{"2606:4700::1111", "", false},
+		{"10.1.2.3", "10.1.0.0/16", false},
+		{"10.2.0.1", "10.1.0.0/16", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip+"/"+tt.allowed, func(t *testing.T) {
+			t.Setenv("OUTBOUND_ALLOWED_NETWORKS", tt.allowed)
+			err := checkOutboundIP(netip.MustParseAddr(tt.ip))
+			if blocked := errors.Is(err, errOutboundBlocked); blocked != tt.blocked {
+				t.Errorf("checkOutboundIP(%s) = %v, want blocked %v", tt.ip, err, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestCheckOutboundHost(t *testing.T) {
+	tests := []struct {
+		host    string
+		blocked bool
+	}{
+		{"localhost", true},
+		{"api.localhost", true},
+		{"169.254.169.254", true},
+		{"::1", true},
+		{"example.com", false},
+		{"93.184.216.34", false},
+	}
+	for _, tt := range tests {
+		if err := checkOutboundHost(tt.host); errors.Is(err, errOutboundBlocked) != tt.blocked {
+			t.Errorf("checkOutboundHost(%q) = %v, want blocked %v", tt.host, err, tt.blocked)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Events an outbound webhook can subscribe to, besides scan.completed and
+// job.completed
+const (
+	eventMalwareDetected      = "malware.detected"
+	eventRemediationCompleted = "remediation.completed"
+)
+
+// outboundWebhookEvents are the event types accepted in WEBHOOKS_FILE
+var outboundWebhookEvents = map[string]bool{
+	eventMalwareDetected:      true,
+	eventScanCompleted:        true,
+	eventRemediationCompleted: true,
+	eventJobCompleted:         true,
+}
+
+// OutboundWebhook is one entry of WEBHOOKS_FILE
+type OutboundWebhook struct {
+	Name    string            `json:"name"`
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Events  []string          `json:"events"`
+	Filter  WebhookFilter     `json:"filter"`
+	Headers map[string]string `json:"headers"`
+	// Template is a Go text/template for the body; the default is the
+	// {"event", "timestamp", "data"} envelope of scan callbacks
+	Template    string `json:"template"`
+	ContentType string `json:"contentType"`
+	// Secret signs the body with HMAC-SHA256 in SignatureHeader
+	Secret          string `json:"secret"`
+	SignatureHeader string `json:"signatureHeader"`
+	MaxRetries      *int   `json:"maxRetries"`
+}
+
+// WebhookFilter limits the events sent to a webhook. Every set field must
+// match; an event that lacks a filtered attribute does not match.
+type WebhookFilter struct {
+	Sources     []string `json:"sources"`
+	Buckets     []string `json:"buckets"` // glob patterns
+	Tenants     []string `json:"tenants"`
+	Verdicts    []string `json:"verdicts"`
+	MinSeverity string   `json:"minSeverity"`
+}
+
+// webhookAttributes are the event attributes filters look at
+type webhookAttributes struct {
+	source, bucket, tenant, verdict, severity string
+}
+
+// matches reports whether an event with attrs passes the filter
+func (f WebhookFilter) matches(attrs webhookAttributes) bool {
+	if len(f.Sources) > 0 && !containsString(f.Sources, attrs.source) {
+		return false
+	}
+	if len(f.Buckets) > 0 && (attrs.bucket == "" || !matchesAny(f.Buckets, attrs.bucket)) {
+		return false
+	}
+	if len(f.Tenants) > 0 && !containsString(f.Tenants, attrs.tenant) {
+		return false
+	}
+	if len(f.Verdicts) > 0 && !containsString(f.Verdicts, attrs.verdict) {
+		return false
+	}
+	if f.MinSeverity != "" && severityRank[attrs.severity] < severityRank[f.MinSeverity] {
+		return false
+	}
+	return true
+}
+
+// containsString reports whether values holds value; empty values never match
+func containsString(values []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookTemplateData is what body and header templates are executed with
+type webhookTemplateData struct {
+	Event     string
+	Timestamp string
+	Data      interface{}
+}
+
+// webhookTemplateFuncs are the functions available to webhook templates
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"env":   os.Getenv,
+}
+
+// outboundWebhookNotifier delivers the subscribed events to one configured
+// webhook. It is registered both as a notifier (detections and jobs) and as
+// a scan event sink (scans and remediation).
+type outboundWebhookNotifier struct {
+	config  OutboundWebhook
+	events  map[string]bool
+	body    *template.Template
+	headers map[string]*template.Template
+	retries int
+	client  *http.Client
+}
+
+// loadOutboundWebhooks reads WEBHOOKS_FILE, a JSON array of OutboundWebhook.
+// Invalid webhooks are skipped with a warning.
+func loadOutboundWebhooks(path string) ([]*outboundWebhookNotifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []OutboundWebhook
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", path, err)
+	}
+	webhooks := make([]*outboundWebhookNotifier, 0, len(configs))
+	for i, config := range configs {
+		w, err := newOutboundWebhookNotifier(config)
+		if err != nil {
+			log.Printf("Warning: skipping webhook %d (%s) in %s: %v", i, config.Name, path, err)
+			continue
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, nil
+}
+
+// newOutboundWebhookNotifier validates a webhook and parses its templates
+func newOutboundWebhookNotifier(config OutboundWebhook) (*outboundWebhookNotifier, error) {
+	// Webhooks are configured by the operator and may target internal services
+	if _, err := parseHTTPURL(config.URL); err != nil {
+		return nil, fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if config.Name == "" {
+		config.Name = config.URL
+	}
+	if config.Method == "" {
+		config.Method = http.MethodPost
+	}
+	config.Method = strings.ToUpper(config.Method)
+	if config.ContentType == "" {
+		config.ContentType = "application/json"
+	}
+	if config.SignatureHeader == "" {
+		config.SignatureHeader = callbackSignatureHead
+	}
+	if len(config.Events) == 0 {
+		config.Events = []string{eventMalwareDetected}
+	}
+	if _, ok := severityRank[config.Filter.MinSeverity]; config.Filter.MinSeverity != "" && !ok {
+		return nil, fmt.Errorf("invalid filter.minSeverity %q, expected critical, high or low", config.Filter.MinSeverity)
+	}
+	for _, pattern := range config.Filter.Buckets {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid bucket pattern %q: %v", pattern, err)
+		}
+	}
+
+	w := &outboundWebhookNotifier{
+		config:  config,
+		events:  make(map[string]bool, len(config.Events)),
+		headers: make(map[string]*template.Template, len(config.Headers)),
+		retries: defaultCallbackRetries,
+		client:  &http.Client{Timeout: callbackTimeout},
+	}
+	if config.MaxRetries != nil && *config.MaxRetries >= 0 {
+		w.retries = *config.MaxRetries
+	}
+	for _, event := range config.Events {
+		if !outboundWebhookEvents[event] {
+			return nil, fmt.Errorf("unknown event %q, expected malware.detected, scan.completed, remediation.completed or job.completed", event)
+		}
+		w.events[event] = true
+	}
+	var err error
+	if config.Template != "" {
+		if w.body, err = template.New("body").Funcs(webhookTemplateFuncs).Option("missingkey=zero").Parse(config.Template); err != nil {
+			return nil, fmt.Errorf("invalid template: %v", err)
+		}
+	}
+	for name, value := range config.Headers {
+		if w.headers[name], err = template.New(name).Funcs(webhookTemplateFuncs).Parse(value); err != nil {
+			return nil, fmt.Errorf("invalid template for header %s: %v", name, err)
+		}
+	}
+	return w, nil
+}
+
+func (w *outboundWebhookNotifier) Name() string {
+	return "webhook:" + w.config.Name
+}
+
+// Notify sends a malware.detected event
+func (w *outboundWebhookNotifier) Notify(ctx context.Context, event DetectionEvent) error {
+	return w.deliver(ctx, eventMalwareDetected, webhookAttributes{
+		source: event.Source, bucket: event.Bucket, tenant: event.Tenant, verdict: "malicious", severity: event.Severity,
+	}, event)
+}
+
+// Emit sends a scan.completed event
+func (w *outboundWebhookNotifier) Emit(ctx context.Context, event ScanCompletedEvent) error {
+	attrs := webhookAttributes{source: event.Source, bucket: event.Bucket, tenant: event.Tenant, verdict: event.Verdict}
+	if event.Verdict == "malicious" {
+		attrs.severity = detectionSeverity(event.MalwareNames)
+	}
+	return w.deliver(ctx, eventScanCompleted, attrs, event)
+}
+
+// EmitRemediation sends a remediation.completed event
+func (w *outboundWebhookNotifier) EmitRemediation(ctx context.Context, event RemediationEvent) error {
+	return w.deliver(ctx, eventRemediationCompleted, webhookAttributes{
+		source: sourceS3, bucket: event.Bucket, tenant: event.Tenant, verdict: "malicious",
+	}, event)
+}
+
+// NotifyJob sends a job.completed event. Jobs that found malware have the
+// malicious verdict for filtering, others clean.
+func (w *outboundWebhookNotifier) NotifyJob(ctx context.Context, state JobState) error {
+	verdict := "clean"
+	if state.Infected > 0 {
+		verdict = "malicious"
+	}
+	return w.deliver(ctx, eventJobCompleted, webhookAttributes{tenant: state.Tenant, verdict: verdict}, state)
+}
+
+// deliver renders and sends the event if the webhook subscribes to it and
+// its filter passes, retrying network errors, 429 and 5xx responses
+func (w *outboundWebhookNotifier) deliver(ctx context.Context, event string, attrs webhookAttributes, data interface{}) error {
+	if !w.events[event] || !w.config.Filter.matches(attrs) {
+		return nil
+	}
+	payload := webhookTemplateData{Event: event, Timestamp: time.Now().Format(time.RFC3339), Data: data}
+
+	var body bytes.Buffer
+	if w.body != nil {
+		if err := w.body.Execute(&body, payload); err != nil {
+			return fmt.Errorf("template failed: %v", err)
+		}
+	} else if err := json.NewEncoder(&body).Encode(CallbackPayload{Event: payload.Event, Timestamp: payload.Timestamp, Data: data}); err != nil {
+		return err
+	}
+	headers := make(map[string]string, len(w.headers))
+	for name, tmpl := range w.headers {
+		var value strings.Builder
+		if err := tmpl.Execute(&value, payload); err != nil {
+			return fmt.Errorf("template for header %s failed: %v", name, err)
+		}
+		headers[name] = value.String()
+	}
+
+	return retryWithBackoff(w.retries, func() (bool, error) {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		req, err := http.NewRequestWithContext(ctx, w.config.Method, w.config.URL, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return false, err
+		}
+		req.Header.Set("Content-Type", w.config.ContentType)
+		req.Header.Set("X-Finguard-Event", event)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		if w.config.Secret != "" {
+			req.Header.Set(w.config.SignatureHeader, signCallback(w.config.Secret, body.Bytes()))
+		}
+		resp, err := w.client.Do(req)
+		if err != nil {
+			return true, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return false, nil
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err = fmt.Errorf("%s returned %d: %s", w.config.URL, resp.StatusCode, strings.TrimSpace(string(msg)))
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+	})
+}
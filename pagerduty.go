@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultPagerDutyEventsURL  = "https://events.pagerduty.com/v2/enqueue"
+	defaultPagerDutyMaxRetries = 3
+)
+
+// severityRank orders detection severities for PAGERDUTY_MIN_SEVERITY
+var severityRank = map[string]int{severityLow: 1, severityHigh: 2, severityCritical: 3}
+
+// pagerDutySeverities maps detection severities to PagerDuty's
+var pagerDutySeverities = map[string]string{severityCritical: "critical", severityHigh: "error", severityLow: "warning"}
+
+// pagerDutyNotifier triggers PagerDuty incidents through the Events API v2
+// for the detections matching every configured criterion. Detections of the
+// same malware in the same object share a dedup key, so repeated scans update
+// the open incident instead of paging again.
+type pagerDutyNotifier struct {
+	routingKey  string
+	eventsURL   string
+	minSeverity string
+	buckets     []string // glob patterns; empty matches every bucket
+	malware     []string // lowercase glob patterns; empty matches every name
+	sources     map[string]bool
+	maxRetries  int
+	client      *http.Client
+}
+
+// newPagerDutyNotifier creates a notifier for the service integration with
+// routingKey (PAGERDUTY_ROUTING_KEY)
+func newPagerDutyNotifier(routingKey string) (*pagerDutyNotifier, error) {
+	n := &pagerDutyNotifier{
+		routingKey:  routingKey,
+		eventsURL:   getEnv("PAGERDUTY_EVENTS_URL", defaultPagerDutyEventsURL),
+		minSeverity: strings.ToLower(getEnv("PAGERDUTY_MIN_SEVERITY", severityCritical)),
+		buckets:     envList("PAGERDUTY_BUCKETS"),
+		maxRetries:  getEnvInt("PAGERDUTY_MAX_RETRIES", defaultPagerDutyMaxRetries),
+		client:      &http.Client{Timeout: notifyTimeout},
+	}
+	if _, ok := severityRank[n.minSeverity]; !ok {
+		return nil, fmt.Errorf("invalid PAGERDUTY_MIN_SEVERITY %q, expected critical, high or low", n.minSeverity)
+	}
+	for _, pattern := range envList("PAGERDUTY_MALWARE") {
+		n.malware = append(n.malware, strings.ToLower(pattern))
+	}
+	for _, pattern := range append(append([]string{}, n.buckets...), n.malware...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid PagerDuty pattern %q: %v", pattern, err)
+		}
+	}
+	if sources := envList("PAGERDUTY_SOURCES"); len(sources) > 0 {
+		n.sources = make(map[string]bool, len(sources))
+		for _, source := range sources {
+			n.sources[source] = true
+		}
+	}
+	return n, nil
+}
+
+func (n *pagerDutyNotifier) Name() string {
+	return "pagerduty (" + n.minSeverity + " and above)"
+}
+
+// matches reports whether the detection meets the paging criteria
+func (n *pagerDutyNotifier) matches(event DetectionEvent) bool {
+	if severityRank[event.Severity] < severityRank[n.minSeverity] {
+		return false
+	}
+	if n.sources != nil && !n.sources[event.Source] {
+		return false
+	}
+	if len(n.buckets) > 0 && !matchesAny(n.buckets, event.Bucket) {
+		return false
+	}
+	if len(n.malware) == 0 {
+		return true
+	}
+	for _, name := range event.MalwareNames {
+		if matchesAny(n.malware, strings.ToLower(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// envList splits a comma-separated environment variable, skipping empty entries
+func envList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// matchesAny reports whether value matches one of the glob patterns
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pagerDutyDedupKey identifies the incident for a detection: the object and
+// the malware found in it
+func pagerDutyDedupKey(event DetectionEvent) string {
+	names := append([]string{}, event.MalwareNames...)
+	sort.Strings(names)
+	sum := sha256.Sum256([]byte(event.Identifier + "\n" + strings.Join(names, ",")))
+	return "finguard-" + hex.EncodeToString(sum[:16])
+}
+
+// Notify triggers an incident for a matching detection
+func (n *pagerDutyNotifier) Notify(ctx context.Context, event DetectionEvent) error {
+	if !n.matches(event) {
+		return nil
+	}
+	malware := strings.Join(event.MalwareNames, ", ")
+	if malware == "" {
+		malware = "unknown malware"
+	}
+	payload := map[string]interface{}{
+		"summary":   truncateText(fmt.Sprintf("FinGuard: %s detected in %s", malware, event.Identifier), 1024),
+		"source":    event.Identifier,
+		"severity":  pagerDutySeverities[event.Severity],
+		"timestamp": event.DetectedAt,
+		"component": event.Bucket,
+		"group":     event.Source,
+		"class":     "malware",
+		"custom_details": map[string]interface{}{
+			"identifier":   event.Identifier,
+			"bucket":       event.Bucket,
+			"key":          event.Key,
+			"malwareNames": event.MalwareNames,
+			"severity":     event.Severity,
+			"scanId":       event.ScanID,
+			"tags":         event.Tags,
+		},
+	}
+	body := map[string]interface{}{
+		"routing_key":  n.routingKey,
+		"event_action": "trigger",
+		"dedup_key":    pagerDutyDedupKey(event),
+		"client":       "FinGuard",
+		"payload":      payload,
+	}
+	if event.Source == sourceS3 {
+		if link := quarantineLink(event.Bucket, event.Key); link != "" {
+			body["links"] = []map[string]string{{"href": link, "text": "Quarantine object"}}
+		}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return retryWithBackoff(n.maxRetries, func() (bool, error) {
+		return n.post(ctx, data)
+	})
+}
+
+// post sends one event and reports whether a failure is worth retrying
+func (n *pagerDutyNotifier) post(ctx context.Context, body []byte) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.eventsURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("Events API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
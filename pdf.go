@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 page size and margins, in points
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 50.0
+)
+
+// pdfDocument lays out text on A4 pages with the standard Helvetica fonts,
+// enough for tabular reports without a PDF library. Text outside Latin-1 is
+// replaced with '?'.
+type pdfDocument struct {
+	title string
+	pages []*bytes.Buffer
+	y     float64
+}
+
+// newPDFDocument starts a document whose pages are footed with title
+func newPDFDocument(title string) *pdfDocument {
+	d := &pdfDocument{title: title}
+	d.newPage()
+	return d
+}
+
+func (d *pdfDocument) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pdfPageHeight - pdfMargin
+}
+
+// reserve moves to the next line of height h, starting a page when needed
+func (d *pdfDocument) reserve(h float64) {
+	if d.y-h < pdfMargin+20 {
+		d.newPage()
+	}
+	d.y -= h
+}
+
+// Space leaves h points of vertical space
+func (d *pdfDocument) Space(h float64) {
+	d.y -= h
+}
+
+// Heading writes a bold line in size points
+func (d *pdfDocument) Heading(text string, size float64) {
+	d.Space(size / 2)
+	d.reserve(size * 1.4)
+	d.show("F2", size, pdfMargin, text)
+}
+
+// Text writes a paragraph, wrapped to the page width
+func (d *pdfDocument) Text(text string) {
+	for _, line := range pdfWrap(text, 10, pdfPageWidth-2*pdfMargin) {
+		d.reserve(13)
+		d.show("F1", 10, pdfMargin, line)
+	}
+}
+
+// Row writes one table row; columns are cut to fit widths, which are
+// fractions of the page width
+func (d *pdfDocument) Row(bold bool, widths []float64, cells ...string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	d.reserve(12)
+	x := pdfMargin
+	for i, cell := range cells {
+		width := widths[i] * (pdfPageWidth - 2*pdfMargin)
+		if lines := pdfWrap(cell, 9, width-6); len(lines) > 0 {
+			cell = lines[0]
+			if len(lines) > 1 {
+				cell = strings.TrimRight(cell, " ") + "..."
+			}
+		}
+		d.show(font, 9, x, cell)
+		x += width
+	}
+}
+
+// show places text at x on the current line
+func (d *pdfDocument) show(font string, size, x float64, text string) {
+	fmt.Fprintf(d.pages[len(d.pages)-1], "BT /%s %.1f Tf %.1f %.1f Td (%s) Tj ET\n", font, size, x, d.y, pdfEscape(text))
+}
+
+// Bytes renders the document
+func (d *pdfDocument) Bytes() []byte {
+	var out bytes.Buffer
+	offsets := []int{0}
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets)-1, body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// 1 catalog, 2 page tree, 3 and 4 fonts, then a content stream and a
+	// page object for every page
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range d.pages {
+		content := page.String() + fmt.Sprintf("BT /F1 8 Tf %.1f %.1f Td (%s) Tj ET\n", pdfMargin, pdfMargin-20,
+			pdfEscape(fmt.Sprintf("%s - page %d of %d", d.title, i+1, len(d.pages))))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 5+2*i))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets))
+	for _, offset := range offsets[1:] {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets), xref)
+	return out.Bytes()
+}
+
+// pdfEscape encodes text as the body of a PDF literal string in WinAnsi
+func pdfEscape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20:
+			b.WriteByte(' ')
+		case r < 0x80:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// pdfWrap splits text into lines that fit width at size points, estimating
+// Helvetica's average character width. Long words are broken.
+func pdfWrap(text string, size, width float64) []string {
+	perLine := int(width / (size * 0.5))
+	if perLine < 1 {
+		perLine = 1
+	}
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			for len([]rune(word)) > perLine {
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				lines = append(lines, string([]rune(word)[:perLine]))
+				word = string([]rune(word)[perLine:])
+			}
+			switch {
+			case line == "":
+				line = word
+			case len([]rune(line))+1+len([]rune(word)) <= perLine:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// Scan policy actions
+const (
+	policyScan = "scan"
+	policySkip = "skip"
+)
+
+// verdictSkipped is recorded for files a policy rule skipped
+const verdictSkipped = "skipped"
+
+// ScanPolicyRule decides, before a file is scanned, whether to scan it, the
+// scanner options to force and the remediation for S3 objects. Every set
+// condition must hold; conditions on values that are unknown do not match.
+type ScanPolicyRule struct {
+	Name          string   `json:"name"`
+	Sources       []string `json:"sources,omitempty"`
+	Buckets       []string `json:"buckets,omitempty"`       // glob patterns
+	Prefixes      []string `json:"prefixes,omitempty"`      // object key or file name prefixes
+	Extensions    []string `json:"extensions,omitempty"`    // such as .iso, case-insensitive
+	MIMETypes     []string `json:"mimeTypes,omitempty"`     // glob patterns such as image/*
+	DetectedTypes []string `json:"detectedTypes,omitempty"` // glob patterns on the type sniffed from the content
+	MinSize       int64    `json:"minSize,omitempty"`       // bytes
+	MaxSize       int64    `json:"maxSize,omitempty"`       // bytes
+
+	// Action is scan (the default) or skip
+	Action string `json:"action,omitempty"`
+	// PML, ActiveContent and Feedback override the request's scanner options
+	PML           *bool `json:"pml,omitempty"`
+	ActiveContent *bool `json:"activeContent,omitempty"`
+	Feedback      *bool `json:"feedback,omitempty"`
+	// Remediation applies to infected S3 objects the request gives no policy for
+	Remediation *RemediationPolicy `json:"remediation,omitempty"`
+}
+
+// ScanTarget is what is known about a file before it is scanned. Size is
+// negative when unknown. DetectedType is sniffed from the first bytes.
+type ScanTarget struct {
+	Source       string
+	Bucket       string
+	Key          string
+	ContentType  string
+	DetectedType string
+	Size         int64
+}
+
+// Policy rules loaded at startup by initScanPolicy and replaced on reload
+var (
+	scanPolicyMu    sync.RWMutex
+	scanPolicyRules []ScanPolicyRule
+)
+
+// initScanPolicy loads the policy rules at startup
+func initScanPolicy() {
+	if err := reloadScanPolicy(); err != nil {
+		log.Printf("Warning: scan policy disabled: %v", err)
+	}
+}
+
+// reloadScanPolicy replaces the policy rules. On error the current rules
+// are kept.
+func reloadScanPolicy() error {
+	rules, err := loadScanPolicy()
+	if err != nil {
+		return err
+	}
+	scanPolicyMu.Lock()
+	scanPolicyRules = rules
+	scanPolicyMu.Unlock()
+	if len(rules) > 0 {
+		log.Printf("- Scan policy rules: %d", len(rules))
+	}
+	return nil
+}
+
+// loadScanPolicy reads the rules from SCAN_POLICY_FILE, a JSON array of
+// ScanPolicyRule, or from the policy.rules section of the config file
+func loadScanPolicy() ([]ScanPolicyRule, error) {
+	data, source := configScanPolicyRules, "config file rules"
+	if file := os.Getenv("SCAN_POLICY_FILE"); file != "" {
+		var err error
+		if data, err = os.ReadFile(file); err != nil {
+			return nil, err
+		}
+		source = file
+	}
+	if data == nil {
+		return nil, nil
+	}
+	var rules []ScanPolicyRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", source, err)
+	}
+	valid := make([]ScanPolicyRule, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if err := rule.validate(); err != nil {
+			log.Printf("Warning: skipping scan policy rule %q: %v", rule.Name, err)
+			continue
+		}
+		valid = append(valid, rule)
+	}
+	return valid, nil
+}
+
+// validate normalizes the rule's conditions and checks its actions
+func (p *ScanPolicyRule) validate() error {
+	if p.Action == "" {
+		p.Action = policyScan
+	}
+	if p.Action != policyScan && p.Action != policySkip {
+		return fmt.Errorf("invalid action %q, expected scan or skip", p.Action)
+	}
+	patterns := append(append(append([]string{}, p.Buckets...), p.MIMETypes...), p.DetectedTypes...)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	for i, ext := range p.Extensions {
+		p.Extensions[i] = "." + strings.TrimPrefix(strings.ToLower(ext), ".")
+	}
+	for i, pattern := range p.MIMETypes {
+		p.MIMETypes[i] = strings.ToLower(pattern)
+	}
+	for i, pattern := range p.DetectedTypes {
+		p.DetectedTypes[i] = strings.ToLower(pattern)
+	}
+	if p.MaxSize > 0 && p.MinSize > p.MaxSize {
+		return fmt.Errorf("minSize is larger than maxSize")
+	}
+	if p.Remediation != nil {
+		policy, err := p.Remediation.withDefaults()
+		if err != nil {
+			return err
+		}
+		p.Remediation = &policy
+	}
+	return nil
+}
+
+// matches reports whether the rule covers target
+func (p ScanPolicyRule) matches(target ScanTarget) bool {
+	if len(p.Sources) > 0 && !containsString(p.Sources, target.Source) {
+		return false
+	}
+	if len(p.Buckets) > 0 && (target.Bucket == "" || !matchesAny(p.Buckets, target.Bucket)) {
+		return false
+	}
+	if len(p.Prefixes) > 0 {
+		matched := false
+		for _, prefix := range p.Prefixes {
+			matched = matched || strings.HasPrefix(target.Key, prefix)
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(p.Extensions) > 0 && !containsString(p.Extensions, strings.ToLower(path.Ext(target.Key))) {
+		return false
+	}
+	if len(p.MIMETypes) > 0 {
+		mediaType, _, err := mime.ParseMediaType(target.ContentType)
+		if err != nil || !matchesAny(p.MIMETypes, mediaType) {
+			return false
+		}
+	}
+	if len(p.DetectedTypes) > 0 && (target.DetectedType == "" || !matchesAny(p.DetectedTypes, target.DetectedType)) {
+		return false
+	}
+	if (p.MinSize > 0 || p.MaxSize > 0) && target.Size < 0 {
+		return false
+	}
+	if p.MinSize > 0 && target.Size < p.MinSize {
+		return false
+	}
+	if p.MaxSize > 0 && target.Size > p.MaxSize {
+		return false
+	}
+	return true
+}
+
+// options returns opts with the rule's overrides applied
+func (p ScanPolicyRule) options(opts ScanOptions) ScanOptions {
+	if p.PML != nil {
+		opts.PML = *p.PML
+	}
+	if p.ActiveContent != nil {
+		opts.ActiveContent = *p.ActiveContent
+	}
+	if p.Feedback != nil {
+		opts.Feedback = *p.Feedback
+	}
+	return opts
+}
+
+// matchScanPolicy returns the first rule covering target, or nil
+func matchScanPolicy(target ScanTarget) *ScanPolicyRule {
+	scanPolicyMu.RLock()
+	defer scanPolicyMu.RUnlock()
+	for i := range scanPolicyRules {
+		if scanPolicyRules[i].matches(target) {
+			rule := scanPolicyRules[i]
+			return &rule
+		}
+	}
+	return nil
+}
+
+// uploadScanTarget describes a raw /scan upload from its headers and the
+// first bytes of its body, or from the file for the file scan method
+func uploadScanTarget(r *http.Request, filename, filePath, scanMethod string) ScanTarget {
+	target := ScanTarget{Source: sourceUpload, Key: filename, ContentType: r.Header.Get("Content-Type"), Size: r.ContentLength}
+	if scanMethod == "file" && filePath != "" {
+		target.Size = -1
+		if info, err := os.Stat(filePath); err == nil {
+			target.Size = info.Size()
+		}
+		target.DetectedType = sniffFile(filePath)
+		return target
+	}
+	target.DetectedType = sniffBody(r)
+	return target
+}
+
+type scanPolicyKey struct{}
+
+type scanSourceKey struct{}
+
+// withScanSource records the source observeScan counts a scan under, for
+// the scan policy of backends that leave it to the scan funnels
+func withScanSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, scanSourceKey{}, source)
+}
+
+// scanSourceFrom returns the source recorded by withScanSource, or ""
+func scanSourceFrom(ctx context.Context) string {
+	source, _ := ctx.Value(scanSourceKey{}).(string)
+	return source
+}
+
+// applyScanPolicy matches target against the policy rules. It returns opts
+// with the matching rule's overrides and a context carrying the rule, which
+// scanUnlessListed and resolveRemediation read, and the detected type. The
+// context records that the policy was applied even when no rule matches.
+func applyScanPolicy(ctx context.Context, target ScanTarget, opts ScanOptions) (context.Context, ScanOptions) {
+	ctx = withDetectedType(ctx, target.DetectedType)
+	rule := matchScanPolicy(target)
+	if rule == nil {
+		return context.WithValue(ctx, scanPolicyKey{}, rule), opts
+	}
+	log.Printf("Scan policy rule %q applies to %s (%s)", rule.Name, target.Key, rule.Action)
+	return context.WithValue(ctx, scanPolicyKey{}, rule), rule.options(opts)
+}
+
+// scanPolicyClient applies the scan policy for target and returns the
+// context, client and options to scan it with
+func scanPolicyClient(ctx context.Context, base *amaasclient.AmaasClient, target ScanTarget, opts ScanOptions) (context.Context, *amaasclient.AmaasClient, ScanOptions) {
+	ctx, opts = applyScanPolicy(ctx, target, opts)
+	return withScanOptions(ctx, opts), clientWithOptions(base, opts), opts
+}
+
+// scanTargeter is implemented by readers of objects in buckets, so rules on
+// buckets and key prefixes match them in the scan funnels
+type scanTargeter interface {
+	scanTarget() ScanTarget
+}
+
+// funnelScanPolicy applies the scan policy in the scan funnels when the
+// caller did not apply it before scanning, so every backend evaluates it.
+// It returns the context and client to scan with.
+func funnelScanPolicy(ctx context.Context, client *amaasclient.AmaasClient, target ScanTarget) (context.Context, *amaasclient.AmaasClient) {
+	if ctx.Value(scanPolicyKey{}) != nil {
+		return ctx, client
+	}
+	target.Source = scanSourceFrom(ctx)
+	ctx, opts := applyScanPolicy(ctx, target, scanOptionsFrom(ctx))
+	if scanPolicyFrom(ctx) == nil {
+		return ctx, client
+	}
+	if client != nil {
+		client = clientWithOptions(client, opts)
+	}
+	return withScanOptions(ctx, opts), client
+}
+
+// scanPolicyFrom returns the rule recorded by applyScanPolicy, or nil
+func scanPolicyFrom(ctx context.Context) *ScanPolicyRule {
+	rule, _ := ctx.Value(scanPolicyKey{}).(*ScanPolicyRule)
+	return rule
+}
+
+// skippedResult returns a clean SDK-style result marked skipped for a file
+// the policy rule in ctx skips
+func skippedResult(ctx context.Context, sha256 string) (string, bool) {
+	rule := scanPolicyFrom(ctx)
+	if rule == nil || rule.Action != policySkip {
+		return "", false
+	}
+	result, _ := json.Marshal(map[string]interface{}{
+		"scanResult":    0,
+		"foundMalwares": []interface{}{},
+		"fileSHA256":    sha256,
+		"skipped":       true,
+		"policyRule":    rule.Name,
+	})
+	return string(result), true
+}
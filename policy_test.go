@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useTestScanPolicy replaces the scan policy rules for the test
+func useTestScanPolicy(t *testing.T, rules ...ScanPolicyRule) {
+	t.Helper()
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			t.Fatalf("rule %s: %v", rules[i].Name, err)
+		}
+	}
+	scanPolicyMu.Lock()
+	previous := scanPolicyRules
+	scanPolicyRules = rules
+	scanPolicyMu.Unlock()
+	t.Cleanup(func() {
+		scanPolicyMu.Lock()
+		scanPolicyRules = previous
+		scanPolicyMu.Unlock()
+	})
+}
+
+func TestFunnelScanPolicy(t *testing.T) {
+	enabled := true
+	useTestScanPolicy(t,
+		ScanPolicyRule{Name: "skip-iso", Sources: []string{sourceFilesystem}, Extensions: []string{".iso"}, Action: policySkip},
+		ScanPolicyRule{Name: "archive-bucket", Sources: []string{sourceGCS}, Buckets: []string{"archive-*"}, Action: policySkip},
+		ScanPolicyRule{Name: "kafka-pml", Sources: []string{"kafka"}, PML: &enabled},
+	)
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		target   ScanTarget
+		wantRule string
+		wantPML  bool
+	}{
+		{"source and extension", withScanSource(context.Background(), sourceFilesystem), ScanTarget{Key: "/data/disk.iso", Size: 10}, "skip-iso", false},
+		{"other source", withScanSource(context.Background(), sourceClamd), ScanTarget{Key: "/data/disk.iso", Size: 10}, "", false},
+		{"bucket from the reader", withScanSource(context.Background(), sourceGCS), ScanTarget{Bucket: "archive-2024", Key: "a.txt"}, "archive-bucket", false},
+		{"options", withScanSource(context.Background(), "kafka"), ScanTarget{Key: "message"}, "kafka-pml", true},
+		{"applied by the caller", func() context.Context {
+			ctx, _ := applyScanPolicy(withScanSource(context.Background(), sourceFilesystem), ScanTarget{Source: sourceUpload, Key: "x.txt"}, ScanOptions{})
+			return ctx
+		}(), ScanTarget{Key: "/data/disk.iso", Size: 10}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := funnelScanPolicy(tt.ctx, nil, tt.target)
+			rule := ""
+			if matched := scanPolicyFrom(ctx); matched != nil {
+				rule = matched.Name
+			}
+			if rule != tt.wantRule {
+				t.Errorf("rule = %q, want %q", rule, tt.wantRule)
+			}
+			if pml := scanOptionsFrom(ctx).PML; pml != tt.wantPML {
+				t.Errorf("PML = %v, want %v", pml, tt.wantPML)
+			}
+		})
+	}
+}
+
+// A skip rule answers in the funnel without calling the scanner, which is nil here
+func TestScanFunnelsSkipByPolicy(t *testing.T) {
+	useTestLists(t, nil, nil)
+	useTestScanPolicy(t, ScanPolicyRule{Name: "skip-iso", Sources: []string{sourceFilesystem}, Extensions: []string{".iso"}, Action: policySkip})
+	path := filepath.Join(t.TempDir(), "disk.iso")
+	if err := os.WriteFile(path, []byte("image"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx := withScanSource(context.Background(), sourceFilesystem)
+
+	tests := []struct {
+		name string
+		scan func() (string, error)
+	}{
+		{"file", func() (string, error) { return scanFile(ctx, nil, path, nil) }},
+		{"reader", func() (string, error) {
+			reader, err := NewFileReader(path, path)
+			if err != nil {
+				return "", err
+			}
+			defer reader.Close()
+			return scanReader(ctx, nil, reader, nil)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.scan()
+			if err != nil {
+				t.Fatalf("scan: %v", err)
+			}
+			verdict, err := parseScanVerdict(result)
+			if err != nil {
+				t.Fatalf("parseScanVerdict(%s): %v", result, err)
+			}
+			if !verdict.Skipped || verdict.PolicyRule != "skip-iso" {
+				t.Errorf("verdict %+v, want skipped by skip-iso", verdict)
+			}
+		})
+	}
+}
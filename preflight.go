@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// preflightTimeout bounds each startup check so an unreachable backend
+// fails preflight instead of hanging startup
+const preflightTimeout = 30 * time.Second
+
+// PreflightCheck is the outcome of a single startup check
+type PreflightCheck struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"` // ok, failed or skipped
+	Detail   string `json:"detail,omitempty"`
+	Duration string `json:"duration"`
+	// LatencyMs is reported by the health endpoints
+	LatencyMs float64 `json:"latencyMs,omitempty"`
+}
+
+// PreflightReport aggregates all startup checks
+type PreflightReport struct {
+	Passed    bool             `json:"passed"`
+	Timestamp string           `json:"timestamp"`
+	Checks    []PreflightCheck `json:"checks"`
+}
+
+// runPreflight verifies that the scanner backend is reachable and, when AWS
+// credentials are available (or required), that they are valid.
+func runPreflight(client *amaasclient.AmaasClient, endpoint string) PreflightReport {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	scanner := checkScannerBackend(ctx, client, endpoint)
+
+	ctx, cancel = context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	return preflightReport(scanner, checkAWSCredentials(ctx))
+}
+
+// preflightReport aggregates checks; it passes when none failed
+func preflightReport(checks ...PreflightCheck) PreflightReport {
+	report := PreflightReport{
+		Passed:    true,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	for _, check := range checks {
+		if check.Status == "failed" {
+			report.Passed = false
+		}
+		report.Checks = append(report.Checks, check)
+	}
+	return report
+}
+
+// checkScannerBackend scans a tiny buffer to prove the backend accepts requests
+func checkScannerBackend(ctx context.Context, client *amaasclient.AmaasClient, endpoint string) PreflightCheck {
+	start := time.Now()
+	check := PreflightCheck{Name: "scanner"}
+
+	if client == nil {
+		check.Status = "failed"
+		check.Detail = "scanner client is not initialized"
+		check.Duration = time.Since(start).String()
+		return check
+	}
+
+	primary, release := primaryClient(client)
+	defer release()
+	if _, err := primary.ScanBufferWithContext(ctx, []byte("finguard-preflight"), "finguard-preflight", []string{"app=finguard", "preflight=true"}); err != nil {
+		check.Status = "failed"
+		check.Detail = fmt.Sprintf("scanner backend %s unreachable: %v", endpoint, err)
+	} else {
+		check.Status = "ok"
+		check.Detail = fmt.Sprintf("scanner backend %s reachable", endpoint)
+	}
+
+	check.Duration = time.Since(start).String()
+	return check
+}
+
+// checkAWSCredentials validates the default AWS credential chain with STS.
+// The check is skipped when no credentials are configured unless
+// PREFLIGHT_REQUIRE_AWS=true.
+func checkAWSCredentials(ctx context.Context) PreflightCheck {
+	start := time.Now()
+	check := PreflightCheck{Name: "aws"}
+	required := os.Getenv("PREFLIGHT_REQUIRE_AWS") == "true"
+
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(getEnv("AWS_REGION", "us-east-1")))
+	if err != nil {
+		check.Status = "failed"
+		check.Detail = fmt.Sprintf("failed to load AWS config: %v", err)
+		check.Duration = time.Since(start).String()
+		return check
+	}
+
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		if required {
+			check.Status = "failed"
+			check.Detail = fmt.Sprintf("no usable AWS credentials: %v", err)
+		} else {
+			check.Status = "skipped"
+			check.Detail = "no default AWS credentials configured, S3 checks skipped"
+		}
+		check.Duration = time.Since(start).String()
+		return check
+	}
+
+	identity, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		check.Status = "failed"
+		check.Detail = fmt.Sprintf("AWS credentials rejected: %v", err)
+	} else {
+		check.Status = "ok"
+		check.Detail = fmt.Sprintf("AWS credentials valid for %s", aws.ToString(identity.Arn))
+	}
+	check.Duration = time.Since(start).String()
+	return check
+}
+
+// enforcePreflight runs the preflight checks and exits non-zero on failure
+func enforcePreflight(client *amaasclient.AmaasClient, endpoint string) {
+	log.Printf("Running startup preflight checks...")
+	report := runPreflight(client, endpoint)
+
+	output, _ := json.MarshalIndent(report, "", "  ")
+	for _, check := range report.Checks {
+		log.Printf("- Preflight %s: %s (%s) %s", check.Name, check.Status, check.Duration, check.Detail)
+	}
+
+	if !report.Passed {
+		fmt.Fprintln(os.Stderr, string(output))
+		log.Fatalf("Preflight checks failed")
+	}
+	fmt.Println(string(output))
+	log.Printf("Preflight checks passed")
+}
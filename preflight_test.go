@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// stsInvalidToken answers every STS call like AWS does for unknown keys
+const stsInvalidToken = `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error><Type>Sender</Type><Code>InvalidClientTokenId</Code><Message>The security token included in the request is invalid.</Message></Error>
+  <RequestId>preflight-test</RequestId>
+</ErrorResponse>`
+
+func TestPreflightReportsInvalidAWSCredentials(t *testing.T) {
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(stsInvalidToken))
+	}))
+	defer sts.Close()
+	t.Setenv("AWS_ENDPOINT_URL_STS", sts.URL)
+	t.Setenv("AWS_CONFIG_FILE", "/nonexistent")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/nonexistent")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_MAX_ATTEMPTS", "1")
+
+	tests := []struct {
+		name       string
+		accessKey  string
+		required   string
+		wantStatus string
+		wantPassed bool
+	}{
+		{"invalid credentials", "AKIAINVALID", "", "failed", false},
+		{"no credentials", "", "", "skipped", true},
+		{"no credentials but required", "", "true", "failed", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_ACCESS_KEY_ID", tt.accessKey)
+			t.Setenv("AWS_SECRET_ACCESS_KEY", tt.accessKey)
+			t.Setenv("PREFLIGHT_REQUIRE_AWS", tt.required)
+
+			// The scanner is reachable; only AWS can fail the report
+			scanner := PreflightCheck{Name: "scanner", Status: "ok"}
+			report := preflightReport(scanner, checkAWSCredentials(context.Background()))
+			if report.Passed != tt.wantPassed {
+				t.Errorf("passed = %v, want %v: %+v", report.Passed, tt.wantPassed, report.Checks)
+			}
+			for _, check := range report.Checks {
+				switch check.Name {
+				case "scanner":
+					if check.Status != "ok" {
+						t.Errorf("scanner check %s, want ok", check.Status)
+					}
+				case "aws":
+					if check.Status != tt.wantStatus {
+						t.Errorf("aws check %s (%s), want %s", check.Status, check.Detail, tt.wantStatus)
+					}
+					if tt.accessKey != "" && !strings.Contains(check.Detail, "AWS credentials rejected") {
+						t.Errorf("aws detail %q does not name the rejected credentials", check.Detail)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// What happens to the original object once it has been copied to quarantine
+const (
+	quarantineOriginalDelete = "delete"
+	quarantineOriginalTag    = "tag"
+	quarantineOriginalKeep   = "keep"
+)
+
+// Tags added to an original object that was quarantined with original=tag
+const (
+	quarantineTagKey      = "finguard-quarantined"
+	quarantineLocationTag = "finguard-quarantine-location"
+)
+
+// QuarantineOptions enables quarantine of infected objects on /s3/scan and
+// bulk jobs. Unset fields default to QUARANTINE_BUCKET, QUARANTINE_PREFIX and
+// QUARANTINE_ORIGINAL. The quarantine bucket is reached with the same
+// credentials and region as the scanned object.
+type QuarantineOptions struct {
+	Bucket   string `json:"bucket"`
+	Prefix   string `json:"prefix"`
+	Original string `json:"original"` // delete, tag or keep
+}
+
+// QuarantineResult records the quarantine action taken for an object
+type QuarantineResult struct {
+	Destination string `json:"destination,omitempty"`
+	Original    string `json:"original,omitempty"` // deleted, tagged or kept
+	Error       string `json:"error,omitempty"`
+}
+
+// withDefaults fills unset quarantine options from the environment
+func (o QuarantineOptions) withDefaults() QuarantineOptions {
+	if o.Bucket == "" {
+		o.Bucket = os.Getenv("QUARANTINE_BUCKET")
+	}
+	if o.Prefix == "" {
+		o.Prefix = getEnv("QUARANTINE_PREFIX", "quarantine/")
+	}
+	if o.Original == "" {
+		o.Original = getEnv("QUARANTINE_ORIGINAL", quarantineOriginalTag)
+	}
+	return o
+}
+
+// validate checks the options before a scan starts
+func (o QuarantineOptions) validate() error {
+	if o.Bucket == "" {
+		return fmt.Errorf("quarantine bucket is required (set quarantine.bucket or QUARANTINE_BUCKET)")
+	}
+	switch o.Original {
+	case quarantineOriginalDelete, quarantineOriginalTag, quarantineOriginalKeep:
+		return nil
+	}
+	return fmt.Errorf("invalid quarantine original action %q, expected delete, tag or keep", o.Original)
+}
+
+// scannedS3Object is the version of an object a scan read. Remediation acts
+// on that version, or on an unversioned object only while its ETag matches,
+// so objects replaced after the scan are left alone.
+type scannedS3Object struct {
+	bucket      string
+	accessPoint bool
+	key         string
+	versionID   string
+	etag        string
+	sse         *SSECustomerKey // SSE-C key the object was read with
+}
+
+// scannedObject returns the object version the reader scanned
+func (r *S3ClientReader) scannedObject() scannedS3Object {
+	return scannedS3Object{bucket: r.bucket, accessPoint: r.accessPoint, key: r.key, versionID: r.versionID, etag: r.etag, sse: r.sse}
+}
+
+// copySource returns the CopySource value for an object version; access
+// point ARNs use the <arn>/object/<key> form
+func copySource(bucket string, accessPoint bool, key, versionID string) string {
+	escaped := strings.ReplaceAll(url.PathEscape(key), "%2F", "/")
+	source := bucket + "/" + escaped
+	if accessPoint {
+		source = bucket + "/object/" + escaped
+	}
+	if versionID != "" {
+		source += "?versionId=" + url.QueryEscape(versionID)
+	}
+	return source
+}
+
+// quarantineS3Object copies the scanned version of an infected object to the
+// quarantine bucket, preserving its metadata and tags, then deletes, tags or
+// keeps that version. SSE-C objects are read with their key and the copy is
+// encrypted with the same key. Objects larger than 5 GB cannot be copied with
+// CopyObject and are reported as errors.
+func quarantineS3Object(ctx context.Context, client *s3.Client, obj scannedS3Object, opts QuarantineOptions) QuarantineResult {
+	bucket, key := obj.bucket, obj.key
+	destKey := opts.Prefix + key
+	result := QuarantineResult{Destination: fmt.Sprintf("s3://%s/%s", opts.Bucket, destKey)}
+
+	s3Logger.Printf("Quarantining s3://%s/%s to %s", bucket, key, result.Destination)
+	sseAlgorithm, sseKey, sseKeyMD5 := obj.sse.params()
+	input := &s3.CopyObjectInput{
+		Bucket:                         aws.String(opts.Bucket),
+		Key:                            aws.String(destKey),
+		CopySource:                     aws.String(copySource(bucket, obj.accessPoint, key, obj.versionID)),
+		MetadataDirective:              types.MetadataDirectiveCopy,
+		TaggingDirective:               types.TaggingDirectiveCopy,
+		CopySourceSSECustomerAlgorithm: sseAlgorithm,
+		CopySourceSSECustomerKey:       sseKey,
+		CopySourceSSECustomerKeyMD5:    sseKeyMD5,
+		SSECustomerAlgorithm:           sseAlgorithm,
+		SSECustomerKey:                 sseKey,
+		SSECustomerKeyMD5:              sseKeyMD5,
+	}
+	if obj.versionID == "" && obj.etag != "" {
+		input.CopySourceIfMatch = aws.String(`"` + obj.etag + `"`)
+	}
+	if _, err := client.CopyObject(ctx, input); err != nil {
+		s3Logger.Printf("ERROR: Failed to copy s3://%s/%s to quarantine: %v", bucket, key, err)
+		result.Error = fmt.Sprintf("copy to quarantine failed: %v", err)
+		return result
+	}
+
+	switch opts.Original {
+	case quarantineOriginalDelete:
+		if err := deleteS3Object(ctx, client, bucket, key, obj.versionID, obj.etag); err != nil {
+			result.Error = fmt.Sprintf("delete original failed: %v", err)
+			break
+		}
+		result.Original = "deleted"
+	case quarantineOriginalTag:
+		if err := tagS3Object(ctx, client, bucket, key, obj.versionID, map[string]string{quarantineTagKey: "true", quarantineLocationTag: result.Destination}); err != nil {
+			result.Error = fmt.Sprintf("tag original failed: %v", err)
+			break
+		}
+		result.Original = "tagged"
+	default:
+		result.Original = "kept"
+	}
+
+	if result.Error != "" {
+		s3Logger.Printf("ERROR: Quarantine of s3://%s/%s incomplete: %s", bucket, key, result.Error)
+	} else {
+		s3Logger.Printf("Quarantined s3://%s/%s (original %s)", bucket, key, result.Original)
+	}
+	return result
+}
+
+// tagS3Object merges tags into the existing tag set of an object version, or
+// of the current object when versionID is empty
+func tagS3Object(ctx context.Context, client *s3.Client, bucket, key, versionID string, add map[string]string) error {
+	var version *string
+	if versionID != "" {
+		version = aws.String(versionID)
+	}
+	existing, err := client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{Bucket: aws.String(bucket), Key: aws.String(key), VersionId: version})
+	if err != nil {
+		return err
+	}
+
+	tags := make([]types.Tag, 0, len(existing.TagSet)+len(add))
+	for _, tag := range existing.TagSet {
+		if _, replaced := add[aws.ToString(tag.Key)]; !replaced {
+			tags = append(tags, tag)
+		}
+	}
+	for k, v := range add {
+		tags = append(tags, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	_, err = client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: version,
+		Tagging:   &types.Tagging{TagSet: tags},
+	})
+	return err
+}
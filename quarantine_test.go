@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestQuarantineSSECObject(t *testing.T) {
+	scanner := newTestScanner(t)
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	fake := newFakeS3(map[string]string{"uploads/infected.txt": "X5O!P%@AP EICAR test"})
+	response := scanS3(t, scanner, map[string]interface{}{
+		"bucket": "uploads", "key": "infected.txt", "endpointUrl": fake.serve(t), "sseCustomerKey": key,
+		"remediation": map[string]interface{}{
+			"action":     remediationQuarantine,
+			"quarantine": map[string]string{"bucket": "quarantine", "prefix": "q/", "original": quarantineOriginalKeep},
+		},
+	})
+	if response.Remediation == nil || response.Remediation.Status != remediationApplied {
+		t.Fatalf("remediation = %+v, want applied", response.Remediation)
+	}
+
+	copies := fake.received(http.MethodPut, "quarantine/q/infected.txt")
+	if len(copies) != 1 {
+		t.Fatalf("got %d quarantine copies, want 1", len(copies))
+	}
+	for _, header := range []string{
+		"X-Amz-Copy-Source-Server-Side-Encryption-Customer-Algorithm",
+		"X-Amz-Copy-Source-Server-Side-Encryption-Customer-Key",
+		"X-Amz-Server-Side-Encryption-Customer-Algorithm",
+		"X-Amz-Server-Side-Encryption-Customer-Key",
+	} {
+		want := key
+		if strings.HasSuffix(header, "Algorithm") {
+			want = "AES256"
+		}
+		if got := copies[0].header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+}
+
+// Quarantine copies and removes the scanned version even when the object was
+// replaced after the scan
+func TestQuarantinePinsScannedVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		versioned bool
+		wantError bool
+	}{
+		{"versioned", true, false},
+		{"unversioned", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeS3(map[string]string{"uploads/infected.txt": "X5O!P%@AP EICAR test"})
+			scanned := fake.object("uploads/infected.txt")
+			replaced := fake.put("uploads/infected.txt", []byte("replaced after the scan"))
+			client := newTestS3Client(t, fake)
+
+			obj := scannedS3Object{bucket: "uploads", key: "infected.txt", versionID: scanned.versionID, etag: scanned.etag}
+			if !tt.versioned {
+				obj.versionID = ""
+			}
+			result := quarantineS3Object(context.Background(), client, obj, QuarantineOptions{Bucket: "quarantine", Prefix: "q/", Original: quarantineOriginalDelete})
+			if (result.Error != "") != tt.wantError {
+				t.Fatalf("quarantine error = %q, want error %v", result.Error, tt.wantError)
+			}
+			if fake.object("uploads/infected.txt") != replaced {
+				t.Error("the replacement object was deleted")
+			}
+			copied := fake.object("quarantine/q/infected.txt")
+			if tt.wantError {
+				if copied != nil {
+					t.Error("the replacement object was copied to quarantine")
+				}
+				return
+			}
+			if copied == nil || string(copied.data) != string(scanned.data) {
+				t.Errorf("quarantine copy = %v, want the scanned version", copied)
+			}
+			if fake.version("uploads/infected.txt", scanned.versionID) != nil {
+				t.Error("the scanned version is still retrievable")
+			}
+		})
+	}
+}
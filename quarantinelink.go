@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	quarantineLinkPath       = "/quarantine-links"
+	quarantineLinkCallerName = "quarantine-link"
+	defaultQuarantineLinkTTL = 7 * 24 * time.Hour
+)
+
+// S3QuarantineRequest is the body of POST /s3/quarantine
+type S3QuarantineRequest struct {
+	S3Options
+	Bucket     string             `json:"bucket"`
+	Key        string             `json:"key"`
+	Quarantine *QuarantineOptions `json:"quarantine"`
+	DryRun     bool               `json:"dryRun"`
+}
+
+// handleQuarantineS3Object quarantines an object on request, such as one
+// found by a scan without remediation
+func handleQuarantineS3Object(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req S3QuarantineRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON request body", "")
+		return
+	}
+	policy, err := RemediationPolicy{Action: remediationQuarantine, DryRun: req.DryRun, Quarantine: req.Quarantine}.withDefaults()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), "quarantine")
+		return
+	}
+	result, err := quarantineOnDemand(r.Context(), req.S3Options, req.Bucket, req.Key, policy)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err.Error(), "")
+		return
+	}
+	status := http.StatusOK
+	if result.Status == remediationFailed {
+		status = http.StatusBadGateway
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// quarantineOnDemand applies a quarantine policy to bucket/key outside a scan
+func quarantineOnDemand(ctx context.Context, opts S3Options, bucket, key string, policy RemediationPolicy) (RemediationResult, error) {
+	opts = opts.withDefaults()
+	target, err := resolveBucket(bucket)
+	if err == nil {
+		opts, err = target.clientOptions(opts)
+	}
+	if err != nil {
+		return RemediationResult{}, err
+	}
+	region := opts.Region
+	if target.Region != "" {
+		region = target.Region
+	}
+	cfg, err := loadAWSConfig(ctx, opts, region)
+	if err != nil {
+		return RemediationResult{}, fmt.Errorf("failed to load AWS config: %v", err)
+	}
+	return remediateS3Object(ctx, newS3Client(cfg, opts), scannedS3Object{bucket: target.Name, accessPoint: target.AccessPoint, key: key}, policy, "on-demand"), nil
+}
+
+// quarantineLinkSignature signs the object and expiry of a quarantine link
+func quarantineLinkSignature(secret, bucket, key, expires string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s", bucket, key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// quarantineLink returns a signed link that quarantines bucket/key from a
+// browser, or "" when SCANNER_PUBLIC_URL or QUARANTINE_LINK_SECRET is unset
+func quarantineLink(bucket, key string) string {
+	base, secret := os.Getenv("SCANNER_PUBLIC_URL"), os.Getenv("QUARANTINE_LINK_SECRET")
+	if base == "" || secret == "" || bucket == "" || key == "" {
+		return ""
+	}
+	ttl, err := time.ParseDuration(getEnv("QUARANTINE_LINK_TTL", defaultQuarantineLinkTTL.String()))
+	if err != nil || ttl <= 0 {
+		ttl = defaultQuarantineLinkTTL
+	}
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	query := url.Values{
+		"bucket":    {bucket},
+		"key":       {key},
+		"expires":   {expires},
+		"signature": {quarantineLinkSignature(secret, bucket, key, expires)},
+	}
+	return strings.TrimRight(base, "/") + quarantineLinkPath + "?" + query.Encode()
+}
+
+// verifyQuarantineLink checks the signature and expiry of a quarantine link
+func verifyQuarantineLink(query url.Values) (bucket, key string, err error) {
+	secret := os.Getenv("QUARANTINE_LINK_SECRET")
+	if secret == "" {
+		return "", "", fmt.Errorf("quarantine links are disabled")
+	}
+	bucket, key, expires := query.Get("bucket"), query.Get("key"), query.Get("expires")
+	want := quarantineLinkSignature(secret, bucket, key, expires)
+	if !hmac.Equal([]byte(want), []byte(query.Get("signature"))) {
+		return "", "", fmt.Errorf("invalid quarantine link signature")
+	}
+	if unix, err := strconv.ParseInt(expires, 10, 64); err != nil || time.Now().Unix() > unix {
+		return "", "", fmt.Errorf("quarantine link has expired")
+	}
+	return bucket, key, nil
+}
+
+// quarantineLinkCaller authenticates requests to a quarantine link by its
+// signature, since they come from a browser without an API key
+func quarantineLinkCaller(r *http.Request) (string, bool) {
+	if r.URL.Path != quarantineLinkPath {
+		return "", false
+	}
+	if _, _, err := verifyQuarantineLink(r.URL.Query()); err != nil {
+		return "", false
+	}
+	return quarantineLinkCallerName, true
+}
+
+// quarantineLinkPage is shown for quarantine links: a confirmation form on
+// GET, so link previews and scanners cannot trigger the action, and the
+// outcome after the form is posted
+var quarantineLinkPage = template.Must(template.New("quarantine").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>FinGuard quarantine</title></head>
+<body style="font-family: sans-serif; max-width: 40em; margin: 3em auto">
+<h2>Quarantine s3://{{.Bucket}}/{{.Key}}</h2>
+{{if .Error}}<p style="color: #b00020">{{.Error}}</p>
+{{else if .Result}}<p>Status: <b>{{.Result.Status}}</b>{{if .Result.Quarantine}}{{if .Result.Quarantine.Destination}}, moved to {{.Result.Quarantine.Destination}}{{end}}{{if .Result.Quarantine.Original}} (original {{.Result.Quarantine.Original}}){{end}}{{end}}</p>
+{{if .Result.Error}}<p style="color: #b00020">{{.Result.Error}}</p>{{end}}
+{{else}}<p>The object is copied to the quarantine bucket and the original is handled as configured for quarantine.</p>
+<form method="post"><button type="submit">Quarantine object</button></form>
+{{end}}</body></html>
+`))
+
+// handleQuarantineLink serves the signed quarantine links put in detection
+// notifications. The object is quarantined with the server's credentials and
+// quarantine settings.
+func handleQuarantineLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var page struct {
+		Bucket, Key string
+		Error       string
+		Result      *RemediationResult
+	}
+	status := http.StatusOK
+	bucket, key, err := verifyQuarantineLink(r.URL.Query())
+	page.Bucket, page.Key = bucket, key
+	switch {
+	case err != nil:
+		status, page.Error = http.StatusForbidden, err.Error()
+	case r.Method == http.MethodPost:
+		policy, err := RemediationPolicy{Action: remediationQuarantine}.withDefaults()
+		if err != nil {
+			status, page.Error = http.StatusServiceUnavailable, err.Error()
+			break
+		}
+		result, err := quarantineOnDemand(r.Context(), S3Options{}, bucket, key, policy)
+		if err != nil {
+			status, page.Error = http.StatusBadGateway, err.Error()
+			break
+		}
+		page.Result = &result
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	quarantineLinkPage.Execute(w, page)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+const (
+	defaultRabbitMQWorkers    = 4
+	defaultRabbitMQMaxRetries = 3
+	rabbitMQRetriesHeader     = "x-finguard-retries"
+	rabbitMQConfirmTimeout    = 30 * time.Second
+)
+
+// RabbitMQConsumer reads ScanRequestMessage tasks from a work queue. A task is
+// acknowledged after its result is confirmed by the broker; tasks that fail
+// too often, or are not scan requests, are rejected so the queue's dead-letter
+// exchange receives them.
+type RabbitMQConsumer struct {
+	scannerClient *amaasclient.AmaasClient
+	url           string
+	queue         string
+	workers       int
+	prefetch      int
+	maxRetries    int
+	resultsExch   string
+	resultsKey    string
+
+	publishMu sync.Mutex
+	publisher *amqp.Channel
+}
+
+// startRabbitMQConsumer consumes RABBITMQ_QUEUE on the broker at url
+// (RABBITMQ_URL, amqp:// or amqps://) in the background
+func startRabbitMQConsumer(ctx context.Context, scannerClient *amaasclient.AmaasClient, url string) error {
+	queue := getEnv("RABBITMQ_QUEUE", "")
+	if queue == "" {
+		return fmt.Errorf("RABBITMQ_QUEUE is required with RABBITMQ_URL")
+	}
+	workers := getEnvInt("RABBITMQ_WORKERS", defaultRabbitMQWorkers)
+	if workers <= 0 {
+		workers = defaultRabbitMQWorkers
+	}
+	c := &RabbitMQConsumer{
+		scannerClient: scannerClient,
+		url:           url,
+		queue:         queue,
+		workers:       workers,
+		prefetch:      getEnvInt("RABBITMQ_PREFETCH", workers),
+		maxRetries:    getEnvInt("RABBITMQ_MAX_RETRIES", defaultRabbitMQMaxRetries),
+		resultsExch:   getEnv("RABBITMQ_RESULTS_EXCHANGE", ""),
+		resultsKey:    getEnv("RABBITMQ_RESULTS_ROUTING_KEY", ""),
+	}
+	if c.prefetch < workers {
+		c.prefetch = workers
+	}
+	log.Printf("- RabbitMQ consumer: queue %s (%d workers, prefetch %d, %d retries)", queue, workers, c.prefetch, c.maxRetries)
+	go c.run(withBackgroundScan(withTenant(ctx, tenantRegistry.get(getEnv("RABBITMQ_TENANT", "")))))
+	return nil
+}
+
+// run consumes until ctx is canceled, reconnecting when the connection drops
+func (c *RabbitMQConsumer) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := c.consume(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("RabbitMQ consumer: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// consume opens a connection and processes deliveries until it closes
+func (c *RabbitMQConsumer) consume(ctx context.Context) error {
+	conn, err := amqp.DialConfig(c.url, amqp.Config{
+		Heartbeat:  10 * time.Second,
+		Properties: amqp.Table{"connection_name": "finguard"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-conn.NotifyClose(make(chan *amqp.Error, 1)):
+		}
+	}()
+
+	publisher, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	if err := publisher.Confirm(false); err != nil {
+		return fmt.Errorf("failed to enable publisher confirms: %v", err)
+	}
+	c.publishMu.Lock()
+	c.publisher = publisher
+	c.publishMu.Unlock()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	if err := ch.Qos(c.prefetch, 0, false); err != nil {
+		return fmt.Errorf("failed to set prefetch: %v", err)
+	}
+	deliveries, err := ch.Consume(c.queue, "finguard-"+newRequestID(), false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to consume %s: %v", c.queue, err)
+	}
+	log.Printf("RabbitMQ consumer: consuming %s", c.queue)
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range deliveries {
+				c.handleDelivery(ctx, d)
+			}
+		}()
+	}
+	wg.Wait()
+	return fmt.Errorf("consumer on %s closed", c.queue)
+}
+
+// handleDelivery scans one task. Failed scans are republished to the queue
+// with an incremented retry count until RABBITMQ_MAX_RETRIES; the last failure
+// and malformed tasks are answered with an error result and dead-lettered.
+func (c *RabbitMQConsumer) handleDelivery(ctx context.Context, d amqp.Delivery) {
+	id := d.MessageId
+	if id == "" {
+		id = d.CorrelationId
+	}
+	if id == "" {
+		id = newRequestID()
+	}
+
+	var req ScanRequestMessage
+	if err := json.Unmarshal(d.Body, &req); err != nil {
+		log.Printf("RabbitMQ consumer: dead-lettering malformed task %s: %v", id, err)
+		c.publishResult(ctx, d, ScanResultMessage{ID: id, Verdict: "error", Error: fmt.Sprintf("invalid scan request: %v", err), ScannedAt: time.Now().UTC()})
+		d.Reject(false)
+		return
+	}
+	if req.ID == "" {
+		req.ID = id
+	}
+
+	result := scanRequestMessage(ctx, c.scannerClient, "rabbitmq", req)
+	if result.Verdict == "malicious" {
+		log.Printf("RabbitMQ consumer: THREAT DETECTED in %s (%s): %v", result.ID, result.Identifier, result.MalwareNames)
+	}
+	if result.Verdict == "error" {
+		retries := rabbitMQRetries(d)
+		if retries < c.maxRetries {
+			log.Printf("RabbitMQ consumer: scan of %s failed (attempt %d), retrying: %s", result.ID, retries+1, result.Error)
+			if err := c.retry(ctx, d, retries+1); err != nil {
+				log.Printf("RabbitMQ consumer: failed to requeue %s: %v", result.ID, err)
+				d.Nack(false, true)
+				return
+			}
+			d.Ack(false)
+			return
+		}
+		log.Printf("RabbitMQ consumer: dead-lettering %s after %d attempts: %s", result.ID, retries+1, result.Error)
+		c.publishResult(ctx, d, result)
+		d.Reject(false)
+		return
+	}
+
+	if err := c.publishResult(ctx, d, result); err != nil {
+		log.Printf("RabbitMQ consumer: failed to publish result of %s: %v", result.ID, err)
+		d.Nack(false, true)
+		return
+	}
+	d.Ack(false)
+}
+
+// rabbitMQRetries returns how often a task was retried, from our header or
+// the delivery count quorum queues maintain
+func rabbitMQRetries(d amqp.Delivery) int {
+	retries := 0
+	for _, header := range []string{rabbitMQRetriesHeader, "x-delivery-count"} {
+		switch v := d.Headers[header].(type) {
+		case int32:
+			retries = max(retries, int(v))
+		case int64:
+			retries = max(retries, int(v))
+		}
+	}
+	return retries
+}
+
+// retry republishes a task to the back of its queue
+func (c *RabbitMQConsumer) retry(ctx context.Context, d amqp.Delivery, retries int) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[rabbitMQRetriesHeader] = int32(retries)
+	return c.publish(ctx, "", c.queue, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     d.MessageId,
+		CorrelationId: d.CorrelationId,
+		ReplyTo:       d.ReplyTo,
+		Body:          d.Body,
+	})
+}
+
+// publishResult sends the result to the task's reply-to queue, or to
+// RABBITMQ_RESULTS_EXCHANGE with RABBITMQ_RESULTS_ROUTING_KEY when set
+func (c *RabbitMQConsumer) publishResult(ctx context.Context, d amqp.Delivery, result ScanResultMessage) error {
+	exchange, key := c.resultsExch, c.resultsKey
+	if d.ReplyTo != "" {
+		exchange, key = "", d.ReplyTo
+	}
+	if key == "" {
+		return nil
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return c.publish(ctx, exchange, key, amqp.Publishing{
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     result.ID,
+		CorrelationId: d.CorrelationId,
+		Timestamp:     result.ScannedAt,
+		Body:          body,
+	})
+}
+
+// publish sends a message and waits for the broker to confirm it
+func (c *RabbitMQConsumer) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	c.publishMu.Lock()
+	publisher := c.publisher
+	c.publishMu.Unlock()
+
+	confirm, err := publisher.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, msg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, rabbitMQConfirmTimeout)
+	defer cancel()
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("broker rejected the message")
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const rateLimiterIdleTTL = 10 * time.Minute
+
+// clientLimiter is a token bucket with its last use, for idle cleanup
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimiter keeps one token bucket per client (API key / JWT subject, or IP)
+type RateLimiter struct {
+	mu         sync.Mutex
+	limit      rate.Limit
+	burst      int
+	trustProxy bool
+	clients    map[string]*clientLimiter
+}
+
+// newRateLimiterFromEnv returns a limiter configured by RATE_LIMIT_RPS and
+// RATE_LIMIT_BURST. It lets every request through while RATE_LIMIT_RPS is
+// unset or zero, and can be reconfigured on reload.
+func newRateLimiterFromEnv() *RateLimiter {
+	rl := &RateLimiter{clients: make(map[string]*clientLimiter)}
+	rl.configure()
+	go rl.cleanup()
+	return rl
+}
+
+// configure applies the rate limit settings from the environment. Existing
+// buckets are dropped so new limits take effect immediately.
+func (rl *RateLimiter) configure() {
+	rps, err := strconv.ParseFloat(getEnv("RATE_LIMIT_RPS", "0"), 64)
+	if err != nil || rps < 0 {
+		rps = 0
+	}
+	burst := getEnvInt("RATE_LIMIT_BURST", int(math.Ceil(rps*2)))
+	if burst <= 0 {
+		burst = 1
+	}
+	if rps > 0 {
+		log.Printf("- Rate limit: %.2f req/s per client, burst %d", rps, burst)
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.limit = rate.Limit(rps)
+	rl.burst = burst
+	rl.trustProxy = getEnv("RATE_LIMIT_TRUST_PROXY", "false") == "true"
+	rl.clients = make(map[string]*clientLimiter)
+}
+
+// settings returns the current limit and proxy trust
+func (rl *RateLimiter) settings() (rate.Limit, bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.limit, rl.trustProxy
+}
+
+// clientKey identifies the caller: the authenticated client when known,
+// otherwise the remote IP (or the first X-Forwarded-For hop behind a trusted proxy)
+func (rl *RateLimiter) clientKey(r *http.Request, trustProxy bool) string {
+	if client := callerFrom(r.Context()); client != "" {
+		return "client:" + client
+	}
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return "ip:" + strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// get returns the bucket for key, creating it on first use
+func (rl *RateLimiter) get(key string) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	c, ok := rl.clients[key]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(rl.limit, rl.burst)}
+		rl.clients[key] = c
+	}
+	c.lastSeen = time.Now()
+	return c.limiter
+}
+
+// cleanup drops buckets for clients not seen recently
+func (rl *RateLimiter) cleanup() {
+	for range time.Tick(time.Minute) {
+		rl.mu.Lock()
+		for key, c := range rl.clients {
+			if time.Since(c.lastSeen) > rateLimiterIdleTTL {
+				delete(rl.clients, key)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+// Wrap rejects requests over the client's rate with 429 and Retry-After.
+// Health probes are not limited.
+func (rl *RateLimiter) Wrap(next http.Handler) http.Handler {
+	if rl == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, trustProxy := rl.settings()
+		if publicPaths[r.URL.Path] || limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := rl.clientKey(r, trustProxy)
+		reservation := rl.get(key).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			loggerFrom(r.Context()).Warn("rate limit exceeded", "client_key", key, "path", r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeJSONError(w, http.StatusTooManyRequests, "Rate limit exceeded", "")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// ReaderFactory opens a scan reader for the location that follows "scheme://"
+// in a URI. Options carry backend-specific settings such as credentials.
+type ReaderFactory func(ctx context.Context, location string, options map[string]string) (amaasclient.AmaasClientReader, error)
+
+// readerBackend pairs a reader factory with the source= tag used for its scans
+type readerBackend struct {
+	source string
+	open   ReaderFactory
+}
+
+// readerFactories maps a URI scheme to the factory for its storage backend.
+// New backends are added here and become available on /scan/uri.
+var readerFactories = map[string]readerBackend{
+	"s3":      {sourceS3, newS3ReaderFromURI},
+	"az":      {sourceAzure, newAzureReaderFromURI},
+	"gs":      {sourceGCS, newGCSReaderFromURI},
+	"gdrive":  {sourceDrive, newDriveReaderFromURI},
+	"msgraph": {sourceGraph, newGraphReaderFromURI},
+	"dropbox": {sourceDropbox, newDropboxReaderFromURI},
+	"http":    {sourceURL, newHTTPReaderFromURI("http")},
+	"https":   {sourceURL, newHTTPReaderFromURI("https")},
+}
+
+// splitScanURI splits a URI into its scheme and the remaining location
+func splitScanURI(uri string) (string, string, error) {
+	scheme, location, ok := strings.Cut(uri, "://")
+	if !ok || scheme == "" || location == "" {
+		return "", "", fmt.Errorf("invalid URI %q, expected scheme://location", uri)
+	}
+	return strings.ToLower(scheme), location, nil
+}
+
+// openReaderForURI dispatches a URI to the registered factory for its scheme
+func openReaderForURI(ctx context.Context, uri string, options map[string]string) (amaasclient.AmaasClientReader, error) {
+	scheme, location, err := splitScanURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	backend, ok := readerFactories[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported URI scheme %q", scheme)
+	}
+	return backend.open(ctx, location, options)
+}
+
+// newS3ReaderFromURI opens an S3 reader for bucket/key
+func newS3ReaderFromURI(ctx context.Context, location string, options map[string]string) (amaasclient.AmaasClientReader, error) {
+	bucket, key, ok := strings.Cut(location, "/")
+	if !ok || bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid S3 location %q, expected s3://bucket/key", location)
+	}
+	return NewS3ClientReader(ctx, S3Options{
+		Profile:         options["profile"],
+		AwsAccessKey:    options["awsAccessKey"],
+		AwsSecretKey:    Secret(options["awsSecretKey"]),
+		Region:          options["region"],
+		EndpointURL:     options["endpointUrl"],
+		ForcePathStyle:  options["forcePathStyle"] == "true",
+		RoleArn:         options["roleArn"],
+		ExternalID:      options["externalId"],
+		RoleSessionName: options["roleSessionName"],
+		RequesterPays:   options["requesterPays"] == "true",
+	}, bucket, key)
+}
+
+// HTTP handler for scanning any registered storage backend by URI
+func handleScanURI(scannerClient *amaasclient.AmaasClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ScanURIRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON request body", "")
+			return
+		}
+		if req.URI == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing required field: uri", "uri")
+			return
+		}
+
+		ctx, attempts := withScanAttempts(r.Context())
+		scheme, _, err := splitScanURI(req.URI)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "uri")
+			return
+		}
+		backend, ok := readerFactories[scheme]
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unsupported URI scheme %q", scheme), "uri")
+			return
+		}
+		if scheme == "s3" {
+			opts := S3Options{Profile: req.Options["profile"], AwsAccessKey: req.Options["awsAccessKey"]}
+			if status, field, err := checkS3Credentials(ctx, opts); err != nil {
+				writeJSONError(w, status, err.Error(), "options."+field)
+				return
+			}
+		}
+
+		reader, err := openReaderForURI(ctx, req.URI, req.Options)
+		if errors.Is(err, errRemoteScanDenied) || errors.Is(err, errOutboundBlocked) {
+			writeJSONError(w, http.StatusForbidden, err.Error(), "uri")
+			return
+		}
+		if errors.Is(err, errURLTooLarge) {
+			writeUploadTooLarge(w, currentRemoteScanPolicy().MaxSize)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to open reader for %s: %v", req.URI, err)
+			http.Error(w, fmt.Sprintf("Failed to open %s: %v", req.URI, err), http.StatusInternalServerError)
+			return
+		}
+
+		tags := buildScanTags(backend.source, getCustomTags(), req.Tags...)
+		log.Printf("Starting URI scan for %s with tags: %v", reader.Identifier(), tags)
+		size, _ := reader.DataSize()
+		scanStart := time.Now()
+		scanResult, err := observeScan(ctx, backend.source, size, func(ctx context.Context) (string, error) {
+			return scanReader(ctx, scannerClient, reader, tags)
+		})
+		if writeScanRejected(w, err) || writeScanTimeout(w, ctx, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("Scan error for %s: %v", reader.Identifier(), err)
+			http.Error(w, fmt.Sprintf("Scan failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("URI scan completed for %s", reader.Identifier())
+		response := ScanURIResponse{
+			URI:        req.URI,
+			Identifier: reader.Identifier(),
+			ScanResult: scanResult,
+			Attempts:   int(attempts.Load()),
+		}
+		if verdict, err := parseScanVerdict(scanResult); err == nil {
+			reportScanOutcome(ctx, ScanOutcome{
+				Source:     backend.source,
+				Identifier: reader.Identifier(),
+				Tags:       tags,
+				Verdict:    verdict,
+				Duration:   time.Since(scanStart),
+				Size:       size,
+			})
+			normalized := verdict.Normalize(time.Since(scanStart), wantsRawResult(r))
+			response.Result = &normalized
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// routedReader is returned by the stub factories of TestOpenReaderForURI
+type routedReader struct {
+	amaasclient.AmaasClientReader
+	scheme   string
+	location string
+}
+
+func TestOpenReaderForURI(t *testing.T) {
+	tests := []struct {
+		uri          string
+		wantScheme   string
+		wantSource   string
+		wantLocation string
+		wantErr      string
+	}{
+		{"s3://bucket/docs/a.pdf", "s3", sourceS3, "bucket/docs/a.pdf", ""},
+		{"S3://bucket/a.pdf", "s3", sourceS3, "bucket/a.pdf", ""},
+		{"az://account/container/blob.zip", "az", sourceAzure, "account/container/blob.zip", ""},
+		{"gs://bucket/object", "gs", sourceGCS, "bucket/object", ""},
+		{"gdrive://file-id", "gdrive", sourceDrive, "file-id", ""},
+		{"msgraph://drives/d/items/i", "msgraph", sourceGraph, "drives/d/items/i", ""},
+		{"dropbox://reports/q1.xlsx", "dropbox", sourceDropbox, "reports/q1.xlsx", ""},
+		{"http://example.com/file", "http", sourceURL, "example.com/file", ""},
+		{"https://example.com/file?sig=x", "https", sourceURL, "example.com/file?sig=x", ""},
+		{"ftp://host/file", "", "", "", `unsupported URI scheme "ftp"`},
+		{"file:///etc/passwd", "", "", "", `unsupported URI scheme "file"`},
+		{"bucket/key", "", "", "", "expected scheme://location"},
+		{"s3://", "", "", "", "expected scheme://location"},
+	}
+
+	// Every scheme keeps its source tag; the factories only record the route
+	registered := readerFactories
+	t.Cleanup(func() { readerFactories = registered })
+	readerFactories = map[string]readerBackend{}
+	for scheme, backend := range registered {
+		scheme := scheme
+		readerFactories[scheme] = readerBackend{backend.source, func(_ context.Context, location string, _ map[string]string) (amaasclient.AmaasClientReader, error) {
+			return routedReader{scheme: scheme, location: location}, nil
+		}}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			reader, err := openReaderForURI(context.Background(), tt.uri, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("openReaderForURI(%s) error = %v, want %q", tt.uri, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("openReaderForURI(%s): %v", tt.uri, err)
+			}
+			routed := reader.(routedReader)
+			if routed.scheme != tt.wantScheme || routed.location != tt.wantLocation {
+				t.Errorf("routed to %s with %q, want %s with %q", routed.scheme, routed.location, tt.wantScheme, tt.wantLocation)
+			}
+			if source := readerFactories[tt.wantScheme].source; source != tt.wantSource {
+				t.Errorf("source = %s, want %s", source, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestScanURIRejects(t *testing.T) {
+	t.Setenv("REMOTE_SCAN_ALLOWED_HOSTS", "downloads.example.com,localhost")
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"unknown scheme", `{"uri":"ftp://host/file"}`, http.StatusBadRequest},
+		{"missing scheme", `{"uri":"bucket/key"}`, http.StatusBadRequest},
+		{"host outside the allowlist", `{"uri":"https://other.example.com/file"}`, http.StatusForbidden},
+		{"plain http", `{"uri":"http://downloads.example.com/file"}`, http.StatusForbidden},
+		{"allowlisted host resolving to loopback", `{"uri":"https://localhost/file"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handleScanURI(nil)(rec, httptest.NewRequest(http.MethodPost, "/scan/uri", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
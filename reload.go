@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// reloadHook re-applies one group of settings from the environment
+type reloadHook struct {
+	name string
+	fn   func() error
+}
+
+var (
+	reloadMu    sync.Mutex
+	reloadHooks []reloadHook
+)
+
+// ReloadResult reports which settings were reloaded
+type ReloadResult struct {
+	Reloaded   []string          `json:"reloaded"`
+	Errors     map[string]string `json:"errors,omitempty"`
+	ReloadedAt string            `json:"reloadedAt"`
+}
+
+// onReload registers fn to run on SIGHUP and POST /admin/reload
+func onReload(name string, fn func() error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloadHooks = append(reloadHooks, reloadHook{name: name, fn: fn})
+}
+
+// reloadConfig re-reads the config file and re-applies every reloadable
+// setting: custom tags, remediation rules, scan policy rules, the hash
+// allowlist and blocklist, the remote scan policy, rate limits and notifiers. Listener, authentication and scanner backend settings
+// need a restart. In-flight scans keep the settings they started with.
+func reloadConfig() ReloadResult {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	result := ReloadResult{Reloaded: []string{}, ReloadedAt: time.Now().Format(time.RFC3339)}
+	if configFilePath != "" {
+		if err := loadConfigFile(configFilePath); err != nil {
+			// Keep the current settings rather than applying half a file
+			log.Printf("Reload: config file not applied: %v", err)
+			result.Errors = map[string]string{"config file": err.Error()}
+			return result
+		}
+		result.Reloaded = append(result.Reloaded, "config file")
+	}
+	// Tags are read from FSS_CUSTOM_TAGS on every scan
+	result.Reloaded = append(result.Reloaded, "custom tags")
+
+	for _, hook := range reloadHooks {
+		if err := hook.fn(); err != nil {
+			log.Printf("Reload: %s failed: %v", hook.name, err)
+			if result.Errors == nil {
+				result.Errors = map[string]string{}
+			}
+			result.Errors[hook.name] = err.Error()
+			continue
+		}
+		result.Reloaded = append(result.Reloaded, hook.name)
+	}
+	log.Printf("Configuration reloaded: %v", result.Reloaded)
+	return result
+}
+
+// registerReloadHooks wires the startup-configured components into reloads
+func registerReloadHooks(rateLimiter *RateLimiter) {
+	onReload("remediation rules", reloadRemediationRules)
+	onReload("scan policy", reloadScanPolicy)
+	onReload("hash allowlist", hashAllowlist.reload)
+	onReload("hash blocklist", hashBlocklist.reload)
+	onReload("AWS clients", awsClients.flush)
+	onReload("rate limits", func() error {
+		rateLimiter.configure()
+		return nil
+	})
+	onReload("notifiers", func() error {
+		initNotifiers(context.Background())
+		return nil
+	})
+	if secretResolver != nil {
+		onReload("secrets", func() error {
+			return secretResolver.refresh(context.Background())
+		})
+	}
+}
+
+// reloadOnSIGHUP reloads the configuration every time the process gets SIGHUP
+func reloadOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			log.Printf("Received SIGHUP, reloading configuration")
+			reloadConfig()
+		}
+	}()
+}
+
+// HTTP handler that reloads the configuration at /admin/reload
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	loggerFrom(r.Context()).Info("configuration reload requested", "client", callerFrom(r.Context()))
+
+	result := reloadConfig()
+	w.Header().Set("Content-Type", "application/json")
+	if len(result.Errors) > 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Remediation actions applied to an object after a malicious verdict
+const (
+	remediationNone       = "none"
+	remediationTag        = "tag"
+	remediationQuarantine = "quarantine"
+	remediationDelete     = "delete"
+)
+
+// Remediation outcomes reported in RemediationResult.Status
+const (
+	remediationApplied = "applied"
+	remediationDryRun  = "dry-run"
+	remediationFailed  = "failed"
+)
+
+// Tag added to infected objects by the tag action
+const infectedTagKey = "finguard-verdict"
+
+// RemediationPolicy says what to do with an infected object. DryRun reports
+// the action that would be taken without changing anything.
+type RemediationPolicy struct {
+	Action     string             `json:"action"`
+	DryRun     bool               `json:"dryRun"`
+	Quarantine *QuarantineOptions `json:"quarantine,omitempty"`
+}
+
+// RemediationRule applies a policy to every object in Bucket under Prefix
+type RemediationRule struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"`
+	RemediationPolicy
+}
+
+// RemediationResult is the audit record of a remediation action
+type RemediationResult struct {
+	Action     string            `json:"action"`
+	Status     string            `json:"status"`
+	DryRun     bool              `json:"dryRun,omitempty"`
+	Rule       string            `json:"rule,omitempty"`
+	Quarantine *QuarantineResult `json:"quarantine,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Bucket rules loaded at startup by initRemediation and replaced on reload
+var (
+	remediationMu    sync.RWMutex
+	remediationRules []RemediationRule
+)
+
+// initRemediation loads the bucket rules at startup
+func initRemediation() {
+	if err := reloadRemediationRules(); err != nil {
+		log.Printf("Warning: remediation rules disabled: %v", err)
+	}
+}
+
+// reloadRemediationRules replaces the bucket rules. On error the current
+// rules are kept.
+func reloadRemediationRules() error {
+	rules, err := loadRemediationRules()
+	if err != nil {
+		return err
+	}
+	remediationMu.Lock()
+	remediationRules = rules
+	remediationMu.Unlock()
+	log.Printf("- Remediation rules: %d", len(rules))
+	return nil
+}
+
+// loadRemediationRules reads the per-bucket rules from REMEDIATION_RULES_FILE,
+// a JSON array of {"bucket", "prefix", "action", "dryRun", "quarantine"}, or
+// from the remediation.rules section of the config file
+func loadRemediationRules() ([]RemediationRule, error) {
+	data, source := configRemediationRules, "config file rules"
+	if path := os.Getenv("REMEDIATION_RULES_FILE"); path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+		source = path
+	}
+	if data == nil {
+		return nil, nil
+	}
+	var rules []RemediationRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", source, err)
+	}
+	valid := make([]RemediationRule, 0, len(rules))
+	for _, rule := range rules {
+		policy, err := rule.RemediationPolicy.withDefaults()
+		if err != nil {
+			log.Printf("Warning: skipping remediation rule for %s/%s: %v", rule.Bucket, rule.Prefix, err)
+			continue
+		}
+		rule.RemediationPolicy = policy
+		valid = append(valid, rule)
+	}
+	return valid, nil
+}
+
+// withDefaults validates the policy and fills quarantine options from the environment.
+// REMEDIATION_DRY_RUN=true forces dry-run for every policy.
+func (p RemediationPolicy) withDefaults() (RemediationPolicy, error) {
+	if p.Action == "" {
+		p.Action = remediationNone
+	}
+	if os.Getenv("REMEDIATION_DRY_RUN") == "true" {
+		p.DryRun = true
+	}
+
+	switch p.Action {
+	case remediationNone, remediationTag, remediationDelete:
+		return p, nil
+	case remediationQuarantine:
+		opts := QuarantineOptions{}
+		if p.Quarantine != nil {
+			opts = *p.Quarantine
+		}
+		opts = opts.withDefaults()
+		if err := opts.validate(); err != nil {
+			return p, err
+		}
+		p.Quarantine = &opts
+		return p, nil
+	}
+	return p, fmt.Errorf("invalid remediation action %q, expected none, tag, quarantine or delete", p.Action)
+}
+
+// requestRemediation validates the remediation requested on an S3 scan.
+// The quarantine field is shorthand for {"action": "quarantine"}.
+func requestRemediation(policy *RemediationPolicy, quarantine *QuarantineOptions) (*RemediationPolicy, error) {
+	if policy == nil && quarantine != nil {
+		policy = &RemediationPolicy{Action: remediationQuarantine, Quarantine: quarantine}
+	}
+	if policy == nil {
+		return nil, nil
+	}
+	resolved, err := policy.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	return &resolved, nil
+}
+
+// matches reports whether the rule covers bucket/key
+func (r RemediationRule) matches(bucket, key string) bool {
+	return r.Bucket == bucket && strings.HasPrefix(key, r.Prefix)
+}
+
+// resolveRemediation picks the policy for an object: the request policy when
+// given, otherwise the remediation of the scan policy rule in ctx, otherwise
+// the first matching bucket rule. ok is false when no action applies.
+func resolveRemediation(ctx context.Context, requested *RemediationPolicy, bucket, key string) (policy RemediationPolicy, rule string, ok bool) {
+	if requested != nil {
+		return *requested, "", requested.Action != remediationNone
+	}
+	if scanRule := scanPolicyFrom(ctx); scanRule != nil && scanRule.Remediation != nil {
+		return *scanRule.Remediation, "policy " + scanRule.Name, scanRule.Remediation.Action != remediationNone
+	}
+	remediationMu.RLock()
+	defer remediationMu.RUnlock()
+	for _, r := range remediationRules {
+		if r.matches(bucket, key) {
+			return r.RemediationPolicy, fmt.Sprintf("%s/%s", r.Bucket, r.Prefix), r.Action != remediationNone
+		}
+	}
+	return RemediationPolicy{}, "", false
+}
+
+// remediateS3Object applies the remediation policy to an infected object and
+// writes an audit log line for the action, including dry runs and failures
+func remediateS3Object(ctx context.Context, client *s3.Client, obj scannedS3Object, policy RemediationPolicy, rule string) RemediationResult {
+	bucket, key := obj.bucket, obj.key
+	result := RemediationResult{Action: policy.Action, DryRun: policy.DryRun, Rule: rule, Status: remediationApplied}
+
+	switch {
+	case policy.DryRun:
+		result.Status = remediationDryRun
+		s3Logger.Printf("Remediation dry run: would %s s3://%s/%s", policy.Action, bucket, key)
+	case policy.Action == remediationTag:
+		if err := tagS3Object(ctx, client, bucket, key, obj.versionID, map[string]string{infectedTagKey: "malicious"}); err != nil {
+			result.Error = err.Error()
+		}
+	case policy.Action == remediationQuarantine:
+		q := quarantineS3Object(ctx, client, obj, *policy.Quarantine)
+		result.Quarantine = &q
+		result.Error = q.Error
+	case policy.Action == remediationDelete:
+		if err := deleteS3Object(ctx, client, bucket, key, obj.versionID, obj.etag); err != nil {
+			result.Error = err.Error()
+		}
+	}
+	if result.Error != "" {
+		result.Status = remediationFailed
+	}
+
+	loggerFrom(ctx).Info("remediation",
+		"client", callerFrom(ctx),
+		"bucket", bucket,
+		"key", key,
+		"action", result.Action,
+		"status", result.Status,
+		"dry_run", result.DryRun,
+		"rule", result.Rule,
+		"error", result.Error,
+	)
+	emitRemediation(RemediationEvent{
+		RequestID: requestIDFrom(ctx),
+		Tenant:    tenantName(ctx),
+		Client:    callerFrom(ctx),
+		Bucket:    bucket,
+		Key:       key,
+		Result:    result,
+	})
+	return result
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+const defaultRemoteScanTimeout = 60 * time.Second
+
+// RemoteScanPolicy limits what /scan/remote may fetch
+type RemoteScanPolicy struct {
+	AllowedHosts []string // exact hosts or *.domain wildcards
+	MaxSize      int64
+	Timeout      time.Duration
+}
+
+// loadRemoteScanPolicy reads REMOTE_SCAN_ALLOWED_HOSTS (comma separated),
+// REMOTE_SCAN_MAX_SIZE_MB (defaults to MAX_UPLOAD_SIZE_MB) and REMOTE_SCAN_TIMEOUT
+func loadRemoteScanPolicy() RemoteScanPolicy {
+	policy := RemoteScanPolicy{
+		MaxSize: getMaxUploadSize(),
+		Timeout: defaultRemoteScanTimeout,
+	}
+	for _, host := range strings.Split(os.Getenv("REMOTE_SCAN_ALLOWED_HOSTS"), ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			policy.AllowedHosts = append(policy.AllowedHosts, host)
+		}
+	}
+	if mb := getEnvInt("REMOTE_SCAN_MAX_SIZE_MB", 0); mb > 0 {
+		policy.MaxSize = int64(mb) << 20
+	}
+	if raw := os.Getenv("REMOTE_SCAN_TIMEOUT"); raw != "" {
+		if timeout, err := time.ParseDuration(raw); err == nil && timeout > 0 {
+			policy.Timeout = timeout
+		} else {
+			log.Printf("Warning: invalid REMOTE_SCAN_TIMEOUT %q, using %s", raw, defaultRemoteScanTimeout)
+		}
+	}
+	return policy
+}
+
+// remoteScanPolicy is the policy loaded by handleScanRemote, which reloads it
+var remoteScanPolicy atomic.Pointer[RemoteScanPolicy]
+
+// currentRemoteScanPolicy returns the loaded policy, or reads it from the
+// environment before handleScanRemote has loaded one
+func currentRemoteScanPolicy() RemoteScanPolicy {
+	if policy := remoteScanPolicy.Load(); policy != nil {
+		return *policy
+	}
+	return loadRemoteScanPolicy()
+}
+
+// hostAllowed reports whether host matches the allowlist
+func (p RemoteScanPolicy) hostAllowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range p.AllowedHosts {
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURL rejects non-HTTPS URLs and hosts outside the allowlist
+func (p RemoteScanPolicy) checkURL(u *url.URL) error {
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("url must be an absolute https URL")
+	}
+	if !p.hostAllowed(u.Hostname()) {
+		return fmt.Errorf("host %q is not in REMOTE_SCAN_ALLOWED_HOSTS", u.Hostname())
+	}
+	return nil
+}
+
+// httpClient returns a client that applies the allowlist to every redirect
+// and refuses internal addresses even for allowlisted hosts
+func (p RemoteScanPolicy) httpClient() *http.Client {
+	client := newGuardedHTTPClient(p.Timeout)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return p.checkURL(req.URL)
+	}
+	return client
+}
+
+// HTTP handler that downloads an allowlisted HTTPS URL and scans it
+func handleScanRemote(scannerClient *amaasclient.AmaasClient) http.HandlerFunc {
+	load := func() error {
+		policy := loadRemoteScanPolicy()
+		if len(policy.AllowedHosts) == 0 {
+			log.Printf("- Remote URL scanning: disabled (set REMOTE_SCAN_ALLOWED_HOSTS)")
+		} else {
+			log.Printf("- Remote URL scanning: %d allowed host(s), max %d MB, timeout %s", len(policy.AllowedHosts), policy.MaxSize>>20, policy.Timeout)
+		}
+		remoteScanPolicy.Store(&policy)
+		return nil
+	}
+	load()
+	onReload("remote scan policy", load)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		policy := remoteScanPolicy.Load()
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ScanURLRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON request body", "")
+			return
+		}
+		if req.URL == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing required field: url", "url")
+			return
+		}
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "callbackUrl")
+			return
+		}
+
+		u, err := url.Parse(req.URL)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid url", "url")
+			return
+		}
+		if err := policy.checkURL(u); err != nil {
+			writeJSONError(w, http.StatusForbidden, err.Error(), "url")
+			return
+		}
+
+		// The timeout covers the download and the scan
+		ctx, cancel := context.WithTimeout(r.Context(), policy.Timeout)
+		defer cancel()
+
+		reader, err := newHTTPURLReader(ctx, policy.httpClient(), req.URL, policy.MaxSize)
+		if err == nil && reader.size > policy.MaxSize {
+			err = errURLTooLarge
+		}
+		if errors.Is(err, errURLTooLarge) {
+			writeUploadTooLarge(w, policy.MaxSize)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to fetch remote URL for scanning: %v", err)
+			writeURLFetchError(w, err)
+			return
+		}
+
+		tags := buildScanTags(sourceURL, getCustomTags(), append(req.Tags, "host="+u.Hostname())...)
+		serveURLScan(ctx, w, scannerClient, reader, tags, req.CallbackURL, wantsRawResult(r))
+	}
+}
+
+// errRemoteScanDenied is returned when a URI is outside the remote scan policy
+var errRemoteScanDenied = errors.New("url is not allowed by the remote scan policy")
+
+// newHTTPReaderFromURI returns the factory for http:// and https:// URIs. They
+// are fetched under the /scan/remote policy: allowlisted HTTPS hosts only,
+// with its size cap, timeout and redirect checks.
+func newHTTPReaderFromURI(scheme string) ReaderFactory {
+	return func(ctx context.Context, location string, _ map[string]string) (amaasclient.AmaasClientReader, error) {
+		policy := currentRemoteScanPolicy()
+		rawURL := scheme + "://" + location
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL %q", rawURL)
+		}
+		if err := policy.checkURL(u); err != nil {
+			return nil, fmt.Errorf("%w: %v", errRemoteScanDenied, err)
+		}
+		reader, err := newHTTPURLReader(ctx, policy.httpClient(), rawURL, policy.MaxSize)
+		if err == nil && reader.size > policy.MaxSize {
+			err = errURLTooLarge
+		}
+		return reader, err
+	}
+}
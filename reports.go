@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Report periods, in UTC: a calendar day, or an ISO week starting Monday
+const (
+	reportDaily  = "daily"
+	reportWeekly = "weekly"
+)
+
+const (
+	defaultReportRetain = 30
+	defaultReportTop    = 10
+	reportBuildLimit    = 10 * time.Minute
+)
+
+var (
+	errReportNotFound  = errors.New("report not found")
+	errInvalidReportID = errors.New("invalid report id, expected daily-YYYY-MM-DD or weekly-YYYY-MM-DD")
+)
+
+// ReportCount is one row of a report's top lists
+type ReportCount struct {
+	Name     string `json:"name"`
+	Scans    int    `json:"scans,omitempty"`
+	Infected int    `json:"infected"`
+	Bytes    int64  `json:"bytes,omitempty"`
+}
+
+// ScanReport summarizes the scan history of one period
+type ScanReport struct {
+	ID           string        `json:"id"`
+	Period       string        `json:"period"`
+	Tenant       string        `json:"tenant,omitempty"`
+	From         time.Time     `json:"from"`
+	To           time.Time     `json:"to"`
+	GeneratedAt  time.Time     `json:"generatedAt"`
+	TotalScans   int           `json:"totalScans"`
+	Clean        int           `json:"clean"`
+	Infected     int           `json:"infected"`
+	Skipped      int           `json:"skipped,omitempty"` // skipped by scan policy rules
+	Other        int           `json:"other,omitempty"`   // errors and unknown verdicts
+	BytesScanned int64         `json:"bytesScanned"`
+	TopMalware   []ReportCount `json:"topMalware"`
+	TopBuckets   []ReportCount `json:"topBuckets"`
+}
+
+// ReportListResponse is returned by GET /reports
+type ReportListResponse struct {
+	Reports []ScanReport `json:"reports"`
+}
+
+// ReportStore builds daily and weekly reports from the scan history on a
+// schedule and keeps the most recent ones in memory. Reports for earlier
+// periods are built on request.
+type ReportStore struct {
+	mu      sync.Mutex
+	reports map[string]ScanReport
+	retain  int
+	top     int
+	cron    *cron.Cron
+}
+
+// reportStore is configured by initReports; nil disables reports
+var reportStore *ReportStore
+
+// initReports enables reports when scan history is enabled. The reports of
+// the last complete day and week are built at startup, the next ones shortly
+// after each period ends.
+func initReports() {
+	if scanHistory == nil {
+		return
+	}
+	rs := &ReportStore{
+		reports: make(map[string]ScanReport),
+		retain:  getEnvInt("REPORTS_RETAIN", defaultReportRetain),
+		top:     getEnvInt("REPORTS_TOP", defaultReportTop),
+		cron:    cron.New(cron.WithLocation(time.UTC)),
+	}
+	rs.cron.AddFunc("5 0 * * *", func() { rs.generate(reportDaily) })
+	rs.cron.AddFunc("10 0 * * 1", func() { rs.generate(reportWeekly) })
+	rs.cron.Start()
+	go func() {
+		rs.generate(reportDaily)
+		rs.generate(reportWeekly)
+	}()
+	reportStore = rs
+	log.Printf("- Reports: daily and weekly, keeping %d of each", rs.retain)
+}
+
+// Close stops the report schedule
+func (rs *ReportStore) Close() {
+	if rs != nil {
+		<-rs.cron.Stop().Done()
+	}
+}
+
+// reportBounds returns the period containing t
+func reportBounds(period string, t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	from := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if period == reportWeekly {
+		from = from.AddDate(0, 0, -(int(from.Weekday())+6)%7)
+		return from, from.AddDate(0, 0, 7)
+	}
+	return from, from.AddDate(0, 0, 1)
+}
+
+// reportID names the report of a period: daily-2026-10-15, or
+// weekly-2026-10-12 for the week starting that Monday, with the tenant
+// appended after a slash for tenant reports
+func reportID(period string, from time.Time, tenant string) string {
+	id := period + "-" + from.Format(time.DateOnly)
+	if tenant != "" {
+		id += "/" + tenant
+	}
+	return id
+}
+
+// parseReportID returns the period and start of a report ID without tenant
+func parseReportID(id string) (string, time.Time, error) {
+	period, date, _ := strings.Cut(id, "-")
+	day, err := time.Parse(time.DateOnly, date)
+	if err != nil || (period != reportDaily && period != reportWeekly) {
+		return "", time.Time{}, errInvalidReportID
+	}
+	from, _ := reportBounds(period, day)
+	return period, from, nil
+}
+
+// generate builds the reports of the last complete period, for the whole
+// deployment and for every tenant
+func (rs *ReportStore) generate(period string) {
+	from, _ := reportBounds(period, time.Now())
+	from, _ = reportBounds(period, from.Add(-time.Second))
+	tenants := []string{""}
+	if tenantRegistry != nil {
+		for name := range tenantRegistry.tenants {
+			tenants = append(tenants, name)
+		}
+	}
+	for _, tenant := range tenants {
+		ctx, cancel := context.WithTimeout(context.Background(), reportBuildLimit)
+		report, err := rs.build(ctx, period, from, tenant)
+		cancel()
+		if err != nil {
+			log.Printf("Warning: failed to build %s report: %v", reportID(period, from, tenant), err)
+			continue
+		}
+		rs.store(report)
+	}
+}
+
+// build aggregates the scan history of one period
+func (rs *ReportStore) build(ctx context.Context, period string, from time.Time, tenant string) (ScanReport, error) {
+	_, to := reportBounds(period, from)
+	report := ScanReport{
+		ID:     reportID(period, from, tenant),
+		Period: period,
+		Tenant: tenant,
+		From:   from,
+		To:     to,
+	}
+	malware := make(map[string]*ReportCount)
+	buckets := make(map[string]*ReportCount)
+	filter := HistoryFilter{Tenant: tenant, From: from, To: to}
+	err := scanHistory.Each(ctx, filter, func(record ScanRecord) error {
+		// Skipped files were not scanned, so they only count as skipped
+		if isSkippedVerdict(record.Verdict) {
+			report.Skipped++
+			return nil
+		}
+		report.TotalScans++
+		report.BytesScanned += record.SizeBytes
+		infected := record.Verdict == "malicious"
+		switch {
+		case infected:
+			report.Infected++
+		case record.Verdict == "clean" || record.Verdict == verdictAllowlisted:
+			report.Clean++
+		default:
+			report.Other++
+		}
+		if record.Bucket != "" {
+			count := buckets[record.Bucket]
+			if count == nil {
+				count = &ReportCount{Name: record.Bucket}
+				buckets[record.Bucket] = count
+			}
+			count.Scans++
+			count.Bytes += record.SizeBytes
+			if infected {
+				count.Infected++
+			}
+		}
+		for _, name := range record.MalwareNames {
+			count := malware[name]
+			if count == nil {
+				count = &ReportCount{Name: name}
+				malware[name] = count
+			}
+			count.Infected++
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	report.TopMalware = topReportCounts(malware, rs.top)
+	report.TopBuckets = topReportCounts(buckets, rs.top)
+	report.GeneratedAt = time.Now().UTC()
+	return report, nil
+}
+
+// topReportCounts returns the n counts with the most infections, then scans
+func topReportCounts(counts map[string]*ReportCount, n int) []ReportCount {
+	top := make([]ReportCount, 0, len(counts))
+	for _, count := range counts {
+		top = append(top, *count)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Infected != top[j].Infected {
+			return top[i].Infected > top[j].Infected
+		}
+		if top[i].Scans != top[j].Scans {
+			return top[i].Scans > top[j].Scans
+		}
+		return top[i].Name < top[j].Name
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// store keeps a report, dropping the oldest of its period and tenant beyond
+// REPORTS_RETAIN
+func (rs *ReportStore) store(report ScanReport) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.reports[report.ID] = report
+	var same []ScanReport
+	for _, r := range rs.reports {
+		if r.Period == report.Period && r.Tenant == report.Tenant {
+			same = append(same, r)
+		}
+	}
+	if len(same) <= rs.retain {
+		return
+	}
+	sort.Slice(same, func(i, j int) bool { return same[i].From.After(same[j].From) })
+	for _, r := range same[rs.retain:] {
+		delete(rs.reports, r.ID)
+	}
+}
+
+// List returns the stored reports visible to ctx, newest first
+func (rs *ReportStore) List(ctx context.Context, period string) []ScanReport {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	reports := []ScanReport{}
+	for _, report := range rs.reports {
+		if (period == "" || report.Period == period) && tenantOwns(ctx, report.Tenant) {
+			reports = append(reports, report)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if !reports[i].From.Equal(reports[j].From) {
+			return reports[i].From.After(reports[j].From)
+		}
+		return reports[i].ID < reports[j].ID
+	})
+	return reports
+}
+
+// Get returns the report with id for the tenant in ctx, building it from the
+// history when it is not stored. Reports of periods that have not ended are
+// built on every request and not stored.
+func (rs *ReportStore) Get(ctx context.Context, id string) (ScanReport, error) {
+	base, tenant, _ := strings.Cut(id, "/")
+	if name := tenantName(ctx); name != "" {
+		if tenant != "" && tenant != name {
+			return ScanReport{}, errReportNotFound
+		}
+		tenant = name
+	}
+	period, from, err := parseReportID(base)
+	if err != nil {
+		return ScanReport{}, err
+	}
+	id = reportID(period, from, tenant)
+	rs.mu.Lock()
+	report, ok := rs.reports[id]
+	rs.mu.Unlock()
+	if ok {
+		return report, nil
+	}
+	if from.After(time.Now()) {
+		return ScanReport{}, errReportNotFound
+	}
+	if report, err = rs.build(ctx, period, from, tenant); err != nil {
+		return report, err
+	}
+	if !report.To.After(report.GeneratedAt) {
+		rs.store(report)
+	}
+	return report, nil
+}
+
+// reportPage renders a report, or the list of reports, as HTML
+var reportPage = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes": formatReportBytes,
+	"date":  func(t time.Time) string { return t.Format(time.DateOnly) },
+	"pct": func(part, total int) string {
+		if total == 0 {
+			return "0%"
+		}
+		return fmt.Sprintf("%.1f%%", float64(part)*100/float64(total))
+	},
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>FinGuard reports</title>
+<style>body { font-family: sans-serif; max-width: 60em; margin: 2em auto } table { border-collapse: collapse; margin-bottom: 1.5em }
+th, td { border: 1px solid #ccc; padding: .3em .8em; text-align: left } td.n { text-align: right } .bad { color: #b00020 }</style></head>
+<body>
+{{with .Report}}<h2>FinGuard {{.Period}} report: {{date .From}}{{if eq .Period "weekly"}} to {{date .To}}{{end}}{{if .Tenant}} ({{.Tenant}}){{end}}</h2>
+<table>
+<tr><th>Total scans</th><td class="n">{{.TotalScans}}</td></tr>
+<tr><th>Clean</th><td class="n">{{.Clean}} ({{pct .Clean .TotalScans}})</td></tr>
+<tr><th>Infected</th><td class="n{{if .Infected}} bad{{end}}">{{.Infected}} ({{pct .Infected .TotalScans}})</td></tr>
+{{if .Skipped}}<tr><th>Skipped by policy</th><td class="n">{{.Skipped}}</td></tr>{{end}}
+{{if .Other}}<tr><th>Errors</th><td class="n">{{.Other}}</td></tr>{{end}}
+<tr><th>Bytes scanned</th><td class="n">{{bytes .BytesScanned}}</td></tr>
+</table>
+<h3>Top malware</h3>
+{{if .TopMalware}}<table><tr><th>Malware</th><th>Detections</th></tr>
+{{range .TopMalware}}<tr><td>{{.Name}}</td><td class="n">{{.Infected}}</td></tr>
+{{end}}</table>{{else}}<p>No detections.</p>{{end}}
+<h3>Top buckets</h3>
+{{if .TopBuckets}}<table><tr><th>Bucket</th><th>Infected</th><th>Scans</th><th>Bytes</th></tr>
+{{range .TopBuckets}}<tr><td>{{.Name}}</td><td class="n">{{.Infected}}</td><td class="n">{{.Scans}}</td><td class="n">{{bytes .Bytes}}</td></tr>
+{{end}}</table>{{else}}<p>No bucket scans.</p>{{end}}
+<p><small>Generated {{.GeneratedAt.Format "2006-01-02 15:04 MST"}}</small></p>
+{{else}}<h2>FinGuard reports</h2>
+{{if .Reports}}<table><tr><th>Report</th><th>Scans</th><th>Infected</th><th>Bytes</th></tr>
+{{range .Reports}}<tr><td><a href="/reports/{{.ID}}?format=html">{{.ID}}</a></td><td class="n">{{.TotalScans}}</td><td class="n{{if .Infected}} bad{{end}}">{{.Infected}}</td><td class="n">{{bytes .BytesScanned}}</td></tr>
+{{end}}</table>{{else}}<p>No reports yet.</p>{{end}}
+{{end}}</body></html>
+`))
+
+// formatReportBytes formats a byte count with a binary unit
+func formatReportBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// wantsHTML reports whether the report should be rendered as HTML: with
+// format=html, or for browsers asking for text/html without a format
+func wantsHTML(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "html"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// handleReports serves GET /reports, the stored reports, GET /reports/latest,
+// the latest report of a period, and GET /reports/{id}
+func handleReports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if reportStore == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Reports are disabled (set HISTORY_DSN)", "")
+		return
+	}
+	if format := r.URL.Query().Get("format"); format != "" && format != "json" && format != "html" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported report format %q, expected json or html", format), "format")
+		return
+	}
+	period := r.URL.Query().Get("period")
+	if period != "" && period != reportDaily && period != reportWeekly {
+		writeJSONError(w, http.StatusBadRequest, "period must be daily or weekly", "period")
+		return
+	}
+
+	var page struct {
+		Report  *ScanReport
+		Reports []ScanReport
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/reports"), "/")
+	switch id {
+	case "":
+		page.Reports = reportStore.List(r.Context(), period)
+	case "latest":
+		if period == "" {
+			period = reportDaily
+		}
+		// Callers without a tenant get the deployment's report, not a tenant's
+		for _, report := range reportStore.List(r.Context(), period) {
+			if report.Tenant == tenantName(r.Context()) {
+				page.Report = &report
+				break
+			}
+		}
+		if page.Report == nil {
+			writeJSONError(w, http.StatusNotFound, errReportNotFound.Error(), "")
+			return
+		}
+	default:
+		report, err := reportStore.Get(r.Context(), id)
+		switch {
+		case errors.Is(err, errReportNotFound):
+			writeJSONError(w, http.StatusNotFound, err.Error(), "id")
+			return
+		case errors.Is(err, errInvalidReportID):
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "id")
+			return
+		case err != nil:
+			loggerFrom(r.Context()).Error("report failed", "report", id, "error", err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to build report", "")
+			return
+		}
+		page.Report = &report
+	}
+
+	if wantsHTML(r) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		reportPage.Execute(w, page)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if page.Report != nil {
+		json.NewEncoder(w).Encode(page.Report)
+		return
+	}
+	json.NewEncoder(w).Encode(ReportListResponse{Reports: page.Reports})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultScanRetryAttempts  = 3
+	defaultScanRetryBaseDelay = 250 * time.Millisecond
+	defaultScanRetryMaxDelay  = 5 * time.Second
+)
+
+// scanRetryPolicy retries scanner calls that fail with a transient gRPC or
+// network error, waiting a jittered exponential backoff between attempts
+type scanRetryPolicy struct {
+	attempts  int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// scanRetry is the process-wide policy used by observeScan
+var scanRetry = newScanRetryPolicyFromEnv()
+
+// newScanRetryPolicyFromEnv reads SCAN_RETRY_ATTEMPTS, SCAN_RETRY_BASE_DELAY
+// and SCAN_RETRY_MAX_DELAY. One attempt disables retries.
+func newScanRetryPolicyFromEnv() scanRetryPolicy {
+	policy := scanRetryPolicy{
+		attempts:  getEnvInt("SCAN_RETRY_ATTEMPTS", defaultScanRetryAttempts),
+		baseDelay: defaultScanRetryBaseDelay,
+		maxDelay:  defaultScanRetryMaxDelay,
+	}
+	if policy.attempts < 1 {
+		policy.attempts = 1
+	}
+	if d, err := time.ParseDuration(getEnv("SCAN_RETRY_BASE_DELAY", "")); err == nil && d > 0 {
+		policy.baseDelay = d
+	}
+	if d, err := time.ParseDuration(getEnv("SCAN_RETRY_MAX_DELAY", "")); err == nil && d > 0 {
+		policy.maxDelay = d
+	}
+	return policy
+}
+
+// do calls scan until it succeeds, fails with a permanent error or the
+// attempt budget is used up. It returns the number of attempts made.
+func (p scanRetryPolicy) do(ctx context.Context, source string, scan func(ctx context.Context) (string, error)) (string, int, error) {
+	for attempt := 1; ; attempt++ {
+		scanResult, err := scan(ctx)
+		if err == nil || attempt >= p.attempts || ctx.Err() != nil || !isTransientScanError(err) {
+			return scanResult, attempt, err
+		}
+
+		delay := p.backoff(attempt)
+		scanRetries.WithLabelValues(source).Inc()
+		loggerFrom(ctx).Warn("transient scan failure, retrying",
+			"source", source,
+			"attempt", attempt,
+			"max_attempts", p.attempts,
+			"delay", delay.String(),
+			"error", err,
+		)
+		select {
+		case <-ctx.Done():
+			return scanResult, attempt, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff returns a random delay between half and all of baseDelay*2^(attempt-1),
+// capped at maxDelay
+func (p scanRetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.baseDelay << (attempt - 1)
+	if ceiling <= 0 || ceiling > p.maxDelay {
+		ceiling = p.maxDelay
+	}
+	return ceiling/2 + time.Duration(rand.Int63n(int64(ceiling/2)+1))
+}
+
+// isTransientScanError reports whether a failed scan is worth retrying:
+// gRPC codes the service uses for overload and connection loss, rate
+// limiting, and network errors raised while reading the scanned content
+func isTransientScanError(err error) bool {
+	if errors.Is(err, errScanQueueFull) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+			return true
+		case codes.Internal:
+			// The SDK reports HTTP 429 from the service as Internal
+			return strings.Contains(st.Message(), "429")
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+type scanAttemptsKey struct{}
+
+// withScanAttempts returns a context in which observeScan records how many
+// attempts the latest scan took, for handlers that report it to the caller
+func withScanAttempts(ctx context.Context) (context.Context, *atomic.Int32) {
+	attempts := new(atomic.Int32)
+	return context.WithValue(ctx, scanAttemptsKey{}, attempts), attempts
+}
+
+// recordScanAttempts stores the attempt count in ctx when it is tracked
+func recordScanAttempts(ctx context.Context, attempts int) {
+	if counter, ok := ctx.Value(scanAttemptsKey{}).(*atomic.Int32); ok {
+		counter.Store(int32(attempts))
+	}
+}
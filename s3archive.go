@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// What scans do with archived objects, which cannot be read until restored
+const (
+	archivedSkip    = "skip"
+	archivedRestore = "restore"
+)
+
+// verdictArchived is recorded for archived objects that were not scanned
+const verdictArchived = "archived"
+
+// Restore states reported in ArchiveResult
+const (
+	restoreStarted    = "started"
+	restoreInProgress = "in-progress"
+	restoreCompleted  = "restored"
+	restoreFailed     = "failed"
+)
+
+// Archive defaults, overridden by S3_ARCHIVED_* and S3_RESTORE_*
+const (
+	defaultRestoreDays         = 1
+	defaultRestorePollInterval = 30 * time.Second
+)
+
+// ArchivedObjectOptions say what a scan does with objects in S3 Glacier
+// Flexible Retrieval, Glacier Deep Archive or an Intelligent-Tiering archive
+// tier: skip them, or start a restore and wait up to Wait for it before
+// scanning
+type ArchivedObjectOptions struct {
+	Action string `json:"action"`         // skip or restore
+	Tier   string `json:"tier,omitempty"` // Standard, Bulk or Expedited
+	Days   int32  `json:"days,omitempty"` // how long the restored copy is kept
+	Wait   string `json:"wait,omitempty"` // duration; 0 only starts the restore
+}
+
+// ArchiveResult reports an archived object and its restore
+type ArchiveResult struct {
+	StorageClass string `json:"storageClass,omitempty"`
+	Restore      string `json:"restore,omitempty"` // started, in-progress, restored or failed
+	Error        string `json:"error,omitempty"`
+}
+
+// requestArchived fills the archived object options of a request from
+// S3_ARCHIVED_ACTION, S3_RESTORE_TIER, S3_RESTORE_DAYS and S3_RESTORE_WAIT
+func requestArchived(requested *ArchivedObjectOptions) (*ArchivedObjectOptions, error) {
+	opts := ArchivedObjectOptions{}
+	if requested != nil {
+		opts = *requested
+	}
+	if opts.Action == "" {
+		opts.Action = getEnv("S3_ARCHIVED_ACTION", archivedSkip)
+	}
+	if opts.Tier == "" {
+		opts.Tier = getEnv("S3_RESTORE_TIER", string(types.TierStandard))
+	}
+	if opts.Days == 0 {
+		opts.Days = int32(getEnvInt("S3_RESTORE_DAYS", defaultRestoreDays))
+	}
+	if opts.Wait == "" {
+		opts.Wait = getEnv("S3_RESTORE_WAIT", "0s")
+	}
+
+	opts.Action = strings.ToLower(opts.Action)
+	if opts.Action != archivedSkip && opts.Action != archivedRestore {
+		return nil, fmt.Errorf("invalid archived action %q, expected skip or restore", opts.Action)
+	}
+	switch types.Tier(opts.Tier) {
+	case types.TierStandard, types.TierBulk, types.TierExpedited:
+	default:
+		return nil, fmt.Errorf("invalid restore tier %q, expected Standard, Bulk or Expedited", opts.Tier)
+	}
+	if opts.Days < 1 {
+		return nil, fmt.Errorf("restore days must be at least 1")
+	}
+	if wait, err := time.ParseDuration(opts.Wait); err != nil || wait < 0 {
+		return nil, fmt.Errorf("invalid restore wait %q", opts.Wait)
+	}
+	return &opts, nil
+}
+
+// isArchiveStorageClass reports whether objects of the storage class must
+// be restored before they are read
+func isArchiveStorageClass(class string) bool {
+	return class == string(types.StorageClassGlacier) || class == string(types.StorageClassDeepArchive)
+}
+
+// isInvalidObjectState reports whether S3 refused to read an archived object
+func isInvalidObjectState(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidObjectState"
+}
+
+// headArchiveState returns whether the object HeadObject describes is
+// archived without a readable restored copy, and whether a restore is
+// running
+func headArchiveState(head *s3.HeadObjectOutput) (archived, restoring bool) {
+	if !isArchiveStorageClass(string(head.StorageClass)) && head.ArchiveStatus == "" {
+		return false, false
+	}
+	// Restore is `ongoing-request="false", expiry-date="..."` once a copy is readable
+	restore := aws.ToString(head.Restore)
+	if strings.Contains(restore, `ongoing-request="false"`) {
+		return false, false
+	}
+	return true, strings.Contains(restore, `ongoing-request="true"`)
+}
+
+// refreshArchive reads the size, storage class and restore state of the
+// object again with HeadObject
+func (r *S3ClientReader) refreshArchive(ctx context.Context) error {
+	info, err := probeS3ObjectWith(ctx, r.client, r.bucket, r.key, s3ProbeHead, r.sse)
+	if err != nil {
+		return err
+	}
+	r.size, r.etag, r.versionID = info.size, normalizeETag(info.etag), info.versionID
+	r.storageClass, r.archived, r.restoring = info.storageClass, info.archived, info.restoring
+	r.prefetch = s3PrefetchDepth(info.size)
+	return nil
+}
+
+// handleArchivedObject applies opts to an archived object before its scan.
+// It returns nil for objects that are not archived. With restore, a
+// restore is started unless one is running and awaited for up to the
+// wait; the reader is readable again when it completes in time.
+func handleArchivedObject(ctx context.Context, reader *S3ClientReader, opts *ArchivedObjectOptions) *ArchiveResult {
+	if !reader.archived {
+		return nil
+	}
+	result := &ArchiveResult{StorageClass: reader.storageClass}
+	if opts == nil || opts.Action != archivedRestore {
+		s3Logger.Printf("Skipping archived object %s (%s)", reader.Identifier(), reader.storageClass)
+		return result
+	}
+
+	result.Restore = restoreInProgress
+	if !reader.restoring {
+		request := &types.RestoreRequest{GlacierJobParameters: &types.GlacierJobParameters{Tier: types.Tier(opts.Tier)}}
+		// Intelligent-Tiering archive tiers restore in place and take no days
+		if reader.storageClass != string(types.StorageClassIntelligentTiering) {
+			request.Days = aws.Int32(opts.Days)
+		}
+		_, err := reader.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+			Bucket:         &reader.bucket,
+			Key:            &reader.key,
+			RestoreRequest: request,
+		})
+		var apiErr smithy.APIError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress":
+		case err != nil:
+			s3Logger.Printf("ERROR: Failed to restore %s: %v", reader.Identifier(), err)
+			s3Restores.WithLabelValues(restoreFailed).Inc()
+			result.Restore, result.Error = restoreFailed, err.Error()
+			return result
+		default:
+			s3Logger.Printf("Started %s restore of %s for %d day(s)", opts.Tier, reader.Identifier(), opts.Days)
+			s3Restores.WithLabelValues(restoreStarted).Inc()
+			result.Restore = restoreStarted
+		}
+	}
+
+	wait, _ := time.ParseDuration(opts.Wait)
+	if wait <= 0 {
+		return result
+	}
+	interval, err := time.ParseDuration(getEnv("S3_RESTORE_POLL_INTERVAL", defaultRestorePollInterval.String()))
+	if err != nil || interval <= 0 {
+		log.Printf("Warning: invalid S3_RESTORE_POLL_INTERVAL, using %s", defaultRestorePollInterval)
+		interval = defaultRestorePollInterval
+	}
+	interval = min(interval, wait)
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return result
+		case <-time.After(min(interval, time.Until(deadline))):
+		}
+		if err := reader.refreshArchive(ctx); err != nil {
+			log.Printf("Warning: failed to check the restore of %s: %v", reader.Identifier(), err)
+			continue
+		}
+		if !reader.archived {
+			s3Logger.Printf("Restore of %s completed", reader.Identifier())
+			s3Restores.WithLabelValues(restoreCompleted).Inc()
+			result.Restore = restoreCompleted
+			return result
+		}
+	}
+	s3Logger.Printf("Restore of %s still running after %s", reader.Identifier(), wait)
+	return result
+}
+
+// archivedResult is the scan result recorded for an archived object that
+// was not scanned
+func archivedResult(reader *S3ClientReader) string {
+	scansTotal.WithLabelValues(sourceS3, verdictArchived).Inc()
+	result, _ := json.Marshal(map[string]interface{}{
+		"scanResult":    0,
+		"foundMalwares": []interface{}{},
+		"skipped":       true,
+		"archived":      true,
+		"storageClass":  reader.storageClass,
+		"fileSize":      reader.size,
+	})
+	return string(result)
+}
+
+// sniff detects the type of the object from its first bytes, unless it is
+// archived and cannot be read
+func (r *S3ClientReader) sniff() string {
+	if r.archived {
+		return ""
+	}
+	return sniffReader(r)
+}
+
+// scanS3Reader scans an S3 object under observeScan, or returns the
+// archived result for objects that are still archived
+func scanS3Reader(ctx context.Context, scanClient *amaasclient.AmaasClient, reader *S3ClientReader, tags []string) (string, error) {
+	if reader.archived {
+		return archivedResult(reader), nil
+	}
+	return observeScan(ctx, sourceS3, reader.size, func(ctx context.Context) (string, error) {
+		return scanReader(ctx, scanClient, reader, tags)
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// S3Bucket is a bucket reference resolved from a plain name or an ARN
+type S3Bucket struct {
+	Name        string // value passed in the S3 API Bucket field
+	Region      string // region taken from the ARN, empty for plain names
+	AccessPoint bool   // Name is an access point ARN
+	MultiRegion bool   // Name is a Multi-Region Access Point ARN
+}
+
+// mrapClientRegion is the client region used with Multi-Region Access
+// Points. Their requests are routed to the nearest bucket and signed for
+// every region with SigV4A, but the client still needs a region to
+// resolve the endpoint.
+const mrapClientRegion = "us-east-1"
+
+// resolveBucket accepts a bucket name, a bucket ARN (arn:aws:s3:::name), an
+// access point ARN (arn:aws:s3:region:account:accesspoint/name) or a
+// Multi-Region Access Point ARN (arn:aws:s3::account:accesspoint/alias.mrap).
+// Access point ARNs are forwarded unchanged since the S3 API accepts them as
+// the bucket.
+func resolveBucket(bucket string) (S3Bucket, error) {
+	if !arn.IsARN(bucket) {
+		return S3Bucket{Name: bucket}, nil
+	}
+
+	parsed, err := arn.Parse(bucket)
+	if err != nil {
+		return S3Bucket{}, fmt.Errorf("invalid bucket ARN %q: %v", bucket, err)
+	}
+	if parsed.Service != "s3" && parsed.Service != "s3-object-lambda" {
+		return S3Bucket{}, fmt.Errorf("unsupported ARN service %q for bucket", parsed.Service)
+	}
+
+	switch {
+	case strings.HasPrefix(parsed.Resource, "accesspoint/") || strings.HasPrefix(parsed.Resource, "accesspoint:"):
+		if len(parsed.Resource) == len("accesspoint/") || parsed.AccountID == "" {
+			return S3Bucket{}, fmt.Errorf("invalid access point ARN %q, expected an account and access point name", bucket)
+		}
+		// Only Multi-Region Access Points have no region
+		if parsed.Region == "" {
+			return S3Bucket{Name: bucket, Region: mrapClientRegion, AccessPoint: true, MultiRegion: true}, nil
+		}
+		return S3Bucket{Name: bucket, Region: parsed.Region, AccessPoint: true}, nil
+	case parsed.Region == "" && parsed.AccountID == "" && !strings.ContainsAny(parsed.Resource, "/:"):
+		// Bucket ARNs are not accepted by the object APIs, use the bucket name
+		return S3Bucket{Name: parsed.Resource}, nil
+	}
+	return S3Bucket{}, fmt.Errorf("unsupported S3 ARN resource %q", parsed.Resource)
+}
+
+// clientOptions adjusts opts for the addressing of the bucket: access points
+// are only reachable on AWS and always use virtual-hosted addressing, so
+// custom endpoints are rejected and path-style addressing is turned off
+func (b S3Bucket) clientOptions(opts S3Options) (S3Options, error) {
+	if !b.AccessPoint {
+		return opts, nil
+	}
+	if opts.EndpointURL != "" {
+		return opts, fmt.Errorf("access point ARNs are not supported with the custom endpoint %s", opts.EndpointURL)
+	}
+	opts.ForcePathStyle = false
+	return opts, nil
+}
+
+// objectIdentifier returns a display identifier for an object in the bucket
+func (b S3Bucket) objectIdentifier(key string) string {
+	if b.AccessPoint {
+		return fmt.Sprintf("%s/object/%s", b.Name, key)
+	}
+	return fmt.Sprintf("s3://%s/%s", b.Name, key)
+}
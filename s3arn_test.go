@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+func TestResolveBucket(t *testing.T) {
+	tests := []struct {
+		bucket  string
+		want    S3Bucket
+		wantErr bool
+	}{
+		{"uploads", S3Bucket{Name: "uploads"}, false},
+		{"arn:aws:s3:::uploads", S3Bucket{Name: "uploads"}, false},
+		{"arn:aws:s3:us-west-2:123456789012:accesspoint/finance", S3Bucket{Name: "arn:aws:s3:us-west-2:123456789012:accesspoint/finance", Region: "us-west-2", AccessPoint: true}, false},
+		{"arn:aws:s3-object-lambda:us-west-2:123456789012:accesspoint/redact", S3Bucket{Name: "arn:aws:s3-object-lambda:us-west-2:123456789012:accesspoint/redact", Region: "us-west-2", AccessPoint: true}, false},
+		{"arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap", S3Bucket{Name: "arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap", Region: mrapClientRegion, AccessPoint: true, MultiRegion: true}, false},
+		{"arn:aws:s3:us-west-2::accesspoint/finance", S3Bucket{}, true},
+		{"arn:aws:sqs:us-west-2:123456789012:queue", S3Bucket{}, true},
+	}
+	for _, tt := range tests {
+		got, err := resolveBucket(tt.bucket)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("resolveBucket(%q) = %+v, %v; want %+v, error %v", tt.bucket, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// accessPointTransport sends requests for any host to a fakeS3, prefixing the
+// path with the original host so the object is found under "host/key"
+type accessPointTransport struct {
+	target *url.URL
+	mu     sync.Mutex
+	hosts  []string
+}
+
+func (a *accessPointTransport) Do(req *http.Request) (*http.Response, error) {
+	a.mu.Lock()
+	a.hosts = append(a.hosts, req.URL.Host)
+	a.mu.Unlock()
+	req = req.Clone(req.Context())
+	req.URL.Path = "/" + req.URL.Host + req.URL.Path
+	req.URL.RawPath = ""
+	req.URL.Scheme, req.URL.Host, req.Host = a.target.Scheme, a.target.Host, a.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestAccessPointARNForwardedAsBucket(t *testing.T) {
+	tests := []struct {
+		name     string
+		bucket   string
+		wantHost string
+	}{
+		{"access point", "arn:aws:s3:us-west-2:123456789012:accesspoint/finance", "finance-123456789012.s3-accesspoint.us-west-2.amazonaws.com"},
+		{"object lambda access point", "arn:aws:s3-object-lambda:us-west-2:123456789012:accesspoint/redact", "redact-123456789012.s3-object-lambda.us-west-2.amazonaws.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeS3(map[string]string{tt.wantHost + "/docs/report.pdf": "hello"})
+			target, _ := url.Parse(fake.serve(t))
+			transport := &accessPointTransport{target: target}
+
+			// The reader loads its config through the cache, which is seeded
+			// with one whose requests go to the fake
+			awsClients = NewAWSClientCache()
+			t.Cleanup(func() { awsClients = nil })
+			awsClients.config(S3Options{}, "us-west-2", func() (aws.Config, error) {
+				return aws.Config{
+					Region:      "us-west-2",
+					Credentials: credentials.NewStaticCredentialsProvider("test", "test", ""),
+					HTTPClient:  transport,
+				}, nil
+			})
+
+			reader, err := NewS3ClientReader(context.Background(), S3Options{Region: "us-west-2"}, tt.bucket, "docs/report.pdf")
+			if err != nil {
+				t.Fatalf("NewS3ClientReader: %v", err)
+			}
+			if reader.bucket != tt.bucket {
+				t.Errorf("reader bucket = %q, want the ARN %q", reader.bucket, tt.bucket)
+			}
+			if size, _ := reader.DataSize(); size != int64(len("hello")) {
+				t.Errorf("size = %d, want %d", size, len("hello"))
+			}
+			if len(transport.hosts) == 0 {
+				t.Fatal("no requests were sent")
+			}
+			for _, host := range transport.hosts {
+				if host != tt.wantHost {
+					t.Errorf("request sent to %s, want the access point host %s", host, tt.wantHost)
+				}
+			}
+		})
+	}
+}
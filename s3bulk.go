@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+const (
+	defaultBucketScanWorkers = 4
+	jobTypeBucketScan        = "s3-bucket-scan"
+)
+
+// BucketScanRequest is the body accepted by /s3/scan-bucket
+type BucketScanRequest struct {
+	S3Options
+	Bucket  string   `json:"bucket"`
+	Prefix  string   `json:"prefix"`
+	Tags    []string `json:"tags"`
+	Workers int      `json:"workers"`
+	// CallbackURL receives the final job state when the scan finishes
+	CallbackURL string `json:"callbackUrl"`
+	// Remediation is applied to infected objects; it overrides bucket rules
+	Remediation *RemediationPolicy `json:"remediation"`
+	// Quarantine is shorthand for a quarantine remediation
+	Quarantine *QuarantineOptions `json:"quarantine"`
+	// SkipUnchanged reuses the previous verdict for objects whose ETag is unchanged
+	SkipUnchanged bool `json:"skipUnchanged"`
+	// DryRun reports the remediation of infected objects, from the request,
+	// scan policy or bucket rules, without changing them
+	DryRun bool `json:"dryRun"`
+	// Archived says what to do with Glacier and Deep Archive objects
+	Archived *ArchivedObjectOptions `json:"archived"`
+}
+
+// Get the bucket scan worker count from the request or S3_SCAN_WORKERS
+func getBucketScanWorkers(requested int) int {
+	workers := requested
+	if workers <= 0 {
+		workers, _ = strconv.Atoi(getEnv("S3_SCAN_WORKERS", strconv.Itoa(defaultBucketScanWorkers)))
+	}
+	if workers <= 0 {
+		workers = defaultBucketScanWorkers
+	}
+	return workers
+}
+
+// HTTP handler that enqueues an asynchronous scan of every object under bucket/prefix
+func handleScanBucket(scannerClient *amaasclient.AmaasClient, jobs *JobManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		s3Logger.Printf("=== BUCKET SCAN REQUEST at %s ===", time.Now().Format(time.RFC3339))
+
+		var req BucketScanRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "callbackUrl")
+			return
+		}
+		remediation, err := requestRemediation(req.Remediation, req.Quarantine)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "remediation")
+			return
+		}
+		req.Remediation, req.Quarantine = remediation, nil
+		if req.Archived, err = requestArchived(req.Archived); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "archived")
+			return
+		}
+		if req.SkipUnchanged && scanHistory == nil {
+			writeJSONError(w, http.StatusBadRequest, "skipUnchanged requires scan history (HISTORY_DSN)", "skipUnchanged")
+			return
+		}
+
+		job, err := jobs.Submit(r.Context(), jobTypeBucketScan, fmt.Sprintf("s3://%s/%s", req.Bucket, req.Prefix), req.CallbackURL, req)
+		if err != nil {
+			s3Logger.Printf("ERROR: Failed to queue bucket scan: %v", err)
+			writeJSONError(w, http.StatusServiceUnavailable, fmt.Sprintf("Failed to queue bucket scan: %v", err), "")
+			return
+		}
+		s3Logger.Printf("Queued bucket scan job %s for s3://%s/%s", job.ID(), req.Bucket, req.Prefix)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(JobAcceptedResponse{
+			JobID:  job.ID(),
+			Status: JobQueued,
+		})
+	}
+}
+
+// bucketScanRunner returns the job runner for bucket scans
+func bucketScanRunner(scannerClient *amaasclient.AmaasClient) JobRunner {
+	return func(ctx context.Context, job *Job) error {
+		var req BucketScanRequest
+		if err := json.Unmarshal(job.Params(), &req); err != nil {
+			return fmt.Errorf("invalid bucket scan parameters: %v", err)
+		}
+		return runBucketScan(ctx, scannerClient, job, req)
+	}
+}
+
+// runBucketScan lists the bucket page by page and scans every object with a
+// worker pool. The job is checkpointed after each page, so a paused or
+// restarted job lists from its cursor and skips objects already recorded.
+func runBucketScan(ctx context.Context, scannerClient *amaasclient.AmaasClient, job *Job, req BucketScanRequest) error {
+	ctx = withBackgroundScan(ctx)
+	opts := req.S3Options.withDefaults()
+
+	target, err := resolveBucket(req.Bucket)
+	if err == nil {
+		opts, err = target.clientOptions(opts)
+	}
+	if err != nil {
+		return err
+	}
+	region := opts.Region
+	if target.Region != "" {
+		region = target.Region
+	}
+
+	cfg, err := loadAWSConfig(ctx, opts, region)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %v", err)
+	}
+
+	// Detect the bucket region when the caller did not provide one
+	if region == "" && !target.AccessPoint && opts.EndpointURL == "" {
+		if detected, err := getBucketRegion(ctx, cfg, opts, target.Name); err == nil {
+			s3Logger.Printf("Job %s: bucket %s is in region %s", job.ID(), target.Name, detected)
+			cfg.Region = detected
+		}
+	}
+	client := newS3Client(cfg, opts)
+
+	// Scans in flight when the job is paused or canceled run to completion
+	scanCtx := context.WithoutCancel(ctx)
+	workers := getBucketScanWorkers(req.Workers)
+	cursor := job.Cursor()
+	s3Logger.Printf("Job %s: scanning s3://%s/%s with %d workers (resuming after %q)", job.ID(), req.Bucket, req.Prefix, workers, cursor)
+
+	// The total grows as pages are listed; objects scanned before a pause or
+	// restart are already counted
+	job.SetDryRun(req.DryRun)
+	job.Start(job.Snapshot(false).Scanned)
+	done := job.CompletedKeys()
+
+	err = listObjectPages(ctx, client, target.Name, req.Prefix, cursor, func(page []types.Object) error {
+		// Skip folder placeholder objects and objects already scanned
+		objects := make([]types.Object, 0, len(page))
+		for _, obj := range page {
+			key := aws.ToString(obj.Key)
+			if (strings.HasSuffix(key, "/") && aws.ToInt64(obj.Size) == 0) || done[key] {
+				continue
+			}
+			objects = append(objects, obj)
+		}
+		job.AddTotal(len(objects))
+
+		queue := make(chan types.Object)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for obj := range queue {
+					reader := &S3ClientReader{
+						ctx:          scanCtx,
+						client:       client,
+						bucket:       target.Name,
+						accessPoint:  target.AccessPoint,
+						key:          aws.ToString(obj.Key),
+						size:         aws.ToInt64(obj.Size),
+						etag:         normalizeETag(aws.ToString(obj.ETag)),
+						readAhead:    s3ReadAhead(),
+						prefetch:     s3PrefetchDepth(aws.ToInt64(obj.Size)),
+						storageClass: string(obj.StorageClass),
+					}
+					if req.SkipUnchanged {
+						if previous, ok := lookupUnchanged(scanCtx, reader); ok {
+							job.Record(JobObjectResult{Key: reader.key, Verdict: previous.Verdict, MalwareNames: previous.MalwareNames, Skipped: true, Result: previous.Result})
+							continue
+						}
+					}
+					// Listings show the storage class; HeadObject tells whether a restored copy is readable
+					var archive *ArchiveResult
+					if isArchiveStorageClass(reader.storageClass) {
+						if err := reader.refreshArchive(scanCtx); err != nil {
+							job.Record(JobObjectResult{Key: reader.key, Verdict: "error", Error: err.Error()})
+							continue
+						}
+						archive = handleArchivedObject(scanCtx, reader, req.Archived)
+					}
+					result := scanBulkObject(scannerClient, reader, req.Tags, req.Remediation, req.DryRun, job.ID())
+					result.Archive = archive
+					job.Record(result)
+				}
+			}()
+		}
+
+		// Stop dispatching once the job is paused or canceled
+		stopped := false
+		for _, obj := range objects {
+			select {
+			case queue <- obj:
+				continue
+			case <-ctx.Done():
+				stopped = true
+			}
+			break
+		}
+		close(queue)
+		wg.Wait()
+		if stopped {
+			return ctx.Err()
+		}
+
+		// Every object of the page is scanned, resume after it from now on
+		if len(page) > 0 {
+			job.Checkpoint(aws.ToString(page[len(page)-1].Key))
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		s3Logger.Printf("Job %s: stopped scanning s3://%s/%s", job.ID(), req.Bucket, req.Prefix)
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list objects: %v", err)
+	}
+
+	s3Logger.Printf("Job %s: finished scanning s3://%s/%s", job.ID(), req.Bucket, req.Prefix)
+	return nil
+}
+
+// scanBulkObject scans a single object for a bulk job. With dryRun, the
+// remediation of an infected object is only reported.
+func scanBulkObject(scannerClient *amaasclient.AmaasClient, reader *S3ClientReader, extraTags []string, remediation *RemediationPolicy, dryRun bool, jobID string) JobObjectResult {
+	result := JobObjectResult{Key: reader.key}
+
+	tags := buildScanTags(sourceS3, getCustomTags(), append(append([]string{}, extraTags...), "file_type="+path.Ext(reader.key))...)
+	// The scan binds its own context to the reader; keep the job context
+	ctx, scanClient, _ := scanPolicyClient(reader.ctx, scannerClient, ScanTarget{
+		Source: sourceS3, Bucket: reader.bucket, Key: reader.key, DetectedType: reader.sniff(), Size: reader.size,
+	}, ScanOptions{})
+	scanStart := time.Now()
+	scanResult, _, err := scanUnlessListed(ctx, nil, "", reader.key, "", func() (string, error) {
+		return scanS3Reader(ctx, scanClient, reader, tags)
+	})
+	if err != nil {
+		s3Logger.Printf("Job %s: scan FAILED for %s: %v", jobID, reader.Identifier(), err)
+		result.Verdict = "error"
+		result.Error = err.Error()
+		return result
+	}
+
+	verdict, err := parseScanVerdict(scanResult)
+	if err != nil {
+		result.Verdict = "error"
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Verdict = verdict.Label()
+	result.MalwareNames = verdict.MalwareNames
+	result.Skipped = verdict.Skipped
+	normalized := verdict.Normalize(time.Since(scanStart), false)
+	result.Result = &normalized
+	reportScanOutcome(ctx, ScanOutcome{
+		Source:     sourceS3,
+		Bucket:     reader.bucket,
+		Key:        reader.key,
+		ETag:       reader.etag,
+		Identifier: reader.Identifier(),
+		Tags:       tags,
+		Verdict:    verdict,
+		Duration:   time.Since(scanStart),
+		Size:       reader.size,
+	})
+	if !verdict.IsSafe {
+		if policy, rule, ok := resolveRemediation(ctx, remediation, reader.bucket, reader.key); ok {
+			policy.DryRun = policy.DryRun || dryRun
+			r := remediateS3Object(ctx, reader.client, reader.scannedObject(), policy, rule)
+			result.Remediation = &r
+		}
+	}
+	s3Logger.Printf("Job %s: %s is %s", jobID, reader.Identifier(), result.Verdict)
+	return result
+}
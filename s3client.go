@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// S3Options are the connection settings shared by every /s3/* request body.
+// EndpointURL and ForcePathStyle target S3-compatible stores such as MinIO,
+// Ceph or Wasabi and default to S3_ENDPOINT_URL and S3_FORCE_PATH_STYLE.
+// RoleArn assumes a role (e.g. in another account) on top of the base credentials.
+// RequesterPays accepts the request charges of requester-pays buckets.
+// Profile selects credentials configured on the server instead of inline keys.
+type S3Options struct {
+	Profile         string `json:"profile,omitempty"`
+	AwsAccessKey    string `json:"awsAccessKey"`
+	AwsSecretKey    Secret `json:"awsSecretKey"`
+	Region          string `json:"region"`
+	EndpointURL     string `json:"endpointUrl"`
+	ForcePathStyle  bool   `json:"forcePathStyle"`
+	RoleArn         string `json:"roleArn"`
+	ExternalID      string `json:"externalId"`
+	RoleSessionName string `json:"roleSessionName"`
+	RequesterPays   bool   `json:"requesterPays,omitempty"`
+}
+
+// withDefaults fills unset options from the credential profile, falling back
+// to S3_DEFAULT_PROFILE when the request has no credentials of its own, and
+// then from the environment
+func (o S3Options) withDefaults() S3Options {
+	if o.Profile == "" && o.AwsAccessKey == "" {
+		o.Profile = os.Getenv("S3_DEFAULT_PROFILE")
+	}
+	if profile, ok := awsProfiles[o.Profile]; ok {
+		if o.Region == "" {
+			o.Region = profile.Region
+		}
+		if o.EndpointURL == "" {
+			o.EndpointURL = profile.EndpointURL
+		}
+		o.ForcePathStyle = o.ForcePathStyle || profile.ForcePathStyle
+		o.RequesterPays = o.RequesterPays || profile.RequesterPays
+	}
+	if o.EndpointURL == "" {
+		o.EndpointURL = os.Getenv("S3_ENDPOINT_URL")
+	}
+	if os.Getenv("S3_FORCE_PATH_STYLE") == "true" {
+		o.ForcePathStyle = true
+	}
+	// S3-compatible stores still need a signing region
+	if o.EndpointURL != "" && o.Region == "" {
+		o.Region = getEnv("S3_ENDPOINT_REGION", "us-east-1")
+	}
+	return o
+}
+
+// s3EndpointHTTPClient connects to endpoints from requests, refusing
+// internal addresses after DNS resolution and on redirects
+var s3EndpointHTTPClient = newGuardedHTTPClient(0)
+
+// callerEndpoint reports whether EndpointURL came from the request rather
+// than S3_ENDPOINT_URL or the credential profile, which the operator trusts
+func (o S3Options) callerEndpoint() bool {
+	if o.EndpointURL == "" || o.EndpointURL == os.Getenv("S3_ENDPOINT_URL") {
+		return false
+	}
+	if profile, ok := awsProfiles[o.Profile]; ok && o.EndpointURL == profile.EndpointURL {
+		return false
+	}
+	return true
+}
+
+// checkEndpoint rejects request endpoints that are not absolute http(s)
+// URLs or that point at loopback, private or link-local addresses
+func (o S3Options) checkEndpoint() error {
+	if !o.callerEndpoint() {
+		return nil
+	}
+	u, err := parseHTTPURL(o.EndpointURL)
+	if err != nil {
+		return fmt.Errorf("endpointUrl must be an absolute http or https URL")
+	}
+	if err := checkOutboundHost(u.Hostname()); err != nil {
+		return fmt.Errorf("endpointUrl is not allowed: %w", err)
+	}
+	return nil
+}
+
+// loadAWSConfig loads an AWS config for region using the credential profile or
+// request credentials when provided, or the default credential chain otherwise.
+// When RoleArn is set the resulting credentials are used to assume that role.
+// Configs are reused from awsClients until AWS_CLIENT_CACHE_TTL.
+func loadAWSConfig(ctx context.Context, opts S3Options, region string) (aws.Config, error) {
+	if opts.Profile == "" && opts.AwsAccessKey != "" && rejectInlineCredentials() {
+		return aws.Config{}, errInlineCredentials
+	}
+	if err := opts.checkEndpoint(); err != nil {
+		return aws.Config{}, err
+	}
+	return awsClients.config(opts, region, func() (aws.Config, error) {
+		return resolveAWSConfig(ctx, opts, region)
+	})
+}
+
+// resolveAWSConfig builds the config loadAWSConfig returns
+func resolveAWSConfig(ctx context.Context, opts S3Options, region string) (aws.Config, error) {
+	var cfg aws.Config
+	var err error
+
+	if opts.Profile != "" {
+		cfg, err = loadProfileConfig(ctx, opts.Profile, region)
+	} else if opts.AwsAccessKey != "" && opts.AwsSecretKey != "" {
+		s3Logger.Println("Using provided AWS credentials")
+		cfg, err = config.LoadDefaultConfig(ctx,
+			config.WithRegion(region),
+			config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(opts.AwsAccessKey, string(opts.AwsSecretKey), "")),
+		)
+	} else {
+		s3Logger.Println("Using default AWS credentials from environment")
+		cfg, err = config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	}
+	if err != nil || opts.RoleArn == "" {
+		return cfg, err
+	}
+
+	s3Logger.Printf("Assuming role %s", opts.RoleArn)
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), opts.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = opts.RoleSessionName
+		if o.RoleSessionName == "" {
+			o.RoleSessionName = fmt.Sprintf("finguard-%d", time.Now().Unix())
+		}
+		if opts.ExternalID != "" {
+			o.ExternalID = aws.String(opts.ExternalID)
+		}
+	})
+	cfg.Credentials = aws.NewCredentialsCache(provider)
+	return cfg, nil
+}
+
+// newS3Client creates an S3 client that honors the region embedded in access
+// point ARNs and any custom S3-compatible endpoint; endpoints from requests
+// are reached through the outbound guard. With RequesterPays every
+// request carries the header the RequestPayer parameter sets, so reads,
+// attribute probes, listings and quarantine copies all accept the charges.
+// cfg must be loaded from opts, since clients are reused from awsClients by
+// opts and region.
+func newS3Client(cfg aws.Config, opts S3Options) *s3.Client {
+	return awsClients.client(cfg, opts, func() *s3.Client {
+		return s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UseARNRegion = true
+			if opts.EndpointURL != "" {
+				o.BaseEndpoint = aws.String(opts.EndpointURL)
+			}
+			if opts.callerEndpoint() {
+				o.HTTPClient = s3EndpointHTTPClient
+			}
+			o.UsePathStyle = opts.ForcePathStyle
+			if opts.RequesterPays {
+				o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue("X-Amz-Request-Payer", "requester"))
+			}
+		})
+	})
+}
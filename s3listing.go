@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	defaultListConcurrency = 4
+	maxListConcurrency     = 16
+	maxListAttempts        = 5
+)
+
+// Get the sharded listing concurrency from environment, bounded to a sane range
+func getListConcurrency(requested int) int {
+	concurrency := requested
+	if concurrency <= 0 {
+		concurrency, _ = strconv.Atoi(getEnv("S3_LIST_CONCURRENCY", strconv.Itoa(defaultListConcurrency)))
+	}
+	if concurrency <= 0 {
+		concurrency = defaultListConcurrency
+	}
+	if concurrency > maxListConcurrency {
+		concurrency = maxListConcurrency
+	}
+	return concurrency
+}
+
+// listObjects lists every object under prefix, one page after another
+func listObjects(ctx context.Context, client *s3.Client, bucket, prefix string) ([]types.Object, error) {
+	return listObjectsRange(ctx, client, bucket, prefix, "", "")
+}
+
+// listObjectsSharded splits the key space under prefix into contiguous ranges and
+// lists them concurrently. Shard i covers keys in (bounds[i-1], bounds[i]], so every
+// key belongs to exactly one shard and the merged result matches listObjects.
+func listObjectsSharded(ctx context.Context, client *s3.Client, bucket, prefix string, concurrency int) ([]types.Object, error) {
+	bounds := shardBoundaries(prefix, concurrency)
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		objects  []types.Object
+	)
+
+	for i := 0; i <= len(bounds); i++ {
+		startAfter, endAt := "", ""
+		if i > 0 {
+			startAfter = bounds[i-1]
+		}
+		if i < len(bounds) {
+			endAt = bounds[i]
+		}
+
+		wg.Add(1)
+		go func(startAfter, endAt string) {
+			defer wg.Done()
+			shard, err := listObjectsRange(ctx, client, bucket, prefix, startAfter, endAt)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			objects = append(objects, shard...)
+		}(startAfter, endAt)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return aws.ToString(objects[i].Key) < aws.ToString(objects[j].Key)
+	})
+	return objects, nil
+}
+
+// shardBoundaries picks concurrency-1 split points spread over printable ASCII
+func shardBoundaries(prefix string, concurrency int) []string {
+	const first, last = 0x21, 0x7e
+	bounds := make([]string, 0, concurrency-1)
+	step := (last - first + 1) / concurrency
+	for i := 1; i < concurrency; i++ {
+		bounds = append(bounds, prefix+string(rune(first+i*step)))
+	}
+	return bounds
+}
+
+// listObjectPages lists keys under prefix that sort after startAfter and hands
+// each page to fn as it arrives. Listing stops at the first error from fn.
+func listObjectPages(ctx context.Context, client *s3.Client, bucket, prefix, startAfter string, fn func(page []types.Object) error) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket: &bucket,
+	}
+	if prefix != "" {
+		input.Prefix = &prefix
+	}
+	if startAfter != "" {
+		input.StartAfter = &startAfter
+	}
+
+	for {
+		result, err := listPageWithBackoff(ctx, client, input)
+		if err != nil {
+			return err
+		}
+		if err := fn(result.Contents); err != nil {
+			return err
+		}
+		if !aws.ToBool(result.IsTruncated) {
+			return nil
+		}
+		input.ContinuationToken = result.NextContinuationToken
+	}
+}
+
+// listObjectsRange lists keys under prefix that sort after startAfter and up to and
+// including endAt. Empty bounds are open.
+func listObjectsRange(ctx context.Context, client *s3.Client, bucket, prefix, startAfter, endAt string) ([]types.Object, error) {
+	objects := make([]types.Object, 0)
+	var continuationToken *string
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: &bucket,
+	}
+	if prefix != "" {
+		input.Prefix = &prefix
+	}
+	if startAfter != "" {
+		input.StartAfter = &startAfter
+	}
+
+	for {
+		input.ContinuationToken = continuationToken
+		result, err := listPageWithBackoff(ctx, client, input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range result.Contents {
+			if endAt != "" && aws.ToString(obj.Key) > endAt {
+				return objects, nil
+			}
+			objects = append(objects, obj)
+		}
+
+		if !aws.ToBool(result.IsTruncated) {
+			return objects, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// listPageWithBackoff fetches one page, retrying throttling errors with jittered backoff
+func listPageWithBackoff(ctx context.Context, client *s3.Client, input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	backoff := 200 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		result, err := client.ListObjectsV2(ctx, input)
+		if err == nil || !isThrottlingError(err) || attempt == maxListAttempts {
+			return result, err
+		}
+
+		delay := backoff + time.Duration(rand.Int63n(int64(backoff)))
+		s3Logger.Printf("Listing throttled for s3://%s (attempt %d/%d), retrying in %s", aws.ToString(input.Bucket), attempt, maxListAttempts, delay)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		backoff *= 2
+	}
+}
+
+// isThrottlingError reports whether err is an S3 throttling response
+func isThrottlingError(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException":
+		return true
+	}
+	return false
+}
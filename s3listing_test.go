@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// listBucketResult is the ListObjectsV2 response body
+type listBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	IsTruncated           bool
+	KeyCount              int
+	NextContinuationToken string `xml:",omitempty"`
+	Contents              []struct{ Key string }
+}
+
+// listingHandler serves ListObjectsV2 for keys, pageSize keys at a time,
+// honoring prefix, start-after and continuation tokens
+func listingHandler(keys []string, pageSize int) http.HandlerFunc {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		after := query.Get("start-after")
+		if token := query.Get("continuation-token"); token != "" {
+			after = token
+		}
+		var result listBucketResult
+		for _, key := range sorted {
+			if !strings.HasPrefix(key, query.Get("prefix")) || key <= after {
+				continue
+			}
+			if len(result.Contents) == pageSize {
+				result.IsTruncated = true
+				result.NextContinuationToken = result.Contents[pageSize-1].Key
+				break
+			}
+			result.Contents = append(result.Contents, struct{ Key string }{key})
+		}
+		result.KeyCount = len(result.Contents)
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(result)
+	}
+}
+
+// objectKeys returns the keys of objects in order
+func objectKeys(objects []types.Object) []string {
+	keys := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		keys = append(keys, aws.ToString(obj.Key))
+	}
+	return keys
+}
+
+func TestShardedListingMatchesSequential(t *testing.T) {
+	keys := []string{" leading-space", "!bang", "0.txt", "A.txt", "Z/deep/key", "a", "a/b", "logs/2024/01.log",
+		"logs/2024/02.log", "logs/~old", "m", "z", "{brace", "~tilde", "ü-unicode", "日本.txt"}
+	for i := 0; i < 40; i++ {
+		keys = append(keys, fmt.Sprintf("logs/bulk-%03d", i), fmt.Sprintf("%c-flat-%d", 'a'+i%26, i))
+	}
+	client := newTestS3Client(t, listingHandler(keys, 7))
+	ctx := context.Background()
+
+	tests := []struct {
+		prefix      string
+		concurrency int
+	}{
+		{"", 1},
+		{"", 2},
+		{"", 4},
+		{"", maxListConcurrency},
+		{"logs/", 4},
+		{"logs/", maxListConcurrency},
+		{"missing/", 4},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q/%d", tt.prefix, tt.concurrency), func(t *testing.T) {
+			sequential, err := listObjects(ctx, client, "bucket", tt.prefix)
+			if err != nil {
+				t.Fatalf("listObjects: %v", err)
+			}
+			sharded, err := listObjectsSharded(ctx, client, "bucket", tt.prefix, tt.concurrency)
+			if err != nil {
+				t.Fatalf("listObjectsSharded: %v", err)
+			}
+			want, got := objectKeys(sequential), objectKeys(sharded)
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("sharded listing returned %d keys %q, want the %d sequential keys %q", len(got), got, len(want), want)
+			}
+			for _, key := range keys {
+				if strings.HasPrefix(key, tt.prefix) && !containsString(want, key) {
+					t.Errorf("sequential listing missed %q", key)
+				}
+			}
+		})
+	}
+}
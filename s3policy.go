@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Grantee group URIs that make an object publicly accessible
+const (
+	allUsersGroupURI           = "http://acs.amazonaws.com/groups/global/AllUsers"
+	authenticatedUsersGroupURI = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+)
+
+// PolicyFinding describes a metadata policy violation on an S3 object.
+// Findings are reported independently of the malware verdict.
+type PolicyFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// checkObjectPolicy inspects object metadata and ACL for policy violations;
+// sse is the SSE-C key needed to read the metadata of such objects. Metadata
+// or an ACL that cannot be read is reported as a policy_check_failed finding,
+// so an object that was not checked never looks compliant.
+func checkObjectPolicy(ctx context.Context, client *s3.Client, bucket, key string, sse *SSECustomerKey) []PolicyFinding {
+	findings := make([]PolicyFinding, 0)
+
+	sseAlgorithm, sseKey, sseKeyMD5 := sse.params()
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:               &bucket,
+		Key:                  &key,
+		SSECustomerAlgorithm: sseAlgorithm,
+		SSECustomerKey:       sseKey,
+		SSECustomerKeyMD5:    sseKeyMD5,
+	})
+	if err != nil {
+		s3Logger.Printf("WARNING: Policy check could not read metadata for s3://%s/%s: %v", bucket, key, err)
+		findings = append(findings, policyCheckFailed("metadata", err))
+	} else {
+		findings = append(findings, checkEncryption(head.ServerSideEncryption, aws.ToString(head.SSECustomerAlgorithm))...)
+		findings = append(findings, checkContentType(key, aws.ToString(head.ContentType))...)
+	}
+
+	acl, err := client.GetObjectAcl(ctx, &s3.GetObjectAclInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		s3Logger.Printf("WARNING: Policy check could not read ACL for s3://%s/%s: %v", bucket, key, err)
+		findings = append(findings, policyCheckFailed("ACL", err))
+	} else {
+		findings = append(findings, checkPublicGrants(acl.Grants)...)
+	}
+
+	s3Logger.Printf("Policy check for s3://%s/%s produced %d finding(s)", bucket, key, len(findings))
+	return findings
+}
+
+// policyCheckFailed reports a part of the object that could not be checked
+func policyCheckFailed(part string, err error) PolicyFinding {
+	return PolicyFinding{
+		Rule:     "policy_check_failed",
+		Severity: "high",
+		Message:  fmt.Sprintf("could not read the object %s: %v", part, err),
+	}
+}
+
+// checkEncryption flags objects stored without server-side encryption,
+// managed (sse) or with a customer key (sseCustomerAlgorithm)
+func checkEncryption(sse types.ServerSideEncryption, sseCustomerAlgorithm string) []PolicyFinding {
+	if sse != "" || sseCustomerAlgorithm != "" {
+		return nil
+	}
+	return []PolicyFinding{{
+		Rule:     "unencrypted_object",
+		Severity: "medium",
+		Message:  "object is not protected by server-side encryption",
+	}}
+}
+
+// checkContentType flags a declared content type that does not match the key's extension.
+// Generic binary content types are not considered a mismatch.
+func checkContentType(key, contentType string) []PolicyFinding {
+	ext := strings.ToLower(path.Ext(key))
+	if ext == "" || contentType == "" {
+		return nil
+	}
+
+	declared, _, err := mime.ParseMediaType(contentType)
+	if err != nil || declared == "application/octet-stream" || declared == "binary/octet-stream" {
+		return nil
+	}
+
+	expected, _, err := mime.ParseMediaType(mime.TypeByExtension(ext))
+	if err != nil || expected == declared {
+		return nil
+	}
+
+	return []PolicyFinding{{
+		Rule:     "content_type_mismatch",
+		Severity: "low",
+		Message:  fmt.Sprintf("content type %q does not match extension %s (expected %q)", declared, ext, expected),
+	}}
+}
+
+// checkPublicGrants flags ACL grants to the AllUsers or AuthenticatedUsers groups
+func checkPublicGrants(grants []types.Grant) []PolicyFinding {
+	findings := make([]PolicyFinding, 0)
+	for _, grant := range grants {
+		if grant.Grantee == nil || grant.Grantee.URI == nil {
+			continue
+		}
+		switch *grant.Grantee.URI {
+		case allUsersGroupURI:
+			findings = append(findings, PolicyFinding{
+				Rule:     "public_acl",
+				Severity: "high",
+				Message:  fmt.Sprintf("ACL grants %s to everyone", grant.Permission),
+			})
+		case authenticatedUsersGroupURI:
+			findings = append(findings, PolicyFinding{
+				Rule:     "public_acl",
+				Severity: "high",
+				Message:  fmt.Sprintf("ACL grants %s to any authenticated AWS user", grant.Permission),
+			})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// newTestS3Client returns a path-style client for a fake S3 served by
+// handler, without retries, and keeps S3 logs out of the test output
+func newTestS3Client(t *testing.T, handler http.Handler) *s3.Client {
+	t.Helper()
+	s3Logger = newComponentLogger(io.Discard, "s3")
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return s3.New(s3.Options{
+		Region:           "us-east-1",
+		BaseEndpoint:     aws.String(server.URL),
+		UsePathStyle:     true,
+		Credentials:      aws.AnonymousCredentials{},
+		RetryMaxAttempts: 1,
+	})
+}
+
+const emptyObjectACL = `<?xml version="1.0" encoding="UTF-8"?>
+<AccessControlPolicy><Owner><ID>owner</ID></Owner><AccessControlList></AccessControlList></AccessControlPolicy>`
+
+func TestCheckObjectPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		contentType string
+		encryption  string
+		headStatus  int
+		aclStatus   int
+		wantRules   []string
+	}{
+		{"compliant", "report.pdf", "application/pdf", "AES256", 0, 0, nil},
+		{"content type mismatch", "report.pdf", "image/png", "aws:kms", 0, 0, []string{"content_type_mismatch"}},
+		{"unencrypted", "report.pdf", "application/pdf", "", 0, 0, []string{"unencrypted_object"}},
+		{"both", "photo.png", "text/html", "", 0, 0, []string{"unencrypted_object", "content_type_mismatch"}},
+		{"generic binary type", "photo.png", "application/octet-stream", "AES256", 0, 0, nil},
+		{"metadata denied", "report.pdf", "application/pdf", "AES256", http.StatusForbidden, 0, []string{"policy_check_failed"}},
+		{"ACL denied", "report.pdf", "application/pdf", "AES256", 0, http.StatusForbidden, []string{"policy_check_failed"}},
+		{"S3 error", "report.pdf", "application/pdf", "AES256", http.StatusInternalServerError, http.StatusInternalServerError, []string{"policy_check_failed", "policy_check_failed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestS3Client(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Has("acl") {
+					if tt.aclStatus != 0 {
+						s3Error(w, tt.aclStatus, http.StatusText(tt.aclStatus))
+						return
+					}
+					w.Write([]byte(emptyObjectACL))
+					return
+				}
+				if tt.headStatus != 0 {
+					w.WriteHeader(tt.headStatus)
+					return
+				}
+				w.Header().Set("Content-Type", tt.contentType)
+				if tt.encryption != "" {
+					w.Header().Set("X-Amz-Server-Side-Encryption", tt.encryption)
+				}
+			}))
+
+			findings := checkObjectPolicy(context.Background(), client, "bucket", tt.key, nil)
+			if len(findings) != len(tt.wantRules) {
+				t.Fatalf("findings %+v, want rules %v", findings, tt.wantRules)
+			}
+			for i, finding := range findings {
+				if finding.Rule != tt.wantRules[i] {
+					t.Errorf("finding %d rule %s, want %s", i, finding.Rule, tt.wantRules[i])
+				}
+			}
+		})
+	}
+}
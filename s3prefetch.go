@@ -0,0 +1,114 @@
+package main
+
+import "context"
+
+// Read-ahead defaults for S3 readers
+const (
+	defaultS3ReadAheadMB   = 8
+	defaultS3PrefetchDepth = 2
+	defaultS3PrefetchMinMB = 64
+)
+
+// s3Chunk is one read-ahead chunk of an S3 object. ready is closed once
+// data or err is set.
+type s3Chunk struct {
+	ready chan struct{}
+	data  []byte
+	err   error
+}
+
+// s3ReadAhead returns the read-ahead chunk size from S3_READ_AHEAD_MB; 0
+// disables read-ahead
+func s3ReadAhead() int64 {
+	return int64(getEnvInt("S3_READ_AHEAD_MB", defaultS3ReadAheadMB)) << 20
+}
+
+// s3PrefetchDepth returns how many chunks past the one being read are
+// fetched in the background for an object of size: S3_PREFETCH_DEPTH for
+// objects of at least S3_PREFETCH_MIN_MB, otherwise none
+func s3PrefetchDepth(size int64) int {
+	if size < int64(getEnvInt("S3_PREFETCH_MIN_MB", defaultS3PrefetchMinMB))<<20 {
+		return 0
+	}
+	return getEnvInt("S3_PREFETCH_DEPTH", defaultS3PrefetchDepth)
+}
+
+// readChunks returns the bytes from offset up to end, within the object,
+// from the read-ahead chunks covering them. Chunks before the first one
+// read are dropped, so a reader holds at most prefetch+1 chunks while
+// reading forward.
+func (r *S3ClientReader) readChunks(offset, end int64) ([]byte, error) {
+	first, last := offset/r.readAhead, (end-1)/r.readAhead
+	var out []byte
+	for index := first; index <= last; index++ {
+		chunk := r.chunk(index, index == first)
+		<-chunk.ready
+		if chunk.err != nil {
+			r.mu.Lock()
+			if r.chunks[index] == chunk {
+				delete(r.chunks, index)
+			}
+			r.mu.Unlock()
+			return nil, chunk.err
+		}
+		start := index * r.readAhead
+		from, to := max(offset, start)-start, min(end-start, int64(len(chunk.data)))
+		if from >= to {
+			break
+		}
+		// A read within one chunk shares its data, which is never modified
+		if first == last {
+			return chunk.data[from:to], nil
+		}
+		out = append(out, chunk.data[from:to]...)
+	}
+	return out, nil
+}
+
+// chunk returns the chunk at index, starting its fetch when it is missing,
+// and the fetches of the next prefetch chunks. With drop, chunks before
+// index are released.
+func (r *S3ClientReader) chunk(index int64, drop bool) *s3Chunk {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.chunks == nil {
+		r.chunks = make(map[int64]*s3Chunk)
+	}
+	if drop {
+		for i := range r.chunks {
+			if i < index {
+				delete(r.chunks, i)
+			}
+		}
+	}
+	chunk, ok := r.chunks[index]
+	if ok {
+		s3ReadAheadHits.Inc()
+	} else {
+		chunk = r.fetchChunk(index)
+	}
+	for next := index + 1; next <= index+int64(r.prefetch) && next*r.readAhead < r.size; next++ {
+		if _, ok := r.chunks[next]; !ok {
+			s3Prefetches.Inc()
+			r.fetchChunk(next)
+		}
+	}
+	return chunk
+}
+
+// fetchChunk starts fetching the chunk at index in the background under
+// the current context; callers must hold r.mu
+func (r *S3ClientReader) fetchChunk(index int64) *s3Chunk {
+	chunk := &s3Chunk{ready: make(chan struct{})}
+	r.chunks[index] = chunk
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	start := index * r.readAhead
+	go func() {
+		defer close(chunk.ready)
+		chunk.data, chunk.err = r.getRange(ctx, start, min(start+r.readAhead, r.size))
+	}()
+	return chunk
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// Ways to read the size of an object before scanning it, set by S3_SIZE_PROBE
+const (
+	s3ProbeAuto       = "auto"       // attributes, then head, then range
+	s3ProbeAttributes = "attributes" // GetObjectAttributes (s3:GetObjectAttributes)
+	s3ProbeHead       = "head"       // HeadObject (s3:GetObject)
+	s3ProbeRange      = "range"      // a one-byte ranged GetObject (s3:GetObject)
+)
+
+// s3ObjectInfo is what a probe learns about an object. Archived objects
+// cannot be read until restored; restoring is set while a restore runs.
+type s3ObjectInfo struct {
+	size         int64
+	etag         string
+	versionID    string
+	storageClass string
+	archived     bool
+	restoring    bool
+}
+
+// s3ProbeStrategies returns the probes to try in order from S3_SIZE_PROBE
+func s3ProbeStrategies() []string {
+	switch probe := strings.ToLower(getEnv("S3_SIZE_PROBE", s3ProbeAuto)); probe {
+	case s3ProbeAttributes, s3ProbeHead, s3ProbeRange:
+		return []string{probe}
+	case s3ProbeAuto:
+	default:
+		s3Logger.Printf("WARNING: invalid S3_SIZE_PROBE %q, using %s", probe, s3ProbeAuto)
+	}
+	return []string{s3ProbeAttributes, s3ProbeHead, s3ProbeRange}
+}
+
+// probeS3Object reads the size, entity tag and version of an object. With
+// several strategies, the next one is tried when a call is denied or not
+// implemented, so least-privilege roles and S3-compatible stores work.
+func probeS3Object(ctx context.Context, client *s3.Client, bucket, key string, sse *SSECustomerKey) (s3ObjectInfo, error) {
+	strategies := s3ProbeStrategies()
+	for i, strategy := range strategies {
+		info, err := probeS3ObjectWith(ctx, client, bucket, key, strategy, sse)
+		if err == nil || i == len(strategies)-1 || !isProbeUnavailable(err) {
+			return info, err
+		}
+		s3Logger.Printf("Size probe %s unavailable for s3://%s/%s, trying %s: %v", strategy, bucket, key, strategies[i+1], err)
+	}
+	return s3ObjectInfo{}, fmt.Errorf("no size probe configured")
+}
+
+// probeS3ObjectWith runs one probe strategy; sse is the SSE-C key, if any
+func probeS3ObjectWith(ctx context.Context, client *s3.Client, bucket, key, strategy string, sse *SSECustomerKey) (s3ObjectInfo, error) {
+	sseAlgorithm, sseKey, sseKeyMD5 := sse.params()
+	switch strategy {
+	case s3ProbeHead:
+		head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: &bucket, Key: &key,
+			SSECustomerAlgorithm: sseAlgorithm, SSECustomerKey: sseKey, SSECustomerKeyMD5: sseKeyMD5,
+		})
+		if err != nil {
+			return s3ObjectInfo{}, err
+		}
+		if head.ContentLength == nil {
+			return s3ObjectInfo{}, fmt.Errorf("unable to get object size from S3")
+		}
+		info := s3ObjectInfo{size: *head.ContentLength, etag: aws.ToString(head.ETag), versionID: aws.ToString(head.VersionId), storageClass: string(head.StorageClass)}
+		info.archived, info.restoring = headArchiveState(head)
+		return info, nil
+
+	case s3ProbeRange:
+		rng := "bytes=0-0"
+		output, err := client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: &bucket, Key: &key, Range: &rng,
+			SSECustomerAlgorithm: sseAlgorithm, SSECustomerKey: sseKey, SSECustomerKeyMD5: sseKeyMD5,
+		})
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+			// Only empty objects have no first byte
+			return s3ObjectInfo{}, nil
+		}
+		if isInvalidObjectState(err) {
+			// Archived objects cannot be read; HeadObject tells their state
+			if info, headErr := probeS3ObjectWith(ctx, client, bucket, key, s3ProbeHead, sse); headErr == nil {
+				return info, nil
+			}
+			return s3ObjectInfo{archived: true}, nil
+		}
+		if err != nil {
+			return s3ObjectInfo{}, err
+		}
+		output.Body.Close()
+		// Content-Range is "bytes 0-0/<size>"
+		_, total, _ := strings.Cut(aws.ToString(output.ContentRange), "/")
+		size, err := strconv.ParseInt(total, 10, 64)
+		if err != nil {
+			return s3ObjectInfo{}, fmt.Errorf("unable to get object size from S3 Content-Range %q", aws.ToString(output.ContentRange))
+		}
+		return s3ObjectInfo{size: size, etag: aws.ToString(output.ETag), versionID: aws.ToString(output.VersionId), storageClass: string(output.StorageClass)}, nil
+	}
+
+	attr, err := client.GetObjectAttributes(ctx, &s3.GetObjectAttributesInput{
+		Bucket: &bucket,
+		Key:    &key,
+		ObjectAttributes: []types.ObjectAttributes{
+			types.ObjectAttributesObjectSize,
+			types.ObjectAttributesEtag,
+			types.ObjectAttributesStorageClass,
+		},
+		SSECustomerAlgorithm: sseAlgorithm,
+		SSECustomerKey:       sseKey,
+		SSECustomerKeyMD5:    sseKeyMD5,
+	})
+	if err != nil {
+		return s3ObjectInfo{}, err
+	}
+	if attr.ObjectSize == nil {
+		return s3ObjectInfo{}, fmt.Errorf("unable to get object size from S3")
+	}
+	info := s3ObjectInfo{size: *attr.ObjectSize, etag: aws.ToString(attr.ETag), versionID: aws.ToString(attr.VersionId), storageClass: string(attr.StorageClass)}
+	if isArchiveStorageClass(info.storageClass) {
+		// Only HeadObject tells whether a restored copy is readable
+		if head, err := probeS3ObjectWith(ctx, client, bucket, key, s3ProbeHead, sse); err == nil {
+			return head, nil
+		}
+		info.archived = true
+	}
+	return info, nil
+}
+
+// isProbeUnavailable reports whether err means the probe call is not
+// allowed or not supported, rather than a problem with the object
+func isProbeUnavailable(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "AccessDenied", "Forbidden", "NotImplemented", "MethodNotAllowed", "XNotImplemented":
+		return true
+	}
+	return false
+}
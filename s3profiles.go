@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+var (
+	errInlineCredentials = errors.New("inline AWS credentials are disabled, use a credential profile")
+	errUnknownProfile    = errors.New("unknown credential profile")
+)
+
+// AWSProfile is a named set of AWS credentials configured on the server and
+// referenced by "profile" in request bodies. The base credentials are the
+// static keys, a shared config profile or the default chain (instance role,
+// IRSA); RoleArn is then assumed with them, or with WebIdentityTokenFile.
+type AWSProfile struct {
+	AccessKeyID          string   `json:"accessKeyId"`
+	SecretAccessKey      string   `json:"secretAccessKey"`
+	SessionToken         string   `json:"sessionToken"`
+	CredentialsEnv       string   `json:"credentialsEnv"` // prefix of <P>_ACCESS_KEY_ID and <P>_SECRET_ACCESS_KEY
+	SharedConfigProfile  string   `json:"sharedConfigProfile"`
+	RoleArn              string   `json:"roleArn"`
+	ExternalID           string   `json:"externalId"`
+	RoleSessionName      string   `json:"roleSessionName"`
+	WebIdentityTokenFile string   `json:"webIdentityTokenFile"`
+	Region               string   `json:"region"`
+	EndpointURL          string   `json:"endpointUrl"`
+	ForcePathStyle       bool     `json:"forcePathStyle"`
+	RequesterPays        bool     `json:"requesterPays"`
+	Clients              []string `json:"clients"` // callers allowed to use it; empty allows all
+	Tenants              []string `json:"tenants"` // tenants allowed to use it
+}
+
+// awsProfiles holds the profiles from AWS_PROFILES_FILE
+var awsProfiles = map[string]*AWSProfile{}
+
+// loadAWSProfiles reads AWS_PROFILES_FILE, a JSON object of profiles by name
+func loadAWSProfiles(path string) (map[string]*AWSProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	profiles := map[string]*AWSProfile{}
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", path, err)
+	}
+	for name, profile := range profiles {
+		if profile.CredentialsEnv != "" {
+			profile.AccessKeyID = os.Getenv(profile.CredentialsEnv + "_ACCESS_KEY_ID")
+			profile.SecretAccessKey = os.Getenv(profile.CredentialsEnv + "_SECRET_ACCESS_KEY")
+			profile.SessionToken = os.Getenv(profile.CredentialsEnv + "_SESSION_TOKEN")
+		}
+		if (profile.AccessKeyID == "") != (profile.SecretAccessKey == "") {
+			return nil, fmt.Errorf("profile %q: access key ID and secret access key must be set together", name)
+		}
+		if profile.WebIdentityTokenFile != "" && profile.RoleArn == "" {
+			return nil, fmt.Errorf("profile %q: webIdentityTokenFile requires roleArn", name)
+		}
+		log.Printf("- AWS credential profile %s (role: %s)", name, profile.RoleArn)
+	}
+	return profiles, nil
+}
+
+// rejectInlineCredentials reports whether awsAccessKey/awsSecretKey in
+// request bodies are refused (S3_REJECT_INLINE_CREDENTIALS)
+func rejectInlineCredentials() bool {
+	return getEnv("S3_REJECT_INLINE_CREDENTIALS", "false") == "true"
+}
+
+// allows reports whether the caller or tenant in ctx may use the profile
+func (p *AWSProfile) allows(ctx context.Context) bool {
+	if len(p.Clients) == 0 && len(p.Tenants) == 0 {
+		return true
+	}
+	return slices.Contains(p.Clients, callerFrom(ctx)) || slices.Contains(p.Tenants, tenantName(ctx))
+}
+
+// checkS3Credentials validates the credentials a request asks for, returning
+// the HTTP status and field to report when they are refused
+func checkS3Credentials(ctx context.Context, opts S3Options) (int, string, error) {
+	if opts.AwsAccessKey != "" && rejectInlineCredentials() {
+		return http.StatusBadRequest, "awsAccessKey", errInlineCredentials
+	}
+	if opts.AwsAccessKey != "" && opts.Profile != "" {
+		return http.StatusBadRequest, "profile", errors.New("profile and awsAccessKey cannot be combined")
+	}
+	if opts.Profile == "" {
+		return 0, "", nil
+	}
+	profile, ok := awsProfiles[opts.Profile]
+	if !ok {
+		return http.StatusBadRequest, "profile", fmt.Errorf("%v %q", errUnknownProfile, opts.Profile)
+	}
+	if !profile.allows(ctx) {
+		return http.StatusForbidden, "profile", fmt.Errorf("credential profile %q is not allowed for this caller", opts.Profile)
+	}
+	return 0, "", nil
+}
+
+// loadProfileConfig loads the AWS config for a named profile in region
+func loadProfileConfig(ctx context.Context, name, region string) (aws.Config, error) {
+	profile, ok := awsProfiles[name]
+	if !ok {
+		return aws.Config{}, fmt.Errorf("%v %q", errUnknownProfile, name)
+	}
+
+	s3Logger.Printf("Using credential profile %s", name)
+	loadOpts := []func(*config.LoadOptions) error{config.WithRegion(region)}
+	switch {
+	case profile.AccessKeyID != "":
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(profile.AccessKeyID, profile.SecretAccessKey, profile.SessionToken)))
+	case profile.SharedConfigProfile != "":
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(profile.SharedConfigProfile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil || profile.RoleArn == "" {
+		return cfg, err
+	}
+
+	sessionName := profile.RoleSessionName
+	if sessionName == "" {
+		sessionName = "finguard-" + name
+	}
+	stsClient := sts.NewFromConfig(cfg)
+	if profile.WebIdentityTokenFile != "" {
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewWebIdentityRoleProvider(stsClient, profile.RoleArn,
+			stscreds.IdentityTokenFile(profile.WebIdentityTokenFile), func(o *stscreds.WebIdentityRoleOptions) {
+				o.RoleSessionName = sessionName
+			}))
+		return cfg, nil
+	}
+	cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(stsClient, profile.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = sessionName
+		if profile.ExternalID != "" {
+			o.ExternalID = aws.String(profile.ExternalID)
+		}
+	}))
+	return cfg, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// defaultBucketRegionTTL is how long detected bucket regions are reused
+const defaultBucketRegionTTL = time.Hour
+
+// BucketRegionCache remembers the detected region of each bucket, so scans
+// that leave the region out only look it up once
+type BucketRegionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	regions map[string]cachedBucketRegion
+}
+
+type cachedBucketRegion struct {
+	region  string
+	expires time.Time
+}
+
+// bucketRegions is configured at startup; nil or a zero TTL disables caching
+var bucketRegions *BucketRegionCache
+
+// NewBucketRegionCache creates a cache with the TTL from S3_REGION_CACHE_TTL
+func NewBucketRegionCache() *BucketRegionCache {
+	ttl, err := time.ParseDuration(getEnv("S3_REGION_CACHE_TTL", defaultBucketRegionTTL.String()))
+	if err != nil || ttl < 0 {
+		log.Printf("Warning: invalid S3_REGION_CACHE_TTL, using %s", defaultBucketRegionTTL)
+		ttl = defaultBucketRegionTTL
+	}
+	return &BucketRegionCache{ttl: ttl, regions: make(map[string]cachedBucketRegion)}
+}
+
+// lookup returns the cached region of bucket
+func (c *BucketRegionCache) lookup(bucket string) (string, bool) {
+	if c == nil || c.ttl == 0 {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.regions[bucket]
+	if !ok || time.Now().After(cached.expires) {
+		return "", false
+	}
+	return cached.region, true
+}
+
+// store caches the region of bucket, dropping expired entries
+func (c *BucketRegionCache) store(bucket, region string) {
+	if c == nil || c.ttl == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for name, cached := range c.regions {
+		if now.After(cached.expires) {
+			delete(c.regions, name)
+		}
+	}
+	c.regions[bucket] = cachedBucketRegion{region: region, expires: now.Add(c.ttl)}
+}
+
+// forget drops the cached region of bucket, e.g. after S3 redirected a
+// request sent there
+func (c *BucketRegionCache) forget(bucket string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.regions, bucket)
+}
+
+// getBucketRegion detects the region of an S3 bucket with GetBucketLocation,
+// falling back to the region HeadBucket reports for callers without
+// s3:GetBucketLocation. Regions are reused from bucketRegions.
+func getBucketRegion(ctx context.Context, cfg aws.Config, opts S3Options, bucket string) (string, error) {
+	if region, ok := bucketRegions.lookup(bucket); ok {
+		s3RegionLookups.WithLabelValues("hit").Inc()
+		return region, nil
+	}
+	s3RegionLookups.WithLabelValues("miss").Inc()
+
+	// Lookups need some region to sign with; S3 answers for any bucket
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	client := newS3Client(cfg, opts)
+	region, err := bucketLocation(ctx, client, bucket)
+	if err != nil {
+		var headErr error
+		if region, headErr = headBucketRegion(ctx, client, bucket); headErr != nil {
+			return "", err
+		}
+	}
+	bucketRegions.store(bucket, region)
+	return region, nil
+}
+
+// bucketLocation returns the region GetBucketLocation reports for bucket
+func bucketLocation(ctx context.Context, client *s3.Client, bucket string) (string, error) {
+	resp, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// GetBucketLocation returns empty string for us-east-1
+	if resp.LocationConstraint == "" {
+		return "us-east-1", nil
+	}
+	return string(resp.LocationConstraint), nil
+}
+
+// headBucketRegion returns the region HeadBucket reports for bucket. S3 sends
+// it with redirects and denials too, so only s3:ListBucket may be needed.
+func headBucketRegion(ctx context.Context, client *s3.Client, bucket string) (string, error) {
+	resp, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err == nil {
+		if region := aws.ToString(resp.BucketRegion); region != "" {
+			return region, nil
+		}
+		return "", errors.New("HeadBucket did not report a region")
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.Response != nil {
+		if region := respErr.Response.Header.Get("X-Amz-Bucket-Region"); region != "" {
+			return region, nil
+		}
+	}
+	return "", err
+}
+
+// isWrongRegionError reports whether S3 rejected a request because it was
+// sent to or signed for another region than the bucket's
+func isWrongRegionError(err error) bool {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusMovedPermanently {
+		return true
+	}
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "PermanentRedirect", "AuthorizationHeaderMalformed", "IllegalLocationConstraintException":
+		return true
+	}
+	return false
+}
@@ -3,101 +3,170 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var s3Logger *log.Logger
 
 func initS3Logger() {
-	logFile, err := os.OpenFile("/var/log/s3-scanner.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		log.Printf("Failed to open S3 log file: %v", err)
-		s3Logger = log.New(os.Stdout, "[S3] ", log.LstdFlags)
+	// Stdout-only mode applies to both logs
+	logPath := getEnv("S3_LOG_PATH", "/var/log/s3-scanner.log")
+	if getEnv("LOG_PATH", "") == logStdout || logPath == logStdout {
+		s3Logger = newComponentLogger(os.Stdout, "s3")
 	} else {
-		s3Logger = log.New(io.MultiWriter(logFile, os.Stdout), "[S3] ", log.LstdFlags)
+		s3Logger = newComponentLogger(io.MultiWriter(openLogWriter(logPath), os.Stdout), "s3")
 	}
 	s3Logger.Println("=== S3 Scanner initialized ===")
 }
 
-// S3ClientReader implements AmaasClientReader for S3 objects
+// S3ClientReader implements AmaasClientReader for S3 objects. ctx is the
+// request context, replaced by the scan context while scanning; range reads
+// run under it so they join the trace and stop at the scan deadline.
 type S3ClientReader struct {
-	client *s3.Client
-	bucket string
-	key    string
-	size   int64
+	ctx         context.Context
+	client      *s3.Client
+	bucket      string
+	region      string // region the client reads from, detected when not given
+	accessPoint bool
+	key         string
+	size        int64
+	etag        string // entity tag without quotes, used by skipUnchanged
+	versionID   string // object version, empty for unversioned buckets
+	sse         *SSECustomerKey
+	// Archived objects are not read; see handleArchivedObject
+	storageClass string
+	archived     bool
+	restoring    bool
+
+	// Reads are served from chunks of readAhead bytes, each fetched in one
+	// range request, and the next prefetch chunks are fetched in the
+	// background; zero readAhead fetches every read on its own
+	readAhead int64
+	prefetch  int
+	mu        sync.Mutex
+	chunks    map[int64]*s3Chunk
 }
 
-func NewS3ClientReader(ctx context.Context, awsAccessKey, awsSecretKey, bucketRegion, bucket, key string) (*S3ClientReader, error) {
-	s3Logger.Printf("Creating S3 reader for s3://%s/%s in region %s", bucket, key, bucketRegion)
+func NewS3ClientReader(ctx context.Context, opts S3Options, bucket, key string) (*S3ClientReader, error) {
+	return newS3ClientReaderSSE(ctx, opts, bucket, key, nil)
+}
 
-	var cfg aws.Config
-	var err error
+// newS3ClientReaderSSE is NewS3ClientReader for objects encrypted with the
+// SSE-C key sse, which is sent with every read
+func newS3ClientReaderSSE(ctx context.Context, opts S3Options, bucket, key string, sse *SSECustomerKey) (*S3ClientReader, error) {
+	opts = opts.withDefaults()
+	bucketRegion := opts.Region
+	s3Logger.Printf("Creating S3 reader for s3://%s/%s in region %s", bucket, key, bucketRegion)
 
-	// Load config with credentials if provided
-	if awsAccessKey != "" && awsSecretKey != "" {
-		s3Logger.Println("Using provided AWS credentials")
-		cfg, err = config.LoadDefaultConfig(ctx,
-			config.WithRegion(bucketRegion),
-			config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(awsAccessKey, awsSecretKey, "")),
-		)
-	} else {
-		s3Logger.Println("Using default AWS credentials from environment")
-		// Use default credentials from environment
-		cfg, err = config.LoadDefaultConfig(ctx, config.WithRegion(bucketRegion))
+	// Bucket and access point ARNs carry their own region
+	target, err := resolveBucket(bucket)
+	if err == nil {
+		opts, err = target.clientOptions(opts)
+	}
+	if err != nil {
+		s3Logger.Printf("Failed to resolve bucket: %v", err)
+		return nil, err
+	}
+	if target.Region != "" {
+		bucketRegion = target.Region
 	}
+	bucket = target.Name
 
+	// Load config with credentials if provided
+	cfg, err := loadAWSConfig(ctx, opts, bucketRegion)
 	if err != nil {
 		s3Logger.Printf("Failed to load AWS config: %v", err)
 		return nil, err
 	}
 
-	client := s3.NewFromConfig(cfg)
+	// Detect the bucket region when the caller did not provide one
+	detectRegion := !target.AccessPoint && opts.EndpointURL == ""
+	if bucketRegion == "" && detectRegion {
+		if detected, err := getBucketRegion(ctx, cfg, opts, bucket); err == nil {
+			s3Logger.Printf("Bucket %s is in region %s", bucket, detected)
+			cfg.Region = detected
+		} else {
+			s3Logger.Printf("Warning: could not detect the region of bucket %s: %v", bucket, err)
+		}
+	}
+
+	client := newS3Client(cfg, opts)
 	s3Logger.Println("AWS S3 client created successfully")
 
-	// Get object attributes to determine size
-	s3Logger.Printf("Getting object attributes for %s", key)
-	attr, err := client.GetObjectAttributes(ctx, &s3.GetObjectAttributesInput{
-		Bucket: &bucket,
-		Key:    &key,
-		ObjectAttributes: []types.ObjectAttributes{
-			types.ObjectAttributesObjectSize,
-		},
-	})
+	// Get the object size, falling back to other probes when denied
+	s3Logger.Printf("Probing object size for %s", key)
+	info, err := probeS3Object(ctx, client, bucket, key, sse)
+
+	// A wrong or stale region is corrected once by detecting it again
+	if err != nil && detectRegion && isWrongRegionError(err) {
+		bucketRegions.forget(bucket)
+		if detected, detectErr := getBucketRegion(ctx, cfg, opts, bucket); detectErr == nil && detected != cfg.Region {
+			s3Logger.Printf("Bucket %s is in region %s, not %s; retrying", bucket, detected, cfg.Region)
+			cfg.Region = detected
+			client = newS3Client(cfg, opts)
+			info, err = probeS3Object(ctx, client, bucket, key, sse)
+		}
+	}
 	if err != nil {
-		s3Logger.Printf("Failed to get object attributes: %v", err)
+		s3Logger.Printf("Failed to get object size: %v", err)
 		return nil, err
 	}
 
-	if attr.ObjectSize == nil {
-		s3Logger.Println("Object size is nil")
-		return nil, fmt.Errorf("unable to get object size from S3")
+	s3Logger.Printf("Object size: %d bytes", info.size)
+	// Multi-Region Access Points are not served from one region
+	if target.MultiRegion {
+		cfg.Region = ""
 	}
-
-	s3Logger.Printf("Object size: %d bytes", *attr.ObjectSize)
 	return &S3ClientReader{
-		client: client,
-		bucket: bucket,
-		key:    key,
-		size:   *attr.ObjectSize,
+		ctx:          ctx,
+		client:       client,
+		bucket:       bucket,
+		region:       cfg.Region,
+		accessPoint:  target.AccessPoint,
+		key:          key,
+		size:         info.size,
+		etag:         normalizeETag(info.etag),
+		versionID:    info.versionID,
+		sse:          sse,
+		storageClass: info.storageClass,
+		archived:     info.archived,
+		restoring:    info.restoring,
+		readAhead:    s3ReadAhead(),
+		prefetch:     s3PrefetchDepth(info.size),
 	}, nil
 }
 
+// normalizeETag strips the quotes S3 puts around entity tags in some responses
+func normalizeETag(etag string) string {
+	return strings.Trim(etag, `"`)
+}
+
 // Identifier returns the S3 object identifier
 func (r *S3ClientReader) Identifier() string {
-	return fmt.Sprintf("s3://%s/%s", r.bucket, r.key)
+	return S3Bucket{Name: r.bucket, AccessPoint: r.accessPoint}.objectIdentifier(r.key)
+}
+
+// scanTarget describes the object for the scan policy
+func (r *S3ClientReader) scanTarget() ScanTarget {
+	return ScanTarget{Bucket: r.bucket, Key: r.key, Size: r.size}
 }
 
 // DataSize returns the size of the S3 object
@@ -105,16 +174,73 @@ func (r *S3ClientReader) DataSize() (int64, error) {
 	return r.size, nil
 }
 
-// ReadBytes reads bytes from the S3 object at the specified offset
+// bindContext runs the range reads of a scan under its context
+func (r *S3ClientReader) bindContext(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ctx = ctx
+}
+
+// context returns the context range reads run under
+func (r *S3ClientReader) context() context.Context {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// ReadBytes reads bytes from the S3 object at the specified offset. With
+// read-ahead, reads are served from chunks of readAhead bytes, so the
+// sequential reads of a scan cost one request per chunk.
 func (r *S3ClientReader) ReadBytes(offset int64, length int32) ([]byte, error) {
-	rng := fmt.Sprintf("bytes=%d-%d", offset, offset+int64(length)-1)
+	if r.archived {
+		return nil, fmt.Errorf("%s is archived (%s) and must be restored before it is scanned", r.Identifier(), r.storageClass)
+	}
+	end := offset + int64(length)
+	if r.readAhead <= int64(length) || offset >= r.size {
+		return r.getRange(r.context(), offset, end)
+	}
+	return r.readChunks(offset, min(end, r.size))
+}
 
-	output, err := r.client.GetObject(context.Background(), &s3.GetObjectInput{
-		Bucket: &r.bucket,
-		Key:    &r.key,
-		Range:  &rng,
-	})
+// getRange fetches the bytes of the object from offset up to end. Every range
+// is pinned to the version, or on unversioned buckets the entity tag, the
+// probe saw, so a scan never stitches together two versions of an object.
+func (r *S3ClientReader) getRange(ctx context.Context, offset, end int64) ([]byte, error) {
+	rng := fmt.Sprintf("bytes=%d-%d", offset, end-1)
+	s3RangeGets.Inc()
+
+	ctx, span := tracer.Start(ctx, "s3.GetObject", trace.WithAttributes(
+		attribute.String("s3.bucket", r.bucket),
+		attribute.String("s3.key", r.key),
+		attribute.String("s3.range", rng),
+	))
+	defer span.End()
+
+	sseAlgorithm, sseKey, sseKeyMD5 := r.sse.params()
+	input := &s3.GetObjectInput{
+		Bucket:               &r.bucket,
+		Key:                  &r.key,
+		Range:                &rng,
+		SSECustomerAlgorithm: sseAlgorithm,
+		SSECustomerKey:       sseKey,
+		SSECustomerKeyMD5:    sseKeyMD5,
+	}
+	if r.versionID != "" {
+		input.VersionId = aws.String(r.versionID)
+	} else if r.etag != "" {
+		input.IfMatch = aws.String(`"` + r.etag + `"`)
+	}
+	output, err := r.client.GetObject(ctx, input)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "GetObject failed")
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed" {
+			return nil, fmt.Errorf("%s changed while it was being scanned: %w", r.Identifier(), err)
+		}
 		return nil, err
 	}
 	defer output.Body.Close()
@@ -127,21 +253,27 @@ func (r *S3ClientReader) ReadBytes(offset int64, length int32) ([]byte, error) {
 	return bytes, err
 }
 
-// getBucketRegion detects the region of an S3 bucket
-func getBucketRegion(ctx context.Context, cfg aws.Config, bucket string) (string, error) {
-	client := s3.NewFromConfig(cfg)
-	resp, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
-		Bucket: aws.String(bucket),
-	})
-	if err != nil {
-		return "", err
+// deleteS3Object removes a scanned object from its bucket. The delete is
+// pinned to the scanned version, or to its entity tag on unversioned
+// buckets, so an object replaced since the scan is not removed unscanned.
+func deleteS3Object(ctx context.Context, client *s3.Client, bucket, key, versionID, etag string) error {
+	s3Logger.Printf("Deleting s3://%s/%s", bucket, key)
+	input := &s3.DeleteObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
 	}
-
-	// GetBucketLocation returns empty string for us-east-1
-	if resp.LocationConstraint == "" {
-		return "us-east-1", nil
+	if versionID != "" {
+		input.VersionId = &versionID
+	} else if etag != "" {
+		input.IfMatch = aws.String(`"` + etag + `"`)
 	}
-	return string(resp.LocationConstraint), nil
+	_, err := client.DeleteObject(ctx, input)
+	if err != nil {
+		s3Logger.Printf("ERROR: Failed to delete s3://%s/%s: %v", bucket, key, err)
+		return err
+	}
+	s3Logger.Printf("Deleted s3://%s/%s", bucket, key)
+	return nil
 }
 
 // HTTP handler for listing S3 buckets
@@ -154,37 +286,23 @@ func handleListBuckets(scannerClient *amaasclient.AmaasClient) http.HandlerFunc
 
 		s3Logger.Printf("--- LIST BUCKETS REQUEST at %s ---", time.Now().Format(time.RFC3339))
 
-		var req struct {
-			AwsAccessKey string `json:"awsAccessKey"`
-			AwsSecretKey string `json:"awsSecretKey"`
-			Region       string `json:"region"`
-		}
+		var req S3Options
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
+		req = req.withDefaults()
 
-		ctx := context.Background()
-		var cfg aws.Config
-		var err error
-
-		if req.AwsAccessKey != "" && req.AwsSecretKey != "" {
-			cfg, err = config.LoadDefaultConfig(ctx,
-				config.WithRegion(req.Region),
-				config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(req.AwsAccessKey, req.AwsSecretKey, "")),
-			)
-		} else {
-			cfg, err = config.LoadDefaultConfig(ctx, config.WithRegion(req.Region))
-		}
-
+		ctx := r.Context()
+		cfg, err := loadAWSConfig(ctx, req, req.Region)
 		if err != nil {
 			s3Logger.Printf("ERROR: Failed to load AWS config: %v", err)
 			http.Error(w, fmt.Sprintf("Failed to load AWS config: %v", err), http.StatusInternalServerError)
 			return
 		}
 
-		client := s3.NewFromConfig(cfg)
+		client := newS3Client(cfg, req)
 		s3Logger.Println("Listing S3 buckets...")
 		result, err := client.ListBuckets(ctx, &s3.ListBucketsInput{})
 		if err != nil {
@@ -194,20 +312,18 @@ func handleListBuckets(scannerClient *amaasclient.AmaasClient) http.HandlerFunc
 		}
 		s3Logger.Printf("Found %d buckets", len(result.Buckets))
 
-		buckets := make([]map[string]interface{}, 0)
+		response := S3ListBucketsResponse{Buckets: make([]S3BucketInfo, 0, len(result.Buckets))}
 		for _, bucket := range result.Buckets {
 			s3Logger.Printf("  - Bucket: %s (created: %s)", *bucket.Name, bucket.CreationDate)
-			buckets = append(buckets, map[string]interface{}{
-				"name":         *bucket.Name,
-				"creationDate": bucket.CreationDate,
+			response.Buckets = append(response.Buckets, S3BucketInfo{
+				Name:         *bucket.Name,
+				CreationDate: bucket.CreationDate,
 			})
 		}
-		s3Logger.Printf("Successfully listed %d buckets", len(buckets))
+		s3Logger.Printf("Successfully listed %d buckets", len(response.Buckets))
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"buckets": buckets,
-		})
+		json.NewEncoder(w).Encode(response)
 	}
 }
 
@@ -221,120 +337,92 @@ func handleListObjects(scannerClient *amaasclient.AmaasClient) http.HandlerFunc
 
 		s3Logger.Printf("--- LIST OBJECTS REQUEST at %s ---", time.Now().Format(time.RFC3339))
 
-		var req struct {
-			AwsAccessKey string `json:"awsAccessKey"`
-			AwsSecretKey string `json:"awsSecretKey"`
-			Region       string `json:"region"`
-			Bucket       string `json:"bucket"`
-			Prefix       string `json:"prefix"`
-			Recursive    bool   `json:"recursive"`
-		}
+		var req S3ListObjectsRequest
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
+		req.S3Options = req.S3Options.withDefaults()
 
-		ctx := context.Background()
-		var cfg aws.Config
-		var err error
-
-		if req.AwsAccessKey != "" && req.AwsSecretKey != "" {
-			cfg, err = config.LoadDefaultConfig(ctx,
-				config.WithRegion(req.Region),
-				config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(req.AwsAccessKey, req.AwsSecretKey, "")),
-			)
-		} else {
-			cfg, err = config.LoadDefaultConfig(ctx, config.WithRegion(req.Region))
+		// Bucket ARNs resolve to a name; access point ARNs carry their own region
+		target, err := resolveBucket(req.Bucket)
+		if err == nil {
+			req.S3Options, err = target.clientOptions(req.S3Options)
 		}
-
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to load AWS config: %v", err), http.StatusInternalServerError)
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "bucket")
 			return
 		}
+		req.Bucket = target.Name
+		if target.Region != "" {
+			req.Region = target.Region
+		}
 
-		client := s3.NewFromConfig(cfg)
-
-		// Try to get bucket region first
-		bucketRegion, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
-			Bucket: &req.Bucket,
-		})
+		ctx := r.Context()
+		cfg, err := loadAWSConfig(ctx, req.S3Options, req.Region)
 		if err != nil {
-			log.Printf("Warning: Could not get bucket region for %s: %v", req.Bucket, err)
-		} else if bucketRegion.LocationConstraint != "" {
-			// Recreate client with correct region
-			log.Printf("Bucket %s is in region: %s", req.Bucket, bucketRegion.LocationConstraint)
-			if req.AwsAccessKey != "" && req.AwsSecretKey != "" {
-				cfg, err = config.LoadDefaultConfig(ctx,
-					config.WithRegion(string(bucketRegion.LocationConstraint)),
-					config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(req.AwsAccessKey, req.AwsSecretKey, "")),
-				)
-			} else {
-				cfg, err = config.LoadDefaultConfig(ctx, config.WithRegion(string(bucketRegion.LocationConstraint)))
-			}
-			if err == nil {
-				client = s3.NewFromConfig(cfg)
-			}
+			http.Error(w, fmt.Sprintf("Failed to load AWS config: %v", err), http.StatusInternalServerError)
+			return
 		}
 
-		var prefix *string
-		if req.Prefix != "" {
-			prefix = &req.Prefix
+		client := newS3Client(cfg, req.S3Options)
+
+		// Try to get bucket region first (access points are addressed by ARN region instead)
+		if !target.AccessPoint {
+			if detected, err := getBucketRegion(ctx, cfg, req.S3Options, req.Bucket); err != nil {
+				log.Printf("Warning: Could not get bucket region for %s: %v", req.Bucket, err)
+			} else if detected != cfg.Region {
+				// Recreate client with correct region
+				log.Printf("Bucket %s is in region: %s", req.Bucket, detected)
+				cfg, err = loadAWSConfig(ctx, req.S3Options, detected)
+				if err == nil {
+					client = newS3Client(cfg, req.S3Options)
+				}
+			}
 		}
 
-		log.Printf("Listing objects in bucket %s with prefix '%s' (recursive: %v)", req.Bucket, req.Prefix, req.Recursive)
-
-		objects := make([]map[string]interface{}, 0)
-		var continuationToken *string
+		log.Printf("Listing objects in bucket %s with prefix '%s' (recursive: %v, sharded: %v)", req.Bucket, req.Prefix, req.Recursive, req.Sharded)
 
-		// Paginate through all results
-		for {
-			result, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
-				Bucket:            &req.Bucket,
-				Prefix:            prefix,
-				ContinuationToken: continuationToken,
-			})
-			if err != nil {
-				log.Printf("Failed to list objects in %s: %v", req.Bucket, err)
-				http.Error(w, fmt.Sprintf("Failed to list objects: %v", err), http.StatusInternalServerError)
-				return
-			}
+		var listed []types.Object
+		if req.Sharded {
+			listed, err = listObjectsSharded(ctx, client, req.Bucket, req.Prefix, getListConcurrency(req.Concurrency))
+		} else {
+			listed, err = listObjects(ctx, client, req.Bucket, req.Prefix)
+		}
+		if err != nil {
+			log.Printf("Failed to list objects in %s: %v", req.Bucket, err)
+			http.Error(w, fmt.Sprintf("Failed to list objects: %v", err), http.StatusInternalServerError)
+			return
+		}
 
-			for _, obj := range result.Contents {
-				// If not recursive, skip objects that are in subdirectories
-				if !req.Recursive && req.Prefix != "" {
-					relativePath := (*obj.Key)[len(req.Prefix):]
-					if strings.Contains(relativePath, "/") {
-						continue
-					}
-				} else if !req.Recursive && req.Prefix == "" {
-					if strings.Contains(*obj.Key, "/") {
-						continue
-					}
+		response := S3ListObjectsResponse{Bucket: req.Bucket, Objects: make([]S3ObjectInfo, 0)}
+		for _, obj := range listed {
+			// If not recursive, skip objects that are in subdirectories
+			// (TrimPrefix also guards against keys shorter than the prefix)
+			if !req.Recursive && req.Prefix != "" {
+				relativePath := strings.TrimPrefix(*obj.Key, req.Prefix)
+				if strings.Contains(relativePath, "/") {
+					continue
+				}
+			} else if !req.Recursive && req.Prefix == "" {
+				if strings.Contains(*obj.Key, "/") {
+					continue
 				}
-
-				s3Logger.Printf("  - Object: %s (size: %d bytes)", *obj.Key, obj.Size)
-				objects = append(objects, map[string]interface{}{
-					"key":          *obj.Key,
-					"size":         obj.Size,
-					"lastModified": obj.LastModified,
-				})
 			}
 
-			// Check if there are more results
-			if !*result.IsTruncated {
-				break
-			}
-			continuationToken = result.NextContinuationToken
+			s3Logger.Printf("  - Object: %s (size: %d bytes)", *obj.Key, obj.Size)
+			response.Objects = append(response.Objects, S3ObjectInfo{
+				Key:          *obj.Key,
+				Size:         obj.Size,
+				LastModified: obj.LastModified,
+			})
 		}
 
-		s3Logger.Printf("Successfully listed %d objects from s3://%s/%s", len(objects), req.Bucket, req.Prefix)
+		s3Logger.Printf("Successfully listed %d objects from s3://%s/%s", len(response.Objects), req.Bucket, req.Prefix)
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"bucket":  req.Bucket,
-			"objects": objects,
-		})
+		json.NewEncoder(w).Encode(response)
 	}
 }
 
@@ -348,14 +436,7 @@ func handleScanS3Object(scannerClient *amaasclient.AmaasClient) http.HandlerFunc
 
 		s3Logger.Printf("=== SCAN REQUEST at %s ===", time.Now().Format(time.RFC3339))
 
-		var req struct {
-			AwsAccessKey string   `json:"awsAccessKey"`
-			AwsSecretKey string   `json:"awsSecretKey"`
-			Region       string   `json:"region"`
-			Bucket       string   `json:"bucket"`
-			Key          string   `json:"key"`
-			Tags         []string `json:"tags"`
-		}
+		var req S3ScanRequest
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			s3Logger.Printf("Invalid request body: %v", err)
@@ -363,14 +444,38 @@ func handleScanS3Object(scannerClient *amaasclient.AmaasClient) http.HandlerFunc
 			return
 		}
 
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "callbackUrl")
+			return
+		}
+		remediation, err := requestRemediation(req.Remediation, req.Quarantine)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "remediation")
+			return
+		}
+		if req.SkipUnchanged && scanHistory == nil {
+			writeJSONError(w, http.StatusBadRequest, "skipUnchanged requires scan history (HISTORY_DSN)", "skipUnchanged")
+			return
+		}
+		sse, field, err := sseCustomerKeyFromRequest(r, req)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), field)
+			return
+		}
+		archived, err := requestArchived(req.Archived)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "archived")
+			return
+		}
+
 		s3Logger.Printf("Scan target: s3://%s/%s", req.Bucket, req.Key)
 		s3Logger.Printf("Region: %s, Tags: %v", req.Region, req.Tags)
 
-		ctx := context.Background()
+		ctx, attempts := withScanAttempts(r.Context())
 
 		// Create S3 reader
 		s3Logger.Println("Creating S3 reader for scan...")
-		reader, err := NewS3ClientReader(ctx, req.AwsAccessKey, req.AwsSecretKey, req.Region, req.Bucket, req.Key)
+		reader, err := newS3ClientReaderSSE(ctx, req.S3Options, req.Bucket, req.Key, sse)
 		if err != nil {
 			s3Logger.Printf("ERROR: Failed to create S3 reader: %v", err)
 			http.Error(w, fmt.Sprintf("Failed to create S3 reader: %v", err), http.StatusInternalServerError)
@@ -378,20 +483,48 @@ func handleScanS3Object(scannerClient *amaasclient.AmaasClient) http.HandlerFunc
 		}
 		s3Logger.Println("S3 reader created successfully")
 
-		// Scan the S3 object using the scanner client
-		tags := req.Tags
-		if tags == nil {
-			tags = []string{"source:s3"}
-		} else {
-			tags = append(tags, "source:s3")
+		if req.SkipUnchanged {
+			if previous, ok := lookupUnchanged(ctx, reader); ok {
+				s3Logger.Printf("skipUnchanged: %s is unchanged since %s", reader.Identifier(), previous.ScannedAt.Format(time.RFC3339))
+				response := S3ScanResponse{
+					Bucket:       req.Bucket,
+					Key:          req.Key,
+					Region:       reader.region,
+					RequestID:    requestIDFrom(ctx),
+					Skipped:      true,
+					Verdict:      previous.Verdict,
+					MalwareNames: previous.MalwareNames,
+					PreviousScan: &previous,
+					Result:       previous.Result,
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(response)
+				sendCallback(req.CallbackURL, eventScanCompleted, response)
+				return
+			}
 		}
 
+		// Archived objects are skipped, or scanned once restored in time
+		archive := handleArchivedObject(ctx, reader, archived)
+
+		// Scan the S3 object using the scanner client
+		tags := buildScanTags(sourceS3, getCustomTags(), append(req.Tags, "file_type="+path.Ext(req.Key))...)
+
 		log.Printf("=== Starting S3 Scan ===")
-		log.Printf("Object: s3://%s/%s", req.Bucket, req.Key)
-		log.Printf("Region: %s", req.Region)
+		log.Printf("Object: %s", reader.Identifier())
+		log.Printf("Region: %s", reader.region)
 		log.Printf("Size: %d bytes", reader.size)
 
-		scanResult, err := scannerClient.ScanReader(reader, tags)
+		ctx, scanClient, _ := scanPolicyClient(ctx, scannerClient, ScanTarget{
+			Source: sourceS3, Bucket: reader.bucket, Key: req.Key, DetectedType: reader.sniff(), Size: reader.size,
+		}, ScanOptions{})
+		scanStart := time.Now()
+		scanResult, _, err := scanUnlessListed(ctx, nil, "", req.Key, "", func() (string, error) {
+			return scanS3Reader(ctx, scanClient, reader, tags)
+		})
+		if writeScanRejected(w, err) || writeScanTimeout(w, ctx, err) {
+			return
+		}
 		if err != nil {
 			log.Printf("❌ Scan FAILED for s3://%s/%s: %v", req.Bucket, req.Key, err)
 			http.Error(w, fmt.Sprintf("Scan failed: %v", err), http.StatusInternalServerError)
@@ -402,12 +535,16 @@ func handleScanS3Object(scannerClient *amaasclient.AmaasClient) http.HandlerFunc
 		log.Printf("Result preview: %s", scanResult[:min(len(scanResult), 200)])
 
 		// Parse scan result to extract key information
+		// confirmedClean is only set when the result parses and reports no threats
+		confirmedClean := false
 		var result map[string]interface{}
 		if err := json.Unmarshal([]byte(scanResult), &result); err != nil {
 			s3Logger.Printf("WARNING: Failed to parse scan result: %v", err)
 		} else {
 			if scanResultCode, ok := result["scanResult"].(float64); ok {
 				if scanResultCode == 0 {
+					foundMalwares, _ := result["foundMalwares"].([]interface{})
+					confirmedClean = len(foundMalwares) == 0
 					s3Logger.Printf("Scan result: CLEAN (no threats detected)")
 				} else {
 					s3Logger.Printf("Scan result: THREAT DETECTED (code: %.0f)", scanResultCode)
@@ -426,12 +563,71 @@ func handleScanS3Object(scannerClient *amaasclient.AmaasClient) http.HandlerFunc
 			}
 		}
 
+		verdict, verdictErr := parseScanVerdict(scanResult)
+		// A clean result for a blocklisted hash is not clean, objects skipped
+		// by a scan policy rule or the size limit were not scanned, and
+		// truncated scans did not cover the whole object
+		confirmedClean = confirmedClean && verdictErr == nil && verdict.IsSafe && !verdict.Skipped && !verdict.Truncated
+		if verdictErr == nil {
+			reportScanOutcome(ctx, ScanOutcome{
+				Source:     sourceS3,
+				Bucket:     reader.bucket,
+				Key:        req.Key,
+				ETag:       reader.etag,
+				VersionID:  reader.versionID,
+				Identifier: reader.Identifier(),
+				Tags:       tags,
+				Verdict:    verdict,
+				Duration:   time.Since(scanStart),
+				Size:       reader.size,
+			})
+		}
+
+		response := S3ScanResponse{
+			ScanResult: scanResult,
+			Bucket:     req.Bucket,
+			Key:        req.Key,
+			Region:     reader.region,
+			RequestID:  requestIDFrom(ctx),
+			Attempts:   int(attempts.Load()),
+			Archive:    archive,
+		}
+		if verdictErr == nil {
+			normalized := verdict.Normalize(time.Since(scanStart), wantsRawResult(r))
+			response.Result = &normalized
+		}
+
+		// Optional metadata policy check, reported separately from the malware verdict
+		if req.CheckPolicy {
+			findings := checkObjectPolicy(ctx, reader.client, reader.bucket, req.Key, reader.sse)
+			response.PolicyFindings = &findings
+		}
+
+		// Remediate only when the verdict confirms a detection
+		if verdictErr == nil && !verdict.IsSafe {
+			if policy, rule, ok := resolveRemediation(ctx, remediation, reader.bucket, req.Key); ok {
+				result := remediateS3Object(ctx, reader.client, reader.scannedObject(), policy, rule)
+				response.Remediation = &result
+			}
+		}
+
+		// Delete clean objects only once the verdict is confirmed; infected objects are left for review
+		if req.DeleteOnClean {
+			deleted := false
+			response.Deleted = &deleted
+			if confirmedClean {
+				if err := deleteS3Object(ctx, reader.client, reader.bucket, req.Key, reader.versionID, reader.etag); err != nil {
+					response.DeleteError = err.Error()
+				} else {
+					deleted = true
+				}
+			} else {
+				s3Logger.Printf("deleteOnClean: keeping s3://%s/%s (verdict not clean)", req.Bucket, req.Key)
+			}
+		}
+
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"scanResult": scanResult,
-			"bucket":     req.Bucket,
-			"key":        req.Key,
-			"region":     req.Region,
-		})
+		json.NewEncoder(w).Encode(response)
+		sendCallback(req.CallbackURL, eventScanCompleted, response)
 	}
 }
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+	pb "github.com/trendmicro/tm-v1-fs-golang-sdk/protos"
+	"google.golang.org/grpc"
+)
+
+// fakeScanner is a scanner backend that reports files containing "EICAR" as
+// infected and everything else as clean
+type fakeScanner struct {
+	pb.UnimplementedScanServer
+	mu   sync.Mutex
+	tags [][]string
+}
+
+func (f *fakeScanner) Run(stream pb.Scan_RunServer) error {
+	init, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.tags = append(f.tags, init.Tags)
+	f.mu.Unlock()
+	var data []byte
+	if init.RsSize > 0 {
+		// Bulk clients read the bulk fields, others offset and length
+		retr := &pb.S2C{Stage: pb.Stage_STAGE_RUN, Cmd: pb.Command_CMD_RETR, Length: int32(init.RsSize), BulkOffset: []int32{0}, BulkLength: []int32{int32(init.RsSize)}}
+		if err := stream.Send(retr); err != nil {
+			return err
+		}
+		chunk, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		data = chunk.Chunk
+	}
+	result := map[string]interface{}{"scanResult": 0, "foundMalwares": []interface{}{}, "fileName": init.FileName, "fileSHA256": sha256Hex(data)}
+	if bytes.Contains(data, []byte("EICAR")) {
+		result["scanResult"] = 1
+		result["foundMalwares"] = []interface{}{map[string]string{"fileName": init.FileName, "malwareName": "Eicar_test_file"}}
+	}
+	encoded, _ := json.Marshal(result)
+	return stream.Send(&pb.S2C{Stage: pb.Stage_STAGE_FINI, Cmd: pb.Command_CMD_QUIT, Result: string(encoded)})
+}
+
+// newTestScanner starts a fakeScanner and returns a client for it
+func newTestScanner(t *testing.T) *amaasclient.AmaasClient {
+	t.Helper()
+	client, _ := newRecordingTestScanner(t)
+	return client
+}
+
+// newRecordingTestScanner starts a fakeScanner and returns a client for it and
+// the scanner, which records the tags of every scan
+func newRecordingTestScanner(t *testing.T) (*amaasclient.AmaasClient, *fakeScanner) {
+	t.Helper()
+	scanner := &fakeScanner{}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	pb.RegisterScanServer(server, scanner)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	client, err := amaasclient.NewClientInternal("", listener.Addr().String(), false, "")
+	if err != nil {
+		t.Fatalf("scanner client: %v", err)
+	}
+	t.Cleanup(client.Destroy)
+	return client, scanner
+}
+
+// scannedTags returns the tags of every scan so far
+func (f *fakeScanner) scannedTags() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]string{}, f.tags...)
+}
+
+// fakeS3Object is an object version held by fakeS3
+type fakeS3Object struct {
+	data      []byte
+	etag      string
+	versionID string
+}
+
+// fakeS3Request is a request fakeS3 received
+type fakeS3Request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+}
+
+// fakeS3 serves HeadObject, GetObjectAttributes, ranged GetObject,
+// DeleteObject, CopyObject and object tagging for objects keyed by "bucket/key".
+// Every version is kept; requests without a versionId address the current one.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]*fakeS3Object // versions, oldest first
+	requests []fakeS3Request
+}
+
+// newFakeS3 returns a fakeS3 holding the first version of objects
+func newFakeS3(objects map[string]string) *fakeS3 {
+	fake := &fakeS3{objects: map[string][]*fakeS3Object{}}
+	for path, data := range objects {
+		fake.put(path, []byte(data))
+	}
+	return fake
+}
+
+// serve starts a server for f and returns its endpoint, which requests may
+// name since loopback is allowed for the test
+func (f *fakeS3) serve(t *testing.T) string {
+	t.Helper()
+	t.Setenv("OUTBOUND_ALLOWED_NETWORKS", "127.0.0.0/8")
+	s3Logger = newComponentLogger(io.Discard, "s3")
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// object returns the current version of the object at path
+func (f *fakeS3) object(path string) *fakeS3Object {
+	return f.version(path, "")
+}
+
+// version returns the version of the object at path, or the current one
+// when versionID is empty
+func (f *fakeS3) version(path, versionID string) *fakeS3Object {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	versions := f.objects[path]
+	if versionID == "" && len(versions) > 0 {
+		return versions[len(versions)-1]
+	}
+	for _, obj := range versions {
+		if obj.versionID == versionID {
+			return obj
+		}
+	}
+	return nil
+}
+
+// put stores a new version of the object at path
+func (f *fakeS3) put(path string, data []byte) *fakeS3Object {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	version := 1
+	if versions := f.objects[path]; len(versions) > 0 {
+		version, _ = strconv.Atoi(strings.TrimPrefix(versions[len(versions)-1].versionID, "v"))
+		version++
+	}
+	obj := &fakeS3Object{data: data, etag: sha256Hex(data)[:32], versionID: fmt.Sprintf("v%d", version)}
+	f.objects[path] = append(f.objects[path], obj)
+	return obj
+}
+
+// received returns the requests with method for path
+func (f *fakeS3) received(method, path string) []fakeS3Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var matched []fakeS3Request
+	for _, req := range f.requests {
+		if req.method == method && req.path == path {
+			matched = append(matched, req)
+		}
+	}
+	return matched
+}
+
+// s3Error writes an S3 error response
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeS3Request{method: r.Method, path: path, query: r.URL.Query(), header: r.Header.Clone()})
+	f.mu.Unlock()
+	versionID := r.URL.Query().Get("versionId")
+	obj := f.version(path, versionID)
+
+	if r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "" {
+		f.copyObject(w, r, path)
+		return
+	}
+	if r.Method == http.MethodPut && r.URL.Query().Has("tagging") {
+		if obj == nil {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+		}
+		return
+	}
+	if obj == nil {
+		switch {
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		case versionID != "":
+			s3Error(w, http.StatusNotFound, "NoSuchVersion")
+		default:
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+		}
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" && strings.Trim(match, `"`) != obj.etag {
+		s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		// Deletes without a versionId remove every version, like on an
+		// unversioned bucket
+		f.mu.Lock()
+		if versionID == "" {
+			delete(f.objects, path)
+		} else {
+			f.objects[path] = slices.DeleteFunc(f.objects[path], func(v *fakeS3Object) bool { return v == obj })
+		}
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodHead, http.MethodGet:
+		w.Header().Set("ETag", `"`+obj.etag+`"`)
+		w.Header().Set("X-Amz-Version-Id", obj.versionID)
+		if r.URL.Query().Has("attributes") {
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprintf(w, "<GetObjectAttributesResponse><ETag>%s</ETag><ObjectSize>%d</ObjectSize><StorageClass>STANDARD</StorageClass></GetObjectAttributesResponse>", obj.etag, len(obj.data))
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		data, status := obj.data, http.StatusOK
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+			if start >= len(data) {
+				s3Error(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+				return
+			}
+			end = min(end, len(data)-1)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			data, status = data[start:end+1], http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	default:
+		s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// copyObject copies the version named by X-Amz-Copy-Source to path
+func (f *fakeS3) copyObject(w http.ResponseWriter, r *http.Request, path string) {
+	source, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err != nil {
+		s3Error(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	sourcePath, query, _ := strings.Cut(source, "?")
+	obj := f.version(sourcePath, strings.TrimPrefix(query, "versionId="))
+	if obj == nil {
+		s3Error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	if match := r.Header.Get("X-Amz-Copy-Source-If-Match"); match != "" && strings.Trim(match, `"`) != obj.etag {
+		s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+	copied := f.put(path, obj.data)
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, `<CopyObjectResult><ETag>"%s"</ETag></CopyObjectResult>`, copied.etag)
+}
+
+// scanS3 posts req to /s3/scan and decodes the response
+func scanS3(t *testing.T, scanner *amaasclient.AmaasClient, req map[string]interface{}) S3ScanResponse {
+	t.Helper()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	req["region"], req["forcePathStyle"] = "us-east-1", true
+	body, _ := json.Marshal(req)
+	rec := httptest.NewRecorder()
+	handleScanS3Object(scanner)(rec, httptest.NewRequest(http.MethodPost, "/s3/scan", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/s3/scan status %d: %s", rec.Code, rec.Body)
+	}
+	var response S3ScanResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return response
+}
+
+func TestDeleteOnClean(t *testing.T) {
+	scanner := newTestScanner(t)
+	tests := []struct {
+		name        string
+		key         string
+		data        string
+		wantDeleted bool
+	}{
+		{"clean object is deleted", "clean.txt", "hello", true},
+		{"infected object is kept", "infected.txt", "X5O!P%@AP EICAR test", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeS3(map[string]string{"uploads/" + tt.key: tt.data})
+			response := scanS3(t, scanner, map[string]interface{}{
+				"bucket": "uploads", "key": tt.key, "endpointUrl": fake.serve(t), "deleteOnClean": true,
+			})
+			if response.Deleted == nil || *response.Deleted != tt.wantDeleted {
+				t.Fatalf("deleted = %v (%s), want %v", response.Deleted, response.DeleteError, tt.wantDeleted)
+			}
+			deletes := fake.received(http.MethodDelete, "uploads/"+tt.key)
+			if !tt.wantDeleted {
+				if len(deletes) != 0 {
+					t.Errorf("infected object received %d deletes", len(deletes))
+				}
+				return
+			}
+			if len(deletes) != 1 || deletes[0].query.Get("versionId") != "v1" {
+				t.Errorf("deletes %+v, want one pinned to the scanned version v1", deletes)
+			}
+		})
+	}
+}
+
+// An object replaced after the scan is not deleted: on versioned buckets only
+// the scanned version is, on others the delete is refused
+func TestDeleteOnCleanPinsScannedVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		versioned bool
+	}{
+		{"versioned", true},
+		{"unversioned", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeS3(map[string]string{"uploads/file.txt": "scanned"})
+			scanned := fake.object("uploads/file.txt")
+			replaced := fake.put("uploads/file.txt", []byte("replaced after the scan"))
+			client := newTestS3Client(t, fake)
+
+			versionID, etag := scanned.versionID, scanned.etag
+			if !tt.versioned {
+				versionID = ""
+			}
+			err := deleteS3Object(context.Background(), client, "uploads", "file.txt", versionID, etag)
+			if tt.versioned {
+				if err != nil {
+					t.Errorf("deleting the scanned version: %v", err)
+				}
+				if fake.version("uploads/file.txt", scanned.versionID) != nil {
+					t.Error("the scanned version was not deleted")
+				}
+			} else if err == nil {
+				t.Error("deleting the scanned object succeeded, but it was replaced")
+			}
+			if fake.object("uploads/file.txt") != replaced {
+				t.Error("the replacement object was deleted")
+			}
+		})
+	}
+}
+
+// Range reads keep returning the probed object after it is replaced
+func TestRangeReadsPinProbedVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		versioned bool
+		wantErr   bool
+	}{
+		{"versioned", true, false},
+		{"unversioned", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_ACCESS_KEY_ID", "test")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+			fake := newFakeS3(map[string]string{"uploads/file.txt": "first version"})
+			opts := S3Options{Region: "us-east-1", EndpointURL: fake.serve(t), ForcePathStyle: true}
+			reader, err := NewS3ClientReader(context.Background(), opts, "uploads", "file.txt")
+			if err != nil {
+				t.Fatalf("NewS3ClientReader: %v", err)
+			}
+			if !tt.versioned {
+				reader.versionID = ""
+			}
+
+			fake.put("uploads/file.txt", []byte("second version"))
+			data, err := reader.ReadBytes(0, int32(reader.size))
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "changed while it was being scanned") {
+					t.Errorf("ReadBytes = %q, %v; want a changed-object error", data, err)
+				}
+				return
+			}
+			if err != nil || string(data) != "first version" {
+				t.Errorf("ReadBytes = %q, %v; want the probed version", data, err)
+			}
+		})
+	}
+}
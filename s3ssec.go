@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SSE-C request headers, accepted on /s3/scan like S3 accepts them
+const (
+	sseCustomerAlgorithmHeader = "X-Amz-Server-Side-Encryption-Customer-Algorithm"
+	sseCustomerKeyHeader       = "X-Amz-Server-Side-Encryption-Customer-Key"
+	sseCustomerKeyMD5Header    = "X-Amz-Server-Side-Encryption-Customer-Key-Md5"
+)
+
+// SSECustomerKey is the customer-provided key of an object encrypted with
+// SSE-C, in the base64 form S3 expects. It is only kept for the request.
+type SSECustomerKey struct {
+	Algorithm string
+	Key       string
+	KeyMD5    string
+}
+
+// parseSSECustomerKey validates a base64 256-bit key and its optional
+// base64 MD5, which is computed when missing. An empty key returns nil.
+func parseSSECustomerKey(algorithm, key, keyMD5 string) (*SSECustomerKey, error) {
+	if key == "" {
+		if algorithm != "" || keyMD5 != "" {
+			return nil, fmt.Errorf("sseCustomerKey is required with sseCustomerAlgorithm or sseCustomerKeyMD5")
+		}
+		return nil, nil
+	}
+	if algorithm == "" {
+		algorithm = "AES256"
+	}
+	if !strings.EqualFold(algorithm, "AES256") {
+		return nil, fmt.Errorf("unsupported sseCustomerAlgorithm %q, expected AES256", algorithm)
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("sseCustomerKey must be a base64-encoded 256-bit key")
+	}
+	sum := md5.Sum(raw)
+	digest := base64.StdEncoding.EncodeToString(sum[:])
+	if keyMD5 != "" && keyMD5 != digest {
+		return nil, fmt.Errorf("sseCustomerKeyMD5 does not match sseCustomerKey")
+	}
+	return &SSECustomerKey{Algorithm: "AES256", Key: key, KeyMD5: digest}, nil
+}
+
+// sseCustomerKeyFromRequest returns the SSE-C key of an /s3/scan request,
+// from its body fields or else the S3 SSE-C headers, and the field to
+// report errors on
+func sseCustomerKeyFromRequest(r *http.Request, req S3ScanRequest) (*SSECustomerKey, string, error) {
+	if req.SSECustomerKey != "" || req.SSECustomerAlgorithm != "" || req.SSECustomerKeyMD5 != "" {
+		sse, err := parseSSECustomerKey(req.SSECustomerAlgorithm, string(req.SSECustomerKey), req.SSECustomerKeyMD5)
+		return sse, "sseCustomerKey", err
+	}
+	sse, err := parseSSECustomerKey(r.Header.Get(sseCustomerAlgorithmHeader), r.Header.Get(sseCustomerKeyHeader), r.Header.Get(sseCustomerKeyMD5Header))
+	return sse, "", err
+}
+
+// params returns the algorithm, key and MD5 to set on an S3 input; all are
+// nil without a key
+func (k *SSECustomerKey) params() (algorithm, key, keyMD5 *string) {
+	if k == nil {
+		return nil, nil, nil
+	}
+	return &k.Algorithm, &k.Key, &k.KeyMD5
+}
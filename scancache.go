@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultScanCacheTTL  = time.Hour
+	defaultScanCacheSize = 10000
+	redisScanCachePrefix = "finguard:scan:"
+)
+
+var (
+	scanCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "finguard_scan_cache_hits_total",
+		Help: "Uploads answered from the scan result cache.",
+	})
+	scanCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "finguard_scan_cache_misses_total",
+		Help: "Uploads not found in the scan result cache.",
+	})
+)
+
+// scanCacheBackend stores raw scan results by content key
+type scanCacheBackend interface {
+	Get(ctx context.Context, key string) (string, bool)
+	Set(ctx context.Context, key, scanResult string)
+}
+
+// ScanCache deduplicates uploads by SHA-256 so identical content is only sent
+// to the scanner once per TTL. A nil *ScanCache disables caching.
+type ScanCache struct {
+	backend scanCacheBackend
+}
+
+// newScanCacheFromEnv builds the cache selected by SCAN_CACHE (off, memory or
+// redis). SCAN_CACHE_TTL bounds how long a verdict is reused.
+func newScanCacheFromEnv() *ScanCache {
+	mode := getEnv("SCAN_CACHE", "off")
+	if mode == "off" {
+		return nil
+	}
+
+	ttl, err := time.ParseDuration(getEnv("SCAN_CACHE_TTL", defaultScanCacheTTL.String()))
+	if err != nil || ttl <= 0 {
+		log.Printf("Warning: invalid SCAN_CACHE_TTL, using %s", defaultScanCacheTTL)
+		ttl = defaultScanCacheTTL
+	}
+
+	switch mode {
+	case "memory":
+		size := getEnvInt("SCAN_CACHE_SIZE", defaultScanCacheSize)
+		log.Printf("- Scan cache: in-memory LRU (%d entries, TTL %s)", size, ttl)
+		return &ScanCache{backend: &memoryScanCache{lru: expirable.NewLRU[string, string](size, nil, ttl)}}
+	case "redis":
+		opts, err := redis.ParseURL(getEnv("SCAN_CACHE_REDIS_URL", "redis://localhost:6379/0"))
+		if err != nil {
+			log.Printf("Warning: scan cache disabled, invalid SCAN_CACHE_REDIS_URL: %v", err)
+			return nil
+		}
+		log.Printf("- Scan cache: redis %s (TTL %s)", opts.Addr, ttl)
+		return &ScanCache{backend: &redisScanCache{client: redis.NewClient(opts), ttl: ttl}}
+	}
+	log.Printf("Warning: unknown SCAN_CACHE %q, caching disabled", mode)
+	return nil
+}
+
+// Ping checks that a shared cache backend is reachable
+func (c *ScanCache) Ping(ctx context.Context) error {
+	if redisCache, ok := c.backend.(*redisScanCache); ok {
+		return redisCache.client.Ping(ctx).Err()
+	}
+	return nil
+}
+
+// scanCacheKey combines the content hash with the options that change the
+// scanner output, so results scanned with different features are kept apart
+func scanCacheKey(sha256Hex string, opts ScanOptions) string {
+	return fmt.Sprintf("%s:pml=%t,fb=%t,verbose=%t,ac=%t,digest=%t",
+		sha256Hex, opts.PML, opts.Feedback, opts.Verbose, opts.ActiveContent, !opts.DisableDigest)
+}
+
+// Do returns the cached result for key or runs scan and caches it when the
+// result carries a verdict of a complete scan. An empty key or nil cache
+// always scans.
+func (c *ScanCache) Do(ctx context.Context, key string, scan func() (string, error)) (string, bool, error) {
+	if c == nil || key == "" {
+		result, err := scan()
+		return result, false, err
+	}
+	key = tenantScoped(ctx, key)
+
+	if result, ok := c.backend.Get(ctx, key); ok {
+		scanCacheHits.Inc()
+		return result, true, nil
+	}
+	scanCacheMisses.Inc()
+
+	result, err := scan()
+	if err == nil {
+		// Size-limited results depend on the limits, not only the content
+		if verdict, parseErr := parseScanVerdict(result); parseErr == nil && !verdict.TooLarge && !verdict.Truncated {
+			c.backend.Set(ctx, key, result)
+		}
+	}
+	return result, false, err
+}
+
+// sha256Hex returns the hex SHA-256 of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sha256File returns the hex SHA-256 of the file at path
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// memoryScanCache is an in-process LRU with per-entry expiry
+type memoryScanCache struct {
+	lru *expirable.LRU[string, string]
+}
+
+func (m *memoryScanCache) Get(_ context.Context, key string) (string, bool) {
+	return m.lru.Get(key)
+}
+
+func (m *memoryScanCache) Set(_ context.Context, key, scanResult string) {
+	m.lru.Add(key, scanResult)
+}
+
+// redisScanCache shares results between replicas through Redis
+type redisScanCache struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func (r *redisScanCache) Get(ctx context.Context, key string) (string, bool) {
+	result, err := r.client.Get(ctx, redisScanCachePrefix+key).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Scan cache: redis get failed: %v", err)
+		}
+		return "", false
+	}
+	return result, true
+}
+
+func (r *redisScanCache) Set(ctx context.Context, key, scanResult string) {
+	if err := r.client.Set(ctx, redisScanCachePrefix+key, scanResult, r.ttl).Err(); err != nil {
+		log.Printf("Scan cache: redis set failed: %v", err)
+	}
+}
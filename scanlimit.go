@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	defaultScanConcurrency = 16
+	defaultScanQueueDepth  = 100
+)
+
+// errScanQueueFull is returned when the scan queue is at its configured depth
+var errScanQueueFull = errors.New("scan queue is full")
+
+type backgroundScanKey struct{}
+
+// ScanLimiter bounds the number of concurrent scanner calls. Interactive
+// requests wait in a queue of limited depth; background work (bulk jobs, SQS)
+// waits without a depth limit since its own worker pools already bound it.
+type ScanLimiter struct {
+	slots   chan struct{}
+	depth   int64
+	waiting atomic.Int64
+}
+
+// scanLimiter is the process-wide limiter used by observeScan
+var scanLimiter = newScanLimiterFromEnv()
+
+// newScanLimiterFromEnv reads SCAN_CONCURRENCY and SCAN_QUEUE_DEPTH
+func newScanLimiterFromEnv() *ScanLimiter {
+	concurrency := getEnvInt("SCAN_CONCURRENCY", defaultScanConcurrency)
+	if concurrency <= 0 {
+		concurrency = defaultScanConcurrency
+	}
+	return &ScanLimiter{
+		slots: make(chan struct{}, concurrency),
+		depth: int64(getEnvInt("SCAN_QUEUE_DEPTH", defaultScanQueueDepth)),
+	}
+}
+
+// withBackgroundScan marks ctx as background work that should wait for a slot
+// instead of being rejected when the queue is full
+func withBackgroundScan(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundScanKey{}, true)
+}
+
+// Acquire takes a scan slot, queueing if all are busy. The returned function
+// releases the slot.
+func (l *ScanLimiter) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	default:
+	}
+
+	background, _ := ctx.Value(backgroundScanKey{}).(bool)
+	if waiting := l.waiting.Add(1); !background && waiting > l.depth {
+		l.waiting.Add(-1)
+		return nil, errScanQueueFull
+	}
+	defer l.waiting.Add(-1)
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *ScanLimiter) release() {
+	<-l.slots
+}
+
+// writeScanQueueFull sends 429 for scans rejected by the limiter
+func writeScanQueueFull(w http.ResponseWriter) {
+	log.Printf("Scan rejected: %v", errScanQueueFull)
+	w.Header().Set("Retry-After", "1")
+	writeJSONError(w, http.StatusTooManyRequests, "Scanner is busy, retry later", "")
+}
@@ -1,25 +1,56 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
 )
 
 // ScanResponse represents the response we'll send back to the Node.js application
 type ScanResponse struct {
-	IsSafe     bool     `json:"isSafe"`
-	Message    string   `json:"message"`
-	ScanID     string   `json:"scanId,omitempty"`
-	Detections string   `json:"detections,omitempty"`
-	Tags       []string `json:"tags,omitempty"`
+	IsSafe       bool     `json:"isSafe"`
+	Verdict      string   `json:"verdict,omitempty"`
+	MalwareNames []string `json:"malwareNames,omitempty"`
+	Message      string   `json:"message"`
+	ScanID       string   `json:"scanId,omitempty"`
+	Detections   string   `json:"detections,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	DetectedType string   `json:"detectedType,omitempty"`
+	// Suppressed lists detections ignored because the file is allowlisted
+	Suppressed []string `json:"suppressedMalware,omitempty"`
+	RequestID  string   `json:"requestId,omitempty"`
+	Cached     bool     `json:"cached,omitempty"`
+	// Attempts is the number of scanner calls made, including retries
+	Attempts int `json:"attempts,omitempty"`
+	// Result is the normalized verdict shared by every scan endpoint
+	Result *NormalizedVerdict `json:"result,omitempty"`
+}
+
+// MinimalScanResponse is the verdict-only response returned when the caller
+// asks for X-Response-Mode: minimal. It omits the raw scan payload and the
+// normalized result.
+type MinimalScanResponse struct {
+	IsSafe       bool     `json:"isSafe"`
+	Verdict      string   `json:"verdict"`
+	MalwareNames []string `json:"malwareNames"`
+	ScanID       string   `json:"scanId,omitempty"`
+	DetectedType string   `json:"detectedType,omitempty"`
+	RequestID    string   `json:"requestId,omitempty"`
+	Cached       bool     `json:"cached,omitempty"`
+	Attempts     int      `json:"attempts,omitempty"`
 }
 
 // HealthResponse represents the health check response
@@ -39,6 +70,56 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
+// Check whether the caller asked for the verdict-only response
+// (X-Response-Mode header or responseMode query parameter)
+func wantsMinimalResponse(r *http.Request) bool {
+	mode := r.Header.Get("X-Response-Mode")
+	if mode == "" {
+		mode = r.URL.Query().Get("responseMode")
+	}
+	return strings.EqualFold(mode, "minimal")
+}
+
+// scanResponseFor builds the /scan response for a verdict, trimmed to the
+// verdict when the caller asked for the minimal response
+func scanResponseFor(r *http.Request, verdict ScanVerdict, scanResult string, tags []string, cached bool, attempts int, normalized NormalizedVerdict) interface{} {
+	if wantsMinimalResponse(r) {
+		return MinimalScanResponse{
+			IsSafe:       verdict.IsSafe,
+			Verdict:      verdict.Label(),
+			MalwareNames: verdict.MalwareNames,
+			ScanID:       verdict.ScanID,
+			DetectedType: verdict.DetectedType,
+			RequestID:    requestIDFrom(r.Context()),
+			Cached:       cached,
+			Attempts:     attempts,
+		}
+	}
+	return ScanResponse{
+		IsSafe:       verdict.IsSafe,
+		Verdict:      verdict.Label(),
+		MalwareNames: verdict.MalwareNames,
+		Message:      scanResult,
+		ScanID:       verdict.ScanID,
+		Tags:         tags,
+		DetectedType: verdict.DetectedType,
+		Detections:   scanResult,
+		Suppressed:   verdict.SuppressedMalware,
+		RequestID:    requestIDFrom(r.Context()),
+		Cached:       cached,
+		Attempts:     attempts,
+		Result:       &normalized,
+	}
+}
+
+// Get verdict string from the isSafe flag
+func verdictFor(isSafe bool) string {
+	if isSafe {
+		return "clean"
+	}
+	return "malicious"
+}
+
 // Get custom tags from environment
 func getCustomTags() []string {
 	customTags := os.Getenv("FSS_CUSTOM_TAGS")
@@ -49,33 +130,124 @@ func getCustomTags() []string {
 }
 
 func main() {
-	// Get configuration from environment variables
-	apiKey := os.Getenv("FSS_API_KEY")
-	region := getEnv("FSS_REGION", "us-1")
-	externalAddr := os.Getenv("SCANNER_EXTERNAL_ADDR")
-	useTLS := os.Getenv("SCANNER_USE_TLS") == "true"
+	preflight := flag.Bool("preflight", false, "verify scanner backend and AWS credentials before serving")
+	configPath := flag.String("config", "", "YAML config file; environment variables override its settings")
+	flag.Parse()
 
-	// Get custom tags
-	customTags := getCustomTags()
+	if *configPath != "" {
+		if err := loadConfigFile(*configPath); err != nil {
+			log.Fatalf("Failed to load config file: %v", err)
+		}
+		// The scan limiter and retry policy are built before main runs
+		scanLimiter = newScanLimiterFromEnv()
+		scanRetry = newScanRetryPolicyFromEnv()
+		scanBreaker = newCircuitBreakerFromEnv()
+	}
 
-	// Configure logging
-	f, err := os.OpenFile("/app/scanner.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	// Resolve secretsmanager:, ssm: and vault: references in FSS_API_KEY and the AWS credentials
+	var err error
+	secretResolver, err = resolveSecretsFromEnv(context.Background())
 	if err != nil {
-		log.Fatalf("Error opening log file: %v", err)
+		log.Fatalf("Failed to resolve secrets: %v", err)
+	}
+
+	// Named AWS credential profiles referenced by "profile" in S3 requests
+	if path := os.Getenv("AWS_PROFILES_FILE"); path != "" {
+		if awsProfiles, err = loadAWSProfiles(path); err != nil {
+			log.Fatalf("Failed to load AWS credential profiles: %v", err)
+		}
+	}
+	awsClients = NewAWSClientCache()
+	bucketRegions = NewBucketRegionCache()
+
+	// One-off scans without the HTTP server
+	if flag.Arg(0) == "scan" {
+		os.Exit(runCLI(flag.Args()[1:]))
 	}
-	defer f.Close()
-	log.SetOutput(f)
+
+	// Get custom tags
+	customTags := getCustomTags()
+
+	// Configure logging (LOG_PATH=stdout disables the log file)
+	initLogging(openLogWriter(getEnv("LOG_PATH", "/app/scanner.log")))
 
 	// Initialize S3 logger
 	initS3Logger()
 
 	// Log startup configuration
+	build := buildVersionInfo()
 	log.Printf("Scanner Service Starting")
+	log.Printf("- Version: %s (commit %s, built %s, %s, SDK %s)", build.Version, build.GitCommit, build.BuildDate, build.GoVersion, build.SDKVersion)
 	log.Printf("Configuration:")
+	if *configPath != "" {
+		log.Printf("- Config File: %s", *configPath)
+	}
+
+	client, endpoint, err := newScannerClient(customTags)
+	if err != nil {
+		log.Fatalf("Failed to create scanner client: %v", err)
+	}
+	// Failover backends from SCANNER_FAILOVER_BACKENDS
+	scannerPool, err = newScannerPoolFromEnv(client, endpoint)
+	if err != nil {
+		log.Fatalf("Failed to create scanner backends: %v", err)
+	}
+	scannerPool.Start()
+
+	// Fetch rotated secrets every SECRETS_REFRESH_INTERVAL
+	secretResolver.Start(context.Background())
+
+	// Tenants with their own scanner credentials, tags and quotas
+	if path := os.Getenv("TENANTS_FILE"); path != "" {
+		tenantRegistry, err = loadTenants(path)
+		if err != nil {
+			log.Fatalf("Failed to load tenants: %v", err)
+		}
+	}
+
+	// Tracing (no-op unless an OTLP endpoint is configured)
+	shutdownTracing := initTracing(context.Background())
+	defer shutdownTracing(context.Background())
+
+	// Detection notifiers and scan event sinks (SNS, EventBridge)
+	initNotifiers(context.Background())
+
+	// Per-bucket remediation rules for infected S3 objects
+	initRemediation()
+	initScanPolicy()
+	initHashAllowlist()
+	initHashBlocklist()
+
+	// Remove upload spool files left by a previous run
+	cleanSpoolDir()
+
+	// Persistent scan history (SQLite or Postgres when HISTORY_DSN is set)
+	initHistory(context.Background())
+	defer scanHistory.Close()
+
+	// Daily and weekly summary reports built from the scan history
+	initReports()
+	defer reportStore.Close()
+	if err := initComplianceSigning(); err != nil {
+		log.Fatalf("Failed to load compliance signing key: %v", err)
+	}
+
+	// Optional startup gate
+	if *preflight || os.Getenv("SCANNER_PREFLIGHT") == "true" {
+		enforcePreflight(client, endpoint)
+	}
 
-	// Create AMaaS client - both modes use the SDK client interface
-	var client *amaasclient.AmaasClient
-	var endpoint string
+	startHTTPServer(client, customTags, endpoint)
+}
+
+// newScannerClient creates the AMaaS client from the environment - an external
+// gRPC scanner when SCANNER_EXTERNAL_ADDR is set, the SaaS SDK otherwise - and
+// returns it with the endpoint it talks to
+func newScannerClient(customTags []string) (*amaasclient.AmaasClient, string, error) {
+	apiKey := os.Getenv("FSS_API_KEY")
+	region := getEnv("FSS_REGION", "us-1")
+	externalAddr := os.Getenv("SCANNER_EXTERNAL_ADDR")
+	useTLS := os.Getenv("SCANNER_USE_TLS") == "true"
 
 	if externalAddr != "" {
 		// External gRPC scanner mode
@@ -83,31 +255,27 @@ func main() {
 		log.Printf("- Scanner Address: %s", externalAddr)
 		log.Printf("- TLS: %v", useTLS)
 		log.Printf("- Custom Tags: %v", customTags)
-		endpoint = externalAddr
 
-		var err error
-		client, err = amaasclient.NewClientInternal("", externalAddr, useTLS, "")
+		client, err := amaasclient.NewClientInternal("", externalAddr, useTLS, "")
 		if err != nil {
-			log.Fatalf("Failed to create external scanner client: %v", err)
-		}
-	} else {
-		// SaaS SDK mode (default)
-		if apiKey == "" {
-			log.Fatal("FSS_API_KEY must be set when not using external scanner")
+			return nil, "", fmt.Errorf("external scanner: %v", err)
 		}
-		log.Printf("- Mode: SaaS SDK Scanner")
-		log.Printf("- Region: %s", region)
-		log.Printf("- Custom Tags: %v", customTags)
-		endpoint = region
+		return client, externalAddr, nil
+	}
 
-		var err error
-		client, err = amaasclient.NewClient(apiKey, region)
-		if err != nil {
-			log.Fatalf("Failed to create SaaS SDK scanner client: %v", err)
-		}
+	// SaaS SDK mode (default)
+	if apiKey == "" {
+		return nil, "", errors.New("FSS_API_KEY must be set when not using external scanner")
 	}
+	log.Printf("- Mode: SaaS SDK Scanner")
+	log.Printf("- Region: %s", region)
+	log.Printf("- Custom Tags: %v", customTags)
 
-	startHTTPServer(client, customTags, endpoint)
+	client, err := amaasclient.NewClient(apiKey, region)
+	if err != nil {
+		return nil, "", fmt.Errorf("SaaS SDK scanner: %v", err)
+	}
+	return client, region, nil
 }
 
 // startHTTPServer starts the HTTP server with the given client
@@ -117,13 +285,39 @@ func startHTTPServer(client *amaasclient.AmaasClient, customTags []string, endpo
 	// Note: Digest is disabled by default. We enable it for security auditing.
 	// Only disable if using AmaasReader with remote files to reduce network traffic.
 
+	// Repeated requests with the same Idempotency-Key replay the stored response
+	idempotency := NewIdempotencyStore()
+	go idempotency.sweep(idempotencySweepInterval)
+
+	// Uploads with identical content reuse a recent verdict when SCAN_CACHE is set
+	scanCache := newScanCacheFromEnv()
+
+	// While the circuit breaker is open, a test scan checks for recovery.
+	// Errors other than outages mean the backend is answering again.
+	scanBreaker.SetProbe(func() error {
+		if scannerPool != nil {
+			return scannerPool.probe()
+		}
+		primary, release := primaryClient(client)
+		defer release()
+		_, err := primary.ScanBuffer([]byte("finguard-preflight"), "finguard-breaker-probe", []string{"app=finguard", "preflight=true"})
+		if err != nil && isTransientScanError(err) {
+			return err
+		}
+		return nil
+	})
+
 	// Handle scan requests
-	http.HandleFunc("/scan", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/scan", idempotency.Wrap(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
+		// Count scanner attempts so retries show up in the response
+		ctx, attempts := withScanAttempts(r.Context())
+		r = r.WithContext(ctx)
+
 		// Get headers
 		filename := r.Header.Get("X-Filename")
 		if filename == "" {
@@ -137,149 +331,216 @@ func startHTTPServer(client *amaasclient.AmaasClient, customTags []string, endpo
 
 		filePath := r.Header.Get("X-File-Path")
 
-		// Get digest configuration
-		digestEnabled := r.Header.Get("X-Digest-Enabled")
-		if digestEnabled == "false" {
-			client.SetDigestDisable()
-			log.Printf("Digest calculation disabled for this scan")
-		} else {
-			// Digest is enabled by default, no action needed
-			log.Printf("Digest calculation enabled for this scan")
+		// Optional URL to POST the final verdict to
+		callbackURL := r.Header.Get("X-Callback-Url")
+		if callbackURL == "" {
+			callbackURL = r.URL.Query().Get("callbackUrl")
+		}
+		if err := validateCallbackURL(callbackURL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "callbackUrl")
+			return
 		}
 
-		// Get PML configuration
-		pmlEnabled := r.Header.Get("X-PML-Enabled")
-		if pmlEnabled == "true" {
-			client.SetPMLEnable()
-			log.Printf("PML (Predictive Machine Learning) detection enabled")
+		// Scanner options are applied to a per-request copy of the client so
+		// concurrent requests do not leak settings into each other; failover
+		// backends read them from the request context
+		opts := scanOptionsFromHeaders(r)
+		multipartUpload := isMultipartUpload(r)
+		if !multipartUpload {
+			// Multipart uploads apply the scan policy to each file
+			ctx, policyOpts := applyScanPolicy(r.Context(), uploadScanTarget(r, filename, filePath, scanMethod), opts)
+			r, opts = r.WithContext(ctx), policyOpts
 		}
+		scanClient := clientWithOptions(client, opts)
+		r = r.WithContext(withScanOptions(r.Context(), opts))
+		log.Printf("Scan options: digest=%v pml=%v spn_feedback=%v verbose=%v active_content=%v",
+			!opts.DisableDigest, opts.PML, opts.Feedback, opts.Verbose, opts.ActiveContent)
 
-		// Get SPN Feedback configuration
+		pmlEnabled := r.Header.Get("X-PML-Enabled")
 		spnFeedbackEnabled := r.Header.Get("X-SPN-Feedback-Enabled")
-		if spnFeedbackEnabled == "true" {
-			client.SetFeedbackEnable()
-			log.Printf("SPN feedback enabled")
-		}
+		activeContentEnabled := r.Header.Get("X-Active-Content-Enabled")
 
-		// Get Verbose configuration
-		verboseEnabled := r.Header.Get("X-Verbose-Enabled")
-		if verboseEnabled == "true" {
-			client.SetVerboseEnable()
-			log.Printf("Verbose scan result enabled")
+		// multipart/form-data carries several files, each scanned and reported separately
+		if multipartUpload {
+			serveMultipartScan(w, r, client, scanCache, opts, func(filename string) []string {
+				return buildScanTags(sourceUpload, customTags,
+					"file_type="+filepath.Ext(filename),
+					"scan_method=multipart",
+					"ml_enabled="+pmlEnabled,
+					"spn_feedback="+spnFeedbackEnabled,
+					"active_content="+activeContentEnabled,
+				)
+			}, callbackURL)
+			return
 		}
 
-		// Get Active Content Detection configuration
-		activeContentEnabled := r.Header.Get("X-Active-Content-Enabled")
-		if activeContentEnabled == "true" {
-			client.SetActiveContentEnable()
-			log.Printf("Active content detection enabled (PDF scripts, Office macros)")
+		// Tag the options a scan policy rule forced as applied
+		if rule := scanPolicyFrom(r.Context()); rule != nil {
+			pmlEnabled = strconv.FormatBool(opts.PML)
+			spnFeedbackEnabled = strconv.FormatBool(opts.Feedback)
+			activeContentEnabled = strconv.FormatBool(opts.ActiveContent)
 		}
 
 		// Generate unique identifier
 		identifier := time.Now().Format("20060102150405") + "-" + filepath.Base(filename)
 
-		// Initial tags with key=value format
-		tags := append([]string{
-			"app=finguard",                           // Application tag
-			"file_type=" + filepath.Ext(filename),    // File extension tag
-			"scan_method=" + scanMethod,              // Scan method tag
-			"ml_enabled=" + pmlEnabled,               // PML detection status
-			"spn_feedback=" + spnFeedbackEnabled,     // SPN feedback status
-			"active_content=" + activeContentEnabled, // Active content detection status
-		}, customTags...)
+		// Base tags (app, source, custom tags) plus upload-specific tags
+		tags := buildScanTags(sourceUpload, customTags,
+			"file_type="+filepath.Ext(filename),    // File extension tag
+			"scan_method="+scanMethod,              // Scan method tag
+			"ml_enabled="+pmlEnabled,               // PML detection status
+			"spn_feedback="+spnFeedbackEnabled,     // SPN feedback status
+			"active_content="+activeContentEnabled, // Active content detection status
+		)
 
 		var scanResult string
 		var err error
+		var cached bool
+		var scanSize int64
+		scanStart := time.Now()
 
 		// Choose scan method based on header
 		if scanMethod == "file" && filePath != "" {
 			// Scan using file method
 			log.Printf("Starting file scan for: %s with tags: %v", filePath, tags)
 			log.Printf("SDK Call: client.ScanFile(filePath=%s, tags=%v)", filePath, tags)
-			scanResult, err = client.ScanFile(filePath, tags)
+			var size int64
+			if info, statErr := os.Stat(filePath); statErr == nil {
+				size = info.Size()
+			}
+			scanSize = size
+			useReader := fileScanMode(r) == fileScanModeReader
+			cacheKey, sum := "", ""
+			// Hashing reads the whole file, which reader mode with digest disabled avoids
+			if (scanCache != nil || hashAllowlist.Skips()) && !(useReader && opts.DisableDigest) {
+				if fileSum, hashErr := sha256File(filePath); hashErr == nil {
+					sum = fileSum
+				}
+			}
+			if scanCache != nil && sum != "" {
+				cacheKey = scanCacheKey(sum, opts)
+			}
+			scanResult, cached, err = scanUnlessListed(r.Context(), scanCache, sum, filename, cacheKey, func() (string, error) {
+				return observeScan(r.Context(), "file", size, func(ctx context.Context) (string, error) {
+					if !useReader {
+						return scanFile(ctx, scanClient, filePath, tags)
+					}
+					reader, openErr := NewFileReader(filePath, filePath)
+					if openErr != nil {
+						return "", openErr
+					}
+					defer reader.Close()
+					log.Printf("Scanning %s with ScanReader (chunks read on demand)", filePath)
+					return scanReader(ctx, scanClient, reader, tags)
+				})
+			})
 			if err == nil {
 				log.Printf("SDK Response: client.ScanFile() completed successfully")
 			}
 		} else {
 			// Scan using buffer method (default)
-			// Read file data
-			data, readErr := io.ReadAll(r.Body)
-			if readErr != nil {
-				log.Printf("Error reading request body: %v", readErr)
-				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			// Read file data, bounded by MAX_UPLOAD_SIZE_MB
+			maxSize := getMaxUploadSize()
+			if r.ContentLength > maxSize {
+				writeUploadTooLarge(w, maxSize)
 				return
 			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+			if shouldSpool(r) {
+				// Large or chunked uploads are spooled to SCAN_SPOOL_DIR and
+				// scanned with ScanReader so memory use stays bounded
+				reader, sum, spoolErr := spoolUpload(r.Body, identifier)
+				if spoolErr != nil {
+					var tooLarge *http.MaxBytesError
+					if errors.As(spoolErr, &tooLarge) {
+						writeUploadTooLarge(w, maxSize)
+						return
+					}
+					log.Printf("Error spooling request body: %v", spoolErr)
+					http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+					return
+				}
+				defer reader.Close()
 
-			log.Printf("Starting buffer scan for file: %s with tags: %v", identifier, tags)
-			log.Printf("SDK Call: client.ScanBuffer(data=[]byte[%d bytes], identifier=%s, tags=%v)", len(data), identifier, tags)
-			scanResult, err = client.ScanBuffer(data, identifier, tags)
-			if err == nil {
-				log.Printf("SDK Response: client.ScanBuffer() completed successfully")
+				log.Printf("Starting spooled scan for file: %s (%d bytes) with tags: %v", identifier, reader.size, tags)
+				scanSize = reader.size
+				cacheKey := ""
+				if scanCache != nil {
+					cacheKey = scanCacheKey(sum, opts)
+				}
+				scanResult, cached, err = scanUnlessListed(r.Context(), scanCache, sum, filename, cacheKey, func() (string, error) {
+					return observeScan(r.Context(), "spool", reader.size, func(ctx context.Context) (string, error) {
+						return scanReader(ctx, scanClient, reader, tags)
+					})
+				})
+			} else {
+				data, readErr := io.ReadAll(r.Body)
+				if readErr != nil {
+					var tooLarge *http.MaxBytesError
+					if errors.As(readErr, &tooLarge) {
+						writeUploadTooLarge(w, maxSize)
+						return
+					}
+					log.Printf("Error reading request body: %v", readErr)
+					http.Error(w, "Failed to read request body", http.StatusBadRequest)
+					return
+				}
+
+				log.Printf("Starting buffer scan for file: %s with tags: %v", identifier, tags)
+				scanSize = int64(len(data))
+				log.Printf("SDK Call: client.ScanBuffer(data=[]byte[%d bytes], identifier=%s, tags=%v)", len(data), identifier, tags)
+				sum := sha256Hex(data)
+				cacheKey := ""
+				if scanCache != nil {
+					cacheKey = scanCacheKey(sum, opts)
+				}
+				scanResult, cached, err = scanUnlessListed(r.Context(), scanCache, sum, filename, cacheKey, func() (string, error) {
+					return observeScan(r.Context(), "buffer", int64(len(data)), func(ctx context.Context) (string, error) {
+						return scanBuffer(ctx, scanClient, data, identifier, tags)
+					})
+				})
+				if err == nil {
+					log.Printf("SDK Response: client.ScanBuffer() completed successfully")
+				}
 			}
 		}
 
+		if writeScanRejected(w, err) || writeScanTimeout(w, ctx, err) {
+			return
+		}
+		if cached {
+			log.Printf("Scan result for %s served from cache", identifier)
+		}
 		if err != nil {
-			log.Printf("Scan error for %s: %v", identifier, err)
+			loggerFrom(r.Context()).Error("scan failed", "scan_id", identifier, "source", sourceUpload, "scan_method", scanMethod, "error", err)
 			http.Error(w, "Scanning failed", http.StatusInternalServerError)
 			return
 		}
 
-		// Parse scan result to extract malware names, file hashes, and determine if file is safe
-		isSafe := true // Default to safe unless malware is found
-		var scanData map[string]interface{}
-		if err := json.Unmarshal([]byte(scanResult), &scanData); err == nil {
-			// Extract file hashes for logging
-			if fileSha1, ok := scanData["fileSha1"].(string); ok && fileSha1 != "" {
-				log.Printf("File SHA1: %s", fileSha1)
-			}
-			if fileSha256, ok := scanData["fileSha256"].(string); ok && fileSha256 != "" {
-				log.Printf("File SHA256: %s", fileSha256)
-			}
-
-			// Check if malware was found by examining the result.atse.malwareCount field
-			if result, ok := scanData["result"].(map[string]interface{}); ok {
-				if atse, ok := result["atse"].(map[string]interface{}); ok {
-					if malwareCount, ok := atse["malwareCount"].(float64); ok && malwareCount > 0 {
-						isSafe = false
-						log.Printf("Malware detected! Malware count: %.0f", malwareCount)
-					}
-
-					// Extract malware names from the malware array
-					if malwares, ok := atse["malware"].([]interface{}); ok {
-						for _, malware := range malwares {
-							if malwareMap, ok := malware.(map[string]interface{}); ok {
-								if malwareName, ok := malwareMap["name"].(string); ok {
-									tags = append(tags, "malware_name="+malwareName)
-									log.Printf("Malware name: %s", malwareName)
-								}
-							}
-						}
-					}
-				}
-			}
-
-			// Also check foundMalwares for backward compatibility
-			if foundMalwares, ok := scanData["foundMalwares"].([]interface{}); ok && len(foundMalwares) > 0 {
-				isSafe = false
-				for _, malware := range foundMalwares {
-					if malwareMap, ok := malware.(map[string]interface{}); ok {
-						if malwareName, ok := malwareMap["malwareName"].(string); ok {
-							tags = append(tags, "malware_name="+malwareName)
-							log.Printf("Malware name (from foundMalwares): %s", malwareName)
-						}
-					}
-				}
-			}
+		// Parse scan result to extract malware names, file hashes, and
+		// determine if file is safe. Files on the hash blocklist are
+		// malicious, files on the hash allowlist clean with any detections
+		// suppressed, and files a scan policy rule or the size limit skipped
+		// are reported as skipped.
+		verdict, parseErr := parseScanVerdict(scanResult)
+		if parseErr != nil {
+			log.Printf("Warning: %v", parseErr)
+		}
+		verdict.ScanID = identifier
+		if verdict.FileSHA256 != "" {
+			log.Printf("File SHA256: %s", verdict.FileSHA256)
 		}
+		for _, malwareName := range verdict.MalwareNames {
+			tags = append(tags, "malware_name="+malwareName)
+			log.Printf("Malware name: %s", malwareName)
+		}
+		if verdict.Allowlisted || verdict.Blocklisted || verdict.Skipped || verdict.Truncated {
+			log.Printf("File %s is on the hash allowlist or blocklist, skipped or truncated", identifier)
+		}
+		normalized := verdict.Normalize(time.Since(scanStart), wantsRawResult(r))
 
 		// Prepare response based on scan result
-		response := ScanResponse{
-			IsSafe:     isSafe,
-			Message:    scanResult,
-			ScanID:     identifier,
-			Tags:       tags,
-			Detections: scanResult,
-		}
+		response := scanResponseFor(r, verdict, scanResult, tags, cached, int(attempts.Load()), normalized)
 
 		// Send response
 		w.Header().Set("Content-Type", "application/json")
@@ -289,8 +550,25 @@ func startHTTPServer(client *amaasclient.AmaasClient, customTags []string, endpo
 			return
 		}
 
-		log.Printf("Scan completed for %s: %s with tags: %v", identifier, scanResult, tags)
-	})
+		reportScanOutcome(r.Context(), ScanOutcome{
+			Source:     sourceUpload,
+			Identifier: identifier,
+			Tags:       tags,
+			Verdict:    verdict,
+			Duration:   time.Since(scanStart),
+			Size:       scanSize,
+		})
+		sendCallback(callbackURL, eventScanCompleted, response)
+	}))
+
+	// Build information
+	http.HandleFunc("/version", handleVersion)
+
+	// OpenAPI 3 description of the JSON API
+	http.HandleFunc("/openapi.json", handleOpenAPI)
+
+	// Prometheus metrics
+	http.Handle("/metrics", promhttp.Handler())
 
 	// Health check endpoint
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -317,14 +595,185 @@ func startHTTPServer(client *amaasclient.AmaasClient, customTags []string, endpo
 		json.NewEncoder(w).Encode(response)
 	})
 
+	// Kubernetes liveness and readiness probes
+	http.HandleFunc("/healthz", handleLiveness)
+	http.HandleFunc("/readyz", handleReadiness(client, os.Getenv("SCANNER_EXTERNAL_ADDR")))
+
+	// Dependency check with a real scan and per-dependency latency
+	http.HandleFunc("/health/deep", handleDeepHealth(client, endpoint, scanCache))
+
 	// S3 object storage endpoints
-	http.HandleFunc("/s3/buckets", handleListBuckets(client))
-	http.HandleFunc("/s3/objects", handleListObjects(client))
-	http.HandleFunc("/s3/scan", handleScanS3Object(client))
+	http.HandleFunc("/s3/buckets", validateS3Request()(handleListBuckets(client)))
+	http.HandleFunc("/s3/objects", validateS3Request("bucket")(handleListObjects(client)))
+	http.HandleFunc("/s3/scan", idempotency.Wrap(validateS3Request("bucket", "key")(handleScanS3Object(client))))
+	http.HandleFunc("/s3/quarantine", validateS3Request("bucket", "key")(handleQuarantineS3Object))
+	http.HandleFunc(quarantineLinkPath, handleQuarantineLink)
+
+	// Asynchronous bulk scans and job status
+	jobs := newJobManagerFromEnv()
+	jobs.RegisterRunner(jobTypeBucketScan, bucketScanRunner(client))
+	jobs.RegisterRunner(jobTypeDirectoryScan, directoryScanRunner(client))
+	jobs.RegisterRunner(jobTypeGraphDeltaScan, graphDeltaScanRunner(client, jobs))
+	jobs.RegisterRunner(jobTypeDropboxFolderScan, dropboxFolderScanRunner(client))
+	jobs.Start(context.Background())
+	http.HandleFunc("/s3/scan-bucket", idempotency.Wrap(validateS3Request("bucket")(handleScanBucket(client, jobs))))
+	http.HandleFunc("/jobs/", handleJobs(jobs))
+
+	// Recurring jobs from SCHEDULES_FILE and POST /schedules
+	scheduler := newSchedulerFromEnv(jobs)
+	scheduler.Start()
+	http.HandleFunc("/schedules", handleSchedules(scheduler))
+	http.HandleFunc("/schedules/", handleSchedules(scheduler))
+
+	// Azure Blob Storage endpoints
+	http.HandleFunc("/azure/containers", handleListAzureContainers(client))
+	http.HandleFunc("/azure/blobs", handleListAzureBlobs(client))
+	http.HandleFunc("/azure/scan", idempotency.Wrap(handleScanAzureBlob(client)))
+
+	// Google Cloud Storage endpoints
+	http.HandleFunc("/gcs/buckets", handleListGCSBuckets(client))
+	http.HandleFunc("/gcs/objects", handleListGCSObjects(client))
+	http.HandleFunc("/gcs/scan", idempotency.Wrap(handleScanGCSObject(client)))
+
+	// Google Drive endpoints
+	http.HandleFunc("/gdrive/files", handleListDriveFiles(client))
+	http.HandleFunc("/gdrive/scan", idempotency.Wrap(handleScanDriveFile(client)))
+
+	// OneDrive and SharePoint endpoints (Microsoft Graph)
+	http.HandleFunc("/graph/files", handleListGraphItems(client))
+	http.HandleFunc("/graph/scan", idempotency.Wrap(handleScanGraphItem(client)))
+	http.HandleFunc("/graph/scan-delta", idempotency.Wrap(handleScanGraphDelta(client, jobs)))
+
+	// Dropbox endpoints
+	http.HandleFunc("/dropbox/scan", idempotency.Wrap(handleScanDropboxFile(client)))
+	http.HandleFunc("/dropbox/scan-folder", idempotency.Wrap(handleScanDropboxFolder(client, jobs)))
+
+	// Generic scan endpoint dispatching s3:// and other registered URI schemes
+	http.HandleFunc("/scan/uri", idempotency.Wrap(handleScanURI(client)))
+
+	// Presigned or public URL scanning with ranged reads
+	http.HandleFunc("/scan/url", idempotency.Wrap(handleScanURL(client)))
+
+	// Chunked uploads over a WebSocket with progress messages
+	http.Handle(wsScanPath, handleScanWebSocket(client, scanCache))
+
+	// Download-and-scan for allowlisted HTTPS hosts
+	http.HandleFunc("/scan/remote", idempotency.Wrap(handleScanRemote(client)))
+
+	// Scan-on-upload from S3 event notifications delivered to SQS
+	if queueURL := os.Getenv("SQS_QUEUE_URL"); queueURL != "" {
+		if err := startSQSWorker(context.Background(), client, queueURL); err != nil {
+			log.Fatalf("Failed to start SQS worker: %v", err)
+		}
+	}
+
+	// Scan-on-write for directories listed in WATCH_CONFIG_FILE
+	if watchConfig := os.Getenv("WATCH_CONFIG_FILE"); watchConfig != "" {
+		if err := startFileWatcher(context.Background(), client, watchConfig); err != nil {
+			log.Fatalf("Failed to start file watcher: %v", err)
+		}
+	}
+
+	// clamd protocol listener for existing ClamAV clients
+	if clamdAddr := os.Getenv("CLAMD_LISTEN_ADDR"); clamdAddr != "" {
+		if err := startClamdListener(context.Background(), client, clamdAddr); err != nil {
+			log.Fatalf("Failed to start clamd listener: %v", err)
+		}
+	}
+
+	// Milter listener for Postfix and Sendmail attachment scanning
+	if milterAddr := os.Getenv("MILTER_LISTEN_ADDR"); milterAddr != "" {
+		if err := startMilterListener(context.Background(), client, milterAddr); err != nil {
+			log.Fatalf("Failed to start milter listener: %v", err)
+		}
+	}
+
+	// Kafka consumer for asynchronous scan requests
+	if kafkaBrokers := os.Getenv("KAFKA_BROKERS"); kafkaBrokers != "" {
+		if err := startKafkaConsumer(context.Background(), client, kafkaBrokers); err != nil {
+			log.Fatalf("Failed to start Kafka consumer: %v", err)
+		}
+	}
+
+	// NATS request-reply and JetStream scanning
+	if natsURL := os.Getenv("NATS_URL"); natsURL != "" {
+		if err := startNATSScanner(context.Background(), client, natsURL); err != nil {
+			log.Fatalf("Failed to start NATS scanner: %v", err)
+		}
+	}
+
+	// RabbitMQ work-queue consumer
+	if rabbitURL := os.Getenv("RABBITMQ_URL"); rabbitURL != "" {
+		if err := startRabbitMQConsumer(context.Background(), client, rabbitURL); err != nil {
+			log.Fatalf("Failed to start RabbitMQ consumer: %v", err)
+		}
+	}
+
+	// API key / JWT authentication for everything except health probes
+	apiKeys, err := loadAPIKeys()
+	if err != nil {
+		log.Fatalf("Failed to load API keys: %v", err)
+	}
+	// Per-client rate limiting runs after authentication so it can key on the caller
+	rateLimiter := newRateLimiterFromEnv()
+	// The tenant is resolved from the caller, so it also runs after authentication
+	jwtValidator := newJWTValidatorFromEnv()
+	var inner http.Handler = requireAuth(apiKeys, jwtValidator, tenantRegistry.Wrap(rateLimiter.Wrap(scanTimeoutFromHeader(http.DefaultServeMux))))
+
+	// Reload tags, policies, rate limits and notifiers on SIGHUP or POST /admin/reload
+	registerReloadHooks(rateLimiter)
+	reloadOnSIGHUP()
+	http.HandleFunc("/admin/reload", handleReload)
+	http.HandleFunc("/scans/export", handleScanExport)
+	http.HandleFunc("/reports", handleReports)
+	http.HandleFunc("/reports/", handleReports)
+	http.HandleFunc("/stats/buckets", handleBucketStats(jobs))
+	http.HandleFunc("/compliance/report", handleComplianceReport)
+	http.HandleFunc("/compliance/public-key", handleCompliancePublicKey)
+	http.HandleFunc("/allowlist", handleAllowlist)
+	http.HandleFunc("/allowlist/", handleAllowlist)
+	http.HandleFunc("/blocklist", handleBlocklist)
+	http.HandleFunc("/blocklist/", handleBlocklist)
+
+	// Optional TLS, with client certificate verification when a client CA is set
+	server := &http.Server{Addr: getEnv("SCANNER_LISTEN_ADDR", ":3001")}
+	tlsSettings := tlsSettingsFromEnv()
+	if tlsSettings.Enabled() {
+		tlsConfig, err := tlsSettings.buildTLSConfig()
+		if err != nil {
+			log.Fatalf("Invalid TLS configuration: %v", err)
+		}
+		server.TLSConfig = tlsConfig
+		if tlsSettings.ClientCA != "" {
+			log.Printf("- mTLS: client certificates required (CA %s)", tlsSettings.ClientCA)
+			inner = requireClientCert(inner)
+		}
+	}
+	server.Handler = traceHandler(withRequestID(inner))
+
+	// gRPC API, admitted through the same authentication, tenant and rate limit checks
+	if grpcAddr := os.Getenv("GRPC_LISTEN_ADDR"); grpcAddr != "" {
+		gate := func(next http.Handler) http.Handler {
+			gated := requireAuth(apiKeys, jwtValidator, tenantRegistry.Wrap(rateLimiter.Wrap(next)))
+			if server.TLSConfig != nil && tlsSettings.ClientCA != "" {
+				gated = requireClientCert(gated)
+			}
+			return gated
+		}
+		if err := startGRPCServer(client, scanCache, jobs, gate, server.TLSConfig, grpcAddr); err != nil {
+			log.Fatalf("Failed to start gRPC server: %v", err)
+		}
+	}
 
 	// Start the server
-	log.Printf("Scanner service starting on :3001")
-	if err := http.ListenAndServe(":3001", nil); err != nil {
+	if server.TLSConfig != nil {
+		log.Printf("Scanner service starting on %s (TLS)", server.Addr)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("Scanner service starting on %s", server.Addr)
+		err = server.ListenAndServe()
+	}
+	if err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
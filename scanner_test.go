@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// responseFields encodes response and returns its JSON object
+func responseFields(t *testing.T, response interface{}) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	return fields
+}
+
+func TestMinimalScanResponse(t *testing.T) {
+	scanResult := `{"scanResult":1,"foundMalwares":[{"fileName":"eicar.com","malwareName":"Eicar_test_file"}],"fileSHA256":"275a021bbfb6489e54d471899f7db9d1663fc695ec2fe2a2c4538aabf651fd0f"}`
+	verdict, err := parseScanVerdict(scanResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verdict.ScanID = "scan-1"
+	normalized := verdict.Normalize(time.Second, false)
+	tags := []string{"app=finguard", "malware_name=Eicar_test_file"}
+
+	request := func(header, query string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/scan"+query, nil)
+		if header != "" {
+			r.Header.Set("X-Response-Mode", header)
+		}
+		return r
+	}
+	full := responseFields(t, scanResponseFor(request("", ""), verdict, scanResult, tags, false, 1, normalized))
+
+	tests := []struct {
+		name    string
+		r       *http.Request
+		minimal bool
+	}{
+		{"default", request("", ""), false},
+		{"header", request("minimal", ""), true},
+		{"header case-insensitive", request("MINIMAL", ""), true},
+		{"query parameter", request("", "?responseMode=minimal"), true},
+		{"other mode", request("full", ""), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := responseFields(t, scanResponseFor(tt.r, verdict, scanResult, tags, false, 1, normalized))
+			for _, field := range []string{"isSafe", "verdict", "malwareNames", "scanId"} {
+				if !reflect.DeepEqual(got[field], full[field]) {
+					t.Errorf("%s = %v, want %v as in the full response", field, got[field], full[field])
+				}
+			}
+			for _, field := range []string{"message", "detections", "tags", "result"} {
+				if _, ok := got[field]; ok == tt.minimal {
+					t.Errorf("%s present = %v, want %v", field, ok, !tt.minimal)
+				}
+			}
+			if tt.minimal {
+				for field := range got {
+					if _, ok := full[field]; !ok {
+						t.Errorf("minimal response has %s, which the full response lacks", field)
+					}
+				}
+			} else if keys(got) != keys(full) {
+				t.Errorf("fields %s, want %s", keys(got), keys(full))
+			}
+		})
+	}
+}
+
+// keys lists the sorted keys of fields
+func keys(fields map[string]interface{}) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	data, _ := json.Marshal(names)
+	return string(data)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// ScanOptions are the scanner features a single request can turn on
+type ScanOptions struct {
+	PML           bool
+	Feedback      bool
+	Verbose       bool
+	ActiveContent bool
+	DisableDigest bool
+}
+
+// scanOptionsFromHeaders reads the X-*-Enabled headers sent by the Node.js app
+func scanOptionsFromHeaders(r *http.Request) ScanOptions {
+	return ScanOptions{
+		PML:           r.Header.Get("X-PML-Enabled") == "true",
+		Feedback:      r.Header.Get("X-SPN-Feedback-Enabled") == "true",
+		Verbose:       r.Header.Get("X-Verbose-Enabled") == "true",
+		ActiveContent: r.Header.Get("X-Active-Content-Enabled") == "true",
+		DisableDigest: r.Header.Get("X-Digest-Enabled") == "false",
+	}
+}
+
+// clientWithOptions returns a copy of base with opts applied. The SDK setters
+// mutate the client they are called on, so each request configures its own
+// copy instead of the shared client. The copy shares base's gRPC connection;
+// it is cheap to create and must never be Destroy()ed.
+func clientWithOptions(base *amaasclient.AmaasClient, opts ScanOptions) *amaasclient.AmaasClient {
+	client := *base
+	if opts.PML {
+		client.SetPMLEnable()
+	}
+	if opts.Feedback {
+		client.SetFeedbackEnable()
+	}
+	if opts.Verbose {
+		client.SetVerboseEnable()
+	}
+	if opts.ActiveContent {
+		client.SetActiveContentEnable()
+	}
+	if opts.DisableDigest {
+		client.SetDigestDisable()
+	}
+	return &client
+}
+
+// File scan modes for X-Scan-Method: file
+const (
+	fileScanModeFile   = "file"   // SDK ScanFile
+	fileScanModeReader = "reader" // ScanReader over the file, reading only requested chunks
+)
+
+// fileScanMode returns the X-File-Scan-Mode header, defaulting to FILE_SCAN_MODE
+func fileScanMode(r *http.Request) string {
+	mode := r.Header.Get("X-File-Scan-Mode")
+	if mode == "" {
+		mode = getEnv("FILE_SCAN_MODE", fileScanModeFile)
+	}
+	if mode != fileScanModeReader {
+		return fileScanModeFile
+	}
+	return mode
+}
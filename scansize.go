@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// What to do with files larger than the maximum scan size
+const (
+	oversizeReject   = "reject"
+	oversizeSkip     = "skip"
+	oversizeTruncate = "truncate"
+)
+
+// verdictSkippedTooLarge is recorded for files over the maximum scan size
+// that were not scanned
+const verdictSkippedTooLarge = "skipped-too-large"
+
+// ScanSizeLimit is the maximum scan size for a source and what happens to
+// larger files. Zero bytes means no limit.
+type ScanSizeLimit struct {
+	Bytes  int64
+	Action string
+}
+
+// ScanTooLargeError is returned by observeScan for files over the maximum
+// scan size when the action is reject
+type ScanTooLargeError struct {
+	Size  int64
+	Limit int64
+}
+
+func (e *ScanTooLargeError) Error() string {
+	return fmt.Sprintf("file of %d bytes exceeds the maximum scan size of %d bytes", e.Size, e.Limit)
+}
+
+var (
+	scanSizeOnce      sync.Once
+	scanSizeDefault   ScanSizeLimit
+	scanSizeOverrides map[string]ScanSizeLimit
+)
+
+// loadScanSizeLimits reads MAX_SCAN_SIZE_MB and MAX_SCAN_SIZE_ACTION, and
+// MAX_SCAN_SIZE_OVERRIDES, a comma-separated list of source=MB[:action]
+// such as "s3=2048:skip,upload=512"
+func loadScanSizeLimits() {
+	scanSizeDefault = ScanSizeLimit{
+		Bytes:  int64(getEnvInt("MAX_SCAN_SIZE_MB", 0)) << 20,
+		Action: strings.ToLower(getEnv("MAX_SCAN_SIZE_ACTION", oversizeReject)),
+	}
+	if !validOversizeAction(scanSizeDefault.Action) {
+		log.Printf("Warning: invalid MAX_SCAN_SIZE_ACTION %q, using %s", scanSizeDefault.Action, oversizeReject)
+		scanSizeDefault.Action = oversizeReject
+	}
+
+	scanSizeOverrides = map[string]ScanSizeLimit{}
+	for _, entry := range envList("MAX_SCAN_SIZE_OVERRIDES") {
+		source, value, ok := strings.Cut(entry, "=")
+		mb, action, _ := strings.Cut(value, ":")
+		limit := ScanSizeLimit{Action: scanSizeDefault.Action}
+		if action != "" {
+			limit.Action = strings.ToLower(action)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(mb))
+		if !ok || err != nil || n < 0 || !validOversizeAction(limit.Action) {
+			log.Printf("Warning: skipping invalid MAX_SCAN_SIZE_OVERRIDES entry %q", entry)
+			continue
+		}
+		limit.Bytes = int64(n) << 20
+		scanSizeOverrides[strings.TrimSpace(source)] = limit
+	}
+}
+
+// validOversizeAction reports whether action is reject, skip or truncate
+func validOversizeAction(action string) bool {
+	return action == oversizeReject || action == oversizeSkip || action == oversizeTruncate
+}
+
+// scanSizeLimitFor returns the limit for a metrics source. The upload scan
+// methods share the upload override.
+func scanSizeLimitFor(source string) ScanSizeLimit {
+	scanSizeOnce.Do(loadScanSizeLimits)
+	switch source {
+	case "file", "spool", "buffer":
+		source = sourceUpload
+	}
+	if limit, ok := scanSizeOverrides[source]; ok {
+		return limit
+	}
+	return scanSizeDefault
+}
+
+// exceeded reports whether a file of size is over the limit
+func (l ScanSizeLimit) exceeded(size int64) bool {
+	return l.Bytes > 0 && size > l.Bytes
+}
+
+// tooLargeResult returns a clean SDK-style result marked skipped for a file
+// over the maximum scan size
+func tooLargeResult(size int64, limit ScanSizeLimit) string {
+	result, _ := json.Marshal(map[string]interface{}{
+		"scanResult":    0,
+		"foundMalwares": []interface{}{},
+		"skipped":       true,
+		"tooLarge":      true,
+		"fileSize":      size,
+		"maxScanSize":   limit.Bytes,
+	})
+	return string(result)
+}
+
+// markTruncated records in result that only the first limit bytes of a
+// file of size were scanned
+func markTruncated(result string, size, limit int64) string {
+	return annotateResult(result, map[string]interface{}{"truncated": true, "fileSize": size, "scannedBytes": limit})
+}
+
+type scanTruncateKey struct{}
+
+// withScanTruncation makes scanReader, scanBuffer and scanFile scan only the
+// first limit bytes
+func withScanTruncation(ctx context.Context, limit int64) context.Context {
+	return context.WithValue(ctx, scanTruncateKey{}, limit)
+}
+
+// scanTruncationFrom returns the limit set by withScanTruncation, or zero
+func scanTruncationFrom(ctx context.Context) int64 {
+	limit, _ := ctx.Value(scanTruncateKey{}).(int64)
+	return limit
+}
+
+// truncatedReader exposes the first limit bytes of a reader
+type truncatedReader struct {
+	amaasclient.AmaasClientReader
+	limit int64
+}
+
+// bindContext passes the scan context on to the wrapped reader
+func (r *truncatedReader) bindContext(ctx context.Context) {
+	if binder, ok := r.AmaasClientReader.(contextBinder); ok {
+		binder.bindContext(ctx)
+	}
+}
+
+// DataSize returns the smaller of the reader's size and the limit
+func (r *truncatedReader) DataSize() (int64, error) {
+	size, err := r.AmaasClientReader.DataSize()
+	if err != nil || size <= r.limit {
+		return size, err
+	}
+	return r.limit, nil
+}
+
+// ReadBytes reads from the wrapped reader without going past the limit
+func (r *truncatedReader) ReadBytes(offset int64, length int32) ([]byte, error) {
+	if offset >= r.limit {
+		return []byte{}, nil
+	}
+	if remaining := r.limit - offset; int64(length) > remaining {
+		length = int32(remaining)
+	}
+	return r.AmaasClientReader.ReadBytes(offset, length)
+}
+
+// writeScanTooLarge sends 413 for files rejected by the maximum scan size
+func writeScanTooLarge(w http.ResponseWriter, err *ScanTooLargeError) {
+	log.Printf("Scan rejected: %v", err)
+	writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("File exceeds the maximum scan size of %d MB", err.Limit>>20), "")
+}
+
+// isSkippedVerdict reports whether verdict is recorded for files that were
+// not scanned
+func isSkippedVerdict(verdict string) bool {
+	return verdict == verdictSkipped || verdict == verdictSkippedTooLarge || verdict == verdictArchived
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Number of runs kept in each schedule's history
+const maxScheduleRuns = 20
+
+var schedulesBucket = []byte("schedules")
+
+var errScheduleNotFound = errors.New("schedule not found")
+
+// Schedule runs a job of JobType with Params on a cron spec ("0 2 * * *",
+// "@hourly", ...). Schedules from SCHEDULES_FILE have Source "file" and
+// cannot be changed through the API.
+type Schedule struct {
+	ID           string          `json:"id"`
+	Name         string          `json:"name"`
+	Cron         string          `json:"cron"`
+	JobType      string          `json:"jobType"`
+	Params       json.RawMessage `json:"params"`
+	CallbackURL  string          `json:"callbackUrl,omitempty"`
+	AllowOverlap bool            `json:"allowOverlap"`
+	Tenant       string          `json:"tenant,omitempty"`
+	Source       string          `json:"source,omitempty"`
+	CreatedAt    time.Time       `json:"createdAt"`
+	NextRun      *time.Time      `json:"nextRun,omitempty"`
+	Runs         []ScheduleRun   `json:"runs,omitempty"`
+}
+
+// ScheduleRun records one trigger of a schedule
+type ScheduleRun struct {
+	At      time.Time `json:"at"`
+	JobID   string    `json:"jobId,omitempty"`
+	Skipped bool      `json:"skipped,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// scheduleEntry is a registered schedule and its cron entry
+type scheduleEntry struct {
+	schedule Schedule
+	target   string
+	entryID  cron.EntryID
+}
+
+// Scheduler submits jobs to the job manager on cron schedules. API-created
+// schedules and run history are persisted in a BoltDB file.
+type Scheduler struct {
+	mu      sync.Mutex
+	cron    *cron.Cron
+	jobs    *JobManager
+	db      *bolt.DB
+	entries map[string]*scheduleEntry
+}
+
+// newSchedulerFromEnv creates the scheduler with SCHEDULE_STORE_PATH for
+// persistence and loads the schedules from SCHEDULES_FILE
+func newSchedulerFromEnv(jobs *JobManager) *Scheduler {
+	s := &Scheduler{
+		cron:    cron.New(),
+		jobs:    jobs,
+		entries: make(map[string]*scheduleEntry),
+	}
+
+	if path := getEnv("SCHEDULE_STORE_PATH", "/app/schedules.db"); path != "" {
+		db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+		if err == nil {
+			err = db.Update(func(tx *bolt.Tx) error {
+				_, err := tx.CreateBucketIfNotExists(schedulesBucket)
+				return err
+			})
+		}
+		if err != nil {
+			log.Printf("Warning: failed to open schedule store %s, schedules will not survive restarts: %v", path, err)
+		} else {
+			s.db = db
+			s.loadPersisted()
+		}
+	}
+
+	if path := os.Getenv("SCHEDULES_FILE"); path != "" {
+		s.loadFile(path)
+	}
+	return s
+}
+
+// loadPersisted registers the schedules saved by a previous run
+func (s *Scheduler) loadPersisted() {
+	var schedules []Schedule
+	s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(schedulesBucket).ForEach(func(_, data []byte) error {
+			var schedule Schedule
+			if err := json.Unmarshal(data, &schedule); err == nil {
+				schedules = append(schedules, schedule)
+			}
+			return nil
+		})
+	})
+	for _, schedule := range schedules {
+		if _, err := s.register(schedule); err != nil {
+			log.Printf("Warning: skipping persisted schedule %s: %v", schedule.ID, err)
+		}
+	}
+}
+
+// loadFile registers the schedules in a JSON array file
+func (s *Scheduler) loadFile(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Warning: failed to read SCHEDULES_FILE: %v", err)
+		return
+	}
+	var schedules []Schedule
+	if err := json.Unmarshal(data, &schedules); err != nil {
+		log.Printf("Warning: invalid SCHEDULES_FILE %s: %v", path, err)
+		return
+	}
+	for _, schedule := range schedules {
+		if schedule.ID == "" {
+			schedule.ID = schedule.Name
+		}
+		schedule.Source = "file"
+		if _, err := s.register(schedule); err != nil {
+			log.Printf("Warning: skipping schedule %q from %s: %v", schedule.ID, path, err)
+		}
+	}
+}
+
+// Start begins triggering schedules
+func (s *Scheduler) Start() {
+	s.cron.Start()
+	log.Printf("- Scheduler: %d schedule(s)", len(s.entries))
+}
+
+// scheduleTarget validates the job parameters and returns the job target
+func scheduleTarget(jobType string, params json.RawMessage) (string, error) {
+	switch jobType {
+	case jobTypeBucketScan:
+		var req BucketScanRequest
+		if err := json.Unmarshal(params, &req); err != nil {
+			return "", fmt.Errorf("invalid params: %v", err)
+		}
+		if req.Bucket == "" {
+			return "", fmt.Errorf("params.bucket is required")
+		}
+		if _, err := requestRemediation(req.Remediation, req.Quarantine); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("s3://%s/%s", req.Bucket, req.Prefix), nil
+	case jobTypeDirectoryScan:
+		var req DirectoryScanRequest
+		if err := json.Unmarshal(params, &req); err != nil {
+			return "", fmt.Errorf("invalid params: %v", err)
+		}
+		if err := req.validate(); err != nil {
+			return "", err
+		}
+		return req.Path, nil
+	case jobTypeGraphDeltaScan:
+		var req GraphDeltaScanRequest
+		if err := json.Unmarshal(params, &req); err != nil {
+			return "", fmt.Errorf("invalid params: %v", err)
+		}
+		return req.target()
+	case jobTypeDropboxFolderScan:
+		var req DropboxFolderScanRequest
+		if err := json.Unmarshal(params, &req); err != nil {
+			return "", fmt.Errorf("invalid params: %v", err)
+		}
+		return dropboxURI(req.folder()), nil
+	}
+	return "", fmt.Errorf("unsupported job type %q", jobType)
+}
+
+// register validates a schedule and adds it to cron
+func (s *Scheduler) register(schedule Schedule) (Schedule, error) {
+	if schedule.ID == "" {
+		return schedule, fmt.Errorf("schedule ID is required")
+	}
+	spec, err := cron.ParseStandard(schedule.Cron)
+	if err != nil {
+		return schedule, fmt.Errorf("invalid cron expression %q: %v", schedule.Cron, err)
+	}
+	target, err := scheduleTarget(schedule.JobType, schedule.Params)
+	if err != nil {
+		return schedule, err
+	}
+	if err := validateCallbackURL(schedule.CallbackURL); err != nil {
+		return schedule, err
+	}
+	if schedule.CreatedAt.IsZero() {
+		schedule.CreatedAt = time.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.entries[schedule.ID]; exists {
+		return schedule, fmt.Errorf("schedule %q already exists", schedule.ID)
+	}
+	id := schedule.ID
+	entry := &scheduleEntry{schedule: schedule, target: target}
+	entry.entryID = s.cron.Schedule(spec, cron.FuncJob(func() { s.trigger(id) }))
+	s.entries[id] = entry
+	return schedule, nil
+}
+
+// Add registers and persists a schedule created through the API
+func (s *Scheduler) Add(schedule Schedule) (Schedule, error) {
+	schedule.Source = ""
+	schedule.Runs = nil
+	schedule.CreatedAt = time.Now()
+	if schedule.ID == "" {
+		schedule.ID = newJobID()
+	}
+	if _, inline := withoutSecretParams(schedule.JobType, schedule.Params); inline {
+		// Schedules run long after the request, so their credentials must
+		// come from server-side profiles or the environment
+		return schedule, fmt.Errorf("schedules cannot hold inline credentials, use a credential profile or server credentials")
+	}
+	schedule, err := s.register(schedule)
+	if err != nil {
+		return schedule, err
+	}
+	s.persist(schedule)
+	return s.Get(schedule.ID)
+}
+
+// Delete removes an API-created schedule
+func (s *Scheduler) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return errScheduleNotFound
+	}
+	if entry.schedule.Source == "file" {
+		return fmt.Errorf("schedule %q is defined in SCHEDULES_FILE", id)
+	}
+	s.cron.Remove(entry.entryID)
+	delete(s.entries, id)
+	if s.db != nil {
+		s.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(schedulesBucket).Delete([]byte(id))
+		})
+	}
+	return nil
+}
+
+// Get returns a copy of the schedule with its next run time
+func (s *Scheduler) Get(id string) (Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return Schedule{}, errScheduleNotFound
+	}
+	return s.snapshot(entry), nil
+}
+
+// List returns every schedule ordered by ID
+func (s *Scheduler) List() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedules := make([]Schedule, 0, len(s.entries))
+	for _, entry := range s.entries {
+		schedules = append(schedules, s.snapshot(entry))
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].ID < schedules[j].ID })
+	return schedules
+}
+
+// snapshot copies an entry's schedule without inline credentials; callers
+// must hold s.mu
+func (s *Scheduler) snapshot(entry *scheduleEntry) Schedule {
+	schedule := entry.schedule
+	schedule.Params, _ = withoutSecretParams(schedule.JobType, schedule.Params)
+	schedule.Runs = append([]ScheduleRun(nil), entry.schedule.Runs...)
+	if next := s.cron.Entry(entry.entryID).Next; !next.IsZero() {
+		schedule.NextRun = &next
+	}
+	return schedule
+}
+
+// trigger submits the schedule's job unless its previous job is still active
+func (s *Scheduler) trigger(id string) {
+	s.mu.Lock()
+	entry, ok := s.entries[id]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	schedule := entry.schedule
+	s.mu.Unlock()
+
+	run := ScheduleRun{At: time.Now()}
+	if last := lastScheduleJob(schedule); last != "" && !schedule.AllowOverlap {
+		if job, ok := s.jobs.Get(last); ok {
+			if status := job.Snapshot(false).Status; status == JobQueued || status == JobRunning || status == JobPaused || status == JobCancelling {
+				run.Skipped = true
+				run.Error = fmt.Sprintf("previous job %s is still %s", last, status)
+			}
+		}
+	}
+	if !run.Skipped {
+		job, err := s.jobs.Submit(withTenant(context.Background(), tenantRegistry.get(schedule.Tenant)), schedule.JobType, entry.target, schedule.CallbackURL, schedule.Params)
+		if job != nil {
+			run.JobID = job.ID()
+		}
+		if err != nil {
+			run.Error = err.Error()
+		}
+	}
+	log.Printf("Schedule %s: triggered %s (job %q, skipped %v, error %q)", id, schedule.JobType, run.JobID, run.Skipped, run.Error)
+
+	s.mu.Lock()
+	entry.schedule.Runs = append(entry.schedule.Runs, run)
+	if len(entry.schedule.Runs) > maxScheduleRuns {
+		entry.schedule.Runs = entry.schedule.Runs[len(entry.schedule.Runs)-maxScheduleRuns:]
+	}
+	updated := entry.schedule
+	s.mu.Unlock()
+	if updated.Source != "file" {
+		s.persist(updated)
+	}
+}
+
+// lastScheduleJob returns the ID of the most recent job started by a schedule
+func lastScheduleJob(schedule Schedule) string {
+	for i := len(schedule.Runs) - 1; i >= 0; i-- {
+		if schedule.Runs[i].JobID != "" {
+			return schedule.Runs[i].JobID
+		}
+	}
+	return ""
+}
+
+// persist saves an API-created schedule and its history
+func (s *Scheduler) persist(schedule Schedule) {
+	if s.db == nil {
+		return
+	}
+	schedule.NextRun = nil
+	schedule.Params, _ = withoutSecretParams(schedule.JobType, schedule.Params)
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(schedulesBucket).Put([]byte(schedule.ID), data)
+	})
+	if err != nil {
+		log.Printf("Warning: failed to persist schedule %s: %v", schedule.ID, err)
+	}
+}
+
+// HTTP handler for /schedules (GET list, POST create) and /schedules/{id} (GET, DELETE)
+func handleSchedules(scheduler *Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/schedules"), "/")
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case id == "" && r.Method == http.MethodGet:
+			schedules := []Schedule{}
+			for _, schedule := range scheduler.List() {
+				if tenantOwns(r.Context(), schedule.Tenant) {
+					schedules = append(schedules, schedule)
+				}
+			}
+			json.NewEncoder(w).Encode(ScheduleListResponse{Schedules: schedules})
+		case id == "" && r.Method == http.MethodPost:
+			var schedule Schedule
+			if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid JSON request body", "")
+				return
+			}
+			if name := tenantName(r.Context()); name != "" {
+				schedule.Tenant = name
+			}
+			created, err := scheduler.Add(schedule)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error(), "")
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(created)
+		case id != "" && r.Method == http.MethodGet:
+			schedule, err := scheduler.Get(id)
+			if err == nil && !tenantOwns(r.Context(), schedule.Tenant) {
+				err = errScheduleNotFound
+			}
+			if err != nil {
+				writeJSONError(w, http.StatusNotFound, err.Error(), "id")
+				return
+			}
+			json.NewEncoder(w).Encode(schedule)
+		case id != "" && r.Method == http.MethodDelete:
+			if schedule, err := scheduler.Get(id); err == nil && !tenantOwns(r.Context(), schedule.Tenant) {
+				writeJSONError(w, http.StatusNotFound, errScheduleNotFound.Error(), "id")
+				return
+			}
+			if err := scheduler.Delete(id); err == errScheduleNotFound {
+				writeJSONError(w, http.StatusNotFound, err.Error(), "id")
+				return
+			} else if err != nil {
+				writeJSONError(w, http.StatusConflict, err.Error(), "id")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
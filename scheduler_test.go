@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robfig/cron/v3"
+	bolt "go.etcd.io/bbolt"
+)
+
+// newTestScheduler returns a scheduler whose jobs are queued but never run
+func newTestScheduler(t *testing.T) *Scheduler {
+	t.Helper()
+	jobs := NewJobManager(nil, 1)
+	for jobType := range jobParamTypes {
+		jobs.RegisterRunner(jobType, func(context.Context, *Job) error { return nil })
+	}
+	return &Scheduler{cron: cron.New(), jobs: jobs, entries: make(map[string]*scheduleEntry)}
+}
+
+func TestSchedulerRejectsInlineCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		jobType string
+		params  string
+		wantErr bool
+	}{
+		{"aws secret key", jobTypeBucketScan, `{"bucket":"b","awsAccessKey":"AKIA","awsSecretKey":"secret"}`, true},
+		{"graph client secret", jobTypeGraphDeltaScan, `{"driveId":"d","tenantId":"t","clientId":"c","clientSecret":"secret"}`, true},
+		{"dropbox token", jobTypeDropboxFolderScan, `{"path":"/p","accessToken":"token"}`, true},
+		{"credential profile", jobTypeBucketScan, `{"bucket":"b","profile":"archive"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheduler := newTestScheduler(t)
+			_, err := scheduler.Add(Schedule{Cron: "@daily", JobType: tt.jobType, Params: json.RawMessage(tt.params)})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Add error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchedulerKeepsFileCredentialsPrivate(t *testing.T) {
+	scheduler := newTestScheduler(t)
+	path := filepath.Join(t.TempDir(), "schedules.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(schedulesBucket)
+		return err
+	})
+	scheduler.db = db
+
+	schedule := Schedule{ID: "nightly", Cron: "@daily", JobType: jobTypeGraphDeltaScan,
+		Params: json.RawMessage(`{"driveId":"d","tenantId":"t","clientId":"c","clientSecret":"graph-client-secret"}`)}
+	if _, err := scheduler.register(schedule); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	got, err := scheduler.Get("nightly")
+	if err != nil || bytes.Contains(got.Params, []byte("graph-client-secret")) {
+		t.Errorf("Get returned %s, %v; want params without the client secret", got.Params, err)
+	}
+	for _, listed := range scheduler.List() {
+		if bytes.Contains(listed.Params, []byte("graph-client-secret")) {
+			t.Errorf("List returned the client secret: %s", listed.Params)
+		}
+	}
+
+	scheduler.trigger("nightly")
+	jobs := scheduler.jobs.List()
+	if len(jobs) != 1 || !bytes.Contains(jobs[0].Params(), []byte("graph-client-secret")) {
+		t.Fatalf("the triggered job did not get the schedule's credentials")
+	}
+
+	scheduler.persist(schedule)
+	db.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("graph-client-secret")) {
+		t.Error("the client secret was written to the schedule store")
+	}
+}
+
+func TestDirectoryScanRoots(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		roots   string
+		req     DirectoryScanRequest
+		wantErr string
+	}{
+		{"inside a root", root, DirectoryScanRequest{Path: filepath.Join(root, "in"), Action: watchActionQuarantine, Target: filepath.Join(root, "quarantine")}, ""},
+		{"the root itself", root, DirectoryScanRequest{Path: root, Action: watchActionDelete}, ""},
+		{"no roots configured", "", DirectoryScanRequest{Path: root}, "DIRECTORY_SCAN_ROOTS"},
+		{"outside the roots", root, DirectoryScanRequest{Path: outside, Action: watchActionDelete}, "outside"},
+		{"dot-dot escape", root, DirectoryScanRequest{Path: filepath.Join(root, "..", filepath.Base(outside))}, "outside"},
+		{"symlink escape", root, DirectoryScanRequest{Path: filepath.Join(root, "link")}, "outside"},
+		{"target outside the roots", root, DirectoryScanRequest{Path: root, Action: watchActionMove, Target: outside}, "target"},
+		{"relative path", ".", DirectoryScanRequest{Path: "data"}, "absolute"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DIRECTORY_SCAN_ROOTS", tt.roots)
+			err := tt.req.validate()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("validate = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("validate = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// Secret reference schemes accepted in place of a plaintext value
+const (
+	secretSchemeSecretsManager = "secretsmanager" // secretsmanager:<secret-id>[#<json-key>]
+	secretSchemeSSM            = "ssm"            // ssm:<parameter-name>
+	secretSchemeVault          = "vault"          // vault:<path>#<key>
+)
+
+const (
+	defaultSecretsRefresh = time.Hour
+	secretsFetchTimeout   = 30 * time.Second
+)
+
+// secretEnvVars are the variables that may hold a secret reference
+var secretEnvVars = []string{"FSS_API_KEY", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "DROPBOX_APP_SECRET", "DROPBOX_REFRESH_TOKEN", "KAFKA_SASL_PASSWORD", "NATS_TOKEN", "NATS_PASSWORD", "RABBITMQ_URL", "SPLUNK_HEC_TOKEN", "ELASTICSEARCH_PASSWORD", "ELASTICSEARCH_API_KEY", "SLACK_WEBHOOK_URL", "TEAMS_WEBHOOK_URL", "QUARANTINE_LINK_SECRET", "PAGERDUTY_ROUTING_KEY", "SMTP_PASSWORD", "JIRA_API_TOKEN"}
+
+// secretRef points at one value in a secret store
+type secretRef struct {
+	raw    string
+	scheme string
+	id     string
+	key    string
+}
+
+// parseSecretRef reads a reference such as secretsmanager:prod/finguard#fssApiKey.
+// Values without a known scheme are plaintext.
+func parseSecretRef(value string) (secretRef, bool) {
+	scheme, rest, ok := strings.Cut(value, ":")
+	if !ok || rest == "" {
+		return secretRef{}, false
+	}
+	switch scheme {
+	case secretSchemeSecretsManager, secretSchemeSSM, secretSchemeVault:
+	default:
+		return secretRef{}, false
+	}
+	id, key, _ := strings.Cut(rest, "#")
+	return secretRef{raw: value, scheme: scheme, id: id, key: key}, true
+}
+
+// SecretResolver replaces secret references in the environment with values
+// fetched from AWS Secrets Manager, SSM Parameter Store or HashiCorp Vault,
+// and fetches them again on rotation
+type SecretResolver struct {
+	refs   map[string]secretRef // environment variable -> reference
+	client *http.Client
+	signer *v4.Signer
+
+	awsOnce sync.Once
+	awsCfg  aws.Config
+	awsErr  error
+}
+
+// secretResolver is nil when no variable holds a secret reference
+var secretResolver *SecretResolver
+
+// rotatedScanner replaces the startup scanner client once FSS_API_KEY rotates
+var rotatedScanner atomic.Pointer[scannerLease]
+
+// scannerLease counts the scans running on a rotated scanner client, so the
+// client is destroyed once it has been replaced and the last scan finished
+type scannerLease struct {
+	client  *amaasclient.AmaasClient
+	mu      sync.Mutex
+	refs    int
+	retired bool
+}
+
+// acquire reserves the client for a scan; it fails once the lease is retired
+func (l *scannerLease) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.retired {
+		return false
+	}
+	l.refs++
+	return true
+}
+
+// release ends a scan started with acquire
+func (l *scannerLease) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refs--
+	l.destroyIfDrained()
+}
+
+// retire stops new scans on the client and destroys it when none are running
+func (l *scannerLease) retire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.retired = true
+	l.destroyIfDrained()
+}
+
+func (l *scannerLease) destroyIfDrained() {
+	if l.retired && l.refs == 0 && l.client != nil {
+		l.client.Destroy()
+		l.client = nil
+	}
+}
+
+// acquireRotatedScanner returns the current rotated client's lease, acquired,
+// or nil before FSS_API_KEY has rotated
+func acquireRotatedScanner() *scannerLease {
+	for {
+		lease := rotatedScanner.Load()
+		if lease == nil || lease.acquire() {
+			return lease
+		}
+		// Retired between the load and the acquire; its replacement is stored
+	}
+}
+
+// resolveSecretsFromEnv fetches every secret reference in secretEnvVars and
+// sets the variables to the values. The references are cleared from the
+// environment first so the AWS credential chain used to read them never sees
+// a reference in place of a key.
+func resolveSecretsFromEnv(ctx context.Context) (*SecretResolver, error) {
+	refs := make(map[string]secretRef)
+	for _, name := range secretEnvVars {
+		if ref, ok := parseSecretRef(os.Getenv(name)); ok {
+			refs[name] = ref
+			os.Unsetenv(name)
+		}
+	}
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	s := &SecretResolver{
+		refs:   refs,
+		client: &http.Client{Timeout: secretsFetchTimeout},
+		signer: v4.NewSigner(),
+	}
+	values, err := s.fetchAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range values {
+		os.Setenv(name, value)
+		log.Printf("- Secret: %s from %s", name, refs[name].raw)
+	}
+	return s, nil
+}
+
+// fetchAll reads every reference, fetching each distinct secret once
+func (s *SecretResolver) fetchAll(ctx context.Context) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, secretsFetchTimeout)
+	defer cancel()
+
+	documents := make(map[string]string)
+	values := make(map[string]string, len(s.refs))
+	for name, ref := range s.refs {
+		source := ref.scheme + ":" + ref.id
+		document, ok := documents[source]
+		if !ok {
+			var err error
+			document, err = s.fetch(ctx, ref)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			documents[source] = document
+		}
+		value, err := ref.extract(document)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// extract returns the value for ref from a fetched secret. Secrets Manager
+// and Vault secrets holding a JSON object are indexed by the #key.
+func (ref secretRef) extract(document string) (string, error) {
+	if ref.key == "" {
+		if ref.scheme == secretSchemeVault {
+			return "", fmt.Errorf("vault references need a #key")
+		}
+		return document, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(document), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object", ref.id)
+	}
+	value, ok := fields[ref.key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no string field %q", ref.id, ref.key)
+	}
+	return value, nil
+}
+
+// fetch reads the raw secret for ref
+func (s *SecretResolver) fetch(ctx context.Context, ref secretRef) (string, error) {
+	switch ref.scheme {
+	case secretSchemeSecretsManager:
+		var out struct {
+			SecretString string `json:"SecretString"`
+		}
+		if err := s.awsCall(ctx, "secretsmanager", "secretsmanager.GetSecretValue", ref.id, map[string]interface{}{"SecretId": ref.id}, &out); err != nil {
+			return "", err
+		}
+		return out.SecretString, nil
+	case secretSchemeSSM:
+		var out struct {
+			Parameter struct {
+				Value string `json:"Value"`
+			} `json:"Parameter"`
+		}
+		if err := s.awsCall(ctx, "ssm", "AmazonSSM.GetParameter", ref.id, map[string]interface{}{"Name": ref.id, "WithDecryption": true}, &out); err != nil {
+			return "", err
+		}
+		return out.Parameter.Value, nil
+	case secretSchemeVault:
+		return s.fetchVault(ctx, ref.id)
+	}
+	return "", fmt.Errorf("unknown secret scheme %q", ref.scheme)
+}
+
+// awsConfig loads the AWS config used for secret lookups once, so later
+// refreshes keep using the ambient credentials rather than resolved ones
+func (s *SecretResolver) awsConfig(ctx context.Context) (aws.Config, error) {
+	s.awsOnce.Do(func() {
+		s.awsCfg, s.awsErr = config.LoadDefaultConfig(ctx, config.WithRegion(getEnv("SECRETS_AWS_REGION", os.Getenv("AWS_REGION"))))
+	})
+	return s.awsCfg, s.awsErr
+}
+
+// awsCall makes a SigV4-signed call to an AWS JSON API. The region comes
+// from id when it is an ARN, otherwise from SECRETS_AWS_REGION or the AWS config.
+func (s *SecretResolver) awsCall(ctx context.Context, service, target, id string, input, output interface{}) error {
+	cfg, err := s.awsConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %v", err)
+	}
+	region := cfg.Region
+	if parts := strings.Split(id, ":"); len(parts) > 4 && parts[0] == "arn" && parts[3] != "" {
+		region = parts[3]
+	}
+	if region == "" {
+		return fmt.Errorf("no region configured for %s (set SECRETS_AWS_REGION)", service)
+	}
+
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://%s.%s.amazonaws.com/", service, region), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve AWS credentials: %v", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := s.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), service, region, time.Now()); err != nil {
+		return fmt.Errorf("failed to sign request: %v", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d: %s", target, resp.StatusCode, respBody)
+	}
+	return json.Unmarshal(respBody, output)
+}
+
+// fetchVault reads a secret from VAULT_ADDR with VAULT_TOKEN. KV version 2
+// responses nest the fields under data.data; they are returned as JSON.
+func (s *SecretResolver) fetchVault(ctx context.Context, path string) (string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR must be set for vault references")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %d for %s", resp.StatusCode, path)
+	}
+
+	var out struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &out); err != nil {
+		return "", fmt.Errorf("invalid vault response: %v", err)
+	}
+	if nested, ok := out.Data["data"]; ok && len(nested) > 0 && nested[0] == '{' {
+		return string(nested), nil
+	}
+	data, err := json.Marshal(out.Data)
+	return string(data), err
+}
+
+// refresh fetches every reference again and applies the values that changed.
+// A failed fetch keeps the current values.
+func (s *SecretResolver) refresh(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	values, err := s.fetchAll(ctx)
+	if err != nil {
+		return err
+	}
+	var changed []string
+	for name, value := range values {
+		if os.Getenv(name) != value {
+			os.Setenv(name, value)
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	log.Printf("Secrets rotated: %v", changed)
+	for _, name := range changed {
+		switch name {
+		case "FSS_API_KEY":
+			if err := rotateScannerClient(); err != nil {
+				return err
+			}
+		case "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN":
+			// Cached default-chain configs hold the previous credentials
+			awsClients.flush()
+		}
+	}
+	return nil
+}
+
+// Start refreshes the secrets every SECRETS_REFRESH_INTERVAL (0 disables)
+func (s *SecretResolver) Start(ctx context.Context) {
+	if s == nil {
+		return
+	}
+	interval, err := time.ParseDuration(getEnv("SECRETS_REFRESH_INTERVAL", defaultSecretsRefresh.String()))
+	if err != nil || interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := s.refresh(ctx); err != nil {
+				log.Printf("Warning: secret refresh failed, keeping current values: %v", err)
+			}
+		}
+	}()
+}
+
+// rotateScannerClient creates a SaaS scanner client with the current
+// FSS_API_KEY. Scans already running finish on the previous client, which is
+// destroyed after them; the startup client stays open for its other users.
+func rotateScannerClient() error {
+	if os.Getenv("SCANNER_EXTERNAL_ADDR") != "" {
+		return nil
+	}
+	client, err := amaasclient.NewClient(os.Getenv("FSS_API_KEY"), getEnv("FSS_REGION", "us-1"))
+	if err != nil {
+		return fmt.Errorf("failed to create scanner client with rotated FSS_API_KEY: %v", err)
+	}
+	if previous := rotatedScanner.Swap(&scannerLease{client: client}); previous != nil {
+		previous.retire()
+	}
+	log.Printf("Scanner client recreated with rotated FSS_API_KEY")
+	return nil
+}
+
+// primaryClient returns the scanner client for the primary backend: client,
+// or its replacement after FSS_API_KEY rotated. release must be called once
+// the client is no longer used.
+func primaryClient(client *amaasclient.AmaasClient) (primary *amaasclient.AmaasClient, release func()) {
+	if lease := acquireRotatedScanner(); lease != nil {
+		return lease.client, lease.release
+	}
+	return client, func() {}
+}
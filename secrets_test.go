@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// fakeVault serves KV version 2 secrets whose fields can be changed
+type fakeVault struct {
+	mu      sync.Mutex
+	secrets map[string]map[string]string
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fields, ok := v.secrets[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": fields}})
+}
+
+func (v *fakeVault) set(path, field, value string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.secrets[path][field] = value
+}
+
+func TestSecretRefreshFlushesAWSClients(t *testing.T) {
+	tests := []struct {
+		name      string
+		field     string
+		wantFlush bool
+	}{
+		{"access key rotated", "accessKeyId", true},
+		{"secret key rotated", "secretAccessKey", true},
+		{"other secret rotated", "natsToken", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault := &fakeVault{secrets: map[string]map[string]string{
+				"/v1/secret/data/finguard": {"accessKeyId": "AKIA1", "secretAccessKey": "secret1", "natsToken": "token1"},
+			}}
+			server := httptest.NewServer(vault)
+			defer server.Close()
+			t.Setenv("VAULT_ADDR", server.URL)
+			t.Setenv("AWS_ACCESS_KEY_ID", "vault:secret/data/finguard#accessKeyId")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "vault:secret/data/finguard#secretAccessKey")
+			t.Setenv("NATS_TOKEN", "vault:secret/data/finguard#natsToken")
+
+			resolver, err := resolveSecretsFromEnv(context.Background())
+			if err != nil {
+				t.Fatalf("resolveSecretsFromEnv: %v", err)
+			}
+
+			awsClients = NewAWSClientCache()
+			t.Cleanup(func() { awsClients = nil })
+			awsClients.config(S3Options{}, "us-east-1", func() (aws.Config, error) {
+				return aws.Config{Region: "us-east-1"}, nil
+			})
+
+			vault.set("/v1/secret/data/finguard", tt.field, "rotated")
+			if err := resolver.refresh(context.Background()); err != nil {
+				t.Fatalf("refresh: %v", err)
+			}
+			if flushed := len(awsClients.configs) == 0; flushed != tt.wantFlush {
+				t.Errorf("AWS client cache flushed = %v, want %v", flushed, tt.wantFlush)
+			}
+		})
+	}
+}
+
+func TestRotatedScannerDestroyedAfterScansDrain(t *testing.T) {
+	first, _ := newRecordingTestScanner(t)
+	second, _ := newRecordingTestScanner(t)
+	t.Cleanup(func() { rotatedScanner.Store(nil) })
+
+	previous := &scannerLease{client: first}
+	rotatedScanner.Store(previous)
+	running := acquireRotatedScanner()
+	if running != previous {
+		t.Fatal("acquireRotatedScanner did not return the current lease")
+	}
+
+	// FSS_API_KEY rotates while a scan runs on the first client
+	rotatedScanner.Swap(&scannerLease{client: second}).retire()
+	if lease := acquireRotatedScanner(); lease == nil || lease.client != second {
+		t.Fatal("new scans did not get the replacement client")
+	} else {
+		lease.release()
+	}
+	if _, err := running.client.ScanBuffer([]byte("hello"), "running", nil); err != nil {
+		t.Fatalf("the running scan lost its client: %v", err)
+	}
+	if running.acquire() {
+		t.Error("a retired lease accepted a new scan")
+	}
+
+	running.release()
+	if previous.client != nil {
+		t.Error("the replaced client was not destroyed once its scans finished")
+	}
+}
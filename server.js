@@ -19,6 +19,20 @@ const {
     isAdmin 
 } = require('./middleware/auth');
 
+// Authenticate calls to the scanner service when it requires an API key
+if (process.env.SCANNER_API_KEY) {
+    axios.defaults.headers.common['Authorization'] = `Bearer ${process.env.SCANNER_API_KEY}`;
+}
+
+// Present a client certificate when the scanner service enforces mTLS
+if (process.env.SCANNER_CLIENT_CERT && process.env.SCANNER_CLIENT_KEY) {
+    axios.defaults.httpsAgent = new https.Agent({
+        cert: fs.readFileSync(process.env.SCANNER_CLIENT_CERT),
+        key: fs.readFileSync(process.env.SCANNER_CLIENT_KEY),
+        ca: process.env.SCANNER_CA_CERT ? fs.readFileSync(process.env.SCANNER_CA_CERT) : undefined,
+    });
+}
+
 const app = express();
 const httpPort = process.env.HTTP_PORT || 3000;
 const httpsPort = process.env.HTTPS_PORT || 3443;
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	siemFormatCEF  = "cef"
+	siemFormatLEEF = "leef"
+	siemVendor     = "TrendAI"
+	siemProduct    = "FinGuard"
+)
+
+// syslogFacilities maps facility names to their codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "security": 13, "audit": 13,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// siemField is a scan event field and its default CEF and LEEF keys
+type siemField struct {
+	name    string
+	cefKey  string
+	leefKey string
+	value   func(ScanCompletedEvent) string
+}
+
+// siemFields are the event fields sent in every message, in order
+var siemFields = []siemField{
+	{"time", "rt", "devTime", func(e ScanCompletedEvent) string {
+		t, err := time.Parse(time.RFC3339, e.CompletedAt)
+		if err != nil {
+			return ""
+		}
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	}},
+	{"verdict", "outcome", "verdict", func(e ScanCompletedEvent) string { return e.Verdict }},
+	{"identifier", "fname", "resource", func(e ScanCompletedEvent) string { return e.Identifier }},
+	{"bucket", "cs5", "bucket", func(e ScanCompletedEvent) string { return e.Bucket }},
+	{"key", "filePath", "key", func(e ScanCompletedEvent) string { return e.Key }},
+	{"sha256", "fileHash", "fileHash", func(e ScanCompletedEvent) string { return e.FileSHA256 }},
+	{"malwareNames", "cs1", "malwareNames", func(e ScanCompletedEvent) string { return strings.Join(e.MalwareNames, ",") }},
+	{"source", "cs2", "source", func(e ScanCompletedEvent) string { return e.Source }},
+	{"tenant", "cs3", "tenant", func(e ScanCompletedEvent) string { return e.Tenant }},
+	{"scanId", "cs4", "scanId", func(e ScanCompletedEvent) string { return e.ScanID }},
+	{"tags", "cs6", "tags", func(e ScanCompletedEvent) string { return strings.Join(e.Tags, ",") }},
+	{"requestId", "externalId", "requestId", func(e ScanCompletedEvent) string { return e.RequestID }},
+}
+
+// syslogSink forwards every scan verdict to a SIEM as a CEF or LEEF message
+// over syslog. TCP and TLS connections are kept open and redialed on error.
+type syslogSink struct {
+	network  string // udp, tcp or tls
+	addr     string
+	tls      *tls.Config
+	format   string
+	facility int
+	hostname string
+	keys     map[string]string // field name -> CEF or LEEF key; empty drops the field
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// newSyslogSink creates a sink for addr (SIEM_SYSLOG_ADDR), a udp://, tcp://
+// or tls:// URL with host and port
+func newSyslogSink(addr string) (*syslogSink, error) {
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" || u.Port() == "" {
+		return nil, fmt.Errorf("invalid SIEM_SYSLOG_ADDR %q, expected udp://, tcp:// or tls://host:port", addr)
+	}
+	s := &syslogSink{network: u.Scheme, addr: u.Host, format: strings.ToLower(getEnv("SIEM_FORMAT", siemFormatCEF))}
+	switch s.network {
+	case "udp", "tcp":
+	case "tls":
+		s.tls = &tls.Config{MinVersion: tls.VersionTLS12, ServerName: u.Hostname()}
+		if caFile := getEnv("SIEM_TLS_CA", ""); caFile != "" {
+			pem, err := os.ReadFile(caFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read SIEM_TLS_CA: %v", err)
+			}
+			s.tls.RootCAs = x509.NewCertPool()
+			if !s.tls.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates in SIEM_TLS_CA %s", caFile)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid SIEM_SYSLOG_ADDR %q, expected udp://, tcp:// or tls://host:port", addr)
+	}
+	if s.format != siemFormatCEF && s.format != siemFormatLEEF {
+		return nil, fmt.Errorf("invalid SIEM_FORMAT %q, expected cef or leef", s.format)
+	}
+	facility, ok := syslogFacilities[strings.ToLower(getEnv("SIEM_SYSLOG_FACILITY", "local4"))]
+	if !ok {
+		return nil, fmt.Errorf("invalid SIEM_SYSLOG_FACILITY %q", getEnv("SIEM_SYSLOG_FACILITY", ""))
+	}
+	s.facility = facility
+	if s.hostname, err = os.Hostname(); err != nil {
+		s.hostname = "finguard"
+	}
+
+	s.keys = make(map[string]string, len(siemFields))
+	for _, field := range siemFields {
+		s.keys[field.name] = field.cefKey
+		if s.format == siemFormatLEEF {
+			s.keys[field.name] = field.leefKey
+		}
+	}
+	// SIEM_FIELD_MAP overrides keys as field=key pairs; an empty key drops the field
+	for _, pair := range strings.Split(getEnv("SIEM_FIELD_MAP", ""), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, key, _ := strings.Cut(pair, "=")
+		if _, ok := s.keys[name]; !ok {
+			return nil, fmt.Errorf("unknown field %q in SIEM_FIELD_MAP", name)
+		}
+		s.keys[name] = strings.TrimSpace(key)
+	}
+	return s, nil
+}
+
+func (s *syslogSink) Name() string {
+	return fmt.Sprintf("syslog:%s://%s (%s)", s.network, s.addr, s.format)
+}
+
+// Emit sends one message for the event
+func (s *syslogSink) Emit(ctx context.Context, event ScanCompletedEvent) error {
+	msg := s.message(event)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A kept-alive connection may have been closed by the collector; retry once
+	for attempt := 0; ; attempt++ {
+		if s.conn == nil {
+			conn, err := s.dial(ctx)
+			if err != nil {
+				return err
+			}
+			s.conn = conn
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			s.conn.SetWriteDeadline(deadline)
+		}
+		_, err := s.conn.Write(msg)
+		if err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+		if attempt > 0 {
+			return err
+		}
+	}
+}
+
+// dial connects to the collector
+func (s *syslogSink) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if s.tls != nil {
+		return (&tls.Dialer{NetDialer: dialer, Config: s.tls}).DialContext(ctx, "tcp", s.addr)
+	}
+	return dialer.DialContext(ctx, s.network, s.addr)
+}
+
+// message frames the CEF or LEEF payload as an RFC 3164 syslog line. Stream
+// transports separate messages with a newline, which payloads never contain.
+func (s *syslogSink) message(event ScanCompletedEvent) []byte {
+	severity := 6 // informational
+	if event.Verdict == "malicious" {
+		severity = 2 // critical
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>%s %s finguard: ", s.facility*8+severity, time.Now().Format(time.Stamp), s.hostname)
+	if s.format == siemFormatLEEF {
+		s.writeLEEF(&b, event)
+	} else {
+		s.writeCEF(&b, event)
+	}
+	if s.network != "udp" {
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+// writeCEF writes an ArcSight Common Event Format record
+func (s *syslogSink) writeCEF(b *strings.Builder, event ScanCompletedEvent) {
+	signature, name, severity := "scan.clean", "File scanned clean", 1
+	if event.Verdict == "malicious" {
+		signature, name, severity = "scan.malicious", "Malware detected", 10
+	}
+	header := strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	fmt.Fprintf(b, "CEF:0|%s|%s|%s|%s|%s|%d|", siemVendor, siemProduct, header.Replace(version), signature, name, severity)
+
+	value := strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+	first := true
+	for _, field := range siemFields {
+		key, v := s.keys[field.name], field.value(event)
+		if key == "" || v == "" {
+			continue
+		}
+		if !first {
+			b.WriteByte(' ')
+		}
+		first = false
+		fmt.Fprintf(b, "%s=%s", key, value.Replace(v))
+		// Custom string fields carry their meaning in a label
+		if strings.HasPrefix(key, "cs") && len(key) == 3 {
+			fmt.Fprintf(b, " %sLabel=%s", key, field.name)
+		}
+	}
+}
+
+// writeLEEF writes an IBM QRadar Log Event Extended Format 1.0 record with
+// tab-separated attributes
+func (s *syslogSink) writeLEEF(b *strings.Builder, event ScanCompletedEvent) {
+	eventID, severity := "scan.clean", 1
+	if event.Verdict == "malicious" {
+		eventID, severity = "scan.malicious", 10
+	}
+	header := strings.NewReplacer(`|`, ` `, "\t", " ", "\n", " ", "\r", " ")
+	fmt.Fprintf(b, "LEEF:1.0|%s|%s|%s|%s|", siemVendor, siemProduct, header.Replace(version), eventID)
+
+	value := strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+	fmt.Fprintf(b, "sev=%d\tcat=%s", severity, event.Verdict)
+	for _, field := range siemFields {
+		key, v := s.keys[field.name], field.value(event)
+		if key == "" || v == "" {
+			continue
+		}
+		fmt.Fprintf(b, "\t%s=%s", key, value.Replace(v))
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSlackBatchSize  = 20
+	defaultSlackMaxRetries = 3
+	// Slack accepts about one message per second on an incoming webhook
+	slackPostInterval = time.Second
+)
+
+// slackEscaper escapes the characters Slack treats as markup in text
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackRoute is one webhook and channel; detections routed to it are posted
+// together
+type slackRoute struct {
+	webhookURL string
+	channel    string
+	batcher    *eventBatcher
+
+	mu       sync.Mutex
+	lastPost time.Time
+}
+
+// slackNotifier posts detections to Slack incoming webhooks. Each severity
+// can go to its own channel or webhook, and detections arriving close
+// together are batched into one message to stay under Slack's rate limit.
+type slackNotifier struct {
+	routes     map[string]*slackRoute // severity -> route; missing drops the severity
+	resultURL  string
+	maxRetries int
+	client     *http.Client
+}
+
+// newSlackNotifier creates a notifier posting to webhookURL
+// (SLACK_WEBHOOK_URL). SLACK_ROUTES sends severities elsewhere as
+// severity=target pairs, where the target is a #channel, another webhook URL
+// or empty to mute the severity.
+func newSlackNotifier(webhookURL string) (*slackNotifier, error) {
+	if !strings.HasPrefix(webhookURL, "https://") {
+		return nil, fmt.Errorf("invalid SLACK_WEBHOOK_URL, expected an https:// incoming webhook URL")
+	}
+	interval, err := time.ParseDuration(getEnv("SLACK_BATCH_INTERVAL", "10s"))
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid SLACK_BATCH_INTERVAL %q", getEnv("SLACK_BATCH_INTERVAL", ""))
+	}
+	batchSize := getEnvInt("SLACK_BATCH_SIZE", defaultSlackBatchSize)
+	if batchSize <= 0 || batchSize > defaultSlackBatchSize {
+		// A message holds at most 50 blocks, two per detection plus a header
+		batchSize = defaultSlackBatchSize
+	}
+
+	targets := map[string][2]string{}
+	for _, severity := range []string{severityCritical, severityHigh, severityLow} {
+		targets[severity] = [2]string{webhookURL, getEnv("SLACK_CHANNEL", "")}
+	}
+	for _, pair := range strings.Split(getEnv("SLACK_ROUTES", ""), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		severity, target, _ := strings.Cut(pair, "=")
+		severity, target = strings.ToLower(strings.TrimSpace(severity)), strings.TrimSpace(target)
+		route, ok := targets[severity]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("unknown severity %q in SLACK_ROUTES, expected critical, high or low", severity)
+		case target == "":
+			delete(targets, severity)
+		case strings.HasPrefix(target, "https://"):
+			targets[severity] = [2]string{target, ""}
+		case strings.HasPrefix(target, "#"), strings.HasPrefix(target, "@"):
+			targets[severity] = [2]string{route[0], target}
+		default:
+			return nil, fmt.Errorf("invalid SLACK_ROUTES target %q, expected a #channel or https:// webhook URL", target)
+		}
+	}
+
+	n := &slackNotifier{
+		routes:     make(map[string]*slackRoute, len(targets)),
+		resultURL:  getEnv("SLACK_RESULT_URL", ""),
+		maxRetries: getEnvInt("SLACK_MAX_RETRIES", defaultSlackMaxRetries),
+		client:     &http.Client{Timeout: notifyTimeout},
+	}
+	// Severities sharing a webhook and channel share its batches and rate limit
+	shared := map[[2]string]*slackRoute{}
+	for severity, target := range targets {
+		route, ok := shared[target]
+		if !ok {
+			route = &slackRoute{webhookURL: target[0], channel: target[1]}
+			route.batcher = newEventBatcher(batchSize, interval, func(batch []interface{}) { n.send(route, batch) })
+			shared[target] = route
+		}
+		n.routes[severity] = route
+	}
+	return n, nil
+}
+
+func (n *slackNotifier) Name() string {
+	var channels []string
+	for _, severity := range []string{severityCritical, severityHigh, severityLow} {
+		if route, ok := n.routes[severity]; ok && route.channel != "" {
+			channels = append(channels, severity+"="+route.channel)
+		}
+	}
+	if len(channels) == 0 {
+		return "slack"
+	}
+	return "slack (" + strings.Join(channels, ", ") + ")"
+}
+
+// Notify queues the detection for the next message on its severity's route
+func (n *slackNotifier) Notify(ctx context.Context, event DetectionEvent) error {
+	route, ok := n.routes[event.Severity]
+	if !ok {
+		return nil
+	}
+	return route.batcher.add(event)
+}
+
+// send posts a batch of detections as one message, waiting out the rate
+// limit of the webhook first
+func (n *slackNotifier) send(route *slackRoute, batch []interface{}) {
+	events := make([]DetectionEvent, len(batch))
+	for i, event := range batch {
+		events[i] = event.(DetectionEvent)
+	}
+	body, err := json.Marshal(n.message(route.channel, events))
+	if err != nil {
+		log.Printf("Slack: failed to encode message: %v", err)
+		return
+	}
+
+	err = retryWithBackoff(n.maxRetries, func() (bool, error) {
+		route.mu.Lock()
+		if wait := time.Until(route.lastPost.Add(slackPostInterval)); wait > 0 {
+			time.Sleep(wait)
+		}
+		route.lastPost = time.Now()
+		route.mu.Unlock()
+
+		retryAfter, retry, err := n.post(route.webhookURL, body)
+		if retryAfter > 0 {
+			route.mu.Lock()
+			route.lastPost = time.Now().Add(retryAfter - slackPostInterval)
+			route.mu.Unlock()
+		}
+		return retry, err
+	})
+	if err != nil {
+		log.Printf("Slack: dropping message with %d detections: %v", len(events), err)
+	}
+}
+
+// post sends one message and reports whether a failure is worth retrying and
+// how long Slack asked to wait
+func (n *slackNotifier) post(webhookURL string, body []byte) (time.Duration, bool, error) {
+	resp, err := n.client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 0, false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	if resp.StatusCode == http.StatusTooManyRequests {
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return time.Duration(seconds) * time.Second, true, err
+	}
+	return 0, resp.StatusCode >= 500, err
+}
+
+// message builds the Block Kit message for a batch of detections
+func (n *slackNotifier) message(channel string, events []DetectionEvent) map[string]interface{} {
+	title := fmt.Sprintf("Malware detected in %s", events[0].Identifier)
+	if len(events) > 1 {
+		title = fmt.Sprintf("%d malware detections", len(events))
+	}
+	blocks := []map[string]interface{}{
+		{"type": "header", "text": map[string]interface{}{"type": "plain_text", "text": truncateText(":rotating_light: "+title, 150), "emoji": true}},
+	}
+	for _, event := range events {
+		malware := strings.Join(event.MalwareNames, ", ")
+		if malware == "" {
+			malware = "unknown"
+		}
+		lines := []string{
+			fmt.Sprintf("*Object:* `%s`", slackEscaper.Replace(event.Identifier)),
+			fmt.Sprintf("*Malware:* %s", slackEscaper.Replace(malware)),
+			fmt.Sprintf("*Severity:* %s", event.Severity),
+		}
+		if event.ScanID != "" {
+			lines = append(lines, fmt.Sprintf("*Scan ID:* `%s`", slackEscaper.Replace(event.ScanID)))
+		}
+		if link := detectionLink(n.resultURL, event); link != "" {
+			lines = append(lines, fmt.Sprintf("<%s|View result>", link))
+		}
+		blocks = append(blocks,
+			map[string]interface{}{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": truncateText(strings.Join(lines, "\n"), 3000)}},
+			map[string]interface{}{"type": "context", "elements": []map[string]string{{"type": "mrkdwn", "text": fmt.Sprintf("FinGuard %s · %s · %s", version, event.Source, event.DetectedAt)}}},
+		)
+	}
+
+	msg := map[string]interface{}{"text": slackEscaper.Replace(title), "blocks": blocks}
+	if channel != "" {
+		msg["channel"] = channel
+	}
+	return msg
+}
+
+// truncateText shortens text to limit characters, such as the limit of a Slack block field
+func truncateText(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// Close posts the queued detections and stops the batchers
+func (n *slackNotifier) Close() error {
+	closed := map[*slackRoute]bool{}
+	for _, route := range n.routes {
+		if !closed[route] {
+			closed[route] = true
+			route.batcher.Close()
+		}
+	}
+	return nil
+}
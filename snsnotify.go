@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// snsNotifier publishes detection events to an SNS topic
+type snsNotifier struct {
+	client   *sns.Client
+	topicARN string
+}
+
+// newSNSNotifier creates a publisher for topicARN using the default credential chain
+func newSNSNotifier(ctx context.Context, topicARN string) (*snsNotifier, error) {
+	parsed, err := arn.Parse(topicARN)
+	if err != nil || parsed.Service != "sns" {
+		return nil, fmt.Errorf("invalid SNS_TOPIC_ARN %q", topicARN)
+	}
+
+	cfg, err := loadAWSConfig(ctx, S3Options{}, parsed.Region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config for SNS: %v", err)
+	}
+	return &snsNotifier{client: sns.NewFromConfig(cfg), topicARN: topicARN}, nil
+}
+
+func (n *snsNotifier) Name() string {
+	return "sns:" + n.topicARN
+}
+
+// Notify publishes the event as JSON with source and bucket message attributes
+// so subscriptions can filter on them
+func (n *snsNotifier) Notify(ctx context.Context, event DetectionEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	attributes := map[string]types.MessageAttributeValue{
+		"source": {DataType: aws.String("String"), StringValue: aws.String(event.Source)},
+	}
+	if event.Bucket != "" {
+		attributes["bucket"] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(event.Bucket)}
+	}
+
+	_, err = n.client.Publish(ctx, &sns.PublishInput{
+		TopicArn:          aws.String(n.topicARN),
+		Subject:           aws.String("FinGuard malware detected"),
+		Message:           aws.String(string(body)),
+		MessageAttributes: attributes,
+	})
+	return err
+}
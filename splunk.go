@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSplunkBatchSize     = 100
+	defaultSplunkFlushInterval = 5 * time.Second
+	defaultSplunkMaxRetries    = 3
+)
+
+// splunkEvent is one event of the HEC JSON protocol
+type splunkEvent struct {
+	Time       float64     `json:"time"`
+	Host       string      `json:"host,omitempty"`
+	Source     string      `json:"source"`
+	SourceType string      `json:"sourcetype,omitempty"`
+	Index      string      `json:"index,omitempty"`
+	Event      interface{} `json:"event"`
+}
+
+// splunkHECSink sends scan verdicts and remediation actions to a Splunk HTTP
+// Event Collector. Events are queued and posted in batches by a background
+// goroutine, so scans never wait on Splunk.
+type splunkHECSink struct {
+	endpoint   string
+	token      string
+	index      string
+	sourceType string
+	host       string
+	maxRetries int
+	client     *http.Client
+	batcher    *eventBatcher
+}
+
+// newSplunkHECSink creates a sink for the collector at url (SPLUNK_HEC_URL),
+// such as https://splunk:8088; the /services/collector/event path is added
+// when the URL has none
+func newSplunkHECSink(url string) (*splunkHECSink, error) {
+	token := getEnv("SPLUNK_HEC_TOKEN", "")
+	if token == "" {
+		return nil, fmt.Errorf("SPLUNK_HEC_TOKEN is required with SPLUNK_HEC_URL")
+	}
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return nil, fmt.Errorf("invalid SPLUNK_HEC_URL %q", url)
+	}
+	if !strings.Contains(strings.SplitN(url, "://", 2)[1], "/") {
+		url = strings.TrimRight(url, "/") + "/services/collector/event"
+	}
+	interval, err := time.ParseDuration(getEnv("SPLUNK_HEC_FLUSH_INTERVAL", defaultSplunkFlushInterval.String()))
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid SPLUNK_HEC_FLUSH_INTERVAL %q", getEnv("SPLUNK_HEC_FLUSH_INTERVAL", ""))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if getEnv("SPLUNK_HEC_INSECURE_SKIP_VERIFY", "false") == "true" {
+		// Splunk ships with a self-signed certificate on the HEC port
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	batchSize := getEnvInt("SPLUNK_HEC_BATCH_SIZE", defaultSplunkBatchSize)
+	if batchSize <= 0 {
+		batchSize = defaultSplunkBatchSize
+	}
+	s := &splunkHECSink{
+		endpoint:   url,
+		token:      token,
+		index:      getEnv("SPLUNK_HEC_INDEX", ""),
+		sourceType: getEnv("SPLUNK_HEC_SOURCETYPE", "finguard:scan"),
+		maxRetries: getEnvInt("SPLUNK_HEC_MAX_RETRIES", defaultSplunkMaxRetries),
+		client:     &http.Client{Timeout: notifyTimeout, Transport: transport},
+	}
+	s.host, _ = os.Hostname()
+	s.batcher = newEventBatcher(batchSize, interval, s.send)
+	return s, nil
+}
+
+func (s *splunkHECSink) Name() string {
+	return "splunk:" + s.endpoint
+}
+
+// Emit queues the verdict of a scan
+func (s *splunkHECSink) Emit(ctx context.Context, event ScanCompletedEvent) error {
+	at, err := time.Parse(time.RFC3339, event.CompletedAt)
+	if err != nil {
+		at = time.Now()
+	}
+	return s.enqueue(at, "finguard:scan", event)
+}
+
+// EmitRemediation queues a remediation action
+func (s *splunkHECSink) EmitRemediation(ctx context.Context, event RemediationEvent) error {
+	at, err := time.Parse(time.RFC3339, event.At)
+	if err != nil {
+		at = time.Now()
+	}
+	return s.enqueue(at, "finguard:remediation", event)
+}
+
+// enqueue wraps an event for HEC and queues it for the next batch
+func (s *splunkHECSink) enqueue(at time.Time, source string, event interface{}) error {
+	return s.batcher.add(splunkEvent{
+		Time:       float64(at.UnixMilli()) / 1000,
+		Host:       s.host,
+		Source:     source,
+		SourceType: s.sourceType,
+		Index:      s.index,
+		Event:      event,
+	})
+}
+
+// send posts a batch, retrying with exponential backoff on network errors,
+// 429 and 5xx responses
+func (s *splunkHECSink) send(batch []interface{}) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, event := range batch {
+		if err := enc.Encode(event); err != nil {
+			log.Printf("Splunk HEC: failed to encode event: %v", err)
+			return
+		}
+	}
+
+	attempts := 0
+	err := retryWithBackoff(s.maxRetries, func() (bool, error) {
+		attempts++
+		retry, err := s.post(body.Bytes())
+		if err != nil && retry {
+			log.Printf("Splunk HEC: attempt %d failed: %v", attempts, err)
+		}
+		return retry, err
+	})
+	if err != nil {
+		log.Printf("Splunk HEC: dropping %d events after %d attempts: %v", len(batch), attempts, err)
+	}
+}
+
+// post sends one request and reports whether a failure is worth retrying
+func (s *splunkHECSink) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Splunk "+s.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("HEC returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// Close sends the queued events and stops the batcher
+func (s *splunkHECSink) Close() error {
+	return s.batcher.Close()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const (
+	spoolFilePattern      = "finguard-upload-*"
+	defaultSpoolThreshold = 32 // MB
+)
+
+// FileReader implements AmaasClientReader over a local file so the scanner
+// only reads the chunks the engine requests. Spooled files are removed on Close.
+type FileReader struct {
+	file       *os.File
+	identifier string
+	size       int64
+	remove     bool
+}
+
+// NewFileReader opens path for reader-based scanning
+func NewFileReader(path, identifier string) (*FileReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &FileReader{file: file, identifier: identifier, size: info.Size()}, nil
+}
+
+// Identifier returns the scan identifier
+func (r *FileReader) Identifier() string {
+	return r.identifier
+}
+
+// DataSize returns the file size
+func (r *FileReader) DataSize() (int64, error) {
+	return r.size, nil
+}
+
+// ReadBytes reads length bytes at offset
+func (r *FileReader) ReadBytes(offset int64, length int32) ([]byte, error) {
+	buf := make([]byte, length)
+	n, err := r.file.ReadAt(buf, offset)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return buf[:n], err
+}
+
+// sum256 returns the hex SHA-256 of the file, or "" when it cannot be read
+func (r *FileReader) sum256() string {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r.file, 0, r.size)); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Close closes the file and removes it when it was spooled
+func (r *FileReader) Close() error {
+	err := r.file.Close()
+	if r.remove {
+		if removeErr := os.Remove(r.file.Name()); removeErr != nil && err == nil {
+			err = removeErr
+		}
+	}
+	return err
+}
+
+// getSpoolDir returns SCAN_SPOOL_DIR, defaulting to the system temp directory
+func getSpoolDir() string {
+	return getEnv("SCAN_SPOOL_DIR", os.TempDir())
+}
+
+// shouldSpool reports whether an upload should be streamed to a temp file
+// instead of buffered in memory: bodies of unknown length and bodies larger
+// than SCAN_SPOOL_THRESHOLD_MB. A negative threshold disables spooling.
+func shouldSpool(r *http.Request) bool {
+	threshold := getEnvInt("SCAN_SPOOL_THRESHOLD_MB", defaultSpoolThreshold)
+	if threshold < 0 {
+		return false
+	}
+	return r.ContentLength < 0 || r.ContentLength > int64(threshold)<<20
+}
+
+// spoolUpload copies body to a temp file in the spool directory and returns a
+// reader over it together with the content SHA-256. The file is removed when
+// the reader is closed, or immediately when the copy fails.
+func spoolUpload(body io.Reader, identifier string) (*FileReader, string, error) {
+	file, err := os.CreateTemp(getSpoolDir(), spoolFilePattern)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create spool file: %w", err)
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), body)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, "", err
+	}
+	return &FileReader{file: file, identifier: identifier, size: size, remove: true}, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// cleanSpoolDir removes spool files left behind by a previous process
+func cleanSpoolDir() {
+	matches, err := filepath.Glob(filepath.Join(getSpoolDir(), spoolFilePattern))
+	if err != nil {
+		return
+	}
+	for _, path := range matches {
+		if err := os.Remove(path); err == nil {
+			log.Printf("Removed stale spool file %s", path)
+		}
+	}
+}
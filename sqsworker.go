@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+const defaultSQSWorkers = 4
+
+// S3EventRecord is the part of an S3 event notification record we need
+type S3EventRecord struct {
+	EventName string `json:"eventName"`
+	AWSRegion string `json:"awsRegion"`
+	S3        struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key  string `json:"key"`
+			Size int64  `json:"size"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+// S3EventNotification is the body S3 sends to SQS (directly or through SNS)
+type S3EventNotification struct {
+	Records []S3EventRecord `json:"Records"`
+	Event   string          `json:"Event"` // s3:TestEvent when notifications are first configured
+}
+
+// parseS3EventMessage decodes an SQS message body, unwrapping SNS envelopes
+func parseS3EventMessage(body string) (S3EventNotification, error) {
+	var event S3EventNotification
+
+	var envelope struct {
+		Type    string `json:"Type"`
+		Message string `json:"Message"`
+	}
+	if err := json.Unmarshal([]byte(body), &envelope); err == nil && envelope.Type == "Notification" {
+		body = envelope.Message
+	}
+
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		return event, fmt.Errorf("invalid S3 event message: %v", err)
+	}
+	return event, nil
+}
+
+// queueRegion extracts the region from https://sqs.<region>.amazonaws.com/<account>/<queue>
+func queueRegion(queueURL string) string {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(u.Hostname(), ".")
+	if len(parts) >= 3 && parts[0] == "sqs" {
+		return parts[1]
+	}
+	return ""
+}
+
+// startSQSWorker consumes S3 ObjectCreated events from SQS_QUEUE_URL and scans
+// each new object. Messages are deleted only after every record scanned
+// successfully; otherwise they become visible again and are retried.
+func startSQSWorker(ctx context.Context, scannerClient *amaasclient.AmaasClient, queueURL string) error {
+	region := getEnv("SQS_REGION", queueRegion(queueURL))
+	cfg, err := loadAWSConfig(ctx, S3Options{}, region)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config for SQS: %v", err)
+	}
+	client := sqs.NewFromConfig(cfg)
+
+	workers, err := strconv.Atoi(getEnv("SQS_WORKERS", strconv.Itoa(defaultSQSWorkers)))
+	if err != nil || workers <= 0 {
+		workers = defaultSQSWorkers
+	}
+
+	s3Logger.Printf("SQS worker: consuming %s with %d workers", queueURL, workers)
+	ctx = withBackgroundScan(ctx)
+
+	messages := make(chan types.Message)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range messages {
+				handleSQSMessage(ctx, scannerClient, client, queueURL, msg)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(messages)
+		for ctx.Err() == nil {
+			out, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+				QueueUrl:            aws.String(queueURL),
+				MaxNumberOfMessages: int32(min(workers, 10)),
+				WaitTimeSeconds:     20,
+			})
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				s3Logger.Printf("SQS worker: receive failed: %v", err)
+				time.Sleep(5 * time.Second)
+				continue
+			}
+			for _, msg := range out.Messages {
+				messages <- msg
+			}
+		}
+	}()
+
+	return nil
+}
+
+// handleSQSMessage scans the objects in one message and deletes it on success
+func handleSQSMessage(ctx context.Context, scannerClient *amaasclient.AmaasClient, client *sqs.Client, queueURL string, msg types.Message) {
+	messageID := aws.ToString(msg.MessageId)
+
+	event, err := parseS3EventMessage(aws.ToString(msg.Body))
+	if err != nil {
+		// Malformed messages are left for the queue's redrive policy
+		s3Logger.Printf("SQS worker: message %s: %v", messageID, err)
+		return
+	}
+
+	ok := true
+	for _, record := range event.Records {
+		if !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+			continue
+		}
+		if err := scanS3EventRecord(ctx, scannerClient, record); err != nil {
+			s3Logger.Printf("SQS worker: message %s: %v", messageID, err)
+			ok = false
+		}
+	}
+	if !ok {
+		return
+	}
+
+	_, err = client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(queueURL),
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	if err != nil {
+		s3Logger.Printf("SQS worker: failed to delete message %s: %v", messageID, err)
+	}
+}
+
+// scanS3EventRecord scans the object referenced by an ObjectCreated record
+func scanS3EventRecord(ctx context.Context, scannerClient *amaasclient.AmaasClient, record S3EventRecord) error {
+	// Keys in event notifications are URL encoded with '+' for spaces
+	key, err := url.QueryUnescape(record.S3.Object.Key)
+	if err != nil {
+		return fmt.Errorf("invalid object key %q: %v", record.S3.Object.Key, err)
+	}
+	bucket := record.S3.Bucket.Name
+
+	reader, err := NewS3ClientReader(ctx, S3Options{Region: record.AWSRegion}, bucket, key)
+	if err != nil {
+		return fmt.Errorf("failed to open s3://%s/%s: %v", bucket, key, err)
+	}
+
+	// Objects uploaded straight to an archive tier are skipped or restored
+	archived, err := requestArchived(nil)
+	if err != nil {
+		return err
+	}
+	handleArchivedObject(ctx, reader, archived)
+
+	tags := buildScanTags(sourceS3, getCustomTags(), "file_type="+path.Ext(key), "trigger=sqs")
+	ctx, scanClient, _ := scanPolicyClient(ctx, scannerClient, ScanTarget{
+		Source: sourceS3, Bucket: reader.bucket, Key: key, DetectedType: reader.sniff(), Size: reader.size,
+	}, ScanOptions{})
+	scanStart := time.Now()
+	scanResult, _, err := scanUnlessListed(ctx, nil, "", key, "", func() (string, error) {
+		return scanS3Reader(ctx, scanClient, reader, tags)
+	})
+	if err != nil {
+		return fmt.Errorf("scan failed for s3://%s/%s: %v", bucket, key, err)
+	}
+
+	verdict, err := parseScanVerdict(scanResult)
+	if err != nil {
+		return err
+	}
+	if verdict.IsSafe {
+		s3Logger.Printf("SQS worker: s3://%s/%s is clean", bucket, key)
+	} else {
+		s3Logger.Printf("SQS worker: THREAT DETECTED in s3://%s/%s: %v", bucket, key, verdict.MalwareNames)
+	}
+	reportScanOutcome(ctx, ScanOutcome{
+		Source:     sourceS3,
+		Bucket:     bucket,
+		Key:        key,
+		ETag:       reader.etag,
+		VersionID:  reader.versionID,
+		Identifier: reader.Identifier(),
+		Tags:       tags,
+		Verdict:    verdict,
+		Duration:   time.Since(scanStart),
+		Size:       reader.size,
+	})
+
+	// Event-driven scans only follow the per-bucket remediation rules
+	if !verdict.IsSafe {
+		if policy, rule, ok := resolveRemediation(ctx, nil, reader.bucket, key); ok {
+			remediateS3Object(ctx, reader.client, reader.scannedObject(), policy, rule)
+		}
+	}
+	return nil
+}
@@ -1,7 +1,11 @@
 #!/bin/sh
 
 # Start the scanner in the background
-./scanner &
+if [ -n "$SCANNER_CONFIG_FILE" ]; then
+    ./scanner --config "$SCANNER_CONFIG_FILE" &
+else
+    ./scanner &
+fi
 
 # Start the Node.js application
 node server.js
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// The scanning SDK rejects requests with more than 8 tags, or tags longer
+// than 63 characters
+const (
+	maxScanTags      = 8
+	maxScanTagLength = 63
+)
+
+// Scan sources reported in the source= tag
+const (
+	sourceUpload     = "upload"
+	sourceS3         = "s3"
+	sourceAzure      = "azure"
+	sourceGCS        = "gcs"
+	sourceDrive      = "gdrive"
+	sourceGraph      = "msgraph"
+	sourceDropbox    = "dropbox"
+	sourceURL        = "url"
+	sourceFilesystem = "filesystem"
+	sourceClamd      = "clamd"
+	sourceMilter     = "milter"
+)
+
+// normalizeTag converts legacy key:value tags to the key=value convention and
+// cuts them to the SDK tag length
+func normalizeTag(tag string) string {
+	tag = strings.TrimSpace(tag)
+	if !strings.Contains(tag, "=") {
+		tag = strings.Replace(tag, ":", "=", 1)
+	}
+	if len(tag) > maxScanTagLength {
+		tag = tag[:maxScanTagLength]
+	}
+	return tag
+}
+
+// buildScanTags returns the tags sent with every scan: app=finguard, source=<source>
+// and the configured custom tags, followed by path-specific extras. The extras
+// keep their slots under the SDK tag limit; custom tags fill the remaining ones.
+func buildScanTags(source string, customTags []string, extras ...string) []string {
+	tags := []string{"app=finguard", "source=" + source}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		seen[tag] = true
+	}
+
+	var pathTags []string
+	for _, tag := range extras {
+		if tag = normalizeTag(tag); tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		if len(tags)+len(pathTags) >= maxScanTags {
+			log.Printf("Warning: tag %q dropped, tag limit of %d reached", tag, maxScanTags)
+			continue
+		}
+		pathTags = append(pathTags, tag)
+	}
+
+	for _, tag := range customTags {
+		if tag = normalizeTag(tag); tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		if len(tags)+len(pathTags) >= maxScanTags {
+			log.Printf("Warning: custom tag %q dropped, tag limit of %d reached", tag, maxScanTags)
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	return append(tags, pathTags...)
+}
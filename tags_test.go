@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildScanTags(t *testing.T) {
+	uploadExtras := []string{"file_type=.pdf", "scan_method=buffer", "ml_enabled=true", "spn_feedback=false", "active_content=true"}
+	long := "team=" + strings.Repeat("x", 80)
+	tests := []struct {
+		name       string
+		source     string
+		customTags []string
+		extras     []string
+		want       []string
+	}{
+		{
+			"upload", sourceUpload, []string{"env=prod"}, uploadExtras,
+			append([]string{"app=finguard", "source=upload", "env=prod"}, uploadExtras...),
+		},
+		{
+			"s3", sourceS3, []string{"env=prod"}, []string{"file_type=.zip"},
+			[]string{"app=finguard", "source=s3", "env=prod", "file_type=.zip"},
+		},
+		{
+			"url", sourceURL, []string{"env=prod"}, []string{"host=example.com"},
+			[]string{"app=finguard", "source=url", "env=prod", "host=example.com"},
+		},
+		{
+			"legacy key:value tags are normalized", sourceS3, []string{" env:prod ", "url:http://x"}, []string{"case:42"},
+			[]string{"app=finguard", "source=s3", "env=prod", "url=http://x", "case=42"},
+		},
+		{
+			"duplicates are sent once", sourceS3, []string{"env=prod", "env:prod", "", "source=s3"}, []string{"env=prod"},
+			[]string{"app=finguard", "source=s3", "env=prod"},
+		},
+		{
+			"path tags keep their slots over custom tags", sourceUpload, []string{"env=prod", "team=a", "cost=b"}, uploadExtras,
+			append([]string{"app=finguard", "source=upload", "env=prod"}, uploadExtras...),
+		},
+		{
+			"path tags beyond the limit are dropped", sourceS3, nil, []string{"a=1", "b=2", "c=3", "d=4", "e=5", "f=6", "g=7"},
+			[]string{"app=finguard", "source=s3", "a=1", "b=2", "c=3", "d=4", "e=5", "f=6"},
+		},
+		{
+			"long tags are cut", sourceS3, []string{long}, nil,
+			[]string{"app=finguard", "source=s3", long[:maxScanTagLength]},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildScanTags(tt.source, tt.customTags, tt.extras...)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildScanTags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Every scan goes out with the unified base tags
+func TestS3ScanSendsBaseTags(t *testing.T) {
+	t.Setenv("FSS_CUSTOM_TAGS", "env:prod,team=security")
+	scanner, backend := newRecordingTestScanner(t)
+	endpoint := newFakeS3(map[string]string{"bucket/docs/report.pdf": "hello"}).serve(t)
+
+	scanS3(t, scanner, map[string]interface{}{"bucket": "bucket", "key": "docs/report.pdf", "endpointUrl": endpoint, "tags": []string{"case:42"}})
+
+	scans := backend.scannedTags()
+	if len(scans) != 1 {
+		t.Fatalf("got %d scans, want 1", len(scans))
+	}
+	want := []string{"app=finguard", "source=s3", "env=prod", "team=security", "case=42", "file_type=.pdf", "detected_type=text/plain"}
+	if !reflect.DeepEqual(scans[0], want) {
+		t.Errorf("scan tags = %q, want %q", scans[0], want)
+	}
+}
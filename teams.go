@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const defaultTeamsMaxRetries = 3
+
+// teamsNotifier posts detections to a Microsoft Teams channel as Adaptive
+// Cards through an incoming webhook or a Workflows webhook. Cards for S3
+// objects carry a button that quarantines the object through a signed link.
+type teamsNotifier struct {
+	webhookURL string
+	resultURL  string
+	maxRetries int
+	client     *http.Client
+}
+
+// newTeamsNotifier creates a notifier posting to webhookURL (TEAMS_WEBHOOK_URL)
+func newTeamsNotifier(webhookURL string) (*teamsNotifier, error) {
+	if !strings.HasPrefix(webhookURL, "https://") {
+		return nil, fmt.Errorf("invalid TEAMS_WEBHOOK_URL, expected an https:// webhook URL")
+	}
+	return &teamsNotifier{
+		webhookURL: webhookURL,
+		resultURL:  getEnv("TEAMS_RESULT_URL", ""),
+		maxRetries: getEnvInt("TEAMS_MAX_RETRIES", defaultTeamsMaxRetries),
+		client:     &http.Client{Timeout: notifyTimeout},
+	}, nil
+}
+
+func (n *teamsNotifier) Name() string {
+	return "teams"
+}
+
+// Notify posts one card for the detection, retrying network errors, 429 and
+// 5xx responses with backoff
+func (n *teamsNotifier) Notify(ctx context.Context, event DetectionEvent) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     n.card(event),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	return retryWithBackoff(n.maxRetries, func() (bool, error) {
+		return n.post(ctx, body)
+	})
+}
+
+// post sends one request and reports whether a failure is worth retrying
+func (n *teamsNotifier) post(ctx context.Context, body []byte) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// card builds the Adaptive Card for a detection
+func (n *teamsNotifier) card(event DetectionEvent) map[string]interface{} {
+	malware := strings.Join(event.MalwareNames, ", ")
+	if malware == "" {
+		malware = "unknown"
+	}
+	facts := []map[string]string{
+		{"title": "Malware", "value": malware},
+		{"title": "Severity", "value": event.Severity},
+		{"title": "Source", "value": event.Source},
+	}
+	if event.ScanID != "" {
+		facts = append(facts, map[string]string{"title": "Scan ID", "value": event.ScanID})
+	}
+	facts = append(facts, map[string]string{"title": "Detected", "value": event.DetectedAt})
+
+	var actions []map[string]interface{}
+	if event.Source == sourceS3 {
+		if link := quarantineLink(event.Bucket, event.Key); link != "" {
+			actions = append(actions, map[string]interface{}{"type": "Action.OpenUrl", "title": "Quarantine object", "url": link, "style": "destructive"})
+		}
+	}
+	if link := detectionLink(n.resultURL, event); link != "" {
+		actions = append(actions, map[string]interface{}{"type": "Action.OpenUrl", "title": "View result", "url": link})
+	}
+
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body": []map[string]interface{}{
+			{"type": "TextBlock", "text": "Malware detected", "size": "Large", "weight": "Bolder", "color": "Attention"},
+			{"type": "TextBlock", "text": event.Identifier, "wrap": true, "fontType": "Monospace"},
+			{"type": "FactSet", "facts": facts},
+		},
+		"msteams": map[string]string{"width": "Full"},
+	}
+	if len(actions) > 0 {
+		card["actions"] = actions
+	}
+	return card
+}
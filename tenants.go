@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+	"golang.org/x/time/rate"
+)
+
+const tenantHeader = "X-Tenant"
+
+// errTenantQuotaExceeded is returned when a tenant has used its daily scans
+var errTenantQuotaExceeded = errors.New("tenant daily scan quota exceeded")
+
+// Tenant is one business unit served by the deployment. Tenants with their
+// own FSS credentials or scanner address scan on a dedicated client; the
+// others share the default scanner but keep their tags, quotas and results.
+type Tenant struct {
+	Name         string   `json:"name"`
+	Clients      []string `json:"clients"` // API key clients and JWT subjects
+	FSSAPIKey    string   `json:"fssApiKey"`
+	FSSAPIKeyEnv string   `json:"fssApiKeyEnv"` // variable holding the FSS API key
+	Region       string   `json:"region"`
+	ExternalAddr string   `json:"externalAddr"`
+	UseTLS       bool     `json:"useTLS"`
+	Tags         []string `json:"tags"`
+	RateLimitRPS float64  `json:"rateLimitRps"`
+	RateBurst    int      `json:"rateLimitBurst"`
+	DailyScans   int      `json:"dailyScans"`
+
+	client  *amaasclient.AmaasClient
+	limiter *rate.Limiter
+
+	mu       sync.Mutex
+	day      string
+	dayScans int
+}
+
+// TenantRegistry resolves the tenant of each request
+type TenantRegistry struct {
+	tenants  map[string]*Tenant
+	byClient map[string][]*Tenant
+}
+
+// tenantRegistry is nil unless TENANTS_FILE is set
+var tenantRegistry *TenantRegistry
+
+type tenantKey struct{}
+
+// loadTenants reads TENANTS_FILE, a JSON array of tenants, and creates the
+// scanner client of every tenant with its own credentials
+func loadTenants(path string) (*TenantRegistry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []*Tenant
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", path, err)
+	}
+
+	registry := &TenantRegistry{tenants: make(map[string]*Tenant), byClient: make(map[string][]*Tenant)}
+	for _, tenant := range list {
+		if tenant.Name == "" {
+			return nil, fmt.Errorf("%s: tenant name is required", path)
+		}
+		if _, ok := registry.tenants[tenant.Name]; ok {
+			return nil, fmt.Errorf("%s: duplicate tenant %q", path, tenant.Name)
+		}
+		if err := tenant.init(); err != nil {
+			return nil, fmt.Errorf("tenant %q: %v", tenant.Name, err)
+		}
+		registry.tenants[tenant.Name] = tenant
+		for _, client := range tenant.Clients {
+			registry.byClient[client] = append(registry.byClient[client], tenant)
+		}
+		log.Printf("- Tenant %s: %d client(s), dedicated scanner: %v", tenant.Name, len(tenant.Clients), tenant.client != nil)
+	}
+	return registry, nil
+}
+
+// init creates the tenant scanner client and rate limiter
+func (t *Tenant) init() error {
+	if t.RateLimitRPS > 0 {
+		burst := t.RateBurst
+		if burst <= 0 {
+			burst = int(math.Ceil(t.RateLimitRPS * 2))
+		}
+		t.limiter = rate.NewLimiter(rate.Limit(t.RateLimitRPS), burst)
+	}
+
+	apiKey := t.FSSAPIKey
+	if t.FSSAPIKeyEnv != "" {
+		apiKey = os.Getenv(t.FSSAPIKeyEnv)
+		if apiKey == "" {
+			return fmt.Errorf("%s is not set", t.FSSAPIKeyEnv)
+		}
+	}
+	var err error
+	switch {
+	case t.ExternalAddr != "":
+		t.client, err = amaasclient.NewClientInternal(apiKey, t.ExternalAddr, t.UseTLS, "")
+	case apiKey != "":
+		region := t.Region
+		if region == "" {
+			region = getEnv("FSS_REGION", "us-1")
+		}
+		t.client, err = amaasclient.NewClient(apiKey, region)
+	case t.Region != "":
+		return fmt.Errorf("region requires fssApiKey or fssApiKeyEnv")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create scanner client: %v", err)
+	}
+	return nil
+}
+
+// resolve returns the tenant for a request. Callers listed in a tenant act
+// for it, and may pick among several tenants with X-Tenant. Callers that
+// belong to no tenant may act for any tenant through X-Tenant.
+func (tr *TenantRegistry) resolve(caller, requested string) (*Tenant, int, error) {
+	member := tr.byClient[caller]
+	if requested == "" {
+		if len(member) == 0 {
+			return nil, 0, nil
+		}
+		return member[0], 0, nil
+	}
+
+	tenant, ok := tr.tenants[requested]
+	if !ok {
+		return nil, http.StatusBadRequest, fmt.Errorf("Unknown tenant %q", requested)
+	}
+	if len(member) == 0 {
+		return tenant, 0, nil
+	}
+	for _, t := range member {
+		if t == tenant {
+			return tenant, 0, nil
+		}
+	}
+	return nil, http.StatusForbidden, fmt.Errorf("Caller is not a member of tenant %q", requested)
+}
+
+// get returns the named tenant, or nil
+func (tr *TenantRegistry) get(name string) *Tenant {
+	if tr == nil || name == "" {
+		return nil
+	}
+	return tr.tenants[name]
+}
+
+// Wrap stores the caller's tenant in the request context and applies the
+// tenant rate limit. It runs after authentication so it can map the caller.
+// Health probes are not tenant-scoped.
+func (tr *TenantRegistry) Wrap(next http.Handler) http.Handler {
+	if tr == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tenant, status, err := tr.resolve(callerFrom(r.Context()), r.Header.Get(tenantHeader))
+		if err != nil {
+			loggerFrom(r.Context()).Warn("tenant rejected", "client", callerFrom(r.Context()), "path", r.URL.Path, "error", err.Error())
+			writeJSONError(w, status, err.Error(), tenantHeader)
+			return
+		}
+		if tenant == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if tenant.limiter != nil {
+			reservation := tenant.limiter.Reserve()
+			if delay := reservation.Delay(); delay > 0 {
+				reservation.Cancel()
+				loggerFrom(r.Context()).Warn("tenant rate limit exceeded", "tenant", tenant.Name, "path", r.URL.Path)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				writeJSONError(w, http.StatusTooManyRequests, "Tenant rate limit exceeded", "")
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), tenant)))
+	})
+}
+
+// withTenant marks ctx as acting for tenant
+func withTenant(ctx context.Context, tenant *Tenant) context.Context {
+	if tenant == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantFrom returns the tenant stored in ctx, or nil
+func tenantFrom(ctx context.Context) *Tenant {
+	tenant, _ := ctx.Value(tenantKey{}).(*Tenant)
+	return tenant
+}
+
+// tenantName returns the name of the tenant in ctx, or "" without one
+func tenantName(ctx context.Context) string {
+	if tenant := tenantFrom(ctx); tenant != nil {
+		return tenant.Name
+	}
+	return ""
+}
+
+// tenantScoped prefixes key with the tenant in ctx so cached and stored
+// results are never shared between tenants
+func tenantScoped(ctx context.Context, key string) string {
+	if name := tenantName(ctx); name != "" {
+		return "tenant:" + name + "|" + key
+	}
+	return key
+}
+
+// tenantOwns reports whether the tenant in ctx may see a job or schedule
+// owned by owner. Requests without a tenant see everything.
+func tenantOwns(ctx context.Context, owner string) bool {
+	name := tenantName(ctx)
+	return name == "" || name == owner
+}
+
+// allowScan counts a scan against the tenant's daily quota. Counts are kept
+// per replica and reset at midnight UTC.
+func (t *Tenant) allowScan() error {
+	if t == nil || t.DailyScans <= 0 {
+		return nil
+	}
+	today := time.Now().UTC().Format(time.DateOnly)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.day != today {
+		t.day = today
+		t.dayScans = 0
+	}
+	if t.dayScans >= t.DailyScans {
+		return errTenantQuotaExceeded
+	}
+	t.dayScans++
+	return nil
+}
+
+// scanTags adds tenant=<name> and the tenant tags to tags, within the SDK tag limit
+func (t *Tenant) scanTags(tags []string) []string {
+	if t == nil {
+		return tags
+	}
+	merged := append([]string{}, tags...)
+	seen := map[string]bool{}
+	for _, tag := range merged {
+		seen[tag] = true
+	}
+	for _, tag := range append([]string{"tenant=" + t.Name}, t.Tags...) {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(merged) >= maxScanTags {
+			log.Printf("Warning: tenant %s tag %q dropped, tag limit of %d reached", t.Name, tag, maxScanTags)
+			continue
+		}
+		seen[tag] = true
+		merged = append(merged, tag)
+	}
+	return merged
+}
+
+// writeTenantQuotaExceeded answers a scan over the tenant's daily quota,
+// asking the client to retry after midnight UTC
+func writeTenantQuotaExceeded(w http.ResponseWriter) {
+	now := time.Now().UTC()
+	midnight := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(midnight.Sub(now).Seconds()))))
+	writeJSONError(w, http.StatusTooManyRequests, "Tenant daily scan quota exceeded", "")
+}
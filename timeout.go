@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+const (
+	defaultScanTimeout    = 5 * time.Minute
+	defaultScanTimeoutMax = 30 * time.Minute
+	scanTimeoutHeader     = "X-Scan-Timeout"
+)
+
+// Stages reported when a scan runs out of time
+const (
+	scanStageQueued   = "queued"   // waiting for a scan slot
+	scanStageScanning = "scanning" // inside the scanner call
+)
+
+// ScanTimeoutError is returned by observeScan when the per-scan deadline
+// expires. It matches context.DeadlineExceeded with errors.Is.
+type ScanTimeoutError struct {
+	Timeout  time.Duration
+	Elapsed  time.Duration
+	Stage    string
+	Attempts int
+	Err      error // last scanner error, if the call had started
+}
+
+func (e *ScanTimeoutError) Error() string {
+	return fmt.Sprintf("scan timed out after %s while %s", e.Timeout, e.Stage)
+}
+
+func (e *ScanTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+type scanTimeoutKey struct{}
+
+// withScanTimeout overrides SCAN_TIMEOUT for scans run under ctx
+func withScanTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, scanTimeoutKey{}, timeout)
+}
+
+// scanTimeoutFor returns the per-scan deadline for ctx: the X-Scan-Timeout
+// override when present, otherwise SCAN_TIMEOUT
+func scanTimeoutFor(ctx context.Context) time.Duration {
+	if timeout, ok := ctx.Value(scanTimeoutKey{}).(time.Duration); ok {
+		return timeout
+	}
+	timeout, err := time.ParseDuration(getEnv("SCAN_TIMEOUT", defaultScanTimeout.String()))
+	if err != nil || timeout <= 0 {
+		return defaultScanTimeout
+	}
+	return timeout
+}
+
+// parseScanTimeout reads X-Scan-Timeout as a Go duration or a number of
+// seconds. Values above SCAN_TIMEOUT_MAX are rejected.
+func parseScanTimeout(value string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid %s %q, expected a duration such as 90s", scanTimeoutHeader, value)
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	limit, err := time.ParseDuration(getEnv("SCAN_TIMEOUT_MAX", defaultScanTimeoutMax.String()))
+	if err != nil || limit <= 0 {
+		limit = defaultScanTimeoutMax
+	}
+	if timeout < time.Second || timeout > limit {
+		return 0, fmt.Errorf("%s must be between 1s and %s", scanTimeoutHeader, limit)
+	}
+	return timeout, nil
+}
+
+// scanTimeoutFromHeader applies the X-Scan-Timeout request header to the
+// scans made while serving the request
+func scanTimeoutFromHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if value := r.Header.Get(scanTimeoutHeader); value != "" {
+			timeout, err := parseScanTimeout(value)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error(), scanTimeoutHeader)
+				return
+			}
+			r = r.WithContext(withScanTimeout(r.Context(), timeout))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeScanTimeout sends 504 with what is known about a scan that ran out
+// of time and reports whether err was a timeout
+func writeScanTimeout(w http.ResponseWriter, ctx context.Context, err error) bool {
+	var timeout *ScanTimeoutError
+	if !errors.As(err, &timeout) {
+		return false
+	}
+	response := ScanTimeoutResponse{
+		Error:     "Scan timed out",
+		Stage:     timeout.Stage,
+		Timeout:   timeout.Timeout.String(),
+		ElapsedMs: latencyMs(timeout.Elapsed),
+		Attempts:  timeout.Attempts,
+		RequestID: requestIDFrom(ctx),
+	}
+	if timeout.Err != nil {
+		response.LastError = timeout.Err.Error()
+	}
+	log.Printf("Scan timed out after %s while %s (attempts: %d)", timeout.Timeout, timeout.Stage, timeout.Attempts)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusGatewayTimeout)
+	json.NewEncoder(w).Encode(response)
+	return true
+}
+
+// contextBinder is implemented by readers that issue network requests from
+// ReadBytes, so those requests run under the scan's deadline
+type contextBinder interface {
+	bindContext(ctx context.Context)
+}
+
+// scanReader scans reader under ctx on the selected backend
+func scanReader(ctx context.Context, scannerClient *amaasclient.AmaasClient, reader amaasclient.AmaasClientReader, tags []string) (string, error) {
+	// Local files are hashed before scanning; remote readers are checked by name
+	var sum func() string
+	if file, ok := reader.(*FileReader); ok {
+		sum = file.sum256
+	}
+	target := ScanTarget{Key: reader.Identifier(), Size: -1}
+	if targeter, ok := reader.(scanTargeter); ok {
+		target = targeter.scanTarget()
+	} else if size, err := reader.DataSize(); err == nil {
+		target.Size = size
+	}
+	if limit := scanTruncationFrom(ctx); limit > 0 {
+		reader = &truncatedReader{AmaasClientReader: reader, limit: limit}
+	}
+	if binder, ok := reader.(contextBinder); ok {
+		binder.bindContext(ctx)
+	}
+	detected := detectedTypeFrom(ctx)
+	if detected == "" {
+		detected = sniffReader(reader)
+	}
+	ctx = withDetectedType(ctx, detected)
+	target.DetectedType = detected
+	ctx, scannerClient = funnelScanPolicy(ctx, scannerClient, target)
+	if result, ok := scanListed(ctx, reader.Identifier(), sum); ok {
+		return result, nil
+	}
+	tags = detectedTypeTags(tenantFrom(ctx).scanTags(tags), detected)
+	return scanDetected(detected, func() (string, error) {
+		return scannerPool.scan(ctx, scannerClient, func(client *amaasclient.AmaasClient) (string, error) {
+			return client.ScanReaderWithContext(ctx, reader, tags)
+		})
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// TLSSettings are the certificate paths for the HTTPS listener. When ClientCA
+// is set, callers must present a certificate signed by it (mTLS). SelfSigned
+// generates a certificate at startup, written to CertFile/KeyFile when those
+// are set but do not exist yet.
+type TLSSettings struct {
+	CertFile   string
+	KeyFile    string
+	ClientCA   string
+	SelfSigned bool
+}
+
+// tlsSettingsFromEnv reads SCANNER_TLS_CERT, SCANNER_TLS_KEY, SCANNER_TLS_CLIENT_CA
+// and SCANNER_TLS_SELF_SIGNED
+func tlsSettingsFromEnv() TLSSettings {
+	return TLSSettings{
+		CertFile:   os.Getenv("SCANNER_TLS_CERT"),
+		KeyFile:    os.Getenv("SCANNER_TLS_KEY"),
+		ClientCA:   os.Getenv("SCANNER_TLS_CLIENT_CA"),
+		SelfSigned: os.Getenv("SCANNER_TLS_SELF_SIGNED") == "true",
+	}
+}
+
+// Enabled reports whether the listener should serve TLS
+func (s TLSSettings) Enabled() bool {
+	return s.CertFile != "" || s.KeyFile != "" || s.ClientCA != "" || s.SelfSigned
+}
+
+// buildTLSConfig loads the server certificate and, for mTLS, the client CA pool.
+// Client certificates are verified when presented; requireClientCert enforces
+// them per path so health probes keep working without one.
+func (s TLSSettings) buildTLSConfig() (*tls.Config, error) {
+	cert, err := s.loadCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if s.ClientCA != "" {
+		caPEM, err := os.ReadFile(s.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", s.ClientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// loadCertificate reads the configured key pair, generating a self-signed one
+// when enabled and the files are missing
+func (s TLSSettings) loadCertificate() (tls.Certificate, error) {
+	if s.SelfSigned && (s.CertFile == "" || s.KeyFile == "" || !fileExists(s.CertFile)) {
+		certPEM, keyPEM, err := generateSelfSignedCert()
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to generate self-signed certificate: %v", err)
+		}
+		if s.CertFile != "" && s.KeyFile != "" {
+			if err := os.WriteFile(s.CertFile, certPEM, 0644); err != nil {
+				return tls.Certificate{}, err
+			}
+			if err := os.WriteFile(s.KeyFile, keyPEM, 0600); err != nil {
+				return tls.Certificate{}, err
+			}
+			log.Printf("- TLS: wrote self-signed certificate to %s", s.CertFile)
+		} else {
+			log.Printf("- TLS: using in-memory self-signed certificate")
+		}
+		return tls.X509KeyPair(certPEM, keyPEM)
+	}
+
+	if s.CertFile == "" || s.KeyFile == "" {
+		return tls.Certificate{}, fmt.Errorf("SCANNER_TLS_CERT and SCANNER_TLS_KEY are both required for TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load server certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// generateSelfSignedCert creates a one-year ECDSA certificate for localhost and
+// the container hostname
+func generateSelfSignedCert() ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dnsNames := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "localhost" {
+		dnsNames = append(dnsNames, hostname)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "finguard-scanner", Organization: []string{"FinGuard"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1"), net.IPv6loopback},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// requireClientCert rejects requests without a verified client certificate,
+// except for public health paths
+func requireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			slog.Warn("client certificate required",
+				"request_id", requestIDFrom(r.Context()),
+				"remote_addr", r.RemoteAddr,
+				"path", r.URL.Path,
+			)
+			writeJSONError(w, http.StatusUnauthorized, "Client certificate required", "")
+			return
+		}
+		slog.Info("client certificate accepted",
+			"request_id", requestIDFrom(r.Context()),
+			"subject", r.TLS.VerifiedChains[0][0].Subject.String(),
+			"path", r.URL.Path,
+		)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// tracer is used for the scan and storage spans; it is a no-op until initTracing
+// installs an exporting provider
+var tracer = otel.Tracer("finguard/scanner")
+
+// initTracing installs the W3C trace context propagator so incoming traceparent
+// headers are honoured, and exports spans over OTLP/HTTP when
+// OTEL_EXPORTER_OTLP_ENDPOINT (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) is set.
+// The exporter reads the standard OTEL_* variables. The returned function
+// flushes pending spans.
+func initTracing(ctx context.Context) func(context.Context) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		log.Printf("Warning: tracing disabled, failed to create OTLP exporter: %v", err)
+		return func(context.Context) error { return nil }
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(getEnv("OTEL_SERVICE_NAME", "finguard-scanner")),
+	))
+	if err != nil {
+		res = resource.Default()
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	tracer = provider.Tracer("finguard/scanner")
+	log.Printf("- Tracing: OTLP export enabled")
+	return provider.Shutdown
+}
+
+// traceHandler wraps the HTTP server so every request gets a server span
+// parented on the caller's traceparent
+func traceHandler(h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, "finguard",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + routeName(r.URL.Path)
+		}),
+	)
+}
+
+// routeName collapses per-resource paths so span names stay low cardinality
+func routeName(urlPath string) string {
+	if strings.HasPrefix(urlPath, "/jobs/") {
+		_, action, _ := strings.Cut(strings.TrimPrefix(urlPath, "/jobs/"), "/")
+		switch action = strings.Trim(action, "/"); action {
+		case "events", jobActionPause, jobActionResume, jobActionCancel:
+			return "/jobs/{id}/" + action
+		}
+		return "/jobs/{id}"
+	}
+	if strings.HasPrefix(urlPath, "/schedules/") {
+		return "/schedules/{id}"
+	}
+	return urlPath
+}
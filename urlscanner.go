@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// Timeout for each request made while reading a URL
+const urlFetchTimeout = 2 * time.Minute
+
+// errURLTooLarge is returned when a server without range support sends more than the size cap
+var errURLTooLarge = errors.New("content exceeds the maximum size")
+
+// HTTPURLReader implements AmaasClientReader for content behind an HTTP(S)
+// URL such as a presigned S3 URL. Servers that support range requests are
+// read on demand; otherwise the body is downloaded once, up to maxSize.
+type HTTPURLReader struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+	size   int64
+	data   []byte // full body when the server ignores Range
+}
+
+// NewHTTPURLReader probes the URL with a single-byte range request, which
+// works for presigned GET URLs that cannot be used with HEAD. Internal and
+// metadata addresses are refused, including after redirects.
+func NewHTTPURLReader(ctx context.Context, rawURL string, maxSize int64) (*HTTPURLReader, error) {
+	return newHTTPURLReader(ctx, newGuardedHTTPClient(urlFetchTimeout), rawURL, maxSize)
+}
+
+// newHTTPURLReader is NewHTTPURLReader with a caller-supplied HTTP client
+func newHTTPURLReader(ctx context.Context, client *http.Client, rawURL string, maxSize int64) (*HTTPURLReader, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if err := checkOutboundHost(u.Hostname()); err != nil {
+		return nil, err
+	}
+
+	reader := &HTTPURLReader{
+		ctx:    ctx,
+		client: client,
+		url:    rawURL,
+	}
+
+	resp, err := reader.get(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		size, err := parseContentRangeSize(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, err
+		}
+		reader.size = size
+	case http.StatusOK:
+		// No range support: keep the whole body in memory
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %s: %v", reader.Identifier(), err)
+		}
+		if int64(len(data)) > maxSize {
+			return nil, errURLTooLarge
+		}
+		reader.data = data
+		reader.size = int64(len(data))
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Empty content
+		reader.data = []byte{}
+	default:
+		return nil, fmt.Errorf("fetching %s returned HTTP %d", reader.Identifier(), resp.StatusCode)
+	}
+	return reader, nil
+}
+
+// parseContentRangeSize reads the total size from "bytes 0-0/<size>"
+func parseContentRangeSize(contentRange string) (int64, error) {
+	_, total, ok := strings.Cut(contentRange, "/")
+	if !ok || total == "*" {
+		return 0, fmt.Errorf("server did not report the content size (Content-Range %q)", contentRange)
+	}
+	return strconv.ParseInt(total, 10, 64)
+}
+
+// get issues a ranged GET for bytes first..last
+func (r *HTTPURLReader) get(first, last int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", first, last))
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", r.Identifier(), err)
+	}
+	return resp, nil
+}
+
+// Identifier returns the URL without its query string, so presigned
+// signatures never reach logs or scan results
+func (r *HTTPURLReader) Identifier() string {
+	u, err := url.Parse(r.url)
+	if err != nil {
+		return "url"
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	u.User = nil
+	return u.String()
+}
+
+// DataSize returns the content length
+func (r *HTTPURLReader) DataSize() (int64, error) {
+	return r.size, nil
+}
+
+// bindContext runs the range requests of a scan under its context
+func (r *HTTPURLReader) bindContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// ReadBytes reads length bytes at offset with a range request
+func (r *HTTPURLReader) ReadBytes(offset int64, length int32) ([]byte, error) {
+	if r.data != nil {
+		end := min(offset+int64(length), int64(len(r.data)))
+		if offset >= end {
+			return nil, io.EOF
+		}
+		return r.data[offset:end], nil
+	}
+
+	resp, err := r.get(offset, offset+int64(length)-1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("range request for %s returned HTTP %d", r.Identifier(), resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, int64(length)))
+}
+
+// HTTP handler that scans the content behind a presigned or public URL
+func handleScanURL(scannerClient *amaasclient.AmaasClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ScanURLRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON request body", "")
+			return
+		}
+		if req.URL == "" {
+			writeJSONError(w, http.StatusBadRequest, "Missing required field: url", "url")
+			return
+		}
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), "callbackUrl")
+			return
+		}
+
+		ctx := r.Context()
+		maxSize := getMaxUploadSize()
+		reader, err := NewHTTPURLReader(ctx, req.URL, maxSize)
+		if errors.Is(err, errURLTooLarge) {
+			writeUploadTooLarge(w, maxSize)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to open URL for scanning: %v", err)
+			writeURLFetchError(w, err)
+			return
+		}
+
+		serveURLScan(ctx, w, scannerClient, reader, buildScanTags(sourceURL, getCustomTags(), req.Tags...), req.CallbackURL, wantsRawResult(r))
+	}
+}
+
+// writeURLFetchError answers a failed URL fetch without echoing what the
+// upstream server returned
+func writeURLFetchError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errOutboundBlocked) {
+		writeJSONError(w, http.StatusForbidden, "url resolves to an address that is not allowed", "url")
+		return
+	}
+	writeJSONError(w, http.StatusBadGateway, "Failed to fetch url", "url")
+}
+
+// serveURLScan scans an opened URL reader and writes the scan response
+func serveURLScan(ctx context.Context, w http.ResponseWriter, scannerClient *amaasclient.AmaasClient, reader *HTTPURLReader, tags []string, callbackURL string, withRaw bool) {
+	log.Printf("Starting URL scan for %s (%d bytes, ranged: %v)", reader.Identifier(), reader.size, reader.data == nil)
+	ctx, attempts := withScanAttempts(ctx)
+	scanStart := time.Now()
+	scanResult, err := observeScan(ctx, sourceURL, reader.size, func(ctx context.Context) (string, error) {
+		return scanReader(ctx, scannerClient, reader, tags)
+	})
+	if writeScanRejected(w, err) || writeScanTimeout(w, ctx, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Scan error for %s: %v", reader.Identifier(), err)
+		http.Error(w, fmt.Sprintf("Scan failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	response := ScanURLResponse{
+		Identifier: reader.Identifier(),
+		Size:       reader.size,
+		ScanResult: scanResult,
+		RequestID:  requestIDFrom(ctx),
+		Attempts:   int(attempts.Load()),
+	}
+	if verdict, err := parseScanVerdict(scanResult); err == nil {
+		reportScanOutcome(ctx, ScanOutcome{
+			Source:     sourceURL,
+			Identifier: reader.Identifier(),
+			Tags:       tags,
+			Verdict:    verdict,
+			Duration:   time.Since(scanStart),
+			Size:       reader.size,
+		})
+		normalized := verdict.Normalize(time.Since(scanStart), withRaw)
+		response.Result = &normalized
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	sendCallback(callbackURL, eventScanCompleted, response)
+}
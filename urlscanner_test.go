@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPURLReaderRefusesInternalAddresses(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("hello"))
+	}))
+	defer srv.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(srv.URL, http.StatusFound))
+	defer redirect.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		allowed string
+		blocked bool
+	}{
+		{"loopback", srv.URL, "", true},
+		{"localhost name", strings.Replace(srv.URL, "127.0.0.1", "localhost", 1), "", true},
+		{"metadata service", "http://169.254.169.254/latest/meta-data/", "", true},
+		{"allowed network", srv.URL, "127.0.0.0/8", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OUTBOUND_ALLOWED_NETWORKS", tt.allowed)
+			hits.Store(0)
+			reader, err := NewHTTPURLReader(context.Background(), tt.url, 1<<20)
+			if tt.blocked {
+				if !errors.Is(err, errOutboundBlocked) {
+					t.Fatalf("NewHTTPURLReader(%s) = %v, want %v", tt.url, err, errOutboundBlocked)
+				}
+				if hits.Load() != 0 {
+					t.Errorf("server was reached %d times", hits.Load())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewHTTPURLReader: %v", err)
+			}
+			if size, _ := reader.DataSize(); size != 5 {
+				t.Errorf("size = %d, want 5", size)
+			}
+		})
+	}
+}
+
+func TestScanURLDoesNotEchoUpstreamErrors(t *testing.T) {
+	t.Setenv("OUTBOUND_ALLOWED_NETWORKS", "127.0.0.0/8")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal secret", http.StatusTeapot)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+	}{
+		{"upstream error", srv.URL, http.StatusBadGateway},
+		{"blocked address", "http://169.254.169.254/", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			body := strings.NewReader(`{"url":"` + tt.url + `"}`)
+			handleScanURL(nil)(rec, httptest.NewRequest(http.MethodPost, "/scan/url", body))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if strings.Contains(rec.Body.String(), "418") || strings.Contains(rec.Body.String(), "secret") {
+				t.Errorf("response echoes the upstream reply: %s", rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+)
+
+// ErrorResponse is the JSON error envelope returned for rejected requests
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Field string `json:"field,omitempty"`
+}
+
+// AWS region names such as us-east-1, eu-central-2 or us-gov-west-1
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-gov|-iso[a-z]?)?-[a-z]+-\d+$`)
+
+// Region names of S3-compatible stores, such as Ceph's default or RegionOne
+var customRegionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// maxS3RequestSize bounds the JSON body of the S3 endpoints
+const maxS3RequestSize = 1 << 20
+
+const defaultMaxUploadSizeMB = 512
+
+// getMaxUploadSize returns the /scan body limit in bytes from MAX_UPLOAD_SIZE_MB
+func getMaxUploadSize() int64 {
+	mb := getEnvInt("MAX_UPLOAD_SIZE_MB", defaultMaxUploadSizeMB)
+	if mb <= 0 {
+		mb = defaultMaxUploadSizeMB
+	}
+	return int64(mb) << 20
+}
+
+// writeUploadTooLarge sends the 413 error for uploads over the limit
+func writeUploadTooLarge(w http.ResponseWriter, maxSize int64) {
+	writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload exceeds the maximum size of %d MB", maxSize>>20), "")
+}
+
+// writeJSONError sends an error envelope with the given status code
+func writeJSONError(w http.ResponseWriter, status int, message, field string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error: message,
+		Field: field,
+	})
+}
+
+// validateS3Request rejects malformed S3 request bodies before they reach the handler.
+// required lists the fields (bucket, key) that must be non-empty. The body is
+// restored so the wrapped handler can decode it as usual.
+func validateS3Request(required ...string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				next(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxS3RequestSize))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxS3RequestSize), "")
+				return
+			}
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Failed to read request body", "")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			var req struct {
+				Region string `json:"region"`
+				Bucket string `json:"bucket"`
+				Key    string `json:"key"`
+			}
+			if err := json.Unmarshal(body, &req); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid JSON request body", "")
+				return
+			}
+
+			fields := map[string]string{
+				"bucket": req.Bucket,
+				"key":    req.Key,
+			}
+			for _, field := range required {
+				if fields[field] == "" {
+					writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Missing required field: %s", field), field)
+					return
+				}
+			}
+
+			// Custom endpoints name their regions freely
+			var opts S3Options
+			json.Unmarshal(body, &opts)
+			pattern := regionPattern
+			if opts.withDefaults().EndpointURL != "" {
+				pattern = customRegionPattern
+			}
+			if err := opts.withDefaults().checkEndpoint(); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error(), "endpointUrl")
+				return
+			}
+			if req.Region != "" && !pattern.MatchString(req.Region) {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid region format: %q", req.Region), "region")
+				return
+			}
+
+			if status, field, err := checkS3Credentials(r.Context(), opts); err != nil {
+				writeJSONError(w, status, err.Error(), field)
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
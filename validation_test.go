@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateS3Request(t *testing.T) {
+	tests := []struct {
+		name       string
+		required   []string
+		body       string
+		wantStatus int
+		wantField  string
+	}{
+		{"valid scan", []string{"bucket", "key"}, `{"bucket":"b","key":"k","region":"eu-west-1"}`, http.StatusOK, ""},
+		{"empty bucket", []string{"bucket", "key"}, `{"bucket":"","key":"k"}`, http.StatusBadRequest, "bucket"},
+		{"missing bucket", []string{"bucket"}, `{"prefix":"logs/"}`, http.StatusBadRequest, "bucket"},
+		{"empty key on scan", []string{"bucket", "key"}, `{"bucket":"b","key":""}`, http.StatusBadRequest, "key"},
+		{"key not required for listing", []string{"bucket"}, `{"bucket":"b"}`, http.StatusOK, ""},
+		{"invalid region", []string{"bucket"}, `{"bucket":"b","region":"US East 1"}`, http.StatusBadRequest, "region"},
+		{"custom region without endpoint", []string{"bucket"}, `{"bucket":"b","region":"default"}`, http.StatusBadRequest, "region"},
+		{"custom region with endpoint", []string{"bucket"}, `{"bucket":"b","region":"default","endpointUrl":"http://ceph:7480"}`, http.StatusOK, ""},
+		{"metadata endpoint", []string{"bucket"}, `{"bucket":"b","endpointUrl":"http://169.254.169.254/latest"}`, http.StatusBadRequest, "endpointUrl"},
+		{"loopback endpoint", []string{"bucket"}, `{"bucket":"b","endpointUrl":"http://localhost:9000"}`, http.StatusBadRequest, "endpointUrl"},
+		{"private endpoint", []string{"bucket"}, `{"bucket":"b","endpointUrl":"http://10.0.0.8:9000"}`, http.StatusBadRequest, "endpointUrl"},
+		{"endpoint without a scheme", []string{"bucket"}, `{"bucket":"b","endpointUrl":"minio:9000"}`, http.StatusBadRequest, "endpointUrl"},
+		{"invalid JSON", []string{"bucket"}, `{"bucket":`, http.StatusBadRequest, ""},
+		{"oversized body", []string{"bucket"}, `{"bucket":"b","tags":["` + strings.Repeat("x", maxS3RequestSize) + `"]}`, http.StatusRequestEntityTooLarge, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var decoded map[string]interface{}
+			handler := validateS3Request(tt.required...)(func(w http.ResponseWriter, r *http.Request) {
+				// The body is restored for the handler
+				if err := json.NewDecoder(r.Body).Decode(&decoded); err != nil {
+					t.Errorf("handler could not decode the body: %v", err)
+				}
+			})
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodPost, "/s3/scan", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code == http.StatusOK {
+				if decoded == nil {
+					t.Error("the handler was not called")
+				}
+				return
+			}
+			var envelope ErrorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&envelope); err != nil {
+				t.Fatalf("error body is not the JSON envelope: %v", err)
+			}
+			if envelope.Field != tt.wantField {
+				t.Errorf("field = %q, want %q (%s)", envelope.Field, tt.wantField, envelope.Error)
+			}
+		})
+	}
+}
+
+// Stores that ignore the prefix may list keys shorter than it
+func TestListObjectsPrefixLongerThanKey(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("OUTBOUND_ALLOWED_NETWORKS", "127.0.0.0/8")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !r.URL.Query().Has("list-type") {
+			s3Error(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		result := listBucketResult{Contents: []struct{ Key string }{{"a"}, {"logs/"}, {"logs/2024/01/app.log"}, {"logs/2024/01/sub/x.log"}}}
+		result.KeyCount = len(result.Contents)
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(result)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		prefix   string
+		wantKeys string
+	}{
+		{"prefix longer than every key", "logs/2024/01/app.log/extra/", "a"},
+		{"prefix longer than some keys", "logs/2024/01/", "a,logs/2024/01/app.log"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]interface{}{
+				"bucket": "b", "prefix": tt.prefix, "region": "us-east-1", "endpointUrl": server.URL, "forcePathStyle": true,
+			})
+			rec := httptest.NewRecorder()
+			validateS3Request("bucket")(handleListObjects(nil))(rec, httptest.NewRequest(http.MethodPost, "/s3/objects", strings.NewReader(string(body))))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var response S3ListObjectsResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			keys := make([]string, 0, len(response.Objects))
+			for _, obj := range response.Objects {
+				keys = append(keys, obj.Key)
+			}
+			if got := strings.Join(keys, ","); got != tt.wantKeys {
+				t.Errorf("keys = %s, want %s", got, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestS3EndpointFromConfigurationIsTrusted(t *testing.T) {
+	t.Setenv("S3_ENDPOINT_URL", "http://10.0.0.8:9000")
+	tests := []struct {
+		name    string
+		opts    S3Options
+		wantErr bool
+	}{
+		{"S3_ENDPOINT_URL", S3Options{}, false},
+		{"same endpoint in the request", S3Options{EndpointURL: "http://10.0.0.8:9000"}, false},
+		{"other internal endpoint in the request", S3Options{EndpointURL: "http://10.0.0.9:9000"}, true},
+		{"public endpoint in the request", S3Options{EndpointURL: "https://s3.wasabisys.com"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.withDefaults().checkEndpoint(); (err != nil) != tt.wantErr {
+				t.Errorf("checkEndpoint = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ScanVerdict is the outcome extracted from a raw SDK scan result
+type ScanVerdict struct {
+	IsSafe       bool     `json:"isSafe"`
+	MalwareNames []string `json:"malwareNames,omitempty"`
+	ScanID       string   `json:"scanId,omitempty"`
+	FileSHA1     string   `json:"fileSha1,omitempty"`
+	FileSHA256   string   `json:"fileSha256,omitempty"`
+	// Allowlisted is set for files on the hash allowlist; their detections
+	// are moved to SuppressedMalware
+	Allowlisted       bool     `json:"allowlisted,omitempty"`
+	SuppressedMalware []string `json:"suppressedMalware,omitempty"`
+	// Blocklisted is set for files on the hash blocklist, which are malicious
+	// whatever the scanner found
+	Blocklisted bool `json:"blocklisted,omitempty"`
+	// Skipped is set for files a scan policy rule skipped, which were not
+	// scanned
+	Skipped    bool   `json:"skipped,omitempty"`
+	PolicyRule string `json:"policyRule,omitempty"`
+	// TooLarge is set with Skipped for files over the maximum scan size, and
+	// Truncated for those of which only the first ScannedBytes were scanned
+	TooLarge bool `json:"tooLarge,omitempty"`
+	// Archived is set with Skipped for archived S3 objects, in StorageClass
+	Archived     bool   `json:"archived,omitempty"`
+	StorageClass string `json:"storageClass,omitempty"`
+	Truncated    bool   `json:"truncated,omitempty"`
+	ScannedBytes int64  `json:"scannedBytes,omitempty"`
+	// DetectedType is the media type sniffed from the file's first bytes
+	DetectedType string `json:"detectedType,omitempty"`
+	// Engine metadata reported by the scanner
+	ScannerVersion string `json:"scannerVersion,omitempty"`
+	SchemaVersion  string `json:"schemaVersion,omitempty"`
+	ScanTimestamp  string `json:"scanTimestamp,omitempty"`
+
+	// engines maps malware names to the engine that found them, and raw is
+	// the SDK result the verdict was parsed from
+	engines map[string]string
+	raw     string
+}
+
+// Severities of verdicts without a detection, next to those of
+// detectionSeverity
+const (
+	severityNone    = "none"
+	severityUnknown = "unknown"
+)
+
+// NormalizedVerdict is the scan outcome returned by every scan endpoint and
+// stored in the scan history, whatever the source. Raw is the SDK result,
+// included on request.
+type NormalizedVerdict struct {
+	Verdict      string           `json:"verdict"`
+	IsSafe       bool             `json:"isSafe"`
+	Severity     string           `json:"severity"`
+	Malware      []MalwareFinding `json:"malware"`
+	Suppressed   []string         `json:"suppressedMalware,omitempty"`
+	Hashes       FileHashes       `json:"hashes"`
+	DetectedType string           `json:"detectedType,omitempty"`
+	Engine       EngineMetadata   `json:"engine"`
+	DurationMs   int64            `json:"durationMs"`
+	Blocklisted  bool             `json:"blocklisted,omitempty"`
+	PolicyRule   string           `json:"policyRule,omitempty"`
+	Truncated    bool             `json:"truncated,omitempty"`
+	ScannedBytes int64            `json:"scannedBytes,omitempty"`
+	Raw          json.RawMessage  `json:"raw,omitempty"`
+}
+
+// MalwareFinding is one detection; Engine is empty for blocklist matches
+type MalwareFinding struct {
+	Name   string `json:"name"`
+	Engine string `json:"engine,omitempty"`
+}
+
+// FileHashes are the digests of the scanned file, when known
+type FileHashes struct {
+	SHA1   string `json:"sha1,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// EngineMetadata describes the scan as reported by the scanner
+type EngineMetadata struct {
+	ScanID         string `json:"scanId,omitempty"`
+	ScannerVersion string `json:"scannerVersion,omitempty"`
+	SchemaVersion  string `json:"schemaVersion,omitempty"`
+	ScannedAt      string `json:"scannedAt,omitempty"`
+}
+
+// Normalize returns the normalized verdict of a scan that took duration,
+// with the raw SDK result when withRaw is set
+func (v ScanVerdict) Normalize(duration time.Duration, withRaw bool) NormalizedVerdict {
+	normalized := NormalizedVerdict{
+		Verdict:      v.Label(),
+		IsSafe:       v.IsSafe,
+		Severity:     v.severity(),
+		Malware:      []MalwareFinding{},
+		Suppressed:   v.SuppressedMalware,
+		Hashes:       FileHashes{SHA1: v.FileSHA1, SHA256: v.FileSHA256},
+		DetectedType: v.DetectedType,
+		Engine: EngineMetadata{
+			ScanID:         v.ScanID,
+			ScannerVersion: v.ScannerVersion,
+			SchemaVersion:  v.SchemaVersion,
+			ScannedAt:      v.ScanTimestamp,
+		},
+		DurationMs:   duration.Milliseconds(),
+		Blocklisted:  v.Blocklisted,
+		PolicyRule:   v.PolicyRule,
+		Truncated:    v.Truncated,
+		ScannedBytes: v.ScannedBytes,
+	}
+	seen := map[string]bool{}
+	for _, name := range v.MalwareNames {
+		if !seen[name] {
+			seen[name] = true
+			normalized.Malware = append(normalized.Malware, MalwareFinding{Name: name, Engine: v.engines[name]})
+		}
+	}
+	if withRaw && json.Valid([]byte(v.raw)) {
+		normalized.Raw = json.RawMessage(v.raw)
+	}
+	return normalized
+}
+
+// severity rates the verdict: none when clean, unknown when the file was
+// not scanned and as notifications do for detections
+func (v ScanVerdict) severity() string {
+	switch {
+	case v.Skipped:
+		return severityUnknown
+	case v.IsSafe:
+		return severityNone
+	}
+	return detectionSeverity(v.MalwareNames)
+}
+
+// wantsRawResult reports whether the caller asked for the raw SDK result in
+// the normalized verdict (X-Include-Raw header or raw query parameter)
+func wantsRawResult(r *http.Request) bool {
+	value := r.Header.Get("X-Include-Raw")
+	if value == "" {
+		value = r.URL.Query().Get("raw")
+	}
+	include, _ := strconv.ParseBool(value)
+	return include
+}
+
+// normalizeResult parses a raw SDK result into its normalized verdict, or
+// returns nil when it cannot be parsed
+func normalizeResult(scanResult string, duration time.Duration, withRaw bool) *NormalizedVerdict {
+	verdict, err := parseScanVerdict(scanResult)
+	if err != nil {
+		return nil
+	}
+	normalized := verdict.Normalize(duration, withRaw)
+	return &normalized
+}
+
+// Label returns the verdict recorded in results, metrics and history
+func (v ScanVerdict) Label() string {
+	if v.Skipped && v.TooLarge {
+		return verdictSkippedTooLarge
+	}
+	if v.Skipped && v.Archived {
+		return verdictArchived
+	}
+	if v.Skipped {
+		return verdictSkipped
+	}
+	if v.Allowlisted {
+		return verdictAllowlisted
+	}
+	return verdictFor(v.IsSafe)
+}
+
+// parseScanVerdict reads the SDK JSON result. A file is unsafe when scanResult
+// is non-zero, foundMalwares is non-empty, result.atse.malwareCount > 0 or its
+// SHA-256 is on the hash blocklist, unless it is on the hash allowlist.
+func parseScanVerdict(scanResult string) (ScanVerdict, error) {
+	verdict := ScanVerdict{IsSafe: true, MalwareNames: []string{}, engines: map[string]string{}, raw: scanResult}
+
+	var scanData map[string]interface{}
+	if err := json.Unmarshal([]byte(scanResult), &scanData); err != nil {
+		return verdict, fmt.Errorf("failed to parse scan result: %v", err)
+	}
+
+	verdict.ScanID, _ = scanData["scanId"].(string)
+	verdict.FileSHA1, _ = scanData["fileSHA1"].(string)
+	verdict.FileSHA256, _ = scanData["fileSHA256"].(string)
+	verdict.ScannerVersion, _ = scanData["scannerVersion"].(string)
+	verdict.SchemaVersion, _ = scanData["schemaVersion"].(string)
+	verdict.ScanTimestamp, _ = scanData["scanTimestamp"].(string)
+	verdict.Skipped, _ = scanData["skipped"].(bool)
+	verdict.PolicyRule, _ = scanData["policyRule"].(string)
+	verdict.TooLarge, _ = scanData["tooLarge"].(bool)
+	verdict.Archived, _ = scanData["archived"].(bool)
+	verdict.StorageClass, _ = scanData["storageClass"].(string)
+	verdict.DetectedType, _ = scanData["detectedType"].(string)
+	verdict.Truncated, _ = scanData["truncated"].(bool)
+	if scanned, ok := scanData["scannedBytes"].(float64); ok {
+		verdict.ScannedBytes = int64(scanned)
+	}
+
+	if code, ok := scanData["scanResult"].(float64); ok && code != 0 {
+		verdict.IsSafe = false
+	}
+
+	if foundMalwares, ok := scanData["foundMalwares"].([]interface{}); ok && len(foundMalwares) > 0 {
+		verdict.IsSafe = false
+		for _, malware := range foundMalwares {
+			if malwareMap, ok := malware.(map[string]interface{}); ok {
+				if name, ok := malwareMap["malwareName"].(string); ok {
+					verdict.MalwareNames = append(verdict.MalwareNames, name)
+					verdict.engines[name], _ = malwareMap["engine"].(string)
+				}
+			}
+		}
+	}
+
+	if result, ok := scanData["result"].(map[string]interface{}); ok {
+		if atse, ok := result["atse"].(map[string]interface{}); ok {
+			if malwareCount, ok := atse["malwareCount"].(float64); ok && malwareCount > 0 {
+				verdict.IsSafe = false
+			}
+			if malwares, ok := atse["malware"].([]interface{}); ok {
+				for _, malware := range malwares {
+					if malwareMap, ok := malware.(map[string]interface{}); ok {
+						if name, ok := malwareMap["name"].(string); ok {
+							verdict.MalwareNames = append(verdict.MalwareNames, name)
+							if verdict.engines[name] == "" {
+								verdict.engines[name] = "atse"
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// The blocklist wins over the allowlist for files on both
+	blocked, _ := scanData["blocklisted"].(bool)
+	if !applyBlocklist(&verdict, blocked) {
+		allowed, _ := scanData["allowlisted"].(bool)
+		applyAllowlist(&verdict, allowed)
+	}
+	return verdict, nil
+}
+
+// annotateResult adds fields to a raw SDK result. Results that are not JSON
+// objects are returned unchanged.
+func annotateResult(result string, fields map[string]interface{}) string {
+	var scanData map[string]interface{}
+	if err := json.Unmarshal([]byte(result), &scanData); err != nil || scanData == nil {
+		return result
+	}
+	for key, value := range fields {
+		scanData[key] = value
+	}
+	annotated, err := json.Marshal(scanData)
+	if err != nil {
+		return result
+	}
+	return string(annotated)
+}
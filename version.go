@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+const sdkModulePath = "github.com/trendmicro/tm-v1-fs-golang-sdk"
+
+// Build metadata, set with -ldflags "-X main.version=... -X main.gitCommit=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	gitCommit = ""
+	buildDate = ""
+)
+
+// VersionInfo is the body of /version
+type VersionInfo struct {
+	Version    string `json:"version"`
+	GitCommit  string `json:"gitCommit"`
+	BuildDate  string `json:"buildDate"`
+	GoVersion  string `json:"goVersion"`
+	SDKVersion string `json:"sdkVersion"`
+}
+
+// buildVersionInfo combines the ldflags values with the module build info,
+// which supplies the VCS revision and time when ldflags were not used
+func buildVersionInfo() VersionInfo {
+	info := VersionInfo{
+		Version:    version,
+		GitCommit:  gitCommit,
+		BuildDate:  buildDate,
+		GoVersion:  runtime.Version(),
+		SDKVersion: "unknown",
+	}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, dep := range build.Deps {
+		if dep.Path == sdkModulePath {
+			info.SDKVersion = dep.Version
+			if dep.Replace != nil {
+				info.SDKVersion = dep.Replace.Version
+			}
+		}
+	}
+	for _, setting := range build.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && info.GitCommit == "":
+			info.GitCommit = setting.Value
+		case setting.Key == "vcs.time" && info.BuildDate == "":
+			info.BuildDate = setting.Value
+		}
+	}
+	return info
+}
+
+// HTTP handler for build information at /version
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildVersionInfo())
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+)
+
+// Actions a watched directory applies after a scan
+const (
+	watchActionNone       = "none"       // log only
+	watchActionQuarantine = "quarantine" // move infected files to Target
+	watchActionMove       = "move"       // move clean files to Target
+	watchActionDelete     = "delete"     // delete infected files
+)
+
+const defaultWatchDebounce = 2 * time.Second
+
+// WatchDir is one directory watched for new and modified files. Inotify does
+// not see writes made by other NFS/EFS clients, so shares should also set
+// PollInterval to rescan for changed files.
+type WatchDir struct {
+	Path         string   `json:"path"`
+	Recursive    bool     `json:"recursive"`
+	Action       string   `json:"action"`
+	Target       string   `json:"target"`
+	PollInterval Duration `json:"pollInterval"`
+	Tags         []string `json:"tags"`
+	// DryRun logs the action instead of moving or deleting files
+	DryRun bool `json:"dryRun"`
+}
+
+// Duration is a time.Duration read from a JSON string such as "30s"
+type Duration time.Duration
+
+// UnmarshalJSON parses a Go duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// validate checks the action and target of a watched directory
+func (d WatchDir) validate() error {
+	if d.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	switch d.Action {
+	case watchActionNone, watchActionDelete:
+		return nil
+	case watchActionQuarantine, watchActionMove:
+		if d.Target == "" {
+			return fmt.Errorf("action %q requires a target directory", d.Action)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid action %q, expected none, quarantine, move or delete", d.Action)
+}
+
+// FileWatcher scans files written to the watched directories
+type FileWatcher struct {
+	scannerClient *amaasclient.AmaasClient
+	dirs          []WatchDir
+	debounce      time.Duration
+	watcher       *fsnotify.Watcher
+
+	mu      sync.Mutex
+	pending map[string]*time.Timer
+	seen    map[string]time.Time // modification time at the last scan
+}
+
+// loadWatchDirs reads WATCH_CONFIG_FILE, a JSON array of watched directories
+func loadWatchDirs(path string) ([]WatchDir, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []WatchDir
+	if err := json.Unmarshal(data, &dirs); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", path, err)
+	}
+	for i := range dirs {
+		if dirs[i].Action == "" {
+			dirs[i].Action = watchActionNone
+		}
+		if err := dirs[i].validate(); err != nil {
+			return nil, fmt.Errorf("watch %q: %v", dirs[i].Path, err)
+		}
+		dirs[i].Path = filepath.Clean(dirs[i].Path)
+	}
+	return dirs, nil
+}
+
+// startFileWatcher watches the directories in WATCH_CONFIG_FILE and scans
+// files once they stop changing for WATCH_DEBOUNCE
+func startFileWatcher(ctx context.Context, scannerClient *amaasclient.AmaasClient, configPath string) error {
+	dirs, err := loadWatchDirs(configPath)
+	if err != nil {
+		return err
+	}
+	debounce, err := time.ParseDuration(getEnv("WATCH_DEBOUNCE", defaultWatchDebounce.String()))
+	if err != nil || debounce <= 0 {
+		debounce = defaultWatchDebounce
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %v", err)
+	}
+	ctx = withBackgroundScan(ctx)
+	fw := &FileWatcher{
+		scannerClient: scannerClient,
+		dirs:          dirs,
+		debounce:      debounce,
+		watcher:       watcher,
+		pending:       make(map[string]*time.Timer),
+		seen:          make(map[string]time.Time),
+	}
+
+	for _, dir := range dirs {
+		if err := fw.add(dir.Path, dir.Recursive); err != nil {
+			watcher.Close()
+			return err
+		}
+		log.Printf("- Watching %s (recursive: %v, action: %s)", dir.Path, dir.Recursive, dir.Action)
+		if dir.PollInterval > 0 {
+			go fw.poll(ctx, dir)
+		}
+	}
+
+	go fw.run(ctx)
+	return nil
+}
+
+// add watches path and, when recursive, every directory below it
+func (fw *FileWatcher) add(path string, recursive bool) error {
+	if !recursive {
+		return fw.watcher.Add(path)
+	}
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && !fw.isTarget(p) {
+			return fw.watcher.Add(p)
+		}
+		return nil
+	})
+}
+
+// dirFor returns the watch configuration that covers path
+func (fw *FileWatcher) dirFor(path string) (WatchDir, bool) {
+	for _, dir := range fw.dirs {
+		rel, err := filepath.Rel(dir.Path, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if dir.Recursive || !strings.Contains(rel, string(filepath.Separator)) {
+			return dir, true
+		}
+	}
+	return WatchDir{}, false
+}
+
+// isTarget reports whether path is inside a quarantine or move target, which
+// are never scanned so moved files do not trigger new events
+func (fw *FileWatcher) isTarget(path string) bool {
+	for _, dir := range fw.dirs {
+		if dir.Target == "" {
+			continue
+		}
+		rel, err := filepath.Rel(filepath.Clean(dir.Target), path)
+		if err == nil && !strings.HasPrefix(rel, "..") {
+			return true
+		}
+	}
+	return false
+}
+
+// run dispatches fsnotify events until ctx is cancelled
+func (fw *FileWatcher) run(ctx context.Context) {
+	defer fw.watcher.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err, ok := <-fw.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("File watcher error: %v", err)
+		case event, ok := <-fw.watcher.Events:
+			if !ok {
+				return
+			}
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			if fw.isTarget(event.Name) {
+				continue
+			}
+			info, err := os.Stat(event.Name)
+			if err != nil {
+				continue
+			}
+			if info.IsDir() {
+				if dir, ok := fw.dirFor(event.Name); ok && dir.Recursive {
+					if err := fw.add(event.Name, true); err != nil {
+						log.Printf("File watcher: failed to watch %s: %v", event.Name, err)
+					}
+				}
+				continue
+			}
+			fw.schedule(ctx, event.Name)
+		}
+	}
+}
+
+// schedule scans path once no further events arrive for the debounce period
+func (fw *FileWatcher) schedule(ctx context.Context, path string) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if timer, ok := fw.pending[path]; ok {
+		timer.Reset(fw.debounce)
+		return
+	}
+	fw.pending[path] = time.AfterFunc(fw.debounce, func() {
+		fw.mu.Lock()
+		delete(fw.pending, path)
+		fw.mu.Unlock()
+		fw.scan(ctx, path)
+	})
+}
+
+// poll rescans dir every PollInterval for files changed since their last scan
+func (fw *FileWatcher) poll(ctx context.Context, dir WatchDir) {
+	ticker := time.NewTicker(time.Duration(dir.PollInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		filepath.WalkDir(dir.Path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if p != dir.Path && (!dir.Recursive || fw.isTarget(p)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			fw.mu.Lock()
+			last, scanned := fw.seen[p]
+			fw.mu.Unlock()
+			if !scanned || info.ModTime().After(last) {
+				fw.schedule(ctx, p)
+			}
+			return nil
+		})
+	}
+}
+
+// scan scans a single file and applies the directory action
+func (fw *FileWatcher) scan(ctx context.Context, path string) {
+	dir, ok := fw.dirFor(path)
+	if !ok {
+		return
+	}
+	reader, err := NewFileReader(path, path)
+	if err != nil {
+		// The file was removed or renamed before the scan started
+		return
+	}
+	modTime := time.Now()
+	if info, err := reader.file.Stat(); err == nil {
+		modTime = info.ModTime()
+	}
+
+	tags := buildScanTags(sourceFilesystem, getCustomTags(), append(append([]string{}, dir.Tags...), "file_type="+filepath.Ext(path), "trigger=watch")...)
+	scanStart := time.Now()
+	scanResult, err := observeScan(ctx, sourceFilesystem, reader.size, func(ctx context.Context) (string, error) {
+		return scanReader(ctx, fw.scannerClient, reader, tags)
+	})
+	reader.Close()
+	if err != nil {
+		log.Printf("File watcher: scan failed for %s: %v", path, err)
+		return
+	}
+	verdict, err := parseScanVerdict(scanResult)
+	if err != nil {
+		log.Printf("File watcher: %v", err)
+		return
+	}
+
+	fw.mu.Lock()
+	fw.seen[path] = modTime
+	fw.mu.Unlock()
+
+	reportScanOutcome(ctx, ScanOutcome{
+		Source:     sourceFilesystem,
+		Identifier: path,
+		Tags:       tags,
+		Verdict:    verdict,
+		Duration:   time.Since(scanStart),
+		Size:       reader.size,
+	})
+	fw.apply(ctx, dir, path, verdict)
+}
+
+// apply runs the directory action for a scanned file
+func (fw *FileWatcher) apply(ctx context.Context, dir WatchDir, path string, verdict ScanVerdict) {
+	if result := applyDirAction(ctx, dir, path, verdict); result != nil && !result.DryRun {
+		fw.mu.Lock()
+		delete(fw.seen, path)
+		fw.mu.Unlock()
+	}
+}
+
+// applyDirAction moves or deletes a scanned file according to the directory
+// action and writes an audit log line. It returns the action record, or nil
+// when no action applies; dry runs are recorded without touching the file.
+func applyDirAction(ctx context.Context, dir WatchDir, path string, verdict ScanVerdict) *RemediationResult {
+	action := watchActionNone
+	switch {
+	case dir.Action == watchActionQuarantine && !verdict.IsSafe,
+		dir.Action == watchActionMove && verdict.IsSafe,
+		dir.Action == watchActionDelete && !verdict.IsSafe:
+		action = dir.Action
+	}
+	if action == watchActionNone {
+		return nil
+	}
+
+	var err error
+	result := &RemediationResult{Action: action, DryRun: dir.DryRun, Status: remediationApplied}
+	switch {
+	case dir.DryRun:
+		result.Status = remediationDryRun
+	case action == watchActionDelete:
+		err = os.Remove(path)
+	default:
+		err = moveToDir(path, dir.Path, dir.Target)
+	}
+	if err != nil {
+		result.Status = remediationFailed
+		result.Error = err.Error()
+	}
+	loggerFrom(ctx).Info("watch action",
+		"path", path,
+		"action", action,
+		"status", result.Status,
+		"dry_run", result.DryRun,
+		"verdict", verdict.Label(),
+		"error", result.Error,
+	)
+	return result
+}
+
+// moveToDir moves path into target, keeping its location relative to root
+func moveToDir(path, root, target string) error {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	dest := filepath.Join(target, rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o750); err != nil {
+		return err
+	}
+	return os.Rename(path, dest)
+}
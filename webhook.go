@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultCallbackRetries = 3
+	callbackTimeout        = 10 * time.Second
+	callbackSignatureHead  = "X-Finguard-Signature"
+)
+
+// Callback events sent to callbackUrl
+const (
+	eventScanCompleted = "scan.completed"
+	eventJobCompleted  = "job.completed"
+)
+
+// CallbackPayload is the body POSTed to a callbackUrl
+type CallbackPayload struct {
+	Event     string      `json:"event"`
+	Timestamp string      `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// callbackHTTPClient refuses to connect to internal addresses, so callbacks
+// cannot be pointed at metadata services or private networks
+var callbackHTTPClient = newGuardedHTTPClient(callbackTimeout)
+
+// validateCallbackURL accepts absolute http(s) URLs whose host is not a
+// loopback, private or link-local address
+func validateCallbackURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := parseHTTPURL(raw)
+	if err != nil {
+		return fmt.Errorf("callbackUrl must be an absolute http or https URL")
+	}
+	if err := checkOutboundHost(u.Hostname()); err != nil {
+		return fmt.Errorf("callbackUrl is not allowed: %v", err)
+	}
+	return nil
+}
+
+// parseHTTPURL parses an absolute http(s) URL
+func parseHTTPURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("not an absolute http or https URL")
+	}
+	return u, nil
+}
+
+// signCallback returns the hex HMAC-SHA256 of body using CALLBACK_SECRET
+func signCallback(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendCallback delivers the payload in the background. Delivery is retried
+// with exponential backoff on network errors and non-2xx responses.
+func sendCallback(callbackURL, event string, data interface{}) {
+	if callbackURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(CallbackPayload{
+		Event:     event,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Data:      data,
+	})
+	if err != nil {
+		log.Printf("Callback: failed to encode %s payload: %v", event, err)
+		return
+	}
+
+	go deliverCallback(callbackURL, event, body)
+}
+
+// deliverCallback POSTs body to callbackURL until it succeeds or retries run out
+func deliverCallback(callbackURL, event string, body []byte) {
+	retries, err := strconv.Atoi(getEnv("CALLBACK_MAX_RETRIES", strconv.Itoa(defaultCallbackRetries)))
+	if err != nil || retries < 0 {
+		retries = defaultCallbackRetries
+	}
+	secret := getEnv("CALLBACK_SECRET", "")
+
+	backoff := time.Second
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Callback: invalid URL %s: %v", callbackURL, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Finguard-Event", event)
+		if secret != "" {
+			req.Header.Set(callbackSignatureHead, signCallback(secret, body))
+		}
+
+		resp, err := callbackHTTPClient.Do(req)
+		if err != nil {
+			log.Printf("Callback: attempt %d to %s failed: %v", attempt+1, callbackURL, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			log.Printf("Callback: delivered %s to %s", event, callbackURL)
+			return
+		}
+		log.Printf("Callback: attempt %d to %s returned %d", attempt+1, callbackURL, resp.StatusCode)
+	}
+	log.Printf("Callback: giving up on %s after %d attempts", callbackURL, retries+1)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestValidateCallbackURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		allowed string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"https://hooks.example.com/finguard", "", false},
+		{"http://93.184.216.34:8080/cb", "", false},
+		{"ftp://hooks.example.com/finguard", "", true},
+		{"/relative", "", true},
+		{"http://127.0.0.1:8080/cb", "", true},
+		{"http://169.254.169.254/latest/meta-data/", "", true},
+		{"http://localhost/cb", "", true},
+		{"http://10.0.0.5/cb", "", true},
+		{"http://[::1]/cb", "", true},
+		{"http://127.0.0.1:8080/cb", "127.0.0.0/8", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url+"/"+tt.allowed, func(t *testing.T) {
+			t.Setenv("OUTBOUND_ALLOWED_NETWORKS", tt.allowed)
+			if err := validateCallbackURL(tt.url); (err != nil) != tt.wantErr {
+				t.Errorf("validateCallbackURL(%q) = %v, want error %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeliverCallbackBlocksInternalAddresses(t *testing.T) {
+	tests := []struct {
+		allowed   string
+		delivered bool
+	}{
+		{"", false},
+		{"127.0.0.0/8", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.allowed, func(t *testing.T) {
+			t.Setenv("OUTBOUND_ALLOWED_NETWORKS", tt.allowed)
+			t.Setenv("CALLBACK_MAX_RETRIES", "0")
+			var hits atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hits.Add(1)
+			}))
+			defer server.Close()
+
+			// Delivery skips validateCallbackURL, so only the dial check can stop it
+			deliverCallback(server.URL, eventScanCompleted, []byte(`{}`))
+			if delivered := hits.Load() > 0; delivered != tt.delivered {
+				t.Errorf("delivered = %v, want %v", delivered, tt.delivered)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	amaasclient "github.com/trendmicro/tm-v1-fs-golang-sdk"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	wsScanPath = "/scan/ws"
+	// wsMaxFrameSize bounds a single data message; files are sent in many
+	wsMaxFrameSize = 4 << 20
+	wsIdleTimeout  = 2 * time.Minute
+)
+
+var (
+	errWSUploadTooLarge = errors.New("upload exceeds the maximum size")
+	errWSUnexpected     = errors.New("unexpected message")
+)
+
+// WSScanStart is the first message of a /scan/ws session. Size is optional
+// and only used to report progress as a percentage.
+type WSScanStart struct {
+	Type     string   `json:"type"` // start
+	Filename string   `json:"filename"`
+	Size     int64    `json:"size,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Options  struct {
+		PML           bool `json:"pml"`
+		Feedback      bool `json:"feedback"`
+		Verbose       bool `json:"verbose"`
+		ActiveContent bool `json:"activeContent"`
+		DisableDigest bool `json:"disableDigest"`
+	} `json:"options"`
+}
+
+// WSScanMessage is sent by the server: ready after the start message,
+// progress after every chunk, scanning once the upload is complete, then
+// result or error before the connection is closed
+type WSScanMessage struct {
+	Type       string        `json:"type"`
+	Received   int64         `json:"received,omitempty"`
+	Total      int64         `json:"total,omitempty"`
+	Percent    int           `json:"percent,omitempty"`
+	Result     *WSScanResult `json:"result,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Status     int           `json:"status,omitempty"`
+	RetryAfter string        `json:"retryAfter,omitempty"`
+	RequestID  string        `json:"requestId,omitempty"`
+}
+
+// WSScanResult is the verdict-only scan response with the normalized result
+type WSScanResult struct {
+	MinimalScanResponse
+	Result *NormalizedVerdict `json:"result,omitempty"`
+}
+
+// wsFrame is one received message and whether it was binary
+type wsFrame struct {
+	binary bool
+	data   []byte
+}
+
+var wsFrameCodec = websocket.Codec{
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		frame := v.(*wsFrame)
+		frame.binary = payloadType == websocket.BinaryFrame
+		frame.data = data
+		return nil
+	},
+}
+
+// handleScanWebSocket serves /scan/ws. The client sends a JSON start message,
+// the file as binary messages and {"type":"end"}; the server answers with
+// progress messages and the verdict on the same connection.
+func handleScanWebSocket(scannerClient *amaasclient.AmaasClient, scanCache *ScanCache) http.Handler {
+	return websocket.Server{
+		Handshake: checkWSOrigin,
+		Handler: func(ws *websocket.Conn) {
+			serveScanWebSocket(ws, scannerClient, scanCache)
+		},
+	}
+}
+
+// checkWSOrigin accepts browsers from SCAN_WS_ALLOWED_ORIGINS, or any origin
+// when it is unset. Clients without an Origin header are always accepted;
+// every client still authenticates.
+func checkWSOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	allowed := os.Getenv("SCAN_WS_ALLOWED_ORIGINS")
+	if origin == "" || allowed == "" {
+		return nil
+	}
+	if !slices.Contains(strings.Split(allowed, ","), origin) {
+		return fmt.Errorf("origin %q is not allowed", origin)
+	}
+	return nil
+}
+
+func serveScanWebSocket(ws *websocket.Conn, scannerClient *amaasclient.AmaasClient, scanCache *ScanCache) {
+	ws.MaxPayloadBytes = wsMaxFrameSize
+	ctx := ws.Request().Context()
+	send := func(msg WSScanMessage) error {
+		msg.RequestID = requestIDFrom(ctx)
+		ws.SetWriteDeadline(time.Now().Add(wsIdleTimeout))
+		return websocket.JSON.Send(ws, msg)
+	}
+	sendError := func(status int, message string) {
+		send(WSScanMessage{Type: "error", Status: status, Error: message})
+	}
+
+	var start WSScanStart
+	var frame wsFrame
+	ws.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+	if err := wsFrameCodec.Receive(ws, &frame); err != nil {
+		return
+	}
+	if frame.binary || json.Unmarshal(frame.data, &start) != nil || start.Type != "start" {
+		sendError(http.StatusBadRequest, `First message must be {"type":"start",...}`)
+		return
+	}
+	maxSize := getMaxUploadSize()
+	if start.Size > maxSize {
+		sendError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload exceeds the maximum size of %d MB", maxSize>>20))
+		return
+	}
+	filename := start.Filename
+	if filename == "" {
+		filename = "unknown"
+	}
+	identifier := time.Now().Format("20060102150405") + "-" + filepath.Base(filename)
+	if err := send(WSScanMessage{Type: "ready"}); err != nil {
+		return
+	}
+
+	reader, sum, err := spoolUpload(&wsChunkReader{ws: ws, send: send, total: start.Size, max: maxSize}, identifier)
+	switch {
+	case errors.Is(err, errWSUploadTooLarge):
+		sendError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload exceeds the maximum size of %d MB", maxSize>>20))
+		return
+	case errors.Is(err, errWSUnexpected), errors.Is(err, websocket.ErrFrameTooLarge):
+		sendError(http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		log.Printf("WebSocket upload %s failed: %v", identifier, err)
+		return
+	}
+	defer reader.Close()
+
+	opts := ScanOptions{
+		PML:           start.Options.PML,
+		Feedback:      start.Options.Feedback,
+		Verbose:       start.Options.Verbose,
+		ActiveContent: start.Options.ActiveContent,
+		DisableDigest: start.Options.DisableDigest,
+	}
+	ctx, scanClient, opts := scanPolicyClient(ctx, scannerClient, ScanTarget{
+		Source: sourceUpload, Key: filename, DetectedType: sniffReader(reader), Size: reader.size,
+	}, opts)
+	ctx, attempts := withScanAttempts(ctx)
+	extras := append([]string{"file_type=" + filepath.Ext(filename), "scan_method=websocket"}, start.Tags...)
+	tags := buildScanTags(sourceUpload, getCustomTags(), extras...)
+
+	if err := send(WSScanMessage{Type: "scanning", Received: reader.size}); err != nil {
+		return
+	}
+	log.Printf("Starting WebSocket scan for file: %s (%d bytes) with tags: %v", identifier, reader.size, tags)
+	cacheKey := ""
+	if scanCache != nil {
+		cacheKey = scanCacheKey(sum, opts)
+	}
+	scanStart := time.Now()
+	scanResult, cached, err := scanUnlessListed(ctx, scanCache, sum, filename, cacheKey, func() (string, error) {
+		return observeScan(ctx, "spool", reader.size, func(ctx context.Context) (string, error) {
+			return scanReader(ctx, scanClient, reader, tags)
+		})
+	})
+	var verdict ScanVerdict
+	if err == nil {
+		verdict, err = parseScanVerdict(scanResult)
+	}
+	if err != nil {
+		loggerFrom(ctx).Error("scan failed", "scan_id", identifier, "source", sourceUpload, "scan_method", "websocket", "error", err)
+		send(wsScanError(ctx, err))
+		return
+	}
+
+	verdict.ScanID = identifier
+	normalized := verdict.Normalize(time.Since(scanStart), wantsRawResult(ws.Request()))
+	reportScanOutcome(ctx, ScanOutcome{
+		Source:     sourceUpload,
+		Identifier: identifier,
+		Tags:       tags,
+		Verdict:    verdict,
+		Duration:   time.Since(scanStart),
+		Size:       reader.size,
+	})
+	send(WSScanMessage{Type: "result", Result: &WSScanResult{
+		MinimalScanResponse: MinimalScanResponse{
+			IsSafe:       verdict.IsSafe,
+			Verdict:      verdict.Label(),
+			MalwareNames: verdict.MalwareNames,
+			ScanID:       identifier,
+			RequestID:    requestIDFrom(ctx),
+			Cached:       cached,
+			Attempts:     int(attempts.Load()),
+		},
+		Result: &normalized,
+	}})
+}
+
+// wsScanError reports a scan failure with the status and message the HTTP
+// endpoints would answer with
+func wsScanError(ctx context.Context, err error) WSScanMessage {
+	rec := httptest.NewRecorder()
+	if !writeScanRejected(rec, err) && !writeScanTimeout(rec, ctx, err) {
+		return WSScanMessage{Type: "error", Status: http.StatusInternalServerError, Error: "Scanning failed"}
+	}
+	var body ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return WSScanMessage{Type: "error", Status: rec.Code, Error: body.Error, RetryAfter: rec.Header().Get("Retry-After")}
+}
+
+// wsChunkReader reads binary messages as file data until {"type":"end"},
+// reporting progress after each one
+type wsChunkReader struct {
+	ws       *websocket.Conn
+	send     func(WSScanMessage) error
+	data     []byte
+	received int64
+	total    int64
+	max      int64
+	done     bool
+}
+
+func (c *wsChunkReader) Read(p []byte) (int, error) {
+	for len(c.data) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		var frame wsFrame
+		c.ws.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+		if err := wsFrameCodec.Receive(c.ws, &frame); err != nil {
+			return 0, err
+		}
+		if !frame.binary {
+			var msg struct {
+				Type string `json:"type"`
+			}
+			if json.Unmarshal(frame.data, &msg) != nil || msg.Type != "end" {
+				return 0, fmt.Errorf(`%w: expected binary data or {"type":"end"}`, errWSUnexpected)
+			}
+			c.done = true
+			continue
+		}
+
+		c.received += int64(len(frame.data))
+		if c.received > c.max {
+			return 0, errWSUploadTooLarge
+		}
+		progress := WSScanMessage{Type: "progress", Received: c.received, Total: c.total}
+		if c.total > 0 {
+			progress.Percent = int(min(c.received*100/c.total, 100))
+		}
+		if err := c.send(progress); err != nil {
+			return 0, err
+		}
+		c.data = frame.data
+	}
+	n := copy(p, c.data)
+	c.data = c.data[n:]
+	return n, nil
+}